@@ -0,0 +1,290 @@
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/redact"
+)
+
+// Entry is one access log record
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	ClientIP   string    `json:"client_ip"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	DurationMs float64   `json:"duration_ms"`
+	Consumer   string    `json:"consumer"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+}
+
+// Sink delivers batches of entries somewhere
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, entries []*Entry) error
+	Close() error
+}
+
+// Config represents access log configuration
+type Config struct {
+	BufferSize    int // Entries queued per sink before new ones are dropped
+	BatchSize     int
+	FlushInterval time.Duration
+	WriteTimeout  time.Duration
+	MaxAttempts   int              // Writes of a batch before it is dropped
+	Redactor      *redact.Redactor // Masks secrets in query strings
+}
+
+// Logger records an entry per request and hands it to every sink. Each sink
+// has its own bounded queue, so a slow or failing sink drops entries instead
+// of stalling requests or the other sinks.
+type Logger struct {
+	config *Config
+	queues []*queue
+
+	written *metrics.CounterVec
+	dropped *metrics.CounterVec
+	errors  *metrics.CounterVec
+}
+
+// queue buffers entries for one sink
+type queue struct {
+	sink    Sink
+	entries chan *Entry
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewLogger creates an access logger and starts delivering to the sinks
+func NewLogger(config *Config, sinks []Sink, reg *metrics.Registry) *Logger {
+	l := &Logger{
+		config: config,
+		written: reg.NewCounterVec("gateway_access_log_entries_total",
+			"Access log entries delivered, by sink.", "sink"),
+		dropped: reg.NewCounterVec("gateway_access_log_dropped_total",
+			"Access log entries dropped, by sink and reason (buffer_full or write_failed).", "sink", "reason"),
+		errors: reg.NewCounterVec("gateway_access_log_write_errors_total",
+			"Failed access log batch writes, by sink.", "sink"),
+	}
+	for _, sink := range sinks {
+		q := &queue{
+			sink:    sink,
+			entries: make(chan *Entry, config.BufferSize),
+			closing: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		l.queues = append(l.queues, q)
+		go l.deliverRoutine(q)
+	}
+	return l
+}
+
+// Middleware returns the HTTP middleware function
+func (l *Logger) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = auth.WithIdentitySlot(r)
+			start := time.Now()
+			body := &countingBody{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rw, r)
+
+			l.log(&Entry{
+				Time:       start,
+				RequestID:  r.Header.Get("X-Request-ID"),
+				ClientIP:   httputil.ClientIP(r),
+				Method:     r.Method,
+				Host:       r.Host,
+				Path:       r.URL.Path,
+				Query:      l.config.Redactor.Query(r.URL.RawQuery),
+				Protocol:   r.Proto,
+				Status:     rw.status,
+				BytesIn:    body.n,
+				BytesOut:   rw.bytes,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+				Consumer:   metrics.Consumer(r),
+				UserAgent:  r.UserAgent(),
+				Referer:    r.Referer(),
+			})
+		})
+	}
+}
+
+// log queues an entry for every sink without blocking
+func (l *Logger) log(entry *Entry) {
+	for _, q := range l.queues {
+		select {
+		case q.entries <- entry:
+		default:
+			l.dropped.Inc(q.sink.Name(), "buffer_full")
+		}
+	}
+}
+
+// Close delivers buffered entries and closes the sinks, giving up when ctx ends
+func (l *Logger) Close(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, q := range l.queues {
+		close(q.closing)
+		wg.Add(1)
+		go func(q *queue) {
+			defer wg.Done()
+			select {
+			case <-q.done:
+			case <-ctx.Done():
+				log.Printf("Access log sink %s did not flush before shutdown", q.sink.Name())
+			}
+			q.sink.Close()
+		}(q)
+	}
+	wg.Wait()
+}
+
+// deliverRoutine batches entries for a sink and writes them every flush
+// interval or once a batch is full. A failed batch is retried with the next
+// flush; while it is pending, new entries wait in the queue.
+func (l *Logger) deliverRoutine(q *queue) {
+	defer close(q.done)
+
+	ticker := time.NewTicker(l.config.FlushInterval)
+	defer ticker.Stop()
+
+	name := q.sink.Name()
+	var batch []*Entry
+	attempts := 0
+	var lastError time.Time
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), l.config.WriteTimeout)
+		err := q.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			l.written.Add(float64(len(batch)), name)
+			batch, attempts = batch[:0], 0
+			return
+		}
+
+		l.errors.Inc(name)
+		attempts++
+		if time.Since(lastError) > time.Minute {
+			// Log failures at most once a minute per sink
+			log.Printf("Access log sink %s failed (attempt %d of %d): %v", name, attempts, l.config.MaxAttempts, err)
+			lastError = time.Now()
+		}
+		if attempts >= l.config.MaxAttempts {
+			l.dropped.Add(float64(len(batch)), name, "write_failed")
+			batch, attempts = batch[:0], 0
+		}
+	}
+
+	for {
+		// Stop reading while a full batch waits, so the queue pushes back
+		entries := q.entries
+		if len(batch) >= l.config.BatchSize {
+			entries = nil
+		}
+
+		select {
+		case entry := <-entries:
+			batch = append(batch, entry)
+			if len(batch) >= l.config.BatchSize && attempts == 0 {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-q.closing:
+			for {
+				select {
+				case entry := <-q.entries:
+					batch = append(batch, entry)
+					if len(batch) >= l.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// countingBody counts request body bytes read by the handler
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read implements io.Reader
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the response status and size
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// WriteHeader records the status code and forwards it
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the body bytes and forwards them
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
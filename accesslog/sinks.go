@@ -0,0 +1,430 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// encodeLines encodes entries as JSON lines
+func encodeLines(entries []*Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, fmt.Errorf("failed to encode access log entry: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// WriterSink writes JSON lines to a writer such as stdout
+type WriterSink struct {
+	name string
+	w    io.Writer
+}
+
+// NewWriterSink creates a sink writing to w
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+// Name implements Sink
+func (s *WriterSink) Name() string { return s.name }
+
+// Write implements Sink
+func (s *WriterSink) Write(ctx context.Context, entries []*Entry) error {
+	data, err := encodeLines(entries)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Close implements Sink
+func (s *WriterSink) Close() error { return nil }
+
+// FileSink writes JSON lines to a file, rotating it by size and age. Rotated
+// files are renamed with a timestamp suffix and the oldest are removed.
+type FileSink struct {
+	path        string
+	maxSize     int64         // 0 disables size rotation
+	rotateEvery time.Duration // 0 disables time rotation
+	maxBackups  int           // 0 keeps every rotated file
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewFileSink creates a file sink, opening the file for appending
+func NewFileSink(path string, maxSize int64, rotateEvery time.Duration, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:        path,
+		maxSize:     maxSize,
+		rotateEvery: rotateEvery,
+		maxBackups:  maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Name implements Sink
+func (s *FileSink) Name() string { return "file" }
+
+// Write implements Sink
+func (s *FileSink) Write(ctx context.Context, entries []*Entry) error {
+	data, err := encodeLines(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && ((s.maxSize > 0 && s.size+int64(len(data)) > s.maxSize) ||
+		(s.rotateEvery > 0 && time.Since(s.openedAt) >= s.rotateEvery)) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// open opens the log file for appending
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open access log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open access log file: %w", err)
+	}
+	s.file, s.size, s.openedAt = f, info.Size(), time.Now()
+	return nil
+}
+
+// rotate renames the current file aside, reopens the path and removes
+// backups beyond the limit
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close access log file: %w", err)
+	}
+	s.file = nil
+	backup := s.path + "." + time.Now().UTC().Format("20060102T150405.000")
+	if err := os.Rename(s.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate access log file: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if s.maxBackups > 0 {
+		backups, _ := filepath.Glob(s.path + ".*")
+		sort.Strings(backups)
+		for len(backups) > s.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// syslogFacilities maps facility names to their codes
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverityInfo is the severity of access log messages
+const syslogSeverityInfo = 6
+
+// SyslogSink sends each entry as an RFC 5424 message. Stream connections use
+// octet-counting framing (RFC 6587); datagrams carry one message each.
+type SyslogSink struct {
+	network  string
+	address  string
+	priority int
+	tag      string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink creates a syslog sink; it connects on first write
+func NewSyslogSink(network, address, facility, tag string) (*SyslogSink, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogSink{
+		network:  network,
+		address:  address,
+		priority: code*8 + syslogSeverityInfo,
+		tag:      tag,
+		hostname: hostname,
+	}, nil
+}
+
+// Name implements Sink
+func (s *SyslogSink) Name() string { return "syslog" }
+
+// Write implements Sink
+func (s *SyslogSink) Write(ctx context.Context, entries []*Entry) error {
+	var buf bytes.Buffer
+	stream := s.network == "tcp" || s.network == "unix"
+	var messages [][]byte
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode access log entry: %w", err)
+		}
+		message := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+			s.priority, entry.Time.UTC().Format(time.RFC3339Nano), s.hostname, s.tag, os.Getpid(), data)
+		if stream {
+			buf.WriteString(strconv.Itoa(len(message)))
+			buf.WriteByte(' ')
+			buf.WriteString(message)
+		} else {
+			messages = append(messages, []byte(message))
+		}
+	}
+	if stream {
+		messages = [][]byte{buf.Bytes()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reconnect once when the connection was closed by the server
+	for attempt := 0; ; attempt++ {
+		err := s.send(ctx, messages)
+		if err == nil {
+			return nil
+		}
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		if attempt == 1 {
+			return err
+		}
+	}
+}
+
+// send writes messages over the connection, dialing it first if needed
+func (s *SyslogSink) send(ctx context.Context, messages [][]byte) error {
+	if s.conn == nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, s.network, s.address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		s.conn = conn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	for _, message := range messages {
+		if _, err := s.conn.Write(message); err != nil {
+			return fmt.Errorf("failed to write to syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close implements Sink
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// KafkaSink produces entries to a Kafka topic through the REST Proxy v2 API
+type KafkaSink struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewKafkaSink creates a Kafka sink posting to the REST proxy at baseURL
+func NewKafkaSink(baseURL, topic, username, password string) *KafkaSink {
+	return &KafkaSink{
+		url:      strings.TrimRight(baseURL, "/") + "/topics/" + topic,
+		username: username,
+		password: password,
+		client:   &http.Client{},
+	}
+}
+
+// Name implements Sink
+func (s *KafkaSink) Name() string { return "kafka" }
+
+// Write implements Sink
+func (s *KafkaSink) Write(ctx context.Context, entries []*Entry) error {
+	type record struct {
+		Value *Entry `json:"value"`
+	}
+	records := make([]record, len(entries))
+	for i, entry := range entries {
+		records[i] = record{Value: entry}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return fmt.Errorf("failed to encode access log entries: %w", err)
+	}
+
+	resp, err := post(ctx, s.client, s.url, "application/vnd.kafka.json.v2+json", body, s.username, s.password, nil)
+	if err != nil {
+		return err
+	}
+
+	// The proxy answers 200 even when single records fail
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if json.Unmarshal(resp, &result) == nil {
+		for _, offset := range result.Offsets {
+			if offset.ErrorCode != nil {
+				return fmt.Errorf("kafka rejected a record: %s", offset.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// Close implements Sink
+func (s *KafkaSink) Close() error { return nil }
+
+// LokiSink pushes entries to Grafana Loki as one stream with static labels
+type LokiSink struct {
+	url      string
+	labels   map[string]string
+	tenant   string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewLokiSink creates a Loki sink posting to the push API at baseURL
+func NewLokiSink(baseURL string, labels map[string]string, tenant, username, password string) *LokiSink {
+	return &LokiSink{
+		url:      strings.TrimRight(baseURL, "/") + "/loki/api/v1/push",
+		labels:   labels,
+		tenant:   tenant,
+		username: username,
+		password: password,
+		client:   &http.Client{},
+	}
+}
+
+// Name implements Sink
+func (s *LokiSink) Name() string { return "loki" }
+
+// Write implements Sink
+func (s *LokiSink) Write(ctx context.Context, entries []*Entry) error {
+	values := make([][2]string, 0, len(entries))
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode access log entry: %w", err)
+		}
+		values = append(values, [2]string{strconv.FormatInt(entry.Time.UnixNano(), 10), string(line)})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"streams": []map[string]interface{}{{"stream": s.labels, "values": values}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode access log entries: %w", err)
+	}
+
+	var header http.Header
+	if s.tenant != "" {
+		header = http.Header{"X-Scope-OrgID": {s.tenant}}
+	}
+	_, err = post(ctx, s.client, s.url, "application/json", body, s.username, s.password, header)
+	return err
+}
+
+// Close implements Sink
+func (s *LokiSink) Close() error { return nil }
+
+// post sends a JSON body and returns the response body of a 2xx answer
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, username, password string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s answered %s: %s", url, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
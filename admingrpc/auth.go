@@ -0,0 +1,148 @@
+package admingrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/auth"
+	adminpb "api-gateway/proto/admin/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// permissions are those the REST endpoints of a call require, on top of the
+// admin role every call needs
+var permissions = map[string]string{
+	adminpb.AdminService_ListExemptions_FullMethodName:  "ratelimit:read",
+	adminpb.AdminService_AddExemption_FullMethodName:    "ratelimit:write",
+	adminpb.AdminService_RemoveExemption_FullMethodName: "ratelimit:write",
+	adminpb.AdminService_ListPenalties_FullMethodName:   "ratelimit:read",
+	adminpb.AdminService_ReleasePenalty_FullMethodName:  "ratelimit:write",
+}
+
+type callerKey struct{}
+
+// callerName returns the username of the admin making the call
+func callerName(ctx context.Context) string {
+	if userCtx, ok := ctx.Value(callerKey{}).(*auth.UserContext); ok {
+		return userCtx.Username
+	}
+	return ""
+}
+
+func (s *Server) authorizeUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authorizeStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authorizedStream carries the caller in its context
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize runs the call's authorization metadata through the middleware of
+// the REST admin endpoints: a gateway JWT, the admin role and the method's
+// permission. It returns ctx with the caller, or the refusal as a status.
+func (s *Server) authorize(ctx context.Context, method string) (context.Context, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, method, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			r.Header.Set("Authorization", values[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	var caller *auth.UserContext
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller = auth.GetUserFromContext(r)
+	})
+	if permission, ok := permissions[method]; ok {
+		handler = auth.Require(permission)(handler)
+	}
+	handler = auth.RequireJWT(s.config.Tokens, s.config.Hooks)(auth.RBACMiddleware("admin")(handler))
+
+	refusal := &refusalWriter{header: http.Header{}}
+	handler.ServeHTTP(refusal, r)
+	if caller == nil {
+		return nil, refusal.status()
+	}
+	return context.WithValue(ctx, callerKey{}, caller), nil
+}
+
+// refusalWriter records the response of middleware refusing a call
+type refusalWriter struct {
+	header http.Header
+	code   int
+	body   []byte
+}
+
+func (w *refusalWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *refusalWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.body = append(w.body, data...)
+	return len(data), nil
+}
+
+func (w *refusalWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// status converts the refusal to a gRPC status with its details
+func (w *refusalWriter) status() error {
+	var body struct {
+		Error   string `json:"error"`
+		Details string `json:"details"`
+	}
+	message := "call refused"
+	if json.Unmarshal(w.body, &body) == nil && body.Error != "" {
+		message = body.Error
+		if body.Details != "" {
+			message += ": " + body.Details
+		}
+	}
+	switch w.code {
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, message)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, message)
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, message)
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
@@ -0,0 +1,534 @@
+// Package admingrpc serves the gateway's admin API over gRPC, as defined in
+// proto/admin/v1/admin.proto. It works on the same components as the REST
+// admin endpoints, so both see and make the same changes.
+package admingrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/cluster"
+	"api-gateway/config"
+	"api-gateway/penalty"
+	adminpb "api-gateway/proto/admin/v1"
+	"api-gateway/proxy"
+	"api-gateway/ratelimit"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Config represents the components the admin API manages. Optional
+// components are nil when disabled, and their calls fail as unimplemented.
+type Config struct {
+	Gateway       *config.Config
+	Version       string              // Gateway build version
+	Tokens        auth.TokenValidator // Validates the admins' JWTs
+	Hooks         *auth.Hooks         // Of the gateway, so admins resolve and are authorized as over REST
+	APIKeys       *auth.APIKeyStore
+	Proxy         *proxy.Proxy          // Optional
+	Cluster       *cluster.Coordinator  // Optional
+	Exemptions    *ratelimit.Exemptions // Optional
+	Penalties     *penalty.Box          // Optional
+	WatchInterval time.Duration         // How often watches check for changes
+}
+
+// Server implements the AdminService
+type Server struct {
+	adminpb.UnimplementedAdminServiceServer
+
+	config *Config
+	grpc   *grpc.Server
+
+	stopOnce sync.Once
+	stopped  chan struct{} // Closed on Shutdown, ending watches
+}
+
+// NewServer creates the admin API server. Every call is authorized before
+// it reaches the service.
+func NewServer(cfg *Config, opts ...grpc.ServerOption) *Server {
+	s := &Server{config: cfg, stopped: make(chan struct{})}
+	opts = append(opts, grpc.UnaryInterceptor(s.authorizeUnary), grpc.StreamInterceptor(s.authorizeStream))
+	s.grpc = grpc.NewServer(opts...)
+	adminpb.RegisterAdminServiceServer(s.grpc, s)
+	return s
+}
+
+// Serve serves the admin API on listener until Shutdown
+func (s *Server) Serve(listener net.Listener) error {
+	return s.grpc.Serve(listener)
+}
+
+// Shutdown ends watches, stops accepting calls and waits for calls in flight
+// until ctx is done, then cancels them
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopped) })
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// GetStatus returns the version, enabled subsystems and cluster membership
+func (s *Server) GetStatus(ctx context.Context, _ *adminpb.GetStatusRequest) (*adminpb.Status, error) {
+	result := &adminpb.Status{
+		Version:    s.config.Version,
+		Subsystems: subsystems(s.config.Gateway),
+	}
+	if s.config.Cluster == nil {
+		return result, nil
+	}
+	clusterStatus, err := s.config.Cluster.Status(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cluster state unavailable: %v", err)
+	}
+	result.InstanceId = clusterStatus.InstanceID
+	result.Leader = clusterStatus.Leader
+	result.IsLeader = clusterStatus.IsLeader
+	result.ConfigInSync = clusterStatus.ConfigInSync
+	for _, member := range clusterStatus.Members {
+		result.Members = append(result.Members, &adminpb.Member{
+			Id:            member.ID,
+			ConfigVersion: member.ConfigVersion,
+			StartedAt:     timestamppb.New(member.StartedAt),
+			LastSeen:      timestamppb.New(member.LastSeen),
+		})
+	}
+	return result, nil
+}
+
+// subsystems reports, by their configuration name, whether the parts of the
+// gateway that can be turned on and off are enabled
+func subsystems(cfg *config.Config) map[string]bool {
+	enabled := make(map[string]bool)
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		if field.Kind() != reflect.Struct {
+			continue
+		}
+		if flag := field.FieldByName("Enabled"); flag.IsValid() && flag.Kind() == reflect.Bool {
+			name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+			enabled[name] = flag.Bool()
+		}
+	}
+	return enabled
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+func (s *Server) GetConfig(context.Context, *adminpb.GetConfigRequest) (*adminpb.Config, error) {
+	return s.localConfig()
+}
+
+func (s *Server) localConfig() (*adminpb.Config, error) {
+	data, err := json.Marshal(s.config.Gateway.Redacted())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode configuration: %v", err)
+	}
+	return &adminpb.Config{Version: s.config.Gateway.Version(), Json: string(data)}, nil
+}
+
+// WatchConfig sends this instance's configuration, then the version of
+// every replica that starts with a configuration not seen before
+func (s *Server) WatchConfig(_ *adminpb.WatchConfigRequest, stream adminpb.AdminService_WatchConfigServer) error {
+	local, err := s.localConfig()
+	if err != nil {
+		return err
+	}
+	instanceID := ""
+	if s.config.Cluster != nil {
+		instanceID = s.config.Cluster.InstanceID()
+	}
+	if err := stream.Send(&adminpb.ConfigEvent{Config: local, InstanceId: instanceID, ChangedAt: timestamppb.Now()}); err != nil {
+		return err
+	}
+	if s.config.Cluster == nil {
+		return s.wait(stream.Context())
+	}
+
+	// Replicas are known by the version they run; the first poll sets them
+	seen := map[string]string{instanceID: local.Version}
+	first := true
+	return s.poll(stream.Context(), func(ctx context.Context) error {
+		clusterStatus, err := s.config.Cluster.Status(ctx)
+		if err != nil {
+			// Coordination recovers with Redis; the watch carries on
+			return nil
+		}
+		for _, member := range clusterStatus.Members {
+			if version, ok := seen[member.ID]; ok && version == member.ConfigVersion {
+				continue
+			}
+			seen[member.ID] = member.ConfigVersion
+			if first {
+				continue
+			}
+			event := &adminpb.ConfigEvent{
+				Config:     &adminpb.Config{Version: member.ConfigVersion},
+				InstanceId: member.ID,
+				ChangedAt:  timestamppb.New(member.StartedAt),
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+		first = false
+		return nil
+	})
+}
+
+// ListRoutes lists upstream routes with their health and rate limits
+func (s *Server) ListRoutes(ctx context.Context, _ *adminpb.ListRoutesRequest) (*adminpb.ListRoutesResponse, error) {
+	if s.config.Proxy == nil {
+		return &adminpb.ListRoutesResponse{}, nil
+	}
+	return &adminpb.ListRoutesResponse{Routes: s.routes(s.config.Proxy.CheckHealth(ctx))}, nil
+}
+
+// routes describes the upstreams, sorted by name, given their health
+func (s *Server) routes(health map[string]string) []*adminpb.Route {
+	var limit *adminpb.RateLimit
+	if rateLimit := s.config.Gateway.RateLimit; rateLimit.Enabled {
+		limit = &adminpb.RateLimit{
+			Capacity:   int32(rateLimit.Capacity),
+			RefillRate: int32(rateLimit.RefillRate),
+			Window:     durationpb.New(rateLimit.Window),
+			Identifier: rateLimit.Identifier,
+		}
+	}
+	var routes []*adminpb.Route
+	for _, upstream := range s.config.Proxy.Upstreams() {
+		route := &adminpb.Route{
+			Upstream:   upstream.Name,
+			PathPrefix: upstream.PathPrefix,
+			Healthy:    health[upstream.Name] == "healthy",
+			RateLimit:  limit,
+		}
+		if upstream.Target != nil {
+			route.Url = upstream.Target.Redacted()
+		}
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Upstream < routes[j].Upstream
+	})
+	return routes
+}
+
+// WatchRoutes sends every route, then each route whose upstream turns
+// healthy or unhealthy
+func (s *Server) WatchRoutes(_ *adminpb.WatchRoutesRequest, stream adminpb.AdminService_WatchRoutesServer) error {
+	if s.config.Proxy == nil {
+		return s.wait(stream.Context())
+	}
+
+	healthy := make(map[string]bool)
+	eventType := adminpb.RouteEvent_TYPE_SNAPSHOT
+	return s.poll(stream.Context(), func(ctx context.Context) error {
+		for _, route := range s.routes(s.config.Proxy.CheckHealth(ctx)) {
+			if was, ok := healthy[route.Upstream]; ok && was == route.Healthy {
+				continue
+			}
+			healthy[route.Upstream] = route.Healthy
+			event := &adminpb.RouteEvent{Type: eventType, Route: route, Time: timestamppb.Now()}
+			if eventType != adminpb.RouteEvent_TYPE_SNAPSHOT {
+				event.Type = adminpb.RouteEvent_TYPE_UNHEALTHY
+				if route.Healthy {
+					event.Type = adminpb.RouteEvent_TYPE_HEALTHY
+				}
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+		eventType = adminpb.RouteEvent_TYPE_UNSPECIFIED
+		return nil
+	})
+}
+
+// poll runs check now and then every watch interval, until it fails, the
+// client goes away or the server shuts down
+func (s *Server) poll(ctx context.Context, check func(ctx context.Context) error) error {
+	ticker := time.NewTicker(s.config.WatchInterval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, s.config.WatchInterval)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.stopped:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// wait keeps a watch with nothing to report open
+func (s *Server) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopped:
+		return nil
+	}
+}
+
+// CreateAPIKey creates an API key, defaulting like POST /api/keys
+func (s *Server) CreateAPIKey(_ context.Context, req *adminpb.CreateAPIKeyRequest) (*adminpb.APIKey, error) {
+	if req.Name == "" || req.UserId == "" || len(req.Roles) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "name, user_id and roles are required")
+	}
+	expiresIn := 24 * time.Hour
+	if req.ExpiresIn != nil {
+		expiresIn = req.ExpiresIn.AsDuration()
+		if expiresIn <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "expires_in must be positive")
+		}
+	}
+	rateLimit := int(req.RateLimit)
+	if rateLimit <= 0 {
+		rateLimit = 100
+	}
+	apiKey, err := s.config.APIKeys.GenerateAPIKey(req.Name, req.UserId, req.Roles, rateLimit, req.Plan, req.Products, expiresIn)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create API key: %v", err)
+	}
+	return apiKeyMessage(apiKey), nil
+}
+
+// ListAPIKeys lists the keys of a user, or every key, soft-deleted ones included
+func (s *Server) ListAPIKeys(_ context.Context, req *adminpb.ListAPIKeysRequest) (*adminpb.ListAPIKeysResponse, error) {
+	var keys []*auth.APIKey
+	if req.UserId == "" {
+		keys = s.config.APIKeys.ExportAPIKeys()
+	} else {
+		keys = append(s.config.APIKeys.ListAPIKeys(req.UserId), s.config.APIKeys.ListDeletedAPIKeys(req.UserId)...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	response := &adminpb.ListAPIKeysResponse{}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, apiKeyMessage(key))
+	}
+	return response, nil
+}
+
+// GetAPIKey returns an API key
+func (s *Server) GetAPIKey(_ context.Context, req *adminpb.GetAPIKeyRequest) (*adminpb.APIKey, error) {
+	apiKey, exists := s.config.APIKeys.GetAPIKey(req.Key)
+	if !exists {
+		return nil, status.Error(codes.NotFound, "API key not found")
+	}
+	return apiKeyMessage(apiKey), nil
+}
+
+// RevokeAPIKey deactivates an API key
+func (s *Server) RevokeAPIKey(_ context.Context, req *adminpb.RevokeAPIKeyRequest) (*adminpb.APIKey, error) {
+	return s.changeAPIKey(req.Key, s.config.APIKeys.RevokeAPIKey)
+}
+
+// DeleteAPIKey soft-deletes an API key
+func (s *Server) DeleteAPIKey(_ context.Context, req *adminpb.DeleteAPIKeyRequest) (*adminpb.DeleteAPIKeyResponse, error) {
+	if _, err := s.changeAPIKey(req.Key, s.config.APIKeys.DeleteAPIKey); err != nil {
+		return nil, err
+	}
+	return &adminpb.DeleteAPIKeyResponse{}, nil
+}
+
+// RestoreAPIKey undoes the soft deletion of an API key
+func (s *Server) RestoreAPIKey(_ context.Context, req *adminpb.RestoreAPIKeyRequest) (*adminpb.APIKey, error) {
+	return s.changeAPIKey(req.Key, func(key string) error {
+		_, err := s.config.APIKeys.RestoreAPIKey(key)
+		return err
+	})
+}
+
+// changeAPIKey applies a change to an existing key, returning the changed key
+func (s *Server) changeAPIKey(key string, change func(key string) error) (*adminpb.APIKey, error) {
+	if _, exists := s.config.APIKeys.GetAPIKey(key); !exists {
+		return nil, status.Error(codes.NotFound, "API key not found")
+	}
+	if err := change(key); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	apiKey, exists := s.config.APIKeys.GetAPIKey(key)
+	if !exists {
+		return nil, status.Error(codes.NotFound, "API key not found")
+	}
+	return apiKeyMessage(apiKey), nil
+}
+
+func apiKeyMessage(key *auth.APIKey) *adminpb.APIKey {
+	message := &adminpb.APIKey{
+		Key:        key.Key,
+		Name:       key.Name,
+		UserId:     key.UserID,
+		Roles:      key.Roles,
+		RateLimit:  int32(key.RateLimit),
+		Plan:       key.Plan,
+		Products:   key.Products,
+		Requests:   key.Requests,
+		IsActive:   key.IsActive,
+		CreatedAt:  timestamppb.New(key.CreatedAt),
+		LastUsedAt: timestamp(key.LastUsedAt),
+		ExpiresAt:  timestamppb.New(key.ExpiresAt),
+	}
+	if key.DeletedAt != nil {
+		message.DeletedAt = timestamppb.New(*key.DeletedAt)
+	}
+	return message
+}
+
+// timestamp converts t, leaving the zero time unset
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// ListExemptions lists rate limit exemptions from configuration and the API
+func (s *Server) ListExemptions(context.Context, *adminpb.ListExemptionsRequest) (*adminpb.ListExemptionsResponse, error) {
+	if s.config.Exemptions == nil {
+		return nil, status.Error(codes.Unimplemented, "rate limit exemptions are disabled")
+	}
+	response := &adminpb.ListExemptionsResponse{}
+	for _, exemption := range s.config.Exemptions.List() {
+		response.Exemptions = append(response.Exemptions, exemptionMessage(exemption))
+	}
+	return response, nil
+}
+
+// AddExemption exempts callers from rate limiting; the change is audited
+func (s *Server) AddExemption(ctx context.Context, req *adminpb.AddExemptionRequest) (*adminpb.Exemption, error) {
+	if s.config.Exemptions == nil {
+		return nil, status.Error(codes.Unimplemented, "rate limit exemptions are disabled")
+	}
+	if req.Reason == "" {
+		return nil, status.Error(codes.InvalidArgument, "reason is required")
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		ttl = req.Ttl.AsDuration()
+		if ttl <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+	}
+	exemption := &ratelimit.Exemption{
+		Kind:      req.Kind,
+		Value:     req.Value,
+		Reason:    req.Reason,
+		CreatedBy: callerName(ctx),
+	}
+	if err := s.config.Exemptions.Add(ctx, exemption, ttl); err != nil {
+		if errors.Is(err, ratelimit.ErrInvalidExemption) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to add exemption: %v", err)
+	}
+	return exemptionMessage(exemption), nil
+}
+
+// RemoveExemption removes an exemption added through the API; the change is
+// audited
+func (s *Server) RemoveExemption(ctx context.Context, req *adminpb.RemoveExemptionRequest) (*adminpb.RemoveExemptionResponse, error) {
+	if s.config.Exemptions == nil {
+		return nil, status.Error(codes.Unimplemented, "rate limit exemptions are disabled")
+	}
+	if err := s.config.Exemptions.Remove(ctx, req.Id, callerName(ctx)); err != nil {
+		switch {
+		case errors.Is(err, ratelimit.ErrExemptionNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, ratelimit.ErrConfiguredExemption):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to remove exemption: %v", err)
+	}
+	return &adminpb.RemoveExemptionResponse{}, nil
+}
+
+func exemptionMessage(exemption *ratelimit.Exemption) *adminpb.Exemption {
+	message := &adminpb.Exemption{
+		Id:        exemption.ID,
+		Kind:      exemption.Kind,
+		Value:     exemption.Value,
+		Reason:    exemption.Reason,
+		Source:    exemption.Source,
+		CreatedBy: exemption.CreatedBy,
+	}
+	if exemption.CreatedAt != nil {
+		message.CreatedAt = timestamppb.New(*exemption.CreatedAt)
+	}
+	if exemption.ExpiresAt != nil {
+		message.ExpiresAt = timestamppb.New(*exemption.ExpiresAt)
+	}
+	return message
+}
+
+// ListPenalties lists the clients in the penalty box
+func (s *Server) ListPenalties(ctx context.Context, _ *adminpb.ListPenaltiesRequest) (*adminpb.ListPenaltiesResponse, error) {
+	if s.config.Penalties == nil {
+		return nil, status.Error(codes.Unimplemented, "the penalty box is disabled")
+	}
+	entries, err := s.config.Penalties.List(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list penalties: %v", err)
+	}
+	response := &adminpb.ListPenaltiesResponse{}
+	for _, entry := range entries {
+		response.Penalties = append(response.Penalties, &adminpb.Penalty{
+			Client:   entry.Client,
+			Level:    entry.Level,
+			Strikes:  int32(entry.Strikes),
+			Offences: int32(entry.Offences),
+			Since:    timestamppb.New(entry.Since),
+			Until:    timestamppb.New(entry.Until),
+		})
+	}
+	return response, nil
+}
+
+// ReleasePenalty releases a client from the penalty box; the release is audited
+func (s *Server) ReleasePenalty(ctx context.Context, req *adminpb.ReleasePenaltyRequest) (*adminpb.ReleasePenaltyResponse, error) {
+	if s.config.Penalties == nil {
+		return nil, status.Error(codes.Unimplemented, "the penalty box is disabled")
+	}
+	if err := s.config.Penalties.Release(ctx, req.Client, callerName(ctx)); err != nil {
+		if errors.Is(err, penalty.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to release client: %v", err)
+	}
+	return &adminpb.ReleasePenaltyResponse{}, nil
+}
@@ -0,0 +1,432 @@
+package admingrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/config"
+	"api-gateway/metrics"
+	"api-gateway/penalty"
+	adminpb "api-gateway/proto/admin/v1"
+	"api-gateway/proxy"
+	"api-gateway/ratelimit"
+	"api-gateway/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const testSecret = "admin-grpc-test-secret-0123456789"
+
+var testJWT = auth.NewJWTManager(testSecret, "api-gateway", "api-gateway-users", time.Hour)
+
+// newTestServer serves the admin API over an in-memory connection, filling
+// in the gateway configuration, tokens and API keys unless cfg sets them
+func newTestServer(t *testing.T, cfg *Config) (*Server, adminpb.AdminServiceClient) {
+	t.Helper()
+	if cfg.Gateway == nil {
+		t.Setenv("JWT_SECRET", testSecret)
+		gatewayConfig, err := config.LoadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Gateway = gatewayConfig
+	}
+	if cfg.Tokens == nil {
+		cfg.Tokens = testJWT
+	}
+	if cfg.APIKeys == nil {
+		cfg.APIKeys = auth.NewAPIKeyStore(time.Hour)
+	}
+	if cfg.WatchInterval == 0 {
+		cfg.WatchInterval = 20 * time.Millisecond
+	}
+
+	server := NewServer(cfg)
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///admin",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, adminpb.NewAdminServiceClient(conn)
+}
+
+// as returns a context calling with a token carrying roles
+func as(t *testing.T, username string, roles ...string) context.Context {
+	token, err := testJWT.GenerateToken("id-"+username, username, username+"@example.com", roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func expectCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("code %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	_, client := newTestServer(t, &Config{})
+	admin := as(t, "alice", "admin")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"no token", context.Background(), codes.Unauthenticated},
+		{"malformed header", metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic YWRtaW46YWRtaW4="), codes.Unauthenticated},
+		{"token of another issuer", metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+mustToken(t, auth.NewJWTManager("another-secret-0123456789abcdefgh", "api-gateway", "api-gateway-users", time.Hour))), codes.Unauthenticated},
+		{"user without the admin role", as(t, "bob", "user", "moderator"), codes.PermissionDenied},
+		{"admin", admin, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetConfig(tt.ctx, &adminpb.GetConfigRequest{})
+			expectCode(t, err, tt.want)
+
+			// Watches are authorized before they send anything
+			ctx, cancel := context.WithTimeout(tt.ctx, 5*time.Second)
+			defer cancel()
+			stream, err := client.WatchConfig(ctx, &adminpb.WatchConfigRequest{})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			expectCode(t, err, tt.want)
+		})
+	}
+}
+
+func mustToken(t *testing.T, issuer *auth.JWTManager) string {
+	token, err := issuer.GenerateToken("1", "mallory", "mallory@example.com", []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthorizationPermissions(t *testing.T) {
+	// Admins here may read rate limit settings but not change them
+	hooks := &auth.Hooks{Authorizer: auth.RolePermissions{"admin": {"ratelimit:read"}}}
+	_, client := newTestServer(t, &Config{Exemptions: newTestExemptions(t), Hooks: hooks})
+	admin := as(t, "alice", "admin")
+
+	_, err := client.ListExemptions(admin, &adminpb.ListExemptionsRequest{})
+	expectCode(t, err, codes.OK)
+	_, err = client.AddExemption(admin, &adminpb.AddExemptionRequest{Kind: "ip", Value: "10.0.0.1", Reason: "monitoring"})
+	expectCode(t, err, codes.PermissionDenied)
+	if !strings.Contains(status.Convert(err).Message(), "ratelimit:write") {
+		t.Errorf("refusal %q does not name the permission", status.Convert(err).Message())
+	}
+	// Calls without a permission of their own need only the admin role
+	_, err = client.ListAPIKeys(admin, &adminpb.ListAPIKeysRequest{})
+	expectCode(t, err, codes.OK)
+}
+
+func TestGetStatusAndConfig(t *testing.T) {
+	server, client := newTestServer(t, &Config{Version: "1.2.3"})
+	admin := as(t, "alice", "admin")
+
+	status, err := client.GetStatus(admin, &adminpb.GetStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != "1.2.3" || status.InstanceId != "" || len(status.Members) != 0 {
+		t.Errorf("status %v", status)
+	}
+	if enabled, ok := status.Subsystems["rate_limit"]; !ok || enabled != server.config.Gateway.RateLimit.Enabled {
+		t.Errorf("subsystems %v", status.Subsystems)
+	}
+	if _, ok := status.Subsystems["jwt"]; ok {
+		t.Error("settings without an Enabled switch are reported as subsystems")
+	}
+
+	cfg, err := client.GetConfig(admin, &adminpb.GetConfigRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != server.config.Gateway.Version() || !strings.Contains(cfg.Json, `"rate_limit"`) {
+		t.Errorf("config version %s, JSON %.80s", cfg.Version, cfg.Json)
+	}
+	if strings.Contains(cfg.Json, testSecret) {
+		t.Error("configuration is not redacted")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	_, client := newTestServer(t, &Config{})
+	admin := as(t, "alice", "admin")
+
+	_, err := client.CreateAPIKey(admin, &adminpb.CreateAPIKeyRequest{Name: "ci"})
+	expectCode(t, err, codes.InvalidArgument)
+
+	created, err := client.CreateAPIKey(admin, &adminpb.CreateAPIKeyRequest{
+		Name: "ci", UserId: "42", Roles: []string{"user"}, Plan: "gold", ExpiresIn: durationpb.New(2 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" || !created.IsActive || created.RateLimit != 100 || created.Plan != "gold" || created.LastUsedAt != nil {
+		t.Errorf("created %v", created)
+	}
+	if lifetime := created.ExpiresAt.AsTime().Sub(created.CreatedAt.AsTime()); lifetime < 119*time.Minute || lifetime > 121*time.Minute {
+		t.Errorf("key expires after %v, want 2h", lifetime)
+	}
+	other, err := client.CreateAPIKey(admin, &adminpb.CreateAPIKeyRequest{Name: "other", UserId: "7", Roles: []string{"user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.GetAPIKey(admin, &adminpb.GetAPIKeyRequest{Key: created.Key})
+	if err != nil || got.Name != "ci" || got.UserId != "42" {
+		t.Fatalf("GetAPIKey = %v, %v", got, err)
+	}
+	_, err = client.GetAPIKey(admin, &adminpb.GetAPIKeyRequest{Key: "missing"})
+	expectCode(t, err, codes.NotFound)
+
+	revoked, err := client.RevokeAPIKey(admin, &adminpb.RevokeAPIKeyRequest{Key: created.Key})
+	if err != nil || revoked.IsActive {
+		t.Fatalf("RevokeAPIKey = %v, %v", revoked, err)
+	}
+	_, err = client.RevokeAPIKey(admin, &adminpb.RevokeAPIKeyRequest{Key: "missing"})
+	expectCode(t, err, codes.NotFound)
+
+	if _, err := client.DeleteAPIKey(admin, &adminpb.DeleteAPIKeyRequest{Key: created.Key}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.DeleteAPIKey(admin, &adminpb.DeleteAPIKeyRequest{Key: created.Key})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	list, err := client.ListAPIKeys(admin, &adminpb.ListAPIKeysRequest{UserId: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Keys) != 1 || list.Keys[0].Key != created.Key || list.Keys[0].DeletedAt == nil {
+		t.Errorf("keys of user 42: %v, want the deleted key", list.Keys)
+	}
+	all, err := client.ListAPIKeys(admin, &adminpb.ListAPIKeysRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Keys) != 2 || all.Keys[0].Key != created.Key || all.Keys[1].Key != other.Key {
+		t.Errorf("all keys: %v", all.Keys)
+	}
+
+	restored, err := client.RestoreAPIKey(admin, &adminpb.RestoreAPIKeyRequest{Key: created.Key})
+	if err != nil || restored.DeletedAt != nil {
+		t.Fatalf("RestoreAPIKey = %v, %v", restored, err)
+	}
+	_, err = client.RestoreAPIKey(admin, &adminpb.RestoreAPIKeyRequest{Key: created.Key})
+	expectCode(t, err, codes.FailedPrecondition)
+}
+
+func newTestExemptions(t *testing.T) *ratelimit.Exemptions {
+	configured := []*ratelimit.Exemption{{Kind: "ip", Value: "192.0.2.1", Reason: "load balancer"}}
+	exemptions, err := ratelimit.NewExemptions(configured, ratelimit.NewKVExemptionStore(storage.NewMemoryStore()), func(*http.Request) (string, []string) {
+		return "", nil
+	}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exemptions
+}
+
+func TestExemptions(t *testing.T) {
+	_, client := newTestServer(t, &Config{Exemptions: newTestExemptions(t)})
+	admin := as(t, "alice", "admin")
+
+	_, err := client.AddExemption(admin, &adminpb.AddExemptionRequest{Kind: "ip", Value: "10.0.0.0/8"})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = client.AddExemption(admin, &adminpb.AddExemptionRequest{Kind: "planet", Value: "mars", Reason: "test"})
+	expectCode(t, err, codes.InvalidArgument)
+
+	added, err := client.AddExemption(admin, &adminpb.AddExemptionRequest{Kind: "ip", Value: "10.0.0.0/8", Reason: "monitoring", Ttl: durationpb.New(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Id == "" || added.Source != "api" || added.CreatedBy != "alice" || added.ExpiresAt == nil {
+		t.Errorf("added %v", added)
+	}
+
+	list, err := client.ListExemptions(admin, &adminpb.ListExemptionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Exemptions) != 2 {
+		t.Fatalf("exemptions %v", list.Exemptions)
+	}
+	for _, exemption := range list.Exemptions {
+		if exemption.Source == "config" {
+			_, err := client.RemoveExemption(admin, &adminpb.RemoveExemptionRequest{Id: exemption.Id})
+			expectCode(t, err, codes.FailedPrecondition)
+		}
+	}
+
+	if _, err := client.RemoveExemption(admin, &adminpb.RemoveExemptionRequest{Id: added.Id}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.RemoveExemption(admin, &adminpb.RemoveExemptionRequest{Id: added.Id})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestPenalties(t *testing.T) {
+	box := penalty.NewBox(&penalty.Config{}, penalty.NewKVStore(storage.NewMemoryStore()), metrics.NewRegistry())
+	now := time.Now()
+	if err := box.Replicate(context.Background(), &penalty.Entry{Client: "ip:192.0.2.7", Level: penalty.LevelBanned, Strikes: 12, Offences: 2, Since: now, Until: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	_, client := newTestServer(t, &Config{Penalties: box})
+	admin := as(t, "alice", "admin")
+
+	list, err := client.ListPenalties(admin, &adminpb.ListPenaltiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Penalties) != 1 || list.Penalties[0].Client != "ip:192.0.2.7" || list.Penalties[0].Level != penalty.LevelBanned ||
+		list.Penalties[0].Strikes != 12 || !list.Penalties[0].Until.AsTime().Equal(now.Add(time.Hour).Truncate(time.Nanosecond)) {
+		t.Errorf("penalties %v", list.Penalties)
+	}
+
+	if _, err := client.ReleasePenalty(admin, &adminpb.ReleasePenaltyRequest{Client: "ip:192.0.2.7"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ReleasePenalty(admin, &adminpb.ReleasePenaltyRequest{Client: "ip:192.0.2.7"})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestDisabledComponents(t *testing.T) {
+	_, client := newTestServer(t, &Config{})
+	admin := as(t, "alice", "admin")
+
+	_, err := client.ListExemptions(admin, &adminpb.ListExemptionsRequest{})
+	expectCode(t, err, codes.Unimplemented)
+	_, err = client.ListPenalties(admin, &adminpb.ListPenaltiesRequest{})
+	expectCode(t, err, codes.Unimplemented)
+	_, err = client.ReleasePenalty(admin, &adminpb.ReleasePenaltyRequest{Client: "ip:192.0.2.1"})
+	expectCode(t, err, codes.Unimplemented)
+
+	routes, err := client.ListRoutes(admin, &adminpb.ListRoutesRequest{})
+	if err != nil || len(routes.Routes) != 0 {
+		t.Errorf("ListRoutes without upstreams = %v, %v", routes, err)
+	}
+}
+
+func TestWatchRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	downURL, _ := url.Parse(down.URL)
+	reverseProxy, err := proxy.New(&proxy.Config{Upstreams: []*proxy.Upstream{
+		{Name: "orders", Target: backendURL, PathPrefix: "/orders"},
+		{Name: "users", Target: downURL, PathPrefix: "/users"},
+	}}, metrics.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := newTestServer(t, &Config{Proxy: reverseProxy})
+	admin := as(t, "alice", "admin")
+
+	routes, err := client.ListRoutes(admin, &adminpb.ListRoutesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes.Routes) != 2 || routes.Routes[0].Upstream != "orders" || !routes.Routes[0].Healthy || routes.Routes[1].Healthy ||
+		routes.Routes[0].Url != backend.URL || routes.Routes[0].PathPrefix != "/orders" {
+		t.Errorf("routes %v", routes.Routes)
+	}
+	if limit := routes.Routes[0].RateLimit; (limit != nil) != server.config.Gateway.RateLimit.Enabled {
+		t.Errorf("rate limit %v", limit)
+	}
+
+	ctx, cancel := context.WithTimeout(admin, 10*time.Second)
+	defer cancel()
+	stream, err := client.WatchRoutes(ctx, &adminpb.WatchRoutesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"orders", "users"} {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != adminpb.RouteEvent_TYPE_SNAPSHOT || event.Route.Upstream != want {
+			t.Errorf("event %v, want a snapshot of %s", event, want)
+		}
+	}
+
+	// Only changes follow the snapshot
+	backend.Close()
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != adminpb.RouteEvent_TYPE_UNHEALTHY || event.Route.Upstream != "orders" || event.Route.Healthy {
+		t.Errorf("event %v, want orders turning unhealthy", event)
+	}
+
+	// Shutting down ends watches, rather than waiting for them forever
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("after shutdown: err = %v, want the end of the stream", err)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	server, client := newTestServer(t, &Config{})
+	ctx, cancel := context.WithTimeout(as(t, "alice", "admin"), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchConfig(ctx, &adminpb.WatchConfigRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Config.Version != server.config.Gateway.Version() || event.Config.Json == "" || event.ChangedAt == nil {
+		t.Errorf("first event %v", event)
+	}
+
+	// Without a cluster nothing else changes, and the watch stays open
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	waiting, err := client.WatchConfig(waitCtx, &adminpb.WatchConfigRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waiting.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waiting.Recv(); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("second event: err = %v, want the client's deadline", err)
+	}
+}
@@ -0,0 +1,340 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+)
+
+// Signals scored for each route and consumer
+const (
+	SignalRequests  = "requests"   // Requests per interval
+	SignalErrorRate = "error_rate" // Share of responses with a 4xx or 5xx status
+	SignalLatency   = "latency"    // Mean latency in milliseconds
+)
+
+// Dimensions traffic is grouped by
+const (
+	DimensionRoute    = "route"
+	DimensionConsumer = "consumer"
+)
+
+// maxAlerts is the number of recent alerts kept for the admin API
+const maxAlerts = 100
+
+// idleIntervals is how long a series may see no requests before it is dropped
+const idleIntervals = 60
+
+// Alert describes traffic that deviated sharply from its recent pattern
+type Alert struct {
+	Time      time.Time `json:"time"`
+	Dimension string    `json:"dimension"` // "route" or "consumer"
+	Key       string    `json:"key"`       // Route template or consumer
+	Signal    string    `json:"signal"`
+	Value     float64   `json:"value"`    // Observed over the last interval
+	Expected  float64   `json:"expected"` // Moving average
+	StdDev    float64   `json:"stddev"`
+	Score     float64   `json:"score"` // Deviation in standard deviations; negative for drops
+}
+
+// Config represents anomaly detection configuration
+type Config struct {
+	Dimensions     []string      // "route" and/or "consumer"
+	Interval       time.Duration // Period traffic is aggregated over before scoring
+	Alpha          float64       // Weight of the newest interval in the moving averages, 0-1
+	Threshold      float64       // Deviation in standard deviations that raises an alert
+	Warmup         int           // Intervals a series is observed before it can alert
+	MinRequests    int           // Requests an interval needs before its error rate and latency are scored
+	Cooldown       time.Duration // Minimum time between alerts for the same series and signal
+	MaxSeries      int           // Routes and consumers tracked at once
+	WebhookURL     string        // Receives alerts as JSON; empty disables
+	WebhookTimeout time.Duration
+}
+
+// window aggregates one series' traffic during the current interval
+type window struct {
+	requests  int
+	errors    int
+	latencyMs float64
+}
+
+// ewma is an exponentially weighted moving average and variance
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// update folds a value into the average
+func (e *ewma) update(value, alpha float64) {
+	if e.samples == 0 {
+		e.mean = value
+	} else {
+		diff := value - e.mean
+		e.mean += alpha * diff
+		e.variance = (1 - alpha) * (e.variance + alpha*diff*diff)
+	}
+	e.samples++
+}
+
+// series tracks the moving averages of one route or consumer
+type series struct {
+	signals   map[string]*ewma
+	lastAlert map[string]time.Time
+	idle      int // Intervals without requests
+}
+
+// Detector scores traffic per route and consumer against exponentially
+// weighted moving averages and alerts when an interval deviates by more than
+// the threshold
+type Detector struct {
+	config *Config
+	client *http.Client
+
+	mu      sync.Mutex
+	current map[string]*window // dimension + "\x00" + key -> traffic this interval
+	series  map[string]*series
+	alerts  []*Alert
+
+	alertCount *metrics.CounterVec
+}
+
+// NewDetector creates an anomaly detector and starts scoring every interval
+func NewDetector(config *Config, reg *metrics.Registry) *Detector {
+	d := &Detector{
+		config:  config,
+		client:  &http.Client{Timeout: config.WebhookTimeout},
+		current: make(map[string]*window),
+		series:  make(map[string]*series),
+		alertCount: reg.NewCounterVec("gateway_anomalies_total",
+			"Traffic anomalies detected, by dimension, signal and direction (up or down).", "dimension", "signal", "direction"),
+	}
+
+	go d.scoreRoutine()
+
+	return d
+}
+
+// Middleware returns the HTTP middleware function
+func (d *Detector) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = auth.WithIdentitySlot(r)
+			start := time.Now()
+			sw := httputil.NewStatusWriter(w)
+
+			next.ServeHTTP(sw, r)
+
+			latency := float64(time.Since(start)) / float64(time.Millisecond)
+			for _, dimension := range d.config.Dimensions {
+				key := metrics.RouteLabel(r)
+				if dimension == DimensionConsumer {
+					key = metrics.ConsumerLabel(r)
+				}
+				d.observe(dimension, key, sw.StatusCode(), latency)
+			}
+		})
+	}
+}
+
+// observe adds a request to the current interval
+func (d *Detector) observe(dimension, key string, status int, latencyMs float64) {
+	id := dimension + "\x00" + key
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	win, exists := d.current[id]
+	if !exists {
+		if _, tracked := d.series[id]; !tracked && len(d.series)+len(d.current) >= d.config.MaxSeries {
+			return
+		}
+		win = &window{}
+		d.current[id] = win
+	}
+	win.requests++
+	if status >= 400 {
+		win.errors++
+	}
+	win.latencyMs += latencyMs
+}
+
+// Alerts returns the most recent alerts, newest first
+func (d *Detector) Alerts() []*Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	alerts := make([]*Alert, len(d.alerts))
+	for i, alert := range d.alerts {
+		alerts[len(d.alerts)-1-i] = alert
+	}
+	return alerts
+}
+
+// scoreRoutine scores the traffic of each interval
+func (d *Detector) scoreRoutine() {
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, alert := range d.score(now) {
+			d.emit(alert)
+		}
+	}
+}
+
+// score compares the finished interval with each series' moving averages,
+// then folds it in
+func (d *Detector) score(now time.Time) []*Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	finished := d.current
+	d.current = make(map[string]*window)
+
+	var alerts []*Alert
+	for id := range finished {
+		if _, exists := d.series[id]; !exists {
+			d.series[id] = &series{signals: make(map[string]*ewma), lastAlert: make(map[string]time.Time)}
+		}
+	}
+	for id, s := range d.series {
+		win := finished[id]
+		if win == nil {
+			// Silence is itself a signal for request rates
+			if s.idle++; s.idle >= idleIntervals {
+				delete(d.series, id)
+				continue
+			}
+			win = &window{}
+		} else {
+			s.idle = 0
+		}
+
+		values := map[string]float64{SignalRequests: float64(win.requests)}
+		if win.requests >= d.config.MinRequests {
+			values[SignalErrorRate] = float64(win.errors) / float64(win.requests)
+			values[SignalLatency] = win.latencyMs / float64(win.requests)
+		}
+		for signal, value := range values {
+			avg := s.signals[signal]
+			if avg == nil {
+				avg = &ewma{}
+				s.signals[signal] = avg
+			}
+			if alert := d.check(id, s, signal, avg, value, now); alert != nil {
+				alerts = append(alerts, alert)
+			}
+			avg.update(value, d.config.Alpha)
+		}
+	}
+
+	d.alerts = append(d.alerts, alerts...)
+	if len(d.alerts) > maxAlerts {
+		d.alerts = append([]*Alert(nil), d.alerts[len(d.alerts)-maxAlerts:]...)
+	}
+	return alerts
+}
+
+// check scores a value against its moving average, returning an alert when
+// it deviates by more than the threshold
+func (d *Detector) check(id string, s *series, signal string, avg *ewma, value float64, now time.Time) *Alert {
+	if avg.samples < d.config.Warmup {
+		return nil
+	}
+	// Floor the deviation so steady series do not alert on small changes
+	stddev := math.Max(math.Sqrt(avg.variance), 0.1*math.Abs(avg.mean))
+	switch signal {
+	case SignalRequests:
+		stddev = math.Max(stddev, math.Sqrt(avg.mean))
+		stddev = math.Max(stddev, 1)
+	case SignalErrorRate:
+		stddev = math.Max(stddev, 0.01)
+	case SignalLatency:
+		stddev = math.Max(stddev, 1)
+	}
+	score := (value - avg.mean) / stddev
+	// Only request rates are expected to drop abnormally; fewer errors or
+	// faster responses are good news
+	if score < d.config.Threshold && (signal != SignalRequests || score > -d.config.Threshold) {
+		return nil
+	}
+	if last, ok := s.lastAlert[signal]; ok && now.Sub(last) < d.config.Cooldown {
+		return nil
+	}
+	s.lastAlert[signal] = now
+
+	dimension, key := splitID(id)
+	return &Alert{
+		Time:      now,
+		Dimension: dimension,
+		Key:       key,
+		Signal:    signal,
+		Value:     value,
+		Expected:  avg.mean,
+		StdDev:    stddev,
+		Score:     score,
+	}
+}
+
+// emit logs, counts and delivers an alert
+func (d *Detector) emit(alert *Alert) {
+	direction := "up"
+	if alert.Score < 0 {
+		direction = "down"
+	}
+	d.alertCount.Inc(alert.Dimension, alert.Signal, direction)
+	log.Printf("Anomaly: %s %s %s is %.4g, expected %.4g ± %.4g (score %.1f)",
+		alert.Dimension, alert.Key, alert.Signal, alert.Value, alert.Expected, alert.StdDev, alert.Score)
+
+	if d.config.WebhookURL == "" {
+		return
+	}
+	if err := d.deliver(alert); err != nil {
+		log.Printf("Failed to deliver anomaly alert: %v", err)
+	}
+}
+
+// deliver posts an alert to the webhook
+func (d *Detector) deliver(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// splitID splits a series ID into its dimension and key
+func splitID(id string) (string, string) {
+	for i := 0; i < len(id); i++ {
+		if id[i] == 0 {
+			return id[:i], id[i+1:]
+		}
+	}
+	return id, ""
+}
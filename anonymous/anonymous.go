@@ -0,0 +1,128 @@
+// Package anonymous admits unauthenticated requests to designated routes
+// under stricter limits than authenticated clients get
+package anonymous
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/httputil"
+)
+
+// Config configures the anonymous tier
+type Config struct {
+	Paths              []string      // Route prefixes open to unauthenticated requests
+	FingerprintHeaders []string      // Request headers identifying a client along with its IP
+	Quota              int           // Requests per client and quota window; zero disables the quota
+	QuotaWindow        time.Duration // Period the quota applies to, starting with a client's first request
+	// Proxies whose forwarding headers give the client IP; nil uses the peer
+	Proxies *httputil.TrustedProxies
+}
+
+// Tier enforces the anonymous limits. Requests carrying credentials are
+// authenticated as usual and never count against them, so clients move to
+// their own limits as soon as they authenticate.
+type Tier struct {
+	config    *Config
+	rateLimit func(http.Handler) http.Handler
+	quotas    QuotaStore
+}
+
+// NewTier creates an anonymous tier. rateLimit is rate limiting middleware
+// keyed by ClientKey with the same config; quotas counts requests against the
+// quota.
+func NewTier(config *Config, rateLimit func(http.Handler) http.Handler, quotas QuotaStore) *Tier {
+	return &Tier{
+		config:    config,
+		rateLimit: rateLimit,
+		quotas:    quotas,
+	}
+}
+
+// ClientKey identifies an anonymous client by its IP and the configured
+// fingerprint headers. The values are hashed so they are not kept in limiter
+// state.
+func ClientKey(r *http.Request, config *Config) string {
+	h := sha256.New()
+	h.Write([]byte(config.Proxies.ClientIP(r)))
+	for _, header := range config.FingerprintHeaders {
+		h.Write([]byte{0})
+		h.Write([]byte(r.Header.Get(header)))
+	}
+	return "anonymous:" + hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// Covers reports whether unauthenticated requests to the path are admitted
+func (t *Tier) Covers(path string) bool {
+	for _, prefix := range t.config.Paths {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware sends requests with credentials, and requests to routes outside
+// the tier, through requireAuth. Other requests skip authentication and are
+// limited by the anonymous rate limit and quota.
+func (t *Tier) Middleware(requireAuth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := requireAuth(next)
+		anonymous := t.rateLimit(t.quota(next))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hasCredentials(r) || !t.Covers(r.URL.Path) {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("X-Access-Tier", "anonymous")
+			anonymous.ServeHTTP(w, r)
+		})
+	}
+}
+
+// quota counts anonymous requests against the quota
+func (t *Tier) quota(next http.Handler) http.Handler {
+	if t.config.Quota <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		used, reset, err := t.quotas.Take(ctx, ClientKey(r, t.config), t.config.QuotaWindow)
+		cancel()
+		if err != nil {
+			// Like the rate limiter, a failing store lets requests through
+			log.Printf("Anonymous quota check failed: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining := t.config.Quota - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("X-Quota-Limit", strconv.Itoa(t.config.Quota))
+		w.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if used > t.config.Quota {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":"Anonymous quota exceeded","details":"Authenticate with a JWT or API key for higher limits"}`))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasCredentials reports whether the request presents a token, an API key
+// or a verified client certificate
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-API-Key") != "" ||
+		(r.TLS != nil && len(r.TLS.VerifiedChains) > 0)
+}
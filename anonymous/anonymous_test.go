@@ -0,0 +1,63 @@
+package anonymous
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/httputil"
+)
+
+func TestClientKey(t *testing.T) {
+	proxies, err := httputil.ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{FingerprintHeaders: []string{"User-Agent"}, Proxies: proxies}
+	key := func(peer, xff, userAgent string) string {
+		r := httptest.NewRequest("GET", "/catalog", nil)
+		r.RemoteAddr = peer
+		if xff != "" {
+			r.Header.Set("X-Forwarded-For", xff)
+		}
+		r.Header.Set("User-Agent", userAgent)
+		return ClientKey(r, config)
+	}
+
+	direct := key("203.0.113.9:4000", "", "curl")
+	if got := key("203.0.113.9:5000", "198.51.100.1", "curl"); got != direct {
+		t.Error("a client rotating X-Forwarded-For got a new key")
+	}
+	if key("203.0.113.10:4000", "", "curl") == direct {
+		t.Error("clients at different addresses share a key")
+	}
+	if key("203.0.113.9:4000", "", "wget") == direct {
+		t.Error("fingerprint headers are not part of the key")
+	}
+	// Behind a trusted proxy, the forwarded address tells clients apart
+	if key("10.0.0.1:4000", "198.51.100.1", "curl") == key("10.0.0.1:4000", "198.51.100.2", "curl") {
+		t.Error("clients behind a trusted proxy share a key")
+	}
+}
+
+func TestQuotaIgnoresForwardedFor(t *testing.T) {
+	config := &Config{Paths: []string{"/catalog"}, Quota: 1, QuotaWindow: time.Hour}
+	tier := NewTier(config, func(next http.Handler) http.Handler { return next }, NewMemoryQuotaStore())
+	handler := tier.Middleware(func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest("GET", "/catalog/items", nil)
+		r.RemoteAddr = "203.0.113.9:4000"
+		r.Header.Set("X-Forwarded-For", []string{"198.51.100.1", "198.51.100.2"}[i])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("request %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
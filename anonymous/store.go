@@ -0,0 +1,103 @@
+package anonymous
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// QuotaStore counts requests in fixed quota windows
+type QuotaStore interface {
+	// Take counts a request of a client, returning the requests counted in the
+	// current window, including this one, and when the window ends
+	Take(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+// quotaWindow counts the requests of one client
+type quotaWindow struct {
+	count int
+	end   time.Time
+}
+
+// MemoryQuotaStore counts requests in memory
+type MemoryQuotaStore struct {
+	mu      sync.Mutex
+	windows map[string]*quotaWindow
+}
+
+// NewMemoryQuotaStore creates a new in-memory quota store
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	store := &MemoryQuotaStore{
+		windows: make(map[string]*quotaWindow),
+	}
+
+	go store.cleanupRoutine()
+
+	return store
+}
+
+// Take counts a request of a client
+func (s *MemoryQuotaStore) Take(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	win, exists := s.windows[key]
+	if !exists || !now.Before(win.end) {
+		win = &quotaWindow{end: now.Add(window)}
+		s.windows[key] = win
+	}
+	win.count++
+	return win.count, win.end, nil
+}
+
+// cleanupRoutine removes finished quota windows
+func (s *MemoryQuotaStore) cleanupRoutine() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for key, win := range s.windows {
+			if !now.Before(win.end) {
+				delete(s.windows, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisQuotaStore counts requests in Redis so the quota holds across replicas
+type RedisQuotaStore struct {
+	client *redis.Client
+}
+
+// NewRedisQuotaStore creates a new Redis-backed quota store
+func NewRedisQuotaStore(client *redis.Client) *RedisQuotaStore {
+	return &RedisQuotaStore{
+		client: client,
+	}
+}
+
+// takeScript increments a counter, starting its window on the first request
+var takeScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {count, redis.call('PTTL', KEYS[1])}
+`)
+
+// Take counts a request of a client
+func (s *RedisQuotaStore) Take(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	result, err := takeScript.Run(ctx, s.client, []string{"quota:" + key}, strconv.FormatInt(window.Milliseconds(), 10)).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to count anonymous request: %w", err)
+	}
+	return int(result[0]), time.Now().Add(time.Duration(result[1]) * time.Millisecond), nil
+}
@@ -0,0 +1,117 @@
+package antireplay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/proxy"
+)
+
+// Config represents anti-replay middleware configuration
+type Config struct {
+	Paths           []string      `json:"paths"` // Route prefixes that require a timestamp and nonce
+	TimestampHeader string        `json:"timestamp_header"`
+	NonceHeader     string        `json:"nonce_header"`
+	MaxSkew         time.Duration `json:"max_skew"`  // Largest accepted difference from the gateway clock
+	FailOpen        bool          `json:"fail_open"` // Allow requests when the nonce store is unavailable
+}
+
+// Middleware rejects requests on protected routes whose timestamp is outside
+// the allowed skew or whose nonce was already used. Nonces are remembered for
+// twice the skew, covering every timestamp that could still be accepted.
+func Middleware(store Store, config *Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions || !protected(r.URL.Path, config.Paths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timestamp, err := parseTimestamp(r.Header.Get(config.TimestampHeader))
+			if err != nil {
+				http.Error(w, `{"error":"Invalid timestamp","details":"`+config.TimestampHeader+` must be Unix seconds or RFC 3339"}`, http.StatusUnauthorized)
+				return
+			}
+			if skew := time.Since(timestamp); skew > config.MaxSkew || skew < -config.MaxSkew {
+				http.Error(w, `{"error":"Stale request","details":"`+config.TimestampHeader+` must be within `+config.MaxSkew.String()+` of the server time"}`, http.StatusUnauthorized)
+				return
+			}
+
+			nonce := r.Header.Get(config.NonceHeader)
+			if !validNonce(nonce) {
+				http.Error(w, `{"error":"Invalid nonce","details":"`+config.NonceHeader+` must be 16-128 letters, digits, '-' or '_'"}`, http.StatusUnauthorized)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			claimed, err := store.Claim(ctx, scopedNonce(r, nonce), 2*config.MaxSkew)
+			cancel()
+			if err != nil {
+				log.Printf("Anti-replay check failed: %v", err)
+				if config.FailOpen {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, `{"error":"Service unavailable","details":"Replay protection is temporarily unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			if !claimed {
+				http.Error(w, `{"error":"Replayed request","details":"`+config.NonceHeader+` was already used"}`, http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// protected reports whether a path requires replay protection
+func protected(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if proxy.HasPathPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimestamp accepts Unix seconds or an RFC 3339 time
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// validNonce reports whether a nonce is long enough to be unpredictable and
+// safe to use in a storage key
+func validNonce(nonce string) bool {
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return false
+	}
+	for _, c := range nonce {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// scopedNonce scopes a nonce to the caller's credentials so clients cannot
+// exhaust each other's nonces
+func scopedNonce(r *http.Request, nonce string) string {
+	h := sha256.New()
+	h.Write([]byte(r.Header.Get("Authorization")))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Header.Get("X-API-Key")))
+	return hex.EncodeToString(h.Sum(nil)[:16]) + ":" + nonce
+}
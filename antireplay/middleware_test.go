@@ -0,0 +1,97 @@
+package antireplay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStore cannot reach its backend
+type failingStore struct{}
+
+func (failingStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestMiddlewareDenies(t *testing.T) {
+	now := time.Now()
+	fresh := strconv.FormatInt(now.Unix(), 10)
+	const nonce = "nonce-0123456789abcdef"
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		timestamp string
+		nonce     string
+		usedBy    string // Authorization header of an earlier request with the nonce
+		store     Store
+		failOpen  bool
+		want      int
+		error     string
+	}{
+		{name: "valid", path: "/api/payments/1", timestamp: fresh, nonce: nonce, want: http.StatusOK},
+		{name: "RFC 3339 timestamp", path: "/api/payments/1", timestamp: now.Format(time.RFC3339), nonce: nonce, want: http.StatusOK},
+		{name: "missing timestamp", path: "/api/payments/1", nonce: nonce, want: http.StatusUnauthorized, error: "Invalid timestamp"},
+		{name: "malformed timestamp", path: "/api/payments/1", timestamp: "yesterday", nonce: nonce, want: http.StatusUnauthorized, error: "Invalid timestamp"},
+		{name: "stale timestamp", path: "/api/payments/1", timestamp: strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), nonce: nonce, want: http.StatusUnauthorized, error: "Stale request"},
+		{name: "future timestamp", path: "/api/payments/1", timestamp: strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10), nonce: nonce, want: http.StatusUnauthorized, error: "Stale request"},
+		{name: "missing nonce", path: "/api/payments/1", timestamp: fresh, want: http.StatusUnauthorized, error: "Invalid nonce"},
+		{name: "short nonce", path: "/api/payments/1", timestamp: fresh, nonce: "abc", want: http.StatusUnauthorized, error: "Invalid nonce"},
+		{name: "nonce with separators", path: "/api/payments/1", timestamp: fresh, nonce: "nonce:0123456789abcdef", want: http.StatusUnauthorized, error: "Invalid nonce"},
+		{name: "replayed nonce", path: "/api/payments/1", timestamp: fresh, nonce: nonce, usedBy: "Bearer caller", want: http.StatusUnauthorized, error: "Replayed request"},
+		{name: "nonce of another caller", path: "/api/payments/1", timestamp: fresh, nonce: nonce, usedBy: "Bearer someone-else", want: http.StatusOK},
+		{name: "store unavailable", path: "/api/payments/1", timestamp: fresh, nonce: nonce, store: failingStore{}, want: http.StatusServiceUnavailable},
+		{name: "store unavailable failing open", path: "/api/payments/1", timestamp: fresh, nonce: nonce, store: failingStore{}, failOpen: true, want: http.StatusOK},
+		{name: "protected prefix itself", path: "/api/payments", want: http.StatusUnauthorized, error: "Invalid timestamp"},
+		{name: "route sharing the prefix", path: "/api/paymentsx", want: http.StatusOK},
+		{name: "route outside the paths", path: "/api/orders", want: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, path: "/api/payments/1", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			if store == nil {
+				store = NewMemoryStore()
+			}
+			handler := Middleware(store, &Config{
+				Paths:           []string{"/api/payments"},
+				TimestampHeader: "X-Timestamp",
+				NonceHeader:     "X-Nonce",
+				MaxSkew:         5 * time.Minute,
+				FailOpen:        tt.failOpen,
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			send := func(authorization string) *httptest.ResponseRecorder {
+				method := tt.method
+				if method == "" {
+					method = http.MethodPost
+				}
+				r := httptest.NewRequest(method, tt.path, nil)
+				r.Header.Set("Authorization", authorization)
+				r.Header.Set("X-Timestamp", tt.timestamp)
+				r.Header.Set("X-Nonce", tt.nonce)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, r)
+				return rec
+			}
+
+			if tt.usedBy != "" {
+				if rec := send(tt.usedBy); rec.Code != http.StatusOK {
+					t.Fatalf("first request: status %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+			rec := send("Bearer caller")
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.error) {
+				t.Errorf("body %s, want error %q", rec.Body.String(), tt.error)
+			}
+		})
+	}
+}
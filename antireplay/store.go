@@ -0,0 +1,85 @@
+package antireplay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store remembers the nonces seen within the replay window
+type Store interface {
+	// Claim records a nonce for ttl. It returns false when the nonce was
+	// already used.
+	Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// MemoryStore keeps nonces in memory
+type MemoryStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time // Nonce -> expiry
+}
+
+// NewMemoryStore creates a new in-memory nonce store
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		nonces: make(map[string]time.Time),
+	}
+
+	go store.cleanupRoutine()
+
+	return store
+}
+
+// Claim records a nonce if it is unused
+func (s *MemoryStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, exists := s.nonces[nonce]; exists && now.Before(expiresAt) {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// cleanupRoutine periodically removes expired nonces
+func (s *MemoryStore) cleanupRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for nonce, expiresAt := range s.nonces {
+			if now.After(expiresAt) {
+				delete(s.nonces, nonce)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisStore keeps nonces in Redis so a replay is detected on any replica
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed nonce store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Claim records a nonce if it is unused
+func (s *RedisStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	claimed, err := s.client.SetNX(ctx, "antireplay:"+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim nonce: %w", err)
+	}
+	return claimed, nil
+}
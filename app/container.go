@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Starter is a component with work to start once the application starts,
+// such as background jobs
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper is a component holding resources to release on shutdown
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// HealthChecker is a component that can report whether it is usable, such as
+// a connection to a database
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Hooks give a component a lifecycle its own methods do not provide. Set
+// hooks replace the component's Start, Stop and HealthCheck methods.
+type Hooks struct {
+	Start  func(ctx context.Context) error
+	Stop   func(ctx context.Context) error
+	Health func(ctx context.Context) error
+}
+
+// component is a registered component with its resolved hooks
+type component struct {
+	name    string
+	value   any
+	hooks   Hooks
+	started bool
+	stopped bool
+}
+
+// Container holds the components of an application by name and manages their
+// lifecycle. Components are registered once built, after the components they
+// use, so starting in registration order and stopping in reverse order never
+// leaves a component running on top of a stopped one.
+type Container struct {
+	mu         sync.Mutex
+	components []*component
+	byName     map[string]*component
+	running    bool
+
+	lifecycle sync.Mutex // Serializes Start and Stop, which run hooks without mu
+}
+
+// New creates an empty container
+func New() *Container {
+	return &Container{
+		byName: make(map[string]*component),
+	}
+}
+
+// Register adds a built component under a unique name. It panics when the
+// name is taken or the container has already started.
+func (c *Container) Register(name string, value any, hooks ...Hooks) {
+	var h Hooks
+	for _, hook := range hooks {
+		if hook.Start != nil {
+			h.Start = hook.Start
+		}
+		if hook.Stop != nil {
+			h.Stop = hook.Stop
+		}
+		if hook.Health != nil {
+			h.Health = hook.Health
+		}
+	}
+	if starter, ok := value.(Starter); ok && h.Start == nil {
+		h.Start = starter.Start
+	}
+	if stopper, ok := value.(Stopper); ok && h.Stop == nil {
+		h.Stop = stopper.Stop
+	}
+	if checker, ok := value.(HealthChecker); ok && h.Health == nil {
+		h.Health = checker.HealthCheck
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		panic(fmt.Sprintf("app: component %q registered after the container started", name))
+	}
+	if _, exists := c.byName[name]; exists {
+		panic(fmt.Sprintf("app: component %q registered twice", name))
+	}
+	registered := &component{name: name, value: value, hooks: h}
+	c.components = append(c.components, registered)
+	c.byName[name] = registered
+}
+
+// Lookup returns the component registered under name, if it has type T
+func Lookup[T any](c *Container, name string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero T
+	registered, exists := c.byName[name]
+	if !exists {
+		return zero, false
+	}
+	value, ok := registered.value.(T)
+	return value, ok
+}
+
+// Names returns the names of the registered components in registration order
+func (c *Container) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, len(c.components))
+	for i, registered := range c.components {
+		names[i] = registered.name
+	}
+	return names
+}
+
+// Start starts the components in registration order. When one fails, those
+// already started are stopped again.
+func (c *Container) Start(ctx context.Context) error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return errors.New("app: container already started")
+	}
+	c.running = true
+	components := append([]*component(nil), c.components...)
+	c.mu.Unlock()
+
+	for _, registered := range components {
+		if registered.hooks.Start != nil {
+			if err := registered.hooks.Start(ctx); err != nil {
+				return errors.Join(fmt.Errorf("failed to start %s: %w", registered.name, err), c.stop(ctx))
+			}
+		}
+		registered.started = true
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse registration order, also
+// releasing the resources of components that have nothing to start. Every
+// component is stopped once, even when others fail; the failures are returned.
+func (c *Container) Stop(ctx context.Context) error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	return c.stop(ctx)
+}
+
+// stop implements Stop. The caller holds the lifecycle lock.
+func (c *Container) stop(ctx context.Context) error {
+	c.mu.Lock()
+	components := append([]*component(nil), c.components...)
+	c.mu.Unlock()
+
+	var err error
+	for i := len(components) - 1; i >= 0; i-- {
+		registered := components[i]
+		if registered.stopped || registered.hooks.Stop == nil || (registered.hooks.Start != nil && !registered.started) {
+			continue
+		}
+		registered.stopped = true
+		if stopErr := registered.hooks.Stop(ctx); stopErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to stop %s: %w", registered.name, stopErr))
+		}
+	}
+	return err
+}
+
+// Health checks the components that can report their health, concurrently,
+// returning the error of each by name; healthy components map to nil
+func (c *Container) Health(ctx context.Context) map[string]error {
+	c.mu.Lock()
+	var checked []*component
+	for _, registered := range c.components {
+		if registered.hooks.Health != nil {
+			checked = append(checked, registered)
+		}
+	}
+	c.mu.Unlock()
+
+	results := make(map[string]error, len(checked))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, registered := range checked {
+		wg.Add(1)
+		go func(registered *component) {
+			defer wg.Done()
+			err := registered.hooks.Health(ctx)
+			mu.Lock()
+			results[registered.name] = err
+			mu.Unlock()
+		}(registered)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recorder records the lifecycle calls of the components it builds
+type recorder struct {
+	calls []string
+}
+
+// hooks returns hooks recording their calls for name, failing to start when failStart is set
+func (r *recorder) hooks(name string, failStart bool) Hooks {
+	return Hooks{
+		Start: func(context.Context) error {
+			r.calls = append(r.calls, "start "+name)
+			if failStart {
+				return errors.New("refused")
+			}
+			return nil
+		},
+		Stop: func(context.Context) error {
+			r.calls = append(r.calls, "stop "+name)
+			return nil
+		},
+	}
+}
+
+// service implements Starter, Stopper and HealthChecker
+type service struct {
+	r      *recorder
+	health error
+}
+
+func (s *service) Start(context.Context) error {
+	s.r.calls = append(s.r.calls, "start service")
+	return nil
+}
+
+func (s *service) Stop(context.Context) error {
+	s.r.calls = append(s.r.calls, "stop service")
+	return nil
+}
+
+func (s *service) HealthCheck(context.Context) error {
+	return s.health
+}
+
+func expectCalls(t *testing.T, r *recorder, want ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls %q, want %q", r.calls, want)
+	}
+	r.calls = nil
+}
+
+func TestStartAndStopOrder(t *testing.T) {
+	r := &recorder{}
+	c := New()
+	c.Register("store", "store", r.hooks("store", false))
+	c.Register("service", &service{r: r})
+	c.Register("jobs", "jobs", r.hooks("jobs", false))
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, r, "start store", "start service", "start jobs")
+	if err := c.Start(context.Background()); err == nil {
+		t.Error("second Start succeeded")
+	}
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, r, "stop jobs", "stop service", "stop store")
+
+	// Every component is stopped once
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, r)
+}
+
+func TestStartRollback(t *testing.T) {
+	r := &recorder{}
+	c := New()
+	c.Register("store", "store", r.hooks("store", false))
+	c.Register("service", &service{r: r})
+	c.Register("jobs", "jobs", r.hooks("jobs", true))
+	c.Register("exporter", "exporter", r.hooks("exporter", false))
+
+	err := c.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to start jobs: refused") {
+		t.Fatalf("Start: %v", err)
+	}
+	// The components started before the failure are stopped in reverse
+	// order; the failed one and those after it are neither started nor stopped
+	expectCalls(t, r, "start store", "start service", "start jobs", "stop service", "stop store")
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, r)
+}
+
+func TestStopReleasesUnstarted(t *testing.T) {
+	r := &recorder{}
+	c := New()
+	// A connection has nothing to start, but is closed on shutdown even
+	// when the container never started
+	c.Register("connection", "connection", Hooks{Stop: r.hooks("connection", false).Stop})
+	c.Register("jobs", "jobs", r.hooks("jobs", false))
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, r, "stop connection")
+}
+
+func TestStopErrors(t *testing.T) {
+	c := New()
+	var stopped []string
+	for _, name := range []string{"first", "second"} {
+		c.Register(name, name, Hooks{Stop: func(context.Context) error {
+			stopped = append(stopped, name)
+			return errors.New(name + " stuck")
+		}})
+	}
+
+	err := c.Stop(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to stop first: first stuck") || !strings.Contains(err.Error(), "failed to stop second: second stuck") {
+		t.Errorf("Stop: %v", err)
+	}
+	if !reflect.DeepEqual(stopped, []string{"second", "first"}) {
+		t.Errorf("stopped %v, want every component despite failures", stopped)
+	}
+}
+
+func TestHooksReplaceMethods(t *testing.T) {
+	r := &recorder{}
+	c := New()
+	c.Register("service", &service{r: r}, Hooks{Start: r.hooks("hook", false).Start})
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, r, "start hook", "stop service")
+}
+
+func TestRegisterPanics(t *testing.T) {
+	expectPanic := func(name string, register func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s did not panic", name)
+			}
+		}()
+		register()
+	}
+
+	c := New()
+	c.Register("store", "store")
+	expectPanic("registering a name twice", func() { c.Register("store", "other") })
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectPanic("registering after Start", func() { c.Register("late", "late") })
+}
+
+func TestLookup(t *testing.T) {
+	c := New()
+	svc := &service{r: &recorder{}}
+	c.Register("service", svc)
+	c.Register("name", "gateway")
+
+	if got, ok := Lookup[*service](c, "service"); !ok || got != svc {
+		t.Errorf("Lookup[*service]: %v, %v", got, ok)
+	}
+	if got, ok := Lookup[Stopper](c, "service"); !ok || got != Stopper(svc) {
+		t.Errorf("Lookup[Stopper]: %v, %v", got, ok)
+	}
+	if got, ok := Lookup[string](c, "name"); !ok || got != "gateway" {
+		t.Errorf("Lookup[string]: %q, %v", got, ok)
+	}
+
+	// A component of another type is not returned
+	if got, ok := Lookup[*recorder](c, "service"); ok || got != nil {
+		t.Errorf("Lookup[*recorder] of a service: %v, %v", got, ok)
+	}
+	if got, ok := Lookup[int](c, "name"); ok || got != 0 {
+		t.Errorf("Lookup[int] of a string: %v, %v", got, ok)
+	}
+	if got, ok := Lookup[Starter](c, "name"); ok || got != nil {
+		t.Errorf("Lookup[Starter] of a string: %v, %v", got, ok)
+	}
+	if _, ok := Lookup[*service](c, "missing"); ok {
+		t.Error("Lookup of a missing component succeeded")
+	}
+}
+
+func TestNamesAndHealth(t *testing.T) {
+	c := New()
+	c.Register("config", "config")
+	c.Register("healthy", &service{r: &recorder{}})
+	c.Register("down", &service{r: &recorder{}, health: errors.New("connection refused")})
+	c.Register("pinged", "pinged", Hooks{Health: func(context.Context) error { return nil }})
+
+	if names := c.Names(); !reflect.DeepEqual(names, []string{"config", "healthy", "down", "pinged"}) {
+		t.Errorf("Names: %v, want registration order", names)
+	}
+
+	health := c.Health(context.Background())
+	if len(health) != 3 {
+		t.Errorf("Health reported %d components, want those that check their health: %v", len(health), health)
+	}
+	if err, ok := health["healthy"]; !ok || err != nil {
+		t.Errorf("healthy: %v, %v", err, ok)
+	}
+	if err := health["down"]; err == nil || err.Error() != "connection refused" {
+		t.Errorf("down: %v", err)
+	}
+	if err, ok := health["pinged"]; !ok || err != nil {
+		t.Errorf("pinged: %v, %v", err, ok)
+	}
+}
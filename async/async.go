@@ -0,0 +1,443 @@
+package async
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/metrics"
+	"api-gateway/storage"
+)
+
+// jobPrefix namespaces jobs in the store, by ID
+const jobPrefix = "async:job:"
+
+// storeTimeout bounds each lookup and write of the store
+const storeTimeout = 2 * time.Second
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed" // The upstream answered; its response is kept, whatever its status
+	StatusFailed    = "failed"    // No response could be obtained
+)
+
+// Route modes
+const (
+	ModeOff    = "off"
+	ModePrefer = "prefer" // Only requests sending "Prefer: respond-async" run asynchronously
+	ModeAlways = "always"
+)
+
+// Job is a request accepted for asynchronous forwarding
+type Job struct {
+	ID         string    `json:"id"`
+	Route      string    `json:"route"`
+	Status     string    `json:"status"`
+	Owner      string    `json:"owner"` // Only the caller who submitted the job may poll it
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	Request  *Request  `json:"request,omitempty"` // Dropped once the job has run
+	Response *Response `json:"response,omitempty"`
+}
+
+// Request is the stored request of a job
+type Request struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"` // Request URI
+	Host       string            `json:"host"`
+	RemoteAddr string            `json:"remote_addr"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body,omitempty"`
+	User       *auth.UserContext `json:"user,omitempty"`
+}
+
+// Response is the upstream's response to a job
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// Config represents asynchronous request configuration
+type Config struct {
+	Workers       int
+	MaxBodySize   int64 // Largest request body accepted
+	MaxResultSize int   // Largest upstream response kept
+	ResultTTL     time.Duration
+	JobTimeout    time.Duration
+	StatusPath    string // Where jobs are polled, followed by the job ID
+}
+
+// Dispatcher runs requests to async routes in the background: the request
+// is stored and queued, the caller gets 202 Accepted with a URL to poll, and
+// a worker later forwards the request to the upstream as the caller and
+// keeps the response until it is fetched or expires.
+type Dispatcher struct {
+	config *Config
+	queue  Queue
+	store  storage.KeyValueStore
+
+	mu     sync.RWMutex
+	routes map[string]http.Handler // Route name -> handler forwarding its jobs
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	jobs *metrics.CounterVec
+}
+
+// NewDispatcher creates a dispatcher keeping jobs in store. With a shared
+// store and a Redis queue, any replica can run and answer for any job.
+func NewDispatcher(config *Config, queue Queue, store storage.KeyValueStore, reg *metrics.Registry) *Dispatcher {
+	return &Dispatcher{
+		config: config,
+		queue:  queue,
+		store:  store,
+		routes: make(map[string]http.Handler),
+		jobs: reg.NewCounterVec("gateway_async_jobs_total",
+			"Asynchronous requests, by route and result (queued, rejected, completed or failed).", "route", "result"),
+	}
+}
+
+// Middleware returns the HTTP middleware queuing a route's requests. It runs
+// after authentication, so jobs run as the caller who submitted them.
+func (d *Dispatcher) Middleware(route, mode string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		d.mu.Lock()
+		d.routes[route] = next
+		d.mu.Unlock()
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !d.applies(r, mode) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, d.config.MaxBodySize+1))
+			if err != nil {
+				http.Error(w, `{"error":"Bad request","details":"Failed to read request body"}`, http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > d.config.MaxBodySize {
+				http.Error(w, `{"error":"Request entity too large","details":"Request body is too large to queue"}`, http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			header := r.Header.Clone()
+			header.Del("Prefer")
+			user := auth.GetUserFromContext(r)
+			job := &Job{
+				Route:     route,
+				Status:    StatusQueued,
+				Owner:     owner(user),
+				CreatedAt: time.Now(),
+				Request: &Request{
+					Method:     r.Method,
+					URL:        r.URL.RequestURI(),
+					Host:       r.Host,
+					RemoteAddr: r.RemoteAddr,
+					Header:     header,
+					Body:       body,
+					User:       user,
+				},
+			}
+			if err := d.submit(r.Context(), job); err != nil {
+				log.Printf("Failed to queue async request to %s: %v", route, err)
+				d.jobs.Inc(route, "rejected")
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, `{"error":"Service unavailable","details":"Request could not be queued"}`, http.StatusServiceUnavailable)
+				return
+			}
+			d.jobs.Inc(route, "queued")
+
+			statusURL := d.config.StatusPath + job.ID
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", statusURL)
+			w.Header().Set("Retry-After", "1")
+			if mode == ModePrefer {
+				w.Header().Set("Preference-Applied", "respond-async")
+			}
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":         job.ID,
+				"status":     job.Status,
+				"status_url": statusURL,
+			})
+		})
+	}
+}
+
+// Get returns a job submitted by the caller, or nil when there is none
+func (d *Dispatcher) Get(ctx context.Context, id string, user *auth.UserContext) (*Job, error) {
+	job, err := d.load(ctx, id)
+	if err != nil || job == nil || job.Owner != owner(user) {
+		return nil, err
+	}
+	job.Request = nil
+	return job, nil
+}
+
+// Start starts the workers
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go d.work(ctx)
+	}
+}
+
+// Stop stops taking jobs and waits for running ones until ctx is done. Jobs
+// left running are picked up again from a Redis queue.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applies reports whether the request runs asynchronously
+func (d *Dispatcher) applies(r *http.Request, mode string) bool {
+	if r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return false
+	}
+	switch mode {
+	case ModeAlways:
+		return true
+	case ModePrefer:
+		for _, value := range r.Header.Values("Prefer") {
+			for _, preference := range strings.Split(value, ",") {
+				if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// submit stores and queues a new job
+func (d *Dispatcher) submit(ctx context.Context, job *Job) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate id: %w", err)
+	}
+	job.ID = hex.EncodeToString(b)
+
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	if err := d.save(ctx, job); err != nil {
+		return err
+	}
+	if err := d.queue.Enqueue(ctx, job.ID); err != nil {
+		d.store.Delete(ctx, jobPrefix+job.ID)
+		return err
+	}
+	return nil
+}
+
+// work runs queued jobs until the dispatcher stops
+func (d *Dispatcher) work(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		id, ack, err := d.queue.Dequeue(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to take async job: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		d.run(ctx, id)
+		ack()
+	}
+}
+
+// run forwards a job's request and stores the response
+func (d *Dispatcher) run(ctx context.Context, id string) {
+	loadCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	job, err := d.load(loadCtx, id)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to load async job %s: %v", id, err)
+		return
+	}
+	// Expired, or finished by a worker whose acknowledgement was lost
+	if job == nil || job.Request == nil {
+		return
+	}
+
+	d.mu.RLock()
+	handler := d.routes[job.Route]
+	d.mu.RUnlock()
+	if handler == nil {
+		d.finish(job, nil, fmt.Errorf("route %s no longer runs asynchronously", job.Route))
+		return
+	}
+
+	job.Status = StatusRunning
+	job.Attempts++
+	job.StartedAt = time.Now()
+	saveCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	err = d.save(saveCtx, job)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to update async job %s: %v", id, err)
+	}
+
+	// The job runs to completion even while the gateway stops
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d.config.JobTimeout)
+	defer cancel()
+	response, err := d.forward(runCtx, handler, job.Request)
+	d.finish(job, response, err)
+}
+
+// forward serves a stored request through the route's handler
+func (d *Dispatcher) forward(ctx context.Context, handler http.Handler, stored *Request) (response *Response, err error) {
+	r, err := http.NewRequestWithContext(ctx, stored.Method, "http://"+stored.Host+stored.URL, bytes.NewReader(stored.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = stored.Header.Clone()
+	r.Host = stored.Host
+	r.RemoteAddr = stored.RemoteAddr
+	r.RequestURI = stored.URL
+	if stored.User != nil {
+		r = auth.WithUser(r, stored.User)
+	}
+
+	rec := &resultRecorder{header: make(http.Header), status: http.StatusOK, limit: d.config.MaxResultSize}
+	defer func() {
+		// The proxy aborts responses that fail midway with a panic
+		if v := recover(); v != nil {
+			err = fmt.Errorf("request aborted: %v", v)
+		}
+	}()
+	handler.ServeHTTP(rec, r)
+	if rec.truncated {
+		return nil, fmt.Errorf("response exceeds %d bytes", d.config.MaxResultSize)
+	}
+	return &Response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}, nil
+}
+
+// finish stores a job's outcome, dropping its request
+func (d *Dispatcher) finish(job *Job, response *Response, err error) {
+	job.Request = nil
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		d.jobs.Inc(job.Route, "failed")
+	} else {
+		job.Status = StatusCompleted
+		job.Response = response
+		d.jobs.Inc(job.Route, "completed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := d.save(ctx, job); err != nil {
+		log.Printf("Failed to store result of async job %s: %v", job.ID, err)
+	}
+}
+
+// load returns a stored job, or nil when it does not exist
+func (d *Dispatcher) load(ctx context.Context, id string) (*Job, error) {
+	data, err := d.store.Get(ctx, jobPrefix+id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode async job: %w", err)
+	}
+	return &job, nil
+}
+
+// save stores a job until ResultTTL after it was created
+func (d *Dispatcher) save(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(job.CreatedAt.Add(d.config.ResultTTL))
+	if ttl <= 0 {
+		return nil
+	}
+	return d.store.Set(ctx, jobPrefix+job.ID, data, ttl)
+}
+
+// owner identifies the caller a job belongs to
+func owner(user *auth.UserContext) string {
+	if user == nil {
+		return ""
+	}
+	return user.AuthType + ":" + user.UserID
+}
+
+// resultRecorder keeps a job's response, up to limit body bytes
+type resultRecorder struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	limit       int
+	wroteHeader bool
+	truncated   bool
+}
+
+func (rec *resultRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *resultRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.status = code
+	}
+}
+
+func (rec *resultRecorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.body.Len()+len(data) > rec.limit {
+		rec.truncated = true
+		return 0, errors.New("response too large")
+	}
+	return rec.body.Write(data)
+}
@@ -0,0 +1,162 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrQueueFull is returned when a memory queue cannot take more jobs
+var ErrQueueFull = errors.New("async queue is full")
+
+// Queue hands job IDs to workers
+type Queue interface {
+	// Enqueue adds a job ID
+	Enqueue(ctx context.Context, id string) error
+	// Dequeue blocks until a job ID is available or ctx is done. The
+	// returned ack removes the job from the queue once it has run.
+	Dequeue(ctx context.Context) (id string, ack func(), err error)
+}
+
+// MemoryQueue queues job IDs in a bounded channel; queued jobs are lost on
+// restart
+type MemoryQueue struct {
+	jobs chan string
+}
+
+// NewMemoryQueue creates a memory queue holding up to size jobs
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{jobs: make(chan string, size)}
+}
+
+// Enqueue adds a job ID, failing when the queue is full
+func (q *MemoryQueue) Enqueue(ctx context.Context, id string) error {
+	select {
+	case q.jobs <- id:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Dequeue waits for a job ID
+func (q *MemoryQueue) Dequeue(ctx context.Context) (string, func(), error) {
+	select {
+	case id := <-q.jobs:
+		return id, func() {}, nil
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+}
+
+// RedisQueue queues job IDs in a Redis stream read by a consumer group, so
+// every replica's workers share one queue. Jobs a dead worker was running
+// are claimed by another worker once they have been idle for ClaimAfter.
+type RedisQueue struct {
+	client     *redis.Client
+	stream     string
+	group      string
+	consumer   string
+	maxLen     int64
+	claimAfter time.Duration
+}
+
+// NewRedisQueue creates a queue on the stream holding up to maxLen jobs,
+// creating its consumer group when needed. Jobs are reclaimed from dead
+// workers after claimAfter, which must exceed the time a job may run.
+func NewRedisQueue(ctx context.Context, client *redis.Client, stream string, maxLen int64, claimAfter time.Duration) (*RedisQueue, error) {
+	group := "workers"
+	err := client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("failed to create async queue consumer group: %w", err)
+	}
+	host, _ := os.Hostname()
+	return &RedisQueue{
+		client:     client,
+		stream:     stream,
+		group:      group,
+		consumer:   fmt.Sprintf("%s-%d", host, os.Getpid()),
+		maxLen:     maxLen,
+		claimAfter: claimAfter,
+	}, nil
+}
+
+// Enqueue adds a job ID to the stream, failing when maxLen jobs are waiting
+// or running. Jobs are never trimmed from the stream, so none are lost.
+func (q *RedisQueue) Enqueue(ctx context.Context, id string) error {
+	length, err := q.client.XLen(ctx, q.stream).Result()
+	if err != nil {
+		return err
+	}
+	if length >= q.maxLen {
+		return ErrQueueFull
+	}
+	return q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.stream,
+		Values: map[string]interface{}{"id": id},
+	}).Err()
+}
+
+// Dequeue claims a job abandoned by a dead worker, or waits for a new one
+func (q *RedisQueue) Dequeue(ctx context.Context) (string, func(), error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", nil, err
+		}
+
+		claimed, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   q.stream,
+			Group:    q.group,
+			Consumer: q.consumer,
+			MinIdle:  q.claimAfter,
+			Start:    "0-0",
+			Count:    1,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return "", nil, err
+		}
+		if len(claimed) > 0 {
+			return q.message(claimed[0])
+		}
+
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: q.consumer,
+			Streams:  []string{q.stream, ">"},
+			Count:    1,
+			Block:    5 * time.Second,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				return q.message(message)
+			}
+		}
+	}
+}
+
+// message returns the job ID of a stream message with its acknowledgement
+func (q *RedisQueue) message(message redis.XMessage) (string, func(), error) {
+	ack := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		q.client.XAck(ctx, q.stream, q.group, message.ID)
+		q.client.XDel(ctx, q.stream, message.ID)
+	}
+	id, _ := message.Values["id"].(string)
+	if id == "" {
+		ack()
+		return "", nil, fmt.Errorf("async queue message %s has no job id", message.ID)
+	}
+	return id, ack, nil
+}
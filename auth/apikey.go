@@ -1,42 +1,69 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
 	"time"
+
+	"api-gateway/storage"
 )
 
 // APIKey represents an API key with metadata
 type APIKey struct {
-	Key        string    `json:"key"`
-	Name       string    `json:"name"`
-	UserID     string    `json:"user_id"`
-	Roles      []string  `json:"roles"`
-	RateLimit  int       `json:"rate_limit"` // requests per minute
-	IsActive   bool      `json:"is_active"`
-	CreatedAt  time.Time `json:"created_at"`
-	LastUsedAt time.Time `json:"last_used_at"`
-	ExpiresAt  time.Time `json:"expires_at"`
-}
-
-// APIKeyStore manages API keys in memory
+	Key        string     `json:"key"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"user_id"`
+	Roles      []string   `json:"roles"`
+	RateLimit  int        `json:"rate_limit"` // requests per minute
+	Plan       string     `json:"plan,omitempty"`
+	Products   []string   `json:"products,omitempty"` // Subscribed API products
+	Requests   int64      `json:"requests"`           // Successfully authenticated requests
+	IsActive   bool       `json:"is_active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"` // Set while soft-deleted
+}
+
+// Deleted reports whether the key is soft-deleted
+func (k *APIKey) Deleted() bool {
+	return k.DeletedAt != nil
+}
+
+// APIKeyStore manages API keys in memory. Once persisted, changes are written
+// through to a shared store and changes made by other replicas are picked up
+// periodically; usage counts and per-key rate limits stay per replica.
 type APIKeyStore struct {
 	keys       map[string]*APIKey
 	mu         sync.RWMutex
 	rateLimits map[string][]time.Time // key -> timestamps of requests
 	rateMu     sync.RWMutex
+	retention  time.Duration // How long soft-deleted keys can be restored
+	store      storage.Store // Set once persisted
 }
 
-// NewAPIKeyStore creates a new API key store
-func NewAPIKeyStore() *APIKeyStore {
+// apiKeyPrefix namespaces API keys in a shared store
+const apiKeyPrefix = "apikey:"
+
+// storeTimeout bounds each write to the shared store
+const storeTimeout = 5 * time.Second
+
+// NewAPIKeyStore creates a new API key store that permanently removes
+// deleted keys once the retention window has passed
+func NewAPIKeyStore(retention time.Duration) *APIKeyStore {
 	store := &APIKeyStore{
 		keys:       make(map[string]*APIKey),
 		rateLimits: make(map[string][]time.Time),
+		retention:  retention,
 	}
 
-	fmt.Println(store.keys)
 	// Start cleanup routine for expired keys and rate limits
 	go store.cleanupRoutine()
 
@@ -44,7 +71,7 @@ func NewAPIKeyStore() *APIKeyStore {
 }
 
 // GenerateAPIKey generates a new API key
-func (s *APIKeyStore) GenerateAPIKey(name, userID string, roles []string, rateLimit int, expiresIn time.Duration) (*APIKey, error) {
+func (s *APIKeyStore) GenerateAPIKey(name, userID string, roles []string, rateLimit int, plan string, products []string, expiresIn time.Duration) (*APIKey, error) {
 	keyBytes := make([]byte, 32)
 	if _, err := rand.Read(keyBytes); err != nil {
 		return nil, fmt.Errorf("failed to generate random key: %w", err)
@@ -56,11 +83,21 @@ func (s *APIKeyStore) GenerateAPIKey(name, userID string, roles []string, rateLi
 		UserID:    userID,
 		Roles:     roles,
 		RateLimit: rateLimit,
+		Plan:      plan,
+		Products:  products,
 		IsActive:  true,
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(expiresIn),
 	}
 
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := s.save(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
 	s.mu.Lock()
 	s.keys[key.Key] = key
 	s.mu.Unlock()
@@ -74,7 +111,7 @@ func (s *APIKeyStore) ValidateAPIKey(key string) (*APIKey, error) {
 	apiKey, exists := s.keys[key]
 	s.mu.RUnlock()
 
-	if !exists {
+	if !exists || apiKey.Deleted() {
 		return nil, fmt.Errorf("invalid API key")
 	}
 
@@ -93,9 +130,10 @@ func (s *APIKeyStore) ValidateAPIKey(key string) (*APIKey, error) {
 		}
 	}
 
-	// Update last used time
+	// Update usage
 	s.mu.Lock()
 	apiKey.LastUsedAt = time.Now()
+	apiKey.Requests++
 	s.mu.Unlock()
 
 	return apiKey, nil
@@ -146,7 +184,22 @@ func (s *APIKeyStore) ListAPIKeys(userID string) []*APIKey {
 
 	var userKeys []*APIKey
 	for _, key := range s.keys {
-		if key.UserID == userID {
+		if key.UserID == userID && !key.Deleted() {
+			userKeys = append(userKeys, key)
+		}
+	}
+
+	return userKeys
+}
+
+// ListDeletedAPIKeys returns the soft-deleted API keys of a user that can still be restored
+func (s *APIKeyStore) ListDeletedAPIKeys(userID string) []*APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var userKeys []*APIKey
+	for _, key := range s.keys {
+		if key.UserID == userID && key.Deleted() {
 			userKeys = append(userKeys, key)
 		}
 	}
@@ -156,35 +209,304 @@ func (s *APIKeyStore) ListAPIKeys(userID string) []*APIKey {
 
 // RevokeAPIKey deactivates an API key
 func (s *APIKeyStore) RevokeAPIKey(key string) error {
+	_, err := s.update(key, func(apiKey *APIKey) error {
+		apiKey.IsActive = false
+		return nil
+	})
+	return err
+}
+
+// ExportAPIKeys returns copies of all keys, including soft-deleted ones
+func (s *APIKeyStore) ExportAPIKeys() []*APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// ImportAPIKey stores a key exported from another gateway, replacing any key
+// with the same value. The key is not kept when the shared store fails.
+func (s *APIKeyStore) ImportAPIKey(key *APIKey) error {
+	copied := *key
+
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := s.save(ctx, &copied); err != nil {
+			return err
+		}
+	}
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.keys[copied.Key] = &copied
+	s.mu.Unlock()
+	return nil
+}
 
-	apiKey, exists := s.keys[key]
-	if !exists {
-		return fmt.Errorf("API key not found")
+// ReplicateAPIKey stores a key changed in another region, replacing any key
+// with the same value. Unlike ImportAPIKey, the usage counted here is kept.
+func (s *APIKeyStore) ReplicateAPIKey(key *APIKey) error {
+	copied := *key
+
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := s.save(ctx, &copied); err != nil {
+			return err
+		}
 	}
 
-	apiKey.IsActive = false
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepUsage(&copied)
+	s.keys[copied.Key] = &copied
 	return nil
 }
 
-// DeleteAPIKey permanently removes an API key
+// PurgeAPIKey removes a key outright, without soft deletion
+func (s *APIKeyStore) PurgeAPIKey(key string) {
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if _, err := s.store.Delete(ctx, apiKeyPrefix+key); err != nil {
+			log.Printf("Failed to purge API key: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.keys, key)
+	s.mu.Unlock()
+}
+
+// SetProducts replaces the API products a key is subscribed to
+func (s *APIKeyStore) SetProducts(key string, products []string) (*APIKey, error) {
+	return s.update(key, func(apiKey *APIKey) error {
+		apiKey.Products = products
+		return nil
+	})
+}
+
+// DeleteAPIKey soft-deletes an API key. The key stops working immediately and
+// can be restored until the retention window has passed.
 func (s *APIKeyStore) DeleteAPIKey(key string) error {
+	_, err := s.update(key, func(apiKey *APIKey) error {
+		if apiKey.Deleted() {
+			return fmt.Errorf("API key is already deleted")
+		}
+		now := time.Now()
+		apiKey.DeletedAt = &now
+		return nil
+	})
+	return err
+}
+
+// RestoreAPIKey undoes the soft deletion of an API key
+func (s *APIKeyStore) RestoreAPIKey(key string) (*APIKey, error) {
+	return s.update(key, func(apiKey *APIKey) error {
+		if !apiKey.Deleted() {
+			return fmt.Errorf("API key is not deleted")
+		}
+		apiKey.DeletedAt = nil
+		return nil
+	})
+}
+
+// update applies a change to a key. Once persisted, the change is made to the
+// stored key under a lock and written back, so concurrent changes on other
+// replicas are not lost.
+func (s *APIKeyStore) update(key string, apply func(apiKey *APIKey) error) (*APIKey, error) {
+	if s.store == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		apiKey, exists := s.keys[key]
+		if !exists {
+			return nil, fmt.Errorf("API key not found")
+		}
+		if err := apply(apiKey); err != nil {
+			return nil, err
+		}
+		return apiKey, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	release, err := storage.Acquire(ctx, s.store, apiKeyPrefix+key, storeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release(ctx)
+
+	// Another replica may have changed the key since the last refresh
+	stored, err := s.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		s.mu.Lock()
+		delete(s.keys, key)
+		s.mu.Unlock()
+		return nil, fmt.Errorf("API key not found")
+	}
+	if err := apply(stored); err != nil {
+		return nil, err
+	}
+	if err := s.save(ctx, stored); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.keepUsage(stored)
+	s.keys[key] = stored
+	return stored, nil
+}
+
+// Persist keeps keys in a shared store, loading the keys already in it and
+// reloading them at the given interval; a zero interval disables reloading
+func (s *APIKeyStore) Persist(store storage.Store, refresh time.Duration) error {
+	s.store = store
 
-	if _, exists := s.keys[key]; !exists {
-		return fmt.Errorf("API key not found")
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := s.reload(ctx); err != nil {
+		return err
 	}
 
-	delete(s.keys, key)
+	if refresh > 0 {
+		go s.refreshRoutine(refresh)
+	}
+
+	return nil
+}
+
+// save writes a key to the shared store, expiring it with the key
+func (s *APIKeyStore) save(ctx context.Context, apiKey *APIKey) error {
+	ttl := time.Until(apiKey.ExpiresAt)
+	if ttl <= 0 {
+		_, err := s.store.Delete(ctx, apiKeyPrefix+apiKey.Key)
+		return err
+	}
+	data, err := json.Marshal(apiKey)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Set(ctx, apiKeyPrefix+apiKey.Key, data, ttl); err != nil {
+		return fmt.Errorf("failed to save API key: %w", err)
+	}
+	return nil
+}
+
+// load reads a key from the shared store, returning nil when it is not there
+func (s *APIKeyStore) load(ctx context.Context, key string) (*APIKey, error) {
+	data, err := s.store.Get(ctx, apiKeyPrefix+key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key: %w", err)
+	}
+	var apiKey APIKey
+	if err := json.Unmarshal(data, &apiKey); err != nil {
+		return nil, fmt.Errorf("failed to decode API key: %w", err)
+	}
+	return &apiKey, nil
+}
+
+// keepUsage carries the usage counted by this replica over to a reloaded
+// key. The caller holds the lock.
+func (s *APIKeyStore) keepUsage(apiKey *APIKey) {
+	if current, exists := s.keys[apiKey.Key]; exists {
+		apiKey.Requests = current.Requests
+		apiKey.LastUsedAt = current.LastUsedAt
+	}
+}
+
+// reload replaces the cached keys with the stored ones
+func (s *APIKeyStore) reload(ctx context.Context) error {
+	started := time.Now()
+	names, err := s.store.Keys(ctx, apiKeyPrefix)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*APIKey, len(names))
+	for _, name := range names {
+		apiKey, err := s.load(ctx, name[len(apiKeyPrefix):])
+		if err != nil {
+			return err
+		}
+		if apiKey != nil {
+			keys[apiKey.Key] = apiKey
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, apiKey := range s.keys {
+		// Keys created while reloading were saved after the listing
+		if _, stored := keys[key]; !stored && !apiKey.CreatedAt.Before(started) {
+			keys[key] = apiKey
+		}
+	}
+	for _, apiKey := range keys {
+		s.keepUsage(apiKey)
+	}
+	s.keys = keys
+	return nil
+}
+
+// refreshRoutine periodically reloads the stored keys
+func (s *APIKeyStore) refreshRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		if err := s.reload(ctx); err != nil {
+			log.Printf("Failed to reload API keys: %v", err)
+		}
+		cancel()
+	}
+}
+
+// purgeDeleted permanently removes keys deleted before the retention window
+func (s *APIKeyStore) purgeDeleted(now time.Time) int {
+	s.mu.Lock()
+	var purged []string
+	for key, apiKey := range s.keys {
+		if apiKey.Deleted() && now.Sub(*apiKey.DeletedAt) >= s.retention {
+			delete(s.keys, key)
+			purged = append(purged, key)
+		}
+	}
+	s.mu.Unlock()
+
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		for _, key := range purged {
+			if _, err := s.store.Delete(ctx, apiKeyPrefix+key); err != nil {
+				log.Printf("Failed to purge API key: %v", err)
+			}
+		}
+		cancel()
+	}
 
 	// Clean up rate limit data
 	s.rateMu.Lock()
-	delete(s.rateLimits, key)
+	for _, key := range purged {
+		delete(s.rateLimits, key)
+	}
 	s.rateMu.Unlock()
 
-	return nil
+	return len(purged)
 }
 
 // cleanupRoutine periodically cleans up expired keys and old rate limit data
@@ -195,6 +517,11 @@ func (s *APIKeyStore) cleanupRoutine() {
 	for range ticker.C {
 		now := time.Now()
 
+		// Permanently remove soft-deleted keys past the retention window
+		if purged := s.purgeDeleted(now); purged > 0 {
+			log.Printf("Purged %d deleted API keys after %s retention", purged, s.retention)
+		}
+
 		// Clean up expired keys
 		s.mu.Lock()
 		for key, apiKey := range s.keys {
@@ -231,9 +558,14 @@ func (s *APIKeyStore) GetStats() map[string]interface{} {
 
 	activeKeys := 0
 	expiredKeys := 0
+	deletedKeys := 0
 	now := time.Now()
 
 	for _, key := range s.keys {
+		if key.Deleted() {
+			deletedKeys++
+			continue
+		}
 		if key.IsActive {
 			if now.After(key.ExpiresAt) {
 				expiredKeys++
@@ -247,6 +579,7 @@ func (s *APIKeyStore) GetStats() map[string]interface{} {
 		"total_keys":    len(s.keys),
 		"active_keys":   activeKeys,
 		"expired_keys":  expiredKeys,
-		"inactive_keys": len(s.keys) - activeKeys - expiredKeys,
+		"deleted_keys":  deletedKeys,
+		"inactive_keys": len(s.keys) - activeKeys - expiredKeys - deletedKeys,
 	}
 }
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+)
+
+// CertificateResolver maps verified client certificates onto identities
+type CertificateResolver interface {
+	// ResolveCertificate returns the identity a certificate belongs to,
+	// failing for certificates that are revoked or unknown
+	ResolveCertificate(cert *x509.Certificate) (*UserContext, error)
+}
+
+// authenticateCertificate attempts to authenticate using the client
+// certificate verified by the TLS handshake
+func authenticateCertificate(r *http.Request, hooks *Hooks) (*UserContext, error) {
+	resolver := hooks.certificates()
+	if resolver == nil {
+		return nil, errors.New("client certificates are not accepted")
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, errors.New("no verified client certificate")
+	}
+
+	userCtx, err := resolver.ResolveCertificate(r.TLS.VerifiedChains[0][0])
+	if err != nil {
+		return nil, err
+	}
+	if err := hooks.complete(userCtx); err != nil {
+		return nil, err
+	}
+	return userCtx, nil
+}
@@ -0,0 +1,9 @@
+package auth
+
+// Directory maps authenticated users onto accounts provisioned by an
+// identity provider
+type Directory interface {
+	// Resolve fills in the user's provisioned account, failing when the
+	// account is deactivated or required but missing
+	Resolve(userCtx *UserContext) error
+}
@@ -0,0 +1,17 @@
+package auth
+
+// GroupResolver resolves the groups a user belongs to and the roles they grant
+type GroupResolver interface {
+	GroupsOf(userID string) (groups []string, roles []string)
+}
+
+// MergeValues appends the values missing from base, returning a new slice
+func MergeValues(base, values []string) []string {
+	merged := append([]string(nil), base...)
+	for _, value := range values {
+		if !contains(merged, value) {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,52 @@
+package auth
+
+// Hooks connect authentication to optional identity components. Each gateway
+// passes its own to the authentication middleware, so gateways in one
+// process never see each other's; a nil Hooks or a nil field disables the
+// hook.
+type Hooks struct {
+	Certificates CertificateResolver // Accepts client certificates where API keys are
+	Directory    Directory           // Checks every user against provisioned accounts
+	Groups       GroupResolver       // Adds the user's groups and group roles
+	Tokens       PersonalTokens      // Accepts personal access tokens where API keys are
+	Authorizer   Authorizer          // Answers Require and UserContext.Can; defaults to admin holding every permission
+}
+
+// defaultAuthorizer answers permission checks without a configured authorizer
+var defaultAuthorizer Authorizer = RolePermissions{"admin": {"*"}}
+
+// complete resolves an authenticated user against the directory, adds their
+// groups and binds the authorizer their permissions are checked with
+func (h *Hooks) complete(userCtx *UserContext) error {
+	if h == nil {
+		return nil
+	}
+	if h.Directory != nil {
+		if err := h.Directory.Resolve(userCtx); err != nil {
+			return err
+		}
+	}
+	if h.Groups != nil && userCtx.UserID != "" {
+		groups, roles := h.Groups.GroupsOf(userCtx.UserID)
+		userCtx.Groups = MergeValues(userCtx.Groups, groups)
+		userCtx.Roles = MergeValues(userCtx.Roles, roles)
+	}
+	userCtx.authorizer = h.Authorizer
+	return nil
+}
+
+// certificates returns the certificate resolver, if any
+func (h *Hooks) certificates() CertificateResolver {
+	if h == nil {
+		return nil
+	}
+	return h.Certificates
+}
+
+// tokens returns the personal access tokens, if enabled
+func (h *Hooks) tokens() PersonalTokens {
+	if h == nil {
+		return nil
+	}
+	return h.Tokens
+}
@@ -1,17 +1,25 @@
 package auth
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
+	"api-gateway/httputil"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenValidator validates bearer tokens
+type TokenValidator interface {
+	ValidateToken(tokenString string) (*Claims, error)
+}
+
 // JWTManager handles JWT operations
 type JWTManager struct {
 	secret   []byte
+	keys     *JWKS // Public keys of an external issuer; nil for HMAC-signed tokens
 	issuer   string
 	audience string
 	expiry   time.Duration
@@ -23,7 +31,26 @@ type Claims struct {
 	Username string   `json:"username"`
 	Email    string   `json:"email"`
 	Roles    []string `json:"roles"`
+	Groups   []string `json:"groups,omitempty"` // Set when group roles are resolved at issuance
+	Actor    *Actor   `json:"act,omitempty"`    // Set on impersonation tokens
 	jwt.RegisteredClaims
+
+	Raw map[string]interface{} `json:"-"` // Every claim of a parsed token, including custom ones
+}
+
+// UnmarshalJSON decodes the claims, keeping every claim in Raw
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type claims Claims
+	if err := json.Unmarshal(data, (*claims)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Raw)
+}
+
+// Actor identifies who is acting on behalf of the token's subject (RFC 8693 "act" claim)
+type Actor struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username,omitempty"`
 }
 
 // NewJWTManager creates a new JWT manager
@@ -36,22 +63,76 @@ func NewJWTManager(secret, issuer, audience string, expiry time.Duration) *JWTMa
 	}
 }
 
+// NewJWKSManager creates a JWT manager that validates tokens signed with an
+// external issuer's published RSA or ECDSA keys
+func NewJWKSManager(keys *JWKS, issuer, audience string) *JWTManager {
+	return &JWTManager{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// Issuer returns the issuer the manager accepts
+func (jm *JWTManager) Issuer() string {
+	return jm.issuer
+}
+
+// TrustsIssuer reports whether tokens of an issuer are accepted
+func (jm *JWTManager) TrustsIssuer(issuer string) bool {
+	return issuer == jm.issuer
+}
+
+// WithAudience returns a copy of the manager accepting a different audience
+func (jm *JWTManager) WithAudience(audience string) *JWTManager {
+	copied := *jm
+	copied.audience = audience
+	return &copied
+}
+
 // GenerateToken creates a new JWT token for the given user
 func (jm *JWTManager) GenerateToken(userID, username, email string, roles []string) (string, error) {
-	now := time.Now()
-	claims := &Claims{
+	return jm.GenerateTokenWithGroups(userID, username, email, roles, nil)
+}
+
+// GenerateTokenWithGroups creates a new JWT token that also lists the user's groups
+func (jm *JWTManager) GenerateTokenWithGroups(userID, username, email string, roles, groups []string) (string, error) {
+	return jm.generate(&Claims{
 		UserID:   userID,
 		Username: username,
 		Email:    email,
 		Roles:    roles,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    jm.issuer,
-			Audience:  []string{jm.audience},
-			Subject:   userID,
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(jm.expiry)),
-			NotBefore: jwt.NewNumericDate(now),
-		},
+		Groups:   groups,
+	}, jm.expiry)
+}
+
+// GenerateImpersonationToken creates a token for the given user that records
+// the actor in its "act" claim and expires after lifetime
+func (jm *JWTManager) GenerateImpersonationToken(userID, username, email string, roles, groups []string, actor *Actor, lifetime time.Duration) (string, error) {
+	return jm.generate(&Claims{
+		UserID:   userID,
+		Username: username,
+		Email:    email,
+		Roles:    roles,
+		Groups:   groups,
+		Actor:    actor,
+	}, lifetime)
+}
+
+// generate signs claims issued now
+func (jm *JWTManager) generate(claims *Claims, lifetime time.Duration) (string, error) {
+	if jm.keys != nil {
+		return "", errors.New("tokens of an external issuer cannot be generated")
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    jm.issuer,
+		Audience:  []string{jm.audience},
+		Subject:   claims.UserID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		NotBefore: jwt.NewNumericDate(now),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -62,6 +143,14 @@ func (jm *JWTManager) GenerateToken(userID, username, email string, roles []stri
 func (jm *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Validate signing method
+		if jm.keys != nil {
+			switch token.Method.(type) {
+			case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+				kid, _ := token.Header["kid"].(string)
+				return jm.keys.Key(kid)
+			}
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
@@ -100,18 +189,52 @@ func (jm *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// TrustedIssuers validates tokens from any of several issuers, choosing the
+// validation rules by the token's "iss" claim
+type TrustedIssuers struct {
+	managers map[string]*JWTManager
+}
+
+// NewTrustedIssuers creates a validator trusting the given issuers
+func NewTrustedIssuers(managers ...*JWTManager) *TrustedIssuers {
+	t := &TrustedIssuers{managers: make(map[string]*JWTManager, len(managers))}
+	for _, manager := range managers {
+		t.managers[manager.issuer] = manager
+	}
+	return t
+}
+
+// ValidateToken validates a token with the manager of its issuer
+func (t *TrustedIssuers) ValidateToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+	manager, ok := t.managers[claims.Issuer]
+	if !ok {
+		return nil, errors.New("untrusted issuer")
+	}
+	return manager.ValidateToken(tokenString)
+}
+
+// TrustsIssuer reports whether tokens of an issuer are accepted
+func (t *TrustedIssuers) TrustsIssuer(issuer string) bool {
+	_, ok := t.managers[issuer]
+	return ok
+}
+
 // ExtractTokenFromHeader extracts JWT token from Authorization header
 func ExtractTokenFromHeader(authHeader string) (string, error) {
 	if authHeader == "" {
 		return "", errors.New("authorization header is required")
 	}
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
+	token, ok := httputil.ParseBearer(authHeader)
+	if !ok {
 		return "", errors.New("authorization header must be in format 'Bearer <token>'")
 	}
 
-	return parts[1], nil
+	return token, nil
 }
 
 // contains checks if a slice contains a string
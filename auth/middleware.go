@@ -3,8 +3,12 @@ package auth
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"api-gateway/httputil"
 )
 
 // AuthType represents the type of authentication
@@ -20,6 +24,7 @@ const (
 type AuthConfig struct {
 	Type     AuthType
 	Required bool
+	Hooks    *Hooks // Optional identity components; nil authenticates JWTs and API keys only
 }
 
 // UserContext represents the authenticated user context
@@ -28,8 +33,22 @@ type UserContext struct {
 	Username string
 	Email    string
 	Roles    []string
-	AuthType string // "jwt" or "apikey"
+	Groups   []string
+	Issuer   string // "iss" claim of the token; empty for API keys
+	AuthType string // "jwt", "apikey", "pat" or "certificate"
 	APIKey   *APIKey
+	Actor    *Actor   // Who is acting as the user when the token is an impersonation token
+	TokenID  string   // ID of the personal access token used
+	Scopes   []string // Permissions a personal access token is limited to; nil when unlimited
+
+	Claims map[string]interface{} // Every claim of the JWT; nil for other credentials
+
+	authorizer Authorizer // Of the hooks that authenticated the user; nil for the default
+}
+
+// Impersonated reports whether someone else is acting as the user
+func (u *UserContext) Impersonated() bool {
+	return u.Actor != nil
 }
 
 // contextKey is a custom type for context keys
@@ -37,17 +56,60 @@ type contextKey string
 
 const userContextKey contextKey = "user"
 
+const identitySlotKey contextKey = "identity_slot"
+
+// identitySlot lets middleware running before authentication observe the
+// identity resolved further down the chain. The slot is its own context
+// node, so adding it to a request takes one allocation.
+type identitySlot struct {
+	context.Context
+	user *UserContext
+
+	// The identity PeekIdentity resolved from the credentials it records,
+	// reused while the request carries the same ones
+	peeked        bool
+	peekedUser    *UserContext
+	authorization string
+	apiKey        string
+}
+
+// Value returns the slot for identitySlotKey and defers other keys to the parent
+func (s *identitySlot) Value(key any) any {
+	if key == identitySlotKey {
+		return s
+	}
+	return s.Context.Value(key)
+}
+
 // AuthMiddleware creates a middleware that supports both JWT and API Key authentication
-func AuthMiddleware(jwtManager *JWTManager, apiKeyStore *APIKeyStore, config AuthConfig) func(http.Handler) http.Handler {
+func AuthMiddleware(jwtManager TokenValidator, apiKeyStore *APIKeyStore, config AuthConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var userCtx *UserContext
 
 			// Try JWT authentication first if required
 			if config.Type == AuthTypeJWT || config.Type == AuthTypeBoth {
-				userCtx, _ = authenticateJWT(r, jwtManager)
+				userCtx, _ = authenticateJWT(r, jwtManager, config.Hooks)
 				if userCtx != nil {
 					userCtx.AuthType = "jwt"
+					if userCtx.Impersonated() {
+						log.Printf("Audit: %s (%s) acting as %s (%s): %s %s",
+							userCtx.Actor.Username, userCtx.Actor.Subject, userCtx.Username, userCtx.UserID, r.Method, r.URL.Path)
+					}
+					recordIdentity(r, userCtx)
+					r = r.WithContext(context.WithValue(r.Context(), userContextKey, userCtx))
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			// Personal access tokens stand in for JWTs only where API keys are
+			// also accepted; JWT-only routes need an interactive login
+			if config.Type == AuthTypeBoth {
+				userCtx, _ = authenticatePersonalToken(r, jwtManager, config.Hooks)
+				if userCtx != nil {
+					userCtx.AuthType = "pat"
+					recordIdentity(r, userCtx)
 					r = r.WithContext(context.WithValue(r.Context(), userContextKey, userCtx))
 					next.ServeHTTP(w, r)
 					return
@@ -56,9 +118,19 @@ func AuthMiddleware(jwtManager *JWTManager, apiKeyStore *APIKeyStore, config Aut
 
 			// Try API Key authentication if JWT failed or if API Key is required
 			if config.Type == AuthTypeAPIKey || config.Type == AuthTypeBoth {
-				userCtx, _ = authenticateAPIKey(r, apiKeyStore)
+				userCtx, _ = authenticateAPIKey(r, apiKeyStore, config.Hooks)
 				if userCtx != nil {
 					userCtx.AuthType = "apikey"
+					recordIdentity(r, userCtx)
+					r = r.WithContext(context.WithValue(r.Context(), userContextKey, userCtx))
+					next.ServeHTTP(w, r)
+					return
+				}
+				// Client certificates are accepted wherever API keys are
+				userCtx, _ = authenticateCertificate(r, config.Hooks)
+				if userCtx != nil {
+					userCtx.AuthType = "certificate"
+					recordIdentity(r, userCtx)
 					r = r.WithContext(context.WithValue(r.Context(), userContextKey, userCtx))
 					next.ServeHTTP(w, r)
 					return
@@ -78,32 +150,46 @@ func AuthMiddleware(jwtManager *JWTManager, apiKeyStore *APIKeyStore, config Aut
 }
 
 // authenticateJWT attempts to authenticate using JWT
-func authenticateJWT(r *http.Request, jwtManager *JWTManager) (*UserContext, error) {
+func authenticateJWT(r *http.Request, jwtManager TokenValidator, hooks *Hooks) (*UserContext, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return nil, fmt.Errorf("no authorization header")
 	}
 
-	if !strings.HasPrefix(authHeader, "Bearer ") {
+	tokenString, ok := httputil.ParseBearer(authHeader)
+	if !ok {
 		return nil, fmt.Errorf("invalid authorization header format")
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	claims, err := jwtManager.ValidateToken(tokenString)
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 
-	return &UserContext{
-		UserID:   claims.UserID,
+	// Tokens from external issuers identify the user by subject only
+	userID := claims.UserID
+	if userID == "" {
+		userID = claims.Subject
+	}
+
+	userCtx := &UserContext{
+		UserID:   userID,
 		Username: claims.Username,
 		Email:    claims.Email,
 		Roles:    claims.Roles,
-	}, nil
+		Groups:   claims.Groups,
+		Issuer:   claims.Issuer,
+		Actor:    claims.Actor,
+		Claims:   claims.Raw,
+	}
+	if err := hooks.complete(userCtx); err != nil {
+		return nil, err
+	}
+	return userCtx, nil
 }
 
 // authenticateAPIKey attempts to authenticate using API Key
-func authenticateAPIKey(r *http.Request, apiKeyStore *APIKeyStore) (*UserContext, error) {
+func authenticateAPIKey(r *http.Request, apiKeyStore *APIKeyStore, hooks *Hooks) (*UserContext, error) {
 	apiKey := r.Header.Get("X-API-Key")
 	if apiKey == "" {
 		return nil, fmt.Errorf("no API key provided")
@@ -114,12 +200,65 @@ func authenticateAPIKey(r *http.Request, apiKeyStore *APIKeyStore) (*UserContext
 		return nil, fmt.Errorf("invalid API key: %w", err)
 	}
 
-	return &UserContext{
+	userCtx := &UserContext{
+		UserID:   key.UserID,
+		Username: key.Name,
+		Roles:    key.Roles,
+		APIKey:   key,
+	}
+	if err := hooks.complete(userCtx); err != nil {
+		return nil, err
+	}
+	return userCtx, nil
+}
+
+// PeekIdentity resolves the caller's identity ahead of authentication middleware
+// without counting API key usage. It returns nil for anonymous or invalid credentials.
+// Requests with an identity slot resolve it once, so callers must pass the same
+// validator, store and hooks, and must not modify the identity.
+func PeekIdentity(r *http.Request, jwtManager TokenValidator, apiKeyStore *APIKeyStore, hooks *Hooks) *UserContext {
+	slot, _ := r.Context().Value(identitySlotKey).(*identitySlot)
+	if slot == nil {
+		return peekIdentity(r, jwtManager, apiKeyStore, hooks)
+	}
+	authorization, apiKey := r.Header.Get("Authorization"), r.Header.Get("X-API-Key")
+	if !slot.peeked || slot.authorization != authorization || slot.apiKey != apiKey {
+		slot.peekedUser = peekIdentity(r, jwtManager, apiKeyStore, hooks)
+		slot.peeked, slot.authorization, slot.apiKey = true, authorization, apiKey
+	}
+	return slot.peekedUser
+}
+
+// peekIdentity resolves the caller's identity from the request's credentials
+func peekIdentity(r *http.Request, jwtManager TokenValidator, apiKeyStore *APIKeyStore, hooks *Hooks) *UserContext {
+	if userCtx, err := authenticateJWT(r, jwtManager, hooks); err == nil {
+		return userCtx
+	}
+	if userCtx, err := authenticatePersonalToken(r, jwtManager, hooks); err == nil {
+		return userCtx
+	}
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		if userCtx, err := authenticateCertificate(r, hooks); err == nil {
+			return userCtx
+		}
+		return nil
+	}
+	key, exists := apiKeyStore.GetAPIKey(apiKey)
+	if !exists || key.Deleted() || !key.IsActive || time.Now().After(key.ExpiresAt) {
+		return nil
+	}
+	userCtx := &UserContext{
 		UserID:   key.UserID,
 		Username: key.Name,
 		Roles:    key.Roles,
 		APIKey:   key,
-	}, nil
+	}
+	if err := hooks.complete(userCtx); err != nil {
+		return nil
+	}
+	return userCtx
 }
 
 // GetUserFromContext extracts user context from request context
@@ -131,6 +270,38 @@ func GetUserFromContext(r *http.Request) *UserContext {
 	return userCtx
 }
 
+// WithUser returns a request authenticated as userCtx, for requests the
+// gateway replays on behalf of a user after the original one has ended
+func WithUser(r *http.Request, userCtx *UserContext) *http.Request {
+	recordIdentity(r, userCtx)
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, userCtx))
+}
+
+// WithIdentitySlot returns a request carrying a slot that authentication
+// middleware fills in, so outer middleware can read the identity afterwards
+func WithIdentitySlot(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(identitySlotKey).(*identitySlot); ok {
+		return r
+	}
+	return r.WithContext(&identitySlot{Context: r.Context()})
+}
+
+// GetResolvedIdentity returns the identity recorded in the request's slot,
+// falling back to the user context when authentication has already run
+func GetResolvedIdentity(r *http.Request) *UserContext {
+	if slot, ok := r.Context().Value(identitySlotKey).(*identitySlot); ok && slot.user != nil {
+		return slot.user
+	}
+	return GetUserFromContext(r)
+}
+
+// recordIdentity stores the authenticated user in the request's slot if present
+func recordIdentity(r *http.Request, userCtx *UserContext) {
+	if slot, ok := r.Context().Value(identitySlotKey).(*identitySlot); ok {
+		slot.user = userCtx
+	}
+}
+
 // RBACMiddleware creates role-based access control middleware
 func RBACMiddleware(requiredRoles ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -141,11 +312,12 @@ func RBACMiddleware(requiredRoles ...string) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Check if user has any of the required roles
+			// Check if user has any of the required roles, and for personal
+			// access tokens a "roles:<role>" scope
 			hasRole := false
 			for _, requiredRole := range requiredRoles {
 				for _, userRole := range userCtx.Roles {
-					if userRole == requiredRole {
+					if userRole == requiredRole && userCtx.scopeAllows("roles", requiredRole) {
 						hasRole = true
 						break
 					}
@@ -166,21 +338,21 @@ func RBACMiddleware(requiredRoles ...string) func(http.Handler) http.Handler {
 }
 
 // RequireJWT creates middleware that requires JWT authentication
-func RequireJWT(jwtManager *JWTManager) func(http.Handler) http.Handler {
-	return AuthMiddleware(jwtManager, nil, AuthConfig{Type: AuthTypeJWT, Required: true})
+func RequireJWT(jwtManager TokenValidator, hooks *Hooks) func(http.Handler) http.Handler {
+	return AuthMiddleware(jwtManager, nil, AuthConfig{Type: AuthTypeJWT, Required: true, Hooks: hooks})
 }
 
 // RequireAPIKey creates middleware that requires API Key authentication
-func RequireAPIKey(apiKeyStore *APIKeyStore) func(http.Handler) http.Handler {
-	return AuthMiddleware(nil, apiKeyStore, AuthConfig{Type: AuthTypeAPIKey, Required: true})
+func RequireAPIKey(apiKeyStore *APIKeyStore, hooks *Hooks) func(http.Handler) http.Handler {
+	return AuthMiddleware(nil, apiKeyStore, AuthConfig{Type: AuthTypeAPIKey, Required: true, Hooks: hooks})
 }
 
 // RequireEither creates middleware that requires either JWT or API Key authentication
-func RequireEither(jwtManager *JWTManager, apiKeyStore *APIKeyStore) func(http.Handler) http.Handler {
-	return AuthMiddleware(jwtManager, apiKeyStore, AuthConfig{Type: AuthTypeBoth, Required: true})
+func RequireEither(jwtManager TokenValidator, apiKeyStore *APIKeyStore, hooks *Hooks) func(http.Handler) http.Handler {
+	return AuthMiddleware(jwtManager, apiKeyStore, AuthConfig{Type: AuthTypeBoth, Required: true, Hooks: hooks})
 }
 
 // OptionalAuth creates middleware that accepts JWT or API Key but doesn't require authentication
-func OptionalAuth(jwtManager *JWTManager, apiKeyStore *APIKeyStore) func(http.Handler) http.Handler {
-	return AuthMiddleware(jwtManager, apiKeyStore, AuthConfig{Type: AuthTypeBoth, Required: false})
+func OptionalAuth(jwtManager TokenValidator, apiKeyStore *APIKeyStore, hooks *Hooks) func(http.Handler) http.Handler {
+	return AuthMiddleware(jwtManager, apiKeyStore, AuthConfig{Type: AuthTypeBoth, Required: false, Hooks: hooks})
 }
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingValidator counts the tokens it validates
+type countingValidator struct {
+	*JWTManager
+	calls int
+}
+
+func (v *countingValidator) ValidateToken(tokenString string) (*Claims, error) {
+	v.calls++
+	return v.JWTManager.ValidateToken(tokenString)
+}
+
+func TestPeekIdentityResolvesOncePerRequest(t *testing.T) {
+	manager := NewJWTManager("secret", "gateway", "clients", time.Hour)
+	validator := &countingValidator{JWTManager: manager}
+	alice, _ := manager.GenerateToken("alice", "alice", "alice@example.com", []string{"user"})
+	bob, _ := manager.GenerateToken("bob", "bob", "bob@example.com", []string{"user"})
+
+	r := WithIdentitySlot(httptest.NewRequest("GET", "/api/orders", nil))
+	r.Header.Set("Authorization", "Bearer "+alice)
+	for i := 0; i < 3; i++ {
+		if userCtx := PeekIdentity(r, validator, nil, nil); userCtx == nil || userCtx.UserID != "alice" {
+			t.Fatalf("peek %d: %+v", i, userCtx)
+		}
+	}
+	if validator.calls != 1 {
+		t.Errorf("token validated %d times, want once", validator.calls)
+	}
+
+	// Middleware replacing the credentials gets the new identity
+	r.Header.Set("Authorization", "Bearer "+bob)
+	if userCtx := PeekIdentity(r, validator, nil, nil); userCtx == nil || userCtx.UserID != "bob" {
+		t.Errorf("peek after the token changed: %+v", userCtx)
+	}
+
+	// Without a slot every call resolves the identity
+	validator.calls = 0
+	plain := httptest.NewRequest("GET", "/api/orders", nil)
+	plain.Header.Set("Authorization", "Bearer "+alice)
+	PeekIdentity(plain, validator, nil, nil)
+	PeekIdentity(plain, validator, nil, nil)
+	if validator.calls != 2 {
+		t.Errorf("token validated %d times without a slot, want twice", validator.calls)
+	}
+}
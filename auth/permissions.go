@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Authorizer decides whether a user may perform an action on a resource.
+// Permissions are written "resource:action", such as "apikeys:revoke".
+type Authorizer interface {
+	Authorize(ctx context.Context, user *UserContext, resource, action string) (bool, error)
+}
+
+// RolePermissions grants permissions to roles. Patterns are a permission,
+// "resource:*" for every action on a resource, or "*" for everything.
+type RolePermissions map[string][]string
+
+// Authorize implements Authorizer. A role named after the permission itself,
+// such as "pii:read", also grants it.
+func (p RolePermissions) Authorize(ctx context.Context, user *UserContext, resource, action string) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	for _, role := range user.Roles {
+		if permissionMatches(role, resource, action) {
+			return true, nil
+		}
+		for _, pattern := range p[role] {
+			if permissionMatches(pattern, resource, action) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// permissionMatches reports whether a permission pattern covers an action on a resource
+func permissionMatches(pattern, resource, action string) bool {
+	if pattern == "*" {
+		return true
+	}
+	patternResource, patternAction, ok := strings.Cut(pattern, ":")
+	return ok && patternResource == resource && (patternAction == "*" || patternAction == action)
+}
+
+// Can reports whether the user may perform an action on a resource. Errors
+// from the authorizer are logged and deny the action.
+func (u *UserContext) Can(action, resource string) bool {
+	allowed, err := u.CanContext(context.Background(), action, resource)
+	if err != nil {
+		log.Printf("Permission check %s:%s failed: %v", resource, action, err)
+		return false
+	}
+	return allowed
+}
+
+// CanContext is Can with a context, returning authorizer errors. The
+// authorizer is the one of the hooks that authenticated the user. Personal
+// access tokens must also hold a scope covering the action.
+func (u *UserContext) CanContext(ctx context.Context, action, resource string) (bool, error) {
+	if !u.scopeAllows(resource, action) {
+		return false, nil
+	}
+	authorizer := u.authorizer
+	if authorizer == nil {
+		authorizer = defaultAuthorizer
+	}
+	return authorizer.Authorize(ctx, u, resource, action)
+}
+
+// Require creates middleware that allows only users holding a permission
+// written "resource:action". It must run after authentication.
+func Require(permission string) func(http.Handler) http.Handler {
+	resource, action, _ := strings.Cut(permission, ":")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userCtx := GetUserFromContext(r)
+			if userCtx == nil {
+				http.Error(w, `{"error":"Authentication required","details":"User context not found"}`, http.StatusUnauthorized)
+				return
+			}
+
+			allowed, err := userCtx.CanContext(r.Context(), action, resource)
+			if err != nil {
+				log.Printf("Permission check %s failed: %v", permission, err)
+				http.Error(w, `{"error":"Authorization unavailable","details":"Permission could not be checked"}`, http.StatusServiceUnavailable)
+				return
+			}
+			if !allowed {
+				http.Error(w, `{"error":"Insufficient permissions","details":"Required permission: `+permission+`"}`, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// User is an account that can log in
+type User struct {
+	ID       string
+	Username string
+	Email    string
+	Roles    []string
+}
+
+// UserStore verifies passwords and looks up users
+type UserStore interface {
+	// Authenticate returns the user when the password is correct, or ErrInvalidCredentials
+	Authenticate(ctx context.Context, username, password string) (*User, error)
+	// Lookup returns a user without verifying a password, or ErrUserNotFound
+	Lookup(ctx context.Context, username string) (*User, error)
+}
+
+var (
+	// ErrInvalidCredentials is returned for unknown users and wrong passwords alike
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNotFound is returned when looking up a user that does not exist
+	ErrUserNotFound = errors.New("user not found")
+)
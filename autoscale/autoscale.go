@@ -0,0 +1,246 @@
+package autoscale
+
+import (
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gwmetrics "api-gateway/metrics"
+)
+
+// maxSamples bounds the latencies kept for the percentile; under heavy
+// traffic the percentile covers the most recent requests of the window
+const maxSamples = 8192
+
+// Config represents autoscaling signal configuration. Targets are the
+// per-replica load each signal should stay under; zero disables a signal.
+type Config struct {
+	TargetInFlight   int
+	TargetQueueDepth int
+	TargetP99        time.Duration
+	TargetCPU        float64
+	Window           time.Duration
+	SampleInterval   time.Duration
+	// QueueDepth reports requests waiting for a concurrency slot; nil
+	// reports an empty queue
+	QueueDepth func() int
+}
+
+// Status reports the load signals of this replica. Load is the highest
+// signal relative to its target, so an autoscaler targeting an average load
+// of 1 keeps every signal under its target.
+type Status struct {
+	InFlight       int64              `json:"in_flight"`
+	QueueDepth     int                `json:"queue_depth"`
+	LatencyP99     float64            `json:"latency_p99_seconds"`
+	CPU            float64            `json:"cpu"`
+	Requests       int                `json:"requests"` // Requests in the latency window
+	Utilization    map[string]float64 `json:"utilization"`
+	Load           float64            `json:"load"`
+	LimitingSignal string             `json:"limiting_signal,omitempty"`
+	SampledAt      time.Time          `json:"sampled_at"`
+}
+
+// DesiredReplicas returns how many replicas would bring the load to 1,
+// given the current replica count, as the Kubernetes HPA computes it
+func (s Status) DesiredReplicas(current int) int {
+	if current < 1 {
+		current = 1
+	}
+	desired := int(math.Ceil(float64(current) * s.Load))
+	if desired < 1 {
+		return 1
+	}
+	return desired
+}
+
+// sample is one request latency
+type sample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// Tracker measures request load and publishes autoscaling signals
+type Tracker struct {
+	config   *Config
+	inFlight atomic.Int64
+
+	mu      sync.Mutex
+	samples []sample // Ring buffer of recent latencies
+	next    int
+	cpu     float64
+	status  Status
+
+	inFlightGauge    *gwmetrics.GaugeVec
+	queueDepthGauge  *gwmetrics.GaugeVec
+	latencyGauge     *gwmetrics.GaugeVec
+	cpuGauge         *gwmetrics.GaugeVec
+	utilizationGauge *gwmetrics.GaugeVec
+	loadGauge        *gwmetrics.GaugeVec
+}
+
+// NewTracker creates a load tracker and starts sampling load signals
+func NewTracker(config *Config, reg *gwmetrics.Registry) *Tracker {
+	t := &Tracker{
+		config:  config,
+		samples: make([]sample, 0, maxSamples),
+		inFlightGauge: reg.NewGaugeVec("gateway_autoscale_in_flight_requests",
+			"Requests being served by this replica."),
+		queueDepthGauge: reg.NewGaugeVec("gateway_autoscale_queue_depth",
+			"Requests waiting for a concurrency slot on this replica."),
+		latencyGauge: reg.NewGaugeVec("gateway_autoscale_latency_p99_seconds",
+			"99th percentile request latency over the autoscaling window."),
+		cpuGauge: reg.NewGaugeVec("gateway_autoscale_cpu_utilization",
+			"CPU utilization (0-1) of this replica."),
+		utilizationGauge: reg.NewGaugeVec("gateway_autoscale_utilization",
+			"Load signal relative to its per-replica target, by signal.", "signal"),
+		loadGauge: reg.NewGaugeVec("gateway_autoscale_load",
+			"Highest load signal relative to its target; scale out above 1."),
+	}
+	t.status = Status{Utilization: map[string]float64{}, SampledAt: time.Now()}
+
+	go t.sampleRoutine()
+
+	return t
+}
+
+// Middleware returns the HTTP middleware function
+func (t *Tracker) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.inFlight.Add(1)
+			start := time.Now()
+			defer func() {
+				t.inFlight.Add(-1)
+				t.record(start, time.Since(start))
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Status returns the load signals as of the last sample, with the current
+// in-flight and queued requests
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	status.Utilization = make(map[string]float64, len(t.status.Utilization))
+	for signal, value := range t.status.Utilization {
+		status.Utilization[signal] = value
+	}
+	return t.score(status)
+}
+
+// record adds a request latency to the window
+func (t *Tracker) record(at time.Time, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < maxSamples {
+		t.samples = append(t.samples, sample{at: at, duration: duration})
+		return
+	}
+	t.samples[t.next] = sample{at: at, duration: duration}
+	t.next = (t.next + 1) % maxSamples
+}
+
+// score fills in the instantaneous signals and the load from the sampled ones
+func (t *Tracker) score(status Status) Status {
+	status.InFlight = t.inFlight.Load()
+	status.QueueDepth = 0
+	if t.config.QueueDepth != nil {
+		status.QueueDepth = t.config.QueueDepth()
+	}
+
+	ratios := map[string]float64{}
+	if t.config.TargetInFlight > 0 {
+		ratios["in_flight"] = float64(status.InFlight) / float64(t.config.TargetInFlight)
+	}
+	if t.config.TargetQueueDepth > 0 {
+		ratios["queue_depth"] = float64(status.QueueDepth) / float64(t.config.TargetQueueDepth)
+	}
+	if t.config.TargetP99 > 0 {
+		ratios["latency_p99"] = status.LatencyP99 / t.config.TargetP99.Seconds()
+	}
+	if t.config.TargetCPU > 0 {
+		ratios["cpu"] = status.CPU / t.config.TargetCPU
+	}
+
+	status.Utilization = ratios
+	status.Load, status.LimitingSignal = 0, ""
+	for _, signal := range []string{"in_flight", "queue_depth", "latency_p99", "cpu"} {
+		if ratio, ok := ratios[signal]; ok && ratio > status.Load {
+			status.Load, status.LimitingSignal = ratio, signal
+		}
+	}
+	return status
+}
+
+// p99 returns the 99th percentile latency and the number of requests in
+// the window
+func (t *Tracker) p99(now time.Time) (time.Duration, int) {
+	cutoff := now.Add(-t.config.Window)
+	durations := make([]time.Duration, 0, len(t.samples))
+	for _, s := range t.samples {
+		if s.at.After(cutoff) {
+			durations = append(durations, s.duration)
+		}
+	}
+	if len(durations) == 0 {
+		return 0, 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	index := int(math.Ceil(0.99*float64(len(durations)))) - 1
+	return durations[index], len(durations)
+}
+
+// sampleRoutine periodically samples CPU and latency and publishes metrics
+func (t *Tracker) sampleRoutine() {
+	ticker := time.NewTicker(t.config.SampleInterval)
+	defer ticker.Stop()
+
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	lastTotal, lastIdle := samples[0].Value.Float64(), samples[1].Value.Float64()
+
+	for now := range ticker.C {
+		metrics.Read(samples)
+		total, idle := samples[0].Value.Float64(), samples[1].Value.Float64()
+
+		cpu := 0.0
+		if elapsed := total - lastTotal; elapsed > 0 {
+			cpu = math.Max(0, 1-(idle-lastIdle)/elapsed)
+		}
+		lastTotal, lastIdle = total, idle
+
+		t.mu.Lock()
+		latency, requests := t.p99(now)
+		status := t.score(Status{
+			LatencyP99: latency.Seconds(),
+			CPU:        cpu,
+			Requests:   requests,
+			SampledAt:  now,
+		})
+		t.status = status
+		t.mu.Unlock()
+
+		t.inFlightGauge.Set(float64(status.InFlight))
+		t.queueDepthGauge.Set(float64(status.QueueDepth))
+		t.latencyGauge.Set(status.LatencyP99)
+		t.cpuGauge.Set(status.CPU)
+		for signal, ratio := range status.Utilization {
+			t.utilizationGauge.Set(ratio, signal)
+		}
+		t.loadGauge.Set(status.Load)
+	}
+}
@@ -0,0 +1,186 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"api-gateway/metrics"
+	"api-gateway/proxy"
+)
+
+// invalidMessageError rejects messages a broker cannot carry
+type invalidMessageError struct {
+	reason string
+}
+
+func (e *invalidMessageError) Error() string {
+	return e.reason
+}
+
+// Message is one request body published to a topic
+type Message struct {
+	Topic       string
+	Key         string // Partition key, or message group of FIFO queues; optional
+	Body        []byte
+	ContentType string
+	DedupeID    string // Lets brokers that support it drop retried publishes; optional
+}
+
+// Receipt is the broker's acknowledgement of a published message
+type Receipt struct {
+	Broker    string `json:"broker"`
+	Topic     string `json:"topic"`
+	Partition *int   `json:"partition,omitempty"`  // Kafka
+	Offset    *int64 `json:"offset,omitempty"`     // Kafka
+	MessageID string `json:"message_id,omitempty"` // SQS
+	Stream    string `json:"stream,omitempty"`     // NATS JetStream
+	Sequence  string `json:"sequence,omitempty"`   // NATS JetStream, SQS FIFO queues
+}
+
+// Publisher publishes messages to a broker
+type Publisher interface {
+	Publish(ctx context.Context, message *Message) (*Receipt, error)
+}
+
+// Route represents the topics a broker route publishes to
+type Route struct {
+	Name        string
+	Topic       string            // For paths no entry of Topics matches; empty rejects them
+	Topics      map[string]string // Path prefix -> topic; the longest match wins
+	KeyHeader   string            // Request header carrying the message key
+	Schema      *proxy.OpenAPIDocument
+	MaxBodySize int64
+	Timeout     time.Duration
+}
+
+// Metrics counts published messages for every bridge
+type Metrics struct {
+	publishes *metrics.CounterVec
+}
+
+// NewMetrics creates and registers the bridge metrics
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		publishes: reg.NewCounterVec("gateway_broker_publishes_total",
+			"Requests published to message brokers, by route and result (published, invalid, unrouted or failed).", "route", "result"),
+	}
+}
+
+// Bridge serves a route by publishing request bodies to a message broker
+// instead of forwarding them to an HTTP upstream. The path picks the topic,
+// the body is checked against the route's OpenAPI document when it has one,
+// and the caller gets the broker's receipt once the message is accepted.
+type Bridge struct {
+	route     *Route
+	publisher Publisher
+	prefixes  []string // Keys of route.Topics, longest first
+	metrics   *Metrics
+}
+
+// NewBridge creates a bridge publishing with publisher
+func NewBridge(route *Route, publisher Publisher, m *Metrics) *Bridge {
+	prefixes := make([]string, 0, len(route.Topics))
+	for prefix := range route.Topics {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	return &Bridge{
+		route:     route,
+		publisher: publisher,
+		prefixes:  prefixes,
+		metrics:   m,
+	}
+}
+
+// ServeHTTP publishes the request body
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, `{"error":"Method not allowed","details":"Messages are published with POST"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	topic := b.topic(r.URL.Path)
+	if topic == "" {
+		b.metrics.publishes.Inc(b.route.Name, "unrouted")
+		http.Error(w, `{"error":"Not found","details":"No topic is mapped to this path"}`, http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, b.route.MaxBodySize+1))
+	if err != nil {
+		http.Error(w, `{"error":"Bad request","details":"Failed to read request body"}`, http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > b.route.MaxBodySize {
+		b.metrics.publishes.Inc(b.route.Name, "invalid")
+		http.Error(w, `{"error":"Request entity too large","details":"Message is too large"}`, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if b.route.Schema != nil {
+		violations, err := b.route.Schema.ValidateRequest(r.URL.Path, r.Method, body)
+		if err == nil && len(violations) > 0 {
+			err = errors.New(strings.Join(violations, "; "))
+		}
+		if err != nil {
+			b.metrics.publishes.Inc(b.route.Name, "invalid")
+			writeError(w, http.StatusBadRequest, "Invalid message", err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), b.route.Timeout)
+	defer cancel()
+	receipt, err := b.publisher.Publish(ctx, &Message{
+		Topic:       topic,
+		Key:         r.Header.Get(b.route.KeyHeader),
+		Body:        body,
+		ContentType: r.Header.Get("Content-Type"),
+		DedupeID:    r.Header.Get("Idempotency-Key"),
+	})
+	var invalid *invalidMessageError
+	if errors.As(err, &invalid) {
+		b.metrics.publishes.Inc(b.route.Name, "invalid")
+		writeError(w, http.StatusBadRequest, "Invalid message", err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Broker route %s failed to publish to %s: %v", b.route.Name, topic, err)
+		b.metrics.publishes.Inc(b.route.Name, "failed")
+		http.Error(w, `{"error":"Bad gateway","details":"Failed to publish message"}`, http.StatusBadGateway)
+		return
+	}
+	b.metrics.publishes.Inc(b.route.Name, "published")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// topic returns the topic mapped to a path
+func (b *Bridge) topic(path string) string {
+	for _, prefix := range b.prefixes {
+		if proxy.HasPathPrefix(path, prefix) {
+			return b.route.Topics[prefix]
+		}
+	}
+	return b.route.Topic
+}
+
+// writeError writes a JSON error whose details may contain any text
+func writeError(w http.ResponseWriter, code int, message, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message, "details": details})
+}
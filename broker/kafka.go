@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KafkaPublisher produces messages to Kafka topics through the REST Proxy v2
+// API. JSON bodies are produced as JSON records, others as binary records.
+type KafkaPublisher struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewKafkaPublisher creates a Kafka publisher posting to the REST proxy at
+// baseURL
+func NewKafkaPublisher(baseURL, username, password string) *KafkaPublisher {
+	return &KafkaPublisher{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		username: username,
+		password: password,
+		client:   &http.Client{},
+	}
+}
+
+// Publish implements Publisher
+func (p *KafkaPublisher) Publish(ctx context.Context, message *Message) (*Receipt, error) {
+	contentType := "application/vnd.kafka.binary.v2+json"
+	record := map[string]interface{}{
+		"value": base64.StdEncoding.EncodeToString(message.Body),
+	}
+	if message.Key != "" {
+		record["key"] = base64.StdEncoding.EncodeToString([]byte(message.Key))
+	}
+	if isJSON(message.ContentType) && json.Valid(message.Body) {
+		contentType = "application/vnd.kafka.json.v2+json"
+		record["value"] = json.RawMessage(message.Body)
+		if message.Key != "" {
+			record["key"] = message.Key
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": []interface{}{record}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	resp, err := post(ctx, p.client, p.baseURL+"/topics/"+url.PathEscape(message.Topic), contentType, body, func(req *http.Request) error {
+		req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+		if p.username != "" {
+			req.SetBasicAuth(p.username, p.password)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The proxy answers 200 even when single records fail
+	var result struct {
+		Offsets []struct {
+			Partition int    `json:"partition"`
+			Offset    int64  `json:"offset"`
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil || len(result.Offsets) == 0 {
+		return nil, fmt.Errorf("unexpected answer from the REST proxy: %s", strings.TrimSpace(string(resp)))
+	}
+	offset := result.Offsets[0]
+	if offset.ErrorCode != nil {
+		return nil, fmt.Errorf("kafka rejected the record: %s", offset.Error)
+	}
+	return &Receipt{
+		Broker:    "kafka",
+		Topic:     message.Topic,
+		Partition: &offset.Partition,
+		Offset:    &offset.Offset,
+	}, nil
+}
+
+// post sends a request built by prepare and returns the response body,
+// failing on non-2xx answers
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, prepare func(*http.Request) error) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := prepare(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s answered %s: %s", url, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// isJSON reports whether a Content-Type header denotes JSON
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
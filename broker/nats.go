@@ -0,0 +1,316 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errRejected marks publishes the server answered with a rejection, which
+// leave the connection usable
+var errRejected = errors.New("rejected")
+
+// natsDialTimeout bounds connecting to the server when the request has no
+// deadline
+const natsDialTimeout = 5 * time.Second
+
+// NATSPublisher publishes messages to NATS subjects over the client protocol.
+// Core NATS publishes are confirmed by a PING round trip, which only shows
+// the server received them; with JetStream, the stream's acknowledgement is
+// awaited and the Idempotency-Key is sent as Nats-Msg-Id, so the stream
+// drops retried publishes. Publishes share one connection, opened on first
+// use and reopened after errors, and are sent one at a time.
+type NATSPublisher struct {
+	address   string
+	useTLS    bool
+	username  string
+	password  string
+	jetStream bool
+
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	headers bool   // The server accepts HPUB
+	inbox   string // Subject prefix of JetStream acknowledgements
+	next    int
+}
+
+// NewNATSPublisher creates a publisher for the server at rawURL, as
+// nats://host:4222 or tls://host:4222. Credentials in the URL are used when
+// username is empty; a username without a password is sent as a token.
+func NewNATSPublisher(rawURL, username, password string, jetStream bool) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q", rawURL)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("NATS URL %q must use nats:// or tls://", rawURL)
+	}
+	if username == "" && u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATSPublisher{
+		address:   address,
+		useTLS:    u.Scheme == "tls",
+		username:  username,
+		password:  password,
+		jetStream: jetStream,
+	}, nil
+}
+
+// Publish implements Publisher
+func (p *NATSPublisher) Publish(ctx context.Context, message *Message) (*Receipt, error) {
+	if message.Topic == "" || strings.ContainsAny(message.Topic, " \t\r\n") {
+		return nil, &invalidMessageError{fmt.Sprintf("invalid NATS subject %q", message.Topic)}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	receipt, err := p.publish(ctx, message)
+	if err != nil && p.conn != nil && !errors.Is(err, errRejected) {
+		// The connection is in an unknown state; the next publish reconnects
+		p.conn.Close()
+		p.conn = nil
+	}
+	return receipt, err
+}
+
+// publish sends the message and waits for its confirmation
+func (p *NATSPublisher) publish(ctx context.Context, message *Message) (*Receipt, error) {
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsDialTimeout)
+	}
+	p.conn.SetDeadline(deadline)
+
+	if !p.jetStream {
+		if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\nPING\r\n", message.Topic, len(message.Body), message.Body); err != nil {
+			return nil, err
+		}
+		if _, _, err := p.await(""); err != nil {
+			return nil, err
+		}
+		return &Receipt{Broker: "nats", Topic: message.Topic}, nil
+	}
+
+	p.next++
+	reply := p.inbox + strconv.Itoa(p.next)
+	var err error
+	if message.DedupeID != "" && p.headers {
+		header := "NATS/1.0\r\nNats-Msg-Id: " + strings.NewReplacer("\r", "", "\n", "").Replace(message.DedupeID) + "\r\n\r\n"
+		_, err = fmt.Fprintf(p.conn, "HPUB %s %s %d %d\r\n%s%s\r\n", message.Topic, reply, len(header), len(header)+len(message.Body), header, message.Body)
+	} else {
+		_, err = fmt.Fprintf(p.conn, "PUB %s %s %d\r\n%s\r\n", message.Topic, reply, len(message.Body), message.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload, status, err := p.await(reply)
+	if err != nil {
+		return nil, err
+	}
+	if status == "503" {
+		return nil, fmt.Errorf("%w: no JetStream stream takes subject %s", errRejected, message.Topic)
+	}
+
+	var ack struct {
+		Stream   string `json:"stream"`
+		Sequence uint64 `json:"seq"`
+		Error    *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return nil, fmt.Errorf("unexpected JetStream acknowledgement: %s", payload)
+	}
+	if ack.Error != nil {
+		return nil, fmt.Errorf("%w by JetStream: %s", errRejected, ack.Error.Description)
+	}
+	return &Receipt{
+		Broker:   "nats",
+		Topic:    message.Topic,
+		Stream:   ack.Stream,
+		Sequence: strconv.FormatUint(ack.Sequence, 10),
+	}, nil
+}
+
+// connect opens the connection, authenticates and, for JetStream,
+// subscribes to the acknowledgement inbox
+func (p *NATSPublisher) connect(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, natsDialTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	deadline, _ := dialCtx.Deadline()
+	conn.SetDeadline(deadline)
+	reader := bufio.NewReader(conn)
+
+	// The server introduces itself first
+	line, err := readLine(reader)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+		Headers     bool `json:"headers"`
+	}
+	if rest, ok := strings.CutPrefix(line, "INFO "); !ok || json.Unmarshal([]byte(rest), &info) != nil {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q", line)
+	}
+	if p.useTLS || info.TLSRequired {
+		host, _, _ := net.SplitHostPort(p.address)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	options := map[string]interface{}{
+		"verbose":       false,
+		"pedantic":      false,
+		"name":          "api-gateway",
+		"lang":          "go",
+		"version":       "1.0.0",
+		"protocol":      1,
+		"headers":       info.Headers,
+		"no_responders": info.Headers,
+	}
+	switch {
+	case p.username != "" && p.password != "":
+		options["user"] = p.username
+		options["pass"] = p.password
+	case p.username != "":
+		options["auth_token"] = p.username
+	}
+	connect, _ := json.Marshal(options)
+	commands := "CONNECT " + string(connect) + "\r\n"
+
+	var inbox string
+	if p.jetStream {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			conn.Close()
+			return err
+		}
+		inbox = "_INBOX." + hex.EncodeToString(b) + "."
+		commands += "SUB " + inbox + "* 1\r\n"
+	}
+	if _, err := conn.Write([]byte(commands + "PING\r\n")); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn, p.reader, p.headers, p.inbox = conn, reader, info.Headers, inbox
+	if _, _, err := p.await(""); err != nil {
+		return fmt.Errorf("NATS connection refused: %w", err)
+	}
+	return nil
+}
+
+// await reads from the server until the PONG answering our PING, or until
+// the message sent to reply when it is set. It returns the message payload
+// with its status header, if any.
+func (p *NATSPublisher) await(reply string) ([]byte, string, error) {
+	for {
+		line, err := readLine(p.reader)
+		if err != nil {
+			return nil, "", err
+		}
+		switch {
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return nil, "", err
+			}
+		case line == "PONG":
+			if reply == "" {
+				return nil, "", nil
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return nil, "", errors.New(strings.Trim(strings.TrimPrefix(line, "-ERR "), "'"))
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			subject, header, payload, err := p.readMessage(line)
+			if err != nil {
+				return nil, "", err
+			}
+			// Acknowledgements of earlier publishes that timed out are dropped
+			if subject == reply && reply != "" {
+				return payload, headerStatus(header), nil
+			}
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+// readMessage reads the payload of a MSG or HMSG, returning its subject,
+// header block and body
+func (p *NATSPublisher) readMessage(line string) (string, string, []byte, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return "", "", nil, fmt.Errorf("malformed NATS message %q", line)
+	}
+	total, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("malformed NATS message %q", line)
+	}
+	headerLength := 0
+	if fields[0] == "HMSG" {
+		if headerLength, err = strconv.Atoi(fields[len(fields)-2]); err != nil || headerLength > total {
+			return "", "", nil, fmt.Errorf("malformed NATS message %q", line)
+		}
+	}
+	data := make([]byte, total+2)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return "", "", nil, err
+	}
+	return fields[1], string(data[:headerLength]), data[headerLength:total], nil
+}
+
+// headerStatus returns the status code of a NATS header block, such as 503 for
+// "no responders"
+func headerStatus(header string) string {
+	first, _, _ := strings.Cut(header, "\r\n")
+	fields := strings.Fields(first)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// readLine reads one protocol line without its CRLF
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
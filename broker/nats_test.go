@@ -0,0 +1,272 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsServer is a fake NATS server speaking enough of the client protocol
+// for the publisher: INFO, CONNECT, PING, SUB, PUB and HPUB. Publishes to
+// "forbidden" get -ERR; with an inbox subscribed, publishes are acknowledged
+// as a JetStream stream would, except to "nostream", which has no responders.
+type natsServer struct {
+	listener net.Listener
+	user     string
+	pass     string
+
+	mu          sync.Mutex
+	connections int
+	connects    []map[string]interface{}
+	published   []natsPublish
+	msgIDs      map[string]int // Nats-Msg-Id to stream sequence
+}
+
+type natsPublish struct {
+	subject, header, body string
+}
+
+func newNATSServer(t *testing.T, user, pass string) *natsServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{listener: listener, user: user, pass: pass, msgIDs: make(map[string]int)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"headers\":true,\"max_payload\":1048576}\r\n")
+	sid := ""
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return
+		}
+		verb, args, _ := strings.Cut(line, " ")
+		fields := strings.Fields(args)
+		switch verb {
+		case "CONNECT":
+			var options map[string]interface{}
+			json.Unmarshal([]byte(args), &options)
+			s.mu.Lock()
+			s.connects = append(s.connects, options)
+			s.mu.Unlock()
+			if s.user != "" && (options["user"] != s.user || options["pass"] != s.pass) {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			// Sent even though the client is not verbose, which it must tolerate
+			fmt.Fprint(conn, "+OK\r\n")
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "SUB":
+			sid = fields[len(fields)-1]
+		case "PUB", "HPUB":
+			headerLength := 0
+			if verb == "HPUB" {
+				headerLength, _ = strconv.Atoi(fields[len(fields)-2])
+			}
+			total, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, total+2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			message := natsPublish{subject: fields[0], header: string(data[:headerLength]), body: string(data[headerLength:total])}
+			reply := ""
+			if len(fields) == 3 && verb == "PUB" || len(fields) == 4 {
+				reply = fields[1]
+			}
+			// Servers ping idle clients; the client must answer mid-publish
+			fmt.Fprint(conn, "PING\r\n")
+			if message.subject == "forbidden" {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish to \"forbidden\"'\r\n")
+				continue
+			}
+			if reply == "" {
+				s.record(message)
+				continue
+			}
+			if sid == "" || !strings.HasPrefix(reply, "_INBOX.") {
+				return
+			}
+			if message.subject == "nostream" {
+				status := "NATS/1.0 503\r\n\r\n"
+				fmt.Fprintf(conn, "HMSG %s %s %d %d\r\n%s\r\n", reply, sid, len(status), len(status), status)
+				continue
+			}
+			ack, _ := json.Marshal(map[string]interface{}{"stream": "ORDERS", "seq": s.record(message)})
+			// An acknowledgement of an earlier publish that timed out comes first
+			fmt.Fprintf(conn, "MSG %s.stale %s 2\r\n{}\r\n", reply, sid)
+			fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", reply, sid, len(ack), ack)
+		}
+	}
+}
+
+// record stores a publish, returning its stream sequence; publishes
+// repeating a Nats-Msg-Id are dropped, as JetStream does
+func (s *natsServer) record(message natsPublish) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, id, _ := strings.Cut(message.header, "Nats-Msg-Id: ")
+	id, _, _ = strings.Cut(id, "\r\n")
+	if seq, ok := s.msgIDs[id]; ok && id != "" {
+		return seq
+	}
+	s.published = append(s.published, message)
+	s.msgIDs[id] = len(s.published)
+	return len(s.published)
+}
+
+func (s *natsServer) state() (int, []map[string]interface{}, []natsPublish) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, append([]map[string]interface{}(nil), s.connects...), append([]natsPublish(nil), s.published...)
+}
+
+func TestNATSPublish(t *testing.T) {
+	server := newNATSServer(t, "gateway", "s3cret")
+	p, err := NewNATSPublisher(strings.Replace(server.url(), "nats://", "nats://gateway:s3cret@", 1), "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, body := range []string{`{"id":1}`, "line one\r\nline two"} {
+		receipt, err := p.Publish(ctx, &Message{Topic: "orders.created", Body: []byte(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.Broker != "nats" || receipt.Topic != "orders.created" {
+			t.Errorf("receipt %+v", receipt)
+		}
+	}
+
+	connections, connects, published := server.state()
+	if connections != 1 {
+		t.Errorf("%d connections, want one shared", connections)
+	}
+	if len(connects) != 1 || connects[0]["user"] != "gateway" || connects[0]["pass"] != "s3cret" || connects[0]["verbose"] != false {
+		t.Errorf("CONNECT %v", connects)
+	}
+	if len(published) != 2 || published[0].body != `{"id":1}` || published[1].body != "line one\r\nline two" {
+		t.Errorf("published %+v", published)
+	}
+}
+
+func TestNATSPublishErrors(t *testing.T) {
+	server := newNATSServer(t, "gateway", "s3cret")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := NewNATSPublisher(server.url(), "gateway", "wrong", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Publish(ctx, &Message{Topic: "orders", Body: []byte("x")}); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("wrong password: err = %v, want the server's -ERR", err)
+	}
+
+	p, err = NewNATSPublisher(server.url(), "gateway", "s3cret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Publish(ctx, &Message{Topic: "forbidden", Body: []byte("x")}); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("forbidden subject: err = %v, want the server's -ERR", err)
+	}
+	// The publisher recovers on a new connection
+	if _, err := p.Publish(ctx, &Message{Topic: "orders", Body: []byte("x")}); err != nil {
+		t.Errorf("publish after -ERR: %v", err)
+	}
+
+	var invalid *invalidMessageError
+	for _, subject := range []string{"", "orders created", "orders\r\nPUB evil 1"} {
+		if _, err := p.Publish(ctx, &Message{Topic: subject, Body: []byte("x")}); !errors.As(err, &invalid) {
+			t.Errorf("subject %q: err = %v, want invalid message", subject, err)
+		}
+	}
+}
+
+func TestNATSPublishToken(t *testing.T) {
+	server := newNATSServer(t, "", "")
+	p, err := NewNATSPublisher(server.url(), "t0ken", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Publish(context.Background(), &Message{Topic: "orders", Body: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, connects, _ := server.state(); len(connects) != 1 || connects[0]["auth_token"] != "t0ken" || connects[0]["user"] != nil {
+		t.Errorf("CONNECT %v, want a token", connects)
+	}
+}
+
+func TestNATSJetStream(t *testing.T) {
+	server := newNATSServer(t, "", "")
+	p, err := NewNATSPublisher(server.url(), "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := p.Publish(ctx, &Message{Topic: "orders", Body: []byte(`{"id":1}`), DedupeID: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Stream != "ORDERS" || first.Sequence != "1" {
+		t.Errorf("receipt %+v", first)
+	}
+	// A retry with the same Idempotency-Key is acknowledged, not stored again
+	retry, err := p.Publish(ctx, &Message{Topic: "orders", Body: []byte(`{"id":1}`), DedupeID: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.Sequence != "1" {
+		t.Errorf("retry stored as sequence %s", retry.Sequence)
+	}
+	if _, err := p.Publish(ctx, &Message{Topic: "orders", Body: []byte(`{"id":2}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Publish(ctx, &Message{Topic: "nostream", Body: []byte("x")}); !errors.Is(err, errRejected) {
+		t.Errorf("no responders: err = %v, want rejected", err)
+	}
+	connections, _, published := server.state()
+	if connections != 1 {
+		t.Errorf("%d connections; a rejection must keep the connection", connections)
+	}
+	if len(published) != 2 || !strings.Contains(published[0].header, "Nats-Msg-Id: key-1\r\n") || published[1].header != "" {
+		t.Errorf("published %+v", published)
+	}
+}
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"api-gateway/proxy"
+)
+
+// SQSPublisher sends messages to Amazon SQS queues through the JSON API.
+// Topics are queue URLs, or queue paths ("<account>/<queue>") under the
+// endpoint.
+type SQSPublisher struct {
+	endpoint string
+	signer   *proxy.SigV4Signer
+	client   *http.Client
+}
+
+// NewSQSPublisher creates an SQS publisher signing requests with signer
+func NewSQSPublisher(endpoint string, signer *proxy.SigV4Signer) *SQSPublisher {
+	return &SQSPublisher{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		signer:   signer,
+		client:   &http.Client{},
+	}
+}
+
+// Publish implements Publisher. FIFO queues get the message key as message
+// group and the Idempotency-Key as deduplication ID.
+func (p *SQSPublisher) Publish(ctx context.Context, message *Message) (*Receipt, error) {
+	// SQS only carries text
+	if !utf8.Valid(message.Body) {
+		return nil, &invalidMessageError{"SQS messages must be UTF-8 text"}
+	}
+	queueURL := message.Topic
+	if !strings.Contains(queueURL, "://") {
+		queueURL = p.endpoint + "/" + strings.TrimLeft(queueURL, "/")
+	}
+	input := map[string]string{
+		"QueueUrl":    queueURL,
+		"MessageBody": string(message.Body),
+	}
+	if strings.HasSuffix(queueURL, ".fifo") {
+		if message.Key == "" {
+			return nil, &invalidMessageError{"FIFO queues require a message key"}
+		}
+		input["MessageGroupId"] = message.Key
+		if message.DedupeID != "" {
+			input["MessageDeduplicationId"] = message.DedupeID
+		}
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	resp, err := post(ctx, p.client, p.endpoint+"/", "application/x-amz-json-1.0", body, func(req *http.Request) error {
+		req.Header.Set("X-Amz-Target", "AmazonSQS.SendMessage")
+		return p.signer.ApplyPayload(req, body)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		MessageID      string `json:"MessageId"`
+		SequenceNumber string `json:"SequenceNumber"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil || result.MessageID == "" {
+		return nil, fmt.Errorf("unexpected answer from SQS: %s", strings.TrimSpace(string(resp)))
+	}
+	return &Receipt{
+		Broker:    "sqs",
+		Topic:     message.Topic,
+		MessageID: result.MessageID,
+		Sequence:  result.SequenceNumber,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/proxy"
+)
+
+func TestSQSPublish(t *testing.T) {
+	var input map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Target") != "AmazonSQS.SendMessage" ||
+			r.Header.Get("Content-Type") != "application/x-amz-json-1.0" ||
+			r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sqs/aws4_request") {
+			http.Error(w, `{"__type":"InvalidSignature"}`, http.StatusForbidden)
+			return
+		}
+		json.Unmarshal(body, &input)
+		w.Write([]byte(`{"MessageId":"5fea7756-0ea4-451a-a703-a558b933e274","SequenceNumber":"18849496460467696128"}`))
+	}))
+	defer server.Close()
+
+	p := NewSQSPublisher(server.URL+"/", &proxy.SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "eu-west-1",
+		Service:         "sqs",
+	})
+	ctx := context.Background()
+
+	receipt, err := p.Publish(ctx, &Message{Topic: "123456789012/orders.fifo", Key: "customer-7", Body: []byte(`{"id":1}`), DedupeID: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.MessageID != "5fea7756-0ea4-451a-a703-a558b933e274" || receipt.Sequence != "18849496460467696128" {
+		t.Errorf("receipt %+v", receipt)
+	}
+	if input["QueueUrl"] != server.URL+"/123456789012/orders.fifo" || input["MessageBody"] != `{"id":1}` ||
+		input["MessageGroupId"] != "customer-7" || input["MessageDeduplicationId"] != "key-1" {
+		t.Errorf("SendMessage input %v", input)
+	}
+
+	var invalid *invalidMessageError
+	if _, err := p.Publish(ctx, &Message{Topic: "123456789012/orders.fifo", Body: []byte("x")}); !errors.As(err, &invalid) {
+		t.Errorf("FIFO without a key: err = %v, want invalid message", err)
+	}
+	if _, err := p.Publish(ctx, &Message{Topic: "123456789012/orders", Body: []byte{0xff, 0xfe}}); !errors.As(err, &invalid) {
+		t.Errorf("binary body: err = %v, want invalid message", err)
+	}
+}
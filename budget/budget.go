@@ -0,0 +1,309 @@
+package budget
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// Route gives the requests matching its path prefixes and methods a budget
+type Route struct {
+	Name    string   // Reported as the budget source, e.g. "slo:checkout"
+	Paths   []string // Route prefixes
+	Methods []string // Empty covers every method
+	Budget  time.Duration
+}
+
+// Config represents latency budget configuration
+type Config struct {
+	Header      string        // Carries the remaining budget in milliseconds
+	Default     time.Duration // Budget of requests no route matches; 0 leaves them unbounded
+	Routes      []*Route      // The longest matching prefix wins; ties go to the smallest budget
+	HonorClient bool          // Let callers lower the budget with the header
+}
+
+// ExceededError is the cause of a request context whose budget ran out
+type ExceededError struct {
+	Budget time.Duration
+	Source string
+}
+
+// Error implements error
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("Latency budget of %s exceeded", e.Budget)
+}
+
+// budgetKey is the context key of a request's budget
+type budgetKey struct{}
+
+// state is the budget of one request
+type state struct {
+	header    string
+	budget    time.Duration
+	source    string
+	start     time.Time
+	deadline  time.Time
+	forwarded atomic.Int64 // Budget in milliseconds last passed to an upstream; -1 if none
+}
+
+// Diagnostics describes a request aborted for exceeding its budget
+type Diagnostics struct {
+	Error             string `json:"error"`
+	Details           string `json:"details"`
+	BudgetMs          int64  `json:"budget_ms"`
+	ElapsedMs         int64  `json:"elapsed_ms"`
+	Source            string `json:"source"`                        // Route, SLO, client or default
+	UpstreamBudgetMs  *int64 `json:"upstream_budget_ms,omitempty"`  // Budget the upstream was given
+	UpstreamElapsedMs *int64 `json:"upstream_elapsed_ms,omitempty"` // Time since the request was forwarded
+}
+
+// Enforcer gives requests a latency budget and aborts them once it runs out
+type Enforcer struct {
+	config *Config
+
+	exceeded *metrics.CounterVec
+}
+
+// NewEnforcer creates a new latency budget enforcer
+func NewEnforcer(config *Config, reg *metrics.Registry) *Enforcer {
+	return &Enforcer{
+		config: config,
+		exceeded: reg.NewCounterVec("gateway_latency_budget_exceeded_total",
+			"Requests aborted for exceeding their latency budget, by budget source and whether an upstream was waited on.", "source", "upstream"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (e *Enforcer) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			budget, source := e.budget(r)
+			if !e.config.HonorClient {
+				r.Header.Del(e.config.Header)
+			}
+			if budget <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			s := &state{
+				header:   e.config.Header,
+				budget:   budget,
+				source:   source,
+				start:    time.Now(),
+				deadline: time.Now().Add(budget),
+			}
+			s.forwarded.Store(-1)
+			ctx, cancel := context.WithDeadlineCause(r.Context(), s.deadline, &ExceededError{Budget: budget, Source: source})
+			defer cancel()
+			r = r.WithContext(context.WithValue(ctx, budgetKey{}, s))
+
+			gw := &guardedWriter{ResponseWriter: w, ctx: ctx, state: s, exceeded: e.exceeded}
+			stop := context.AfterFunc(ctx, gw.expire)
+			defer func() {
+				stop()
+				gw.mu.Lock()
+				gw.done = true
+				gw.mu.Unlock()
+			}()
+
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// budget returns the budget of a request and where it came from
+func (e *Enforcer) budget(r *http.Request) (time.Duration, string) {
+	budget, source := e.config.Default, "default"
+	longest := -1
+	for _, route := range e.config.Routes {
+		if !matchesMethod(route.Methods, r.Method) {
+			continue
+		}
+		for _, path := range route.Paths {
+			if !strings.HasPrefix(r.URL.Path, path) {
+				continue
+			}
+			if len(path) > longest || (len(path) == longest && route.Budget < budget) {
+				longest = len(path)
+				budget, source = route.Budget, route.Name
+			}
+		}
+	}
+
+	if e.config.HonorClient {
+		if ms, err := strconv.ParseInt(r.Header.Get(e.config.Header), 10, 64); err == nil && ms > 0 {
+			if client := time.Duration(ms) * time.Millisecond; budget <= 0 || client < budget {
+				budget, source = client, "client"
+			}
+		}
+	}
+	return budget, source
+}
+
+// matchesMethod reports whether a method is covered
+func matchesMethod(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeader passes the remaining budget of a request to an upstream. It is a
+// no-op for requests without a budget.
+func SetHeader(ctx context.Context, header http.Header) {
+	s, ok := ctx.Value(budgetKey{}).(*state)
+	if !ok {
+		return
+	}
+	remaining := time.Until(s.deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+	s.forwarded.Store(remaining)
+	header.Set(s.header, strconv.FormatInt(remaining, 10))
+}
+
+// Remaining returns the budget left for a request, and false for requests
+// without a budget
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	s, ok := ctx.Value(budgetKey{}).(*state)
+	if !ok {
+		return 0, false
+	}
+	return time.Until(s.deadline), true
+}
+
+// guardedWriter answers with diagnostics once the budget runs out, unless a
+// response has been started already, and discards what the handler writes
+// afterwards
+type guardedWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	state    *state
+	exceeded *metrics.CounterVec
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+	done        bool // The handler returned; the response may no longer be written
+}
+
+// expire runs when the request context ends
+func (gw *guardedWriter) expire() {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.abortLocked()
+}
+
+// abortLocked writes the diagnostics if the budget ran out before the
+// response started
+func (gw *guardedWriter) abortLocked() {
+	if gw.done || gw.wroteHeader || gw.timedOut {
+		return
+	}
+	exceeded, ok := context.Cause(gw.ctx).(*ExceededError)
+	if !ok {
+		// Canceled by the client or another deadline
+		return
+	}
+	gw.timedOut = true
+
+	s := gw.state
+	elapsed := time.Since(s.start)
+	diagnostics := Diagnostics{
+		Error:     "Gateway Timeout",
+		Details:   fmt.Sprintf("%s after %s", exceeded.Error(), elapsed.Truncate(time.Millisecond)),
+		BudgetMs:  s.budget.Milliseconds(),
+		ElapsedMs: elapsed.Milliseconds(),
+		Source:    s.source,
+	}
+	upstream := "false"
+	if forwarded := s.forwarded.Load(); forwarded >= 0 {
+		upstreamElapsed := forwarded - time.Until(s.deadline).Milliseconds()
+		diagnostics.UpstreamBudgetMs = &forwarded
+		diagnostics.UpstreamElapsedMs = &upstreamElapsed
+		upstream = "true"
+	}
+	gw.exceeded.Inc(s.source, upstream)
+
+	body, _ := json.Marshal(diagnostics)
+	header := gw.ResponseWriter.Header()
+	header.Del("Content-Encoding")
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(body)+1))
+	gw.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	gw.ResponseWriter.Write(append(body, '\n'))
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// WriteHeader forwards the status unless the budget ran out first
+func (gw *guardedWriter) WriteHeader(code int) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	gw.abortLocked()
+	if gw.timedOut || gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	gw.ResponseWriter.WriteHeader(code)
+}
+
+// Write forwards the body unless the budget ran out first
+func (gw *guardedWriter) Write(b []byte) (int, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	gw.abortLocked()
+	if gw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	gw.wroteHeader = true
+	return gw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (gw *guardedWriter) Flush() {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	if gw.timedOut {
+		return
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		gw.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (gw *guardedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	hijacker, ok := gw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	// Hijacked connections manage their own lifetime
+	gw.done = true
+	return hijacker.Hijack()
+}
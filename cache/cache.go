@@ -0,0 +1,688 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/proxy"
+	"api-gateway/storage"
+)
+
+// Key namespaces in the store
+const (
+	entryPrefix   = "cache:entry:"   // Stored responses, by request hash
+	varyPrefix    = "cache:vary:"    // Request headers a URL's responses vary on, by URL hash
+	tagPrefix     = "cache:tag:"     // Surrogate key index: tag hash + ":" + request hash
+	refreshPrefix = "cache:refresh:" // Held while a stale entry is refreshed, by request hash
+)
+
+// storeTimeout bounds each lookup and write of the store
+const storeTimeout = 2 * time.Second
+
+// refreshTimeout bounds background refreshes of stale entries
+const refreshTimeout = time.Minute
+
+// cacheableStatuses are the response statuses that may be stored
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// Entry is a stored response
+type Entry struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	Tags     []string    `json:"tags,omitempty"`
+	Vary     []string    `json:"vary,omitempty"` // Request headers the response varies on
+	StoredAt time.Time   `json:"stored_at"`
+	Expires  time.Time   `json:"expires"`
+
+	// Once expired, the entry is still served while it is refreshed in the
+	// background until StaleUntil, and instead of upstream errors until
+	// ErrorUntil
+	StaleUntil time.Time `json:"stale_until"`
+	ErrorUntil time.Time `json:"error_until"`
+}
+
+// expiry returns when the entry can no longer be served at all
+func (e *Entry) expiry() time.Time {
+	expiry := e.Expires
+	for _, t := range []time.Time{e.StaleUntil, e.ErrorUntil} {
+		if t.After(expiry) {
+			expiry = t
+		}
+	}
+	return expiry
+}
+
+// lifetime is how long a response may be served from the cache
+type lifetime struct {
+	fresh                time.Duration
+	staleWhileRevalidate time.Duration // Beyond fresh, while a refresh runs
+	staleIfError         time.Duration // Beyond fresh, when the upstream fails
+}
+
+// Config represents response cache configuration
+type Config struct {
+	PathPrefixes []string      // Empty means every route the cache wraps
+	DefaultTTL   time.Duration // For responses without max-age or s-maxage; 0 leaves them uncached
+	MaxTTL       time.Duration
+	MaxBodySize  int
+	TagHeader    string // Response header listing an entry's surrogate keys, removed before responding
+	// Defaults for responses without the stale-while-revalidate and
+	// stale-if-error directives (RFC 5861)
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	Ranges               bool // Answer Range requests from stored responses
+	// Partition returns what else responses to a request depend on, such as
+	// the claim route or experiment variant the gateway picked for it, so
+	// each partition is stored separately; nil when nothing else matters
+	Partition func(r *http.Request) string
+}
+
+// Cache stores upstream GET responses the upstream marks as cacheable, as a
+// shared cache would (RFC 9111): private and no-store responses and responses
+// setting cookies are never stored, and responses to requests with
+// credentials only when marked public or given an s-maxage. Upstreams tag
+// responses with surrogate keys, such as "user:123 catalog", so related
+// entries can be purged together when the data behind them changes.
+//
+// Expired entries can still be served for a while: instantly while a single
+// background request refreshes them, and in place of 5xx responses and
+// timeouts while the upstream is failing.
+//
+// With Ranges, byte ranges of stored responses are served as 206 Partial
+// Content. A range request that misses is passed through, and when its
+// response shows the full object can be stored, the object is fetched once
+// in the background for later ranges.
+type Cache struct {
+	config *Config
+	store  storage.KeyValueStore
+
+	requests *metrics.CounterVec
+	purged   *metrics.CounterVec
+}
+
+// NewCache creates a response cache on a key-value store. With a shared
+// store, such as Redis or SQL, replicas share entries and purges.
+func NewCache(config *Config, store storage.KeyValueStore, reg *metrics.Registry) *Cache {
+	return &Cache{
+		config: config,
+		store:  store,
+		requests: reg.NewCounterVec("gateway_cache_requests_total",
+			"Cacheable requests, by result (hit, stale, stale_error, miss or error).", "result"),
+		purged: reg.NewCounterVec("gateway_cache_purged_total",
+			"Cache entries removed by purges, by reason.", "reason"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (c *Cache) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.applies(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+			entry, varyOn, err := c.lookup(ctx, r)
+			cancel()
+			if err != nil {
+				// The cache fails open rather than failing requests
+				log.Printf("Cache lookup failed: %v", err)
+				c.requests.Inc("error")
+				next.ServeHTTP(w, r)
+				return
+			}
+			var fallback *Entry
+			if now := time.Now(); entry != nil {
+				switch {
+				case now.Before(entry.Expires):
+					c.requests.Inc("hit")
+					c.serve(w, r, entry)
+					return
+				case now.Before(entry.StaleUntil):
+					c.requests.Inc("stale")
+					c.serve(w, r, entry)
+					go c.refresh(next, fullRequest(r), varyOn)
+					return
+				case now.Before(entry.ErrorUntil):
+					fallback = entry
+				}
+			}
+			if r.Method == http.MethodHead {
+				// A HEAD response has no body to store for later GETs
+				c.requests.Inc("miss")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &recorder{
+				ResponseWriter: w,
+				cache:          c,
+				request:        r,
+				before:         w.Header().Clone(),
+				fallback:       fallback,
+			}
+			// Storing needs the upstream's full response, not a 304 meant
+			// for this client
+			next.ServeHTTP(rec, withoutValidators(r))
+			if rec.failed {
+				c.requests.Inc("stale_error")
+				resetHeader(w.Header(), rec.before)
+				c.serve(w, r, fallback)
+				return
+			}
+			c.requests.Inc("miss")
+			if rec.fill {
+				go c.refresh(next, fullRequest(r), varyOn)
+			}
+			if rec.entry == nil {
+				return
+			}
+
+			ctx, cancel = context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+			if err := c.save(ctx, r, varyOn, rec.entry, rec.body.Bytes()); err != nil {
+				log.Printf("Failed to store cached response: %v", err)
+			}
+		})
+	}
+}
+
+// Purge removes every entry tagged with any of the surrogate keys, returning
+// how many were removed
+func (c *Cache) Purge(ctx context.Context, tags []string) (int, error) {
+	removed := 0
+	for _, tag := range tags {
+		indexPrefix := tagPrefix + hash(tag) + ":"
+		keys, err := c.store.Keys(ctx, indexPrefix)
+		if err != nil {
+			return removed, fmt.Errorf("failed to list entries tagged %s: %w", tag, err)
+		}
+		for _, key := range keys {
+			deleted, err := c.store.Delete(ctx, entryPrefix+key[len(indexPrefix):])
+			if err != nil {
+				return removed, fmt.Errorf("failed to purge entries tagged %s: %w", tag, err)
+			}
+			if deleted {
+				removed++
+			}
+			if _, err := c.store.Delete(ctx, key); err != nil {
+				return removed, fmt.Errorf("failed to purge entries tagged %s: %w", tag, err)
+			}
+		}
+	}
+	c.purged.Add(float64(removed), "tag")
+	return removed, nil
+}
+
+// refresh replaces a stale entry with a new response, fetched in the
+// background. Only one refresh of an entry runs at a time, across replicas
+// sharing the store.
+func (c *Cache) refresh(next http.Handler, r *http.Request, varyOn []string) {
+	defer func() {
+		// The proxy aborts responses that fail midway with a panic
+		if v := recover(); v != nil && v != http.ErrAbortHandler {
+			log.Printf("Cache refresh of %s panicked: %v", r.URL.Path, v)
+		}
+	}()
+
+	key := refreshPrefix + c.requestKey(r, varyOn)
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	refreshing, err := c.store.SetNX(ctx, key, nil, refreshTimeout)
+	cancel()
+	if err != nil || !refreshing {
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		c.store.Delete(ctx, key)
+	}()
+
+	ctx, cancel = context.WithTimeout(r.Context(), refreshTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+	rec := &recorder{
+		ResponseWriter: &discardWriter{header: make(http.Header)},
+		cache:          c,
+		request:        r,
+		before:         http.Header{},
+	}
+	next.ServeHTTP(rec, r)
+	if rec.entry == nil {
+		return
+	}
+	if err := c.save(ctx, r, varyOn, rec.entry, rec.body.Bytes()); err != nil {
+		log.Printf("Failed to store refreshed response: %v", err)
+	}
+}
+
+// applies reports whether the request may be answered from the cache
+func (c *Cache) applies(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	// Upgrades bypass the cache, and so does partial content unless ranges
+	// are served
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if r.Header.Get("Range") != "" && (!c.config.Ranges || r.Method != http.MethodGet) {
+		return false
+	}
+	if len(c.config.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.config.PathPrefixes {
+		if proxy.HasPathPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the entry for the request, or nil, along with the request
+// headers the URL's responses vary on. The entry may have expired.
+func (c *Cache) lookup(ctx context.Context, r *http.Request) (*Entry, []string, error) {
+	var varyOn []string
+	data, err := c.store.Get(ctx, varyPrefix+urlKey(r))
+	if err == nil {
+		if err := json.Unmarshal(data, &varyOn); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode cache variants: %w", err)
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, err
+	}
+
+	data, err = c.store.Get(ctx, entryPrefix+c.requestKey(r, varyOn))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, varyOn, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode cached response: %w", err)
+	}
+	return &entry, varyOn, nil
+}
+
+// serve writes a stored response
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, entry *Entry) {
+	for name, values := range entry.Header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	httputil.MarkCacheHit(r)
+	if c.config.Ranges && entry.Status == http.StatusOK {
+		// net/http answers Range and If-Range from the stored body
+		w.Header().Del("Content-Length")
+		modified, _ := http.ParseTime(entry.Header.Get("Last-Modified"))
+		http.ServeContent(w, r, "", modified, bytes.NewReader(entry.Body))
+		return
+	}
+	w.WriteHeader(entry.Status)
+	if r.Method != http.MethodHead {
+		w.Write(entry.Body)
+	}
+}
+
+// save stores a response with its surrogate key index, which expires with
+// the entry
+func (c *Cache) save(ctx context.Context, r *http.Request, previousVary []string, entry *Entry, body []byte) error {
+	varyOn := entry.Vary
+	ttl := time.Until(entry.expiry())
+	if ttl <= 0 {
+		return nil
+	}
+	if !slices.Equal(varyOn, previousVary) {
+		data, err := json.Marshal(varyOn)
+		if err != nil {
+			return err
+		}
+		if err := c.store.Set(ctx, varyPrefix+urlKey(r), data, c.config.MaxTTL); err != nil {
+			return err
+		}
+	}
+
+	entry.Body = body
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := c.requestKey(r, varyOn)
+	if err := c.store.Set(ctx, entryPrefix+key, data, ttl); err != nil {
+		return err
+	}
+	for _, tag := range entry.Tags {
+		if err := c.store.Set(ctx, tagPrefix+hash(tag)+":"+key, nil, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lifetime returns how long a response may be served from the cache; a
+// zero fresh lifetime means it must not be stored
+func (c *Cache) lifetime(r *http.Request, status int, header http.Header) lifetime {
+	if !cacheableStatuses[status] || header.Get("Set-Cookie") != "" {
+		return lifetime{}
+	}
+	for _, name := range varyHeaders(header) {
+		if name == "*" {
+			return lifetime{}
+		}
+	}
+
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	for _, directive := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[directive]; ok {
+			return lifetime{}
+		}
+	}
+	_, public := directives["public"]
+	_, shared := directives["s-maxage"]
+	if hasCredentials(r) && !public && !shared {
+		return lifetime{}
+	}
+
+	ttl := c.config.DefaultTTL
+	if value, ok := directives["s-maxage"]; ok {
+		ttl = parseSeconds(value)
+	} else if value, ok := directives["max-age"]; ok {
+		ttl = parseSeconds(value)
+	}
+	if age := parseSeconds(header.Get("Age")); age > 0 {
+		ttl -= age
+	}
+	if ttl <= 0 {
+		return lifetime{}
+	}
+
+	l := lifetime{
+		fresh:                min(ttl, c.config.MaxTTL),
+		staleWhileRevalidate: c.config.StaleWhileRevalidate,
+		staleIfError:         c.config.StaleIfError,
+	}
+	if value, ok := directives["stale-while-revalidate"]; ok {
+		l.staleWhileRevalidate = parseSeconds(value)
+	}
+	if value, ok := directives["stale-if-error"]; ok {
+		l.staleIfError = parseSeconds(value)
+	}
+	// Responses that must be revalidated are never served stale
+	_, mustRevalidate := directives["must-revalidate"]
+	_, proxyRevalidate := directives["proxy-revalidate"]
+	if mustRevalidate || proxyRevalidate {
+		l.staleWhileRevalidate, l.staleIfError = 0, 0
+	}
+	l.staleWhileRevalidate = min(l.staleWhileRevalidate, c.config.MaxTTL)
+	l.staleIfError = min(l.staleIfError, c.config.MaxTTL)
+	return l
+}
+
+// fullRequest returns a background copy of the request for the whole
+// response, as the cache stores it
+func fullRequest(r *http.Request) *http.Request {
+	full := r.Clone(context.WithoutCancel(r.Context()))
+	full.Method = http.MethodGet
+	full.Header.Del("Range")
+	full.Header.Del("If-Range")
+	return withoutValidators(full)
+}
+
+// completeLength returns the size of the full response from a Content-Range
+// header, or -1 when it is unknown
+func completeLength(contentRange string) int64 {
+	_, size, ok := strings.Cut(contentRange, "/")
+	if !ok || !strings.HasPrefix(contentRange, "bytes ") {
+		return -1
+	}
+	length, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return length
+}
+
+// withoutValidators returns the request without the preconditions that
+// would let the upstream answer 304 Not Modified
+func withoutValidators(r *http.Request) *http.Request {
+	if r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+		return r
+	}
+	r = r.Clone(r.Context())
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	return r
+}
+
+// hasCredentials reports whether the request identifies its caller, by a
+// header or a verified client certificate
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-API-Key") != "" || r.Header.Get("Cookie") != "" ||
+		(r.TLS != nil && len(r.TLS.VerifiedChains) > 0)
+}
+
+// parseCacheControl returns the directives of Cache-Control header values,
+// with lowercase names and unquoted arguments
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// parseSeconds parses a delta-seconds value, returning 0 when it is invalid
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// varyHeaders returns the canonical, sorted request header names a response
+// varies on
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTags splits a surrogate key header into its keys
+func parseTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	return tags
+}
+
+// urlKey identifies the URL a request is for
+func urlKey(r *http.Request) string {
+	return hash(r.URL.RequestURI())
+}
+
+// requestKey identifies the stored response for a request, given the request
+// headers its URL's responses vary on. Accept-Encoding is always part of the
+// key, since upstreams may encode responses without saying they vary on it,
+// and so is the request's partition.
+func (c *Cache) requestKey(r *http.Request, varyOn []string) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Header.Get("Accept-Encoding")))
+	if c.config.Partition != nil {
+		h.Write([]byte{0})
+		h.Write([]byte("partition:" + c.config.Partition(r)))
+	}
+	for _, name := range varyOn {
+		h.Write([]byte{0})
+		h.Write([]byte(name + ":" + strings.Join(r.Header.Values(name), ",")))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// hash fingerprints a key component
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recorder passes a response through while keeping a copy of cacheable ones
+type recorder struct {
+	http.ResponseWriter
+	cache       *Cache
+	request     *http.Request
+	before      http.Header // Headers set by outer middleware, which are not stored
+	entry       *Entry      // Set while the response is being stored
+	body        bytes.Buffer
+	wroteHeader bool
+	fallback    *Entry // Stale entry served instead of an upstream error, if any
+	failed      bool   // Set when the fallback replaces the response
+	fill        bool   // Set when a byte range shows the full response can be stored
+}
+
+// WriteHeader decides whether the response is stored and removes its
+// surrogate keys before forwarding it
+func (rec *recorder) WriteHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	if rec.fallback != nil && code >= http.StatusInternalServerError {
+		// Nothing is forwarded; the stale entry is served once the handler returns
+		rec.failed = true
+		return
+	}
+
+	header := rec.Header()
+	tagHeader := rec.cache.config.TagHeader
+	tags := parseTags(header.Values(tagHeader))
+	header.Del(tagHeader)
+
+	if code == http.StatusPartialContent && rec.cache.config.Ranges {
+		size := completeLength(header.Get("Content-Range"))
+		rec.fill = size >= 0 && size <= int64(rec.cache.config.MaxBodySize) &&
+			rec.cache.lifetime(rec.request, http.StatusOK, header).fresh > 0
+	}
+	if l := rec.cache.lifetime(rec.request, code, header); l.fresh > 0 {
+		now := time.Now()
+		stored := make(http.Header)
+		for name, values := range header {
+			if name == "Age" || slices.Equal(rec.before[name], values) {
+				continue
+			}
+			stored[name] = append([]string(nil), values...)
+		}
+		rec.entry = &Entry{
+			Status:     code,
+			Header:     stored,
+			Tags:       tags,
+			Vary:       varyHeaders(header),
+			StoredAt:   now,
+			Expires:    now.Add(l.fresh),
+			StaleUntil: now.Add(l.fresh + l.staleWhileRevalidate),
+			ErrorUntil: now.Add(l.fresh + l.staleIfError),
+		}
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Write forwards the body, keeping a copy unless it grows too large to store
+func (rec *recorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.failed {
+		return len(data), nil
+	}
+	if rec.entry != nil {
+		if rec.body.Len()+len(data) > rec.cache.config.MaxBodySize {
+			rec.entry = nil
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(data)
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher
+func (rec *recorder) Flush() {
+	if rec.failed {
+		return
+	}
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// resetHeader restores a response header to the values it had before the
+// handler ran
+func resetHeader(header, before http.Header) {
+	for name := range header {
+		if _, ok := before[name]; !ok {
+			delete(header, name)
+		}
+	}
+	for name, values := range before {
+		header[name] = values
+	}
+}
+
+// discardWriter is the response writer of background refreshes, whose
+// responses are only stored
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardWriter) WriteHeader(code int) {}
+
+func (d *discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
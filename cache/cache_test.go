@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/storage"
+)
+
+func TestCachePartitionsResponses(t *testing.T) {
+	c := NewCache(&Config{
+		DefaultTTL:  time.Minute,
+		MaxTTL:      time.Hour,
+		MaxBodySize: 1 << 20,
+		Partition: func(r *http.Request) string {
+			return r.Header.Get("X-Experiments")
+		},
+	}, storage.NewMemoryStore(), metrics.NewRegistry())
+
+	calls := 0
+	handler := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte("variant " + r.Header.Get("X-Experiments")))
+	}))
+	get := func(variant string) (string, bool) {
+		r := httputil.WithCacheSlot(httptest.NewRequest(http.MethodGet, "/api/items", nil))
+		r.Header.Set("X-Experiments", variant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Body.String(), httputil.CacheHit(r)
+	}
+
+	steps := []struct {
+		variant string
+		body    string
+		hit     bool
+		calls   int
+	}{
+		{"checkout=a", "variant checkout=a", false, 1},
+		{"checkout=a", "variant checkout=a", true, 1},
+		{"checkout=b", "variant checkout=b", false, 2},
+		{"checkout=b", "variant checkout=b", true, 2},
+		{"checkout=a", "variant checkout=a", true, 2},
+	}
+	for i, step := range steps {
+		body, hit := get(step.variant)
+		if body != step.body || hit != step.hit || calls != step.calls {
+			t.Errorf("step %d (%s): body %q hit %v upstream calls %d, want %q %v %d",
+				i, step.variant, body, hit, calls, step.body, step.hit, step.calls)
+		}
+	}
+}
+
+func TestCacheKeepsCertificateResponsesPrivate(t *testing.T) {
+	c := NewCache(&Config{
+		DefaultTTL:  time.Minute,
+		MaxTTL:      time.Hour,
+		MaxBodySize: 1 << 20,
+	}, storage.NewMemoryStore(), metrics.NewRegistry())
+
+	handler := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Cache-Control, so only DefaultTTL would make it cacheable
+		w.Write(r.TLS.VerifiedChains[0][0].Raw)
+	}))
+	get := func(device string) string {
+		r := httptest.NewRequest(http.MethodGet, "/api/devices/me", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Raw: []byte(device)}}}}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Body.String()
+	}
+
+	for _, device := range []string{"device-1", "device-2", "device-1"} {
+		if got := get(device); got != device {
+			t.Errorf("%s was served the response of %s", device, got)
+		}
+	}
+}
+
+func TestCacheAppliesOnSegmentBoundaries(t *testing.T) {
+	c := NewCache(&Config{PathPrefixes: []string{"/api/items"}}, storage.NewMemoryStore(), metrics.NewRegistry())
+	for path, want := range map[string]bool{
+		"/api/items":       true,
+		"/api/items/1":     true,
+		"/api/items-admin": false,
+		"/api/itemsx/1":    false,
+	} {
+		if got := c.applies(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("applies(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
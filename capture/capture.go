@@ -0,0 +1,231 @@
+package capture
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/redact"
+)
+
+// Session represents an active or finished capture
+type Session struct {
+	ID         string    `json:"id"`
+	PathPrefix string    `json:"path_prefix"`
+	SampleRate float64   `json:"sample_rate"` // Fraction of matching requests to capture (0-1]
+	MaxEntries int       `json:"max_entries"`
+	Captured   int       `json:"captured"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Stopped    bool      `json:"stopped"`
+}
+
+// Active reports whether the session is still capturing
+func (s *Session) Active(now time.Time) bool {
+	return !s.Stopped && now.Before(s.ExpiresAt) && s.Captured < s.MaxEntries
+}
+
+// Config represents capture configuration
+type Config struct {
+	MaxBodySize int              `json:"max_body_size"`
+	Redactor    *redact.Redactor `json:"-"` // Masks secrets before entries are stored
+}
+
+// Capturer records sampled request/response pairs for active sessions
+type Capturer struct {
+	config *Config
+	sink   Sink
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewCapturer creates a new capturer writing to the given sink
+func NewCapturer(config *Config, sink Sink) *Capturer {
+	return &Capturer{
+		config:   config,
+		sink:     sink,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// StartSession begins capturing traffic for a path prefix
+func (c *Capturer) StartSession(pathPrefix string, sampleRate float64, maxEntries int, duration time.Duration, createdBy string) (*Session, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be in (0, 1]")
+	}
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("max entries must be positive")
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         id,
+		PathPrefix: pathPrefix,
+		SampleRate: sampleRate,
+		MaxEntries: maxEntries,
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(duration),
+	}
+
+	c.mu.Lock()
+	c.sessions[id] = session
+	c.mu.Unlock()
+
+	return session, nil
+}
+
+// StopSession stops a capture session, keeping its entries
+func (c *Capturer) StopSession(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, exists := c.sessions[id]
+	if !exists {
+		return fmt.Errorf("capture session not found")
+	}
+	session.Stopped = true
+	return nil
+}
+
+// DeleteSession removes a session and its entries
+func (c *Capturer) DeleteSession(ctx context.Context, id string) error {
+	c.mu.Lock()
+	_, exists := c.sessions[id]
+	delete(c.sessions, id)
+	c.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("capture session not found")
+	}
+	return c.sink.Delete(ctx, id)
+}
+
+// GetSession returns a copy of a session
+func (c *Capturer) GetSession(id string) (*Session, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, exists := c.sessions[id]
+	if !exists {
+		return nil, false
+	}
+	copied := *session
+	return &copied, true
+}
+
+// ListSessions returns copies of all sessions, newest first
+func (c *Capturer) ListSessions() []*Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(c.sessions))
+	for _, session := range c.sessions {
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// Entries returns the captured entries of a session
+func (c *Capturer) Entries(ctx context.Context, id string) ([]*Entry, error) {
+	if _, exists := c.GetSession(id); !exists {
+		return nil, fmt.Errorf("capture session not found")
+	}
+	return c.sink.Entries(ctx, id)
+}
+
+// Middleware returns the HTTP middleware function
+func (c *Capturer) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := c.claim(r.URL.Path)
+			if session == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = auth.WithIdentitySlot(r)
+			requestBody := httputil.PeekBody(r, int64(c.config.MaxBodySize))
+
+			start := time.Now()
+			rec := httputil.NewTeeRecorder(w, c.config.MaxBodySize)
+			next.ServeHTTP(rec, r)
+
+			redactor := c.config.Redactor
+			entryID, _ := randomID()
+			entry := &Entry{
+				ID:             entryID,
+				SessionID:      session,
+				Timestamp:      start,
+				Method:         r.Method,
+				Path:           r.URL.Path,
+				Query:          redactor.Query(r.URL.RawQuery),
+				ClientIP:       httputil.ClientIP(r),
+				Consumer:       metrics.Consumer(r),
+				RequestHeader:  redactor.Header(r.Header),
+				RequestBody:    string(redactor.Body(requestBody, r.Header.Get("Content-Type"))),
+				StatusCode:     rec.StatusCode,
+				ResponseHeader: redactor.Header(w.Header()),
+				ResponseBody:   string(redactor.Body(rec.Body.Bytes(), w.Header().Get("Content-Type"))),
+				DurationMs:     float64(time.Since(start).Microseconds()) / 1000,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := c.sink.Append(ctx, entry); err != nil {
+				log.Printf("Failed to store captured request: %v", err)
+			}
+		})
+	}
+}
+
+// claim picks an active session matching the path, applying its sample rate,
+// and reserves a capture slot in it. It returns "" when nothing should be captured.
+func (c *Capturer) claim(path string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range c.sessions {
+		if !session.Active(now) || !strings.HasPrefix(path, session.PathPrefix) {
+			continue
+		}
+		if mathrand.Float64() >= session.SampleRate {
+			continue
+		}
+		session.Captured++
+		return session.ID
+	}
+	return ""
+}
+
+// randomID generates a random hex identifier
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
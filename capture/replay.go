@@ -0,0 +1,131 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"api-gateway/redact"
+)
+
+// ReplayResult represents the outcome of re-sending one captured request
+type ReplayResult struct {
+	EntryID        string  `json:"entry_id"`
+	Method         string  `json:"method"`
+	Path           string  `json:"path"`
+	OriginalStatus int     `json:"original_status"`
+	ReplayStatus   int     `json:"replay_status,omitempty"`
+	StatusMatch    bool    `json:"status_match"`
+	BodyMatch      bool    `json:"body_match"`
+	DurationMs     float64 `json:"duration_ms"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// ReplaySummary aggregates replay results
+type ReplaySummary struct {
+	Target     string          `json:"target"`
+	Total      int             `json:"total"`
+	Matched    int             `json:"matched"`
+	Mismatched int             `json:"mismatched"`
+	Failed     int             `json:"failed"`
+	Results    []*ReplayResult `json:"results"`
+}
+
+// Replayer re-sends captured traffic against another upstream
+type Replayer struct {
+	client *http.Client
+}
+
+// NewReplayer creates a new replayer with the given per-request timeout
+func NewReplayer(timeout time.Duration) *Replayer {
+	return &Replayer{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Replay sends each entry to target in order. Redacted headers are dropped and
+// extraHeaders (e.g. credentials valid for the target) are added instead.
+func (rp *Replayer) Replay(ctx context.Context, entries []*Entry, target string, extraHeaders map[string]string) (*ReplaySummary, error) {
+	base, err := url.Parse(target)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid replay target: %s", target)
+	}
+
+	summary := &ReplaySummary{
+		Target:  target,
+		Results: make([]*ReplayResult, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		result := rp.replayOne(ctx, base, entry, extraHeaders)
+		summary.Total++
+		switch {
+		case result.Error != "":
+			summary.Failed++
+		case result.StatusMatch:
+			summary.Matched++
+		default:
+			summary.Mismatched++
+		}
+		summary.Results = append(summary.Results, result)
+	}
+
+	return summary, nil
+}
+
+// replayOne re-sends a single entry
+func (rp *Replayer) replayOne(ctx context.Context, base *url.URL, entry *Entry, extraHeaders map[string]string) *ReplayResult {
+	result := &ReplayResult{
+		EntryID:        entry.ID,
+		Method:         entry.Method,
+		Path:           entry.Path,
+		OriginalStatus: entry.StatusCode,
+	}
+
+	target := *base
+	target.Path = strings.TrimSuffix(base.Path, "/") + entry.Path
+	target.RawQuery = entry.Query
+
+	req, err := http.NewRequestWithContext(ctx, entry.Method, target.String(), strings.NewReader(entry.RequestBody))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for name, values := range entry.RequestHeader {
+		if len(values) == 1 && values[0] == redact.Placeholder {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	for name, value := range extraHeaders {
+		req.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	resp, err := rp.client.Do(req)
+	result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, int64(len(entry.ResponseBody))+1))
+	result.ReplayStatus = resp.StatusCode
+	result.StatusMatch = resp.StatusCode == entry.StatusCode
+	result.BodyMatch = string(body) == entry.ResponseBody
+
+	return result
+}
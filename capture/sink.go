@@ -0,0 +1,174 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Entry represents one captured request/response pair
+type Entry struct {
+	ID             string              `json:"id"`
+	SessionID      string              `json:"session_id"`
+	Timestamp      time.Time           `json:"timestamp"`
+	Method         string              `json:"method"`
+	Path           string              `json:"path"`
+	Query          string              `json:"query"`
+	ClientIP       string              `json:"client_ip,omitempty"`
+	Consumer       string              `json:"consumer,omitempty"` // Authenticated consumer, such as "jwt:42", or "anonymous"
+	RequestHeader  map[string][]string `json:"request_header"`
+	RequestBody    string              `json:"request_body"`
+	StatusCode     int                 `json:"status_code"`
+	ResponseHeader map[string][]string `json:"response_header"`
+	ResponseBody   string              `json:"response_body"`
+	DurationMs     float64             `json:"duration_ms"`
+}
+
+// Sink persists captured entries
+type Sink interface {
+	Append(ctx context.Context, entry *Entry) error
+	Entries(ctx context.Context, sessionID string) ([]*Entry, error)
+	Delete(ctx context.Context, sessionID string) error
+}
+
+// FileSink stores each session as a JSON-lines file in a directory
+type FileSink struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSink creates a file sink, creating the directory if needed
+func NewFileSink(dir string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	return &FileSink{dir: dir}, nil
+}
+
+// Append writes an entry to the session file
+func (s *FileSink) Append(ctx context.Context, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode capture entry: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path(entry.SessionID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Entries reads all entries of a session
+func (s *FileSink) Entries(ctx context.Context, sessionID string) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path(sessionID))
+	if os.IsNotExist(err) {
+		return []*Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer f.Close()
+
+	entries := []*Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode capture entry: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Delete removes a session file
+func (s *FileSink) Delete(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(sessionID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path returns the file path for a session
+func (s *FileSink) path(sessionID string) string {
+	return filepath.Join(s.dir, filepath.Base(sessionID)+".jsonl")
+}
+
+// RedisSink stores each session as a Redis list
+type RedisSink struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisSink creates a Redis sink whose lists expire after ttl
+func NewRedisSink(client *redis.Client, ttl time.Duration) *RedisSink {
+	return &RedisSink{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// Append pushes an entry onto the session list
+func (s *RedisSink) Append(ctx context.Context, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode capture entry: %w", err)
+	}
+
+	key := s.key(entry.SessionID)
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.Expire(ctx, key, s.ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Entries reads all entries of a session
+func (s *RedisSink) Entries(ctx context.Context, sessionID string) ([]*Entry, error) {
+	values, err := s.client.LRange(ctx, s.key(sessionID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture entries: %w", err)
+	}
+
+	entries := make([]*Entry, 0, len(values))
+	for _, value := range values {
+		var entry Entry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode capture entry: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// Delete removes a session list
+func (s *RedisSink) Delete(ctx context.Context, sessionID string) error {
+	return s.client.Del(ctx, s.key(sessionID)).Err()
+}
+
+// key namespaces capture lists
+func (s *RedisSink) key(sessionID string) string {
+	return "capture:" + sessionID
+}
@@ -0,0 +1,235 @@
+package chaos
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault describes the failures injected into requests matching a path prefix.
+// Percentages are in [0, 100] and are rolled independently per request.
+type Fault struct {
+	ID             string        `json:"id"`
+	PathPrefix     string        `json:"path_prefix"`
+	Latency        time.Duration `json:"latency"`
+	LatencyPercent float64       `json:"latency_percent"`
+	ErrorStatus    int           `json:"error_status,omitempty"`
+	ErrorPercent   float64       `json:"error_percent"`
+	DropPercent    float64       `json:"drop_percent"`
+	CreatedBy      string        `json:"created_by"`
+	CreatedAt      time.Time     `json:"created_at"`
+	ExpiresAt      *time.Time    `json:"expires_at,omitempty"` // Nil means until removed
+	Stats          FaultStats    `json:"stats"`
+}
+
+// FaultStats counts injected failures for a fault
+type FaultStats struct {
+	Matched int64 `json:"matched"`
+	Delayed int64 `json:"delayed"`
+	Errored int64 `json:"errored"`
+	Dropped int64 `json:"dropped"`
+}
+
+// Injector applies configured faults to matching requests
+type Injector struct {
+	mu     sync.Mutex
+	faults map[string]*Fault
+}
+
+// NewInjector creates a new fault injector
+func NewInjector() *Injector {
+	return &Injector{
+		faults: make(map[string]*Fault),
+	}
+}
+
+// AddFault registers a fault, active for duration (zero means until removed)
+func (in *Injector) AddFault(fault *Fault, duration time.Duration) (*Fault, error) {
+	if fault.PathPrefix == "" {
+		return nil, fmt.Errorf("path prefix is required")
+	}
+	for _, percent := range []float64{fault.LatencyPercent, fault.ErrorPercent, fault.DropPercent} {
+		if percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("percentages must be between 0 and 100")
+		}
+	}
+	if fault.LatencyPercent > 0 && fault.Latency <= 0 {
+		return nil, fmt.Errorf("latency must be positive when latency_percent is set")
+	}
+	if fault.ErrorPercent > 0 && (fault.ErrorStatus < 400 || fault.ErrorStatus > 599) {
+		return nil, fmt.Errorf("error status must be a 4xx or 5xx code")
+	}
+	if duration < 0 {
+		return nil, fmt.Errorf("duration must not be negative")
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate id: %w", err)
+	}
+
+	now := time.Now()
+	fault.ID = hex.EncodeToString(b)
+	fault.CreatedAt = now
+	fault.Stats = FaultStats{}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		fault.ExpiresAt = &expiresAt
+	}
+
+	in.mu.Lock()
+	in.faults[fault.ID] = fault
+	in.mu.Unlock()
+
+	log.Printf("Chaos fault %s enabled for %s", fault.ID, fault.PathPrefix)
+
+	copied := *fault
+	return &copied, nil
+}
+
+// RemoveFault disables a fault
+func (in *Injector) RemoveFault(id string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if _, exists := in.faults[id]; !exists {
+		return fmt.Errorf("fault not found")
+	}
+	delete(in.faults, id)
+	return nil
+}
+
+// ListFaults returns copies of the active faults
+func (in *Injector) ListFaults() []*Fault {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	now := time.Now()
+	faults := make([]*Fault, 0, len(in.faults))
+	for id, fault := range in.faults {
+		if fault.expired(now) {
+			delete(in.faults, id)
+			continue
+		}
+		copied := *fault
+		faults = append(faults, &copied)
+	}
+	sort.Slice(faults, func(i, j int) bool {
+		return faults[i].CreatedAt.Before(faults[j].CreatedAt)
+	})
+	return faults
+}
+
+// Middleware returns the HTTP middleware function
+func (in *Injector) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			action, ok := in.roll(r.URL.Path)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if action.delay > 0 {
+				select {
+				case <-time.After(action.delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			if action.drop {
+				dropConnection(w)
+				return
+			}
+
+			if action.status != 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Chaos-Fault", action.faultID)
+				w.WriteHeader(action.status)
+				fmt.Fprintf(w, `{"error":"Injected fault","details":"%s returned by chaos fault %s"}`, http.StatusText(action.status), action.faultID)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// action is the outcome of rolling a fault for one request
+type action struct {
+	faultID string
+	delay   time.Duration
+	status  int
+	drop    bool
+}
+
+// roll finds the most specific active fault for the path and decides what to inject
+func (in *Injector) roll(path string) (action, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if len(in.faults) == 0 {
+		return action{}, false
+	}
+
+	now := time.Now()
+	var fault *Fault
+	for _, candidate := range in.faults {
+		if candidate.expired(now) || !strings.HasPrefix(path, candidate.PathPrefix) {
+			continue
+		}
+		if fault == nil || len(candidate.PathPrefix) > len(fault.PathPrefix) {
+			fault = candidate
+		}
+	}
+	if fault == nil {
+		return action{}, false
+	}
+
+	result := action{faultID: fault.ID}
+	fault.Stats.Matched++
+	if chance(fault.LatencyPercent) {
+		result.delay = fault.Latency
+		fault.Stats.Delayed++
+	}
+	switch {
+	case chance(fault.DropPercent):
+		result.drop = true
+		fault.Stats.Dropped++
+	case chance(fault.ErrorPercent):
+		result.status = fault.ErrorStatus
+		fault.Stats.Errored++
+	}
+	return result, true
+}
+
+// expired reports whether the fault has passed its expiry
+func (f *Fault) expired(now time.Time) bool {
+	return f.ExpiresAt != nil && !now.Before(*f.ExpiresAt)
+}
+
+// chance returns true with the given percentage probability
+func chance(percent float64) bool {
+	return percent > 0 && mathrand.Float64()*100 < percent
+}
+
+// dropConnection closes the client connection without writing a response
+func dropConnection(w http.ResponseWriter) {
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	// Wrapped writers may not support hijacking; aborting the handler makes
+	// net/http reset the connection instead
+	panic(http.ErrAbortHandler)
+}
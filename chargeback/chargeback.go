@@ -0,0 +1,264 @@
+package chargeback
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+)
+
+// MonthFormat is the layout of report months, such as 2026-10
+const MonthFormat = "2006-01"
+
+// Usage is what one consumer of a tenant used in a month
+type Usage struct {
+	Month          string  `json:"month"`
+	Tenant         string  `json:"tenant"`             // Owning user, or the tenant claim of tokens
+	Consumer       string  `json:"consumer,omitempty"` // Credential, such as apikey:ak_1a2b3c4d5 or jwt:42; empty in tenant summaries
+	Name           string  `json:"name,omitempty"`     // API key name
+	Plan           string  `json:"plan,omitempty"`     // API key plan
+	Requests       int64   `json:"requests"`
+	RequestBytes   int64   `json:"request_bytes"`
+	ResponseBytes  int64   `json:"response_bytes"`
+	ComputeSeconds float64 `json:"compute_seconds"` // Request time weighted by route
+	CostUnits      float64 `json:"cost_units"`
+}
+
+// add adds another usage's counters
+func (u *Usage) add(other *Usage) {
+	u.Requests += other.Requests
+	u.RequestBytes += other.RequestBytes
+	u.ResponseBytes += other.ResponseBytes
+	u.ComputeSeconds += other.ComputeSeconds
+	u.CostUnits += other.CostUnits
+	if other.Name != "" {
+		u.Name = other.Name
+	}
+	if other.Plan != "" {
+		u.Plan = other.Plan
+	}
+}
+
+// id identifies the usage's tenant and consumer within a month
+func (u *Usage) id() string {
+	return u.Tenant + "\x00" + u.Consumer
+}
+
+// Config represents chargeback configuration
+type Config struct {
+	UnitsPerRequest       float64
+	UnitsPerGB            float64 // Per GB of request and response bodies
+	UnitsPerComputeSecond float64
+	RouteWeights          map[string]float64 // Route prefix -> compute weight; routes without one weigh 1
+	TenantClaim           string             // JWT claim naming the tenant; empty uses the user ID
+	FlushInterval         time.Duration      // How often accrued usage is written to the store
+}
+
+// Recorder accrues the usage of each request to its tenant and consumer for
+// the current month, writing it to the store periodically
+type Recorder struct {
+	config *Config
+	store  Store
+
+	mu      sync.Mutex
+	pending map[string]map[string]*Usage // Month -> tenant and consumer -> usage not yet stored
+}
+
+// NewRecorder creates a chargeback recorder and starts flushing usage to the
+// store
+func NewRecorder(config *Config, store Store) *Recorder {
+	rec := &Recorder{
+		config:  config,
+		store:   store,
+		pending: make(map[string]map[string]*Usage),
+	}
+
+	go rec.flushRoutine()
+
+	return rec
+}
+
+// Middleware returns the HTTP middleware function
+func (rec *Recorder) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = auth.WithIdentitySlot(r)
+			start := time.Now()
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			cw := httputil.NewStatusWriter(w)
+
+			next.ServeHTTP(cw, r)
+
+			compute := time.Since(start).Seconds() * rec.weight(r.URL.Path)
+			usage := &Usage{
+				Month:          start.UTC().Format(MonthFormat),
+				Requests:       1,
+				RequestBytes:   body.n,
+				ResponseBytes:  cw.Written,
+				ComputeSeconds: compute,
+				CostUnits: rec.config.UnitsPerRequest +
+					float64(body.n+cw.Written)/1e9*rec.config.UnitsPerGB +
+					compute*rec.config.UnitsPerComputeSecond,
+			}
+			rec.identify(r, usage)
+			rec.accrue(usage)
+		})
+	}
+}
+
+// weight returns the compute weight of the longest matching route prefix
+func (rec *Recorder) weight(path string) float64 {
+	weight, longest := 1.0, -1
+	for prefix, w := range rec.config.RouteWeights {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			weight, longest = w, len(prefix)
+		}
+	}
+	return weight
+}
+
+// identify sets the tenant and consumer a request is billed to, and the name
+// and plan of its API key
+func (rec *Recorder) identify(r *http.Request, usage *Usage) {
+	userCtx := auth.GetResolvedIdentity(r)
+	if userCtx == nil {
+		usage.Tenant, usage.Consumer = "anonymous", "anonymous"
+		return
+	}
+
+	tenant := userCtx.UserID
+	if rec.config.TenantClaim != "" {
+		if claim, ok := userCtx.Claims[rec.config.TenantClaim].(string); ok && claim != "" {
+			tenant = claim
+		}
+	}
+	if userCtx.AuthType == "apikey" && userCtx.APIKey != nil {
+		// Reports name keys by a prefix rather than exposing the whole key
+		key := userCtx.APIKey.Key
+		if len(key) > 12 {
+			key = key[:12]
+		}
+		usage.Tenant, usage.Consumer = tenant, "apikey:"+key
+		usage.Name, usage.Plan = userCtx.APIKey.Name, userCtx.APIKey.Plan
+		return
+	}
+	usage.Tenant, usage.Consumer = tenant, fmt.Sprintf("%s:%s", userCtx.AuthType, userCtx.UserID)
+}
+
+// accrue adds usage to the pending totals
+func (rec *Recorder) accrue(usage *Usage) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	month := rec.pending[usage.Month]
+	if month == nil {
+		month = make(map[string]*Usage)
+		rec.pending[usage.Month] = month
+	}
+	if total, exists := month[usage.id()]; exists {
+		total.add(usage)
+		return
+	}
+	month[usage.id()] = usage
+}
+
+// Flush writes pending usage to the store. Usage that fails to be written is
+// kept and retried on the next flush.
+func (rec *Recorder) Flush(ctx context.Context) error {
+	rec.mu.Lock()
+	pending := rec.pending
+	rec.pending = make(map[string]map[string]*Usage)
+	rec.mu.Unlock()
+
+	var firstErr error
+	for month, usages := range pending {
+		list := make([]*Usage, 0, len(usages))
+		for _, usage := range usages {
+			list = append(list, usage)
+		}
+		if err := rec.store.Add(ctx, month, list); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			for _, usage := range list {
+				rec.accrue(usage)
+			}
+		}
+	}
+	return firstErr
+}
+
+// flushRoutine periodically writes pending usage to the store
+func (rec *Recorder) flushRoutine() {
+	ticker := time.NewTicker(rec.config.FlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := rec.Flush(ctx); err != nil {
+			log.Printf("Failed to store chargeback usage: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Report returns a month's usage per consumer, or per tenant when byTenant is
+// set, ordered by tenant and consumer. Usage this instance has not yet stored
+// is flushed first.
+func (rec *Recorder) Report(ctx context.Context, month string, byTenant bool) ([]*Usage, error) {
+	if err := rec.Flush(ctx); err != nil {
+		return nil, err
+	}
+	usages, err := rec.store.Month(ctx, month)
+	if err != nil {
+		return nil, err
+	}
+
+	if byTenant {
+		tenants := make(map[string]*Usage)
+		for _, usage := range usages {
+			total, exists := tenants[usage.Tenant]
+			if !exists {
+				total = &Usage{Month: month, Tenant: usage.Tenant}
+				tenants[usage.Tenant] = total
+			}
+			total.add(usage)
+			total.Name, total.Plan = "", ""
+		}
+		usages = make([]*Usage, 0, len(tenants))
+		for _, total := range tenants {
+			usages = append(usages, total)
+		}
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Tenant != usages[j].Tenant {
+			return usages[i].Tenant < usages[j].Tenant
+		}
+		return usages[i].Consumer < usages[j].Consumer
+	})
+	return usages, nil
+}
+
+// countingReader counts bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,170 @@
+package chargeback
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps monthly usage totals
+type Store interface {
+	// Add adds usage to the month's totals
+	Add(ctx context.Context, month string, usages []*Usage) error
+	// Month returns the month's totals per tenant and consumer
+	Month(ctx context.Context, month string) ([]*Usage, error)
+}
+
+// MemoryStore keeps monthly totals in memory; they are lost on restart
+type MemoryStore struct {
+	mu        sync.Mutex
+	months    map[string]map[string]*Usage
+	retention int // Months kept, the current one included
+}
+
+// NewMemoryStore creates a new in-memory chargeback store keeping the given
+// number of months
+func NewMemoryStore(retention int) *MemoryStore {
+	return &MemoryStore{
+		months:    make(map[string]map[string]*Usage),
+		retention: retention,
+	}
+}
+
+// Add adds usage to the month's totals, forgetting months past retention
+func (s *MemoryStore) Add(ctx context.Context, month string, usages []*Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := s.months[month]
+	if totals == nil {
+		totals = make(map[string]*Usage)
+		s.months[month] = totals
+		oldest := time.Now().UTC().AddDate(0, 1-s.retention, 0).Format(MonthFormat)
+		for m := range s.months {
+			if m < oldest {
+				delete(s.months, m)
+			}
+		}
+	}
+	for _, usage := range usages {
+		total, exists := totals[usage.id()]
+		if !exists {
+			total = &Usage{Month: month, Tenant: usage.Tenant, Consumer: usage.Consumer}
+			totals[usage.id()] = total
+		}
+		total.add(usage)
+	}
+	return nil
+}
+
+// Month returns the month's totals
+func (s *MemoryStore) Month(ctx context.Context, month string) ([]*Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usages := make([]*Usage, 0, len(s.months[month]))
+	for _, total := range s.months[month] {
+		copied := *total
+		usages = append(usages, &copied)
+	}
+	return usages, nil
+}
+
+// redisPrefix namespaces chargeback keys; each month is a hash of counters
+const redisPrefix = "chargeback:"
+
+// RedisStore keeps monthly totals in Redis, so every replica adds to and
+// reports the same totals
+type RedisStore struct {
+	client    *redis.Client
+	retention int
+}
+
+// NewRedisStore creates a new Redis-backed chargeback store keeping the given
+// number of months
+func NewRedisStore(client *redis.Client, retention int) *RedisStore {
+	return &RedisStore{
+		client:    client,
+		retention: retention,
+	}
+}
+
+// Add adds usage to the month's totals. Each month's hash expires once it is
+// past retention.
+func (s *RedisStore) Add(ctx context.Context, month string, usages []*Usage) error {
+	start, err := time.Parse(MonthFormat, month)
+	if err != nil {
+		return fmt.Errorf("invalid month %q: %w", month, err)
+	}
+	key := redisPrefix + month
+
+	pipe := s.client.Pipeline()
+	for _, usage := range usages {
+		field := usage.id() + "\x00"
+		pipe.HIncrBy(ctx, key, field+"requests", usage.Requests)
+		pipe.HIncrBy(ctx, key, field+"request_bytes", usage.RequestBytes)
+		pipe.HIncrBy(ctx, key, field+"response_bytes", usage.ResponseBytes)
+		pipe.HIncrByFloat(ctx, key, field+"compute_seconds", usage.ComputeSeconds)
+		pipe.HIncrByFloat(ctx, key, field+"cost_units", usage.CostUnits)
+		if usage.Name != "" {
+			pipe.HSet(ctx, key, field+"name", usage.Name)
+		}
+		if usage.Plan != "" {
+			pipe.HSet(ctx, key, field+"plan", usage.Plan)
+		}
+	}
+	pipe.ExpireAt(ctx, key, start.AddDate(0, s.retention, 0))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store chargeback usage: %w", err)
+	}
+	return nil
+}
+
+// Month returns the month's totals
+func (s *RedisStore) Month(ctx context.Context, month string) ([]*Usage, error) {
+	values, err := s.client.HGetAll(ctx, redisPrefix+month).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chargeback usage: %w", err)
+	}
+
+	totals := make(map[string]*Usage)
+	for field, value := range values {
+		parts := strings.SplitN(field, "\x00", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		id := parts[0] + "\x00" + parts[1]
+		total, exists := totals[id]
+		if !exists {
+			total = &Usage{Month: month, Tenant: parts[0], Consumer: parts[1]}
+			totals[id] = total
+		}
+		switch parts[2] {
+		case "requests":
+			total.Requests, _ = strconv.ParseInt(value, 10, 64)
+		case "request_bytes":
+			total.RequestBytes, _ = strconv.ParseInt(value, 10, 64)
+		case "response_bytes":
+			total.ResponseBytes, _ = strconv.ParseInt(value, 10, 64)
+		case "compute_seconds":
+			total.ComputeSeconds, _ = strconv.ParseFloat(value, 64)
+		case "cost_units":
+			total.CostUnits, _ = strconv.ParseFloat(value, 64)
+		case "name":
+			total.Name = value
+		case "plan":
+			total.Plan = value
+		}
+	}
+
+	usages := make([]*Usage, 0, len(totals))
+	for _, total := range totals {
+		usages = append(usages, total)
+	}
+	return usages, nil
+}
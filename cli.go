@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/config"
+	"api-gateway/gateway"
+	"api-gateway/handlers"
+	"api-gateway/loadtest"
+
+	"github.com/gorilla/mux"
+)
+
+const usage = `Usage: api-gateway <command> [flags]
+
+Commands:
+  serve [-validate-config] [-dry-run]   Start the gateway (default when no command is given)
+  routes list                           List registered routes
+  keys create [flags]                   Create an API key on a running gateway
+  keys revoke [flags] <key>             Revoke an API key on a running gateway
+  keys restore [flags] <key>            Restore a deleted API key on a running gateway
+  token generate [flags]                Generate a signed JWT
+  config validate                       Validate configuration and exit
+  loadtest [flags]                      Generate synthetic traffic against a gateway
+
+Run 'api-gateway <command> -h' for command flags.
+`
+
+// runCommand dispatches a CLI subcommand and returns the process exit code
+func runCommand(args []string) int {
+	command := args[0]
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		command += " " + args[1]
+		args = args[2:]
+	} else {
+		args = args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+		return 0
+	case "routes list":
+		return listRoutes(args)
+	case "keys create":
+		return createKey(args)
+	case "keys revoke":
+		return revokeKey(args)
+	case "keys restore":
+		return restoreKey(args)
+	case "token generate":
+		return generateToken(args)
+	case "loadtest":
+		return runLoadTest(args)
+	case "config validate":
+		if !validateConfiguration() {
+			return 1
+		}
+		return 0
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+}
+
+// listRoutes prints every route with its methods
+func listRoutes(args []string) int {
+	flags := flag.NewFlagSet("routes list", flag.ExitOnError)
+	flags.Parse(args)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	type routeInfo struct {
+		path    string
+		methods string
+	}
+	var routes []routeInfo
+	gw, err := gateway.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize gateway: %v\n", err)
+		return 1
+	}
+	gw.Router().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"ANY"}
+		}
+		routes = append(routes, routeInfo{path: path, methods: strings.Join(methods, ",")})
+		return nil
+	})
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
+		}
+		return routes[i].methods < routes[j].methods
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHODS\tPATH")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%s\t%s\n", route.methods, route.path)
+	}
+	w.Flush()
+	return 0
+}
+
+// createKey creates an API key through the admin API of a running gateway
+func createKey(args []string) int {
+	flags := flag.NewFlagSet("keys create", flag.ExitOnError)
+	addr := flags.String("addr", "", "Gateway base URL (default http://localhost:$PORT)")
+	name := flags.String("name", "", "Key name")
+	userID := flags.String("user-id", "", "User ID the key belongs to")
+	roles := flags.String("roles", "user", "Comma-separated roles")
+	rateLimit := flags.Int("rate-limit", 0, "Requests per minute (0 for unlimited)")
+	plan := flags.String("plan", "", "Plan selecting the key's bandwidth limit and product quotas")
+	products := flags.String("products", "", "Comma-separated API products to subscribe to")
+	expiresIn := flags.String("expires-in", "", "Key lifetime, e.g. '720h' (empty for no expiry)")
+	flags.Parse(args)
+
+	if *name == "" || *userID == "" {
+		fmt.Fprintln(os.Stderr, "keys create: -name and -user-id are required")
+		return 2
+	}
+
+	request := handlers.CreateAPIKeyRequest{
+		Name:      *name,
+		UserID:    *userID,
+		Roles:     splitList(*roles),
+		RateLimit: *rateLimit,
+		Plan:      *plan,
+		Products:  splitList(*products),
+		ExpiresIn: *expiresIn,
+	}
+	return callAdminAPI(*addr, http.MethodPost, "/api/keys", request)
+}
+
+// revokeKey revokes an API key through the admin API of a running gateway
+func revokeKey(args []string) int {
+	flags := flag.NewFlagSet("keys revoke", flag.ExitOnError)
+	addr := flags.String("addr", "", "Gateway base URL (default http://localhost:$PORT)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "keys revoke: exactly one key is required")
+		return 2
+	}
+	return callAdminAPI(*addr, http.MethodPost, "/api/keys/"+flags.Arg(0)+"/revoke", nil)
+}
+
+// restoreKey restores a deleted API key through the admin API of a running gateway
+func restoreKey(args []string) int {
+	flags := flag.NewFlagSet("keys restore", flag.ExitOnError)
+	addr := flags.String("addr", "", "Gateway base URL (default http://localhost:$PORT)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "keys restore: exactly one key is required")
+		return 2
+	}
+	return callAdminAPI(*addr, http.MethodPost, "/api/keys/"+flags.Arg(0)+"/restore", nil)
+}
+
+// generateToken prints a JWT signed with the configured secret
+func generateToken(args []string) int {
+	flags := flag.NewFlagSet("token generate", flag.ExitOnError)
+	userID := flags.String("user-id", "", "Subject user ID")
+	username := flags.String("username", "", "Username")
+	email := flags.String("email", "", "Email")
+	roles := flags.String("roles", "user", "Comma-separated roles")
+	flags.Parse(args)
+
+	if *userID == "" || *username == "" {
+		fmt.Fprintln(os.Stderr, "token generate: -user-id and -username are required")
+		return 2
+	}
+
+	token, err := newJWTManager().GenerateToken(*userID, *username, *email, splitList(*roles))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate token: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(token)
+	return 0
+}
+
+// runLoadTest sends synthetic traffic to a gateway and prints a summary
+func runLoadTest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	addr := flags.String("addr", "", "Gateway base URL (default http://localhost:$PORT)")
+	paths := flags.String("paths", "GET /health", "Comma-separated path mix, e.g. 'GET /api/profile=3,POST /api/orders=1'")
+	rps := flags.Float64("rps", 50, "Requests started per second (0 for as fast as possible)")
+	concurrency := flags.Int("concurrency", 10, "Requests in flight at most")
+	duration := flags.Duration("duration", 30*time.Second, "How long to send requests")
+	timeout := flags.Duration("timeout", 10*time.Second, "Per-request timeout")
+	authMode := flags.String("auth", "none", "Authentication: none, jwt or apikey")
+	users := flags.Int("users", 1, "Distinct users to spread jwt requests over")
+	roles := flags.String("roles", "user", "Comma-separated roles of jwt users")
+	apiKeys := flags.String("api-keys", "", "Comma-separated API keys to spread apikey requests over")
+	asJSON := flags.Bool("json", false, "Print the result as JSON")
+	flags.Parse(args)
+
+	targets, err := loadtest.ParseTargets(*paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		return 2
+	}
+	if *concurrency <= 0 || *duration <= 0 || *rps < 0 || *users <= 0 {
+		fmt.Fprintln(os.Stderr, "loadtest: -concurrency, -duration and -users must be positive and -rps must not be negative")
+		return 2
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if *addr == "" {
+		*addr = "http://localhost:" + cfg.Server.Port
+	}
+
+	loadConfig := &loadtest.Config{
+		BaseURL:     *addr,
+		Targets:     targets,
+		RPS:         *rps,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Timeout:     *timeout,
+		Users:       *users,
+	}
+	switch *authMode {
+	case "none":
+	case "jwt":
+		// Tokens are signed with the configured secret, one per synthetic user
+		jwtManager := auth.NewJWTManager(cfg.JWT.Secret, cfg.JWT.Issuer, cfg.JWT.Audience, cfg.JWT.Expiry)
+		headers := make([]http.Header, *users)
+		for i := range headers {
+			id := fmt.Sprintf("loadtest-%d", i)
+			token, err := jwtManager.GenerateToken(id, id, "", splitList(*roles))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to generate token: %v\n", err)
+				return 1
+			}
+			headers[i] = http.Header{"Authorization": {"Bearer " + token}}
+		}
+		loadConfig.Credentials = func(user int) http.Header { return headers[user] }
+	case "apikey":
+		keys := splitList(*apiKeys)
+		if len(keys) == 0 {
+			fmt.Fprintln(os.Stderr, "loadtest: -api-keys is required with -auth apikey")
+			return 2
+		}
+		loadConfig.Users = len(keys)
+		loadConfig.Credentials = func(user int) http.Header { return http.Header{"X-Api-Key": {keys[user]}} }
+	default:
+		fmt.Fprintln(os.Stderr, "loadtest: -auth must be none, jwt or apikey")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Sending traffic to %s for %s (Ctrl-C to stop early)\n", *addr, *duration)
+	result := loadtest.Run(ctx, loadConfig)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else {
+		printLoadTestResult(result)
+	}
+	if result.Requests == 0 || result.Errors == result.Requests {
+		return 1
+	}
+	return 0
+}
+
+// printLoadTestResult prints a load test summary as tables
+func printLoadTestResult(result *loadtest.Result) {
+	fmt.Printf("Requests: %d in %.1fs (%.1f/s), %d dropped, %d rate limited, %d errors\n",
+		result.Requests, result.Duration, result.RPS, result.Dropped, result.RateLimited, result.Errors)
+	for _, msg := range result.ErrorSample {
+		fmt.Printf("  error: %s\n", msg)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tREQUESTS\tSTATUSES\tMEAN\tP50\tP90\tP99\tMAX")
+	row := func(method, path string, requests int, statuses map[string]int, latency loadtest.Latency) {
+		codes := make([]string, 0, len(statuses))
+		for code, count := range statuses {
+			codes = append(codes, fmt.Sprintf("%s=%d", code, count))
+		}
+		sort.Strings(codes)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n", method, path, requests,
+			strings.Join(codes, " "), latency.Mean, latency.P50, latency.P90, latency.P99, latency.Max)
+	}
+	for _, target := range result.Targets {
+		row(target.Method, target.Path, target.Requests, target.Statuses, target.Latency)
+	}
+	row("", "TOTAL", result.Requests, result.Statuses, result.Latency)
+	w.Flush()
+}
+
+// callAdminAPI sends an authenticated request to a running gateway and prints the response.
+// It authenticates with a short-lived admin token signed with the configured JWT secret.
+func callAdminAPI(addr, method, path string, payload interface{}) int {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if addr == "" {
+		addr = "http://localhost:" + cfg.Server.Port
+	}
+
+	token, err := newJWTManager().GenerateToken("cli", "cli", "", []string{"admin"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate admin token: %v\n", err)
+		return 1
+	}
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode request: %v\n", err)
+			return 1
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+path, body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid gateway address: %v\n", err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reach gateway: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, respBody, "", "  ") == nil {
+		respBody = pretty.Bytes()
+	}
+
+	if resp.StatusCode >= 400 {
+		fmt.Fprintf(os.Stderr, "Gateway returned %s\n%s\n", resp.Status, respBody)
+		return 1
+	}
+	fmt.Println(string(respBody))
+	return 0
+}
+
+// newJWTManager creates a JWT manager from the loaded configuration
+func newJWTManager() *auth.JWTManager {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	return auth.NewJWTManager(cfg.JWT.Secret, cfg.JWT.Issuer, cfg.JWT.Audience, cfg.JWT.Expiry)
+}
+
+// splitList splits a comma-separated flag value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,374 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config represents multi-instance coordination configuration
+type Config struct {
+	InstanceID        string        // Unique per replica; generated from the hostname when empty
+	ConfigVersion     string        // Fingerprint of this instance's configuration
+	Prefix            string        // Redis key namespace shared by all replicas
+	LeaseTTL          time.Duration // How long leadership survives without renewal
+	HeartbeatInterval time.Duration // How often leadership is renewed and membership refreshed
+	CleanupInterval   time.Duration // How often the leader prunes departed members
+}
+
+// Member describes one gateway instance registered with the cluster
+type Member struct {
+	ID            string    `json:"id"`
+	ConfigVersion string    `json:"config_version"`
+	StartedAt     time.Time `json:"started_at"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+// JobRun records the latest run of a leader job
+type JobRun struct {
+	Name     string          `json:"name"`
+	Instance string          `json:"instance"`
+	RanAt    time.Time       `json:"ran_at"`
+	Duration string          `json:"duration"`
+	Error    string          `json:"error,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+}
+
+// Status describes the cluster as seen by this instance
+type Status struct {
+	InstanceID           string    `json:"instance_id"`
+	Leader               string    `json:"leader"`
+	IsLeader             bool      `json:"is_leader"`
+	ConfigVersion        string    `json:"config_version"`
+	ClusterConfigVersion string    `json:"cluster_config_version"`
+	ConfigInSync         bool      `json:"config_in_sync"`
+	Members              []*Member `json:"members"`
+	Jobs                 []*JobRun `json:"jobs"`
+}
+
+// Job is background work that must run on exactly one instance. The result
+// is recorded with the run so every replica can report it.
+type Job func(ctx context.Context) (any, error)
+
+// Coordinator elects a leader among gateway replicas through a Redis lease
+// and runs leader-only background jobs
+type Coordinator struct {
+	client    *redis.Client
+	config    *Config
+	startedAt time.Time
+
+	mu             sync.RWMutex
+	leader         string
+	clusterVersion string
+
+	leaderGauge  *metrics.GaugeVec
+	membersGauge *metrics.GaugeVec
+	driftGauge   *metrics.GaugeVec
+}
+
+// electScript takes the lease when it is free and renews it when held by the
+// caller, returning the current leader
+var electScript = redis.NewScript(`
+	local current = redis.call('GET', KEYS[1])
+	if not current then
+		redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+		return ARGV[1]
+	end
+	if current == ARGV[1] then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	end
+	return current
+`)
+
+// New creates a coordinator, registers the built-in cleanup job and starts
+// taking part in leader election
+func New(client *redis.Client, config *Config, reg *metrics.Registry) *Coordinator {
+	if config.InstanceID == "" {
+		config.InstanceID = generateInstanceID()
+	}
+
+	c := &Coordinator{
+		client:    client,
+		config:    config,
+		startedAt: time.Now(),
+		leaderGauge: reg.NewGaugeVec("gateway_cluster_leader",
+			"Whether this instance is the cluster leader (1) or not (0).", "instance"),
+		membersGauge: reg.NewGaugeVec("gateway_cluster_members",
+			"Live gateway instances seen in the cluster.", "instance"),
+		driftGauge: reg.NewGaugeVec("gateway_cluster_config_drift",
+			"Whether this instance's configuration differs from the leader's (1) or not (0).", "instance"),
+	}
+
+	c.heartbeat()
+	go c.heartbeatRoutine()
+	c.RunAsLeader("cleanup", config.CleanupInterval, c.pruneMembers)
+
+	return c
+}
+
+// InstanceID returns this instance's identifier
+func (c *Coordinator) InstanceID() string {
+	return c.config.InstanceID
+}
+
+// IsLeader reports whether this instance currently holds the leader lease
+func (c *Coordinator) IsLeader() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.leader == c.config.InstanceID
+}
+
+// RunAsLeader runs the job every interval on whichever instance is leader
+func (c *Coordinator) RunAsLeader(name string, interval time.Duration, job Job) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !c.IsLeader() {
+				continue
+			}
+			c.runJob(name, interval, job)
+		}
+	}()
+}
+
+// Status returns the leader, live members, configuration versions and the
+// latest run of each leader job
+func (c *Coordinator) Status(ctx context.Context) (*Status, error) {
+	members, err := c.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*JobRun{}
+	records, err := c.client.HGetAll(ctx, c.key("jobs")).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range records {
+		var run JobRun
+		if json.Unmarshal([]byte(data), &run) == nil {
+			jobs = append(jobs, &run)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return &Status{
+		InstanceID:           c.config.InstanceID,
+		Leader:               c.leader,
+		IsLeader:             c.leader == c.config.InstanceID,
+		ConfigVersion:        c.config.ConfigVersion,
+		ClusterConfigVersion: c.clusterVersion,
+		ConfigInSync:         c.clusterVersion == "" || c.clusterVersion == c.config.ConfigVersion,
+		Members:              members,
+		Jobs:                 jobs,
+	}, nil
+}
+
+// heartbeatRoutine renews leadership and membership until the process exits
+func (c *Coordinator) heartbeatRoutine() {
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.heartbeat()
+	}
+}
+
+// heartbeat takes part in leader election, refreshes this instance's
+// membership and compares its configuration with the leader's
+func (c *Coordinator) heartbeat() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.HeartbeatInterval)
+	defer cancel()
+
+	id := c.config.InstanceID
+	leader, err := electScript.Run(ctx, c.client, []string{c.key("leader")}, id, c.config.LeaseTTL.Milliseconds()).Text()
+	if err != nil {
+		// Without Redis the lease cannot be renewed, so step down rather than
+		// risk a second leader once it expires
+		log.Printf("Cluster heartbeat failed: %v", err)
+		c.setLeader("")
+		return
+	}
+	c.setLeader(leader)
+
+	member, _ := json.Marshal(&Member{
+		ID:            id,
+		ConfigVersion: c.config.ConfigVersion,
+		StartedAt:     c.startedAt,
+		LastSeen:      time.Now(),
+	})
+	if err := c.client.HSet(ctx, c.key("members"), id, member).Err(); err != nil {
+		log.Printf("Cluster heartbeat failed: %v", err)
+	}
+
+	// The leader publishes its configuration version for the others to match
+	clusterVersion := c.config.ConfigVersion
+	if leader == id {
+		err = c.client.Set(ctx, c.key("config_version"), clusterVersion, 0).Err()
+	} else {
+		clusterVersion, err = c.client.Get(ctx, c.key("config_version")).Result()
+		if err == redis.Nil {
+			clusterVersion, err = "", nil
+		}
+	}
+	if err != nil {
+		log.Printf("Cluster heartbeat failed: %v", err)
+	} else {
+		c.setClusterVersion(clusterVersion)
+	}
+
+	if members, err := c.members(ctx); err == nil {
+		c.membersGauge.Set(float64(len(members)), id)
+	}
+}
+
+// setLeader records the current leader and logs leadership changes
+func (c *Coordinator) setLeader(leader string) {
+	id := c.config.InstanceID
+
+	c.mu.Lock()
+	previous := c.leader
+	c.leader = leader
+	c.mu.Unlock()
+
+	if previous != leader {
+		switch {
+		case leader == id:
+			log.Printf("Cluster: instance %s became leader", id)
+		case previous == id:
+			log.Printf("Cluster: instance %s is no longer leader", id)
+		}
+	}
+
+	value := 0.0
+	if leader == id {
+		value = 1
+	}
+	c.leaderGauge.Set(value, id)
+}
+
+// setClusterVersion records the leader's configuration version and logs drift
+func (c *Coordinator) setClusterVersion(version string) {
+	c.mu.Lock()
+	previous := c.clusterVersion
+	c.clusterVersion = version
+	c.mu.Unlock()
+
+	drift := version != "" && version != c.config.ConfigVersion
+	if drift && version != previous {
+		log.Printf("Cluster: configuration version %s differs from the leader's %s", c.config.ConfigVersion, version)
+	}
+
+	value := 0.0
+	if drift {
+		value = 1
+	}
+	c.driftGauge.Set(value, c.config.InstanceID)
+}
+
+// members returns the instances that sent a heartbeat recently
+func (c *Coordinator) members(ctx context.Context) ([]*Member, error) {
+	records, err := c.client.HGetAll(ctx, c.key("members")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	members := []*Member{}
+	for _, data := range records {
+		var member Member
+		if json.Unmarshal([]byte(data), &member) == nil && !c.departed(&member) {
+			members = append(members, &member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	return members, nil
+}
+
+// departed reports whether a member has missed enough heartbeats to be gone
+func (c *Coordinator) departed(member *Member) bool {
+	return time.Since(member.LastSeen) > 3*c.config.HeartbeatInterval
+}
+
+// pruneMembers removes departed instances from the membership list
+func (c *Coordinator) pruneMembers(ctx context.Context) (any, error) {
+	records, err := c.client.HGetAll(ctx, c.key("members")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := 0
+	for id, data := range records {
+		var member Member
+		if json.Unmarshal([]byte(data), &member) != nil || c.departed(&member) {
+			if err := c.client.HDel(ctx, c.key("members"), id).Err(); err != nil {
+				return nil, err
+			}
+			removed++
+		}
+	}
+
+	return map[string]int{"members_removed": removed}, nil
+}
+
+// runJob runs a leader job and records the outcome for all instances
+func (c *Coordinator) runJob(name string, timeout time.Duration, job Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := job(ctx)
+
+	run := &JobRun{
+		Name:     name,
+		Instance: c.config.InstanceID,
+		RanAt:    start,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Cluster job %s failed: %v", name, err)
+	}
+	if result != nil {
+		run.Result, _ = json.Marshal(result)
+	}
+
+	data, _ := json.Marshal(run)
+	if err := c.client.HSet(ctx, c.key("jobs"), name, data).Err(); err != nil {
+		log.Printf("Cluster job %s: failed to record run: %v", name, err)
+	}
+}
+
+// key namespaces cluster keys
+func (c *Coordinator) key(name string) string {
+	return c.config.Prefix + name
+}
+
+// generateInstanceID derives a unique instance identifier from the hostname
+func generateInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gateway"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", hostname, hex.EncodeToString(suffix))
+}
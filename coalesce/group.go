@@ -0,0 +1,64 @@
+package coalesce
+
+import (
+	"sync"
+)
+
+// call represents an in-flight or completed execution for a key
+type call struct {
+	wg     sync.WaitGroup
+	result *Response
+	dups   int
+}
+
+// Group deduplicates concurrent executions that share a key
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// NewGroup creates a new coalescing group
+func NewGroup() *Group {
+	return &Group{
+		calls: make(map[string]*call),
+	}
+}
+
+// Do executes fn once for all concurrent callers with the same key.
+// The shared return value reports whether the result was given to more than one caller.
+func (g *Group) Do(key string, fn func() *Response) (result *Response, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.result, true
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.result = fn()
+
+	g.mu.Lock()
+	shared = c.dups > 0
+	g.mu.Unlock()
+
+	return c.result, shared
+}
+
+// InFlight returns the number of keys currently being executed
+func (g *Group) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
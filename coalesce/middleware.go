@@ -0,0 +1,139 @@
+package coalesce
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/proxy"
+)
+
+// Response is a fully buffered response shared between coalesced requests
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Config represents request coalescing configuration
+type Config struct {
+	PathPrefixes []string `json:"path_prefixes"` // Empty means all GET routes
+}
+
+// Coalescer shares one handler execution between identical in-flight GETs
+type Coalescer struct {
+	config    *Config
+	group     *Group
+	coalesced *metrics.CounterVec
+}
+
+// NewCoalescer creates a new request coalescer
+func NewCoalescer(config *Config, reg *metrics.Registry) *Coalescer {
+	return &Coalescer{
+		config: config,
+		group:  NewGroup(),
+		coalesced: reg.NewCounterVec("gateway_coalesced_requests_total",
+			"GET requests served from a shared in-flight execution", "route"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (c *Coalescer) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !c.applies(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, shared := c.group.Do(requestKey(r), func() *Response {
+				rec := &recorder{header: make(http.Header), statusCode: http.StatusOK}
+				next.ServeHTTP(rec, r)
+				return &Response{
+					StatusCode: rec.statusCode,
+					Header:     rec.header,
+					Body:       rec.body.Bytes(),
+				}
+			})
+
+			if result == nil {
+				http.Error(w, `{"error":"Internal server error","details":"Shared request failed"}`, http.StatusInternalServerError)
+				return
+			}
+			if shared {
+				c.coalesced.Inc(metrics.RouteLabel(r))
+				httputil.MarkCacheHit(r)
+			}
+
+			for name, values := range result.Header {
+				w.Header()[name] = append([]string(nil), values...)
+			}
+			w.WriteHeader(result.StatusCode)
+			w.Write(result.Body)
+		})
+	}
+}
+
+// applies reports whether coalescing is enabled for the path
+func (c *Coalescer) applies(path string) bool {
+	if len(c.config.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.config.PathPrefixes {
+		if proxy.HasPathPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestKey identifies requests that may share a response. Credentials,
+// client certificates included, are part of the key so responses are never
+// shared between different callers, and so is the requested byte range.
+func requestKey(r *http.Request) string {
+	h := sha256.New()
+	for _, part := range []string{
+		r.URL.RequestURI(),
+		r.Header.Get("Authorization"),
+		r.Header.Get("X-API-Key"),
+		r.Header.Get("Cookie"),
+		httputil.ClientCertificate(r),
+		r.Header.Get("Accept"),
+		r.Header.Get("Accept-Language"),
+		r.Header.Get("Range"),
+		r.Header.Get("If-Range"),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder buffers a response so it can be replayed to every waiter
+type recorder struct {
+	header      http.Header
+	statusCode  int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.statusCode = code
+	}
+}
+
+func (rec *recorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.body.Write(data)
+}
@@ -0,0 +1,80 @@
+package coalesce
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// withCertificate returns a request authenticated by a client certificate with the given DER encoding
+func withCertificate(r *http.Request, der string) *http.Request {
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Raw: []byte(der)}}}}
+	return r
+}
+
+func TestCoalesceSeparatesClientCertificates(t *testing.T) {
+	c := NewCoalescer(&Config{}, metrics.NewRegistry())
+	entered := make(chan string, 2)
+	release := make(chan struct{})
+	handler := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- string(r.TLS.VerifiedChains[0][0].Raw)
+		<-release
+		w.Write(r.TLS.VerifiedChains[0][0].Raw)
+	}))
+
+	bodies := make(chan string, 2)
+	for _, device := range []string{"device-1", "device-2"} {
+		go func() {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, withCertificate(httptest.NewRequest("GET", "/api/telemetry", nil), device))
+			bodies <- device + ":" + w.Body.String()
+		}()
+	}
+
+	// Both devices reach the upstream rather than one waiting for the other's response
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered:
+		case <-time.After(2 * time.Second):
+			t.Fatal("request of the second device was coalesced with the first")
+		}
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		got := <-bodies
+		if got != "device-1:device-1" && got != "device-2:device-2" {
+			t.Errorf("response %q served to another device", got)
+		}
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	get := func() *http.Request { return httptest.NewRequest("GET", "/api/telemetry", nil) }
+	plain := requestKey(get())
+	first := requestKey(withCertificate(get(), "device-1"))
+	if first == plain || first == requestKey(withCertificate(get(), "device-2")) {
+		t.Error("client certificates are not part of the key")
+	}
+	if first != requestKey(withCertificate(get(), "device-1")) {
+		t.Error("requests of one device have different keys")
+	}
+}
+
+func TestAppliesOnSegmentBoundaries(t *testing.T) {
+	c := NewCoalescer(&Config{PathPrefixes: []string{"/api/telemetry"}}, metrics.NewRegistry())
+	for path, want := range map[string]bool{
+		"/api/telemetry":        true,
+		"/api/telemetry/device": true,
+		"/api/telemetryx":       false,
+		"/api/tele":             false,
+	} {
+		if got := c.applies(path); got != want {
+			t.Errorf("applies(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
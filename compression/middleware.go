@@ -0,0 +1,259 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Config represents compression middleware configuration
+type Config struct {
+	MinSize      int      `json:"min_size"`
+	Level        int      `json:"level"`
+	ContentTypes []string `json:"content_types"` // Supports "type/*" wildcards
+	Encodings    []string `json:"encodings"`     // Preferred order, e.g. "br", "gzip"
+}
+
+// Middleware returns a middleware that compresses responses according to Accept-Encoding
+func Middleware(config *Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiate(r.Header.Get("Accept-Encoding"), config.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				config:         config,
+				encoding:       encoding,
+				statusCode:     http.StatusOK,
+			}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate picks the preferred supported encoding accepted by the client
+func negotiate(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the response until it knows whether compression applies
+type compressWriter struct {
+	http.ResponseWriter
+	config     *Config
+	encoding   string
+	statusCode int
+
+	buf         bytes.Buffer
+	encoder     io.WriteCloser
+	decided     bool // Whether the compress/passthrough decision has been made
+	passthrough bool
+	wroteHeader bool
+}
+
+// WriteHeader records the status code; headers are sent once the body decision is made
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.statusCode = code
+
+	// Responses without a body are never compressed
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+// Write buffers data until the minimum size is reached
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.passthrough {
+			return cw.ResponseWriter.Write(data)
+		}
+		return cw.encoder.Write(data)
+	}
+
+	cw.buf.Write(data)
+	if cw.buf.Len() >= cw.config.MinSize {
+		if err := cw.decide(cw.compressible()); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush forces a decision and flushes any buffered data to the client
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(cw.buf.Len() >= cw.config.MinSize && cw.compressible())
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack allows protocol upgrades to bypass compression
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Close finishes the response, writing small bodies uncompressed
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// Nothing was written; let the server send its defaults
+			return nil
+		}
+		cw.decide(cw.buf.Len() >= cw.config.MinSize && cw.compressible())
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+// compressible reports whether the response headers allow compression
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+
+	// Byte ranges are of the representation the upstream sent
+	if cw.statusCode == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	// Passthrough when the handler or upstream already encoded the body
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	if strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(cw.buf.Bytes())
+	}
+	return matchContentType(contentType, cw.config.ContentTypes)
+}
+
+// decide commits to compressing or passing through, sends headers and flushes the buffer
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	cw.passthrough = !compress
+
+	if compress {
+		header := cw.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// Ranges of the compressed body cannot be requested from the upstream
+		header.Del("Accept-Ranges")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = newEncoder(cw.encoding, cw.ResponseWriter, cw.config.Level)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if compress {
+		_, err = cw.encoder.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// newEncoder creates a compressor for the negotiated encoding
+func newEncoder(encoding string, w io.Writer, level int) io.WriteCloser {
+	switch encoding {
+	case "br":
+		if level > brotli.BestCompression {
+			level = brotli.BestCompression
+		}
+		return brotli.NewWriterLevel(w, level)
+	default:
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			gz = gzip.NewWriter(w)
+		}
+		return gz
+	}
+}
+
+// matchContentType reports whether contentType matches one of the allowed patterns
+func matchContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+package conditional
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// Config represents conditional request handling configuration
+type Config struct {
+	PathPrefixes []string // Empty means every route the middleware wraps
+	WeakETags    bool     // Generate W/ ETags, for upstreams whose bodies vary in insignificant ways
+	MaxBodySize  int      // Largest body buffered to generate an ETag; larger ones only get Last-Modified checks
+}
+
+// Validator answers conditional GET and HEAD requests (RFC 9110) on behalf
+// of upstreams. Responses keep the ETag their upstream gave them, and those
+// without one get an ETag hashed from their body. Requests whose
+// If-None-Match or If-Modified-Since still match are answered with 304 Not
+// Modified and no body, so unchanged resources are not sent again; behind
+// the response cache, they do not reach the upstream at all.
+type Validator struct {
+	config *Config
+
+	requests *metrics.CounterVec
+}
+
+// NewValidator creates a conditional request handler
+func NewValidator(config *Config, reg *metrics.Registry) *Validator {
+	return &Validator{
+		config: config,
+		requests: reg.NewCounterVec("gateway_conditional_requests_total",
+			"Conditional GET and HEAD requests, by result (not_modified or modified).", "result"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (v *Validator) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !v.applies(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			vw := &validatingWriter{
+				ResponseWriter: w,
+				validator:      v,
+				request:        r,
+			}
+			defer vw.Close()
+			next.ServeHTTP(vw, r)
+		})
+	}
+}
+
+// applies reports whether the request is handled
+func (v *Validator) applies(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if len(v.config.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range v.config.PathPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns the ETag generated for a body
+func (v *Validator) etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if v.config.WeakETags {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// notModified reports whether the request's preconditions show the client
+// already has the response. If-Modified-Since is only evaluated without
+// If-None-Match, and only against a Last-Modified header.
+func notModified(r *http.Request, header http.Header) bool {
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, value := range values {
+			for _, candidate := range strings.Split(value, ",") {
+				candidate = strings.TrimSpace(candidate)
+				if candidate == "*" || weakMatch(candidate, etag) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// weakMatch compares two entity tags ignoring their weakness, as
+// If-None-Match requires. Compression at the gateway weakens strong ETags,
+// so clients send back weak versions of them.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// validatingWriter holds back successful responses until it knows whether
+// the client already has them. Responses with an ETag are decided on their
+// headers alone; the others are buffered to hash their body, up to
+// MaxBodySize.
+type validatingWriter struct {
+	http.ResponseWriter
+	validator *Validator
+	request   *http.Request
+
+	statusCode  int
+	wroteHeader bool
+	buffering   bool // Holding the body back to hash it
+	buf         bytes.Buffer
+	discard     bool // The client got a 304 and the body is dropped
+}
+
+// WriteHeader decides right away unless the body must be hashed first
+func (vw *validatingWriter) WriteHeader(code int) {
+	if vw.wroteHeader {
+		return
+	}
+	vw.wroteHeader = true
+	vw.statusCode = code
+
+	header := vw.Header()
+	if code != http.StatusOK || header.Get("ETag") != "" || !vw.hashable(header) {
+		vw.decide()
+		return
+	}
+	vw.buffering = true
+}
+
+// hashable reports whether an ETag can be generated from the body
+func (vw *validatingWriter) hashable(header http.Header) bool {
+	if vw.request.Method == http.MethodHead || vw.validator.config.MaxBodySize <= 0 {
+		return false
+	}
+	// Streams, such as server-sent events, are never buffered
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length > vw.validator.config.MaxBodySize {
+		return false
+	}
+	return true
+}
+
+// Write buffers the body while it may still be hashed
+func (vw *validatingWriter) Write(data []byte) (int, error) {
+	if !vw.wroteHeader {
+		vw.WriteHeader(http.StatusOK)
+	}
+	if vw.discard {
+		return len(data), nil
+	}
+	if vw.buffering {
+		if vw.buf.Len()+len(data) <= vw.validator.config.MaxBodySize {
+			return vw.buf.Write(data)
+		}
+		// Too large to hash; sent as is, with only Last-Modified checked
+		if err := vw.release(); err != nil {
+			return 0, err
+		}
+	}
+	return vw.ResponseWriter.Write(data)
+}
+
+// Flush gives up hashing, since the handler wants the body sent now
+func (vw *validatingWriter) Flush() {
+	if vw.buffering {
+		vw.release()
+	}
+	if vw.discard {
+		return
+	}
+	if flusher, ok := vw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports handlers taking over the connection
+func (vw *validatingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := vw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Close tags a buffered body with its ETag and sends it, unless the client
+// already has it
+func (vw *validatingWriter) Close() {
+	if !vw.buffering {
+		return
+	}
+	vw.buffering = false
+	vw.Header().Set("ETag", vw.validator.etag(vw.buf.Bytes()))
+	vw.decide()
+	if !vw.discard {
+		vw.ResponseWriter.Write(vw.buf.Bytes())
+	}
+}
+
+// release sends a buffered response without generating an ETag
+func (vw *validatingWriter) release() error {
+	vw.buffering = false
+	vw.decide()
+	if vw.discard || vw.buf.Len() == 0 {
+		return nil
+	}
+	_, err := vw.ResponseWriter.Write(vw.buf.Bytes())
+	return err
+}
+
+// decide sends a 304 if the client already has the response, or the
+// response headers otherwise
+func (vw *validatingWriter) decide() {
+	r := vw.request
+	conditional := r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+	if vw.statusCode != http.StatusOK || !conditional {
+		vw.ResponseWriter.WriteHeader(vw.statusCode)
+		return
+	}
+	if !notModified(r, vw.Header()) {
+		vw.validator.requests.Inc("modified")
+		vw.ResponseWriter.WriteHeader(vw.statusCode)
+		return
+	}
+
+	vw.validator.requests.Inc("not_modified")
+	vw.discard = true
+	// As net/http does for 304s, drop the headers describing the body
+	header := vw.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	if header.Get("ETag") != "" {
+		header.Del("Last-Modified")
+	}
+	vw.ResponseWriter.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"time"
+)
+
+// AccessLogConfig represents access logging to one or more sinks
+type AccessLogConfig struct {
+	Enabled       bool          `json:"enabled"`
+	Sinks         []string      `json:"sinks"`       // "stdout", "file", "syslog", "kafka" and/or "loki"
+	BufferSize    int           `json:"buffer_size"` // Entries queued per sink before new ones are dropped
+	BatchSize     int           `json:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval"`
+	WriteTimeout  time.Duration `json:"write_timeout"`
+	MaxAttempts   int           `json:"max_attempts"` // Writes of a batch before it is dropped
+
+	File            string        `json:"file"`
+	FileMaxSize     int64         `json:"file_max_size"`        // Bytes before rotating; 0 disables
+	FileRotateEvery time.Duration `json:"file_rotate_interval"` // 0 disables
+	FileMaxBackups  int           `json:"file_max_backups"`     // 0 keeps every rotated file
+
+	SyslogNetwork  string `json:"syslog_network"` // "udp", "tcp", "unix" or "unixgram"
+	SyslogAddress  string `json:"syslog_address"`
+	SyslogFacility string `json:"syslog_facility"` // "user", "daemon" or "local0" to "local7"
+	SyslogTag      string `json:"syslog_tag"`
+
+	KafkaRESTURL  string `json:"kafka_rest_url"` // Confluent REST Proxy compatible endpoint
+	KafkaTopic    string `json:"kafka_topic"`
+	KafkaUsername string `json:"kafka_username"`
+	KafkaPassword string `json:"kafka_password"`
+
+	LokiURL      string            `json:"loki_url"`
+	LokiLabels   map[string]string `json:"loki_labels"`
+	LokiTenant   string            `json:"loki_tenant"` // Sent as X-Scope-OrgID
+	LokiUsername string            `json:"loki_username"`
+	LokiPassword string            `json:"loki_password"`
+}
+
+// DefaultAccessLogConfig returns default access log configuration
+func DefaultAccessLogConfig() *AccessLogConfig {
+	return &AccessLogConfig{
+		Enabled:         false,
+		Sinks:           []string{"stdout"},
+		BufferSize:      10000,
+		BatchSize:       500,
+		FlushInterval:   time.Second,
+		WriteTimeout:    5 * time.Second,
+		MaxAttempts:     3,
+		File:            "access.log",
+		FileMaxSize:     100 * 1024 * 1024,
+		FileRotateEvery: 24 * time.Hour,
+		FileMaxBackups:  7,
+		SyslogNetwork:   "udp",
+		SyslogAddress:   "localhost:514",
+		SyslogFacility:  "local0",
+		SyslogTag:       "api-gateway",
+		KafkaTopic:      "gateway-access-log",
+		LokiLabels:      map[string]string{"job": "api-gateway"},
+	}
+}
+
+// LoadAccessLogConfig loads access log configuration from environment
+func LoadAccessLogConfig() *AccessLogConfig {
+	config := DefaultAccessLogConfig()
+
+	config.Enabled = getEnvBool("ACCESS_LOG_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Sinks = getEnvList("ACCESS_LOG_SINKS", config.Sinks)
+	config.BufferSize = getEnvInt("ACCESS_LOG_BUFFER_SIZE", config.BufferSize)
+	config.BatchSize = getEnvInt("ACCESS_LOG_BATCH_SIZE", config.BatchSize)
+	config.FlushInterval = getEnvDuration("ACCESS_LOG_FLUSH_INTERVAL", config.FlushInterval)
+	config.WriteTimeout = getEnvDuration("ACCESS_LOG_WRITE_TIMEOUT", config.WriteTimeout)
+	config.MaxAttempts = getEnvInt("ACCESS_LOG_MAX_ATTEMPTS", config.MaxAttempts)
+
+	config.File = getEnvString("ACCESS_LOG_FILE", config.File)
+	config.FileMaxSize = int64(getEnvInt("ACCESS_LOG_FILE_MAX_SIZE", int(config.FileMaxSize)))
+	config.FileRotateEvery = getEnvDuration("ACCESS_LOG_FILE_ROTATE_INTERVAL", config.FileRotateEvery)
+	config.FileMaxBackups = getEnvInt("ACCESS_LOG_FILE_MAX_BACKUPS", config.FileMaxBackups)
+
+	config.SyslogNetwork = getEnvString("ACCESS_LOG_SYSLOG_NETWORK", config.SyslogNetwork)
+	config.SyslogAddress = getEnvString("ACCESS_LOG_SYSLOG_ADDRESS", config.SyslogAddress)
+	config.SyslogFacility = getEnvString("ACCESS_LOG_SYSLOG_FACILITY", config.SyslogFacility)
+	config.SyslogTag = getEnvString("ACCESS_LOG_SYSLOG_TAG", config.SyslogTag)
+
+	config.KafkaRESTURL = getEnvString("ACCESS_LOG_KAFKA_REST_URL", "")
+	config.KafkaTopic = getEnvString("ACCESS_LOG_KAFKA_TOPIC", config.KafkaTopic)
+	config.KafkaUsername = getEnvString("ACCESS_LOG_KAFKA_USERNAME", "")
+	config.KafkaPassword = getEnvString("ACCESS_LOG_KAFKA_PASSWORD", "")
+
+	config.LokiURL = getEnvString("ACCESS_LOG_LOKI_URL", "")
+	if getEnv("ACCESS_LOG_LOKI_LABELS") != "" {
+		config.LokiLabels = getEnvMap("ACCESS_LOG_LOKI_LABELS")
+	}
+	config.LokiTenant = getEnvString("ACCESS_LOG_LOKI_TENANT", "")
+	config.LokiUsername = getEnvString("ACCESS_LOG_LOKI_USERNAME", "")
+	config.LokiPassword = getEnvString("ACCESS_LOG_LOKI_PASSWORD", "")
+
+	return config
+}
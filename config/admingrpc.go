@@ -0,0 +1,41 @@
+package config
+
+import (
+	"time"
+)
+
+// AdminGRPCConfig represents the gRPC listener serving the admin API defined
+// in proto/admin/v1/admin.proto
+type AdminGRPCConfig struct {
+	Enabled       bool          `json:"enabled"`
+	Host          string        `json:"host"` // Bind address; empty listens on all interfaces
+	Port          string        `json:"port"`
+	TLS           bool          `json:"tls"`            // Serve gRPC over TLS with TLS_CERT_FILE and TLS_KEY_FILE
+	WatchInterval time.Duration `json:"watch_interval"` // How often watches check upstream health and replica configuration
+}
+
+// DefaultAdminGRPCConfig returns default gRPC admin API configuration
+func DefaultAdminGRPCConfig() *AdminGRPCConfig {
+	return &AdminGRPCConfig{
+		Enabled:       false,
+		Port:          "9090",
+		WatchInterval: 10 * time.Second,
+	}
+}
+
+// LoadAdminGRPCConfig loads gRPC admin API configuration from environment
+func LoadAdminGRPCConfig() *AdminGRPCConfig {
+	config := DefaultAdminGRPCConfig()
+
+	config.Enabled = getEnvBool("ADMIN_GRPC_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Host = getEnvString("ADMIN_GRPC_HOST", config.Host)
+	config.Port = getEnvString("ADMIN_GRPC_PORT", config.Port)
+	config.TLS = getEnvBool("ADMIN_GRPC_TLS", config.TLS)
+	config.WatchInterval = getEnvDuration("ADMIN_GRPC_WATCH_INTERVAL", config.WatchInterval)
+
+	return config
+}
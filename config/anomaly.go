@@ -0,0 +1,57 @@
+package config
+
+import "time"
+
+// AnomalyConfig represents detection of sudden changes in traffic patterns
+type AnomalyConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Dimensions     []string      `json:"dimensions"` // "route" and/or "consumer"
+	Interval       time.Duration `json:"interval"`
+	Alpha          float64       `json:"alpha"`     // Weight of the newest interval in the moving averages
+	Threshold      float64       `json:"threshold"` // Deviation in standard deviations that raises an alert
+	Warmup         int           `json:"warmup"`    // Intervals observed before a series can alert
+	MinRequests    int           `json:"min_requests"`
+	Cooldown       time.Duration `json:"cooldown"`
+	MaxSeries      int           `json:"max_series"`
+	WebhookURL     string        `json:"webhook_url"`
+	WebhookTimeout time.Duration `json:"webhook_timeout"`
+}
+
+// DefaultAnomalyConfig returns default anomaly detection configuration
+func DefaultAnomalyConfig() *AnomalyConfig {
+	return &AnomalyConfig{
+		Enabled:        false,
+		Dimensions:     []string{"route", "consumer"},
+		Interval:       time.Minute,
+		Alpha:          0.1,
+		Threshold:      4,
+		Warmup:         10,
+		MinRequests:    20,
+		Cooldown:       10 * time.Minute,
+		MaxSeries:      10000,
+		WebhookTimeout: 5 * time.Second,
+	}
+}
+
+// LoadAnomalyConfig loads anomaly detection configuration from environment
+func LoadAnomalyConfig() *AnomalyConfig {
+	config := DefaultAnomalyConfig()
+
+	config.Enabled = getEnvBool("ANOMALY_DETECTION_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Dimensions = getEnvList("ANOMALY_DIMENSIONS", config.Dimensions)
+	config.Interval = getEnvDuration("ANOMALY_INTERVAL", config.Interval)
+	config.Alpha = getEnvFloat("ANOMALY_ALPHA", config.Alpha)
+	config.Threshold = getEnvFloat("ANOMALY_THRESHOLD", config.Threshold)
+	config.Warmup = getEnvInt("ANOMALY_WARMUP", config.Warmup)
+	config.MinRequests = getEnvInt("ANOMALY_MIN_REQUESTS", config.MinRequests)
+	config.Cooldown = getEnvDuration("ANOMALY_COOLDOWN", config.Cooldown)
+	config.MaxSeries = getEnvInt("ANOMALY_MAX_SERIES", config.MaxSeries)
+	config.WebhookURL = getEnvString("ANOMALY_WEBHOOK_URL", "")
+	config.WebhookTimeout = getEnvDuration("ANOMALY_WEBHOOK_TIMEOUT", config.WebhookTimeout)
+
+	return config
+}
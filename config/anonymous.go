@@ -0,0 +1,53 @@
+package config
+
+import (
+	"time"
+)
+
+// AnonymousConfig represents the tier admitting unauthenticated requests to
+// designated routes under stricter limits
+type AnonymousConfig struct {
+	Enabled            bool          `json:"enabled"`
+	Paths              []string      `json:"paths"`               // Route prefixes open to unauthenticated requests
+	FingerprintHeaders []string      `json:"fingerprint_headers"` // Identify clients along with their IP
+	Capacity           int           `json:"capacity"`            // Rate limit burst per client
+	RefillRate         int           `json:"refill_rate"`         // Requests per second per client
+	Quota              int           `json:"quota"`               // Requests per client and quota window; zero disables the quota
+	QuotaWindow        time.Duration `json:"quota_window"`
+	UseRedis           bool          `json:"use_redis"`
+	Redis              RedisConfig   `json:"redis"`
+}
+
+// DefaultAnonymousConfig returns default anonymous tier configuration
+func DefaultAnonymousConfig() *AnonymousConfig {
+	return &AnonymousConfig{
+		Enabled:            false,
+		FingerprintHeaders: []string{"User-Agent", "Accept-Language"},
+		Capacity:           10,
+		RefillRate:         1,
+		Quota:              1000,
+		QuotaWindow:        24 * time.Hour,
+		UseRedis:           false,
+	}
+}
+
+// LoadAnonymousConfig loads anonymous tier configuration from environment
+func LoadAnonymousConfig() *AnonymousConfig {
+	config := DefaultAnonymousConfig()
+
+	config.Enabled = getEnvBool("ANONYMOUS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Paths = getEnvList("ANONYMOUS_PATHS", nil)
+	config.FingerprintHeaders = getEnvList("ANONYMOUS_FINGERPRINT_HEADERS", config.FingerprintHeaders)
+	config.Capacity = getEnvInt("ANONYMOUS_RATE_LIMIT_CAPACITY", config.Capacity)
+	config.RefillRate = getEnvInt("ANONYMOUS_RATE_LIMIT_REFILL_RATE", config.RefillRate)
+	config.Quota = getEnvInt("ANONYMOUS_QUOTA", config.Quota)
+	config.QuotaWindow = getEnvDuration("ANONYMOUS_QUOTA_WINDOW", config.QuotaWindow)
+	config.UseRedis = getEnvBool("ANONYMOUS_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
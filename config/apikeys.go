@@ -0,0 +1,34 @@
+package config
+
+import (
+	"time"
+)
+
+// APIKeyConfig represents API key management configuration
+type APIKeyConfig struct {
+	Retention       time.Duration `json:"retention"` // How long deleted keys can be restored before they are purged
+	UseRedis        bool          `json:"use_redis"` // Share keys between replicas through Redis
+	Redis           RedisConfig   `json:"redis"`
+	RefreshInterval time.Duration `json:"refresh_interval"` // How often keys changed by other replicas are picked up
+}
+
+// DefaultAPIKeyConfig returns default API key management configuration
+func DefaultAPIKeyConfig() *APIKeyConfig {
+	return &APIKeyConfig{
+		Retention:       30 * 24 * time.Hour,
+		UseRedis:        false,
+		RefreshInterval: 30 * time.Second,
+	}
+}
+
+// LoadAPIKeyConfig loads API key management configuration from environment
+func LoadAPIKeyConfig() *APIKeyConfig {
+	config := DefaultAPIKeyConfig()
+
+	config.Retention = getEnvDuration("API_KEY_RETENTION", config.Retention)
+	config.UseRedis = getEnvBool("API_KEY_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+	config.RefreshInterval = getEnvDuration("API_KEY_REFRESH_INTERVAL", config.RefreshInterval)
+
+	return config
+}
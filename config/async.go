@@ -0,0 +1,57 @@
+package config
+
+import (
+	"time"
+)
+
+// AsyncConfig represents queuing requests to async upstreams, whose results
+// callers poll for. Upstreams opt in with UPSTREAM_<NAME>_ASYNC.
+type AsyncConfig struct {
+	Enabled       bool          `json:"enabled"`
+	Workers       int           `json:"workers"`         // Jobs run at once by each gateway instance
+	QueueSize     int           `json:"queue_size"`      // Jobs waiting at most
+	MaxBodySize   int           `json:"max_body_size"`   // Largest request body accepted
+	MaxResultSize int           `json:"max_result_size"` // Largest upstream response kept
+	ResultTTL     time.Duration `json:"result_ttl"`      // How long jobs and their results are kept after submission
+	JobTimeout    time.Duration `json:"job_timeout"`
+	Stream        string        `json:"stream"` // Redis stream the jobs are queued on
+	UseRedis      bool          `json:"use_redis"`
+	Redis         RedisConfig   `json:"redis"`
+}
+
+// DefaultAsyncConfig returns default asynchronous request configuration
+func DefaultAsyncConfig() *AsyncConfig {
+	return &AsyncConfig{
+		Enabled:       false,
+		Workers:       4,
+		QueueSize:     1000,
+		MaxBodySize:   1 << 20, // 1MB
+		MaxResultSize: 1 << 20, // 1MB
+		ResultTTL:     24 * time.Hour,
+		JobTimeout:    5 * time.Minute,
+		Stream:        "gateway:async:jobs",
+		UseRedis:      false,
+	}
+}
+
+// LoadAsyncConfig loads asynchronous request configuration from environment
+func LoadAsyncConfig() *AsyncConfig {
+	config := DefaultAsyncConfig()
+
+	config.Enabled = getEnvBool("ASYNC_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Workers = getEnvInt("ASYNC_WORKERS", config.Workers)
+	config.QueueSize = getEnvInt("ASYNC_QUEUE_SIZE", config.QueueSize)
+	config.MaxBodySize = getEnvInt("ASYNC_MAX_BODY_SIZE", config.MaxBodySize)
+	config.MaxResultSize = getEnvInt("ASYNC_MAX_RESULT_SIZE", config.MaxResultSize)
+	config.ResultTTL = getEnvDuration("ASYNC_RESULT_TTL", config.ResultTTL)
+	config.JobTimeout = getEnvDuration("ASYNC_JOB_TIMEOUT", config.JobTimeout)
+	config.Stream = getEnvString("ASYNC_STREAM", config.Stream)
+	config.UseRedis = getEnvBool("ASYNC_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"time"
+)
+
+// AutoscaleConfig represents the load signals reported to autoscalers. Each
+// target is the per-replica load a signal should stay under.
+type AutoscaleConfig struct {
+	Enabled          bool          `json:"enabled"`
+	TargetInFlight   int           `json:"target_in_flight"`   // 0 disables the signal
+	TargetQueueDepth int           `json:"target_queue_depth"` // 0 disables the signal
+	TargetP99        time.Duration `json:"target_p99"`         // 0 disables the signal
+	TargetCPU        float64       `json:"target_cpu"`         // CPU utilization (0-1); 0 disables the signal
+	Window           time.Duration `json:"window"`             // Latency percentile window
+	SampleInterval   time.Duration `json:"sample_interval"`
+}
+
+// DefaultAutoscaleConfig returns default autoscaling signal configuration
+func DefaultAutoscaleConfig() *AutoscaleConfig {
+	return &AutoscaleConfig{
+		Enabled:          false,
+		TargetInFlight:   100,
+		TargetQueueDepth: 10,
+		TargetP99:        500 * time.Millisecond,
+		TargetCPU:        0.7,
+		Window:           time.Minute,
+		SampleInterval:   5 * time.Second,
+	}
+}
+
+// LoadAutoscaleConfig loads autoscaling signal configuration from environment
+func LoadAutoscaleConfig() *AutoscaleConfig {
+	config := DefaultAutoscaleConfig()
+
+	config.Enabled = getEnvBool("AUTOSCALE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.TargetInFlight = getEnvInt("AUTOSCALE_TARGET_IN_FLIGHT", config.TargetInFlight)
+	config.TargetQueueDepth = getEnvInt("AUTOSCALE_TARGET_QUEUE_DEPTH", config.TargetQueueDepth)
+	config.TargetP99 = getEnvDuration("AUTOSCALE_TARGET_P99", config.TargetP99)
+	config.TargetCPU = getEnvFloat("AUTOSCALE_TARGET_CPU", config.TargetCPU)
+	config.Window = getEnvDuration("AUTOSCALE_WINDOW", config.Window)
+	config.SampleInterval = getEnvDuration("AUTOSCALE_SAMPLE_INTERVAL", config.SampleInterval)
+
+	return config
+}
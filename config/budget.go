@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// LatencyBudgetConfig represents per-request latency budgets that are passed
+// to upstreams and enforced by the gateway
+type LatencyBudgetConfig struct {
+	Enabled       bool                     `json:"enabled"`
+	Header        string                   `json:"header"`         // Carries the remaining budget in milliseconds to upstreams
+	Default       time.Duration            `json:"default"`        // Budget of routes without one; 0 leaves them unbounded
+	Routes        map[string]time.Duration `json:"routes"`         // Path prefix -> budget, taking precedence over SLOs
+	FromSLO       bool                     `json:"from_slo"`       // Derive budgets from SLO latency objectives
+	SLOMultiplier float64                  `json:"slo_multiplier"` // Budget as a multiple of the SLO latency threshold
+	HonorClient   bool                     `json:"honor_client"`   // Let callers lower the budget with the header
+}
+
+// DefaultLatencyBudgetConfig returns default latency budget configuration
+func DefaultLatencyBudgetConfig() *LatencyBudgetConfig {
+	return &LatencyBudgetConfig{
+		Enabled:       false,
+		Header:        "X-Budget-Ms",
+		Routes:        map[string]time.Duration{},
+		FromSLO:       true,
+		SLOMultiplier: 1,
+	}
+}
+
+// LoadLatencyBudgetConfig loads latency budget configuration from environment
+func LoadLatencyBudgetConfig() *LatencyBudgetConfig {
+	config := DefaultLatencyBudgetConfig()
+
+	config.Enabled = getEnvBool("LATENCY_BUDGET_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Header = getEnvString("LATENCY_BUDGET_HEADER", config.Header)
+	config.Default = getEnvDuration("LATENCY_BUDGET_DEFAULT", 0)
+	for prefix, value := range getEnvMap("LATENCY_BUDGET_ROUTES") {
+		budget, err := time.ParseDuration(value)
+		if err != nil {
+			recordInvalid("LATENCY_BUDGET_ROUTES", getEnv("LATENCY_BUDGET_ROUTES"), fmt.Errorf("budget %q for %q is not a duration", value, prefix))
+			continue
+		}
+		config.Routes[prefix] = budget
+	}
+	config.FromSLO = getEnvBool("LATENCY_BUDGET_FROM_SLO", config.FromSLO)
+	config.SLOMultiplier = getEnvFloat("LATENCY_BUDGET_SLO_MULTIPLIER", config.SLOMultiplier)
+	config.HonorClient = getEnvBool("LATENCY_BUDGET_HONOR_CLIENT", false)
+
+	return config
+}
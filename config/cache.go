@@ -0,0 +1,58 @@
+package config
+
+import (
+	"time"
+)
+
+// CacheConfig represents caching of upstream responses at the gateway
+type CacheConfig struct {
+	Enabled      bool          `json:"enabled"`
+	PathPrefixes []string      `json:"path_prefixes"` // Empty means every upstream route
+	DefaultTTL   time.Duration `json:"default_ttl"`   // For responses without max-age or s-maxage; 0 leaves them uncached
+	MaxTTL       time.Duration `json:"max_ttl"`
+	MaxBodySize  int           `json:"max_body_size"` // Largest response body that will be stored
+	TagHeader    string        `json:"tag_header"`    // Response header listing an entry's surrogate keys
+	// How long expired entries are served while refreshed in the background,
+	// and in place of upstream errors, unless responses say otherwise
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate"`
+	StaleIfError         time.Duration `json:"stale_if_error"`
+	Ranges               bool          `json:"ranges"` // Serve byte ranges of stored responses
+	UseRedis             bool          `json:"use_redis"`
+	Redis                RedisConfig   `json:"redis"`
+}
+
+// DefaultCacheConfig returns default response cache configuration
+func DefaultCacheConfig() *CacheConfig {
+	return &CacheConfig{
+		Enabled:      false,
+		PathPrefixes: []string{},
+		DefaultTTL:   0,
+		MaxTTL:       time.Hour,
+		MaxBodySize:  1 << 20, // 1MB
+		TagHeader:    "Surrogate-Key",
+		UseRedis:     false,
+	}
+}
+
+// LoadCacheConfig loads response cache configuration from environment
+func LoadCacheConfig() *CacheConfig {
+	config := DefaultCacheConfig()
+
+	config.Enabled = getEnvBool("CACHE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.PathPrefixes = getEnvList("CACHE_PATH_PREFIXES", config.PathPrefixes)
+	config.DefaultTTL = getEnvDuration("CACHE_DEFAULT_TTL", config.DefaultTTL)
+	config.MaxTTL = getEnvDuration("CACHE_MAX_TTL", config.MaxTTL)
+	config.MaxBodySize = getEnvInt("CACHE_MAX_BODY_SIZE", config.MaxBodySize)
+	config.TagHeader = getEnvString("CACHE_TAG_HEADER", config.TagHeader)
+	config.StaleWhileRevalidate = getEnvDuration("CACHE_STALE_WHILE_REVALIDATE", config.StaleWhileRevalidate)
+	config.StaleIfError = getEnvDuration("CACHE_STALE_IF_ERROR", config.StaleIfError)
+	config.Ranges = getEnvBool("CACHE_RANGES", config.Ranges)
+	config.UseRedis = getEnvBool("CACHE_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
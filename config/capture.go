@@ -0,0 +1,49 @@
+package config
+
+import (
+	"time"
+)
+
+// CaptureConfig represents traffic capture and replay configuration
+type CaptureConfig struct {
+	Enabled       bool          `json:"enabled"`
+	Storage       string        `json:"storage"` // "file" or "redis"
+	Dir           string        `json:"dir"`
+	Retention     time.Duration `json:"retention"` // Expiry of captures stored in Redis
+	MaxBodySize   int           `json:"max_body_size"`
+	RedactHeaders []string      `json:"redact_headers"` // Masked in addition to REDACT_HEADERS
+	ReplayTimeout time.Duration `json:"replay_timeout"`
+	Redis         RedisConfig   `json:"redis"`
+}
+
+// DefaultCaptureConfig returns default capture configuration
+func DefaultCaptureConfig() *CaptureConfig {
+	return &CaptureConfig{
+		Enabled:       false,
+		Storage:       "file",
+		Dir:           "captures",
+		Retention:     24 * time.Hour,
+		MaxBodySize:   64 * 1024,
+		ReplayTimeout: 10 * time.Second,
+	}
+}
+
+// LoadCaptureConfig loads capture configuration from environment
+func LoadCaptureConfig() *CaptureConfig {
+	config := DefaultCaptureConfig()
+
+	config.Enabled = getEnvBool("CAPTURE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Storage = getEnvString("CAPTURE_STORAGE", config.Storage)
+	config.Dir = getEnvString("CAPTURE_DIR", config.Dir)
+	config.Retention = getEnvDuration("CAPTURE_RETENTION", config.Retention)
+	config.MaxBodySize = getEnvInt("CAPTURE_MAX_BODY_SIZE", config.MaxBodySize)
+	config.RedactHeaders = getEnvList("CAPTURE_REDACT_HEADERS", config.RedactHeaders)
+	config.ReplayTimeout = getEnvDuration("CAPTURE_REPLAY_TIMEOUT", config.ReplayTimeout)
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
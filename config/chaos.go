@@ -0,0 +1,22 @@
+package config
+
+// ChaosConfig represents fault injection configuration
+type ChaosConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DefaultChaosConfig returns default fault injection configuration
+func DefaultChaosConfig() *ChaosConfig {
+	return &ChaosConfig{
+		Enabled: false,
+	}
+}
+
+// LoadChaosConfig loads fault injection configuration from environment
+func LoadChaosConfig() *ChaosConfig {
+	config := DefaultChaosConfig()
+
+	config.Enabled = getEnvBool("CHAOS_ENABLED", false)
+
+	return config
+}
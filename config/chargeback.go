@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ChargebackConfig represents monthly usage reporting per consumer and tenant
+type ChargebackConfig struct {
+	Enabled               bool               `json:"enabled"`
+	UnitsPerRequest       float64            `json:"units_per_request"`
+	UnitsPerGB            float64            `json:"units_per_gb"`
+	UnitsPerComputeSecond float64            `json:"units_per_compute_second"`
+	RouteWeights          map[string]float64 `json:"route_weights"` // Route prefix -> compute weight
+	TenantClaim           string             `json:"tenant_claim"`  // JWT claim naming the tenant; empty uses the user ID
+	FlushInterval         time.Duration      `json:"flush_interval"`
+	RetentionMonths       int                `json:"retention_months"`
+	UseRedis              bool               `json:"use_redis"`
+	Redis                 RedisConfig        `json:"redis"`
+}
+
+// DefaultChargebackConfig returns default chargeback configuration
+func DefaultChargebackConfig() *ChargebackConfig {
+	return &ChargebackConfig{
+		Enabled:               false,
+		UnitsPerRequest:       1,
+		UnitsPerGB:            100,
+		UnitsPerComputeSecond: 10,
+		RouteWeights:          map[string]float64{},
+		FlushInterval:         10 * time.Second,
+		RetentionMonths:       13,
+		UseRedis:              false,
+	}
+}
+
+// LoadChargebackConfig loads chargeback configuration from environment
+func LoadChargebackConfig() *ChargebackConfig {
+	config := DefaultChargebackConfig()
+
+	config.Enabled = getEnvBool("CHARGEBACK_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.UnitsPerRequest = getEnvFloat("CHARGEBACK_UNITS_PER_REQUEST", config.UnitsPerRequest)
+	config.UnitsPerGB = getEnvFloat("CHARGEBACK_UNITS_PER_GB", config.UnitsPerGB)
+	config.UnitsPerComputeSecond = getEnvFloat("CHARGEBACK_UNITS_PER_COMPUTE_SECOND", config.UnitsPerComputeSecond)
+	for prefix, value := range getEnvMap("CHARGEBACK_ROUTE_WEIGHTS") {
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			recordInvalid("CHARGEBACK_ROUTE_WEIGHTS", getEnv("CHARGEBACK_ROUTE_WEIGHTS"), fmt.Errorf("weight %q of %s is not a number", value, prefix))
+			continue
+		}
+		config.RouteWeights[prefix] = weight
+	}
+	config.TenantClaim = getEnvString("CHARGEBACK_TENANT_CLAIM", "")
+	config.FlushInterval = getEnvDuration("CHARGEBACK_FLUSH_INTERVAL", config.FlushInterval)
+	config.RetentionMonths = getEnvInt("CHARGEBACK_RETENTION_MONTHS", config.RetentionMonths)
+	config.UseRedis = getEnvBool("CHARGEBACK_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
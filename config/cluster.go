@@ -0,0 +1,67 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ClusterConfig represents coordination between gateway replicas
+type ClusterConfig struct {
+	Enabled             bool          `json:"enabled"`
+	InstanceID          string        `json:"instance_id"` // Generated from the hostname when empty
+	Prefix              string        `json:"prefix"`      // Redis key namespace; replicas of one gateway must share it
+	LeaseTTL            time.Duration `json:"lease_ttl"`
+	HeartbeatInterval   time.Duration `json:"heartbeat_interval"`
+	CleanupInterval     time.Duration `json:"cleanup_interval"`
+	HealthCheckInterval time.Duration `json:"health_check_interval"` // Upstream health checks run by the leader
+	Redis               RedisConfig   `json:"redis"`
+}
+
+// DefaultClusterConfig returns default replica coordination configuration
+func DefaultClusterConfig() *ClusterConfig {
+	return &ClusterConfig{
+		Enabled:             false,
+		Prefix:              "gateway:cluster:",
+		LeaseTTL:            15 * time.Second,
+		HeartbeatInterval:   5 * time.Second,
+		CleanupInterval:     time.Minute,
+		HealthCheckInterval: 30 * time.Second,
+	}
+}
+
+// LoadClusterConfig loads replica coordination configuration from environment
+func LoadClusterConfig() *ClusterConfig {
+	config := DefaultClusterConfig()
+
+	config.Enabled = getEnvBool("CLUSTER_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.InstanceID = getEnvString("CLUSTER_INSTANCE_ID", "")
+	config.Prefix = getEnvString("CLUSTER_PREFIX", config.Prefix)
+	config.LeaseTTL = getEnvDuration("CLUSTER_LEASE_TTL", config.LeaseTTL)
+	config.HeartbeatInterval = getEnvDuration("CLUSTER_HEARTBEAT_INTERVAL", config.HeartbeatInterval)
+	config.CleanupInterval = getEnvDuration("CLUSTER_CLEANUP_INTERVAL", config.CleanupInterval)
+	config.HealthCheckInterval = getEnvDuration("CLUSTER_HEALTH_CHECK_INTERVAL", config.HealthCheckInterval)
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
+
+// Version returns a fingerprint of the configuration. Replicas started from
+// the same settings report the same version; instance-specific settings
+// are excluded.
+func (c *Config) Version() string {
+	copied := *c
+	cluster := *c.Cluster
+	cluster.InstanceID = ""
+	copied.Cluster = &cluster
+	copied.Files = nil
+
+	data, _ := json.Marshal(&copied)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
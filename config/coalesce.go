@@ -0,0 +1,29 @@
+package config
+
+// CoalesceConfig represents in-flight GET request coalescing configuration
+type CoalesceConfig struct {
+	Enabled      bool     `json:"enabled"`
+	PathPrefixes []string `json:"path_prefixes"`
+}
+
+// DefaultCoalesceConfig returns default coalescing configuration
+func DefaultCoalesceConfig() *CoalesceConfig {
+	return &CoalesceConfig{
+		Enabled:      false,
+		PathPrefixes: []string{},
+	}
+}
+
+// LoadCoalesceConfig loads coalescing configuration from environment
+func LoadCoalesceConfig() *CoalesceConfig {
+	config := DefaultCoalesceConfig()
+
+	config.Enabled = getEnvBool("COALESCE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.PathPrefixes = getEnvList("COALESCE_PATH_PREFIXES", config.PathPrefixes)
+
+	return config
+}
@@ -0,0 +1,44 @@
+package config
+
+// CompressionConfig represents response compression configuration
+type CompressionConfig struct {
+	Enabled      bool     `json:"enabled"`
+	MinSize      int      `json:"min_size"` // Minimum response size in bytes before compressing
+	Level        int      `json:"level"`
+	ContentTypes []string `json:"content_types"`
+	Encodings    []string `json:"encodings"` // Supported encodings in order of preference
+}
+
+// DefaultCompressionConfig returns default compression configuration
+func DefaultCompressionConfig() *CompressionConfig {
+	return &CompressionConfig{
+		Enabled: false,
+		MinSize: 1024,
+		Level:   6,
+		ContentTypes: []string{
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+			"text/*",
+		},
+		Encodings: []string{"br", "gzip"},
+	}
+}
+
+// LoadCompressionConfig loads compression configuration from environment
+func LoadCompressionConfig() *CompressionConfig {
+	config := DefaultCompressionConfig()
+
+	config.Enabled = getEnvBool("COMPRESSION_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.MinSize = getEnvInt("COMPRESSION_MIN_SIZE", config.MinSize)
+	config.Level = getEnvInt("COMPRESSION_LEVEL", config.Level)
+	config.ContentTypes = getEnvList("COMPRESSION_CONTENT_TYPES", config.ContentTypes)
+	config.Encodings = getEnvList("COMPRESSION_ENCODINGS", config.Encodings)
+
+	return config
+}
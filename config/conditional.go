@@ -0,0 +1,36 @@
+package config
+
+// ConditionalConfig represents answering conditional requests to upstream
+// routes at the gateway
+type ConditionalConfig struct {
+	Enabled      bool     `json:"enabled"`
+	PathPrefixes []string `json:"path_prefixes"` // Empty means every upstream route
+	WeakETags    bool     `json:"weak_etags"`    // Generate weak ETags rather than strong ones
+	MaxBodySize  int      `json:"max_body_size"` // Largest body buffered to generate an ETag
+}
+
+// DefaultConditionalConfig returns default conditional request configuration
+func DefaultConditionalConfig() *ConditionalConfig {
+	return &ConditionalConfig{
+		Enabled:      false,
+		PathPrefixes: []string{},
+		WeakETags:    false,
+		MaxBodySize:  1 << 20, // 1MB
+	}
+}
+
+// LoadConditionalConfig loads conditional request configuration from environment
+func LoadConditionalConfig() *ConditionalConfig {
+	config := DefaultConditionalConfig()
+
+	config.Enabled = getEnvBool("CONDITIONAL_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.PathPrefixes = getEnvList("CONDITIONAL_PATH_PREFIXES", config.PathPrefixes)
+	config.WeakETags = getEnvBool("CONDITIONAL_WEAK_ETAGS", config.WeakETags)
+	config.MaxBodySize = getEnvInt("CONDITIONAL_MAX_BODY_SIZE", config.MaxBodySize)
+
+	return config
+}
@@ -1,64 +1,254 @@
 package config
 
 import (
-	"os"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
+// DefaultJWTSecret is the JWT secret used when JWT_SECRET is not set
+const DefaultJWTSecret = "default-secret-key"
+
+// insecureJWTSecrets are well-known secrets that must never be used in production
+var insecureJWTSecrets = []string{DefaultJWTSecret, "your-secret-key-change-in-production"}
+
 // Config holds all configuration for our application
 type Config struct {
-	JWT    JWTConfig
-	Server ServerConfig
+	JWT            JWTConfig             `json:"jwt"`
+	Server         ServerConfig          `json:"server"`
+	CORS           CORSConfig            `json:"cors"`
+	APIKeys        *APIKeyConfig         `json:"api_keys"`
+	RateLimit      *RateLimitConfig      `json:"rate_limit"`
+	Anonymous      *AnonymousConfig      `json:"anonymous"`
+	Policy         *PolicyConfig         `json:"policy"`
+	Permissions    *PermissionsConfig    `json:"permissions"`
+	Groups         *GroupsConfig         `json:"groups"`
+	SCIM           *SCIMConfig           `json:"scim"`
+	LDAP           *LDAPConfig           `json:"ldap"`
+	SAML           *SAMLConfig           `json:"saml"`
+	DeviceFlow     *DeviceFlowConfig     `json:"device_flow"`
+	PersonalTokens *PersonalTokensConfig `json:"personal_tokens"`
+	WAF            *WAFConfig            `json:"waf"`
+	Compression    *CompressionConfig    `json:"compression"`
+	Headers        *HeadersConfig        `json:"headers"`
+	ErrorPages     *ErrorPagesConfig     `json:"error_pages"`
+	Masking        *MaskingConfig        `json:"masking"`
+	Redaction      *RedactionConfig      `json:"redaction"`
+	Metrics        *MetricsConfig        `json:"metrics"`
+	StatsD         *StatsDConfig         `json:"statsd"`
+	AccessLog      *AccessLogConfig      `json:"access_log"`
+	Idempotency    *IdempotencyConfig    `json:"idempotency"`
+	Replay         *ReplayConfig         `json:"replay_protection"`
+	CSRF           *CSRFConfig           `json:"csrf"`
+	Impersonation  *ImpersonationConfig  `json:"impersonation"`
+	Coalesce       *CoalesceConfig       `json:"coalesce"`
+	Cache          *CacheConfig          `json:"cache"`
+	Conditional    *ConditionalConfig    `json:"conditional"`
+	Capture        *CaptureConfig        `json:"capture"`
+	DebugLog       *DebugLogConfig       `json:"debug_log"`
+	TailCapture    *TailCaptureConfig    `json:"tail_capture"`
+	Diagnostics    *DiagnosticsConfig    `json:"diagnostics"`
+	Chaos          *ChaosConfig          `json:"chaos"`
+	Shedding       *SheddingConfig       `json:"shedding"`
+	ConnLimits     *ConnLimitsConfig     `json:"conn_limits"`
+	Autoscale      *AutoscaleConfig      `json:"autoscale"`
+	Throttle       *ThrottleConfig       `json:"throttle"`
+	StreamLimits   *StreamLimitsConfig   `json:"stream_limits"`
+	UploadScan     *UploadScanConfig     `json:"upload_scan"`
+	FeatureFlags   *FeatureFlagsConfig   `json:"feature_flags"`
+	Experiments    *ExperimentsConfig    `json:"experiments"`
+	PenaltyBox     *PenaltyBoxConfig     `json:"penalty_box"`
+	Anomaly        *AnomalyConfig        `json:"anomaly"`
+	SLO            *SLOConfig            `json:"slo"`
+	LatencyBudget  *LatencyBudgetConfig  `json:"latency_budget"`
+	Chargeback     *ChargebackConfig     `json:"chargeback"`
+	Metering       *MeteringConfig       `json:"metering"`
+	Queue          *QueueConfig          `json:"queue"`
+	Portal         *PortalConfig         `json:"portal"`
+	Products       []*ProductConfig      `json:"products"`
+	State          *StateConfig          `json:"state"`
+	WarmRestart    *WarmRestartConfig    `json:"warm_restart"`
+	Cluster        *ClusterConfig        `json:"cluster"`
+	SQLStorage     *SQLStorageConfig     `json:"sql_storage"`
+	Federation     *FederationConfig     `json:"federation"`
+	Docs           *DocsConfig           `json:"docs"`
+	Proxy          *ProxyConfig          `json:"proxy"`
+	Webhooks       *WebhooksConfig       `json:"webhooks"`
+	Async          *AsyncConfig          `json:"async"`
+	MQTT           *MQTTConfig           `json:"mqtt"`
+	Devices        *DevicesConfig        `json:"devices"`
+	AdminGRPC      *AdminGRPCConfig      `json:"admin_grpc"`
+	Files          []string              `json:"files"` // Loaded configuration files, highest precedence first
 }
 
 // JWTConfig holds JWT-related configuration
 type JWTConfig struct {
-	Secret      string
-	Issuer      string
-	Audience    string
-	ExpiryHours int
-	Expiry      time.Duration
+	Secret      string        `json:"secret"`
+	Issuer      string        `json:"issuer"`
+	Audience    string        `json:"audience"`
+	ExpiryHours int           `json:"expiry_hours"`
+	Expiry      time.Duration `json:"expiry"`
+
+	TrustedIssuers []*TrustedIssuerConfig `json:"trusted_issuers,omitempty"` // External identity providers routes may accept
 }
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
-	Port string
+	Environment string `json:"environment"` // "development", "staging" or "production"
+	Host        string `json:"host"`        // Bind address; empty listens on all interfaces
+	Port        string `json:"port"`
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+
+	HTTP3Enabled      bool          `json:"http3_enabled"`         // Serve HTTP/3 over QUIC next to HTTP/1.1 and HTTP/2; requires TLS
+	HTTP3Port         string        `json:"http3_port"`            // UDP port, advertised in Alt-Svc
+	HTTP3AltSvcMaxAge time.Duration `json:"http3_alt_svc_max_age"` // How long clients may remember that HTTP/3 is available
+
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"` // How long in-flight requests may finish on shutdown
+	UpgradeTimeout  time.Duration `json:"upgrade_timeout"`  // How long a new binary may take to start during an upgrade
+	PIDFile         string        `json:"pid_file"`         // Rewritten by each process, so service managers follow upgrades
+
+	TrustedProxies []string `json:"trusted_proxies"` // Addresses or CIDR ranges of proxies whose forwarding headers are believed
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadConfig() (*Config, error) {
-	// Load .env file if it exists
-	_ = godotenv.Load()
+// Production reports whether the gateway runs in production mode
+func (s ServerConfig) Production() bool {
+	return s.Environment == "production"
+}
+
+// TLSEnabled reports whether the listener serves TLS
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
 
-	expiryHours := 24 // default
-	if hours := os.Getenv("JWT_EXPIRY_HOURS"); hours != "" {
-		if h, err := strconv.Atoi(hours); err == nil {
-			expiryHours = h
+// CORSConfig holds cross-origin resource sharing configuration
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowCredentials bool     `json:"allow_credentials"`
+}
+
+// AllowsAnyOrigin reports whether every origin is allowed
+func (c CORSConfig) AllowsAnyOrigin() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
 		}
 	}
+	return false
+}
+
+// LoadConfig loads the configuration of every subsystem from the layered
+// configuration files and environment variables
+func LoadConfig() (*Config, error) {
+	if _, err := loadLayers(); err != nil {
+		return nil, err
+	}
+
+	expiryHours := getEnvInt("JWT_EXPIRY_HOURS", 24)
+	audience := getEnvOrDefault("JWT_AUDIENCE", "api-users")
 
 	config := &Config{
 		JWT: JWTConfig{
-			Secret:      getEnvOrDefault("JWT_SECRET", "default-secret-key"),
+			Secret:      getEnvOrDefault("JWT_SECRET", DefaultJWTSecret),
 			Issuer:      getEnvOrDefault("JWT_ISSUER", "api-gateway"),
-			Audience:    getEnvOrDefault("JWT_AUDIENCE", "api-users"),
+			Audience:    audience,
 			ExpiryHours: expiryHours,
 			Expiry:      time.Duration(expiryHours) * time.Hour,
+
+			TrustedIssuers: LoadTrustedIssuersConfig(audience),
 		},
 		Server: ServerConfig{
-			Port: getEnvOrDefault("PORT", "8080"),
+			Environment: getEnvOrDefault("GATEWAY_ENV", "development"),
+			Host:        getEnvOrDefault("HOST", ""),
+			Port:        getEnvOrDefault("PORT", "8080"),
+			TLSCertFile: getEnvOrDefault("TLS_CERT_FILE", ""),
+			TLSKeyFile:  getEnvOrDefault("TLS_KEY_FILE", ""),
+
+			HTTP3Enabled:      getEnvBool("HTTP3_ENABLED", false),
+			HTTP3Port:         getEnvOrDefault("HTTP3_PORT", getEnvOrDefault("PORT", "8080")),
+			HTTP3AltSvcMaxAge: getEnvDuration("HTTP3_ALT_SVC_MAX_AGE", 24*time.Hour),
+
+			ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+			UpgradeTimeout:  getEnvDuration("UPGRADE_TIMEOUT", 30*time.Second),
+			PIDFile:         getEnvOrDefault("PID_FILE", ""),
+
+			TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getEnvList("CORS_ALLOWED_ORIGINS", []string{"*"}),
+			AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", false),
 		},
+		APIKeys:        LoadAPIKeyConfig(),
+		RateLimit:      LoadRateLimitConfig(),
+		Anonymous:      LoadAnonymousConfig(),
+		Policy:         LoadPolicyConfig(),
+		Permissions:    LoadPermissionsConfig(),
+		Groups:         LoadGroupsConfig(),
+		SCIM:           LoadSCIMConfig(),
+		LDAP:           LoadLDAPConfig(),
+		SAML:           LoadSAMLConfig(),
+		DeviceFlow:     LoadDeviceFlowConfig(),
+		PersonalTokens: LoadPersonalTokensConfig(),
+		WAF:            LoadWAFConfig(),
+		Compression:    LoadCompressionConfig(),
+		Headers:        LoadHeadersConfig(),
+		ErrorPages:     LoadErrorPagesConfig(),
+		Masking:        LoadMaskingConfig(),
+		Redaction:      LoadRedactionConfig(),
+		Metrics:        LoadMetricsConfig(),
+		StatsD:         LoadStatsDConfig(),
+		AccessLog:      LoadAccessLogConfig(),
+		Idempotency:    LoadIdempotencyConfig(),
+		Replay:         LoadReplayConfig(),
+		CSRF:           LoadCSRFConfig(getEnvOrDefault("JWT_SECRET", DefaultJWTSecret)),
+		Impersonation:  LoadImpersonationConfig(),
+		Coalesce:       LoadCoalesceConfig(),
+		Cache:          LoadCacheConfig(),
+		Conditional:    LoadConditionalConfig(),
+		Capture:        LoadCaptureConfig(),
+		DebugLog:       LoadDebugLogConfig(),
+		TailCapture:    LoadTailCaptureConfig(),
+		Diagnostics:    LoadDiagnosticsConfig(),
+		Chaos:          LoadChaosConfig(),
+		Shedding:       LoadSheddingConfig(),
+		ConnLimits:     LoadConnLimitsConfig(),
+		Autoscale:      LoadAutoscaleConfig(),
+		Throttle:       LoadThrottleConfig(),
+		StreamLimits:   LoadStreamLimitsConfig(),
+		UploadScan:     LoadUploadScanConfig(),
+		FeatureFlags:   LoadFeatureFlagsConfig(),
+		Experiments:    LoadExperimentsConfig(),
+		PenaltyBox:     LoadPenaltyBoxConfig(),
+		Anomaly:        LoadAnomalyConfig(),
+		SLO:            LoadSLOConfig(),
+		LatencyBudget:  LoadLatencyBudgetConfig(),
+		Chargeback:     LoadChargebackConfig(),
+		Metering:       LoadMeteringConfig(),
+		Queue:          LoadQueueConfig(),
+		Portal:         LoadPortalConfig(),
+		Products:       LoadProductsConfig(),
+		State:          LoadStateConfig(getEnvOrDefault("JWT_SECRET", DefaultJWTSecret)),
+		WarmRestart:    LoadWarmRestartConfig(),
+		Cluster:        LoadClusterConfig(),
+		SQLStorage:     LoadSQLStorageConfig(),
+		Federation:     LoadFederationConfig(),
+		Docs:           LoadDocsConfig(),
+		Proxy:          LoadProxyConfig(),
+		Webhooks:       LoadWebhooksConfig(),
+		Async:          LoadAsyncConfig(),
+		MQTT:           LoadMQTTConfig(),
+		Devices:        LoadDevicesConfig(),
+		AdminGRPC:      LoadAdminGRPCConfig(),
+		Files:          LayerFiles(),
 	}
 
 	return config, nil
 }
 
-// getEnvOrDefault returns the environment variable value or a default if not set
+// getEnvOrDefault returns the setting value or a default if not set
 func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := getEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
@@ -70,28 +260,87 @@ func getEnvString(key, defaultValue string) string {
 }
 
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
+	if value := getEnv(key); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err == nil {
 			return intValue
 		}
+		recordInvalid(key, value, fmt.Errorf("not an integer"))
 	}
 	return defaultValue
 }
 
 func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if boolValue, err := strconv.ParseBool(value); err == nil {
+	if value := getEnv(key); value != "" {
+		boolValue, err := strconv.ParseBool(value)
+		if err == nil {
 			return boolValue
 		}
+		recordInvalid(key, value, fmt.Errorf("not a boolean"))
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := getEnv(key); value != "" {
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return floatValue
+		}
+		recordInvalid(key, value, fmt.Errorf("not a number"))
 	}
 	return defaultValue
 }
 
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
+	if value := getEnv(key); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err == nil {
 			return duration
 		}
+		recordInvalid(key, value, fmt.Errorf("not a duration (use format like '30s', '5m')"))
 	}
 	return defaultValue
 }
+
+// getEnvTime parses an RFC 3339 time, returning nil when unset or invalid
+func getEnvTime(key string) *time.Time {
+	if value := getEnv(key); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			return &t
+		}
+		recordInvalid(key, value, fmt.Errorf("not a time (use RFC 3339 format like '2025-01-31T09:00:00Z')"))
+	}
+	return nil
+}
+
+// getEnvList parses a comma-separated list, ignoring empty entries
+func getEnvList(key string, defaultValue []string) []string {
+	value := getEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap parses a comma-separated list of key=value pairs
+func getEnvMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range getEnvList(key, nil) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			recordInvalid(key, getEnv(key), fmt.Errorf("entry %q is not key=value", pair))
+			continue
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result
+}
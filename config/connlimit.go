@@ -0,0 +1,39 @@
+package config
+
+// ConnLimitsConfig represents limits on client connections applied at the
+// listener, before requests are parsed
+type ConnLimitsConfig struct {
+	Enabled     bool    `json:"enabled"`
+	MaxConns    int     `json:"max_conns"`    // Concurrent connections; 0 is unlimited
+	MaxPerIP    int     `json:"max_per_ip"`   // Concurrent connections per client IP; 0 is unlimited
+	AcceptRate  float64 `json:"accept_rate"`  // Connections accepted per second; 0 is unlimited
+	AcceptBurst int     `json:"accept_burst"` // Connections accepted back to back before the rate applies
+}
+
+// DefaultConnLimitsConfig returns default connection limit configuration
+func DefaultConnLimitsConfig() *ConnLimitsConfig {
+	return &ConnLimitsConfig{
+		Enabled:     false,
+		MaxConns:    10000,
+		MaxPerIP:    0,
+		AcceptRate:  0,
+		AcceptBurst: 100,
+	}
+}
+
+// LoadConnLimitsConfig loads connection limit configuration from environment
+func LoadConnLimitsConfig() *ConnLimitsConfig {
+	config := DefaultConnLimitsConfig()
+
+	config.Enabled = getEnvBool("CONN_LIMITS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.MaxConns = getEnvInt("CONN_LIMIT_MAX_CONNS", config.MaxConns)
+	config.MaxPerIP = getEnvInt("CONN_LIMIT_MAX_PER_IP", config.MaxPerIP)
+	config.AcceptRate = getEnvFloat("CONN_LIMIT_ACCEPT_RATE", config.AcceptRate)
+	config.AcceptBurst = getEnvInt("CONN_LIMIT_ACCEPT_BURST", config.AcceptBurst)
+
+	return config
+}
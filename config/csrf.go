@@ -0,0 +1,50 @@
+package config
+
+import (
+	"time"
+)
+
+// CSRFConfig represents CSRF protection configuration for cookie-authenticated routes
+type CSRFConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Paths          []string      `json:"paths"`           // Route prefixes where unsafe requests need a token
+	SessionCookies []string      `json:"session_cookies"` // Cookies that make a request cookie-authenticated
+	CookieName     string        `json:"cookie_name"`
+	HeaderName     string        `json:"header_name"`
+	TTL            time.Duration `json:"ttl"`
+	CookieSecure   bool          `json:"cookie_secure"`
+	SigningKey     string        `json:"signing_key"` // HMAC key for tokens; instances behind one hostname must share it
+}
+
+// DefaultCSRFConfig returns default CSRF protection configuration
+func DefaultCSRFConfig() *CSRFConfig {
+	return &CSRFConfig{
+		Enabled:        false,
+		SessionCookies: []string{"session"},
+		CookieName:     "csrf_token",
+		HeaderName:     "X-CSRF-Token",
+		TTL:            12 * time.Hour,
+		CookieSecure:   false,
+	}
+}
+
+// LoadCSRFConfig loads CSRF protection configuration from environment.
+// The signing key defaults to the JWT secret.
+func LoadCSRFConfig(jwtSecret string) *CSRFConfig {
+	config := DefaultCSRFConfig()
+
+	config.Enabled = getEnvBool("CSRF_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Paths = getEnvList("CSRF_PATHS", nil)
+	config.SessionCookies = getEnvList("CSRF_SESSION_COOKIES", config.SessionCookies)
+	config.CookieName = getEnvString("CSRF_COOKIE_NAME", config.CookieName)
+	config.HeaderName = getEnvString("CSRF_HEADER", config.HeaderName)
+	config.TTL = getEnvDuration("CSRF_TOKEN_TTL", config.TTL)
+	config.CookieSecure = getEnvBool("CSRF_COOKIE_SECURE", config.CookieSecure)
+	config.SigningKey = getEnvString("CSRF_SIGNING_KEY", jwtSecret)
+
+	return config
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"time"
+)
+
+// DebugLogConfig represents on-demand debug logging configuration
+type DebugLogConfig struct {
+	Enabled       bool          `json:"enabled"`
+	MaxBodySize   int           `json:"max_body_size"`
+	MaxDuration   time.Duration `json:"max_duration"`   // Longest time a rule may stay active
+	RedactHeaders []string      `json:"redact_headers"` // Masked in addition to REDACT_HEADERS
+}
+
+// DefaultDebugLogConfig returns default debug logging configuration
+func DefaultDebugLogConfig() *DebugLogConfig {
+	return &DebugLogConfig{
+		Enabled:     false,
+		MaxBodySize: 4 * 1024,
+		MaxDuration: time.Hour,
+	}
+}
+
+// LoadDebugLogConfig loads debug logging configuration from environment
+func LoadDebugLogConfig() *DebugLogConfig {
+	config := DefaultDebugLogConfig()
+
+	config.Enabled = getEnvBool("DEBUG_LOG_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.MaxBodySize = getEnvInt("DEBUG_LOG_MAX_BODY_SIZE", config.MaxBodySize)
+	config.MaxDuration = getEnvDuration("DEBUG_LOG_MAX_DURATION", config.MaxDuration)
+	config.RedactHeaders = getEnvList("DEBUG_LOG_REDACT_HEADERS", config.RedactHeaders)
+
+	return config
+}
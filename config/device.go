@@ -0,0 +1,45 @@
+package config
+
+import (
+	"time"
+)
+
+// DeviceFlowConfig represents the OAuth device authorization grant for CLI clients
+type DeviceFlowConfig struct {
+	Enabled         bool          `json:"enabled"`
+	Clients         []string      `json:"clients"`          // Allowed client IDs; any when empty
+	VerificationURL string        `json:"verification_url"` // Page users open to approve; derived from the request when empty
+	CodeLifetime    time.Duration `json:"code_lifetime"`
+	PollInterval    time.Duration `json:"poll_interval"`
+	UseRedis        bool          `json:"use_redis"`
+	Redis           RedisConfig   `json:"redis"`
+}
+
+// DefaultDeviceFlowConfig returns default device flow configuration
+func DefaultDeviceFlowConfig() *DeviceFlowConfig {
+	return &DeviceFlowConfig{
+		Enabled:      false,
+		CodeLifetime: 10 * time.Minute,
+		PollInterval: 5 * time.Second,
+		UseRedis:     false,
+	}
+}
+
+// LoadDeviceFlowConfig loads device flow configuration from environment
+func LoadDeviceFlowConfig() *DeviceFlowConfig {
+	config := DefaultDeviceFlowConfig()
+
+	config.Enabled = getEnvBool("DEVICE_FLOW_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Clients = getEnvList("DEVICE_FLOW_CLIENTS", nil)
+	config.VerificationURL = getEnvString("DEVICE_FLOW_VERIFICATION_URL", "")
+	config.CodeLifetime = getEnvDuration("DEVICE_FLOW_CODE_LIFETIME", config.CodeLifetime)
+	config.PollInterval = getEnvDuration("DEVICE_FLOW_POLL_INTERVAL", config.PollInterval)
+	config.UseRedis = getEnvBool("DEVICE_FLOW_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"time"
+)
+
+// DevicesConfig represents the device registry, which enrolls devices such
+// as IoT clients for their own API keys or client certificates
+type DevicesConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Roles          []string      `json:"roles"`   // Roles of enrolled devices
+	KeyTTL         time.Duration `json:"key_ttl"` // Lifetime of device API keys
+	EnrollmentTTL  time.Duration `json:"enrollment_ttl"`
+	CACertFile     string        `json:"ca_cert_file"` // CA issuing device certificates; certificates cannot be enrolled without one
+	CAKeyFile      string        `json:"ca_key_file"`
+	CertValidity   time.Duration `json:"cert_validity"`
+	RateCapacity   int           `json:"rate_capacity"` // Default requests a device can make in a burst; 0 disables limits
+	RateRefillRate int           `json:"rate_refill_rate"`
+	Quota          int           `json:"quota"` // Default requests a device can make per quota window; 0 disables quotas
+	QuotaWindow    time.Duration `json:"quota_window"`
+	UseRedis       bool          `json:"use_redis"`
+	Redis          RedisConfig   `json:"redis"`
+}
+
+// DefaultDevicesConfig returns default device registry configuration
+func DefaultDevicesConfig() *DevicesConfig {
+	return &DevicesConfig{
+		Enabled:       false,
+		Roles:         []string{"device"},
+		KeyTTL:        365 * 24 * time.Hour,
+		EnrollmentTTL: 24 * time.Hour,
+		CertValidity:  365 * 24 * time.Hour,
+		QuotaWindow:   24 * time.Hour,
+		UseRedis:      false,
+	}
+}
+
+// LoadDevicesConfig loads device registry configuration from environment
+func LoadDevicesConfig() *DevicesConfig {
+	config := DefaultDevicesConfig()
+
+	config.Enabled = getEnvBool("DEVICES_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Roles = getEnvList("DEVICES_ROLES", config.Roles)
+	config.KeyTTL = getEnvDuration("DEVICES_KEY_TTL", config.KeyTTL)
+	config.EnrollmentTTL = getEnvDuration("DEVICES_ENROLLMENT_TTL", config.EnrollmentTTL)
+	config.CACertFile = getEnvString("DEVICES_CA_CERT_FILE", "")
+	config.CAKeyFile = getEnvString("DEVICES_CA_KEY_FILE", "")
+	config.CertValidity = getEnvDuration("DEVICES_CERT_VALIDITY", config.CertValidity)
+	config.RateCapacity = getEnvInt("DEVICES_RATE_LIMIT_CAPACITY", config.RateCapacity)
+	config.RateRefillRate = getEnvInt("DEVICES_RATE_LIMIT_REFILL_RATE", config.RateRefillRate)
+	config.Quota = getEnvInt("DEVICES_QUOTA", config.Quota)
+	config.QuotaWindow = getEnvDuration("DEVICES_QUOTA_WINDOW", config.QuotaWindow)
+	config.UseRedis = getEnvBool("DEVICES_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"time"
+)
+
+// DiagnosticsConfig represents the synthetic self-diagnostics endpoint
+type DiagnosticsConfig struct {
+	Enabled   bool          `json:"enabled"`
+	ProxyPath string        `json:"proxy_path"` // Proxied route requested by the proxy check; defaults to the first upstream
+	Timeout   time.Duration `json:"timeout"`    // Per check
+}
+
+// DefaultDiagnosticsConfig returns default diagnostics configuration
+func DefaultDiagnosticsConfig() *DiagnosticsConfig {
+	return &DiagnosticsConfig{
+		Enabled: false,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// LoadDiagnosticsConfig loads diagnostics configuration from environment
+func LoadDiagnosticsConfig() *DiagnosticsConfig {
+	config := DefaultDiagnosticsConfig()
+
+	config.Enabled = getEnvBool("DIAGNOSTICS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.ProxyPath = getEnvString("DIAGNOSTICS_PROXY_PATH", "")
+	config.Timeout = getEnvDuration("DIAGNOSTICS_TIMEOUT", config.Timeout)
+
+	return config
+}
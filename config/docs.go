@@ -0,0 +1,22 @@
+package config
+
+// DocsConfig represents API documentation exposure configuration
+type DocsConfig struct {
+	Enabled bool `json:"enabled"` // Serve Swagger UI and the OpenAPI document
+}
+
+// DefaultDocsConfig returns default documentation configuration
+func DefaultDocsConfig() *DocsConfig {
+	return &DocsConfig{
+		Enabled: true,
+	}
+}
+
+// LoadDocsConfig loads documentation configuration from environment
+func LoadDocsConfig() *DocsConfig {
+	config := DefaultDocsConfig()
+
+	config.Enabled = getEnvBool("DOCS_ENABLED", true)
+
+	return config
+}
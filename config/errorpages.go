@@ -0,0 +1,24 @@
+package config
+
+// ErrorPagesConfig represents templated error response configuration
+type ErrorPagesConfig struct {
+	Enabled bool              `json:"enabled"`
+	Dir     string            `json:"dir"`    // Templates applied to every route
+	Routes  map[string]string `json:"routes"` // Path prefix -> template directory replacing Dir
+}
+
+// LoadErrorPagesConfig loads templated error response configuration from environment
+func LoadErrorPagesConfig() *ErrorPagesConfig {
+	config := &ErrorPagesConfig{
+		Enabled: getEnvBool("ERROR_PAGES_ENABLED", false),
+		Routes:  map[string]string{},
+	}
+	if !config.Enabled {
+		return config
+	}
+
+	config.Dir = getEnvString("ERROR_PAGES_DIR", "")
+	config.Routes = getEnvMap("ERROR_PAGES_ROUTES")
+
+	return config
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExperimentsConfig represents A/B experiments on proxied and gateway routes
+type ExperimentsConfig struct {
+	Enabled           bool                `json:"enabled"`
+	Experiments       []*ExperimentConfig `json:"experiments"`
+	Header            string              `json:"header"`              // Request header carrying the caller's variants to upstreams
+	MaxTrackedCallers int                 `json:"max_tracked_callers"` // Callers remembered per experiment to count assignments once
+}
+
+// ExperimentConfig represents one experiment
+type ExperimentConfig struct {
+	Name     string                     `json:"name"`
+	Paths    []string                   `json:"paths"`   // Route prefixes the experiment runs on
+	Percent  float64                    `json:"percent"` // Share of callers entering the experiment, 0-100
+	Variants []*ExperimentVariantConfig `json:"variants"`
+}
+
+// ExperimentVariantConfig represents one variant and its relative weight
+type ExperimentVariantConfig struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// DefaultExperimentsConfig returns default experiment configuration
+func DefaultExperimentsConfig() *ExperimentsConfig {
+	return &ExperimentsConfig{
+		Enabled:           false,
+		Header:            "X-Experiments",
+		MaxTrackedCallers: 100000,
+	}
+}
+
+// LoadExperimentsConfig loads experiment configuration from environment.
+// EXPERIMENTS lists experiments; each is configured with EXPERIMENT_<NAME>_*
+// settings.
+func LoadExperimentsConfig() *ExperimentsConfig {
+	config := DefaultExperimentsConfig()
+
+	config.Enabled = getEnvBool("EXPERIMENTS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	for _, name := range getEnvList("EXPERIMENTS", nil) {
+		prefix := "EXPERIMENT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config.Experiments = append(config.Experiments, &ExperimentConfig{
+			Name:     name,
+			Paths:    getEnvList(prefix+"PATHS", []string{"/" + name}),
+			Percent:  getEnvFloat(prefix+"PERCENT", 100),
+			Variants: getEnvVariants(prefix+"VARIANTS", []string{"control", "treatment"}),
+		})
+	}
+	config.Header = getEnvString("EXPERIMENTS_HEADER", config.Header)
+	config.MaxTrackedCallers = getEnvInt("EXPERIMENTS_MAX_TRACKED_CALLERS", config.MaxTrackedCallers)
+
+	return config
+}
+
+// getEnvVariants parses a list of variants written name or name:weight;
+// variants without a weight get 1
+func getEnvVariants(key string, defaultValue []string) []*ExperimentVariantConfig {
+	var variants []*ExperimentVariantConfig
+	for _, entry := range getEnvList(key, defaultValue) {
+		name, rawWeight, hasWeight := strings.Cut(entry, ":")
+		weight := 1
+		if hasWeight {
+			parsed, err := strconv.Atoi(strings.TrimSpace(rawWeight))
+			if err != nil {
+				recordInvalid(key, getEnv(key), fmt.Errorf("weight of %q is not an integer", entry))
+				continue
+			}
+			weight = parsed
+		}
+		variants = append(variants, &ExperimentVariantConfig{Name: strings.TrimSpace(name), Weight: weight})
+	}
+	return variants
+}
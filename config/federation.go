@@ -0,0 +1,60 @@
+package config
+
+import (
+	"time"
+)
+
+// FederationConfig represents replication of gateway state between gateway
+// clusters in different regions
+type FederationConfig struct {
+	Enabled           bool          `json:"enabled"`
+	Region            string        `json:"region"`             // Unique per cluster, such as eu-west
+	Prefix            string        `json:"prefix"`             // Redis key namespace shared by every region
+	Resources         []string      `json:"resources"`          // Replicated state: api_keys, exemptions and penalties
+	Interval          time.Duration `json:"interval"`           // How often changes are published and applied
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"` // How often an idle region reports in, so its lag stays known
+	MaxLen            int           `json:"max_len"`            // Events kept in the replication stream
+	Redis             RedisConfig   `json:"redis"`              // Reachable from every region
+}
+
+// DefaultFederationConfig returns default federation configuration
+func DefaultFederationConfig() *FederationConfig {
+	return &FederationConfig{
+		Enabled:           false,
+		Prefix:            "gateway:federation:",
+		Resources:         []string{"api_keys", "exemptions", "penalties"},
+		Interval:          time.Second,
+		HeartbeatInterval: 10 * time.Second,
+		MaxLen:            100000,
+	}
+}
+
+// LoadFederationConfig loads federation configuration from environment
+func LoadFederationConfig() *FederationConfig {
+	config := DefaultFederationConfig()
+
+	config.Enabled = getEnvBool("FEDERATION_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Region = getEnvString("FEDERATION_REGION", "")
+	config.Prefix = getEnvString("FEDERATION_PREFIX", config.Prefix)
+	config.Resources = getEnvList("FEDERATION_RESOURCES", config.Resources)
+	config.Interval = getEnvDuration("FEDERATION_INTERVAL", config.Interval)
+	config.HeartbeatInterval = getEnvDuration("FEDERATION_HEARTBEAT_INTERVAL", config.HeartbeatInterval)
+	config.MaxLen = getEnvInt("FEDERATION_MAX_LEN", config.MaxLen)
+
+	// The regions usually share a Redis of their own, apart from the one
+	// each region keeps its state in
+	shared := LoadRedisConfig()
+	config.Redis = RedisConfig{
+		Host:     getEnvString("FEDERATION_REDIS_HOST", shared.Host),
+		Port:     getEnvInt("FEDERATION_REDIS_PORT", shared.Port),
+		Password: getEnvString("FEDERATION_REDIS_PASSWORD", shared.Password),
+		DB:       getEnvInt("FEDERATION_REDIS_DB", shared.DB),
+		PoolSize: shared.PoolSize,
+	}
+
+	return config
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// FeatureFlagsConfig represents feature flags that routes and upstreams key off
+type FeatureFlagsConfig struct {
+	Enabled         bool                 `json:"enabled"`
+	Flags           []*FeatureFlagConfig `json:"flags"`
+	Routes          map[string]string    `json:"routes"` // Path prefix -> flag the route is only served with
+	Header          string               `json:"header"` // Request header listing the caller's flags for upstreams; empty disables
+	RefreshInterval time.Duration        `json:"refresh_interval"`
+	UseRedis        bool                 `json:"use_redis"`
+	Redis           RedisConfig          `json:"redis"`
+}
+
+// FeatureFlagConfig represents the configured settings of one flag
+type FeatureFlagConfig struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Enabled     bool       `json:"enabled"`
+	Percent     float64    `json:"percent"`         // Share of callers the flag is on for, 0-100
+	Roles       []string   `json:"roles,omitempty"` // Limits the flag to callers with one of these roles
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+}
+
+// DefaultFeatureFlagsConfig returns default feature flag configuration
+func DefaultFeatureFlagsConfig() *FeatureFlagsConfig {
+	return &FeatureFlagsConfig{
+		Enabled:         false,
+		Routes:          map[string]string{},
+		Header:          "X-Feature-Flags",
+		RefreshInterval: 10 * time.Second,
+		UseRedis:        false,
+	}
+}
+
+// LoadFeatureFlagsConfig loads feature flag configuration from environment.
+// FEATURE_FLAGS lists flags; each is configured with FEATURE_FLAG_<NAME>_* settings.
+func LoadFeatureFlagsConfig() *FeatureFlagsConfig {
+	config := DefaultFeatureFlagsConfig()
+
+	config.Enabled = getEnvBool("FEATURE_FLAGS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	for _, name := range getEnvList("FEATURE_FLAGS", nil) {
+		prefix := "FEATURE_FLAG_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config.Flags = append(config.Flags, &FeatureFlagConfig{
+			Name:        name,
+			Description: getEnvString(prefix+"DESCRIPTION", ""),
+			Enabled:     getEnvBool(prefix+"ENABLED", true),
+			Percent:     getEnvFloat(prefix+"PERCENT", 100),
+			Roles:       getEnvList(prefix+"ROLES", nil),
+			Start:       getEnvTime(prefix + "START"),
+			End:         getEnvTime(prefix + "END"),
+		})
+	}
+
+	config.Routes = getEnvMap("FEATURE_FLAG_ROUTES")
+	config.Header = getEnvString("FEATURE_FLAGS_HEADER", config.Header)
+	config.RefreshInterval = getEnvDuration("FEATURE_FLAGS_REFRESH_INTERVAL", config.RefreshInterval)
+	config.UseRedis = getEnvBool("FEATURE_FLAGS_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"time"
+)
+
+// GroupsConfig represents group membership configuration
+type GroupsConfig struct {
+	Enabled         bool          `json:"enabled"`
+	Resolve         string        `json:"resolve"` // "lookup" adds group roles per request, "issuance" bakes them into tokens
+	File            string        `json:"file"`    // JSON file holding the groups when Redis is not used
+	RefreshInterval time.Duration `json:"refresh_interval"`
+	UseRedis        bool          `json:"use_redis"`
+	Redis           RedisConfig   `json:"redis"`
+}
+
+// DefaultGroupsConfig returns default group configuration
+func DefaultGroupsConfig() *GroupsConfig {
+	return &GroupsConfig{
+		Enabled:         false,
+		Resolve:         "lookup",
+		File:            "groups.json",
+		RefreshInterval: 30 * time.Second,
+		UseRedis:        false,
+	}
+}
+
+// LoadGroupsConfig loads group configuration from environment
+func LoadGroupsConfig() *GroupsConfig {
+	config := DefaultGroupsConfig()
+
+	config.Enabled = getEnvBool("GROUPS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Resolve = getEnvString("GROUPS_RESOLVE", config.Resolve)
+	config.File = getEnvString("GROUPS_FILE", config.File)
+	config.RefreshInterval = getEnvDuration("GROUPS_REFRESH_INTERVAL", config.RefreshInterval)
+	config.UseRedis = getEnvBool("GROUPS_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
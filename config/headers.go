@@ -0,0 +1,70 @@
+package config
+
+import (
+	"strings"
+)
+
+// HeaderPolicyConfig represents response header changes for a set of routes
+type HeaderPolicyConfig struct {
+	Name         string            `json:"name"`
+	Paths        []string          `json:"paths"`  // Route prefixes the policy applies to
+	Remove       []string          `json:"remove"` // Headers stripped from responses
+	Set          map[string]string `json:"set"`    // Headers added or replaced
+	CacheControl string            `json:"cache_control"`
+	CacheStatus  bool              `json:"cache_status"` // Add a Cache-Status header (RFC 9211)
+}
+
+// HeadersConfig represents response header policy configuration
+type HeadersConfig struct {
+	Enabled bool                  `json:"enabled"`
+	Default *HeaderPolicyConfig   `json:"default"` // Applied to every response
+	Routes  []*HeaderPolicyConfig `json:"routes"`  // Applied after the default, most specific prefix last
+}
+
+// DefaultHeadersConfig returns default response header policy configuration
+func DefaultHeadersConfig() *HeadersConfig {
+	return &HeadersConfig{
+		Enabled: false,
+		Default: &HeaderPolicyConfig{
+			Name:   "default",
+			Remove: []string{"Server", "X-Powered-By"},
+			Set:    map[string]string{},
+		},
+	}
+}
+
+// LoadHeadersConfig loads response header policies from environment.
+// HEADERS_* configure the default policy; HEADER_POLICIES lists route
+// policies, each configured with HEADER_POLICY_<NAME>_* settings.
+func LoadHeadersConfig() *HeadersConfig {
+	config := DefaultHeadersConfig()
+
+	config.Enabled = getEnvBool("HEADERS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Default.Remove = getEnvList("HEADERS_REMOVE", config.Default.Remove)
+	config.Default.Set = getEnvMap("HEADERS_SET")
+	config.Default.CacheControl = getEnvString("HEADERS_CACHE_CONTROL", "")
+	config.Default.CacheStatus = getEnvBool("HEADERS_CACHE_STATUS", false)
+
+	for _, name := range getEnvList("HEADER_POLICIES", nil) {
+		prefix := headerPolicyPrefix(name)
+		config.Routes = append(config.Routes, &HeaderPolicyConfig{
+			Name:         name,
+			Paths:        getEnvList(prefix+"PATHS", []string{"/" + name}),
+			Remove:       getEnvList(prefix+"REMOVE", nil),
+			Set:          getEnvMap(prefix + "SET"),
+			CacheControl: getEnvString(prefix+"CACHE_CONTROL", ""),
+			CacheStatus:  getEnvBool(prefix+"CACHE_STATUS", false),
+		})
+	}
+
+	return config
+}
+
+// headerPolicyPrefix returns the environment prefix of a route policy's settings
+func headerPolicyPrefix(name string) string {
+	return "HEADER_POLICY_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"time"
+)
+
+// IdempotencyConfig represents Idempotency-Key handling configuration
+type IdempotencyConfig struct {
+	Enabled     bool          `json:"enabled"`
+	TTL         time.Duration `json:"ttl"`
+	Methods     []string      `json:"methods"`
+	MaxBodySize int           `json:"max_body_size"` // Largest response body that will be stored
+	UseRedis    bool          `json:"use_redis"`
+	Redis       RedisConfig   `json:"redis"`
+
+	MaxRequestSize int64 `json:"max_request_size"` // Largest request body accepted with an Idempotency-Key
+}
+
+// DefaultIdempotencyConfig returns default idempotency configuration
+func DefaultIdempotencyConfig() *IdempotencyConfig {
+	return &IdempotencyConfig{
+		Enabled:     false,
+		TTL:         24 * time.Hour,
+		Methods:     []string{"POST"},
+		MaxBodySize: 1 << 20, // 1MB
+		UseRedis:    false,
+
+		MaxRequestSize: 1 << 20, // 1MB
+	}
+}
+
+// LoadIdempotencyConfig loads idempotency configuration from environment
+func LoadIdempotencyConfig() *IdempotencyConfig {
+	config := DefaultIdempotencyConfig()
+
+	config.Enabled = getEnvBool("IDEMPOTENCY_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.TTL = getEnvDuration("IDEMPOTENCY_TTL", config.TTL)
+	config.Methods = getEnvList("IDEMPOTENCY_METHODS", config.Methods)
+	config.MaxBodySize = getEnvInt("IDEMPOTENCY_MAX_BODY_SIZE", config.MaxBodySize)
+	config.MaxRequestSize = int64(getEnvInt("IDEMPOTENCY_MAX_REQUEST_SIZE", int(config.MaxRequestSize)))
+	config.UseRedis = getEnvBool("IDEMPOTENCY_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
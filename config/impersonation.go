@@ -0,0 +1,19 @@
+package config
+
+import (
+	"time"
+)
+
+// ImpersonationConfig represents admin impersonation token configuration
+type ImpersonationConfig struct {
+	Enabled     bool          `json:"enabled"`
+	MaxLifetime time.Duration `json:"max_lifetime"` // Longest lifetime of an impersonation token
+}
+
+// LoadImpersonationConfig loads impersonation configuration from environment
+func LoadImpersonationConfig() *ImpersonationConfig {
+	return &ImpersonationConfig{
+		Enabled:     getEnvBool("IMPERSONATION_ENABLED", false),
+		MaxLifetime: getEnvDuration("IMPERSONATION_MAX_LIFETIME", time.Hour),
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// GatewayIssuer names the gateway's own token issuer (JWT_SECRET, JWT_ISSUER
+// and JWT_AUDIENCE) in per-route issuer lists
+const GatewayIssuer = "gateway"
+
+// TrustedIssuerConfig represents an external identity provider whose tokens
+// routes may accept
+type TrustedIssuerConfig struct {
+	Name        string        `json:"name"`
+	Issuer      string        `json:"issuer"`             // Expected "iss" claim
+	Audience    string        `json:"audience"`           // Expected "aud" claim unless a route overrides it
+	Secret      string        `json:"secret,omitempty"`   // HMAC secret for HS256 tokens
+	JWKSURL     string        `json:"jwks_url,omitempty"` // Key set for RS256/ES256 tokens
+	JWKSRefresh time.Duration `json:"jwks_refresh"`
+}
+
+// LoadTrustedIssuersConfig loads external issuers from environment.
+// JWT_TRUSTED_ISSUERS lists issuer names; each is configured with JWT_ISSUER_<NAME>_* settings.
+func LoadTrustedIssuersConfig(defaultAudience string) []*TrustedIssuerConfig {
+	var issuers []*TrustedIssuerConfig
+	for _, name := range getEnvList("JWT_TRUSTED_ISSUERS", nil) {
+		prefix := trustedIssuerPrefix(name)
+		issuers = append(issuers, &TrustedIssuerConfig{
+			Name:        name,
+			Issuer:      getEnvString(prefix+"ISSUER", ""),
+			Audience:    getEnvString(prefix+"AUDIENCE", defaultAudience),
+			Secret:      getEnvString(prefix+"SECRET", ""),
+			JWKSURL:     getEnvString(prefix+"JWKS_URL", ""),
+			JWKSRefresh: getEnvDuration(prefix+"JWKS_REFRESH", time.Hour),
+		})
+	}
+	return issuers
+}
+
+// trustedIssuerPrefix returns the environment prefix of an issuer's settings
+func trustedIssuerPrefix(name string) string {
+	return "JWT_ISSUER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// Configuration is layered, later layers overriding earlier ones:
+//
+//	built-in defaults
+//	$CONFIG_DIR/gateway.env                 shared settings
+//	$CONFIG_DIR/gateway.<GATEWAY_ENV>.env   profile settings (development, staging, production)
+//	.env                                    local overrides
+//	process environment
+//
+// All files use dotenv syntax and are optional.
+
+// envFile is the optional local dotenv file
+const envFile = ".env"
+
+// layer is one configuration file
+type layer struct {
+	path   string
+	values map[string]string
+}
+
+var (
+	layersOnce   sync.Once
+	loadedLayers []layer // Highest precedence first
+	layersErr    error
+)
+
+// loadLayers reads the configuration files once
+func loadLayers() ([]layer, error) {
+	layersOnce.Do(func() {
+		dir := os.Getenv("CONFIG_DIR")
+		if dir == "" {
+			dir = "."
+		}
+
+		local, err := readLayer(envFile)
+		if err != nil {
+			layersErr = err
+			return
+		}
+		base, err := readLayer(filepath.Join(dir, "gateway.env"))
+		if err != nil {
+			layersErr = err
+			return
+		}
+
+		// The profile can itself come from a file layer
+		profile := os.Getenv("GATEWAY_ENV")
+		if profile == "" {
+			profile = local.values["GATEWAY_ENV"]
+		}
+		if profile == "" {
+			profile = base.values["GATEWAY_ENV"]
+		}
+		if profile == "" {
+			profile = "development"
+		}
+
+		profiled, err := readLayer(filepath.Join(dir, "gateway."+profile+".env"))
+		if err != nil {
+			layersErr = err
+			return
+		}
+
+		for _, l := range []layer{local, profiled, base} {
+			if l.values != nil {
+				loadedLayers = append(loadedLayers, l)
+			}
+		}
+	})
+	return loadedLayers, layersErr
+}
+
+// readLayer reads a dotenv file; a missing file yields an empty layer
+func readLayer(path string) (layer, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return layer{path: path}, nil
+	}
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return layer{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return layer{path: path, values: values}, nil
+}
+
+// lookupEnv resolves a setting through the layers, returning its value and
+// where it came from ("environment" or the file path)
+func lookupEnv(key string) (value, source string, ok bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, "environment", true
+	}
+	layers, _ := loadLayers()
+	for _, l := range layers {
+		if value, ok := l.values[key]; ok {
+			return value, l.path, true
+		}
+	}
+	return "", "", false
+}
+
+// getEnv returns the effective value of a setting, or "" if unset
+func getEnv(key string) string {
+	value, _, _ := lookupEnv(key)
+	return value
+}
+
+// LayerFiles returns the configuration files that were loaded, highest precedence first
+func LayerFiles() []string {
+	layers, _ := loadLayers()
+	files := make([]string, 0, len(layers))
+	for _, l := range layers {
+		files = append(files, l.path)
+	}
+	return files
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// LDAPConfig represents the LDAP or Active Directory user store used by /login
+type LDAPConfig struct {
+	Enabled            bool                `json:"enabled"`
+	URL                string              `json:"url"` // ldap://host:389 or ldaps://host:636
+	StartTLS           bool                `json:"start_tls"`
+	CAFile             string              `json:"ca_file"`
+	InsecureSkipVerify bool                `json:"insecure_skip_verify"`
+	BindDN             string              `json:"bind_dn"` // Service account searching for users; anonymous when empty
+	BindPassword       string              `json:"bind_password"`
+	BaseDN             string              `json:"base_dn"`
+	UserFilter         string              `json:"user_filter"` // %s is replaced with the username
+	IDAttribute        string              `json:"id_attribute"`
+	EmailAttribute     string              `json:"email_attribute"`
+	GroupAttribute     string              `json:"group_attribute"`
+	RoleGroups         map[string][]string `json:"role_groups"` // Role to the names of groups granting it
+	DefaultRoles       []string            `json:"default_roles"`
+	PoolSize           int                 `json:"pool_size"`
+	Timeout            time.Duration       `json:"timeout"`
+}
+
+// DefaultLDAPConfig returns default LDAP configuration
+func DefaultLDAPConfig() *LDAPConfig {
+	return &LDAPConfig{
+		Enabled:        false,
+		URL:            "ldap://localhost:389",
+		UserFilter:     "(uid=%s)",
+		IDAttribute:    "uid",
+		EmailAttribute: "mail",
+		GroupAttribute: "memberOf",
+		DefaultRoles:   []string{"user"},
+		PoolSize:       5,
+		Timeout:        5 * time.Second,
+	}
+}
+
+// LoadLDAPConfig loads LDAP configuration from environment.
+// LDAP_ROLES lists role names; each is granted to the groups in LDAP_ROLE_<NAME>_GROUPS.
+func LoadLDAPConfig() *LDAPConfig {
+	config := DefaultLDAPConfig()
+
+	config.Enabled = getEnvBool("LDAP_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.URL = getEnvString("LDAP_URL", config.URL)
+	config.StartTLS = getEnvBool("LDAP_START_TLS", false)
+	config.CAFile = getEnvString("LDAP_TLS_CA_FILE", "")
+	config.InsecureSkipVerify = getEnvBool("LDAP_TLS_INSECURE_SKIP_VERIFY", false)
+	config.BindDN = getEnvString("LDAP_BIND_DN", "")
+	config.BindPassword = getEnvString("LDAP_BIND_PASSWORD", "")
+	config.BaseDN = getEnvString("LDAP_BASE_DN", "")
+	config.UserFilter = getEnvString("LDAP_USER_FILTER", config.UserFilter)
+	config.IDAttribute = getEnvString("LDAP_ID_ATTRIBUTE", config.IDAttribute)
+	config.EmailAttribute = getEnvString("LDAP_EMAIL_ATTRIBUTE", config.EmailAttribute)
+	config.GroupAttribute = getEnvString("LDAP_GROUP_ATTRIBUTE", config.GroupAttribute)
+	config.DefaultRoles = getEnvList("LDAP_DEFAULT_ROLES", config.DefaultRoles)
+	config.PoolSize = getEnvInt("LDAP_POOL_SIZE", config.PoolSize)
+	config.Timeout = getEnvDuration("LDAP_TIMEOUT", config.Timeout)
+
+	config.RoleGroups = make(map[string][]string)
+	for _, role := range getEnvList("LDAP_ROLES", nil) {
+		prefix := "LDAP_ROLE_" + strings.ToUpper(strings.ReplaceAll(role, "-", "_")) + "_"
+		config.RoleGroups[role] = getEnvList(prefix+"GROUPS", nil)
+	}
+
+	return config
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+)
+
+// MaskingRuleConfig represents masked response fields for a set of routes
+type MaskingRuleConfig struct {
+	Name        string   `json:"name"`
+	Paths       []string `json:"paths"`        // Route prefixes the rule applies to
+	Fields      []string `json:"fields"`       // JSONPath selectors, e.g. $.email or $..phone
+	UnmaskRoles []string `json:"unmask_roles"` // Roles that see the original values
+	Mask        string   `json:"mask"`
+}
+
+// MaskingConfig represents role-based response masking configuration
+type MaskingConfig struct {
+	Enabled bool                 `json:"enabled"`
+	Rules   []*MaskingRuleConfig `json:"rules"`
+}
+
+// DefaultMaskingConfig returns default response masking configuration
+func DefaultMaskingConfig() *MaskingConfig {
+	return &MaskingConfig{
+		Enabled: false,
+	}
+}
+
+// LoadMaskingConfig loads response masking rules from environment.
+// MASKING_RULES lists rules, each configured with MASKING_RULE_<NAME>_* settings.
+func LoadMaskingConfig() *MaskingConfig {
+	config := DefaultMaskingConfig()
+
+	config.Enabled = getEnvBool("MASKING_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	for _, name := range getEnvList("MASKING_RULES", nil) {
+		prefix := maskingRulePrefix(name)
+		config.Rules = append(config.Rules, &MaskingRuleConfig{
+			Name:        name,
+			Paths:       getEnvList(prefix+"PATHS", []string{"/" + name}),
+			Fields:      getEnvList(prefix+"FIELDS", nil),
+			UnmaskRoles: getEnvList(prefix+"UNMASK_ROLES", []string{"pii:read"}),
+			Mask:        getEnvString(prefix+"MASK", "***"),
+		})
+	}
+
+	return config
+}
+
+// maskingRulePrefix returns the environment prefix of a masking rule's settings
+func maskingRulePrefix(name string) string {
+	return "MASKING_RULE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
@@ -0,0 +1,63 @@
+package config
+
+import "time"
+
+// MeteringConfig represents pushing usage to a billing provider's metering API
+type MeteringConfig struct {
+	Enabled           bool              `json:"enabled"`
+	Provider          string            `json:"provider"` // "stripe" or "http"
+	Quantity          string            `json:"quantity"` // "requests", "bytes" or "cost_units"
+	Interval          time.Duration     `json:"interval"`
+	MaxRetries        int               `json:"max_retries"`
+	RetryBackoff      time.Duration     `json:"retry_backoff"`
+	Timeout           time.Duration     `json:"timeout"`
+	StripeURL         string            `json:"stripe_url"`
+	StripeAPIKey      string            `json:"stripe_api_key"`
+	SubscriptionItems map[string]string `json:"subscription_items"` // Consumer or tenant -> Stripe subscription item
+	HTTPURL           string            `json:"http_url"`
+	HTTPToken         string            `json:"http_token"`
+	UseRedis          bool              `json:"use_redis"`
+	Redis             RedisConfig       `json:"redis"`
+}
+
+// DefaultMeteringConfig returns default metering configuration
+func DefaultMeteringConfig() *MeteringConfig {
+	return &MeteringConfig{
+		Enabled:           false,
+		Provider:          "stripe",
+		Quantity:          "requests",
+		Interval:          time.Hour,
+		MaxRetries:        3,
+		RetryBackoff:      2 * time.Second,
+		Timeout:           10 * time.Second,
+		StripeURL:         "https://api.stripe.com",
+		SubscriptionItems: map[string]string{},
+		UseRedis:          false,
+	}
+}
+
+// LoadMeteringConfig loads metering configuration from environment
+func LoadMeteringConfig() *MeteringConfig {
+	config := DefaultMeteringConfig()
+
+	config.Enabled = getEnvBool("METERING_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Provider = getEnvString("METERING_PROVIDER", config.Provider)
+	config.Quantity = getEnvString("METERING_QUANTITY", config.Quantity)
+	config.Interval = getEnvDuration("METERING_INTERVAL", config.Interval)
+	config.MaxRetries = getEnvInt("METERING_MAX_RETRIES", config.MaxRetries)
+	config.RetryBackoff = getEnvDuration("METERING_RETRY_BACKOFF", config.RetryBackoff)
+	config.Timeout = getEnvDuration("METERING_TIMEOUT", config.Timeout)
+	config.StripeURL = getEnvString("METERING_STRIPE_URL", config.StripeURL)
+	config.StripeAPIKey = getEnvString("METERING_STRIPE_API_KEY", "")
+	config.SubscriptionItems = getEnvMap("METERING_STRIPE_SUBSCRIPTION_ITEMS")
+	config.HTTPURL = getEnvString("METERING_HTTP_URL", "")
+	config.HTTPToken = getEnvString("METERING_HTTP_TOKEN", "")
+	config.UseRedis = getEnvBool("METERING_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
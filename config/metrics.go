@@ -0,0 +1,35 @@
+package config
+
+// MetricsConfig represents metrics exposure configuration
+type MetricsConfig struct {
+	Enabled           bool     `json:"enabled"`
+	Path              string   `json:"path"`
+	RouteAllowlist    []string `json:"route_allowlist"`    // Route templates, or prefixes ending in "*", labeled individually; empty allows all
+	MaxRoutes         int      `json:"max_routes"`         // Distinct route labels before the rest become "other"; 0 is unlimited
+	ConsumerAllowlist []string `json:"consumer_allowlist"` // Consumers, or prefixes ending in "*", labeled individually; empty allows all
+	MaxConsumers      int      `json:"max_consumers"`      // Distinct consumer labels before the rest become "other"; 0 is unlimited
+}
+
+// DefaultMetricsConfig returns default metrics configuration
+func DefaultMetricsConfig() *MetricsConfig {
+	return &MetricsConfig{
+		Enabled:      true,
+		Path:         "/metrics",
+		MaxRoutes:    500,
+		MaxConsumers: 1000,
+	}
+}
+
+// LoadMetricsConfig loads metrics configuration from environment
+func LoadMetricsConfig() *MetricsConfig {
+	config := DefaultMetricsConfig()
+
+	config.Enabled = getEnvBool("METRICS_ENABLED", true)
+	config.Path = getEnvString("METRICS_PATH", "/metrics")
+	config.RouteAllowlist = getEnvList("METRICS_ROUTE_ALLOWLIST", nil)
+	config.MaxRoutes = getEnvInt("METRICS_MAX_ROUTES", config.MaxRoutes)
+	config.ConsumerAllowlist = getEnvList("METRICS_CONSUMER_ALLOWLIST", nil)
+	config.MaxConsumers = getEnvInt("METRICS_MAX_CONSUMERS", config.MaxConsumers)
+
+	return config
+}
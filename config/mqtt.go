@@ -0,0 +1,70 @@
+package config
+
+import (
+	"time"
+)
+
+// MQTTConfig represents the MQTT listener, which lets devices publish
+// messages that are bridged to upstream routes as HTTP requests
+type MQTTConfig struct {
+	Enabled        bool              `json:"enabled"`
+	Host           string            `json:"host"` // Bind address; empty listens on all interfaces
+	Port           string            `json:"port"`
+	TLS            bool              `json:"tls"`    // Serve MQTT over TLS with TLS_CERT_FILE and TLS_KEY_FILE
+	Routes         map[string]string `json:"routes"` // Topic filter -> gateway path messages are posted to
+	ContentType    string            `json:"content_type"`
+	MaxMessageSize int               `json:"max_message_size"`
+	MaxConnections int               `json:"max_connections"`
+	ConnectTimeout time.Duration     `json:"connect_timeout"` // How long a new connection has to authenticate
+	MessageTimeout time.Duration     `json:"message_timeout"`
+	RateCapacity   int               `json:"rate_capacity"` // Messages a device can publish in a burst; 0 disables limits
+	RateRefillRate int               `json:"rate_refill_rate"`
+	UseRedis       bool              `json:"use_redis"`
+	Redis          RedisConfig       `json:"redis"`
+}
+
+// DefaultMQTTConfig returns default MQTT listener configuration
+func DefaultMQTTConfig() *MQTTConfig {
+	return &MQTTConfig{
+		Enabled:        false,
+		Port:           "1883",
+		Routes:         map[string]string{},
+		ContentType:    "application/json",
+		MaxMessageSize: 256 << 10, // 256KB
+		MaxConnections: 10000,
+		ConnectTimeout: 10 * time.Second,
+		MessageTimeout: 30 * time.Second,
+		RateCapacity:   20,
+		RateRefillRate: 5,
+		UseRedis:       false,
+	}
+}
+
+// LoadMQTTConfig loads MQTT listener configuration from environment
+func LoadMQTTConfig() *MQTTConfig {
+	config := DefaultMQTTConfig()
+
+	config.Enabled = getEnvBool("MQTT_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Host = getEnvString("MQTT_HOST", config.Host)
+	config.TLS = getEnvBool("MQTT_TLS", config.TLS)
+	if config.TLS {
+		config.Port = "8883"
+	}
+	config.Port = getEnvString("MQTT_PORT", config.Port)
+	config.Routes = getEnvMap("MQTT_ROUTES")
+	config.ContentType = getEnvString("MQTT_CONTENT_TYPE", config.ContentType)
+	config.MaxMessageSize = getEnvInt("MQTT_MAX_MESSAGE_SIZE", config.MaxMessageSize)
+	config.MaxConnections = getEnvInt("MQTT_MAX_CONNECTIONS", config.MaxConnections)
+	config.ConnectTimeout = getEnvDuration("MQTT_CONNECT_TIMEOUT", config.ConnectTimeout)
+	config.MessageTimeout = getEnvDuration("MQTT_MESSAGE_TIMEOUT", config.MessageTimeout)
+	config.RateCapacity = getEnvInt("MQTT_RATE_LIMIT_CAPACITY", config.RateCapacity)
+	config.RateRefillRate = getEnvInt("MQTT_RATE_LIMIT_REFILL_RATE", config.RateRefillRate)
+	config.UseRedis = getEnvBool("MQTT_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
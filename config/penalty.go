@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PenaltyBoxConfig represents escalating penalties for clients that are
+// repeatedly rejected
+type PenaltyBoxConfig struct {
+	Enabled         bool          `json:"enabled"`
+	Statuses        []int         `json:"statuses"` // Response statuses counted as strikes
+	StrikeWindow    time.Duration `json:"strike_window"`
+	LimitAfter      int           `json:"limit_after"` // Strikes within the window before a client is limited; 0 disables
+	LimitDuration   time.Duration `json:"limit_duration"`
+	LimitCapacity   int           `json:"limit_capacity"` // Rate limit of limited clients
+	LimitRefillRate int           `json:"limit_refill_rate"`
+	BanAfter        int           `json:"ban_after"` // Strikes within the window before a client is banned; 0 disables
+	BanDuration     time.Duration `json:"ban_duration"`
+	History         time.Duration `json:"history"` // How long offences count towards longer penalties
+	MaxDuration     time.Duration `json:"max_duration"`
+	UseRedis        bool          `json:"use_redis"`
+	Redis           RedisConfig   `json:"redis"`
+}
+
+// DefaultPenaltyBoxConfig returns default penalty box configuration
+func DefaultPenaltyBoxConfig() *PenaltyBoxConfig {
+	return &PenaltyBoxConfig{
+		Enabled:         false,
+		Statuses:        []int{401, 429},
+		StrikeWindow:    time.Minute,
+		LimitAfter:      20,
+		LimitDuration:   10 * time.Minute,
+		LimitCapacity:   5,
+		LimitRefillRate: 1,
+		BanAfter:        60,
+		BanDuration:     time.Hour,
+		History:         24 * time.Hour,
+		MaxDuration:     24 * time.Hour,
+		UseRedis:        false,
+	}
+}
+
+// LoadPenaltyBoxConfig loads penalty box configuration from environment
+func LoadPenaltyBoxConfig() *PenaltyBoxConfig {
+	config := DefaultPenaltyBoxConfig()
+
+	config.Enabled = getEnvBool("PENALTY_BOX_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	if values := getEnvList("PENALTY_BOX_STATUSES", nil); values != nil {
+		config.Statuses = nil
+		for _, value := range values {
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				recordInvalid("PENALTY_BOX_STATUSES", getEnv("PENALTY_BOX_STATUSES"), fmt.Errorf("%q is not a status code", value))
+				continue
+			}
+			config.Statuses = append(config.Statuses, status)
+		}
+	}
+	config.StrikeWindow = getEnvDuration("PENALTY_BOX_STRIKE_WINDOW", config.StrikeWindow)
+	config.LimitAfter = getEnvInt("PENALTY_BOX_LIMIT_AFTER", config.LimitAfter)
+	config.LimitDuration = getEnvDuration("PENALTY_BOX_LIMIT_DURATION", config.LimitDuration)
+	config.LimitCapacity = getEnvInt("PENALTY_BOX_LIMIT_CAPACITY", config.LimitCapacity)
+	config.LimitRefillRate = getEnvInt("PENALTY_BOX_LIMIT_REFILL_RATE", config.LimitRefillRate)
+	config.BanAfter = getEnvInt("PENALTY_BOX_BAN_AFTER", config.BanAfter)
+	config.BanDuration = getEnvDuration("PENALTY_BOX_BAN_DURATION", config.BanDuration)
+	config.History = getEnvDuration("PENALTY_BOX_HISTORY", config.History)
+	config.MaxDuration = getEnvDuration("PENALTY_BOX_MAX_DURATION", config.MaxDuration)
+	config.UseRedis = getEnvBool("PENALTY_BOX_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
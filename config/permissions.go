@@ -0,0 +1,37 @@
+package config
+
+import (
+	"strings"
+)
+
+// PermissionsConfig represents the permissions granted to each role, used by
+// permission checks in handlers ("resource:action", "resource:*" or "*")
+type PermissionsConfig struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// DefaultPermissionsConfig returns default permissions configuration
+func DefaultPermissionsConfig() *PermissionsConfig {
+	return &PermissionsConfig{
+		Roles: map[string][]string{"admin": {"*"}},
+	}
+}
+
+// LoadPermissionsConfig loads role permissions from environment.
+// RBAC_ROLES lists role names; each is configured with RBAC_ROLE_<NAME>_PERMISSIONS.
+func LoadPermissionsConfig() *PermissionsConfig {
+	config := DefaultPermissionsConfig()
+
+	roles := getEnvList("RBAC_ROLES", nil)
+	if len(roles) == 0 {
+		return config
+	}
+
+	config.Roles = make(map[string][]string, len(roles))
+	for _, role := range roles {
+		prefix := "RBAC_ROLE_" + strings.ToUpper(strings.ReplaceAll(role, "-", "_")) + "_"
+		config.Roles[role] = getEnvList(prefix+"PERMISSIONS", nil)
+	}
+
+	return config
+}
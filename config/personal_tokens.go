@@ -0,0 +1,46 @@
+package config
+
+import (
+	"time"
+)
+
+// PersonalTokensConfig represents personal access tokens users mint for scripts and CI
+type PersonalTokensConfig struct {
+	Enabled         bool          `json:"enabled"`
+	File            string        `json:"file"`         // JSON file holding the tokens when Redis is not used
+	MaxPerUser      int           `json:"max_per_user"` // Tokens a user may hold at once
+	MaxLifetime     time.Duration `json:"max_lifetime"` // Longest allowed expiry; zero allows tokens that never expire
+	RefreshInterval time.Duration `json:"refresh_interval"`
+	UseRedis        bool          `json:"use_redis"`
+	Redis           RedisConfig   `json:"redis"`
+}
+
+// DefaultPersonalTokensConfig returns default personal access token configuration
+func DefaultPersonalTokensConfig() *PersonalTokensConfig {
+	return &PersonalTokensConfig{
+		Enabled:         false,
+		File:            "personal_tokens.json",
+		MaxPerUser:      50,
+		RefreshInterval: 30 * time.Second,
+		UseRedis:        false,
+	}
+}
+
+// LoadPersonalTokensConfig loads personal access token configuration from environment
+func LoadPersonalTokensConfig() *PersonalTokensConfig {
+	config := DefaultPersonalTokensConfig()
+
+	config.Enabled = getEnvBool("PERSONAL_TOKENS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.File = getEnvString("PERSONAL_TOKENS_FILE", config.File)
+	config.MaxPerUser = getEnvInt("PERSONAL_TOKENS_MAX_PER_USER", config.MaxPerUser)
+	config.MaxLifetime = getEnvDuration("PERSONAL_TOKENS_MAX_LIFETIME", config.MaxLifetime)
+	config.RefreshInterval = getEnvDuration("PERSONAL_TOKENS_REFRESH_INTERVAL", config.RefreshInterval)
+	config.UseRedis = getEnvBool("PERSONAL_TOKENS_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
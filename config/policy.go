@@ -0,0 +1,45 @@
+package config
+
+import (
+	"time"
+)
+
+// PolicyConfig represents policy-based authorization configuration
+type PolicyConfig struct {
+	Enabled  bool          `json:"enabled"`
+	Mode     string        `json:"mode"` // "augment" or "replace"
+	OPAURL   string        `json:"opa_url"`
+	Path     string        `json:"path"`
+	Timeout  time.Duration `json:"timeout"`
+	FailOpen bool          `json:"fail_open"`
+}
+
+// DefaultPolicyConfig returns default policy configuration
+func DefaultPolicyConfig() *PolicyConfig {
+	return &PolicyConfig{
+		Enabled:  false,
+		Mode:     "augment",
+		OPAURL:   "http://localhost:8181",
+		Path:     "/v1/data/gateway/authz",
+		Timeout:  2 * time.Second,
+		FailOpen: false,
+	}
+}
+
+// LoadPolicyConfig loads policy configuration from environment
+func LoadPolicyConfig() *PolicyConfig {
+	config := DefaultPolicyConfig()
+
+	config.Enabled = getEnvBool("OPA_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Mode = getEnvString("OPA_MODE", "augment")
+	config.OPAURL = getEnvString("OPA_URL", "http://localhost:8181")
+	config.Path = getEnvString("OPA_POLICY_PATH", "/v1/data/gateway/authz")
+	config.Timeout = getEnvDuration("OPA_TIMEOUT", 2*time.Second)
+	config.FailOpen = getEnvBool("OPA_FAIL_OPEN", false)
+
+	return config
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PortalConfig represents developer portal configuration
+type PortalConfig struct {
+	Enabled  bool           `json:"enabled"`
+	Plans    map[string]int `json:"plans"`    // Self-service plan -> requests per minute
+	MaxKeys  int            `json:"max_keys"` // Active keys a developer may hold
+	KeyTTL   time.Duration  `json:"key_ttl"`
+	KeyRoles []string       `json:"key_roles"` // Roles granted to self-service keys
+}
+
+// DefaultPortalConfig returns default developer portal configuration
+func DefaultPortalConfig() *PortalConfig {
+	return &PortalConfig{
+		Enabled:  false,
+		Plans:    map[string]int{"free": 60},
+		MaxKeys:  5,
+		KeyTTL:   90 * 24 * time.Hour,
+		KeyRoles: []string{"user"},
+	}
+}
+
+// LoadPortalConfig loads developer portal configuration from environment
+func LoadPortalConfig() *PortalConfig {
+	config := DefaultPortalConfig()
+
+	config.Enabled = getEnvBool("PORTAL_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	if getEnv("PORTAL_PLANS") != "" {
+		config.Plans = make(map[string]int)
+		for plan, value := range getEnvMap("PORTAL_PLANS") {
+			rateLimit, err := strconv.Atoi(value)
+			if err != nil {
+				recordInvalid("PORTAL_PLANS", getEnv("PORTAL_PLANS"), fmt.Errorf("rate limit %q for plan %q is not an integer", value, plan))
+				continue
+			}
+			config.Plans[plan] = rateLimit
+		}
+	}
+	config.MaxKeys = getEnvInt("PORTAL_MAX_KEYS", config.MaxKeys)
+	config.KeyTTL = getEnvDuration("PORTAL_KEY_TTL", config.KeyTTL)
+	config.KeyRoles = getEnvList("PORTAL_KEY_ROLES", config.KeyRoles)
+
+	return config
+}
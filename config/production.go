@@ -0,0 +1,52 @@
+package config
+
+import (
+	"net"
+)
+
+// ProductionOverrides explicitly permits settings that are refused in production
+type ProductionOverrides struct {
+	AllowInsecureJWTSecret       bool
+	AllowPlaintextListener       bool
+	AllowCORSWildcardCredentials bool
+}
+
+// CheckProduction returns the reasons the configuration is unsafe to run in
+// production, ignoring the ones explicitly overridden. It returns nil outside
+// production mode.
+func (c *Config) CheckProduction(overrides ProductionOverrides) []string {
+	if !c.Server.Production() {
+		return nil
+	}
+
+	var problems []string
+	if !overrides.AllowInsecureJWTSecret && c.InsecureJWTSecret() {
+		problems = append(problems, "JWT_SECRET is a well-known default (override with -allow-insecure-jwt-secret)")
+	}
+	if !overrides.AllowPlaintextListener && !c.Server.TLSEnabled() && publicHost(c.Server.Host) {
+		problems = append(problems, "TLS is disabled on a public listener; set TLS_CERT_FILE and TLS_KEY_FILE or bind HOST to loopback (override with -allow-plaintext)")
+	}
+	if !overrides.AllowCORSWildcardCredentials && c.CORS.AllowsAnyOrigin() && c.CORS.AllowCredentials {
+		problems = append(problems, "CORS allows any origin with credentials (override with -allow-cors-wildcard-credentials)")
+	}
+	return problems
+}
+
+// InsecureJWTSecret reports whether the JWT secret is a well-known default
+func (c *Config) InsecureJWTSecret() bool {
+	for _, secret := range insecureJWTSecrets {
+		if c.JWT.Secret == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// publicHost reports whether a bind address is reachable from other machines
+func publicHost(host string) bool {
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProductConfig represents an API product: a group of routes that API keys
+// subscribe to, with its own per-plan quotas
+type ProductConfig struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Paths       []string       `json:"paths"`  // Route prefixes included in the product
+	Plans       []string       `json:"plans"`  // Plans the product is offered on; empty offers it on every plan
+	Quotas      map[string]int `json:"quotas"` // Plan -> requests per minute per key within the product
+}
+
+// LoadProductsConfig loads API products from environment.
+// PRODUCTS lists product names; each is configured with PRODUCT_<NAME>_* settings.
+func LoadProductsConfig() []*ProductConfig {
+	var products []*ProductConfig
+
+	for _, name := range getEnvList("PRODUCTS", nil) {
+		prefix := productPrefix(name)
+
+		product := &ProductConfig{
+			Name:        name,
+			Description: getEnvString(prefix+"DESCRIPTION", ""),
+			Paths:       getEnvList(prefix+"PATHS", []string{"/" + name}),
+			Plans:       getEnvList(prefix+"PLANS", nil),
+			Quotas:      make(map[string]int),
+		}
+		for plan, value := range getEnvMap(prefix + "QUOTAS") {
+			quota, err := strconv.Atoi(value)
+			if err != nil {
+				recordInvalid(prefix+"QUOTAS", getEnv(prefix+"QUOTAS"), fmt.Errorf("quota %q for plan %q is not an integer", value, plan))
+				continue
+			}
+			product.Quotas[plan] = quota
+		}
+		products = append(products, product)
+	}
+
+	return products
+}
+
+// productPrefix returns the environment prefix of a product's settings
+func productPrefix(name string) string {
+	return "PRODUCT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
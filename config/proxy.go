@@ -0,0 +1,402 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// ProxyConfig represents reverse proxy configuration
+type ProxyConfig struct {
+	Upstreams         []*UpstreamConfig `json:"upstreams"`
+	TLSReloadInterval time.Duration     `json:"tls_reload_interval"` // How often upstream certificate files are checked for rotation
+	Region            string            `json:"region,omitempty"`    // The gateway's region, for zone-aware balancing
+	Zone              string            `json:"zone,omitempty"`      // The gateway's zone, for zone-aware balancing
+}
+
+// EchoURL is the default URL of echo upstreams. Nothing listens there: the
+// gateway answers the requests itself.
+const EchoURL = "http://gateway.internal/debug/echo"
+
+// InProcessURL is the default URL of in-process upstreams, whose requests are
+// served by a Go handler registered inside the gateway
+const InProcessURL = "http://gateway.internal"
+
+// UpstreamConfig represents one backend service
+type UpstreamConfig struct {
+	Name         string                   `json:"name"`
+	Type         string                   `json:"type"` // "http", "s3" for an S3-compatible bucket at URL, "echo" for the built-in echo target, "inprocess" for a registered handler, "webhook" for inbound webhooks or "broker" to publish to a message broker
+	URL          string                   `json:"url"`
+	Socket       string                   `json:"socket,omitempty"`        // Unix domain socket dialed instead of the URL's host
+	Handler      string                   `json:"handler,omitempty"`       // Registered handler serving an inprocess upstream
+	Backends     []string                 `json:"backends,omitempty"`      // Load-balanced backend URLs; empty sends everything to URL
+	BackendZones []string                 `json:"backend_zones,omitempty"` // "region/zone" or "zone" of each backend, in order
+	Balance      UpstreamBalanceConfig    `json:"balance"`
+	PathPrefix   string                   `json:"path_prefix"`  // Gateway path routed to this upstream
+	StripPrefix  bool                     `json:"strip_prefix"` // Remove PathPrefix before forwarding
+	Timeout      time.Duration            `json:"timeout"`
+	Async        string                   `json:"async"` // "off", "prefer" to queue requests sending "Prefer: respond-async", or "always"
+	Auth         UpstreamAuthConfig       `json:"auth"`
+	TLS          UpstreamTLSConfig        `json:"tls"`
+	Pool         UpstreamPoolConfig       `json:"pool"`
+	Cookies      UpstreamCookieConfig     `json:"cookies"`
+	Rewrites     []*RewriteRuleConfig     `json:"rewrites,omitempty"`     // Applied in order; the first match wins
+	ClaimRoutes  []*ClaimRouteConfig      `json:"claim_routes,omitempty"` // Checked in order; the first match wins
+	Response     UpstreamResponseConfig   `json:"response"`
+	Encryption   UpstreamEncryptionConfig `json:"encryption"`
+	JWT          UpstreamJWTConfig        `json:"jwt"`
+	S3           UpstreamS3Config         `json:"s3"`
+	Echo         UpstreamEchoConfig       `json:"echo"`
+	Webhook      UpstreamWebhookConfig    `json:"webhook"`
+	Broker       UpstreamBrokerConfig     `json:"broker"`
+}
+
+// UpstreamBrokerConfig represents the message broker a broker upstream
+// publishes request bodies to, at URL
+type UpstreamBrokerConfig struct {
+	Type            string            `json:"type"`             // "kafka" (REST Proxy), "nats" or "sqs"
+	Topic           string            `json:"topic"`            // For paths no entry of Topics matches; empty rejects them
+	Topics          map[string]string `json:"topics,omitempty"` // Path prefix, as the upstream sees it -> topic, subject or queue
+	KeyHeader       string            `json:"key_header"`       // Request header carrying the message key
+	Schema          string            `json:"schema,omitempty"` // OpenAPI 3 document in JSON whose request bodies are enforced
+	MaxBodySize     int               `json:"max_body_size"`
+	Username        string            `json:"username,omitempty"` // Kafka REST Proxy basic auth, NATS user or token
+	Password        string            `json:"password,omitempty"`
+	JetStream       bool              `json:"jetstream"` // Wait for a NATS JetStream acknowledgement
+	Region          string            `json:"region"`    // SQS
+	AccessKeyID     string            `json:"access_key_id,omitempty"`
+	SecretAccessKey string            `json:"secret_access_key,omitempty"`
+	SessionToken    string            `json:"session_token,omitempty"`
+}
+
+// UpstreamWebhookConfig represents verification of inbound webhooks on a
+// webhook upstream's route, which replaces gateway credentials
+type UpstreamWebhookConfig struct {
+	Provider    string        `json:"provider"`          // "github", "stripe" or "standard" (Standard Webhooks)
+	Secrets     []string      `json:"secrets,omitempty"` // Signing secrets; any of them may sign, so they can be rotated
+	Tolerance   time.Duration `json:"tolerance"`         // How far a signed timestamp may be from now
+	DedupeTTL   time.Duration `json:"dedupe_ttl"`        // How long delivery IDs are remembered
+	MaxBodySize int           `json:"max_body_size"`
+}
+
+// UpstreamEchoConfig represents the built-in target of an echo upstream, which
+// reflects requests back to the caller instead of forwarding them
+type UpstreamEchoConfig struct {
+	MaxBodySize int `json:"max_body_size"` // Bytes of the request body reflected
+}
+
+// UpstreamS3Config represents an S3-compatible bucket exposed by an s3 upstream
+type UpstreamS3Config struct {
+	Bucket          string        `json:"bucket"`
+	Region          string        `json:"region"`
+	AccessKeyID     string        `json:"access_key_id,omitempty"`
+	SecretAccessKey string        `json:"secret_access_key,omitempty"`
+	SessionToken    string        `json:"session_token,omitempty"`
+	KeyPrefix       string        `json:"key_prefix,omitempty"` // Prepended to object keys; "{claim}" is replaced by the caller's claim
+	PathStyle       bool          `json:"path_style"`           // Address the bucket in the path rather than the host name
+	Mode            string        `json:"mode"`                 // "proxy" streams objects through the gateway, "presign" redirects to presigned URLs
+	PresignExpiry   time.Duration `json:"presign_expiry"`
+	Methods         []string      `json:"methods"` // Allowed methods
+}
+
+// UpstreamBalanceConfig represents how requests are spread over an upstream's backends
+type UpstreamBalanceConfig struct {
+	Strategy          string                `json:"strategy"`           // "round_robin" or "consistent_hash"
+	HashKey           string                `json:"hash_key"`           // "ip", "cookie:<name>" or "header:<name>"
+	LoadFactor        float64               `json:"load_factor"`        // Caps a backend at this multiple of the average load; 0 disables
+	FailureCooldown   time.Duration         `json:"failure_cooldown"`   // How long a failing backend is skipped
+	SlowStart         time.Duration         `json:"slow_start"`         // Window over which a recovered backend ramps up to full traffic; 0 disables
+	ZoneOverprovision float64               `json:"zone_overprovision"` // How far a partly healthy zone is trusted before traffic spills over
+	Outliers          UpstreamOutlierConfig `json:"outlier_detection"`
+}
+
+// UpstreamOutlierConfig represents ejection of backends whose error rate or
+// latency stands out, based on proxied traffic
+type UpstreamOutlierConfig struct {
+	Enabled            bool          `json:"enabled"`
+	Interval           time.Duration `json:"interval"`             // How often backends are evaluated
+	MinRequests        int           `json:"min_requests"`         // Requests a backend needs in an interval to be evaluated
+	ErrorRate          float64       `json:"error_rate"`           // Fraction of 5xx responses and connection errors that ejects; 0 disables
+	Latency            time.Duration `json:"latency"`              // Mean latency that ejects; 0 disables
+	EjectionTime       time.Duration `json:"ejection_time"`        // Multiplied by consecutive ejections
+	MaxEjectionTime    time.Duration `json:"max_ejection_time"`    // Upper bound for a single ejection
+	MaxEjectionPercent int           `json:"max_ejection_percent"` // Share of backends that may be ejected at once
+}
+
+// UpstreamJWTConfig represents which token issuers a proxied route trusts
+type UpstreamJWTConfig struct {
+	Issuers  []string `json:"issuers"`            // Issuer names; "gateway" is the gateway's own issuer
+	Audience string   `json:"audience,omitempty"` // Overrides the audience expected from every issuer
+}
+
+// UpstreamEncryptionConfig represents payload encryption between the gateway
+// and an upstream
+type UpstreamEncryptionConfig struct {
+	Key   string   `json:"key,omitempty"`    // Base64 AES key (16, 24 or 32 bytes); empty disables encryption
+	KeyID string   `json:"key_id,omitempty"` // JWE "kid" shared with the upstream
+	Paths []string `json:"paths,omitempty"`  // Upstream path prefixes; empty encrypts every route
+}
+
+// UpstreamResponseConfig represents validation of upstream responses against
+// the upstream's OpenAPI document
+type UpstreamResponseConfig struct {
+	Schema       string `json:"schema,omitempty"` // OpenAPI 3 document in JSON; empty disables validation
+	Validation   string `json:"validation"`       // "report" logs violations, "enforce" replaces them with 502
+	StripHeaders bool   `json:"strip_headers"`    // Remove response headers the document does not declare
+}
+
+// RewriteRuleConfig represents a regex path rewrite for an upstream
+type RewriteRuleConfig struct {
+	Name        string            `json:"name"`
+	Match       string            `json:"match"`   // Regular expression matched against the upstream path
+	Replace     string            `json:"replace"` // New path with $1 or ${name} capture group references
+	AddQuery    map[string]string `json:"add_query,omitempty"`
+	RemoveQuery []string          `json:"remove_query,omitempty"`
+}
+
+// ClaimRouteConfig represents routing and tagging of requests by JWT claims
+type ClaimRouteConfig struct {
+	Name    string            `json:"name"`
+	Claims  map[string]string `json:"claims"`            // Claim -> required value; "*" only requires the claim
+	URL     string            `json:"url,omitempty"`     // Upstream URL for matching requests; empty keeps the upstream's
+	Headers map[string]string `json:"headers,omitempty"` // Set on matching requests; "{claim}" is replaced by the claim's value
+}
+
+// UpstreamCookieConfig represents cookie handling for a proxied upstream
+type UpstreamCookieConfig struct {
+	Strip          []string          `json:"strip,omitempty"`           // Request cookies removed before proxying
+	DomainRewrites map[string]string `json:"domain_rewrites,omitempty"` // Set-Cookie domain -> replacement
+	PathRewrites   map[string]string `json:"path_rewrites,omitempty"`   // Set-Cookie path prefix -> replacement
+	Secure         bool              `json:"secure"`
+	SameSite       string            `json:"same_site,omitempty"` // "lax", "strict", "none" or empty to keep
+}
+
+// Enabled reports whether any cookie handling is configured
+func (c UpstreamCookieConfig) Enabled() bool {
+	return len(c.Strip) > 0 || len(c.DomainRewrites) > 0 || len(c.PathRewrites) > 0 || c.Secure || c.SameSite != ""
+}
+
+// UpstreamPoolConfig represents connection pool and keep-alive settings for an upstream
+type UpstreamPoolConfig struct {
+	MaxIdleConns        int           `json:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `json:"max_conns_per_host"` // 0 means unlimited
+	IdleConnTimeout     time.Duration `json:"idle_conn_timeout"`
+	TLSHandshakeTimeout time.Duration `json:"tls_handshake_timeout"`
+	DisableKeepAlives   bool          `json:"disable_keep_alives"`
+	DialTimeout         time.Duration `json:"dial_timeout"`
+	IPFamily            string        `json:"ip_family"`      // "dual", "ipv4" or "ipv6"
+	FallbackDelay       time.Duration `json:"fallback_delay"` // Happy-eyeballs delay; negative disables
+	DNSResolver         string        `json:"dns_resolver,omitempty"`
+}
+
+// UpstreamTLSConfig represents mutual TLS settings for connecting to an upstream
+type UpstreamTLSConfig struct {
+	CAFile     string `json:"ca_file,omitempty"`
+	CertFile   string `json:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// Enabled reports whether custom TLS settings are configured
+func (c UpstreamTLSConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != ""
+}
+
+// UpstreamAuthConfig represents the credentials the gateway attaches to proxied requests
+type UpstreamAuthConfig struct {
+	Type         string   `json:"type"` // "none", "api_key", "basic" or "oauth2"
+	APIKey       string   `json:"api_key,omitempty"`
+	APIKeyHeader string   `json:"api_key_header,omitempty"`
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	TokenURL     string   `json:"token_url,omitempty"`
+	ClientID     string   `json:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// LoadProxyConfig loads upstream configuration from environment.
+// UPSTREAMS lists upstream names; each is configured with UPSTREAM_<NAME>_* settings.
+func LoadProxyConfig() *ProxyConfig {
+	config := &ProxyConfig{
+		TLSReloadInterval: getEnvDuration("PROXY_TLS_RELOAD_INTERVAL", time.Minute),
+		Region:            getEnvString("GATEWAY_REGION", ""),
+		Zone:              getEnvString("GATEWAY_ZONE", ""),
+	}
+
+	for _, name := range getEnvList("UPSTREAMS", nil) {
+		prefix := "UPSTREAM_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		var rewrites []*RewriteRuleConfig
+		for _, rule := range getEnvList(prefix+"REWRITES", nil) {
+			rulePrefix := prefix + "REWRITE_" + strings.ToUpper(strings.ReplaceAll(rule, "-", "_")) + "_"
+			rewrites = append(rewrites, &RewriteRuleConfig{
+				Name:        rule,
+				Match:       getEnvString(rulePrefix+"MATCH", ""),
+				Replace:     getEnvString(rulePrefix+"REPLACE", ""),
+				AddQuery:    getEnvMap(rulePrefix + "ADD_QUERY"),
+				RemoveQuery: getEnvList(rulePrefix+"REMOVE_QUERY", nil),
+			})
+		}
+
+		var claimRoutes []*ClaimRouteConfig
+		for _, route := range getEnvList(prefix+"CLAIM_ROUTES", nil) {
+			routePrefix := prefix + "CLAIM_ROUTE_" + strings.ToUpper(strings.ReplaceAll(route, "-", "_")) + "_"
+			claimRoutes = append(claimRoutes, &ClaimRouteConfig{
+				Name:    route,
+				Claims:  getEnvMap(routePrefix + "CLAIMS"),
+				URL:     getEnvString(routePrefix+"URL", ""),
+				Headers: getEnvMap(routePrefix + "HEADERS"),
+			})
+		}
+
+		// With BACKENDS, URL defaults to the first backend; s3 upstreams
+		// and sqs broker upstreams default to AWS in their region, echo
+		// upstreams to the built-in echo target and inprocess upstreams to a
+		// host only used for the Host header
+		upstreamType := getEnvString(prefix+"TYPE", "http")
+		s3Region := getEnvString(prefix+"S3_REGION", "us-east-1")
+		brokerType := getEnvString(prefix+"BROKER_TYPE", "")
+		brokerRegion := getEnvString(prefix+"BROKER_REGION", "us-east-1")
+		backends := getEnvList(prefix+"BACKENDS", nil)
+		defaultURL := ""
+		if len(backends) > 0 {
+			defaultURL = backends[0]
+		} else if upstreamType == "s3" {
+			defaultURL = "https://s3." + s3Region + ".amazonaws.com"
+		} else if upstreamType == "echo" {
+			defaultURL = EchoURL
+		} else if upstreamType == "inprocess" {
+			defaultURL = InProcessURL
+		} else if upstreamType == "broker" && brokerType == "sqs" {
+			defaultURL = "https://sqs." + brokerRegion + ".amazonaws.com"
+		}
+		handler := ""
+		if upstreamType == "inprocess" {
+			handler = getEnvString(prefix+"HANDLER", name)
+		}
+
+		config.Upstreams = append(config.Upstreams, &UpstreamConfig{
+			Name:         name,
+			Type:         upstreamType,
+			URL:          getEnvString(prefix+"URL", defaultURL),
+			Socket:       getEnvString(prefix+"SOCKET", ""),
+			Handler:      handler,
+			Backends:     backends,
+			BackendZones: getEnvList(prefix+"BACKEND_ZONES", nil),
+			Balance: UpstreamBalanceConfig{
+				Strategy:          getEnvString(prefix+"BALANCE_STRATEGY", "round_robin"),
+				HashKey:           getEnvString(prefix+"BALANCE_HASH_KEY", "ip"),
+				LoadFactor:        getEnvFloat(prefix+"BALANCE_LOAD_FACTOR", 1.25),
+				FailureCooldown:   getEnvDuration(prefix+"BALANCE_FAILURE_COOLDOWN", 10*time.Second),
+				SlowStart:         getEnvDuration(prefix+"BALANCE_SLOW_START", 0),
+				ZoneOverprovision: getEnvFloat(prefix+"BALANCE_ZONE_OVERPROVISION", 1.4),
+				Outliers: UpstreamOutlierConfig{
+					Enabled:            getEnvBool(prefix+"OUTLIER_ENABLED", false),
+					Interval:           getEnvDuration(prefix+"OUTLIER_INTERVAL", 10*time.Second),
+					MinRequests:        getEnvInt(prefix+"OUTLIER_MIN_REQUESTS", 20),
+					ErrorRate:          getEnvFloat(prefix+"OUTLIER_ERROR_RATE", 0.5),
+					Latency:            getEnvDuration(prefix+"OUTLIER_LATENCY", 0),
+					EjectionTime:       getEnvDuration(prefix+"OUTLIER_EJECTION_TIME", 30*time.Second),
+					MaxEjectionTime:    getEnvDuration(prefix+"OUTLIER_MAX_EJECTION_TIME", 5*time.Minute),
+					MaxEjectionPercent: getEnvInt(prefix+"OUTLIER_MAX_EJECTION_PERCENT", 50),
+				},
+			},
+			PathPrefix:  getEnvString(prefix+"PATH_PREFIX", "/"+name),
+			StripPrefix: getEnvBool(prefix+"STRIP_PREFIX", false),
+			Timeout:     getEnvDuration(prefix+"TIMEOUT", 30*time.Second),
+			Async:       getEnvString(prefix+"ASYNC", "off"),
+			Auth: UpstreamAuthConfig{
+				Type:         getEnvString(prefix+"AUTH_TYPE", "none"),
+				APIKey:       getEnvString(prefix+"API_KEY", ""),
+				APIKeyHeader: getEnvString(prefix+"API_KEY_HEADER", "X-API-Key"),
+				Username:     getEnvString(prefix+"BASIC_USERNAME", ""),
+				Password:     getEnvString(prefix+"BASIC_PASSWORD", ""),
+				TokenURL:     getEnvString(prefix+"OAUTH2_TOKEN_URL", ""),
+				ClientID:     getEnvString(prefix+"OAUTH2_CLIENT_ID", ""),
+				ClientSecret: getEnvString(prefix+"OAUTH2_CLIENT_SECRET", ""),
+				Scopes:       getEnvList(prefix+"OAUTH2_SCOPES", nil),
+			},
+			TLS: UpstreamTLSConfig{
+				CAFile:     getEnvString(prefix+"TLS_CA_FILE", ""),
+				CertFile:   getEnvString(prefix+"TLS_CERT_FILE", ""),
+				KeyFile:    getEnvString(prefix+"TLS_KEY_FILE", ""),
+				ServerName: getEnvString(prefix+"TLS_SERVER_NAME", ""),
+			},
+			Pool: UpstreamPoolConfig{
+				MaxIdleConns:        getEnvInt(prefix+"MAX_IDLE_CONNS", 100),
+				MaxIdleConnsPerHost: getEnvInt(prefix+"MAX_IDLE_CONNS_PER_HOST", 32),
+				MaxConnsPerHost:     getEnvInt(prefix+"MAX_CONNS_PER_HOST", 0),
+				IdleConnTimeout:     getEnvDuration(prefix+"IDLE_CONN_TIMEOUT", 90*time.Second),
+				TLSHandshakeTimeout: getEnvDuration(prefix+"TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+				DisableKeepAlives:   getEnvBool(prefix+"DISABLE_KEEP_ALIVES", false),
+				DialTimeout:         getEnvDuration(prefix+"DIAL_TIMEOUT", 30*time.Second),
+				IPFamily:            getEnvString(prefix+"IP_FAMILY", "dual"),
+				FallbackDelay:       getEnvDuration(prefix+"FALLBACK_DELAY", 300*time.Millisecond),
+				DNSResolver:         getEnvString(prefix+"DNS_RESOLVER", ""),
+			},
+			Cookies: UpstreamCookieConfig{
+				Strip:          getEnvList(prefix+"COOKIE_STRIP", nil),
+				DomainRewrites: getEnvMap(prefix + "COOKIE_DOMAIN_REWRITES"),
+				PathRewrites:   getEnvMap(prefix + "COOKIE_PATH_REWRITES"),
+				Secure:         getEnvBool(prefix+"COOKIE_SECURE", false),
+				SameSite:       getEnvString(prefix+"COOKIE_SAMESITE", ""),
+			},
+			Rewrites:    rewrites,
+			ClaimRoutes: claimRoutes,
+			Response: UpstreamResponseConfig{
+				Schema:       getEnvString(prefix+"RESPONSE_SCHEMA", ""),
+				Validation:   getEnvString(prefix+"RESPONSE_VALIDATION", "report"),
+				StripHeaders: getEnvBool(prefix+"RESPONSE_STRIP_HEADERS", false),
+			},
+			Encryption: UpstreamEncryptionConfig{
+				Key:   getEnvString(prefix+"ENCRYPTION_KEY", ""),
+				KeyID: getEnvString(prefix+"ENCRYPTION_KEY_ID", ""),
+				Paths: getEnvList(prefix+"ENCRYPTION_PATHS", nil),
+			},
+			JWT: UpstreamJWTConfig{
+				Issuers:  getEnvList(prefix+"JWT_ISSUERS", []string{GatewayIssuer}),
+				Audience: getEnvString(prefix+"JWT_AUDIENCE", ""),
+			},
+			S3: UpstreamS3Config{
+				Bucket:          getEnvString(prefix+"S3_BUCKET", ""),
+				Region:          s3Region,
+				AccessKeyID:     getEnvString(prefix+"S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnvString(prefix+"S3_SECRET_ACCESS_KEY", ""),
+				SessionToken:    getEnvString(prefix+"S3_SESSION_TOKEN", ""),
+				KeyPrefix:       getEnvString(prefix+"S3_KEY_PREFIX", ""),
+				PathStyle:       getEnvBool(prefix+"S3_PATH_STYLE", false),
+				Mode:            getEnvString(prefix+"S3_MODE", "proxy"),
+				PresignExpiry:   getEnvDuration(prefix+"S3_PRESIGN_EXPIRY", 15*time.Minute),
+				Methods:         getEnvList(prefix+"S3_METHODS", []string{"GET", "HEAD", "PUT"}),
+			},
+			Echo: UpstreamEchoConfig{
+				MaxBodySize: getEnvInt(prefix+"ECHO_MAX_BODY_SIZE", 64*1024),
+			},
+			Webhook: UpstreamWebhookConfig{
+				Provider:    getEnvString(prefix+"WEBHOOK_PROVIDER", ""),
+				Secrets:     getEnvList(prefix+"WEBHOOK_SECRETS", nil),
+				Tolerance:   getEnvDuration(prefix+"WEBHOOK_TOLERANCE", 5*time.Minute),
+				DedupeTTL:   getEnvDuration(prefix+"WEBHOOK_DEDUPE_TTL", 72*time.Hour),
+				MaxBodySize: getEnvInt(prefix+"WEBHOOK_MAX_BODY_SIZE", 1<<20),
+			},
+			Broker: UpstreamBrokerConfig{
+				Type:            brokerType,
+				Topic:           getEnvString(prefix+"BROKER_TOPIC", ""),
+				Topics:          getEnvMap(prefix + "BROKER_TOPICS"),
+				KeyHeader:       getEnvString(prefix+"BROKER_KEY_HEADER", "X-Message-Key"),
+				Schema:          getEnvString(prefix+"BROKER_SCHEMA", ""),
+				MaxBodySize:     getEnvInt(prefix+"BROKER_MAX_BODY_SIZE", 1<<20),
+				Username:        getEnvString(prefix+"BROKER_USERNAME", ""),
+				Password:        getEnvString(prefix+"BROKER_PASSWORD", ""),
+				JetStream:       getEnvBool(prefix+"BROKER_JETSTREAM", false),
+				Region:          brokerRegion,
+				AccessKeyID:     getEnvString(prefix+"BROKER_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnvString(prefix+"BROKER_SECRET_ACCESS_KEY", ""),
+				SessionToken:    getEnvString(prefix+"BROKER_SESSION_TOKEN", ""),
+			},
+		})
+	}
+
+	return config
+}
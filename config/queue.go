@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// QueueConfig represents per-route request prioritization configuration
+type QueueConfig struct {
+	Enabled       bool              `json:"enabled"`
+	MaxConcurrent int               `json:"max_concurrent"` // Requests served at once before queuing starts
+	MaxDepth      int               `json:"max_depth"`      // Requests waiting per class
+	MaxWait       time.Duration     `json:"max_wait"`
+	Classes       map[string]int    `json:"classes"`       // Class name -> weight
+	RouteClasses  map[string]string `json:"route_classes"` // Path prefix -> class name
+	DefaultClass  string            `json:"default_class"`
+}
+
+// DefaultQueueConfig returns default request prioritization configuration
+func DefaultQueueConfig() *QueueConfig {
+	return &QueueConfig{
+		Enabled:       false,
+		MaxConcurrent: 256,
+		MaxDepth:      512,
+		MaxWait:       5 * time.Second,
+		Classes:       map[string]int{"critical": 10, "default": 5, "bulk": 1},
+		RouteClasses:  map[string]string{"/health": "critical", "/ready": "critical"},
+		DefaultClass:  "default",
+	}
+}
+
+// LoadQueueConfig loads request prioritization configuration from environment
+func LoadQueueConfig() *QueueConfig {
+	config := DefaultQueueConfig()
+
+	config.Enabled = getEnvBool("QUEUE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.MaxConcurrent = getEnvInt("QUEUE_MAX_CONCURRENT", config.MaxConcurrent)
+	config.MaxDepth = getEnvInt("QUEUE_MAX_DEPTH", config.MaxDepth)
+	config.MaxWait = getEnvDuration("QUEUE_MAX_WAIT", config.MaxWait)
+	if getEnv("QUEUE_CLASSES") != "" {
+		config.Classes = make(map[string]int)
+		for name, value := range getEnvMap("QUEUE_CLASSES") {
+			weight, err := strconv.Atoi(value)
+			if err != nil {
+				recordInvalid("QUEUE_CLASSES", getEnv("QUEUE_CLASSES"), fmt.Errorf("weight %q for class %q is not an integer", value, name))
+				continue
+			}
+			config.Classes[name] = weight
+		}
+	}
+	if getEnv("QUEUE_ROUTE_CLASSES") != "" {
+		config.RouteClasses = getEnvMap("QUEUE_ROUTE_CLASSES")
+	}
+	config.DefaultClass = getEnvString("QUEUE_DEFAULT_CLASS", config.DefaultClass)
+
+	return config
+}
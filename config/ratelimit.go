@@ -15,6 +15,26 @@ type RateLimitConfig struct {
 	Redis       RedisConfig   `json:"redis"`
 	SkipSuccess bool          `json:"skip_success"`
 	SkipFailed  bool          `json:"skip_failed"`
+	Sync        SyncConfig    `json:"sync"`
+	Exempt      ExemptConfig  `json:"exempt"`
+}
+
+// ExemptConfig represents callers that bypass rate limiting
+type ExemptConfig struct {
+	IPs             []string      `json:"ips"` // Addresses or CIDR ranges
+	APIKeys         []string      `json:"api_keys"`
+	Roles           []string      `json:"roles"`
+	RefreshInterval time.Duration `json:"refresh_interval"` // Reload of exemptions added through the admin API
+}
+
+// SyncConfig represents usage sharing between in-memory rate limiters of
+// several replicas, for deployments without Redis
+type SyncConfig struct {
+	Enabled    bool          `json:"enabled"`
+	ListenAddr string        `json:"listen_addr"` // UDP address receiving usage reports
+	Peers      []string      `json:"peers"`       // UDP host:port of every replica
+	Interval   time.Duration `json:"interval"`
+	Key        string        `json:"key"` // Shared HMAC key, distinct from the JWT secret
 }
 
 // RedisConfig represents Redis configuration for rate limiting
@@ -44,6 +64,14 @@ func DefaultRateLimitConfig() *RateLimitConfig {
 		},
 		SkipSuccess: false,
 		SkipFailed:  false,
+		Sync: SyncConfig{
+			Enabled:    false,
+			ListenAddr: ":7946",
+			Interval:   time.Second,
+		},
+		Exempt: ExemptConfig{
+			RefreshInterval: 10 * time.Second,
+		},
 	}
 }
 
@@ -61,16 +89,37 @@ func LoadRateLimitConfig() *RateLimitConfig {
 	config.Capacity = getEnvInt("RATE_LIMIT_CAPACITY", 100)
 	config.RefillRate = getEnvInt("RATE_LIMIT_REFILL_RATE", 10)
 	config.Window = getEnvDuration("RATE_LIMIT_WINDOW", time.Minute)
-	config.UseRedis = getEnvBool("RATE_LIMIT_USE_REDIS", false)
+	// Replicas share rate limit buckets through Redis by default
+	config.UseRedis = getEnvBool("RATE_LIMIT_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
 	config.SkipSuccess = getEnvBool("RATE_LIMIT_SKIP_SUCCESS", false)
 	config.SkipFailed = getEnvBool("RATE_LIMIT_SKIP_FAILED", false)
 
 	// Redis configuration
-	config.Redis.Host = getEnvString("REDIS_HOST", "localhost")
-	config.Redis.Port = getEnvInt("REDIS_PORT", 6379)
-	config.Redis.Password = getEnvString("REDIS_PASSWORD", "")
-	config.Redis.DB = getEnvInt("REDIS_DB", 0)
-	config.Redis.PoolSize = getEnvInt("REDIS_POOL_SIZE", 10)
+	config.Redis = LoadRedisConfig()
+
+	// Usage sharing between in-memory limiters
+	config.Sync.Enabled = getEnvBool("RATE_LIMIT_SYNC_ENABLED", false)
+	config.Sync.ListenAddr = getEnvString("RATE_LIMIT_SYNC_LISTEN", config.Sync.ListenAddr)
+	config.Sync.Peers = getEnvList("RATE_LIMIT_SYNC_PEERS", nil)
+	config.Sync.Interval = getEnvDuration("RATE_LIMIT_SYNC_INTERVAL", config.Sync.Interval)
+	config.Sync.Key = getEnvString("RATE_LIMIT_SYNC_KEY", "")
+
+	// Callers bypassing rate limiting
+	config.Exempt.IPs = getEnvList("RATE_LIMIT_EXEMPT_IPS", nil)
+	config.Exempt.APIKeys = getEnvList("RATE_LIMIT_EXEMPT_API_KEYS", nil)
+	config.Exempt.Roles = getEnvList("RATE_LIMIT_EXEMPT_ROLES", nil)
+	config.Exempt.RefreshInterval = getEnvDuration("RATE_LIMIT_EXEMPT_REFRESH_INTERVAL", config.Exempt.RefreshInterval)
 
 	return config
 }
+
+// LoadRedisConfig loads the shared Redis connection settings from environment
+func LoadRedisConfig() RedisConfig {
+	return RedisConfig{
+		Host:     getEnvString("REDIS_HOST", "localhost"),
+		Port:     getEnvInt("REDIS_PORT", 6379),
+		Password: getEnvString("REDIS_PASSWORD", ""),
+		DB:       getEnvInt("REDIS_DB", 0),
+		PoolSize: getEnvInt("REDIS_POOL_SIZE", 10),
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+// redactedValue replaces secrets in the effective configuration
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with secrets masked, safe to
+// expose through the admin API
+func (c *Config) Redacted() *Config {
+	copied := *c
+	copied.JWT.Secret = redact(c.JWT.Secret)
+	copied.JWT.TrustedIssuers = nil
+	for _, issuer := range c.JWT.TrustedIssuers {
+		redacted := *issuer
+		redacted.Secret = redact(issuer.Secret)
+		copied.JWT.TrustedIssuers = append(copied.JWT.TrustedIssuers, &redacted)
+	}
+
+	apiKeys := *c.APIKeys
+	apiKeys.Redis.Password = redact(apiKeys.Redis.Password)
+	copied.APIKeys = &apiKeys
+
+	sqlStorage := *c.SQLStorage
+	sqlStorage.DSN = redact(sqlStorage.DSN)
+	copied.SQLStorage = &sqlStorage
+
+	rateLimit := *c.RateLimit
+	rateLimit.Redis.Password = redact(rateLimit.Redis.Password)
+	rateLimit.Sync.Key = redact(rateLimit.Sync.Key)
+	rateLimit.Exempt.APIKeys = nil
+	for _, key := range c.RateLimit.Exempt.APIKeys {
+		rateLimit.Exempt.APIKeys = append(rateLimit.Exempt.APIKeys, redact(key))
+	}
+	copied.RateLimit = &rateLimit
+
+	anonymous := *c.Anonymous
+	anonymous.Redis.Password = redact(anonymous.Redis.Password)
+	copied.Anonymous = &anonymous
+
+	streamLimits := *c.StreamLimits
+	streamLimits.Redis.Password = redact(streamLimits.Redis.Password)
+	copied.StreamLimits = &streamLimits
+
+	featureFlags := *c.FeatureFlags
+	featureFlags.Redis.Password = redact(featureFlags.Redis.Password)
+	copied.FeatureFlags = &featureFlags
+
+	penaltyBox := *c.PenaltyBox
+	penaltyBox.Redis.Password = redact(penaltyBox.Redis.Password)
+	copied.PenaltyBox = &penaltyBox
+
+	chargeback := *c.Chargeback
+	chargeback.Redis.Password = redact(chargeback.Redis.Password)
+	copied.Chargeback = &chargeback
+
+	metering := *c.Metering
+	metering.StripeAPIKey = redact(metering.StripeAPIKey)
+	metering.HTTPToken = redact(metering.HTTPToken)
+	metering.Redis.Password = redact(metering.Redis.Password)
+	copied.Metering = &metering
+
+	accessLog := *c.AccessLog
+	accessLog.KafkaPassword = redact(accessLog.KafkaPassword)
+	accessLog.LokiPassword = redact(accessLog.LokiPassword)
+	copied.AccessLog = &accessLog
+
+	idempotency := *c.Idempotency
+	idempotency.Redis.Password = redact(idempotency.Redis.Password)
+	copied.Idempotency = &idempotency
+
+	cache := *c.Cache
+	cache.Redis.Password = redact(cache.Redis.Password)
+	copied.Cache = &cache
+
+	webhooks := *c.Webhooks
+	webhooks.Redis.Password = redact(webhooks.Redis.Password)
+	copied.Webhooks = &webhooks
+
+	async := *c.Async
+	async.Redis.Password = redact(async.Redis.Password)
+	copied.Async = &async
+
+	mqtt := *c.MQTT
+	mqtt.Redis.Password = redact(mqtt.Redis.Password)
+	copied.MQTT = &mqtt
+
+	devices := *c.Devices
+	devices.Redis.Password = redact(devices.Redis.Password)
+	copied.Devices = &devices
+
+	replay := *c.Replay
+	replay.Redis.Password = redact(replay.Redis.Password)
+	copied.Replay = &replay
+
+	groups := *c.Groups
+	groups.Redis.Password = redact(groups.Redis.Password)
+	copied.Groups = &groups
+
+	scim := *c.SCIM
+	scim.Token = redact(scim.Token)
+	scim.Redis.Password = redact(scim.Redis.Password)
+	copied.SCIM = &scim
+
+	ldap := *c.LDAP
+	ldap.BindPassword = redact(ldap.BindPassword)
+	copied.LDAP = &ldap
+
+	deviceFlow := *c.DeviceFlow
+	deviceFlow.Redis.Password = redact(deviceFlow.Redis.Password)
+	copied.DeviceFlow = &deviceFlow
+
+	personalTokens := *c.PersonalTokens
+	personalTokens.Redis.Password = redact(personalTokens.Redis.Password)
+	copied.PersonalTokens = &personalTokens
+
+	csrf := *c.CSRF
+	csrf.SigningKey = redact(csrf.SigningKey)
+	copied.CSRF = &csrf
+
+	capture := *c.Capture
+	capture.Redis.Password = redact(capture.Redis.Password)
+	copied.Capture = &capture
+
+	cluster := *c.Cluster
+	cluster.Redis.Password = redact(cluster.Redis.Password)
+	copied.Cluster = &cluster
+
+	federation := *c.Federation
+	federation.Redis.Password = redact(federation.Redis.Password)
+	copied.Federation = &federation
+
+	copied.State = &StateConfig{SigningKey: redact(c.State.SigningKey)}
+
+	warmRestart := *c.WarmRestart
+	warmRestart.Redis.Password = redact(warmRestart.Redis.Password)
+	copied.WarmRestart = &warmRestart
+
+	proxy := *c.Proxy
+	proxy.Upstreams = nil
+	for _, upstream := range c.Proxy.Upstreams {
+		proxy.Upstreams = append(proxy.Upstreams, upstream.Redacted())
+	}
+	copied.Proxy = &proxy
+
+	return &copied
+}
+
+// Redacted returns a copy of the upstream with its secrets masked
+func (u *UpstreamConfig) Redacted() *UpstreamConfig {
+	redacted := *u
+	redacted.Auth.APIKey = redact(u.Auth.APIKey)
+	redacted.Auth.Password = redact(u.Auth.Password)
+	redacted.Auth.ClientSecret = redact(u.Auth.ClientSecret)
+	redacted.Encryption.Key = redact(u.Encryption.Key)
+	redacted.S3.SecretAccessKey = redact(u.S3.SecretAccessKey)
+	redacted.S3.SessionToken = redact(u.S3.SessionToken)
+	redacted.Broker.Password = redact(u.Broker.Password)
+	redacted.Broker.SecretAccessKey = redact(u.Broker.SecretAccessKey)
+	redacted.Broker.SessionToken = redact(u.Broker.SessionToken)
+	// A NATS user without a password is a token
+	if u.Broker.Type == "nats" && u.Broker.Password == "" {
+		redacted.Broker.Username = redact(u.Broker.Username)
+	}
+	if len(u.Webhook.Secrets) > 0 {
+		redacted.Webhook.Secrets = make([]string, len(u.Webhook.Secrets))
+		for i, secret := range u.Webhook.Secrets {
+			redacted.Webhook.Secrets[i] = redact(secret)
+		}
+	}
+	return &redacted
+}
+
+// redact masks a non-empty secret
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedValue
+}
@@ -0,0 +1,29 @@
+package config
+
+// RedactionConfig represents the secrets masked in debug logs and captured
+// traffic before they are written
+type RedactionConfig struct {
+	Headers     []string `json:"headers"`
+	Fields      []string `json:"fields"` // JSON and form fields at any depth
+	QueryParams []string `json:"query_params"`
+}
+
+// DefaultRedactionConfig returns default redaction configuration
+func DefaultRedactionConfig() *RedactionConfig {
+	return &RedactionConfig{
+		Headers:     []string{"Authorization", "Proxy-Authorization", "X-API-Key", "Cookie", "Set-Cookie"},
+		Fields:      []string{"password", "secret", "client_secret", "token", "access_token", "refresh_token", "api_key", "ssn"},
+		QueryParams: []string{"api_key", "token", "access_token"},
+	}
+}
+
+// LoadRedactionConfig loads redaction configuration from environment
+func LoadRedactionConfig() *RedactionConfig {
+	config := DefaultRedactionConfig()
+
+	config.Headers = getEnvList("REDACT_HEADERS", config.Headers)
+	config.Fields = getEnvList("REDACT_FIELDS", config.Fields)
+	config.QueryParams = getEnvList("REDACT_QUERY_PARAMS", config.QueryParams)
+
+	return config
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"time"
+)
+
+// ReplayConfig represents nonce and timestamp replay protection configuration
+type ReplayConfig struct {
+	Enabled         bool          `json:"enabled"`
+	Paths           []string      `json:"paths"` // High-security route prefixes
+	TimestampHeader string        `json:"timestamp_header"`
+	NonceHeader     string        `json:"nonce_header"`
+	MaxSkew         time.Duration `json:"max_skew"`
+	FailOpen        bool          `json:"fail_open"`
+	UseRedis        bool          `json:"use_redis"`
+	Redis           RedisConfig   `json:"redis"`
+}
+
+// DefaultReplayConfig returns default replay protection configuration
+func DefaultReplayConfig() *ReplayConfig {
+	return &ReplayConfig{
+		Enabled:         false,
+		TimestampHeader: "X-Timestamp",
+		NonceHeader:     "X-Nonce",
+		MaxSkew:         5 * time.Minute,
+		FailOpen:        false,
+		UseRedis:        false,
+	}
+}
+
+// LoadReplayConfig loads replay protection configuration from environment
+func LoadReplayConfig() *ReplayConfig {
+	config := DefaultReplayConfig()
+
+	config.Enabled = getEnvBool("REPLAY_PROTECTION_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Paths = getEnvList("REPLAY_PROTECTION_PATHS", nil)
+	config.TimestampHeader = getEnvString("REPLAY_PROTECTION_TIMESTAMP_HEADER", config.TimestampHeader)
+	config.NonceHeader = getEnvString("REPLAY_PROTECTION_NONCE_HEADER", config.NonceHeader)
+	config.MaxSkew = getEnvDuration("REPLAY_PROTECTION_MAX_SKEW", config.MaxSkew)
+	config.FailOpen = getEnvBool("REPLAY_PROTECTION_FAIL_OPEN", config.FailOpen)
+	config.UseRedis = getEnvBool("REPLAY_PROTECTION_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// SAMLConfig represents the SAML 2.0 service provider used for enterprise SSO
+type SAMLConfig struct {
+	Enabled           bool                `json:"enabled"`
+	RootURL           string              `json:"root_url"`  // External URL of the gateway, e.g. https://api.example.com
+	EntityID          string              `json:"entity_id"` // Defaults to the metadata URL
+	IdPEntityID       string              `json:"idp_entity_id"`
+	IdPSSOURL         string              `json:"idp_sso_url"`
+	IdPCertFile       string              `json:"idp_cert_file"`
+	UsernameAttribute string              `json:"username_attribute"` // The NameID is used when empty
+	EmailAttribute    string              `json:"email_attribute"`
+	GroupsAttribute   string              `json:"groups_attribute"`
+	RoleGroups        map[string][]string `json:"role_groups"` // Role to the names of groups granting it
+	DefaultRoles      []string            `json:"default_roles"`
+	AllowIdPInitiated bool                `json:"allow_idp_initiated"`
+	ClockSkew         time.Duration       `json:"clock_skew"`
+}
+
+// DefaultSAMLConfig returns default SAML configuration
+func DefaultSAMLConfig() *SAMLConfig {
+	return &SAMLConfig{
+		Enabled:         false,
+		EmailAttribute:  "email",
+		GroupsAttribute: "groups",
+		DefaultRoles:    []string{"user"},
+		ClockSkew:       90 * time.Second,
+	}
+}
+
+// ACSURL returns the assertion consumer service URL
+func (c *SAMLConfig) ACSURL() string {
+	return strings.TrimSuffix(c.RootURL, "/") + "/saml/acs"
+}
+
+// LoadSAMLConfig loads SAML configuration from environment.
+// SAML_ROLES lists role names; each is granted to the groups in SAML_ROLE_<NAME>_GROUPS.
+func LoadSAMLConfig() *SAMLConfig {
+	config := DefaultSAMLConfig()
+
+	config.Enabled = getEnvBool("SAML_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.RootURL = getEnvString("SAML_ROOT_URL", "")
+	config.EntityID = getEnvString("SAML_ENTITY_ID", strings.TrimSuffix(config.RootURL, "/")+"/saml/metadata")
+	config.IdPEntityID = getEnvString("SAML_IDP_ENTITY_ID", "")
+	config.IdPSSOURL = getEnvString("SAML_IDP_SSO_URL", "")
+	config.IdPCertFile = getEnvString("SAML_IDP_CERT_FILE", "")
+	config.UsernameAttribute = getEnvString("SAML_USERNAME_ATTRIBUTE", "")
+	config.EmailAttribute = getEnvString("SAML_EMAIL_ATTRIBUTE", config.EmailAttribute)
+	config.GroupsAttribute = getEnvString("SAML_GROUPS_ATTRIBUTE", config.GroupsAttribute)
+	config.DefaultRoles = getEnvList("SAML_DEFAULT_ROLES", config.DefaultRoles)
+	config.AllowIdPInitiated = getEnvBool("SAML_ALLOW_IDP_INITIATED", false)
+	config.ClockSkew = getEnvDuration("SAML_CLOCK_SKEW", config.ClockSkew)
+
+	config.RoleGroups = make(map[string][]string)
+	for _, role := range getEnvList("SAML_ROLES", nil) {
+		prefix := "SAML_ROLE_" + strings.ToUpper(strings.ReplaceAll(role, "-", "_")) + "_"
+		config.RoleGroups[role] = getEnvList(prefix+"GROUPS", nil)
+	}
+
+	return config
+}
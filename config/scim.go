@@ -0,0 +1,45 @@
+package config
+
+import (
+	"time"
+)
+
+// SCIMConfig represents SCIM provisioning configuration
+type SCIMConfig struct {
+	Enabled         bool          `json:"enabled"`
+	Token           string        `json:"token"` // Bearer token identity providers authenticate with
+	UsersFile       string        `json:"users_file"`
+	RefreshInterval time.Duration `json:"refresh_interval"`
+	RequiredIssuers []string      `json:"required_issuers"` // Issuers whose users must be provisioned and active
+	UseRedis        bool          `json:"use_redis"`
+	Redis           RedisConfig   `json:"redis"`
+}
+
+// DefaultSCIMConfig returns default SCIM configuration
+func DefaultSCIMConfig() *SCIMConfig {
+	return &SCIMConfig{
+		Enabled:         false,
+		UsersFile:       "scim_users.json",
+		RefreshInterval: 30 * time.Second,
+		UseRedis:        false,
+	}
+}
+
+// LoadSCIMConfig loads SCIM configuration from environment
+func LoadSCIMConfig() *SCIMConfig {
+	config := DefaultSCIMConfig()
+
+	config.Enabled = getEnvBool("SCIM_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Token = getEnvString("SCIM_TOKEN", "")
+	config.UsersFile = getEnvString("SCIM_USERS_FILE", config.UsersFile)
+	config.RefreshInterval = getEnvDuration("SCIM_REFRESH_INTERVAL", config.RefreshInterval)
+	config.RequiredIssuers = getEnvList("SCIM_REQUIRED_ISSUERS", nil)
+	config.UseRedis = getEnvBool("SCIM_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
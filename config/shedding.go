@@ -0,0 +1,53 @@
+package config
+
+import (
+	"time"
+)
+
+// SheddingConfig represents priority-based load shedding configuration
+type SheddingConfig struct {
+	Enabled         bool              `json:"enabled"`
+	MaxCPU          float64           `json:"max_cpu"`          // CPU utilization (0-1) treated as full load
+	MaxGoroutines   int               `json:"max_goroutines"`   // 0 disables the goroutine signal
+	MaxInFlight     int               `json:"max_in_flight"`    // 0 disables the queue depth signal
+	RoutePriorities map[string]string `json:"route_priorities"` // Path prefix -> low, normal, high or critical
+	RolePriorities  map[string]string `json:"role_priorities"`  // Consumer role -> priority
+	DefaultPriority string            `json:"default_priority"`
+	SampleInterval  time.Duration     `json:"sample_interval"`
+}
+
+// DefaultSheddingConfig returns default load shedding configuration
+func DefaultSheddingConfig() *SheddingConfig {
+	return &SheddingConfig{
+		Enabled:         false,
+		MaxCPU:          0.9,
+		MaxGoroutines:   10000,
+		MaxInFlight:     1000,
+		RoutePriorities: map[string]string{"/health": "critical", "/ready": "critical", "/api/admin": "high"},
+		RolePriorities:  map[string]string{},
+		DefaultPriority: "normal",
+		SampleInterval:  time.Second,
+	}
+}
+
+// LoadSheddingConfig loads load shedding configuration from environment
+func LoadSheddingConfig() *SheddingConfig {
+	config := DefaultSheddingConfig()
+
+	config.Enabled = getEnvBool("SHEDDING_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.MaxCPU = getEnvFloat("SHEDDING_MAX_CPU", config.MaxCPU)
+	config.MaxGoroutines = getEnvInt("SHEDDING_MAX_GOROUTINES", config.MaxGoroutines)
+	config.MaxInFlight = getEnvInt("SHEDDING_MAX_IN_FLIGHT", config.MaxInFlight)
+	if getEnv("SHEDDING_ROUTE_PRIORITIES") != "" {
+		config.RoutePriorities = getEnvMap("SHEDDING_ROUTE_PRIORITIES")
+	}
+	config.RolePriorities = getEnvMap("SHEDDING_ROLE_PRIORITIES")
+	config.DefaultPriority = getEnvString("SHEDDING_DEFAULT_PRIORITY", config.DefaultPriority)
+	config.SampleInterval = getEnvDuration("SHEDDING_SAMPLE_INTERVAL", config.SampleInterval)
+
+	return config
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// SLOConfig represents per-route service level objective tracking
+type SLOConfig struct {
+	Enabled        bool            `json:"enabled"`
+	Objectives     []*SLOObjective `json:"objectives"`
+	Window         time.Duration   `json:"window"`         // Compliance period
+	FastBurnRate   float64         `json:"fast_burn_rate"` // Alerts when the last 1h and 5m both burn faster
+	SlowBurnRate   float64         `json:"slow_burn_rate"` // Alerts when the last 6h and 30m both burn faster
+	Interval       time.Duration   `json:"interval"`       // How often compliance and burn rates are evaluated
+	WebhookURL     string          `json:"webhook_url"`
+	WebhookTimeout time.Duration   `json:"webhook_timeout"`
+}
+
+// SLOObjective declares the objectives of a group of routes
+type SLOObjective struct {
+	Name          string        `json:"name"`
+	Paths         []string      `json:"paths"`          // Route prefixes covered
+	Methods       []string      `json:"methods"`        // Empty covers every method
+	Latency       time.Duration `json:"latency"`        // Requests slower than this miss the latency objective; 0 disables it
+	LatencyTarget float64       `json:"latency_target"` // Share of requests that must be faster, e.g. 0.999
+	ErrorRate     float64       `json:"error_rate"`     // Highest share of 5xx responses, e.g. 0.001; 0 disables it
+}
+
+// DefaultSLOConfig returns default SLO tracking configuration
+func DefaultSLOConfig() *SLOConfig {
+	return &SLOConfig{
+		Enabled:        false,
+		Window:         30 * 24 * time.Hour,
+		FastBurnRate:   14.4,
+		SlowBurnRate:   6,
+		Interval:       time.Minute,
+		WebhookTimeout: 5 * time.Second,
+	}
+}
+
+// LoadSLOConfig loads SLO tracking configuration from environment.
+// SLOS lists objective names; each is configured with SLO_<NAME>_* settings.
+func LoadSLOConfig() *SLOConfig {
+	config := DefaultSLOConfig()
+
+	config.Enabled = getEnvBool("SLO_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Window = getEnvDuration("SLO_WINDOW", config.Window)
+	config.FastBurnRate = getEnvFloat("SLO_FAST_BURN_RATE", config.FastBurnRate)
+	config.SlowBurnRate = getEnvFloat("SLO_SLOW_BURN_RATE", config.SlowBurnRate)
+	config.Interval = getEnvDuration("SLO_INTERVAL", config.Interval)
+	config.WebhookURL = getEnvString("SLO_WEBHOOK_URL", "")
+	config.WebhookTimeout = getEnvDuration("SLO_WEBHOOK_TIMEOUT", config.WebhookTimeout)
+
+	for _, name := range getEnvList("SLOS", nil) {
+		prefix := sloPrefix(name)
+
+		config.Objectives = append(config.Objectives, &SLOObjective{
+			Name:          name,
+			Paths:         getEnvList(prefix+"PATHS", []string{"/" + name}),
+			Methods:       getEnvList(prefix+"METHODS", nil),
+			Latency:       getEnvDuration(prefix+"LATENCY", 0),
+			LatencyTarget: getEnvFloat(prefix+"LATENCY_TARGET", 0.99),
+			ErrorRate:     getEnvFloat(prefix+"ERROR_RATE", 0),
+		})
+	}
+
+	return config
+}
+
+// sloPrefix returns the environment prefix of an objective's settings
+func sloPrefix(name string) string {
+	return "SLO_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
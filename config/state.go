@@ -0,0 +1,14 @@
+package config
+
+// StateConfig represents gateway state export/import configuration
+type StateConfig struct {
+	SigningKey string `json:"signing_key"` // HMAC key for state bundles; gateways exchanging bundles must share it
+}
+
+// LoadStateConfig loads state export/import configuration from environment.
+// The signing key defaults to the JWT secret.
+func LoadStateConfig(jwtSecret string) *StateConfig {
+	return &StateConfig{
+		SigningKey: getEnvString("STATE_SIGNING_KEY", jwtSecret),
+	}
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"time"
+)
+
+// StatsDConfig represents pushing metrics to a StatsD or DogStatsD agent
+type StatsDConfig struct {
+	Enabled       bool              `json:"enabled"`
+	Network       string            `json:"network"` // "udp" or "unixgram"
+	Address       string            `json:"address"`
+	Format        string            `json:"format"` // "dogstatsd" (labels as tags) or "statsd" (labels in the name)
+	Prefix        string            `json:"prefix"`
+	Tags          map[string]string `json:"tags"` // Added to every metric (DogStatsD only)
+	FlushInterval time.Duration     `json:"flush_interval"`
+	SampleRate    float64           `json:"sample_rate"` // Share of requests whose duration is sent
+	MaxPacketSize int               `json:"max_packet_size"`
+}
+
+// DefaultStatsDConfig returns default StatsD configuration
+func DefaultStatsDConfig() *StatsDConfig {
+	return &StatsDConfig{
+		Enabled:       false,
+		Network:       "udp",
+		Address:       "localhost:8125",
+		Format:        "dogstatsd",
+		Tags:          map[string]string{},
+		FlushInterval: 10 * time.Second,
+		SampleRate:    1,
+		MaxPacketSize: 1432,
+	}
+}
+
+// LoadStatsDConfig loads StatsD configuration from environment
+func LoadStatsDConfig() *StatsDConfig {
+	config := DefaultStatsDConfig()
+
+	config.Enabled = getEnvBool("STATSD_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Network = getEnvString("STATSD_NETWORK", config.Network)
+	config.Address = getEnvString("STATSD_ADDRESS", config.Address)
+	config.Format = getEnvString("STATSD_FORMAT", config.Format)
+	config.Prefix = getEnvString("STATSD_PREFIX", "")
+	config.Tags = getEnvMap("STATSD_TAGS")
+	config.FlushInterval = getEnvDuration("STATSD_FLUSH_INTERVAL", config.FlushInterval)
+	config.SampleRate = getEnvFloat("STATSD_SAMPLE_RATE", config.SampleRate)
+	config.MaxPacketSize = getEnvInt("STATSD_MAX_PACKET_SIZE", config.MaxPacketSize)
+
+	return config
+}
@@ -0,0 +1,41 @@
+package config
+
+// SQLStorageConfig represents an SQL database that replaces Redis and memory
+// as the store of rate limit exemptions, API keys, device authorizations and
+// the penalty box
+type SQLStorageConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Driver       string `json:"driver"` // database/sql driver name, such as "postgres", "mysql" or "sqlite"
+	DSN          string `json:"dsn,omitempty"`
+	Table        string `json:"table"`
+	Placeholder  string `json:"placeholder"` // "?" for MySQL and SQLite, "$" for PostgreSQL
+	MaxOpenConns int    `json:"max_open_conns"`
+}
+
+// DefaultSQLStorageConfig returns default SQL storage configuration
+func DefaultSQLStorageConfig() *SQLStorageConfig {
+	return &SQLStorageConfig{
+		Enabled:      false,
+		Table:        "gateway_kv",
+		Placeholder:  "?",
+		MaxOpenConns: 10,
+	}
+}
+
+// LoadSQLStorageConfig loads SQL storage configuration from environment
+func LoadSQLStorageConfig() *SQLStorageConfig {
+	config := DefaultSQLStorageConfig()
+
+	config.Enabled = getEnvBool("SQL_STORAGE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Driver = getEnvString("SQL_STORAGE_DRIVER", config.Driver)
+	config.DSN = getEnvString("SQL_STORAGE_DSN", config.DSN)
+	config.Table = getEnvString("SQL_STORAGE_TABLE", config.Table)
+	config.Placeholder = getEnvString("SQL_STORAGE_PLACEHOLDER", config.Placeholder)
+	config.MaxOpenConns = getEnvInt("SQL_STORAGE_MAX_OPEN_CONNS", config.MaxOpenConns)
+
+	return config
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamLimitsConfig represents limits on bodies streamed through proxied
+// routes and per-consumer transfer quotas
+type StreamLimitsConfig struct {
+	Enabled     bool                 `json:"enabled"`
+	Routes      []*StreamRouteConfig `json:"routes"`
+	Quota       int64                `json:"quota"`       // Bytes per consumer and quota window; 0 disables the quota
+	PlanQuotas  map[string]int64     `json:"plan_quotas"` // API key plan or JWT role -> bytes per quota window
+	QuotaWindow time.Duration        `json:"quota_window"`
+	UseRedis    bool                 `json:"use_redis"`
+	Redis       RedisConfig          `json:"redis"`
+}
+
+// StreamRouteConfig represents the stream limits of a group of routes
+type StreamRouteConfig struct {
+	Name             string        `json:"name"`
+	Paths            []string      `json:"paths"`              // Route prefixes the limits apply to
+	MaxRequestBytes  int64         `json:"max_request_bytes"`  // 0 is unlimited
+	MaxResponseBytes int64         `json:"max_response_bytes"` // 0 is unlimited
+	MaxDuration      time.Duration `json:"max_duration"`       // 0 is unlimited
+}
+
+// DefaultStreamLimitsConfig returns default stream limit configuration
+func DefaultStreamLimitsConfig() *StreamLimitsConfig {
+	return &StreamLimitsConfig{
+		Enabled:     false,
+		PlanQuotas:  map[string]int64{},
+		QuotaWindow: 24 * time.Hour,
+		UseRedis:    false,
+	}
+}
+
+// LoadStreamLimitsConfig loads stream limit configuration from environment.
+// STREAM_LIMIT_ROUTES lists route groups; each is configured with
+// STREAM_LIMIT_<NAME>_* settings.
+func LoadStreamLimitsConfig() *StreamLimitsConfig {
+	config := DefaultStreamLimitsConfig()
+
+	config.Enabled = getEnvBool("STREAM_LIMITS_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	for _, name := range getEnvList("STREAM_LIMIT_ROUTES", nil) {
+		prefix := "STREAM_LIMIT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config.Routes = append(config.Routes, &StreamRouteConfig{
+			Name:             name,
+			Paths:            getEnvList(prefix+"PATHS", []string{"/" + name}),
+			MaxRequestBytes:  int64(getEnvInt(prefix+"MAX_REQUEST_BYTES", 0)),
+			MaxResponseBytes: int64(getEnvInt(prefix+"MAX_RESPONSE_BYTES", 0)),
+			MaxDuration:      getEnvDuration(prefix+"MAX_DURATION", 0),
+		})
+	}
+
+	config.Quota = int64(getEnvInt("STREAM_QUOTA_BYTES", 0))
+	for plan, value := range getEnvMap("STREAM_QUOTA_PLAN_BYTES") {
+		quota, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			recordInvalid("STREAM_QUOTA_PLAN_BYTES", getEnv("STREAM_QUOTA_PLAN_BYTES"), fmt.Errorf("quota %q for plan %q is not an integer", value, plan))
+			continue
+		}
+		config.PlanQuotas[plan] = quota
+	}
+	config.QuotaWindow = getEnvDuration("STREAM_QUOTA_WINDOW", config.QuotaWindow)
+	config.UseRedis = getEnvBool("STREAM_LIMITS_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false))
+	config.Redis = LoadRedisConfig()
+
+	return config
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"time"
+)
+
+// TailCaptureConfig represents retaining slow and failed requests for triage
+type TailCaptureConfig struct {
+	Enabled       bool          `json:"enabled"`
+	SlowThreshold time.Duration `json:"slow_threshold"` // 0 keeps only failed requests
+	MinStatus     int           `json:"min_status"`     // Lowest status counted as an error
+	Size          int           `json:"size"`           // Requests kept per instance
+	MaxBodySize   int           `json:"max_body_size"`
+	RedactHeaders []string      `json:"redact_headers"` // Masked in addition to REDACT_HEADERS
+}
+
+// DefaultTailCaptureConfig returns default tail capture configuration
+func DefaultTailCaptureConfig() *TailCaptureConfig {
+	return &TailCaptureConfig{
+		Enabled:       false,
+		SlowThreshold: 2 * time.Second,
+		MinStatus:     500,
+		Size:          200,
+		MaxBodySize:   4 * 1024,
+	}
+}
+
+// LoadTailCaptureConfig loads tail capture configuration from environment
+func LoadTailCaptureConfig() *TailCaptureConfig {
+	config := DefaultTailCaptureConfig()
+
+	config.Enabled = getEnvBool("TAIL_CAPTURE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.SlowThreshold = getEnvDuration("TAIL_CAPTURE_SLOW_THRESHOLD", config.SlowThreshold)
+	config.MinStatus = getEnvInt("TAIL_CAPTURE_MIN_STATUS", config.MinStatus)
+	config.Size = getEnvInt("TAIL_CAPTURE_SIZE", config.Size)
+	config.MaxBodySize = getEnvInt("TAIL_CAPTURE_MAX_BODY_SIZE", config.MaxBodySize)
+	config.RedactHeaders = getEnvList("TAIL_CAPTURE_REDACT_HEADERS", nil)
+
+	return config
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ThrottleConfig represents per-consumer response bandwidth throttling configuration
+type ThrottleConfig struct {
+	Enabled     bool             `json:"enabled"`
+	DefaultRate int64            `json:"default_rate"` // Bytes per second; 0 is unlimited
+	PlanRates   map[string]int64 `json:"plan_rates"`   // API key plan or JWT role -> bytes per second
+	Burst       int64            `json:"burst"`        // Bytes sent at full speed before pacing starts
+}
+
+// DefaultThrottleConfig returns default bandwidth throttling configuration
+func DefaultThrottleConfig() *ThrottleConfig {
+	return &ThrottleConfig{
+		Enabled:     false,
+		DefaultRate: 1024 * 1024,
+		PlanRates:   map[string]int64{},
+		Burst:       256 * 1024,
+	}
+}
+
+// LoadThrottleConfig loads bandwidth throttling configuration from environment
+func LoadThrottleConfig() *ThrottleConfig {
+	config := DefaultThrottleConfig()
+
+	config.Enabled = getEnvBool("THROTTLE_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.DefaultRate = int64(getEnvInt("THROTTLE_DEFAULT_RATE", int(config.DefaultRate)))
+	config.Burst = int64(getEnvInt("THROTTLE_BURST", int(config.Burst)))
+	for plan, value := range getEnvMap("THROTTLE_PLAN_RATES") {
+		rate, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			recordInvalid("THROTTLE_PLAN_RATES", getEnv("THROTTLE_PLAN_RATES"), fmt.Errorf("rate %q for plan %q is not an integer", value, plan))
+			continue
+		}
+		config.PlanRates[plan] = rate
+	}
+
+	return config
+}
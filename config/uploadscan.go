@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// UploadScanConfig represents malware scanning of uploads on proxied routes
+type UploadScanConfig struct {
+	Enabled  bool                     `json:"enabled"`
+	Routes   []*UploadScanRouteConfig `json:"routes"`
+	SpoolDir string                   `json:"spool_dir"` // Where uploads are held while scanned; "" uses the system temp directory
+}
+
+// UploadScanRouteConfig represents the scanning of a group of routes
+type UploadScanRouteConfig struct {
+	Name     string        `json:"name"`
+	Paths    []string      `json:"paths"`   // Route prefixes uploads are scanned on
+	Scanner  string        `json:"scanner"` // "clamd" or "icap"
+	Address  string        `json:"address"` // clamd "host:port" or "unix:<path>", or ICAP service URL
+	Timeout  time.Duration `json:"timeout"` // Limit for scanning one file
+	FailOpen bool          `json:"fail_open"`
+}
+
+// DefaultUploadScanConfig returns default upload scanning configuration
+func DefaultUploadScanConfig() *UploadScanConfig {
+	return &UploadScanConfig{
+		Enabled: false,
+	}
+}
+
+// LoadUploadScanConfig loads upload scanning configuration from environment.
+// UPLOAD_SCAN_ROUTES lists route groups; each is configured with
+// UPLOAD_SCAN_<NAME>_* settings.
+func LoadUploadScanConfig() *UploadScanConfig {
+	config := DefaultUploadScanConfig()
+
+	config.Enabled = getEnvBool("UPLOAD_SCAN_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	for _, name := range getEnvList("UPLOAD_SCAN_ROUTES", nil) {
+		prefix := "UPLOAD_SCAN_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		route := &UploadScanRouteConfig{
+			Name:     name,
+			Paths:    getEnvList(prefix+"PATHS", []string{"/" + name}),
+			Scanner:  getEnvString(prefix+"SCANNER", "clamd"),
+			Timeout:  getEnvDuration(prefix+"TIMEOUT", 30*time.Second),
+			FailOpen: getEnvBool(prefix+"FAIL_OPEN", false),
+		}
+		defaultAddress := "localhost:3310"
+		if route.Scanner == "icap" {
+			defaultAddress = "icap://localhost:1344/avscan"
+		}
+		route.Address = getEnvString(prefix+"ADDRESS", defaultAddress)
+		config.Routes = append(config.Routes, route)
+	}
+	config.SpoolDir = getEnvString("UPLOAD_SCAN_SPOOL_DIR", "")
+
+	return config
+}
@@ -0,0 +1,1930 @@
+package config
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidationIssue describes a problem with one setting
+type ValidationIssue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Source  string `json:"source"` // "<file>:<line>", "environment" or "default"
+	Line    string `json:"line,omitempty"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning"`
+}
+
+// String formats the issue with its source location
+func (i ValidationIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	msg := fmt.Sprintf("%s: %s: %s=%q: %s", i.Source, level, i.Key, i.Value, i.Message)
+	if i.Line != "" {
+		msg += "\n    " + i.Line
+	}
+	return msg
+}
+
+// ValidationReport collects the issues found while validating configuration
+type ValidationReport struct {
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// Valid reports whether the configuration has no errors (warnings are allowed)
+func (r *ValidationReport) Valid() bool {
+	for _, issue := range r.Issues {
+		if !issue.Warning {
+			return false
+		}
+	}
+	return true
+}
+
+// flagNamePattern matches valid feature flag names
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// regionPattern matches valid federation region names
+var regionPattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// tableNamePattern matches SQL table names that need no quoting
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// invalidValues records environment values the getEnv helpers could not parse
+// and silently replaced with defaults
+var (
+	invalidMu     sync.Mutex
+	invalidValues []ValidationIssue
+)
+
+// recordInvalid notes a value that failed to parse
+func recordInvalid(key, value string, err error) {
+	invalidMu.Lock()
+	defer invalidMu.Unlock()
+	invalidValues = append(invalidValues, ValidationIssue{Key: key, Value: value, Message: err.Error()})
+}
+
+// Validate loads every configuration section and reports malformed values and
+// inconsistent settings without starting any component
+func Validate() (*ValidationReport, error) {
+	invalidMu.Lock()
+	invalidValues = nil
+	invalidMu.Unlock()
+
+	report := &ValidationReport{}
+	add := func(key, message string, warning bool) {
+		issue := ValidationIssue{Key: key, Value: getEnv(key), Message: message, Warning: warning}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.InsecureJWTSecret() {
+		add("JWT_SECRET", "well-known default secret in use; set a strong secret", true)
+	}
+	if !oneOf(cfg.Server.Environment, "development", "staging", "production") {
+		add("GATEWAY_ENV", "must be development, staging or production", false)
+	}
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		add("TLS_CERT_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together", false)
+	}
+	if cfg.Server.HTTP3Enabled {
+		if !cfg.Server.TLSEnabled() {
+			add("HTTP3_ENABLED", "requires TLS_CERT_FILE and TLS_KEY_FILE", false)
+		}
+		if port, err := strconv.Atoi(cfg.Server.HTTP3Port); err != nil || port < 1 || port > 65535 {
+			add("HTTP3_PORT", "must be a port number", false)
+		}
+		if cfg.Server.HTTP3AltSvcMaxAge < time.Second {
+			add("HTTP3_ALT_SVC_MAX_AGE", "must be at least 1s", false)
+		}
+	}
+	for _, value := range cfg.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(value); err != nil && net.ParseIP(value) == nil {
+			add("TRUSTED_PROXIES", fmt.Sprintf("%q is not an IP address or CIDR range", value), false)
+		}
+	}
+	if cfg.CORS.AllowsAnyOrigin() && cfg.CORS.AllowCredentials {
+		add("CORS_ALLOW_CREDENTIALS", "credentials are allowed for any origin", true)
+	}
+	if cfg.JWT.ExpiryHours <= 0 {
+		add("JWT_EXPIRY_HOURS", "must be positive", false)
+	}
+
+	for role, permissions := range cfg.Permissions.Roles {
+		for _, permission := range permissions {
+			if resource, action, ok := strings.Cut(permission, ":"); permission != "*" && (!ok || resource == "" || action == "") {
+				add("RBAC_ROLE_"+strings.ToUpper(strings.ReplaceAll(role, "-", "_"))+"_PERMISSIONS",
+					fmt.Sprintf("permission %q must be resource:action, resource:* or *", permission), false)
+			}
+		}
+	}
+
+	if groups := cfg.Groups; groups.Enabled {
+		if !oneOf(groups.Resolve, "lookup", "issuance") {
+			add("GROUPS_RESOLVE", "must be lookup or issuance", false)
+		}
+		if !groups.UseRedis && groups.File == "" {
+			add("GROUPS_FILE", "must not be empty without GROUPS_USE_REDIS", false)
+		}
+		if groups.RefreshInterval < 0 {
+			add("GROUPS_REFRESH_INTERVAL", "must not be negative", false)
+		}
+	}
+
+	if ldap := cfg.LDAP; ldap.Enabled {
+		if u, err := url.Parse(ldap.URL); err != nil || !oneOf(u.Scheme, "ldap", "ldaps") || u.Host == "" {
+			add("LDAP_URL", "must be an ldap:// or ldaps:// URL", false)
+		} else if u.Scheme == "ldaps" && ldap.StartTLS {
+			add("LDAP_START_TLS", "cannot be used with ldaps:// URLs", false)
+		} else if u.Scheme == "ldap" && !ldap.StartTLS {
+			add("LDAP_URL", "passwords are sent in plaintext; use ldaps:// or LDAP_START_TLS", true)
+		}
+		if ldap.BaseDN == "" {
+			add("LDAP_BASE_DN", "must be set when LDAP_ENABLED is true", false)
+		}
+		if !strings.Contains(ldap.UserFilter, "%s") || !strings.HasPrefix(ldap.UserFilter, "(") {
+			add("LDAP_USER_FILTER", "must be a parenthesized filter containing %s", false)
+		}
+		if ldap.BindDN != "" && ldap.BindPassword == "" {
+			add("LDAP_BIND_PASSWORD", "must be set with LDAP_BIND_DN", false)
+		}
+		if ldap.InsecureSkipVerify {
+			add("LDAP_TLS_INSECURE_SKIP_VERIFY", "server certificates are not verified", true)
+		}
+		if ldap.PoolSize <= 0 {
+			add("LDAP_POOL_SIZE", "must be positive", false)
+		}
+		if ldap.Timeout <= 0 {
+			add("LDAP_TIMEOUT", "must be positive", false)
+		}
+	}
+
+	if saml := cfg.SAML; saml.Enabled {
+		if u, err := url.Parse(saml.RootURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+			add("SAML_ROOT_URL", "must be the external http(s) URL of the gateway", false)
+		} else if u.Scheme == "http" {
+			add("SAML_ROOT_URL", "assertions are posted in plaintext; use https", true)
+		}
+		if saml.IdPEntityID == "" {
+			add("SAML_IDP_ENTITY_ID", "must be set when SAML_ENABLED is true", false)
+		}
+		if u, err := url.Parse(saml.IdPSSOURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+			add("SAML_IDP_SSO_URL", "must be an http(s) URL", false)
+		}
+		if saml.IdPCertFile == "" {
+			add("SAML_IDP_CERT_FILE", "must be set when SAML_ENABLED is true", false)
+		}
+		if saml.ClockSkew < 0 {
+			add("SAML_CLOCK_SKEW", "must not be negative", false)
+		}
+	}
+
+	if deviceFlow := cfg.DeviceFlow; deviceFlow.Enabled {
+		if deviceFlow.CodeLifetime <= 0 {
+			add("DEVICE_FLOW_CODE_LIFETIME", "must be positive", false)
+		}
+		if deviceFlow.PollInterval < time.Second {
+			add("DEVICE_FLOW_POLL_INTERVAL", "must be at least 1s", false)
+		} else if deviceFlow.PollInterval >= deviceFlow.CodeLifetime {
+			add("DEVICE_FLOW_POLL_INTERVAL", "must be shorter than DEVICE_FLOW_CODE_LIFETIME", false)
+		}
+		if verificationURL := deviceFlow.VerificationURL; verificationURL != "" {
+			if u, err := url.Parse(verificationURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+				add("DEVICE_FLOW_VERIFICATION_URL", "must be an http(s) URL", false)
+			}
+		}
+	}
+
+	if personalTokens := cfg.PersonalTokens; personalTokens.Enabled {
+		if !personalTokens.UseRedis && personalTokens.File == "" {
+			add("PERSONAL_TOKENS_FILE", "must not be empty without PERSONAL_TOKENS_USE_REDIS", false)
+		}
+		if personalTokens.MaxPerUser <= 0 {
+			add("PERSONAL_TOKENS_MAX_PER_USER", "must be positive", false)
+		}
+		if personalTokens.MaxLifetime < 0 {
+			add("PERSONAL_TOKENS_MAX_LIFETIME", "must not be negative", false)
+		}
+		if personalTokens.RefreshInterval < 0 {
+			add("PERSONAL_TOKENS_REFRESH_INTERVAL", "must not be negative", false)
+		}
+	}
+
+	if cfg.Impersonation.Enabled && cfg.Impersonation.MaxLifetime <= 0 {
+		add("IMPERSONATION_MAX_LIFETIME", "must be positive", false)
+	}
+
+	issuerNames := map[string]bool{GatewayIssuer: true}
+	issuerClaims := map[string]string{cfg.JWT.Issuer: GatewayIssuer}
+	for _, issuer := range cfg.JWT.TrustedIssuers {
+		prefix := trustedIssuerPrefix(issuer.Name)
+		if issuerNames[issuer.Name] {
+			add("JWT_TRUSTED_ISSUERS", fmt.Sprintf("name %q is reserved or listed twice", issuer.Name), false)
+		}
+		issuerNames[issuer.Name] = true
+		if issuer.Issuer == "" {
+			add(prefix+"ISSUER", "must not be empty", false)
+		} else if other, ok := issuerClaims[issuer.Issuer]; ok {
+			add(prefix+"ISSUER", fmt.Sprintf("is already used by issuer %q", other), false)
+		}
+		issuerClaims[issuer.Issuer] = issuer.Name
+		if (issuer.Secret == "") == (issuer.JWKSURL == "") {
+			add(prefix+"SECRET", "exactly one of SECRET and JWKS_URL must be set", false)
+		}
+		if issuer.JWKSURL != "" {
+			if u, err := url.Parse(issuer.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+				add(prefix+"JWKS_URL", "must be an absolute URL", false)
+			}
+			if issuer.JWKSRefresh <= 0 {
+				add(prefix+"JWKS_REFRESH", "must be positive", false)
+			}
+		}
+	}
+
+	if scim := cfg.SCIM; scim.Enabled {
+		if scim.Token == "" {
+			add("SCIM_TOKEN", "must be set when SCIM_ENABLED is true", false)
+		}
+		if !scim.UseRedis && scim.UsersFile == "" {
+			add("SCIM_USERS_FILE", "must not be empty without SCIM_USE_REDIS", false)
+		}
+		if scim.RefreshInterval < 0 {
+			add("SCIM_REFRESH_INTERVAL", "must not be negative", false)
+		}
+		for _, name := range scim.RequiredIssuers {
+			if !issuerNames[name] {
+				add("SCIM_REQUIRED_ISSUERS", fmt.Sprintf("unknown issuer %q", name), false)
+			}
+		}
+		if !cfg.Groups.Enabled {
+			add("SCIM_ENABLED", "the Groups endpoints require GROUPS_ENABLED", true)
+		}
+	}
+
+	if cfg.APIKeys.Retention < 0 {
+		add("API_KEY_RETENTION", "must not be negative", false)
+	}
+	if cfg.APIKeys.RefreshInterval < 0 {
+		add("API_KEY_REFRESH_INTERVAL", "must not be negative", false)
+	}
+
+	if sqlStorage := cfg.SQLStorage; sqlStorage.Enabled {
+		if sqlStorage.Driver == "" {
+			add("SQL_STORAGE_DRIVER", "is required", false)
+		} else if !slices.Contains(sql.Drivers(), sqlStorage.Driver) {
+			add("SQL_STORAGE_DRIVER", fmt.Sprintf("no database/sql driver %q is registered; programs embedding the gateway must import one", sqlStorage.Driver), false)
+		}
+		if sqlStorage.DSN == "" {
+			add("SQL_STORAGE_DSN", "is required", false)
+		}
+		if !tableNamePattern.MatchString(sqlStorage.Table) {
+			add("SQL_STORAGE_TABLE", "must be a table name of letters, digits and underscores", false)
+		}
+		if !oneOf(sqlStorage.Placeholder, "?", "$") {
+			add("SQL_STORAGE_PLACEHOLDER", "must be ? or $", false)
+		}
+		if sqlStorage.MaxOpenConns < 1 {
+			add("SQL_STORAGE_MAX_OPEN_CONNS", "must be at least 1", false)
+		}
+	}
+
+	rateLimit := cfg.RateLimit
+	if rateLimit.Enabled {
+		if !oneOf(rateLimit.Identifier, "ip", "jwt", "apikey", "user") {
+			add("RATE_LIMIT_IDENTIFIER", "must be one of ip, jwt, apikey, user", false)
+		}
+		if rateLimit.Capacity <= 0 {
+			add("RATE_LIMIT_CAPACITY", "must be positive", false)
+		}
+		if rateLimit.RefillRate <= 0 {
+			add("RATE_LIMIT_REFILL_RATE", "must be positive", false)
+		}
+		if peerSync := rateLimit.Sync; peerSync.Enabled {
+			if rateLimit.UseRedis {
+				add("RATE_LIMIT_SYNC_ENABLED", "ignored when RATE_LIMIT_USE_REDIS is enabled", true)
+			}
+			if _, _, err := net.SplitHostPort(peerSync.ListenAddr); err != nil {
+				add("RATE_LIMIT_SYNC_LISTEN", "must be host:port", false)
+			}
+			if len(peerSync.Peers) == 0 {
+				add("RATE_LIMIT_SYNC_PEERS", "at least one peer is required", false)
+			}
+			for _, peer := range peerSync.Peers {
+				if _, _, err := net.SplitHostPort(peer); err != nil {
+					add("RATE_LIMIT_SYNC_PEERS", fmt.Sprintf("peer %q must be host:port", peer), false)
+				}
+			}
+			if peerSync.Interval <= 0 {
+				add("RATE_LIMIT_SYNC_INTERVAL", "must be positive", false)
+			}
+			// Anyone holding the key can drain every client's buckets from
+			// the network, so it must not be a default or shared with tokens
+			switch {
+			case peerSync.Key == "":
+				add("RATE_LIMIT_SYNC_KEY", "required when RATE_LIMIT_SYNC_ENABLED is set", false)
+			case slices.Contains(insecureJWTSecrets, peerSync.Key):
+				add("RATE_LIMIT_SYNC_KEY", "well-known default secret in use; set a strong key", false)
+			case peerSync.Key == cfg.JWT.Secret:
+				add("RATE_LIMIT_SYNC_KEY", "must differ from JWT_SECRET", false)
+			}
+		}
+		for _, value := range rateLimit.Exempt.IPs {
+			if _, _, err := net.ParseCIDR(value); err != nil && net.ParseIP(value) == nil {
+				add("RATE_LIMIT_EXEMPT_IPS", fmt.Sprintf("%q is not an IP address or CIDR range", value), false)
+			}
+		}
+		if rateLimit.Exempt.RefreshInterval < 0 {
+			add("RATE_LIMIT_EXEMPT_REFRESH_INTERVAL", "must not be negative", false)
+		}
+	}
+
+	if penaltyBox := cfg.PenaltyBox; penaltyBox.Enabled {
+		for _, status := range penaltyBox.Statuses {
+			if status < 400 || status > 599 {
+				add("PENALTY_BOX_STATUSES", fmt.Sprintf("%d is not a 4xx or 5xx status", status), false)
+			}
+		}
+		if penaltyBox.StrikeWindow <= 0 {
+			add("PENALTY_BOX_STRIKE_WINDOW", "must be positive", false)
+		}
+		if penaltyBox.LimitAfter < 0 {
+			add("PENALTY_BOX_LIMIT_AFTER", "must not be negative", false)
+		}
+		if penaltyBox.BanAfter < 0 {
+			add("PENALTY_BOX_BAN_AFTER", "must not be negative", false)
+		}
+		if penaltyBox.LimitAfter == 0 && penaltyBox.BanAfter == 0 {
+			add("PENALTY_BOX_LIMIT_AFTER", "neither limits nor bans are enabled, so no client is penalized", true)
+		}
+		if penaltyBox.LimitAfter > 0 && penaltyBox.BanAfter > 0 && penaltyBox.BanAfter <= penaltyBox.LimitAfter {
+			add("PENALTY_BOX_BAN_AFTER", "is not above PENALTY_BOX_LIMIT_AFTER, so clients are banned without being limited first", true)
+		}
+		if penaltyBox.LimitAfter > 0 {
+			if penaltyBox.LimitDuration <= 0 {
+				add("PENALTY_BOX_LIMIT_DURATION", "must be positive", false)
+			}
+			if penaltyBox.LimitCapacity <= 0 {
+				add("PENALTY_BOX_LIMIT_CAPACITY", "must be positive", false)
+			}
+			if penaltyBox.LimitRefillRate <= 0 {
+				add("PENALTY_BOX_LIMIT_REFILL_RATE", "must be positive", false)
+			}
+		}
+		if penaltyBox.BanAfter > 0 && penaltyBox.BanDuration <= 0 {
+			add("PENALTY_BOX_BAN_DURATION", "must be positive", false)
+		}
+		if penaltyBox.History <= 0 {
+			add("PENALTY_BOX_HISTORY", "must be positive", false)
+		}
+		if penaltyBox.MaxDuration < penaltyBox.LimitDuration || penaltyBox.MaxDuration < penaltyBox.BanDuration {
+			add("PENALTY_BOX_MAX_DURATION", "must be at least the limit and ban durations", false)
+		}
+	}
+
+	if anomaly := cfg.Anomaly; anomaly.Enabled {
+		for _, dimension := range anomaly.Dimensions {
+			if !oneOf(dimension, "route", "consumer") {
+				add("ANOMALY_DIMENSIONS", fmt.Sprintf("%q must be route or consumer", dimension), false)
+			}
+		}
+		if len(anomaly.Dimensions) == 0 {
+			add("ANOMALY_DIMENSIONS", "at least one of route or consumer is required", false)
+		}
+		if anomaly.Interval <= 0 {
+			add("ANOMALY_INTERVAL", "must be positive", false)
+		}
+		if anomaly.Alpha <= 0 || anomaly.Alpha > 1 {
+			add("ANOMALY_ALPHA", "must be above 0 and at most 1", false)
+		}
+		if anomaly.Threshold <= 0 {
+			add("ANOMALY_THRESHOLD", "must be positive", false)
+		} else if anomaly.Threshold < 2 {
+			add("ANOMALY_THRESHOLD", "below 2 standard deviations alerts on ordinary fluctuations", true)
+		}
+		if anomaly.Warmup < 1 {
+			add("ANOMALY_WARMUP", "must be at least 1", false)
+		}
+		if anomaly.MinRequests < 1 {
+			add("ANOMALY_MIN_REQUESTS", "must be at least 1", false)
+		}
+		if anomaly.Cooldown < 0 {
+			add("ANOMALY_COOLDOWN", "must not be negative", false)
+		}
+		if anomaly.MaxSeries <= 0 {
+			add("ANOMALY_MAX_SERIES", "must be positive", false)
+		}
+		if anomaly.WebhookURL != "" {
+			if u, err := url.Parse(anomaly.WebhookURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+				add("ANOMALY_WEBHOOK_URL", "must be an http or https URL", false)
+			}
+			if anomaly.WebhookTimeout <= 0 {
+				add("ANOMALY_WEBHOOK_TIMEOUT", "must be positive", false)
+			}
+		}
+	}
+
+	if metrics := cfg.Metrics; metrics.Enabled {
+		if metrics.MaxRoutes < 0 {
+			add("METRICS_MAX_ROUTES", "must not be negative", false)
+		}
+		if metrics.MaxConsumers < 0 {
+			add("METRICS_MAX_CONSUMERS", "must not be negative", false)
+		}
+	}
+
+	if statsd := cfg.StatsD; statsd.Enabled {
+		if !oneOf(statsd.Network, "udp", "unixgram") {
+			add("STATSD_NETWORK", "must be udp or unixgram", false)
+		}
+		if statsd.Address == "" {
+			add("STATSD_ADDRESS", "is required", false)
+		}
+		if !oneOf(statsd.Format, "dogstatsd", "statsd") {
+			add("STATSD_FORMAT", "must be dogstatsd or statsd", false)
+		}
+		if statsd.FlushInterval <= 0 {
+			add("STATSD_FLUSH_INTERVAL", "must be positive", false)
+		}
+		if statsd.SampleRate <= 0 || statsd.SampleRate > 1 {
+			add("STATSD_SAMPLE_RATE", "must be in (0, 1]", false)
+		}
+		if statsd.MaxPacketSize < 512 {
+			add("STATSD_MAX_PACKET_SIZE", "must be at least 512", false)
+		}
+	}
+
+	if accessLog := cfg.AccessLog; accessLog.Enabled {
+		if len(accessLog.Sinks) == 0 {
+			add("ACCESS_LOG_SINKS", "must list at least one sink", false)
+		}
+		for _, sink := range accessLog.Sinks {
+			switch sink {
+			case "stdout":
+			case "file":
+				if accessLog.File == "" {
+					add("ACCESS_LOG_FILE", "is required with the file sink", false)
+				} else if info, err := os.Stat(filepath.Dir(accessLog.File)); err != nil || !info.IsDir() {
+					add("ACCESS_LOG_FILE", "must be in an existing directory", false)
+				}
+				if accessLog.FileMaxSize < 0 || accessLog.FileRotateEvery < 0 || accessLog.FileMaxBackups < 0 {
+					add("ACCESS_LOG_FILE_MAX_SIZE", "rotation settings must not be negative", false)
+				}
+			case "syslog":
+				if !oneOf(accessLog.SyslogNetwork, "udp", "tcp", "unix", "unixgram") {
+					add("ACCESS_LOG_SYSLOG_NETWORK", "must be udp, tcp, unix or unixgram", false)
+				}
+				if accessLog.SyslogAddress == "" {
+					add("ACCESS_LOG_SYSLOG_ADDRESS", "is required with the syslog sink", false)
+				}
+				if !oneOf(accessLog.SyslogFacility, "user", "daemon", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7") {
+					add("ACCESS_LOG_SYSLOG_FACILITY", "must be user, daemon or local0 to local7", false)
+				}
+			case "kafka":
+				if u, err := url.Parse(accessLog.KafkaRESTURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+					add("ACCESS_LOG_KAFKA_REST_URL", "must be an http or https URL with the kafka sink", false)
+				}
+				if accessLog.KafkaTopic == "" {
+					add("ACCESS_LOG_KAFKA_TOPIC", "is required with the kafka sink", false)
+				}
+			case "loki":
+				if u, err := url.Parse(accessLog.LokiURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+					add("ACCESS_LOG_LOKI_URL", "must be an http or https URL with the loki sink", false)
+				}
+				if len(accessLog.LokiLabels) == 0 {
+					add("ACCESS_LOG_LOKI_LABELS", "must have at least one label", false)
+				}
+			default:
+				add("ACCESS_LOG_SINKS", fmt.Sprintf("unknown sink %q; must be stdout, file, syslog, kafka or loki", sink), false)
+			}
+		}
+		if accessLog.BufferSize <= 0 {
+			add("ACCESS_LOG_BUFFER_SIZE", "must be positive", false)
+		}
+		if accessLog.BatchSize <= 0 {
+			add("ACCESS_LOG_BATCH_SIZE", "must be positive", false)
+		}
+		if accessLog.FlushInterval <= 0 {
+			add("ACCESS_LOG_FLUSH_INTERVAL", "must be positive", false)
+		}
+		if accessLog.WriteTimeout <= 0 {
+			add("ACCESS_LOG_WRITE_TIMEOUT", "must be positive", false)
+		}
+		if accessLog.MaxAttempts < 1 {
+			add("ACCESS_LOG_MAX_ATTEMPTS", "must be at least 1", false)
+		}
+	}
+
+	if slo := cfg.SLO; slo.Enabled {
+		if len(slo.Objectives) == 0 {
+			add("SLOS", "lists no objectives, so nothing is tracked", true)
+		}
+		if slo.Window < time.Hour {
+			add("SLO_WINDOW", "must be at least 1h", false)
+		}
+		if slo.FastBurnRate <= 0 || slo.SlowBurnRate <= 0 {
+			add("SLO_FAST_BURN_RATE", "burn rates must be positive", false)
+		} else if slo.SlowBurnRate > slo.FastBurnRate {
+			add("SLO_SLOW_BURN_RATE", "is above SLO_FAST_BURN_RATE", true)
+		}
+		if slo.Interval <= 0 {
+			add("SLO_INTERVAL", "must be positive", false)
+		}
+		if slo.WebhookURL != "" {
+			if u, err := url.Parse(slo.WebhookURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+				add("SLO_WEBHOOK_URL", "must be an http or https URL", false)
+			}
+			if slo.WebhookTimeout <= 0 {
+				add("SLO_WEBHOOK_TIMEOUT", "must be positive", false)
+			}
+		}
+
+		objectiveNames := make(map[string]bool)
+		for _, objective := range slo.Objectives {
+			prefix := sloPrefix(objective.Name)
+			if objectiveNames[objective.Name] {
+				add("SLOS", fmt.Sprintf("objective %q is listed twice", objective.Name), false)
+			}
+			objectiveNames[objective.Name] = true
+			for _, path := range objective.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			if objective.Latency < 0 {
+				add(prefix+"LATENCY", "must not be negative", false)
+			}
+			if objective.Latency > 0 && (objective.LatencyTarget <= 0 || objective.LatencyTarget >= 1) {
+				add(prefix+"LATENCY_TARGET", "must be in (0, 1)", false)
+			}
+			if objective.ErrorRate < 0 || objective.ErrorRate >= 1 {
+				add(prefix+"ERROR_RATE", "must be in [0, 1)", false)
+			}
+			if objective.Latency == 0 && objective.ErrorRate == 0 {
+				add(prefix+"LATENCY", "objective has neither a latency nor an error rate objective", true)
+			}
+		}
+	}
+
+	if latencyBudget := cfg.LatencyBudget; latencyBudget.Enabled {
+		if latencyBudget.Header == "" {
+			add("LATENCY_BUDGET_HEADER", "must not be empty", false)
+		}
+		if latencyBudget.Default < 0 {
+			add("LATENCY_BUDGET_DEFAULT", "must not be negative", false)
+		}
+		for prefix, budget := range latencyBudget.Routes {
+			if !strings.HasPrefix(prefix, "/") {
+				add("LATENCY_BUDGET_ROUTES", fmt.Sprintf("path %q must start with /", prefix), false)
+			}
+			if budget <= 0 {
+				add("LATENCY_BUDGET_ROUTES", fmt.Sprintf("budget for %q must be positive", prefix), false)
+			}
+		}
+		if latencyBudget.FromSLO {
+			if latencyBudget.SLOMultiplier <= 0 {
+				add("LATENCY_BUDGET_SLO_MULTIPLIER", "must be positive", false)
+			} else if latencyBudget.SLOMultiplier < 1 {
+				add("LATENCY_BUDGET_SLO_MULTIPLIER", "aborts requests that would still meet their SLO", true)
+			}
+		}
+		if latencyBudget.Default == 0 && len(latencyBudget.Routes) == 0 && (!latencyBudget.FromSLO || !cfg.SLO.Enabled) {
+			add("LATENCY_BUDGET_ENABLED", "no route has a budget; set LATENCY_BUDGET_DEFAULT, LATENCY_BUDGET_ROUTES or SLO latency objectives", true)
+		}
+	}
+
+	if chargeback := cfg.Chargeback; chargeback.Enabled {
+		if chargeback.UnitsPerRequest < 0 {
+			add("CHARGEBACK_UNITS_PER_REQUEST", "must not be negative", false)
+		}
+		if chargeback.UnitsPerGB < 0 {
+			add("CHARGEBACK_UNITS_PER_GB", "must not be negative", false)
+		}
+		if chargeback.UnitsPerComputeSecond < 0 {
+			add("CHARGEBACK_UNITS_PER_COMPUTE_SECOND", "must not be negative", false)
+		}
+		for prefix, weight := range chargeback.RouteWeights {
+			if !strings.HasPrefix(prefix, "/") {
+				add("CHARGEBACK_ROUTE_WEIGHTS", fmt.Sprintf("route prefix %q must start with /", prefix), false)
+			}
+			if weight < 0 {
+				add("CHARGEBACK_ROUTE_WEIGHTS", fmt.Sprintf("weight of %s must not be negative", prefix), false)
+			}
+		}
+		if chargeback.FlushInterval <= 0 {
+			add("CHARGEBACK_FLUSH_INTERVAL", "must be positive", false)
+		}
+		if chargeback.RetentionMonths < 1 {
+			add("CHARGEBACK_RETENTION_MONTHS", "must be at least 1", false)
+		}
+	}
+
+	if metering := cfg.Metering; metering.Enabled {
+		if !cfg.Chargeback.Enabled {
+			add("METERING_ENABLED", "requires CHARGEBACK_ENABLED, whose usage is reported", false)
+		}
+		switch metering.Provider {
+		case "stripe":
+			if metering.StripeAPIKey == "" {
+				add("METERING_STRIPE_API_KEY", "is required with the stripe provider", false)
+			}
+			if u, err := url.Parse(metering.StripeURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+				add("METERING_STRIPE_URL", "must be an http or https URL", false)
+			}
+			if len(metering.SubscriptionItems) == 0 {
+				add("METERING_STRIPE_SUBSCRIPTION_ITEMS", "is empty, so no usage is reported", true)
+			}
+		case "http":
+			if u, err := url.Parse(metering.HTTPURL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+				add("METERING_HTTP_URL", "must be an http or https URL", false)
+			}
+		default:
+			add("METERING_PROVIDER", "must be stripe or http", false)
+		}
+		if !oneOf(metering.Quantity, "requests", "bytes", "cost_units") {
+			add("METERING_QUANTITY", "must be requests, bytes or cost_units", false)
+		}
+		if metering.Interval <= 0 {
+			add("METERING_INTERVAL", "must be positive", false)
+		}
+		if metering.MaxRetries < 0 {
+			add("METERING_MAX_RETRIES", "must not be negative", false)
+		}
+		if metering.RetryBackoff <= 0 {
+			add("METERING_RETRY_BACKOFF", "must be positive", false)
+		}
+		if metering.Timeout <= 0 {
+			add("METERING_TIMEOUT", "must be positive", false)
+		}
+	}
+
+	if anonymous := cfg.Anonymous; anonymous.Enabled {
+		if len(anonymous.Paths) == 0 {
+			add("ANONYMOUS_PATHS", "at least one route prefix is required when ANONYMOUS_ENABLED is true", false)
+		}
+		for _, path := range anonymous.Paths {
+			if !strings.HasPrefix(path, "/") {
+				add("ANONYMOUS_PATHS", fmt.Sprintf("path %q must start with /", path), false)
+			}
+		}
+		if anonymous.Capacity <= 0 {
+			add("ANONYMOUS_RATE_LIMIT_CAPACITY", "must be positive", false)
+		}
+		if anonymous.RefillRate <= 0 {
+			add("ANONYMOUS_RATE_LIMIT_REFILL_RATE", "must be positive", false)
+		}
+		if anonymous.Quota < 0 {
+			add("ANONYMOUS_QUOTA", "must not be negative", false)
+		} else if anonymous.Quota > 0 && anonymous.QuotaWindow <= 0 {
+			add("ANONYMOUS_QUOTA_WINDOW", "must be positive", false)
+		}
+		if len(anonymous.FingerprintHeaders) == 0 {
+			add("ANONYMOUS_FINGERPRINT_HEADERS", "clients behind a shared IP share one quota", true)
+		}
+	}
+
+	policy := cfg.Policy
+	if policy.Enabled {
+		if !oneOf(policy.Mode, "augment", "replace") {
+			add("OPA_MODE", "must be augment or replace", false)
+		}
+		if u, err := url.Parse(policy.OPAURL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("OPA_URL", "must be an absolute URL", false)
+		}
+	}
+
+	wafConfig := cfg.WAF
+	if wafConfig.Enabled {
+		if !oneOf(wafConfig.Mode, "block", "log", "off") {
+			add("WAF_MODE", "must be block, log or off", false)
+		}
+		for prefix, mode := range wafConfig.RouteModes {
+			if !oneOf(mode, "block", "log", "off") {
+				add("WAF_ROUTE_MODES", fmt.Sprintf("route %s: mode must be block, log or off", prefix), false)
+			}
+		}
+	}
+
+	compression := cfg.Compression
+	if compression.Enabled {
+		if compression.Level < 1 || compression.Level > 9 {
+			add("COMPRESSION_LEVEL", "must be between 1 and 9", false)
+		}
+		for _, encoding := range compression.Encodings {
+			if !oneOf(encoding, "br", "gzip") {
+				add("COMPRESSION_ENCODINGS", fmt.Sprintf("unsupported encoding %q", encoding), false)
+			}
+		}
+	}
+
+	idempotency := cfg.Idempotency
+	if idempotency.Enabled && idempotency.TTL <= 0 {
+		add("IDEMPOTENCY_TTL", "must be positive", false)
+	}
+	if idempotency.Enabled && idempotency.MaxRequestSize <= 0 {
+		add("IDEMPOTENCY_MAX_REQUEST_SIZE", "must be positive", false)
+	}
+
+	if cache := cfg.Cache; cache.Enabled {
+		for _, prefix := range cache.PathPrefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				add("CACHE_PATH_PREFIXES", fmt.Sprintf("prefix %q must start with /", prefix), false)
+			}
+		}
+		if cache.DefaultTTL < 0 {
+			add("CACHE_DEFAULT_TTL", "must not be negative", false)
+		}
+		if cache.MaxTTL <= 0 {
+			add("CACHE_MAX_TTL", "must be positive", false)
+		}
+		if cache.DefaultTTL > cache.MaxTTL {
+			add("CACHE_DEFAULT_TTL", "exceeds CACHE_MAX_TTL", true)
+		}
+		if cache.StaleWhileRevalidate < 0 {
+			add("CACHE_STALE_WHILE_REVALIDATE", "must not be negative", false)
+		}
+		if cache.StaleIfError < 0 {
+			add("CACHE_STALE_IF_ERROR", "must not be negative", false)
+		}
+		if cache.MaxBodySize <= 0 {
+			add("CACHE_MAX_BODY_SIZE", "must be positive", false)
+		}
+		if !validHeaderName(cache.TagHeader) {
+			add("CACHE_TAG_HEADER", "is not a valid header name", false)
+		}
+		if len(cfg.Proxy.Upstreams) == 0 {
+			add("CACHE_ENABLED", "only upstream responses are cached and no upstreams are configured", true)
+		}
+	}
+
+	if conditional := cfg.Conditional; conditional.Enabled {
+		for _, prefix := range conditional.PathPrefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				add("CONDITIONAL_PATH_PREFIXES", fmt.Sprintf("prefix %q must start with /", prefix), false)
+			}
+		}
+		if conditional.MaxBodySize < 0 {
+			add("CONDITIONAL_MAX_BODY_SIZE", "must not be negative", false)
+		}
+		if len(cfg.Proxy.Upstreams) == 0 {
+			add("CONDITIONAL_ENABLED", "only upstream routes are handled and no upstreams are configured", true)
+		}
+	}
+
+	if async := cfg.Async; async.Enabled {
+		if async.Workers <= 0 {
+			add("ASYNC_WORKERS", "must be positive", false)
+		}
+		if async.QueueSize <= 0 {
+			add("ASYNC_QUEUE_SIZE", "must be positive", false)
+		}
+		if async.MaxBodySize <= 0 {
+			add("ASYNC_MAX_BODY_SIZE", "must be positive", false)
+		}
+		if async.MaxResultSize <= 0 {
+			add("ASYNC_MAX_RESULT_SIZE", "must be positive", false)
+		}
+		if async.JobTimeout <= 0 {
+			add("ASYNC_JOB_TIMEOUT", "must be positive", false)
+		}
+		if async.ResultTTL <= async.JobTimeout {
+			add("ASYNC_RESULT_TTL", "must exceed ASYNC_JOB_TIMEOUT, or jobs expire before they finish", false)
+		}
+		if async.UseRedis && async.Stream == "" {
+			add("ASYNC_STREAM", "required when ASYNC_USE_REDIS is set", false)
+		}
+		if !slices.ContainsFunc(cfg.Proxy.Upstreams, func(u *UpstreamConfig) bool { return u.Async != "off" }) {
+			add("ASYNC_ENABLED", "no upstream sets ASYNC, so no requests are queued", true)
+		}
+	}
+
+	if mqtt := cfg.MQTT; mqtt.Enabled {
+		if port, err := strconv.Atoi(mqtt.Port); err != nil || port < 1 || port > 65535 {
+			add("MQTT_PORT", "must be a port number", false)
+		} else if mqtt.Port == cfg.Server.Port && (mqtt.Host == cfg.Server.Host || mqtt.Host == "" || cfg.Server.Host == "") {
+			add("MQTT_PORT", "must differ from PORT", false)
+		}
+		if mqtt.TLS && !cfg.Server.TLSEnabled() {
+			add("MQTT_TLS", "requires TLS_CERT_FILE and TLS_KEY_FILE", false)
+		}
+		if !mqtt.TLS {
+			add("MQTT_TLS", "device credentials are sent in plaintext; enable MQTT_TLS", true)
+		}
+		if len(mqtt.Routes) == 0 {
+			add("MQTT_ROUTES", "no topics are routed, so every message is dropped", true)
+		}
+		for filter, path := range mqtt.Routes {
+			levels := strings.Split(filter, "/")
+			for i, level := range levels {
+				if strings.ContainsAny(level, "+#") && (len(level) > 1 || (level == "#" && i != len(levels)-1)) {
+					add("MQTT_ROUTES", fmt.Sprintf("topic filter %q must use + for whole levels and # only as the last level", filter), false)
+					break
+				}
+			}
+			if !strings.HasPrefix(path, "/") {
+				add("MQTT_ROUTES", fmt.Sprintf("path %q of topic filter %q must start with /", path, filter), false)
+			}
+		}
+		if mqtt.ContentType == "" {
+			add("MQTT_CONTENT_TYPE", "must not be empty", false)
+		}
+		if mqtt.MaxMessageSize <= 0 || mqtt.MaxMessageSize > 268435455 {
+			add("MQTT_MAX_MESSAGE_SIZE", "must be between 1 and 268435455 bytes", false)
+		}
+		if mqtt.MaxConnections < 0 {
+			add("MQTT_MAX_CONNECTIONS", "must not be negative", false)
+		}
+		if mqtt.ConnectTimeout <= 0 {
+			add("MQTT_CONNECT_TIMEOUT", "must be positive", false)
+		}
+		if mqtt.MessageTimeout <= 0 {
+			add("MQTT_MESSAGE_TIMEOUT", "must be positive", false)
+		}
+		if mqtt.RateCapacity < 0 {
+			add("MQTT_RATE_LIMIT_CAPACITY", "must not be negative", false)
+		}
+		if mqtt.RateCapacity > 0 && mqtt.RateRefillRate <= 0 {
+			add("MQTT_RATE_LIMIT_REFILL_RATE", "must be positive", false)
+		}
+	}
+
+	if admin := cfg.AdminGRPC; admin.Enabled {
+		if port, err := strconv.Atoi(admin.Port); err != nil || port < 1 || port > 65535 {
+			add("ADMIN_GRPC_PORT", "must be a port number", false)
+		} else if admin.Port == cfg.Server.Port && (admin.Host == cfg.Server.Host || admin.Host == "" || cfg.Server.Host == "") {
+			add("ADMIN_GRPC_PORT", "must differ from PORT", false)
+		} else if cfg.MQTT.Enabled && admin.Port == cfg.MQTT.Port && (admin.Host == cfg.MQTT.Host || admin.Host == "" || cfg.MQTT.Host == "") {
+			add("ADMIN_GRPC_PORT", "must differ from MQTT_PORT", false)
+		}
+		if admin.TLS && !cfg.Server.TLSEnabled() {
+			add("ADMIN_GRPC_TLS", "requires TLS_CERT_FILE and TLS_KEY_FILE", false)
+		}
+		if !admin.TLS {
+			add("ADMIN_GRPC_TLS", "admin tokens are sent in plaintext; enable ADMIN_GRPC_TLS", true)
+		}
+		if admin.WatchInterval <= 0 {
+			add("ADMIN_GRPC_WATCH_INTERVAL", "must be positive", false)
+		}
+	}
+
+	if devices := cfg.Devices; devices.Enabled {
+		if len(devices.Roles) == 0 {
+			add("DEVICES_ROLES", "devices have no roles, so routes requiring one refuse them", true)
+		}
+		if devices.KeyTTL <= 0 {
+			add("DEVICES_KEY_TTL", "must be positive", false)
+		}
+		if devices.EnrollmentTTL <= 0 {
+			add("DEVICES_ENROLLMENT_TTL", "must be positive", false)
+		}
+		if (devices.CACertFile == "") != (devices.CAKeyFile == "") {
+			add("DEVICES_CA_CERT_FILE", "DEVICES_CA_CERT_FILE and DEVICES_CA_KEY_FILE must be set together", false)
+		}
+		if devices.CACertFile != "" {
+			if !cfg.Server.TLSEnabled() {
+				add("DEVICES_CA_CERT_FILE", "client certificates require TLS_CERT_FILE and TLS_KEY_FILE", false)
+			}
+			if devices.CertValidity <= 0 {
+				add("DEVICES_CERT_VALIDITY", "must be positive", false)
+			}
+		}
+		if devices.RateCapacity < 0 {
+			add("DEVICES_RATE_LIMIT_CAPACITY", "must not be negative", false)
+		}
+		if devices.RateCapacity > 0 && devices.RateRefillRate <= 0 {
+			add("DEVICES_RATE_LIMIT_REFILL_RATE", "must be positive", false)
+		}
+		if devices.Quota < 0 {
+			add("DEVICES_QUOTA", "must not be negative", false)
+		}
+		if devices.QuotaWindow <= 0 {
+			add("DEVICES_QUOTA_WINDOW", "must be positive", false)
+		}
+	}
+
+	if replay := cfg.Replay; replay.Enabled {
+		if len(replay.Paths) == 0 {
+			add("REPLAY_PROTECTION_PATHS", "no routes are protected", true)
+		}
+		for _, path := range replay.Paths {
+			if !strings.HasPrefix(path, "/") {
+				add("REPLAY_PROTECTION_PATHS", fmt.Sprintf("path %q must start with /", path), false)
+			}
+		}
+		if replay.MaxSkew <= 0 {
+			add("REPLAY_PROTECTION_MAX_SKEW", "must be positive", false)
+		}
+		if !validHeaderName(replay.TimestampHeader) {
+			add("REPLAY_PROTECTION_TIMESTAMP_HEADER", "is not a valid header name", false)
+		}
+		if !validHeaderName(replay.NonceHeader) {
+			add("REPLAY_PROTECTION_NONCE_HEADER", "is not a valid header name", false)
+		}
+	}
+
+	if csrf := cfg.CSRF; csrf.Enabled {
+		if len(csrf.Paths) == 0 {
+			add("CSRF_PATHS", "no routes are protected", true)
+		}
+		for _, path := range csrf.Paths {
+			if !strings.HasPrefix(path, "/") {
+				add("CSRF_PATHS", fmt.Sprintf("path %q must start with /", path), false)
+			}
+		}
+		if len(csrf.SessionCookies) == 0 {
+			add("CSRF_SESSION_COOKIES", "must name at least one cookie", false)
+		}
+		if csrf.CookieName == "" {
+			add("CSRF_COOKIE_NAME", "must not be empty", false)
+		}
+		if !validHeaderName(csrf.HeaderName) {
+			add("CSRF_HEADER", "is not a valid header name", false)
+		}
+		if csrf.TTL <= 0 {
+			add("CSRF_TOKEN_TTL", "must be positive", false)
+		}
+		if csrf.SigningKey == "" {
+			add("CSRF_SIGNING_KEY", "must not be empty", false)
+		}
+	}
+
+	capture := cfg.Capture
+	if capture.Enabled && !oneOf(capture.Storage, "file", "redis") {
+		add("CAPTURE_STORAGE", "must be file or redis", false)
+	}
+
+	debugLog := cfg.DebugLog
+	if debugLog.Enabled && debugLog.MaxDuration <= 0 {
+		add("DEBUG_LOG_MAX_DURATION", "must be positive", false)
+	}
+
+	if tailCapture := cfg.TailCapture; tailCapture.Enabled {
+		if tailCapture.SlowThreshold < 0 {
+			add("TAIL_CAPTURE_SLOW_THRESHOLD", "must not be negative", false)
+		}
+		if tailCapture.MinStatus < 100 || tailCapture.MinStatus > 600 {
+			add("TAIL_CAPTURE_MIN_STATUS", "must be an HTTP status code", false)
+		}
+		if tailCapture.Size <= 0 {
+			add("TAIL_CAPTURE_SIZE", "must be positive", false)
+		}
+		if tailCapture.MaxBodySize < 0 {
+			add("TAIL_CAPTURE_MAX_BODY_SIZE", "must not be negative", false)
+		}
+	}
+
+	if diagnostics := cfg.Diagnostics; diagnostics.Enabled {
+		if diagnostics.ProxyPath != "" && !strings.HasPrefix(diagnostics.ProxyPath, "/") {
+			add("DIAGNOSTICS_PROXY_PATH", "must start with /", false)
+		}
+		if diagnostics.Timeout <= 0 {
+			add("DIAGNOSTICS_TIMEOUT", "must be positive", false)
+		}
+	}
+
+	shedding := cfg.Shedding
+	if shedding.Enabled {
+		priorities := []string{"low", "normal", "high", "critical"}
+		if shedding.MaxCPU <= 0 || shedding.MaxCPU > 1 {
+			add("SHEDDING_MAX_CPU", "must be in (0, 1]", false)
+		}
+		if shedding.MaxGoroutines < 0 || shedding.MaxInFlight < 0 {
+			add("SHEDDING_MAX_IN_FLIGHT", "limits must not be negative", false)
+		}
+		if shedding.SampleInterval <= 0 {
+			add("SHEDDING_SAMPLE_INTERVAL", "must be positive", false)
+		}
+		if !oneOf(shedding.DefaultPriority, priorities...) {
+			add("SHEDDING_DEFAULT_PRIORITY", "must be low, normal, high or critical", false)
+		}
+		for key, values := range map[string]map[string]string{"SHEDDING_ROUTE_PRIORITIES": shedding.RoutePriorities, "SHEDDING_ROLE_PRIORITIES": shedding.RolePriorities} {
+			for name, priority := range values {
+				if !oneOf(priority, priorities...) {
+					add(key, fmt.Sprintf("priority %q for %q must be low, normal, high or critical", priority, name), false)
+				}
+			}
+		}
+	}
+
+	if connLimits := cfg.ConnLimits; connLimits.Enabled {
+		if connLimits.MaxConns < 0 || connLimits.MaxPerIP < 0 {
+			add("CONN_LIMIT_MAX_CONNS", "limits must not be negative", false)
+		}
+		if connLimits.AcceptRate < 0 {
+			add("CONN_LIMIT_ACCEPT_RATE", "must not be negative", false)
+		}
+		if connLimits.AcceptRate > 0 && connLimits.AcceptBurst < 1 {
+			add("CONN_LIMIT_ACCEPT_BURST", "must be at least 1", false)
+		}
+		if connLimits.MaxConns > 0 && connLimits.MaxPerIP > connLimits.MaxConns {
+			add("CONN_LIMIT_MAX_PER_IP", "exceeds CONN_LIMIT_MAX_CONNS, so it has no effect", true)
+		}
+		if connLimits.MaxConns == 0 && connLimits.MaxPerIP == 0 && connLimits.AcceptRate == 0 {
+			add("CONN_LIMITS_ENABLED", "every limit is disabled, so connections are not limited", true)
+		}
+	}
+
+	autoscale := cfg.Autoscale
+	if autoscale.Enabled {
+		if autoscale.TargetInFlight < 0 || autoscale.TargetQueueDepth < 0 || autoscale.TargetP99 < 0 {
+			add("AUTOSCALE_TARGET_IN_FLIGHT", "targets must not be negative", false)
+		}
+		if autoscale.TargetCPU < 0 || autoscale.TargetCPU > 1 {
+			add("AUTOSCALE_TARGET_CPU", "must be in [0, 1]", false)
+		}
+		if autoscale.TargetInFlight == 0 && autoscale.TargetQueueDepth == 0 && autoscale.TargetP99 == 0 && autoscale.TargetCPU == 0 {
+			add("AUTOSCALE_ENABLED", "every target is disabled, so load is always zero", true)
+		}
+		if autoscale.TargetQueueDepth > 0 && !cfg.Queue.Enabled {
+			add("AUTOSCALE_TARGET_QUEUE_DEPTH", "has no effect unless QUEUE_ENABLED is set", true)
+		}
+		if autoscale.Window <= 0 {
+			add("AUTOSCALE_WINDOW", "must be positive", false)
+		}
+		if autoscale.SampleInterval <= 0 {
+			add("AUTOSCALE_SAMPLE_INTERVAL", "must be positive", false)
+		}
+	}
+
+	throttle := cfg.Throttle
+	if throttle.Enabled {
+		if throttle.DefaultRate < 0 {
+			add("THROTTLE_DEFAULT_RATE", "must not be negative", false)
+		}
+		if throttle.Burst <= 0 {
+			add("THROTTLE_BURST", "must be positive", false)
+		}
+		for plan, rate := range throttle.PlanRates {
+			if rate < 0 {
+				add("THROTTLE_PLAN_RATES", fmt.Sprintf("rate for plan %q must not be negative", plan), false)
+			}
+		}
+	}
+
+	streamLimits := cfg.StreamLimits
+	if streamLimits.Enabled {
+		for _, route := range streamLimits.Routes {
+			prefix := "STREAM_LIMIT_" + strings.ToUpper(strings.ReplaceAll(route.Name, "-", "_")) + "_"
+			for _, path := range route.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			if route.MaxRequestBytes < 0 || route.MaxResponseBytes < 0 || route.MaxDuration < 0 {
+				add(prefix+"MAX_REQUEST_BYTES", "limits must not be negative", false)
+			}
+			if route.MaxRequestBytes == 0 && route.MaxResponseBytes == 0 && route.MaxDuration == 0 {
+				add(prefix+"MAX_REQUEST_BYTES", "no limit is set, so the route group has no effect", true)
+			}
+		}
+		if streamLimits.Quota < 0 {
+			add("STREAM_QUOTA_BYTES", "must not be negative", false)
+		}
+		for plan, quota := range streamLimits.PlanQuotas {
+			if quota < 0 {
+				add("STREAM_QUOTA_PLAN_BYTES", fmt.Sprintf("quota for plan %q must not be negative", plan), false)
+			}
+		}
+		if streamLimits.QuotaWindow <= 0 {
+			add("STREAM_QUOTA_WINDOW", "must be positive", false)
+		}
+		if len(streamLimits.Routes) == 0 && streamLimits.Quota == 0 && len(streamLimits.PlanQuotas) == 0 {
+			add("STREAM_LIMIT_ROUTES", "neither route limits nor a quota is configured", true)
+		}
+	}
+
+	featureFlags := cfg.FeatureFlags
+	if featureFlags.Enabled {
+		known := make(map[string]bool, len(featureFlags.Flags))
+		for _, flag := range featureFlags.Flags {
+			known[flag.Name] = true
+			prefix := "FEATURE_FLAG_" + strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_")) + "_"
+			if !flagNamePattern.MatchString(flag.Name) {
+				add("FEATURE_FLAGS", fmt.Sprintf("flag name %q must be 1-64 lowercase letters, digits, '-' or '_'", flag.Name), false)
+			}
+			if flag.Percent < 0 || flag.Percent > 100 {
+				add(prefix+"PERCENT", "must be between 0 and 100", false)
+			}
+			if flag.Start != nil && flag.End != nil && !flag.End.After(*flag.Start) {
+				add(prefix+"END", "must be after START", false)
+			}
+		}
+		for path, flag := range featureFlags.Routes {
+			if !strings.HasPrefix(path, "/") {
+				add("FEATURE_FLAG_ROUTES", fmt.Sprintf("path %q must start with /", path), false)
+			}
+			if !known[flag] {
+				add("FEATURE_FLAG_ROUTES", fmt.Sprintf("route %s is gated by flag %q, which is not in FEATURE_FLAGS; it stays hidden until the flag is created through the admin API", path, flag), true)
+			}
+		}
+		if featureFlags.RefreshInterval < 0 {
+			add("FEATURE_FLAGS_REFRESH_INTERVAL", "must not be negative", false)
+		}
+	}
+
+	experiments := cfg.Experiments
+	if experiments.Enabled {
+		for _, experiment := range experiments.Experiments {
+			prefix := "EXPERIMENT_" + strings.ToUpper(strings.ReplaceAll(experiment.Name, "-", "_")) + "_"
+			if strings.ContainsAny(experiment.Name, ",=") {
+				add("EXPERIMENTS", fmt.Sprintf("experiment name %q must not contain ',' or '='", experiment.Name), false)
+			}
+			for _, path := range experiment.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			if experiment.Percent < 0 || experiment.Percent > 100 {
+				add(prefix+"PERCENT", "must be between 0 and 100", false)
+			}
+			if len(experiment.Variants) < 2 {
+				add(prefix+"VARIANTS", "at least two variants are needed to compare", true)
+			}
+			seen := make(map[string]bool, len(experiment.Variants))
+			for _, variant := range experiment.Variants {
+				if variant.Name == "" || strings.ContainsAny(variant.Name, ",=") {
+					add(prefix+"VARIANTS", fmt.Sprintf("variant name %q must be non-empty without ',' or '='", variant.Name), false)
+				}
+				if seen[variant.Name] {
+					add(prefix+"VARIANTS", fmt.Sprintf("variant %q is listed twice", variant.Name), false)
+				}
+				seen[variant.Name] = true
+				if variant.Weight < 0 {
+					add(prefix+"VARIANTS", fmt.Sprintf("weight of %q must not be negative", variant.Name), false)
+				}
+			}
+		}
+		if len(experiments.Experiments) == 0 {
+			add("EXPERIMENTS", "no experiments are configured", true)
+		}
+		if experiments.Header == "" {
+			add("EXPERIMENTS_HEADER", "must not be empty", false)
+		}
+		if experiments.MaxTrackedCallers <= 0 {
+			add("EXPERIMENTS_MAX_TRACKED_CALLERS", "must be positive", false)
+		}
+	}
+
+	uploadScan := cfg.UploadScan
+	if uploadScan.Enabled {
+		for _, route := range uploadScan.Routes {
+			prefix := "UPLOAD_SCAN_" + strings.ToUpper(strings.ReplaceAll(route.Name, "-", "_")) + "_"
+			for _, path := range route.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			switch route.Scanner {
+			case "clamd":
+				if _, _, err := net.SplitHostPort(route.Address); err != nil && !strings.HasPrefix(route.Address, "unix:") {
+					add(prefix+"ADDRESS", "must be host:port or unix:<path>", false)
+				}
+			case "icap":
+				if u, err := url.Parse(route.Address); err != nil || u.Scheme != "icap" || u.Host == "" {
+					add(prefix+"ADDRESS", "must be an icap://host[:port]/service URL", false)
+				}
+			default:
+				add(prefix+"SCANNER", "must be clamd or icap", false)
+			}
+			if route.Timeout <= 0 {
+				add(prefix+"TIMEOUT", "must be positive", false)
+			}
+			if route.FailOpen {
+				add(prefix+"FAIL_OPEN", "uploads pass unscanned while the scanner is unavailable", true)
+			}
+		}
+		if len(uploadScan.Routes) == 0 {
+			add("UPLOAD_SCAN_ROUTES", "no route groups are configured, so no uploads are scanned", true)
+		}
+		if uploadScan.SpoolDir != "" {
+			if info, err := os.Stat(uploadScan.SpoolDir); err != nil || !info.IsDir() {
+				add("UPLOAD_SCAN_SPOOL_DIR", "must be an existing directory", false)
+			}
+		}
+	}
+
+	queue := cfg.Queue
+	if queue.Enabled {
+		if queue.MaxConcurrent <= 0 {
+			add("QUEUE_MAX_CONCURRENT", "must be positive", false)
+		}
+		if queue.MaxDepth < 0 {
+			add("QUEUE_MAX_DEPTH", "must not be negative", false)
+		}
+		if queue.MaxWait <= 0 {
+			add("QUEUE_MAX_WAIT", "must be positive", false)
+		}
+		for name, weight := range queue.Classes {
+			if weight <= 0 {
+				add("QUEUE_CLASSES", fmt.Sprintf("weight for class %q must be positive", name), false)
+			}
+		}
+		if _, ok := queue.Classes[queue.DefaultClass]; !ok {
+			add("QUEUE_DEFAULT_CLASS", "must be one of QUEUE_CLASSES", false)
+		}
+		for prefix, name := range queue.RouteClasses {
+			if _, ok := queue.Classes[name]; !ok {
+				add("QUEUE_ROUTE_CLASSES", fmt.Sprintf("class %q for %q is not in QUEUE_CLASSES", name, prefix), false)
+			}
+		}
+	}
+
+	if cfg.Server.ShutdownTimeout <= 0 {
+		add("SHUTDOWN_TIMEOUT", "must be positive", false)
+	}
+	if cfg.Server.UpgradeTimeout <= 0 {
+		add("UPGRADE_TIMEOUT", "must be positive", false)
+	}
+	if warmRestart := cfg.WarmRestart; warmRestart.Enabled {
+		switch warmRestart.Store {
+		case "file":
+			if warmRestart.File == "" {
+				add("WARM_RESTART_FILE", "is required with the file store", false)
+			} else if info, err := os.Stat(filepath.Dir(warmRestart.File)); err != nil || !info.IsDir() {
+				add("WARM_RESTART_FILE", "must be in an existing directory", false)
+			}
+		case "redis":
+			if warmRestart.RedisKey == "" {
+				add("WARM_RESTART_REDIS_KEY", "is required with the redis store", false)
+			}
+		default:
+			add("WARM_RESTART_STORE", "must be file or redis", false)
+		}
+		if warmRestart.MaxAge <= 0 {
+			add("WARM_RESTART_MAX_AGE", "must be positive", false)
+		}
+	}
+
+	portal := cfg.Portal
+	if portal.Enabled {
+		if len(portal.Plans) == 0 {
+			add("PORTAL_PLANS", "at least one plan is required", false)
+		}
+		for plan, rateLimit := range portal.Plans {
+			if rateLimit <= 0 {
+				add("PORTAL_PLANS", fmt.Sprintf("rate limit for plan %q must be positive", plan), false)
+			}
+		}
+		if portal.MaxKeys <= 0 {
+			add("PORTAL_MAX_KEYS", "must be positive", false)
+		}
+		if portal.KeyTTL <= 0 {
+			add("PORTAL_KEY_TTL", "must be positive", false)
+		}
+	}
+
+	productNames := make(map[string]bool)
+	for _, product := range cfg.Products {
+		prefix := productPrefix(product.Name)
+		if productNames[product.Name] {
+			add("PRODUCTS", fmt.Sprintf("product %q is listed twice", product.Name), false)
+		}
+		productNames[product.Name] = true
+		for _, path := range product.Paths {
+			if !strings.HasPrefix(path, "/") {
+				add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+			}
+		}
+		for plan, quota := range product.Quotas {
+			if quota <= 0 {
+				add(prefix+"QUOTAS", fmt.Sprintf("quota for plan %q must be positive", plan), false)
+			}
+		}
+		if portal.Enabled {
+			for _, plan := range product.Plans {
+				if _, ok := portal.Plans[plan]; !ok {
+					add(prefix+"PLANS", fmt.Sprintf("plan %q is not in PORTAL_PLANS", plan), true)
+				}
+			}
+		}
+	}
+
+	if headers := cfg.Headers; headers.Enabled {
+		policies := append([]*HeaderPolicyConfig{headers.Default}, headers.Routes...)
+		policyNames := make(map[string]bool)
+		for _, policy := range policies {
+			prefix := "HEADERS_"
+			if policy != headers.Default {
+				prefix = headerPolicyPrefix(policy.Name)
+				if policyNames[policy.Name] {
+					add("HEADER_POLICIES", fmt.Sprintf("policy %q is listed twice", policy.Name), false)
+				}
+				policyNames[policy.Name] = true
+			}
+			for _, path := range policy.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			for _, name := range policy.Remove {
+				if !validHeaderName(name) {
+					add(prefix+"REMOVE", fmt.Sprintf("%q is not a valid header name", name), false)
+				}
+			}
+			for name := range policy.Set {
+				if !validHeaderName(name) {
+					add(prefix+"SET", fmt.Sprintf("%q is not a valid header name", name), false)
+				}
+			}
+		}
+	}
+
+	if errorPages := cfg.ErrorPages; errorPages.Enabled {
+		if errorPages.Dir == "" && len(errorPages.Routes) == 0 {
+			add("ERROR_PAGES_DIR", "set ERROR_PAGES_DIR or ERROR_PAGES_ROUTES", false)
+		}
+		if info, err := os.Stat(errorPages.Dir); errorPages.Dir != "" && (err != nil || !info.IsDir()) {
+			add("ERROR_PAGES_DIR", "must be a readable directory", false)
+		}
+		for prefix, dir := range errorPages.Routes {
+			if !strings.HasPrefix(prefix, "/") {
+				add("ERROR_PAGES_ROUTES", fmt.Sprintf("path %q must start with /", prefix), false)
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				add("ERROR_PAGES_ROUTES", fmt.Sprintf("%q is not a readable directory", dir), false)
+			}
+		}
+	}
+
+	if masking := cfg.Masking; masking.Enabled {
+		if len(masking.Rules) == 0 {
+			add("MASKING_RULES", "no masking rules are configured", true)
+		}
+		ruleNames := make(map[string]bool)
+		for _, rule := range masking.Rules {
+			prefix := maskingRulePrefix(rule.Name)
+			if ruleNames[rule.Name] {
+				add("MASKING_RULES", fmt.Sprintf("rule %q is listed twice", rule.Name), false)
+			}
+			ruleNames[rule.Name] = true
+			for _, path := range rule.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			if len(rule.Fields) == 0 {
+				add(prefix+"FIELDS", "is required", false)
+			}
+			for _, field := range rule.Fields {
+				if !strings.HasPrefix(field, "$") || field == "$" {
+					add(prefix+"FIELDS", fmt.Sprintf("%q must be a JSONPath selector such as $.email", field), false)
+				}
+			}
+			if len(rule.UnmaskRoles) == 0 {
+				add(prefix+"UNMASK_ROLES", "no role can see unmasked values", true)
+			}
+		}
+	}
+
+	cluster := cfg.Cluster
+	if cluster.Enabled {
+		if cluster.HeartbeatInterval <= 0 {
+			add("CLUSTER_HEARTBEAT_INTERVAL", "must be positive", false)
+		}
+		if cluster.LeaseTTL <= cluster.HeartbeatInterval {
+			add("CLUSTER_LEASE_TTL", "must be longer than CLUSTER_HEARTBEAT_INTERVAL or leadership is lost between renewals", false)
+		}
+		if cluster.CleanupInterval <= 0 {
+			add("CLUSTER_CLEANUP_INTERVAL", "must be positive", false)
+		}
+		if cluster.HealthCheckInterval <= 0 {
+			add("CLUSTER_HEALTH_CHECK_INTERVAL", "must be positive", false)
+		}
+		if cfg.RateLimit.Enabled && !cfg.RateLimit.UseRedis && !cfg.RateLimit.Sync.Enabled {
+			add("RATE_LIMIT_USE_REDIS", "rate limits are enforced per instance, not across the cluster", true)
+		}
+		if cfg.Anonymous.Enabled && !cfg.Anonymous.UseRedis {
+			add("ANONYMOUS_USE_REDIS", "anonymous limits and quotas are enforced per instance", true)
+		}
+		if cfg.Idempotency.Enabled && !cfg.Idempotency.UseRedis {
+			add("IDEMPOTENCY_USE_REDIS", "retries reaching another instance are not deduplicated", true)
+		}
+		if cfg.Cache.Enabled && !cfg.Cache.UseRedis {
+			add("CACHE_USE_REDIS", "each instance caches on its own and purges reach only the instance handling them", true)
+		}
+		if !cfg.Webhooks.UseRedis && slices.ContainsFunc(cfg.Proxy.Upstreams, func(u *UpstreamConfig) bool { return u.Type == "webhook" }) {
+			add("WEBHOOK_USE_REDIS", "webhooks redelivered to another instance are not deduplicated", true)
+		}
+		if cfg.Async.Enabled && !cfg.Async.UseRedis {
+			add("ASYNC_USE_REDIS", "jobs can only be polled on the instance that accepted them and are lost when it stops", true)
+		}
+		if cfg.MQTT.Enabled && cfg.MQTT.RateCapacity > 0 && !cfg.MQTT.UseRedis {
+			add("MQTT_USE_REDIS", "device rate limits are enforced per instance", true)
+		}
+		if cfg.Devices.Enabled && !cfg.Devices.UseRedis {
+			add("DEVICES_USE_REDIS", "devices registered on one instance are unknown to the others", true)
+		}
+		if cfg.Devices.Enabled && cfg.Devices.RateCapacity > 0 {
+			add("DEVICES_RATE_LIMIT_CAPACITY", "device rate limits are enforced per instance; quotas are shared", true)
+		}
+		if cfg.Replay.Enabled && !cfg.Replay.UseRedis {
+			add("REPLAY_PROTECTION_USE_REDIS", "requests replayed to another instance are not detected", true)
+		}
+		if cfg.Groups.Enabled && !cfg.Groups.UseRedis {
+			add("GROUPS_USE_REDIS", "each instance keeps its own groups file", true)
+		}
+		if cfg.SCIM.Enabled && !cfg.SCIM.UseRedis {
+			add("SCIM_USE_REDIS", "each instance keeps its own provisioned users file", true)
+		}
+		if !cfg.APIKeys.UseRedis && !cfg.SQLStorage.Enabled {
+			add("API_KEY_USE_REDIS", "API keys created through the admin API only exist on one instance", true)
+		}
+		if cfg.DeviceFlow.Enabled && !cfg.DeviceFlow.UseRedis && !cfg.SQLStorage.Enabled {
+			add("DEVICE_FLOW_USE_REDIS", "clients polling another instance never see the approval", true)
+		}
+		if cfg.PersonalTokens.Enabled && !cfg.PersonalTokens.UseRedis {
+			add("PERSONAL_TOKENS_USE_REDIS", "each instance keeps its own tokens file", true)
+		}
+		if cfg.FeatureFlags.Enabled && !cfg.FeatureFlags.UseRedis {
+			add("FEATURE_FLAGS_USE_REDIS", "flags changed through the admin API only change on one instance", true)
+		}
+		if cfg.StreamLimits.Enabled && (cfg.StreamLimits.Quota > 0 || len(cfg.StreamLimits.PlanQuotas) > 0) && !cfg.StreamLimits.UseRedis {
+			add("STREAM_LIMITS_USE_REDIS", "transfer quotas are enforced per instance", true)
+		}
+		if cfg.PenaltyBox.Enabled && !cfg.PenaltyBox.UseRedis && !cfg.SQLStorage.Enabled {
+			add("PENALTY_BOX_USE_REDIS", "strikes are counted and penalties enforced per instance", true)
+		}
+		if cfg.Chargeback.Enabled && !cfg.Chargeback.UseRedis {
+			add("CHARGEBACK_USE_REDIS", "each instance reports only the usage it served", true)
+		}
+		if cfg.Metering.Enabled && !cfg.Metering.UseRedis {
+			add("METERING_USE_REDIS", "a new leader reports usage again, relying on the provider's idempotency", true)
+		}
+	}
+
+	if federation := cfg.Federation; federation.Enabled {
+		if !regionPattern.MatchString(federation.Region) {
+			add("FEDERATION_REGION", "is required and must be lowercase letters, digits, _, . and -", false)
+		}
+		if federation.Prefix == "" {
+			add("FEDERATION_PREFIX", "must not be empty", false)
+		}
+		for _, resource := range federation.Resources {
+			switch resource {
+			case "api_keys":
+			case "exemptions":
+				if !cfg.RateLimit.Enabled {
+					add("FEDERATION_RESOURCES", "exemptions are not replicated while rate limiting is disabled", true)
+				}
+			case "penalties":
+				if !cfg.PenaltyBox.Enabled {
+					add("FEDERATION_RESOURCES", "penalties are not replicated while the penalty box is disabled", true)
+				}
+			default:
+				add("FEDERATION_RESOURCES", fmt.Sprintf("unknown resource %q; must be api_keys, exemptions or penalties", resource), false)
+			}
+		}
+		if federation.Interval <= 0 {
+			add("FEDERATION_INTERVAL", "must be positive", false)
+		}
+		if federation.HeartbeatInterval < federation.Interval {
+			add("FEDERATION_HEARTBEAT_INTERVAL", "must not be shorter than FEDERATION_INTERVAL", false)
+		}
+		if !cfg.Cluster.Enabled {
+			add("FEDERATION_ENABLED", "without CLUSTER_ENABLED every replica syncs on its own; run one replica per region", true)
+		}
+		if federation.MaxLen < 1000 {
+			add("FEDERATION_MAX_LEN", "must be at least 1000, or regions catching up after an outage miss changes", false)
+		}
+	}
+
+	for _, upstream := range cfg.Proxy.Upstreams {
+		prefix := "UPSTREAM_" + strings.ToUpper(strings.ReplaceAll(upstream.Name, "-", "_")) + "_"
+		if u, err := url.Parse(upstream.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			add(prefix+"URL", "must be an absolute URL", false)
+		}
+		if !strings.HasPrefix(upstream.PathPrefix, "/") {
+			add(prefix+"PATH_PREFIX", "must start with /", false)
+		}
+		for _, backend := range upstream.Backends {
+			if u, err := url.Parse(backend); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+				add(prefix+"BACKENDS", fmt.Sprintf("backend %q must be an http(s) URL", backend), false)
+			}
+		}
+		if len(upstream.Backends) > 0 {
+			if !oneOf(upstream.Balance.Strategy, "round_robin", "consistent_hash") {
+				add(prefix+"BALANCE_STRATEGY", "must be round_robin or consistent_hash", false)
+			}
+			if kind, name, _ := strings.Cut(upstream.Balance.HashKey, ":"); !(upstream.Balance.HashKey == "ip" || (oneOf(kind, "cookie", "header") && name != "")) {
+				add(prefix+"BALANCE_HASH_KEY", "must be ip, cookie:<name> or header:<name>", false)
+			}
+			if factor := upstream.Balance.LoadFactor; factor != 0 && factor < 1 {
+				add(prefix+"BALANCE_LOAD_FACTOR", "must be at least 1, or 0 to disable bounded loads", false)
+			}
+			if upstream.Balance.FailureCooldown < 0 {
+				add(prefix+"BALANCE_FAILURE_COOLDOWN", "must not be negative", false)
+			}
+			if upstream.Balance.SlowStart < 0 {
+				add(prefix+"BALANCE_SLOW_START", "must not be negative", false)
+			}
+			if upstream.Balance.ZoneOverprovision < 1 {
+				add(prefix+"BALANCE_ZONE_OVERPROVISION", "must be at least 1", false)
+			}
+			if upstream.URL != upstream.Backends[0] {
+				add(prefix+"URL", "differs from the first backend; only BACKENDS receive traffic", true)
+			}
+		}
+		if zones := upstream.BackendZones; len(zones) > 0 {
+			if len(zones) != len(upstream.Backends) {
+				add(prefix+"BACKEND_ZONES", fmt.Sprintf("lists %d zones for %d BACKENDS", len(zones), len(upstream.Backends)), false)
+			}
+			for _, zone := range zones {
+				if strings.HasPrefix(zone, "/") || strings.HasSuffix(zone, "/") || strings.Count(zone, "/") > 1 {
+					add(prefix+"BACKEND_ZONES", fmt.Sprintf("%q must be region/zone or zone", zone), false)
+				}
+			}
+			if cfg.Proxy.Region == "" && cfg.Proxy.Zone == "" {
+				add(prefix+"BACKEND_ZONES", "has no effect without GATEWAY_ZONE or GATEWAY_REGION", true)
+			}
+		}
+		if outliers := upstream.Balance.Outliers; outliers.Enabled {
+			if len(upstream.Backends) == 0 {
+				add(prefix+"OUTLIER_ENABLED", "has no effect without BACKENDS", true)
+			}
+			if outliers.Interval <= 0 {
+				add(prefix+"OUTLIER_INTERVAL", "must be positive", false)
+			}
+			if outliers.MinRequests < 1 {
+				add(prefix+"OUTLIER_MIN_REQUESTS", "must be at least 1", false)
+			}
+			if outliers.ErrorRate < 0 || outliers.ErrorRate > 1 {
+				add(prefix+"OUTLIER_ERROR_RATE", "must be between 0 and 1", false)
+			}
+			if outliers.Latency < 0 {
+				add(prefix+"OUTLIER_LATENCY", "must not be negative", false)
+			}
+			if outliers.ErrorRate == 0 && outliers.Latency == 0 {
+				add(prefix+"OUTLIER_ERROR_RATE", "neither ERROR_RATE nor LATENCY is set, so no backend is ejected", true)
+			}
+			if outliers.EjectionTime <= 0 {
+				add(prefix+"OUTLIER_EJECTION_TIME", "must be positive", false)
+			}
+			if outliers.MaxEjectionTime < outliers.EjectionTime {
+				add(prefix+"OUTLIER_MAX_EJECTION_TIME", "must be at least OUTLIER_EJECTION_TIME", false)
+			}
+			if outliers.MaxEjectionPercent < 0 || outliers.MaxEjectionPercent > 100 {
+				add(prefix+"OUTLIER_MAX_EJECTION_PERCENT", "must be between 0 and 100", false)
+			} else if len(upstream.Backends)*outliers.MaxEjectionPercent/100 == 0 {
+				add(prefix+"OUTLIER_MAX_EJECTION_PERCENT", "allows no backend to be ejected with this many backends", true)
+			}
+		}
+		if upstream.Pool.MaxIdleConns < 0 || upstream.Pool.MaxIdleConnsPerHost < 0 || upstream.Pool.MaxConnsPerHost < 0 {
+			add(prefix+"MAX_IDLE_CONNS", "connection limits must not be negative", false)
+		}
+		if upstream.Pool.MaxConnsPerHost > 0 && upstream.Pool.MaxIdleConnsPerHost > upstream.Pool.MaxConnsPerHost {
+			add(prefix+"MAX_IDLE_CONNS_PER_HOST", "exceeds MAX_CONNS_PER_HOST", true)
+		}
+		if !oneOf(upstream.Pool.IPFamily, "dual", "ipv4", "ipv6") {
+			add(prefix+"IP_FAMILY", "must be dual, ipv4 or ipv6", false)
+		}
+		if resolver := upstream.Pool.DNSResolver; resolver != "" {
+			if _, _, err := net.SplitHostPort(resolver); err != nil {
+				add(prefix+"DNS_RESOLVER", "must be host:port", false)
+			}
+		}
+		for _, rule := range upstream.Rewrites {
+			rulePrefix := prefix + "REWRITE_" + strings.ToUpper(strings.ReplaceAll(rule.Name, "-", "_")) + "_"
+			if rule.Match == "" {
+				add(rulePrefix+"MATCH", "is required", false)
+			} else if _, err := regexp.Compile(rule.Match); err != nil {
+				add(rulePrefix+"MATCH", "invalid regular expression: "+err.Error(), false)
+			}
+			if rule.Replace != "" && !strings.HasPrefix(rule.Replace, "/") && !strings.HasPrefix(rule.Replace, "$") {
+				add(rulePrefix+"REPLACE", "must start with / or a capture group", false)
+			}
+		}
+		for _, route := range upstream.ClaimRoutes {
+			routePrefix := prefix + "CLAIM_ROUTE_" + strings.ToUpper(strings.ReplaceAll(route.Name, "-", "_")) + "_"
+			if len(route.Claims) == 0 {
+				add(routePrefix+"CLAIMS", "at least one claim=value condition is required", false)
+			}
+			if route.URL != "" {
+				if u, err := url.Parse(route.URL); err != nil || !oneOf(u.Scheme, "http", "https") || u.Host == "" {
+					add(routePrefix+"URL", "must be an http(s) URL", false)
+				}
+			} else if len(route.Headers) == 0 {
+				add(routePrefix+"URL", "neither URL nor HEADERS is set, so the route has no effect", true)
+			}
+		}
+		if encryption := upstream.Encryption; encryption.Key != "" {
+			if key, err := base64.StdEncoding.DecodeString(encryption.Key); err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+				add(prefix+"ENCRYPTION_KEY", "must be a base64-encoded 16, 24 or 32 byte key", false)
+			}
+			for _, path := range encryption.Paths {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"ENCRYPTION_PATHS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+		} else if len(encryption.Paths) > 0 {
+			add(prefix+"ENCRYPTION_PATHS", "has no effect without ENCRYPTION_KEY", true)
+		}
+		if len(upstream.JWT.Issuers) == 0 {
+			add(prefix+"JWT_ISSUERS", "no issuer is trusted; only API keys are accepted", true)
+		}
+		for _, name := range upstream.JWT.Issuers {
+			if !issuerNames[name] {
+				add(prefix+"JWT_ISSUERS", fmt.Sprintf("issuer %q is not listed in JWT_TRUSTED_ISSUERS", name), false)
+			}
+		}
+		if !oneOf(upstream.Response.Validation, "report", "enforce") {
+			add(prefix+"RESPONSE_VALIDATION", "must be report or enforce", false)
+		}
+		if schema := upstream.Response.Schema; schema != "" {
+			if _, err := os.Stat(schema); err != nil {
+				add(prefix+"RESPONSE_SCHEMA", "file is not readable", false)
+			}
+		} else if upstream.Response.StripHeaders {
+			add(prefix+"RESPONSE_STRIP_HEADERS", "has no effect without RESPONSE_SCHEMA", true)
+		}
+		if !oneOf(strings.ToLower(upstream.Cookies.SameSite), "", "lax", "strict", "none") {
+			add(prefix+"COOKIE_SAMESITE", "must be lax, strict or none", false)
+		}
+		for from, to := range upstream.Cookies.PathRewrites {
+			if !strings.HasPrefix(from, "/") || !strings.HasPrefix(to, "/") {
+				add(prefix+"COOKIE_PATH_REWRITES", fmt.Sprintf("paths in %q=%q must start with /", from, to), false)
+			}
+		}
+		if (upstream.TLS.CertFile == "") != (upstream.TLS.KeyFile == "") {
+			add(prefix+"TLS_CERT_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together", false)
+		}
+		for key, path := range map[string]string{"TLS_CA_FILE": upstream.TLS.CAFile, "TLS_CERT_FILE": upstream.TLS.CertFile, "TLS_KEY_FILE": upstream.TLS.KeyFile} {
+			if _, err := os.Stat(path); path != "" && err != nil {
+				add(prefix+key, "file is not readable", false)
+			}
+		}
+		switch upstream.Auth.Type {
+		case "none":
+		case "api_key":
+			if upstream.Auth.APIKey == "" {
+				add(prefix+"API_KEY", "required for api_key authentication", false)
+			}
+		case "basic":
+			if upstream.Auth.Username == "" {
+				add(prefix+"BASIC_USERNAME", "required for basic authentication", false)
+			}
+		case "oauth2":
+			if u, err := url.Parse(upstream.Auth.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+				add(prefix+"OAUTH2_TOKEN_URL", "must be an absolute URL", false)
+			}
+			if upstream.Auth.ClientID == "" {
+				add(prefix+"OAUTH2_CLIENT_ID", "required for oauth2 authentication", false)
+			}
+		default:
+			add(prefix+"AUTH_TYPE", "must be none, api_key, basic or oauth2", false)
+		}
+		switch upstream.Type {
+		case "http":
+		case "s3":
+			s3 := upstream.S3
+			if s3.Bucket == "" {
+				add(prefix+"S3_BUCKET", "required for s3 upstreams", false)
+			}
+			if s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+				add(prefix+"S3_ACCESS_KEY_ID", "S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for s3 upstreams", false)
+			}
+			if !oneOf(s3.Mode, "proxy", "presign") {
+				add(prefix+"S3_MODE", "must be proxy or presign", false)
+			}
+			if s3.Mode == "presign" && (s3.PresignExpiry < time.Second || s3.PresignExpiry > 7*24*time.Hour) {
+				add(prefix+"S3_PRESIGN_EXPIRY", "must be between 1s and 7 days", false)
+			}
+			for _, method := range s3.Methods {
+				if !oneOf(method, "GET", "HEAD", "PUT", "DELETE") {
+					add(prefix+"S3_METHODS", fmt.Sprintf("method %q must be GET, HEAD, PUT or DELETE", method), false)
+				}
+			}
+			if len(s3.Methods) == 0 {
+				add(prefix+"S3_METHODS", "at least one method is required", false)
+			}
+			if upstream.Auth.Type != "none" {
+				add(prefix+"AUTH_TYPE", "s3 upstreams sign requests with their S3 credentials", false)
+			}
+			if len(upstream.Backends) > 0 {
+				add(prefix+"BACKENDS", "s3 upstreams do not support load balancing", false)
+			}
+			if upstream.Encryption.Key != "" || upstream.Response.Schema != "" {
+				add(prefix+"TYPE", "payload encryption and response validation do not apply to s3 upstreams", false)
+			}
+		case "echo":
+			if upstream.Echo.MaxBodySize < 0 {
+				add(prefix+"ECHO_MAX_BODY_SIZE", "must not be negative", false)
+			}
+			if len(upstream.Backends) > 0 {
+				add(prefix+"BACKENDS", "echo upstreams do not support load balancing", false)
+			}
+			if upstream.Encryption.Key != "" || upstream.Response.Schema != "" {
+				add(prefix+"TYPE", "payload encryption and response validation do not apply to echo upstreams", false)
+			}
+			add(prefix+"TYPE", "echo upstreams reflect requests, including identity claims, to callers; never use this in production", true)
+		case "inprocess":
+			if upstream.Handler == "" {
+				add(prefix+"HANDLER", "required for inprocess upstreams", false)
+			}
+			if len(upstream.Backends) > 0 {
+				add(prefix+"BACKENDS", "inprocess upstreams do not support load balancing", false)
+			}
+			if upstream.TLS.Enabled() {
+				add(prefix+"TLS_CA_FILE", "inprocess upstreams are not reached over the network", false)
+			}
+		case "webhook":
+			webhook := upstream.Webhook
+			if !oneOf(webhook.Provider, "github", "stripe", "standard") {
+				add(prefix+"WEBHOOK_PROVIDER", "must be github, stripe or standard", false)
+			}
+			if len(webhook.Secrets) == 0 {
+				add(prefix+"WEBHOOK_SECRETS", "at least one secret is required for webhook upstreams", false)
+			}
+			if webhook.Provider == "standard" {
+				for _, secret := range webhook.Secrets {
+					if encoded, ok := strings.CutPrefix(secret, "whsec_"); ok {
+						if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+							add(prefix+"WEBHOOK_SECRETS", "whsec_ secrets must be base64 encoded", false)
+						}
+					}
+				}
+			}
+			if webhook.Provider != "github" && webhook.Tolerance <= 0 {
+				add(prefix+"WEBHOOK_TOLERANCE", "must be positive", false)
+			}
+			if webhook.DedupeTTL <= 0 {
+				add(prefix+"WEBHOOK_DEDUPE_TTL", "must be positive", false)
+			}
+			if webhook.DedupeTTL < webhook.Tolerance {
+				add(prefix+"WEBHOOK_DEDUPE_TTL", "is shorter than WEBHOOK_TOLERANCE, so deliveries can be replayed after they are forgotten", true)
+			}
+			if webhook.MaxBodySize <= 0 {
+				add(prefix+"WEBHOOK_MAX_BODY_SIZE", "must be positive", false)
+			}
+		case "broker":
+			broker := upstream.Broker
+			if !oneOf(broker.Type, "kafka", "nats", "sqs") {
+				add(prefix+"BROKER_TYPE", "must be kafka, nats or sqs", false)
+			}
+			if broker.Topic == "" && len(broker.Topics) == 0 {
+				add(prefix+"BROKER_TOPIC", "BROKER_TOPIC or BROKER_TOPICS is required for broker upstreams", false)
+			}
+			for path := range broker.Topics {
+				if !strings.HasPrefix(path, "/") {
+					add(prefix+"BROKER_TOPICS", fmt.Sprintf("path %q must start with /", path), false)
+				}
+			}
+			if broker.MaxBodySize <= 0 {
+				add(prefix+"BROKER_MAX_BODY_SIZE", "must be positive", false)
+			}
+			if broker.KeyHeader != "" && !validHeaderName(broker.KeyHeader) {
+				add(prefix+"BROKER_KEY_HEADER", "is not a valid header name", false)
+			}
+			if u, err := url.Parse(upstream.URL); err == nil {
+				switch broker.Type {
+				case "nats":
+					if !oneOf(u.Scheme, "nats", "tls") {
+						add(prefix+"URL", "must be a nats:// or tls:// URL for nats brokers", false)
+					}
+				case "kafka", "sqs":
+					if !oneOf(u.Scheme, "http", "https") {
+						add(prefix+"URL", "must be the HTTP URL of the Kafka REST Proxy or SQS endpoint", false)
+					}
+				}
+			}
+			if broker.Type == "sqs" && (broker.AccessKeyID == "" || broker.SecretAccessKey == "") {
+				add(prefix+"BROKER_ACCESS_KEY_ID", "BROKER_ACCESS_KEY_ID and BROKER_SECRET_ACCESS_KEY are required for sqs brokers", false)
+			}
+			if broker.JetStream && broker.Type != "nats" {
+				add(prefix+"BROKER_JETSTREAM", "only applies to nats brokers", true)
+			}
+			if len(upstream.Backends) > 0 {
+				add(prefix+"BACKENDS", "broker upstreams do not support load balancing", false)
+			}
+			if upstream.TLS.Enabled() {
+				add(prefix+"TLS_CA_FILE", "broker upstreams connect to their broker themselves", false)
+			}
+			if upstream.Encryption.Key != "" || upstream.Response.Schema != "" {
+				add(prefix+"TYPE", "payload encryption and response validation do not apply to broker upstreams", false)
+			}
+		default:
+			add(prefix+"TYPE", "must be http, s3, echo, inprocess, webhook or broker", false)
+		}
+		if !oneOf(upstream.Async, "off", "prefer", "always") {
+			add(prefix+"ASYNC", "must be off, prefer or always", false)
+		}
+		if upstream.Async != "off" && !cfg.Async.Enabled {
+			add(prefix+"ASYNC", "requests are not queued unless ASYNC_ENABLED is set", true)
+		}
+		if upstream.Socket != "" {
+			if oneOf(upstream.Type, "echo", "inprocess", "broker") {
+				add(prefix+"SOCKET", "only http and s3 upstreams connect over a socket", false)
+			}
+			if !filepath.IsAbs(upstream.Socket) {
+				add(prefix+"SOCKET", "must be an absolute path", false)
+			}
+			if len(upstream.Backends) > 0 {
+				add(prefix+"SOCKET", "a socket replaces the URL's host; it cannot be combined with BACKENDS", false)
+			}
+			if upstream.Pool.IPFamily != "dual" || upstream.Pool.DNSResolver != "" {
+				add(prefix+"SOCKET", "IP_FAMILY and DNS_RESOLVER do not apply to Unix socket upstreams", true)
+			}
+		}
+	}
+
+	if cfg.Chaos.Enabled {
+		add("CHAOS_ENABLED", "fault injection is enabled; never use this in production", true)
+	}
+
+	invalidMu.Lock()
+	report.Issues = append(invalidValues, report.Issues...)
+	invalidMu.Unlock()
+
+	lines := make(map[string]map[string]envLine)
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		_, source, ok := lookupEnv(issue.Key)
+		if !ok {
+			issue.Source = "default"
+			continue
+		}
+		issue.Source = source
+		if source == "environment" {
+			continue
+		}
+		if lines[source] == nil {
+			lines[source] = readEnvLines(source)
+		}
+		if line, ok := lines[source][issue.Key]; ok {
+			issue.Source = fmt.Sprintf("%s:%d", source, line.number)
+			issue.Line = line.text
+		}
+	}
+
+	return report, nil
+}
+
+// envLine is the location of a setting in a configuration file
+type envLine struct {
+	number int
+	text   string
+}
+
+// readEnvLines maps each key in a dotenv file to the line that sets it
+func readEnvLines(path string) map[string]envLine {
+	lines := make(map[string]envLine)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return lines
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "export "))
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, _, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			continue
+		}
+		lines[strings.TrimSpace(key)] = envLine{number: number, text: text}
+	}
+	return lines
+}
+
+// oneOf reports whether value is one of the allowed values
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package config
+
+// WAFConfig represents request inspection (WAF) configuration
+type WAFConfig struct {
+	Enabled            bool              `json:"enabled"`
+	Mode               string            `json:"mode"`        // "block" or "log"
+	RouteModes         map[string]string `json:"route_modes"` // path prefix -> "block", "log" or "off"
+	MaxBodySize        int64             `json:"max_body_size"`
+	MaxJSONDepth       int               `json:"max_json_depth"`
+	BannedContentTypes []string          `json:"banned_content_types"`
+	DisabledRules      []string          `json:"disabled_rules"`
+}
+
+// DefaultWAFConfig returns default WAF configuration
+func DefaultWAFConfig() *WAFConfig {
+	return &WAFConfig{
+		Enabled:            false,
+		Mode:               "block",
+		RouteModes:         map[string]string{},
+		MaxBodySize:        1 << 20, // 1MB
+		MaxJSONDepth:       20,
+		BannedContentTypes: []string{},
+		DisabledRules:      []string{},
+	}
+}
+
+// LoadWAFConfig loads WAF configuration from environment
+func LoadWAFConfig() *WAFConfig {
+	config := DefaultWAFConfig()
+
+	config.Enabled = getEnvBool("WAF_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	config.Mode = getEnvString("WAF_MODE", "block")
+	config.RouteModes = getEnvMap("WAF_ROUTE_MODES")
+	config.MaxBodySize = int64(getEnvInt("WAF_MAX_BODY_SIZE", 1<<20))
+	config.MaxJSONDepth = getEnvInt("WAF_MAX_JSON_DEPTH", 20)
+	config.BannedContentTypes = getEnvList("WAF_BANNED_CONTENT_TYPES", []string{})
+	config.DisabledRules = getEnvList("WAF_DISABLED_RULES", []string{})
+
+	return config
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// WarmRestartConfig represents saving in-memory state on shutdown and
+// restoring it on startup
+type WarmRestartConfig struct {
+	Enabled  bool          `json:"enabled"`
+	Store    string        `json:"store"` // "file" or "redis"
+	File     string        `json:"file"`
+	RedisKey string        `json:"redis_key"` // Must differ between instances
+	MaxAge   time.Duration `json:"max_age"`   // Older snapshots are ignored
+	Redis    RedisConfig   `json:"redis"`
+}
+
+// DefaultWarmRestartConfig returns default warm restart configuration
+func DefaultWarmRestartConfig() *WarmRestartConfig {
+	return &WarmRestartConfig{
+		Enabled: false,
+		Store:   "file",
+		File:    "gateway-snapshot.json",
+		MaxAge:  10 * time.Minute,
+	}
+}
+
+// LoadWarmRestartConfig loads warm restart configuration from environment.
+// The Redis key defaults to one per hostname.
+func LoadWarmRestartConfig() *WarmRestartConfig {
+	config := DefaultWarmRestartConfig()
+
+	config.Enabled = getEnvBool("WARM_RESTART_ENABLED", false)
+	if !config.Enabled {
+		return config
+	}
+
+	hostname, _ := os.Hostname()
+	config.Store = getEnvString("WARM_RESTART_STORE", config.Store)
+	config.File = getEnvString("WARM_RESTART_FILE", config.File)
+	config.RedisKey = getEnvString("WARM_RESTART_REDIS_KEY", "warmrestart:"+hostname)
+	config.MaxAge = getEnvDuration("WARM_RESTART_MAX_AGE", config.MaxAge)
+	if config.Store == "redis" {
+		config.Redis = LoadRedisConfig()
+	}
+
+	return config
+}
@@ -0,0 +1,16 @@
+package config
+
+// WebhooksConfig represents where webhook upstreams remember the deliveries
+// they have forwarded. The routes themselves are webhook upstreams.
+type WebhooksConfig struct {
+	UseRedis bool        `json:"use_redis"`
+	Redis    RedisConfig `json:"redis"`
+}
+
+// LoadWebhooksConfig loads webhook delivery storage configuration from environment
+func LoadWebhooksConfig() *WebhooksConfig {
+	return &WebhooksConfig{
+		UseRedis: getEnvBool("WEBHOOK_USE_REDIS", getEnvBool("CLUSTER_ENABLED", false)),
+		Redis:    LoadRedisConfig(),
+	}
+}
@@ -0,0 +1,265 @@
+package connlimit
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// Config represents limits applied to connections as they are accepted,
+// before any request on them is parsed
+type Config struct {
+	MaxConns    int     // Concurrent connections; accepting waits while at the limit. 0 is unlimited
+	MaxPerIP    int     // Concurrent connections per client IP; more are closed once accepted. 0 is unlimited
+	AcceptRate  float64 // Connections accepted per second; 0 is unlimited
+	AcceptBurst int     // Connections accepted back to back before the rate applies
+}
+
+// Stats reports the connections held and turned away by a limiter
+type Stats struct {
+	Active     int64            `json:"active"`
+	ClientIPs  int              `json:"client_ips"` // Distinct client IPs with open connections
+	MaxConns   int              `json:"max_conns"`
+	MaxPerIP   int              `json:"max_per_ip"`
+	AcceptRate float64          `json:"accept_rate"`
+	Delayed    map[string]int64 `json:"delayed"`  // Accepts that waited, by reason
+	Rejected   map[string]int64 `json:"rejected"` // Connections closed on accept, by reason
+}
+
+// Delay and rejection reasons
+const (
+	ReasonMaxConns   = "max_conns"
+	ReasonAcceptRate = "accept_rate"
+	ReasonPerIP      = "per_ip"
+)
+
+// Limiter limits the connections accepted by the listeners it wraps
+type Limiter struct {
+	config   *Config
+	slots    chan struct{} // Holds a token per open connection; nil without MaxConns
+	interval time.Duration // Time between accepts at AcceptRate
+
+	mu       sync.Mutex
+	active   int64
+	perIP    map[string]int
+	next     time.Time // Earliest time the next accept is allowed by the rate
+	delayed  map[string]int64
+	rejected map[string]int64
+
+	activeGauge   *metrics.GaugeVec
+	delayedTotal  *metrics.CounterVec
+	rejectedTotal *metrics.CounterVec
+}
+
+// NewLimiter creates a connection limiter
+func NewLimiter(config *Config, reg *metrics.Registry) *Limiter {
+	l := &Limiter{
+		config:   config,
+		perIP:    make(map[string]int),
+		delayed:  make(map[string]int64),
+		rejected: make(map[string]int64),
+		activeGauge: reg.NewGaugeVec("gateway_connections_active",
+			"Client connections currently open."),
+		delayedTotal: reg.NewCounterVec("gateway_connection_accepts_delayed_total",
+			"Connection accepts that waited for a limit, by reason.", "reason"),
+		rejectedTotal: reg.NewCounterVec("gateway_connections_rejected_total",
+			"Connections closed as soon as they were accepted, by reason.", "reason"),
+	}
+	if config.MaxConns > 0 {
+		l.slots = make(chan struct{}, config.MaxConns)
+	}
+	if config.AcceptRate > 0 {
+		l.interval = time.Duration(float64(time.Second) / config.AcceptRate)
+	}
+	return l
+}
+
+// Listen wraps a listener so that its connections count against the limits
+func (l *Limiter) Listen(inner net.Listener) net.Listener {
+	return &listener{Listener: inner, limiter: l, closed: make(chan struct{})}
+}
+
+// Stats returns the current connection counts
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := Stats{
+		Active:     l.active,
+		ClientIPs:  len(l.perIP),
+		MaxConns:   l.config.MaxConns,
+		MaxPerIP:   l.config.MaxPerIP,
+		AcceptRate: l.config.AcceptRate,
+		Delayed:    make(map[string]int64, len(l.delayed)),
+		Rejected:   make(map[string]int64, len(l.rejected)),
+	}
+	for reason, count := range l.delayed {
+		stats.Delayed[reason] = count
+	}
+	for reason, count := range l.rejected {
+		stats.Rejected[reason] = count
+	}
+	return stats
+}
+
+// acceptDelay reserves the next accept under the accept rate and returns how
+// long to wait for it. Up to AcceptBurst accepts are allowed back to back.
+func (l *Limiter) acceptDelay(now time.Time) time.Duration {
+	if l.interval <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := l.config.AcceptBurst
+	if burst < 1 {
+		burst = 1
+	}
+	// Unused capacity accrues up to the burst
+	if earliest := now.Add(-time.Duration(burst-1) * l.interval); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// admit counts a new connection from ip, or reports that ip is at its limit
+func (l *Limiter) admit(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.MaxPerIP > 0 && l.perIP[ip] >= l.config.MaxPerIP {
+		l.rejected[ReasonPerIP]++
+		l.rejectedTotal.Inc(ReasonPerIP)
+		return false
+	}
+	l.perIP[ip]++
+	l.active++
+	l.activeGauge.Set(float64(l.active))
+	return true
+}
+
+// release forgets a closed connection from ip
+func (l *Limiter) release(ip string) {
+	l.mu.Lock()
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+	l.active--
+	l.activeGauge.Set(float64(l.active))
+	l.mu.Unlock()
+
+	l.freeSlot()
+}
+
+// freeSlot returns a connection slot taken while accepting
+func (l *Limiter) freeSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// recordDelay counts an accept that waited for a limit
+func (l *Limiter) recordDelay(reason string) {
+	l.mu.Lock()
+	l.delayed[reason]++
+	l.mu.Unlock()
+	l.delayedTotal.Inc(reason)
+}
+
+// listener applies a limiter to the connections it accepts. Waiting happens
+// before the underlying Accept, so pending connections queue in the kernel's
+// backlog rather than in the gateway.
+type listener struct {
+	net.Listener
+	limiter   *Limiter
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for the accept rate and a free connection slot, then accepts
+// the next connection whose client IP is under its limit
+func (ln *listener) Accept() (net.Conn, error) {
+	l := ln.limiter
+	for {
+		if wait := l.acceptDelay(time.Now()); wait > 0 {
+			l.recordDelay(ReasonAcceptRate)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ln.closed:
+				timer.Stop()
+				return nil, net.ErrClosed
+			}
+		}
+
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				l.recordDelay(ReasonMaxConns)
+				select {
+				case l.slots <- struct{}{}:
+				case <-ln.closed:
+					return nil, net.ErrClosed
+				}
+			}
+		}
+
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			l.freeSlot()
+			return nil, err
+		}
+
+		ip := clientIP(conn.RemoteAddr())
+		if !l.admit(ip) {
+			conn.Close()
+			l.freeSlot()
+			continue
+		}
+		return &limitedConn{Conn: conn, limiter: l, ip: ip}, nil
+	}
+}
+
+// Close stops accepting, including accepts waiting for a limit
+func (ln *listener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.closed) })
+	return ln.Listener.Close()
+}
+
+// limitedConn releases its limits when closed
+type limitedConn struct {
+	net.Conn
+	limiter   *Limiter
+	ip        string
+	closeOnce sync.Once
+}
+
+// Close closes the connection and frees its place under the limits
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.limiter.release(c.ip) })
+	return err
+}
+
+// clientIP returns the IP of a remote address, or the address itself
+func clientIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,160 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-gateway/proxy"
+)
+
+// Config represents CSRF protection configuration
+type Config struct {
+	Paths          []string      `json:"paths"`           // Route prefixes where unsafe requests need a token
+	SessionCookies []string      `json:"session_cookies"` // Cookies that authenticate a request; requests without them are not checked
+	CookieName     string        `json:"cookie_name"`     // Cookie holding the issued token
+	HeaderName     string        `json:"header_name"`     // Header clients echo the token in
+	TTL            time.Duration `json:"ttl"`
+	Secure         bool          `json:"secure"` // Mark the token cookie Secure
+	Key            []byte        `json:"-"`      // HMAC key signing tokens
+}
+
+// Token is an issued CSRF token
+type Token struct {
+	Token     string    `json:"token"`
+	Header    string    `json:"header"` // Header the token must be sent in
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Protector issues CSRF tokens and verifies them on cookie-authenticated
+// requests. Tokens use the double-submit pattern: the token is set as a cookie
+// and must be echoed in a header, which cross-site pages cannot do. Each token
+// is signed and bound to the caller's session cookies, so a token planted by
+// a sibling subdomain or issued for another session is rejected.
+type Protector struct {
+	config *Config
+}
+
+// New creates a CSRF protector
+func New(config *Config) *Protector {
+	return &Protector{config: config}
+}
+
+// Issue creates a token for the caller's session and sets the token cookie
+func (p *Protector) Issue(w http.ResponseWriter, r *http.Request) (*Token, error) {
+	payload := make([]byte, 24)
+	if _, err := rand.Read(payload[:16]); err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(p.config.TTL).Truncate(time.Second)
+	binary.BigEndian.PutUint64(payload[16:], uint64(expiresAt.Unix()))
+
+	value := base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(p.sign(payload, r))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.config.CookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   p.config.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return &Token{Token: value, Header: p.config.HeaderName, ExpiresAt: expiresAt}, nil
+}
+
+// Middleware rejects unsafe requests on protected routes that carry a session
+// cookie but no valid token
+func (p *Protector) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if safeMethod(r.Method) || !p.protected(r.URL.Path) || !p.hasSession(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := r.Header.Get(p.config.HeaderName)
+			if token == "" {
+				http.Error(w, `{"error":"CSRF token missing","details":"`+p.config.HeaderName+` is required for cookie-authenticated requests"}`, http.StatusForbidden)
+				return
+			}
+			if err := p.verify(token, r); err != nil {
+				http.Error(w, `{"error":"Invalid CSRF token","details":"`+err.Error()+`"}`, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// verify checks a submitted token against the token cookie and the session
+func (p *Protector) verify(token string, r *http.Request) error {
+	cookie, err := r.Cookie(p.config.CookieName)
+	if err != nil || !hmac.Equal([]byte(cookie.Value), []byte(token)) {
+		return errors.New("token does not match the token cookie")
+	}
+
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if !ok || err != nil || len(payload) != 24 {
+		return errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, p.sign(payload, r)) {
+		return errors.New("token was not issued for this session")
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(payload[16:])) {
+		return errors.New("token has expired")
+	}
+	return nil
+}
+
+// sign binds a token payload to the values of the caller's session cookies
+func (p *Protector) sign(payload []byte, r *http.Request) []byte {
+	mac := hmac.New(sha256.New, p.config.Key)
+	mac.Write(payload)
+	for _, name := range p.config.SessionCookies {
+		mac.Write([]byte{0})
+		if cookie, err := r.Cookie(name); err == nil {
+			mac.Write([]byte(cookie.Value))
+		}
+	}
+	return mac.Sum(nil)
+}
+
+// protected reports whether a path requires a token
+func (p *Protector) protected(path string) bool {
+	for _, prefix := range p.config.Paths {
+		if proxy.HasPathPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSession reports whether the request is authenticated by a cookie the
+// browser attaches automatically
+func (p *Protector) hasSession(r *http.Request) bool {
+	for _, name := range p.config.SessionCookies {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// safeMethod reports whether a method must not change state
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig() *Config {
+	return &Config{
+		Paths:          []string{"/api/account"},
+		SessionCookies: []string{"session"},
+		CookieName:     "csrf_token",
+		HeaderName:     "X-CSRF-Token",
+		TTL:            time.Hour,
+		Key:            []byte("csrf-test-key-0123456789abcdef"),
+	}
+}
+
+// issue returns a token the protector issued for the session
+func issue(t *testing.T, p *Protector, session string) string {
+	r := httptest.NewRequest(http.MethodGet, "/api/csrf", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: session})
+	token, err := p.Issue(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Token
+}
+
+func TestMiddlewareDenies(t *testing.T) {
+	p := New(testConfig())
+	valid := issue(t, p, "session-1")
+	other := issue(t, p, "session-2")
+	expiring := testConfig()
+	expiring.TTL = -time.Minute
+	expired := issue(t, New(expiring), "session-1")
+	rekeyed := testConfig()
+	rekeyed.Key = []byte("another-csrf-key-0123456789abcd")
+	foreign := issue(t, New(rekeyed), "session-1")
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		session string
+		cookie  string
+		header  string
+		want    int
+		details string
+	}{
+		{name: "valid token", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: valid, header: valid, want: http.StatusOK},
+		{name: "missing token", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: valid, want: http.StatusForbidden, details: "X-CSRF-Token is required"},
+		{name: "missing token cookie", method: http.MethodPost, path: "/api/account/email", session: "session-1", header: valid, want: http.StatusForbidden, details: "token does not match the token cookie"},
+		{name: "header differs from cookie", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: valid, header: other, want: http.StatusForbidden, details: "token does not match the token cookie"},
+		{name: "malformed token", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: "not-a-token", header: "not-a-token", want: http.StatusForbidden, details: "malformed token"},
+		{name: "token of another session", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: other, header: other, want: http.StatusForbidden, details: "token was not issued for this session"},
+		{name: "token signed with another key", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: foreign, header: foreign, want: http.StatusForbidden, details: "token was not issued for this session"},
+		{name: "expired token", method: http.MethodPost, path: "/api/account/email", session: "session-1", cookie: expired, header: expired, want: http.StatusForbidden, details: "token has expired"},
+		{name: "other unsafe method", method: http.MethodDelete, path: "/api/account", session: "session-1", want: http.StatusForbidden},
+		{name: "safe method", method: http.MethodGet, path: "/api/account/email", session: "session-1", want: http.StatusOK},
+		{name: "no session cookie", method: http.MethodPost, path: "/api/account/email", want: http.StatusOK},
+		{name: "route outside the paths", method: http.MethodPost, path: "/api/orders", session: "session-1", want: http.StatusOK},
+		{name: "route sharing the prefix", method: http.MethodPost, path: "/api/accounts", session: "session-1", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := false
+			handler := p.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			}))
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.session != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.session})
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "csrf_token", Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set("X-CSRF-Token", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.want || served != (tt.want == http.StatusOK) {
+				t.Errorf("status %d, served %v, want %d: %s", rec.Code, served, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.details) {
+				t.Errorf("body %s, want details %q", rec.Body.String(), tt.details)
+			}
+		})
+	}
+}
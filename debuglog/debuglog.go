@@ -0,0 +1,244 @@
+package debuglog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+	"api-gateway/redact"
+)
+
+// Rule enables verbose logging for matching requests until it expires.
+// Empty match fields act as wildcards; at least one must be set.
+type Rule struct {
+	ID         string    `json:"id"`
+	PathPrefix string    `json:"path_prefix,omitempty"`
+	APIKey     string    `json:"-"`
+	APIKeyHint string    `json:"api_key,omitempty"` // Masked API key for display
+	UserID     string    `json:"user_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	SampleRate float64   `json:"sample_rate"`
+	Logged     int       `json:"logged"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Config represents debug logging configuration
+type Config struct {
+	MaxBodySize int              `json:"max_body_size"`
+	MaxDuration time.Duration    `json:"max_duration"`
+	Redactor    *redact.Redactor `json:"-"` // Masks secrets before requests are logged
+}
+
+// Logger writes verbose request/response logs for requests matching active rules
+type Logger struct {
+	config *Config
+
+	mu    sync.Mutex
+	rules map[string]*Rule
+}
+
+// NewLogger creates a new debug logger
+func NewLogger(config *Config) *Logger {
+	logger := &Logger{
+		config: config,
+		rules:  make(map[string]*Rule),
+	}
+
+	go logger.cleanupRoutine()
+
+	return logger
+}
+
+// AddRule enables debug logging for the rule's match criteria for the given duration
+func (l *Logger) AddRule(rule *Rule, duration time.Duration) (*Rule, error) {
+	if rule.PathPrefix == "" && rule.APIKey == "" && rule.UserID == "" && rule.IP == "" {
+		return nil, fmt.Errorf("at least one of path_prefix, api_key, user_id or ip is required")
+	}
+	if rule.SampleRate <= 0 || rule.SampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be in (0, 1]")
+	}
+	if duration <= 0 || duration > l.config.MaxDuration {
+		return nil, fmt.Errorf("duration must be between 0 and %s", l.config.MaxDuration)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate id: %w", err)
+	}
+
+	now := time.Now()
+	rule.ID = hex.EncodeToString(b)
+	rule.CreatedAt = now
+	rule.ExpiresAt = now.Add(duration)
+	if rule.APIKey != "" {
+		rule.APIKeyHint = maskKey(rule.APIKey)
+	}
+
+	l.mu.Lock()
+	l.rules[rule.ID] = rule
+	l.mu.Unlock()
+
+	copied := *rule
+	return &copied, nil
+}
+
+// RemoveRule disables a rule before it expires
+func (l *Logger) RemoveRule(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, exists := l.rules[id]; !exists {
+		return fmt.Errorf("debug logging rule not found")
+	}
+	delete(l.rules, id)
+	return nil
+}
+
+// ListRules returns copies of the active rules
+func (l *Logger) ListRules() []*Rule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rules := make([]*Rule, 0, len(l.rules))
+	for _, rule := range l.rules {
+		if now.Before(rule.ExpiresAt) {
+			copied := *rule
+			rules = append(rules, &copied)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+	})
+	return rules
+}
+
+// Middleware returns the HTTP middleware function
+func (l *Logger) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.hasActiveRules() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = auth.WithIdentitySlot(r)
+
+			requestBody := httputil.PeekBody(r, int64(l.config.MaxBodySize))
+
+			start := time.Now()
+			rec := httputil.NewTeeRecorder(w, l.config.MaxBodySize)
+			next.ServeHTTP(rec, r)
+
+			// Match after the handler ran so the authenticated identity is known
+			rule := l.match(r)
+			if rule == "" {
+				return
+			}
+
+			redactor := l.config.Redactor
+			log.Printf("[debug rule=%s] %s %s?%s client=%s status=%d duration=%s\n  request headers: %s\n  request body: %s\n  response headers: %s\n  response body: %s",
+				rule, r.Method, r.URL.Path, redactor.Query(r.URL.RawQuery), httputil.ClientIP(r), rec.StatusCode, time.Since(start),
+				l.formatHeader(r.Header), redactor.Body(requestBody, r.Header.Get("Content-Type")),
+				l.formatHeader(w.Header()), redactor.Body(rec.Body.Bytes(), w.Header().Get("Content-Type")))
+		})
+	}
+}
+
+// hasActiveRules reports whether any rule is active
+func (l *Logger) hasActiveRules() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.rules) > 0
+}
+
+// match returns the ID of a sampled active rule matching the request, or ""
+func (l *Logger) match(r *http.Request) string {
+	ip := httputil.ClientIP(r)
+	apiKey := r.Header.Get("X-API-Key")
+	userID := ""
+	if userCtx := auth.GetResolvedIdentity(r); userCtx != nil {
+		userID = userCtx.UserID
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, rule := range l.rules {
+		if !now.Before(rule.ExpiresAt) {
+			continue
+		}
+		if rule.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+		if rule.APIKey != "" && rule.APIKey != apiKey {
+			continue
+		}
+		if rule.UserID != "" && rule.UserID != userID {
+			continue
+		}
+		if rule.IP != "" && rule.IP != ip {
+			continue
+		}
+		if mathrand.Float64() >= rule.SampleRate {
+			continue
+		}
+		rule.Logged++
+		return rule.ID
+	}
+	return ""
+}
+
+// formatHeader renders headers on one line, masking secrets
+func (l *Logger) formatHeader(header http.Header) string {
+	masked := l.config.Redactor.Header(header)
+	names := make([]string, 0, len(masked))
+	for name := range masked {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+strings.Join(masked[name], ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// cleanupRoutine removes expired rules so logging reverts automatically
+func (l *Logger) cleanupRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		l.mu.Lock()
+		for id, rule := range l.rules {
+			if !now.Before(rule.ExpiresAt) {
+				log.Printf("Debug logging rule %s expired after logging %d requests", id, rule.Logged)
+				delete(l.rules, id)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// maskKey keeps only the start of an API key for display
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:8] + "****"
+}
@@ -0,0 +1,233 @@
+// Package device implements the OAuth 2.0 device authorization grant
+// (RFC 8628), letting CLI tools obtain gateway tokens without embedding secrets.
+//
+// A user approves each authorization in a browser, and the tool acts as that
+// user. Devices holding their own identity, such as IoT clients, are
+// registered with package fleet instead.
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Polling errors, named after the RFC 8628 error codes
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+	// ErrNotFound is returned for unknown or expired codes
+	ErrNotFound = errors.New("device authorization not found")
+	// ErrAlreadyDecided is returned when approving a request that was already approved or denied
+	ErrAlreadyDecided = errors.New("device authorization already decided")
+)
+
+// Authorization statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// userCodeAlphabet avoids vowels and look-alike characters, as RFC 8628
+// section 6.1 recommends
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// Authorization is a pending, approved or denied device authorization request
+type Authorization struct {
+	DeviceCode string    `json:"device_code"`
+	UserCode   string    `json:"user_code"` // Normalized, without the separator
+	ClientID   string    `json:"client_id"`
+	Scope      string    `json:"scope,omitempty"`
+	Status     string    `json:"status"`
+	Subject    *Subject  `json:"subject,omitempty"` // The approving user
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Subject is the user who approved a request; tokens are issued as this user
+type Subject struct {
+	UserID   string   `json:"user_id"`
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles"`
+}
+
+// FormattedUserCode returns the user code as shown to users, e.g. BCDF-GHJK
+func (a *Authorization) FormattedUserCode() string {
+	return a.UserCode[:4] + "-" + a.UserCode[4:]
+}
+
+// Manager runs device authorization requests
+type Manager struct {
+	store    Store
+	lifetime time.Duration
+	interval time.Duration
+}
+
+// NewManager creates a manager whose codes expire after lifetime and are
+// polled at most once per interval
+func NewManager(store Store, lifetime, interval time.Duration) *Manager {
+	return &Manager{
+		store:    store,
+		lifetime: lifetime,
+		interval: interval,
+	}
+}
+
+// Interval returns the minimum time between polls
+func (m *Manager) Interval() time.Duration {
+	return m.interval
+}
+
+// Start creates a pending authorization for a client
+func (m *Manager) Start(ctx context.Context, clientID, scope string) (*Authorization, error) {
+	deviceCode, err := randomDeviceCode()
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := randomUserCode()
+	if err != nil {
+		return nil, err
+	}
+	a := &Authorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   clientID,
+		Scope:      scope,
+		Status:     StatusPending,
+		ExpiresAt:  time.Now().Add(m.lifetime),
+	}
+	if err := m.store.Save(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Lookup returns the pending authorization with a user code
+func (m *Manager) Lookup(ctx context.Context, userCode string) (*Authorization, error) {
+	deviceCode, err := m.store.DeviceCode(ctx, NormalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+	a, err := m.store.Get(ctx, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(a.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	if a.Status != StatusPending {
+		return nil, ErrAlreadyDecided
+	}
+	return a, nil
+}
+
+// Approve lets the client with a user code obtain a token as subject
+func (m *Manager) Approve(ctx context.Context, userCode string, subject *Subject) (*Authorization, error) {
+	return m.decide(ctx, userCode, StatusApproved, subject)
+}
+
+// Deny rejects the client with a user code
+func (m *Manager) Deny(ctx context.Context, userCode string) (*Authorization, error) {
+	return m.decide(ctx, userCode, StatusDenied, nil)
+}
+
+func (m *Manager) decide(ctx context.Context, userCode, status string, subject *Subject) (*Authorization, error) {
+	a, err := m.Lookup(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+	a.Status = status
+	a.Subject = subject
+	if err := m.store.Save(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Poll returns the approved authorization for a device code, or the error
+// to send to the polling client. An approval is returned only once.
+func (m *Manager) Poll(ctx context.Context, deviceCode, clientID string) (*Authorization, error) {
+	a, err := m.store.Get(ctx, deviceCode)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrExpiredToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.ClientID != clientID {
+		return nil, ErrNotFound
+	}
+
+	now := time.Now()
+	if now.After(a.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	switch a.Status {
+	case StatusApproved:
+		// Only the poll that removes the authorization gets the token
+		deleted, err := m.store.Delete(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, ErrExpiredToken
+		}
+		return a, nil
+	case StatusDenied:
+		m.store.Delete(ctx, a)
+		return nil, ErrAccessDenied
+	}
+
+	// Poll times are kept apart from the authorization, so a poll cannot
+	// overwrite an approval made meanwhile
+	last, err := m.store.Polled(ctx, a, now)
+	if err != nil {
+		return nil, err
+	}
+	if now.Sub(last) < m.interval {
+		return nil, ErrSlowDown
+	}
+	return nil, ErrAuthorizationPending
+}
+
+// NormalizeUserCode uppercases a user code and removes separators, so users
+// may type it in any case, with or without the dash
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// randomDeviceCode returns an unguessable device code
+func randomDeviceCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomUserCode returns an eight character user code
+func randomUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < 8; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
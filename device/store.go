@@ -0,0 +1,116 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"api-gateway/storage"
+)
+
+// Store keeps device authorizations until they expire
+type Store interface {
+	// Save creates or updates an authorization
+	Save(ctx context.Context, a *Authorization) error
+	// Get returns the authorization with a device code, or ErrNotFound
+	Get(ctx context.Context, deviceCode string) (*Authorization, error)
+	// DeviceCode returns the device code of a normalized user code, or ErrNotFound
+	DeviceCode(ctx context.Context, userCode string) (string, error)
+	// Delete removes an authorization, reporting whether it still existed
+	Delete(ctx context.Context, a *Authorization) (bool, error)
+	// Polled records a poll of an authorization, returning the time of the previous one
+	Polled(ctx context.Context, a *Authorization, at time.Time) (time.Time, error)
+}
+
+// KVStore keeps authorizations in a key-value store, expiring with them. With
+// a shared store, such as Redis or SQL, clients can poll any replica.
+type KVStore struct {
+	kv storage.KeyValueStore
+}
+
+// NewKVStore creates a new authorization store on a key-value store
+func NewKVStore(kv storage.KeyValueStore) *KVStore {
+	return &KVStore{
+		kv: kv,
+	}
+}
+
+// Save creates or updates an authorization, expiring with it
+func (s *KVStore) Save(ctx context.Context, a *Authorization) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(a.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	// The user code is saved last, so it never refers to a missing device code
+	if err := s.kv.Set(ctx, "device:code:"+a.DeviceCode, data, ttl); err != nil {
+		return fmt.Errorf("failed to save device authorization: %w", err)
+	}
+	if err := s.kv.Set(ctx, "device:user:"+a.UserCode, []byte(a.DeviceCode), ttl); err != nil {
+		return fmt.Errorf("failed to save device authorization: %w", err)
+	}
+	return nil
+}
+
+// Get returns the authorization with a device code
+func (s *KVStore) Get(ctx context.Context, deviceCode string) (*Authorization, error) {
+	data, err := s.kv.Get(ctx, "device:code:"+deviceCode)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device authorization: %w", err)
+	}
+	var a Authorization
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to decode device authorization: %w", err)
+	}
+	return &a, nil
+}
+
+// DeviceCode returns the device code of a user code
+func (s *KVStore) DeviceCode(ctx context.Context, userCode string) (string, error) {
+	deviceCode, err := s.kv.Get(ctx, "device:user:"+userCode)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get device authorization: %w", err)
+	}
+	return string(deviceCode), nil
+}
+
+// Delete removes an authorization. Only one of several concurrent callers
+// sees it removed.
+func (s *KVStore) Delete(ctx context.Context, a *Authorization) (bool, error) {
+	deleted, err := s.kv.Delete(ctx, "device:code:"+a.DeviceCode)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete device authorization: %w", err)
+	}
+	s.kv.Delete(ctx, "device:user:"+a.UserCode)
+	s.kv.Delete(ctx, "device:poll:"+a.DeviceCode)
+	return deleted, nil
+}
+
+// Polled records a poll of an authorization
+func (s *KVStore) Polled(ctx context.Context, a *Authorization, at time.Time) (time.Time, error) {
+	ttl := time.Until(a.ExpiresAt)
+	if ttl <= 0 {
+		return time.Time{}, nil
+	}
+	previous, err := s.kv.Swap(ctx, "device:poll:"+a.DeviceCode, strconv.AppendInt(nil, at.UnixMilli(), 10), ttl)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to record device poll: %w", err)
+	}
+	if previous == nil {
+		return time.Time{}, nil
+	}
+	millis, _ := strconv.ParseInt(string(previous), 10, 64)
+	return time.UnixMilli(millis), nil
+}
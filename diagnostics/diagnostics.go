@@ -0,0 +1,286 @@
+package diagnostics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/ratelimit"
+)
+
+// Check is one synthetic check. Run returns a detail on success.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// skipError marks a check that does not apply
+type skipError struct {
+	reason string
+}
+
+// Error implements error
+func (e *skipError) Error() string { return e.reason }
+
+// Skip returns the error of a check that does not apply
+func Skip(reason string) error {
+	return &skipError{reason: reason}
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // "pass", "fail" or "skip"
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of a diagnostics run
+type Report struct {
+	Status     string    `json:"status"` // "pass" unless a check failed
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"duration_ms"`
+	Checks     []*Result `json:"checks"`
+}
+
+// Runner runs the checks concurrently, each with its own timeout
+type Runner struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// NewRunner creates a diagnostics runner
+func NewRunner(timeout time.Duration, checks ...Check) *Runner {
+	return &Runner{checks: checks, timeout: timeout}
+}
+
+// Run runs every check and reports the results in check order
+func (rn *Runner) Run(ctx context.Context) *Report {
+	start := time.Now()
+	results := make([]*Result, len(rn.checks))
+
+	var wg sync.WaitGroup
+	for i, check := range rn.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = rn.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := &Report{Status: "pass", Time: start, DurationMs: milliseconds(time.Since(start)), Checks: results}
+	for _, result := range results {
+		if result.Status == "fail" {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+// run runs one check, turning panics into failures
+func (rn *Runner) run(ctx context.Context, check Check) (result *Result) {
+	ctx, cancel := context.WithTimeout(ctx, rn.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result = &Result{Name: check.Name}
+	defer func() {
+		if p := recover(); p != nil {
+			result.Status, result.Error = "fail", fmt.Sprintf("panic: %v", p)
+		}
+		result.DurationMs = milliseconds(time.Since(start))
+	}()
+
+	detail, err := check.Run(ctx)
+	var skip *skipError
+	switch {
+	case errors.As(err, &skip):
+		result.Status, result.Detail = "skip", skip.reason
+	case err != nil:
+		result.Status, result.Error = "fail", err.Error()
+	default:
+		result.Status, result.Detail = "pass", detail
+	}
+	return result
+}
+
+// TokenIssuer issues and validates gateway JWTs
+type TokenIssuer interface {
+	GenerateToken(userID, username, email string, roles []string) (string, error)
+	ValidateToken(token string) (*auth.Claims, error)
+}
+
+// diagnosticsUser is the identity synthetic requests are made as
+const diagnosticsUser = "diagnostics"
+
+// issueToken issues a token for the diagnostics user
+func issueToken(issuer TokenIssuer) (string, error) {
+	return issuer.GenerateToken(diagnosticsUser, diagnosticsUser, "", []string{"user"})
+}
+
+// TokenCheck issues a token and validates it again
+func TokenCheck(issuer TokenIssuer) Check {
+	return Check{
+		Name: "token",
+		Run: func(ctx context.Context) (string, error) {
+			token, err := issueToken(issuer)
+			if err != nil {
+				return "", fmt.Errorf("failed to issue token: %w", err)
+			}
+			claims, err := issuer.ValidateToken(token)
+			if err != nil {
+				return "", fmt.Errorf("issued token did not validate: %w", err)
+			}
+			if claims.UserID != diagnosticsUser {
+				return "", fmt.Errorf("issued token names user %q", claims.UserID)
+			}
+			return fmt.Sprintf("issued and validated a token from %s", claims.Issuer), nil
+		},
+	}
+}
+
+// ProxyCheck sends an authenticated GET for path through the gateway's own
+// handler, so the request passes the full middleware chain and reaches the
+// upstream. Any 2xx response passes.
+func ProxyCheck(handler http.Handler, path string, issuer TokenIssuer) Check {
+	return Check{
+		Name: "proxy",
+		Run: func(ctx context.Context) (string, error) {
+			if path == "" {
+				return "", Skip("no upstream route to check")
+			}
+			token, err := issueToken(issuer)
+			if err != nil {
+				return "", fmt.Errorf("failed to issue token: %w", err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+			if err != nil {
+				return "", err
+			}
+			req.RemoteAddr = "127.0.0.1:0"
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("User-Agent", "api-gateway-diagnostics")
+
+			rec := &recorder{header: make(http.Header), status: http.StatusOK}
+			handler.ServeHTTP(rec, req)
+			if rec.status < 200 || rec.status > 299 {
+				return "", fmt.Errorf("GET %s answered %d: %s", path, rec.status, strings.TrimSpace(rec.body.String()))
+			}
+			return fmt.Sprintf("GET %s answered %d", path, rec.status), nil
+		},
+	}
+}
+
+// RateLimiter is the gateway's rate limiter
+type RateLimiter interface {
+	Check(ctx context.Context, key string, tokens int) (*ratelimit.RateLimitResult, error)
+	Reset(ctx context.Context, key string) error
+	Capacity() int
+}
+
+// RateLimitCheck admits one request for a synthetic client and verifies that
+// a request exceeding the rest of its bucket is rejected
+func RateLimitCheck(limiter RateLimiter) Check {
+	return Check{
+		Name: "rate_limit",
+		Run: func(ctx context.Context) (string, error) {
+			if limiter == nil {
+				return "", Skip("rate limiting is disabled")
+			}
+			b := make([]byte, 8)
+			rand.Read(b)
+			key := "diagnostics:" + hex.EncodeToString(b)
+			defer limiter.Reset(context.Background(), key)
+
+			first, err := limiter.Check(ctx, key, 1)
+			if err != nil {
+				return "", fmt.Errorf("limiter unavailable: %w", err)
+			}
+			if !first.Allowed {
+				return "", fmt.Errorf("first request of a new client was rejected")
+			}
+			over, err := limiter.Check(ctx, key, limiter.Capacity())
+			if err != nil {
+				return "", fmt.Errorf("limiter unavailable: %w", err)
+			}
+			if over.Allowed {
+				return "", fmt.Errorf("request exceeding the bucket was admitted")
+			}
+			return fmt.Sprintf("admitted a request and rejected one over capacity %d", limiter.Capacity()), nil
+		},
+	}
+}
+
+// Pinger is a Redis connection
+type Pinger interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// RedisCheck pings the Redis connections, which connections returns by
+// address
+func RedisCheck(connections func() map[string]Pinger) Check {
+	return Check{
+		Name: "redis",
+		Run: func(ctx context.Context) (string, error) {
+			connections := connections()
+			if len(connections) == 0 {
+				return "", Skip("no subsystem uses Redis")
+			}
+			var failed []string
+			for addr, conn := range connections {
+				if err := conn.HealthCheck(ctx); err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %v", addr, err))
+				}
+			}
+			if len(failed) > 0 {
+				sort.Strings(failed)
+				return "", errors.New(strings.Join(failed, "; "))
+			}
+			return fmt.Sprintf("pinged %d connection(s)", len(connections)), nil
+		},
+	}
+}
+
+// recorder keeps the status and the start of the body of a synthetic request
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// Header implements http.ResponseWriter
+func (rec *recorder) Header() http.Header { return rec.header }
+
+// WriteHeader implements http.ResponseWriter
+func (rec *recorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.status = code
+	}
+}
+
+// Write implements http.ResponseWriter
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	if remaining := 512 - rec.body.Len(); remaining > 0 {
+		rec.body.Write(b[:min(len(b), remaining)])
+	}
+	return len(b), nil
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
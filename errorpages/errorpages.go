@@ -0,0 +1,289 @@
+package errorpages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// maxOriginalBody bounds how much of a replaced error body is kept for the
+// Error and Details template variables
+const maxOriginalBody = 64 * 1024
+
+// Data holds the variables available to error templates
+type Data struct {
+	Status     int
+	StatusText string
+	Error      string // "error" field of the original JSON error body
+	Details    string // "details" field of the original JSON error body
+	RequestID  string
+	RetryAfter int // Seconds from the Retry-After header, 0 if absent
+	Method     string
+	Path       string
+	Timestamp  string
+}
+
+// template renders one error response; JSON templates use text/template
+// with a json function for escaping, HTML templates use html/template
+type template interface {
+	Execute(w io.Writer, data any) error
+}
+
+// Set is a directory of templates named <status>.json or <status>.html, with
+// 4xx and 5xx as fallbacks for a status class
+type Set struct {
+	json map[string]template
+	html map[string]template
+}
+
+// Load parses the templates in a directory
+func Load(dir string) (*Set, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error pages: %w", err)
+	}
+
+	set := &Set{json: make(map[string]template), html: make(map[string]template)}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		key := strings.TrimSuffix(name, ext)
+		if entry.IsDir() || !validKey(key) {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error page %s: %w", name, err)
+		}
+
+		switch ext {
+		case ".json":
+			tmpl, err := texttemplate.New(name).Funcs(texttemplate.FuncMap{"json": jsonValue}).Parse(string(content))
+			if err != nil {
+				return nil, fmt.Errorf("invalid error page %s: %w", name, err)
+			}
+			set.json[key] = tmpl
+		case ".html":
+			tmpl, err := htmltemplate.New(name).Parse(string(content))
+			if err != nil {
+				return nil, fmt.Errorf("invalid error page %s: %w", name, err)
+			}
+			set.html[key] = tmpl
+		}
+	}
+
+	return set, nil
+}
+
+// find returns the template for a status, preferring HTML when the client
+// accepts it and JSON otherwise
+func (s *Set) find(status int, accept string) (template, string) {
+	preferHTML := strings.Contains(accept, "text/html")
+	for _, key := range []string{strconv.Itoa(status), fmt.Sprintf("%dxx", status/100)} {
+		jsonTmpl, hasJSON := s.json[key]
+		htmlTmpl, hasHTML := s.html[key]
+		switch {
+		case hasHTML && (preferHTML || !hasJSON):
+			return htmlTmpl, "text/html; charset=utf-8"
+		case hasJSON:
+			return jsonTmpl, "application/json"
+		}
+	}
+	return nil, ""
+}
+
+// Config represents error page configuration
+type Config struct {
+	Default *Set            // Applies to every route; may be nil
+	Routes  map[string]*Set // Path prefix -> templates replacing the default
+}
+
+// Renderer replaces 4xx and 5xx responses with configured templates
+type Renderer struct {
+	config   *Config
+	prefixes []string
+}
+
+// NewRenderer creates an error page renderer
+func NewRenderer(config *Config) *Renderer {
+	prefixes := make([]string, 0, len(config.Routes))
+	for prefix := range config.Routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	return &Renderer{config: config, prefixes: prefixes}
+}
+
+// Middleware returns the HTTP middleware function
+func (er *Renderer) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			set := er.set(r.URL.Path)
+			if set == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ew := &errorWriter{ResponseWriter: w, set: set, accept: r.Header.Get("Accept")}
+			next.ServeHTTP(ew, r)
+			if ew.tmpl != nil {
+				ew.render(r)
+			}
+		})
+	}
+}
+
+// set returns the templates for a path
+func (er *Renderer) set(path string) *Set {
+	for _, prefix := range er.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return er.config.Routes[prefix]
+		}
+	}
+	return er.config.Default
+}
+
+// errorWriter holds back error responses that have a template so they can
+// be rendered once the handler has finished
+type errorWriter struct {
+	http.ResponseWriter
+	set         *Set
+	accept      string
+	wroteHeader bool
+
+	tmpl        template
+	contentType string
+	status      int
+	body        bytes.Buffer
+}
+
+func (ew *errorWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+
+	if code >= 400 {
+		if tmpl, contentType := ew.set.find(code, ew.accept); tmpl != nil {
+			ew.tmpl, ew.contentType, ew.status = tmpl, contentType, code
+			return
+		}
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *errorWriter) Write(data []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.tmpl != nil {
+		if remaining := maxOriginalBody - ew.body.Len(); remaining > 0 {
+			if len(data) > remaining {
+				ew.body.Write(data[:remaining])
+			} else {
+				ew.body.Write(data)
+			}
+		}
+		return len(data), nil
+	}
+	return ew.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher
+func (ew *errorWriter) Flush() {
+	if ew.tmpl != nil {
+		return
+	}
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// render writes the templated error response in place of the original
+func (ew *errorWriter) render(r *http.Request) {
+	header := ew.ResponseWriter.Header()
+
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	retryAfter, _ := strconv.Atoi(header.Get("Retry-After"))
+
+	data := &Data{
+		Status:     ew.status,
+		StatusText: http.StatusText(ew.status),
+		RequestID:  requestID,
+		RetryAfter: retryAfter,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+	}
+	var original struct {
+		Error   string `json:"error"`
+		Details string `json:"details"`
+	}
+	switch {
+	case header.Get("Content-Encoding") != "":
+		// Compressed bodies are not inspected
+	case json.Unmarshal(ew.body.Bytes(), &original) == nil:
+		data.Error, data.Details = original.Error, original.Details
+	default:
+		data.Error = strings.TrimSpace(ew.body.String())
+	}
+
+	var out bytes.Buffer
+	if err := ew.tmpl.Execute(&out, data); err != nil {
+		// Fall back to the original response rather than send a broken page
+		ew.ResponseWriter.WriteHeader(ew.status)
+		ew.ResponseWriter.Write(ew.body.Bytes())
+		return
+	}
+
+	header.Set("Content-Type", ew.contentType)
+	header.Set("Content-Length", strconv.Itoa(out.Len()))
+	header.Del("Content-Encoding")
+	header.Set("X-Request-ID", requestID)
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(out.Bytes())
+}
+
+// jsonValue encodes a value for safe inclusion in a JSON template
+func jsonValue(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// validKey reports whether a template name is a status code or class
+func validKey(key string) bool {
+	if len(key) != 3 || key[0] < '4' || key[0] > '5' {
+		return false
+	}
+	if key[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(key)
+	return err == nil
+}
+
+// newRequestID generates an identifier for correlating an error with logs
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
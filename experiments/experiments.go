@@ -0,0 +1,235 @@
+package experiments
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"api-gateway/metrics"
+)
+
+// Variant is one arm of an experiment
+type Variant struct {
+	Name   string
+	Weight int // Relative share of the experiment's callers
+}
+
+// Experiment splits the callers of some routes between variants
+type Experiment struct {
+	Name     string
+	Paths    []string  // Route prefixes the experiment runs on
+	Percent  float64   // Share of callers entering the experiment, 0-100
+	Variants []Variant // Ordered; reordering variants reassigns callers
+}
+
+// Assign returns the variant for the caller, or "" when the caller is not in
+// the experiment. Callers keep their variant as long as the experiment's name,
+// percentage and variants are unchanged.
+func (e *Experiment) Assign(caller string) string {
+	h := hashString(e.Name + "\x00" + caller)
+	if float64(h%10000) >= e.Percent*100 {
+		return ""
+	}
+
+	total := 0
+	for _, variant := range e.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return ""
+	}
+	// The low digits placed the caller in the percentage; the rest pick the
+	// variant, so the two choices are independent
+	pick := int((h / 10000) % uint64(total))
+	for _, variant := range e.Variants {
+		if pick < variant.Weight {
+			return variant.Name
+		}
+		pick -= variant.Weight
+	}
+	return ""
+}
+
+// runsOn reports whether the experiment runs on path
+func (e *Experiment) runsOn(path string) bool {
+	for _, prefix := range e.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config represents experiment configuration
+type Config struct {
+	Experiments       []*Experiment
+	Header            string // Request header carrying the caller's variants to upstreams
+	MaxTrackedCallers int    // Callers remembered per experiment to count assignments once
+	// Identify returns the caller variants are assigned to
+	Identify func(r *http.Request) string
+}
+
+// Assigner assigns requests to experiment variants and counts assignments and
+// exposures
+type Assigner struct {
+	config *Config
+
+	mu      sync.Mutex
+	tracked map[string]map[uint64]struct{} // Experiment -> callers already counted as assigned
+
+	assignments *metrics.CounterVec
+	exposures   *metrics.CounterVec
+}
+
+// NewAssigner creates an experiment assigner
+func NewAssigner(config *Config, reg *metrics.Registry) *Assigner {
+	a := &Assigner{
+		config:  config,
+		tracked: make(map[string]map[uint64]struct{}, len(config.Experiments)),
+		assignments: reg.NewCounterVec("gateway_experiment_assignments_total",
+			"Callers assigned to experiment variants, counted once per caller by each replica.", "experiment", "variant"),
+		exposures: reg.NewCounterVec("gateway_experiment_exposures_total",
+			"Requests served under experiment variants.", "experiment", "variant"),
+	}
+	for _, experiment := range config.Experiments {
+		a.tracked[experiment.Name] = make(map[uint64]struct{})
+	}
+	return a
+}
+
+// Variant returns the request caller's variant in the named experiment, or ""
+func (a *Assigner) Variant(r *http.Request, name string) string {
+	for _, experiment := range a.config.Experiments {
+		if experiment.Name == name {
+			return experiment.Assign(a.config.Identify(r))
+		}
+	}
+	return ""
+}
+
+// Middleware returns the HTTP middleware function. Requests to an
+// experiment's routes are assigned a variant, passed to upstreams in the
+// configured header as experiment=variant pairs.
+func (a *Assigner) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Upstreams trust the header, so clients cannot pick their variant
+			r.Header.Del(a.config.Header)
+
+			caller := ""
+			var assigned []string
+			for _, experiment := range a.config.Experiments {
+				if !experiment.runsOn(r.URL.Path) {
+					continue
+				}
+				if caller == "" {
+					caller = a.config.Identify(r)
+				}
+				variant := experiment.Assign(caller)
+				if variant == "" {
+					continue
+				}
+				a.record(experiment.Name, variant, caller)
+				assigned = append(assigned, experiment.Name+"="+variant)
+			}
+			if len(assigned) > 0 {
+				r.Header.Set(a.config.Header, strings.Join(assigned, ","))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// record counts an exposure, and an assignment the first time the caller is
+// seen. Once an experiment tracks MaxTrackedCallers callers they are
+// forgotten, so callers may be counted as assigned again.
+func (a *Assigner) record(experiment, variant, caller string) {
+	a.exposures.Inc(experiment, variant)
+
+	key := hashString(caller)
+	a.mu.Lock()
+	tracked := a.tracked[experiment]
+	_, seen := tracked[key]
+	if !seen {
+		if len(tracked) >= a.config.MaxTrackedCallers {
+			tracked = make(map[uint64]struct{})
+			a.tracked[experiment] = tracked
+		}
+		tracked[key] = struct{}{}
+	}
+	a.mu.Unlock()
+
+	if !seen {
+		a.assignments.Inc(experiment, variant)
+	}
+}
+
+// VariantSummary represents the counts of one variant
+type VariantSummary struct {
+	Name        string `json:"name"`
+	Weight      int    `json:"weight"`
+	Assignments int64  `json:"assignments"`
+	Exposures   int64  `json:"exposures"`
+}
+
+// Summary represents the counts of one experiment
+type Summary struct {
+	Name     string            `json:"name"`
+	Paths    []string          `json:"paths"`
+	Percent  float64           `json:"percent"`
+	Variants []*VariantSummary `json:"variants"`
+}
+
+// Summary returns assignment and exposure counts for every experiment since
+// the gateway started
+func (a *Assigner) Summary() []*Summary {
+	assignments := a.assignments.Snapshot()
+	exposures := a.exposures.Snapshot()
+	count := func(snapshot map[string]float64, experiment, variant string) int64 {
+		for key, value := range snapshot {
+			if labels := metrics.SplitKey(key); labels[0] == experiment && labels[1] == variant {
+				return int64(value)
+			}
+		}
+		return 0
+	}
+
+	summaries := make([]*Summary, 0, len(a.config.Experiments))
+	for _, experiment := range a.config.Experiments {
+		summary := &Summary{
+			Name:    experiment.Name,
+			Paths:   experiment.Paths,
+			Percent: experiment.Percent,
+		}
+		for _, variant := range experiment.Variants {
+			summary.Variants = append(summary.Variants, &VariantSummary{
+				Name:        variant.Name,
+				Weight:      variant.Weight,
+				Assignments: count(assignments, experiment.Name, variant.Name),
+				Exposures:   count(exposures, experiment.Name, variant.Name),
+			})
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// hashString hashes s uniformly. FNV-1a alone clusters similar short
+// strings, so its result is mixed with the splitmix64 finalizer.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
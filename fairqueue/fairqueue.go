@@ -0,0 +1,225 @@
+package fairqueue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+var (
+	// ErrQueueFull is returned when a class has no room for another waiting request
+	ErrQueueFull = errors.New("queue full")
+	// ErrTimeout is returned when a request waited longer than the configured maximum
+	ErrTimeout = errors.New("queue wait timed out")
+)
+
+// Class is a priority class sharing the concurrency limit by weight
+type Class struct {
+	Name   string
+	Weight int
+}
+
+// Config represents request queuing configuration
+type Config struct {
+	MaxConcurrent int               // Requests served at once across all classes
+	MaxDepth      int               // Requests waiting per class
+	MaxWait       time.Duration     // Longest time a request may wait for a slot
+	Classes       []Class           // Must include DefaultClass
+	RouteClasses  map[string]string // Path prefix -> class name
+	DefaultClass  string
+}
+
+// class is the queue state of a priority class
+type class struct {
+	Class
+	current int // Smooth weighted round-robin credit
+	waiters []chan struct{}
+}
+
+// Queue admits requests up to a concurrency limit and, once it is reached,
+// hands freed slots to waiting requests by weighted fair queuing across classes
+type Queue struct {
+	config *Config
+
+	mu      sync.Mutex
+	active  int
+	classes map[string]*class
+	order   []*class // Stable iteration order for slot assignment
+
+	depth    *metrics.GaugeVec
+	rejected *metrics.CounterVec
+}
+
+// New creates a request queue
+func New(config *Config, reg *metrics.Registry) *Queue {
+	q := &Queue{
+		config:  config,
+		classes: make(map[string]*class),
+		depth: reg.NewGaugeVec("gateway_queue_depth",
+			"Requests waiting for a concurrency slot, by priority class.", "class"),
+		rejected: reg.NewCounterVec("gateway_queue_rejected_total",
+			"Requests rejected while queued, by priority class and reason.", "class", "reason"),
+	}
+	for _, c := range config.Classes {
+		state := &class{Class: c}
+		q.classes[c.Name] = state
+		q.order = append(q.order, state)
+		q.depth.Set(0, c.Name)
+	}
+	return q
+}
+
+// Middleware returns the HTTP middleware function
+func (q *Queue) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := q.classify(r.URL.Path)
+
+			if err := q.Acquire(r.Context(), name); err != nil {
+				reason := "timeout"
+				if errors.Is(err, ErrQueueFull) {
+					reason = "full"
+				} else if !errors.Is(err, ErrTimeout) {
+					// Client went away while waiting
+					reason = "canceled"
+				}
+				q.rejected.Inc(name, reason)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":"Service busy","details":"No capacity available for this request; retry later"}`))
+				return
+			}
+			defer q.Release()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Acquire waits for a concurrency slot for a request of the given class
+func (q *Queue) Acquire(ctx context.Context, name string) error {
+	q.mu.Lock()
+	c, exists := q.classes[name]
+	if !exists {
+		c = q.classes[q.config.DefaultClass]
+	}
+
+	if q.active < q.config.MaxConcurrent && !q.waiting() {
+		q.active++
+		q.mu.Unlock()
+		return nil
+	}
+	if len(c.waiters) >= q.config.MaxDepth {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	c.waiters = append(c.waiters, ready)
+	q.depth.Set(float64(len(c.waiters)), c.Name)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.config.MaxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, waiter := range c.waiters {
+		if waiter == ready {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			q.depth.Set(float64(len(c.waiters)), c.Name)
+			return err
+		}
+	}
+
+	// The slot was handed over while giving up; pass it on
+	q.releaseLocked()
+	return err
+}
+
+// Release frees a concurrency slot, handing it to the next waiting request
+func (q *Queue) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+// releaseLocked picks the next waiter by smooth weighted round-robin over
+// classes with waiting requests, or frees the slot if nobody is waiting
+func (q *Queue) releaseLocked() {
+	var next *class
+	total := 0
+	for _, c := range q.order {
+		if len(c.waiters) == 0 {
+			continue
+		}
+		c.current += c.Weight
+		total += c.Weight
+		if next == nil || c.current > next.current {
+			next = c
+		}
+	}
+
+	if next == nil {
+		q.active--
+		return
+	}
+
+	next.current -= total
+	ready := next.waiters[0]
+	next.waiters = next.waiters[1:]
+	q.depth.Set(float64(len(next.waiters)), next.Name)
+	close(ready)
+}
+
+// Depth returns the number of requests waiting for a slot across all classes
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depth := 0
+	for _, c := range q.order {
+		depth += len(c.waiters)
+	}
+	return depth
+}
+
+// waiting reports whether any request is queued
+func (q *Queue) waiting() bool {
+	for _, c := range q.order {
+		if len(c.waiters) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the class of the longest route prefix matching the path
+func (q *Queue) classify(path string) string {
+	name := q.config.DefaultClass
+	longest := -1
+	for prefix, routeClass := range q.config.RouteClasses {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+			name = routeClass
+		}
+	}
+	return name
+}
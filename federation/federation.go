@@ -0,0 +1,605 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Event operations
+const (
+	OpPut       = "put"
+	OpDelete    = "delete"
+	OpHeartbeat = "heartbeat" // Sent by idle regions, so their lag stays known
+)
+
+// Resource is replicated gateway state, such as API keys, made of items by ID
+type Resource interface {
+	// Snapshot returns every item, encoded the same way on each call while
+	// the item is unchanged
+	Snapshot(ctx context.Context) (map[string][]byte, error)
+	// Apply stores an item changed in another region
+	Apply(ctx context.Context, id string, value []byte) error
+	// Remove deletes an item deleted in another region
+	Remove(ctx context.Context, id string) error
+}
+
+// Config represents replication configuration
+type Config struct {
+	Region            string        // Unique per gateway cluster
+	Prefix            string        // Redis key namespace shared by every region
+	Interval          time.Duration // How often changes are published and applied
+	HeartbeatInterval time.Duration // How often an idle region reports in
+	MaxLen            int64         // Events kept in the stream
+}
+
+// Version orders changes to an item. Times come from a hybrid logical clock,
+// so a change is always newer than the changes its region has seen; ties
+// between regions are broken by region name.
+type Version struct {
+	Time   int64  `json:"time"` // Unix milliseconds
+	Region string `json:"region"`
+}
+
+// String encodes the version so that newer versions sort after older ones
+func (v Version) String() string {
+	return fmt.Sprintf("%016d/%s", v.Time, v.Region)
+}
+
+// Event is one change replicated between regions
+type Event struct {
+	Region      string          `json:"region"`
+	Kind        string          `json:"kind,omitempty"`
+	ID          string          `json:"id,omitempty"`
+	Op          string          `json:"op"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	Version     Version         `json:"version"`
+	PublishedAt time.Time       `json:"published_at"`
+}
+
+// PeerStatus describes replication from another region as seen by this one
+type PeerStatus struct {
+	Region      string    `json:"region"`
+	LastEventAt time.Time `json:"last_event_at"` // When the peer published its latest event seen here
+	AppliedAt   time.Time `json:"applied_at"`    // When that event was read here
+	Lag         string    `json:"lag"`           // Time between publishing and reading
+	LagSeconds  float64   `json:"lag_seconds"`
+	Stale       bool      `json:"stale"` // Nothing heard for three heartbeat intervals
+	Applied     int64     `json:"applied"`
+	Conflicts   int64     `json:"conflicts"` // Changes dropped for an item with a newer version
+}
+
+// Status describes replication as seen by this region
+type Status struct {
+	Region    string        `json:"region"`
+	Resources []string      `json:"resources"`
+	LastSync  *time.Time    `json:"last_sync,omitempty"`
+	Peers     []*PeerStatus `json:"peers"`
+}
+
+// SyncResult summarizes one sync
+type SyncResult struct {
+	Published int `json:"published"`
+	Applied   int `json:"applied"`
+	Conflicts int `json:"conflicts"`
+}
+
+// publishScript records a change unless the item already has the same or a
+// newer version, then appends it to the stream. It returns nil for changes
+// that lost to a newer one.
+var publishScript = redis.NewScript(`
+	local current = redis.call('HGET', KEYS[2], ARGV[1])
+	if current and current >= ARGV[2] then
+		return false
+	end
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+	redis.call('HSET', KEYS[3], ARGV[1], ARGV[3])
+	return redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[4], '*', 'event', ARGV[3])
+`)
+
+// Replicator publishes changes to this region's resources to a Redis stream
+// shared by every region and applies the changes of other regions. Each
+// item's latest change is also kept in a compacted state hash, so a region
+// joining or falling behind the stream catches up without replaying it.
+// Concurrent changes to an item resolve to the newest version in every
+// region.
+type Replicator struct {
+	client    *redis.Client
+	config    *Config
+	resources map[string]Resource
+
+	mu            sync.Mutex
+	bootstrapped  bool
+	cursor        string            // Latest stream entry read
+	known         map[string]string // Kind/id -> hash of the value last published or applied
+	versions      map[string]Version
+	clock         int64
+	lastSync      time.Time
+	lastHeartbeat time.Time
+	peers         map[string]*PeerStatus
+
+	lag       *metrics.GaugeVec
+	events    *metrics.CounterVec
+	conflicts *metrics.CounterVec
+}
+
+// New creates a replicator. Syncs are started by the caller, either with
+// Start or as a cluster leader job, once every resource is registered.
+func New(client *redis.Client, config *Config, reg *metrics.Registry) *Replicator {
+	return &Replicator{
+		client:    client,
+		config:    config,
+		resources: make(map[string]Resource),
+		peers:     make(map[string]*PeerStatus),
+		lag: reg.NewGaugeVec("gateway_federation_lag_seconds",
+			"Time between another region publishing a change and this region reading it, by region.", "region"),
+		events: reg.NewCounterVec("gateway_federation_events_total",
+			"Replicated changes, by direction (published or applied) and kind.", "direction", "kind"),
+		conflicts: reg.NewCounterVec("gateway_federation_conflicts_total",
+			"Changes dropped because the item had a newer version, by kind.", "kind"),
+	}
+}
+
+// Register adds a resource replicated under kind
+func (f *Replicator) Register(kind string, resource Resource) {
+	f.resources[kind] = resource
+}
+
+// Kinds returns the registered kinds, sorted
+func (f *Replicator) Kinds() []string {
+	kinds := make([]string, 0, len(f.resources))
+	for kind := range f.resources {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Start syncs every interval
+func (f *Replicator) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if _, err := f.Sync(ctx); err != nil {
+				log.Printf("Federation sync failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// Sync publishes local changes made since the previous sync, then applies the
+// changes other regions published meanwhile. Local changes go first, so a
+// change made here is not overwritten by an older one from elsewhere.
+func (f *Replicator) Sync(ctx context.Context) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	// Another replica may have synced for this region while this one was not
+	// leader, so its view is out of date
+	if !f.bootstrapped || now.Sub(f.lastSync) > 3*f.config.Interval {
+		if err := f.bootstrap(ctx); err != nil {
+			return nil, fmt.Errorf("failed to bootstrap: %w", err)
+		}
+	}
+
+	result := &SyncResult{}
+	if err := f.publish(ctx, result); err != nil {
+		return result, err
+	}
+	if err := f.poll(ctx, result); err != nil {
+		return result, err
+	}
+
+	f.lastSync = time.Now()
+	if err := f.client.Set(ctx, f.key("synced:"+f.config.Region), f.lastSync.UnixMilli(), 0).Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Status returns replication from each other region as last recorded by this
+// region, so every replica can report it
+func (f *Replicator) Status(ctx context.Context) (*Status, error) {
+	records, err := f.client.HGetAll(ctx, f.key("peers:"+f.config.Region)).Result()
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{
+		Region:    f.config.Region,
+		Resources: f.Kinds(),
+		Peers:     []*PeerStatus{},
+	}
+	for _, data := range records {
+		var peer PeerStatus
+		if json.Unmarshal([]byte(data), &peer) == nil {
+			peer.Stale = time.Since(peer.LastEventAt) > 3*f.config.HeartbeatInterval
+			status.Peers = append(status.Peers, &peer)
+		}
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].Region < status.Peers[j].Region
+	})
+
+	synced, err := f.client.Get(ctx, f.key("synced:"+f.config.Region)).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err == nil {
+		lastSync := time.UnixMilli(synced)
+		status.LastSync = &lastSync
+	}
+	return status, nil
+}
+
+// bootstrap catches up from the compacted state. Items unchanged here since
+// this region last synced take the state's value; items changed here
+// meanwhile are left for publish to send out. A region syncing for the first
+// time takes the state for every item in it. The caller holds the lock.
+func (f *Replicator) bootstrap(ctx context.Context) error {
+	// Read the stream position first: changes made while reading the state
+	// are read again from the stream, and are then already known
+	cursor := "0-0"
+	last, err := f.client.XRevRangeN(ctx, f.key("events"), "+", "-", 1).Result()
+	if err != nil {
+		return err
+	}
+	if len(last) > 0 {
+		cursor = last[0].ID
+	}
+
+	state, err := f.client.HGetAll(ctx, f.key("state")).Result()
+	if err != nil {
+		return err
+	}
+	known, err := f.client.HGetAll(ctx, f.key("known:"+f.config.Region)).Result()
+	if err != nil {
+		return err
+	}
+	snapshots, err := f.snapshots(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.known = known
+	f.versions = make(map[string]Version, len(state))
+	applied := 0
+	for field, data := range state {
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			log.Printf("Federation: skipping undecodable state of %s: %v", field, err)
+			continue
+		}
+		if _, registered := f.resources[event.Kind]; !registered {
+			continue
+		}
+		f.observe(event.Version)
+
+		current, exists := snapshots[event.Kind][event.ID]
+		local := ""
+		if exists {
+			local = hash(current)
+		}
+		if synced, wasSynced := known[field]; wasSynced && synced != local {
+			continue // Changed here since the last sync, so publish sends it out
+		}
+		if (event.Op == OpDelete && !exists) || (event.Op == OpPut && local == hash(event.Value)) {
+			f.versions[field] = event.Version
+			if err := f.remember(ctx, field, &event); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := f.apply(ctx, &event); err != nil {
+			return err
+		}
+		applied++
+	}
+
+	f.cursor = cursor
+	f.bootstrapped = true
+	if applied > 0 {
+		log.Printf("Federation: region %s applied %d changes from the replicated state", f.config.Region, applied)
+	}
+	return nil
+}
+
+// publish sends out the items added, changed or deleted here since they were
+// last published or applied. The caller holds the lock.
+func (f *Replicator) publish(ctx context.Context, result *SyncResult) error {
+	snapshots, err := f.snapshots(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range f.Kinds() {
+		items := snapshots[kind]
+		ids := make([]string, 0, len(items))
+		for id := range items {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			field := kind + "/" + id
+			if known, exists := f.known[field]; exists && known == hash(items[id]) {
+				continue
+			}
+			if err := f.send(ctx, &Event{Kind: kind, ID: id, Op: OpPut, Value: items[id]}, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	for field, known := range f.known {
+		kind, id, _ := strings.Cut(field, "/")
+		if _, exists := snapshots[kind][id]; exists || known == "" {
+			continue // Present, or already deleted
+		}
+		if _, registered := f.resources[kind]; !registered {
+			continue
+		}
+		if err := f.send(ctx, &Event{Kind: kind, ID: id, Op: OpDelete}, result); err != nil {
+			return err
+		}
+	}
+
+	if time.Since(f.lastHeartbeat) >= f.config.HeartbeatInterval {
+		data, err := json.Marshal(&Event{Region: f.config.Region, Op: OpHeartbeat, PublishedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := f.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: f.key("events"),
+			MaxLen: f.config.MaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"event": data},
+		}).Err(); err != nil {
+			return err
+		}
+		f.lastHeartbeat = time.Now()
+	}
+	return nil
+}
+
+// send publishes one local change with a new version. A change losing to a
+// newer one from another region counts as a conflict; the newer change is
+// applied when the stream is read. The caller holds the lock.
+func (f *Replicator) send(ctx context.Context, event *Event, result *SyncResult) error {
+	event.Region = f.config.Region
+	event.Version = f.tick()
+	event.PublishedAt = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	field := event.Kind + "/" + event.ID
+	keys := []string{f.key("events"), f.key("versions"), f.key("state")}
+	err = publishScript.Run(ctx, f.client, keys, field, event.Version.String(), data, f.config.MaxLen).Err()
+	if err == redis.Nil {
+		result.Conflicts++
+		f.conflicts.Inc(event.Kind)
+		log.Printf("Federation: local change to %s lost to a newer change from another region", field)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %w", field, err)
+	}
+
+	result.Published++
+	f.events.Inc("published", event.Kind)
+	f.versions[field] = event.Version
+	return f.remember(ctx, field, event)
+}
+
+// poll reads and applies the changes other regions published since the
+// previous poll, re-bootstrapping when some were trimmed from the stream
+// unread. The caller holds the lock.
+func (f *Replicator) poll(ctx context.Context, result *SyncResult) error {
+	first, err := f.client.XRangeN(ctx, f.key("events"), "-", "+", 1).Result()
+	if err != nil {
+		return err
+	}
+	if len(first) > 0 && f.cursor != "0-0" && streamIDLess(f.cursor, first[0].ID) {
+		log.Printf("Federation: region %s fell behind the stream, catching up from the replicated state", f.config.Region)
+		if err := f.bootstrap(ctx); err != nil {
+			return err
+		}
+	}
+
+	for {
+		streams, err := f.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{f.key("events"), f.cursor},
+			Count:   500,
+			Block:   -1,
+		}).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		read := 0
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				read++
+				if err := f.receive(ctx, message, result); err != nil {
+					return err
+				}
+				// Only move past changes that were applied, so failures are retried
+				f.cursor = message.ID
+			}
+		}
+		if read < 500 {
+			return nil
+		}
+	}
+}
+
+// receive applies one stream entry published by another region. The caller
+// holds the lock.
+func (f *Replicator) receive(ctx context.Context, message redis.XMessage, result *SyncResult) error {
+	data, _ := message.Values["event"].(string)
+	var event Event
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		log.Printf("Federation: skipping undecodable event %s: %v", message.ID, err)
+		return nil
+	}
+	if event.Region == f.config.Region {
+		return nil
+	}
+	f.observe(event.Version)
+
+	peer := f.peers[event.Region]
+	if peer == nil {
+		peer = &PeerStatus{Region: event.Region}
+		f.peers[event.Region] = peer
+	}
+	now := time.Now()
+	lag := now.Sub(event.PublishedAt)
+	if lag < 0 {
+		lag = 0 // Clocks of regions drift apart
+	}
+	peer.LastEventAt = event.PublishedAt
+	peer.AppliedAt = now
+	peer.Lag = lag.Round(time.Millisecond).String()
+	peer.LagSeconds = lag.Seconds()
+	f.lag.Set(lag.Seconds(), event.Region)
+
+	if event.Op != OpHeartbeat {
+		if _, registered := f.resources[event.Kind]; registered {
+			field := event.Kind + "/" + event.ID
+			current, exists := f.versions[field]
+			switch {
+			case exists && current == event.Version:
+				// Already applied while bootstrapping
+			case exists && current.String() > event.Version.String():
+				result.Conflicts++
+				peer.Conflicts++
+				f.conflicts.Inc(event.Kind)
+			default:
+				if err := f.apply(ctx, &event); err != nil {
+					return err
+				}
+				result.Applied++
+				peer.Applied++
+			}
+		}
+	}
+
+	status, err := json.Marshal(peer)
+	if err != nil {
+		return err
+	}
+	return f.client.HSet(ctx, f.key("peers:"+f.config.Region), event.Region, status).Err()
+}
+
+// apply stores a change from another region. The caller holds the lock.
+func (f *Replicator) apply(ctx context.Context, event *Event) error {
+	resource := f.resources[event.Kind]
+	field := event.Kind + "/" + event.ID
+	var err error
+	switch event.Op {
+	case OpPut:
+		err = resource.Apply(ctx, event.ID, event.Value)
+	case OpDelete:
+		err = resource.Remove(ctx, event.ID)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s from %s: %w", event.Op, field, event.Region, err)
+	}
+
+	f.events.Inc("applied", event.Kind)
+	f.versions[field] = event.Version
+	return f.remember(ctx, field, event)
+}
+
+// remember records the value of an item as synced, so it is not published
+// again until it changes. The caller holds the lock.
+func (f *Replicator) remember(ctx context.Context, field string, event *Event) error {
+	value := ""
+	if event.Op == OpPut {
+		value = hash(event.Value)
+	}
+	f.known[field] = value
+	return f.client.HSet(ctx, f.key("known:"+f.config.Region), field, value).Err()
+}
+
+// snapshots returns the items of every resource by kind
+func (f *Replicator) snapshots(ctx context.Context) (map[string]map[string][]byte, error) {
+	snapshots := make(map[string]map[string][]byte, len(f.resources))
+	for kind, resource := range f.resources {
+		items, err := resource.Snapshot(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", kind, err)
+		}
+		snapshots[kind] = items
+	}
+	return snapshots, nil
+}
+
+// tick returns the version of a new local change. The caller holds the lock.
+func (f *Replicator) tick() Version {
+	now := time.Now().UnixMilli()
+	if now <= f.clock {
+		now = f.clock + 1
+	}
+	f.clock = now
+	return Version{Time: now, Region: f.config.Region}
+}
+
+// observe moves the clock past a version seen from another region, so later
+// local changes are newer. The caller holds the lock.
+func (f *Replicator) observe(version Version) {
+	if version.Time > f.clock {
+		f.clock = version.Time
+	}
+}
+
+// key namespaces federation keys
+func (f *Replicator) key(name string) string {
+	return f.config.Prefix + name
+}
+
+// hash fingerprints an item's value
+func hash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:16])
+}
+
+// streamIDLess reports whether stream entry ID a comes before b
+func streamIDLess(a, b string) bool {
+	aMs, aSeq := splitStreamID(a)
+	bMs, bSeq := splitStreamID(b)
+	if aMs != bMs {
+		return aMs < bMs
+	}
+	return aSeq < bSeq
+}
+
+// splitStreamID splits a stream entry ID into its time and sequence
+func splitStreamID(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	msValue, _ := strconv.ParseUint(ms, 10, 64)
+	seqValue, _ := strconv.ParseUint(seq, 10, 64)
+	return msValue, seqValue
+}
@@ -0,0 +1,121 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/penalty"
+	"api-gateway/ratelimit"
+)
+
+// APIKeys replicates API keys, including soft-deleted ones. Usage counts stay
+// per region, like they stay per replica.
+type APIKeys struct {
+	Store *auth.APIKeyStore
+}
+
+// Snapshot returns every key without its usage
+func (a *APIKeys) Snapshot(ctx context.Context) (map[string][]byte, error) {
+	keys := a.Store.ExportAPIKeys()
+	items := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		key.Requests = 0
+		key.LastUsedAt = time.Time{}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		items[key.Key] = data
+	}
+	return items, nil
+}
+
+// Apply stores a key changed in another region
+func (a *APIKeys) Apply(ctx context.Context, id string, value []byte) error {
+	var key auth.APIKey
+	if err := json.Unmarshal(value, &key); err != nil {
+		return err
+	}
+	return a.Store.ReplicateAPIKey(&key)
+}
+
+// Remove deletes a key purged in another region
+func (a *APIKeys) Remove(ctx context.Context, id string) error {
+	a.Store.PurgeAPIKey(id)
+	return nil
+}
+
+// Exemptions replicates rate limit exemptions added through the admin API.
+// Configured exemptions stay per region.
+type Exemptions struct {
+	Exemptions *ratelimit.Exemptions
+}
+
+// Snapshot returns every exemption added through the admin API
+func (e *Exemptions) Snapshot(ctx context.Context) (map[string][]byte, error) {
+	items := make(map[string][]byte)
+	for _, exemption := range e.Exemptions.List() {
+		if exemption.Source != "api" {
+			continue
+		}
+		data, err := json.Marshal(exemption)
+		if err != nil {
+			return nil, err
+		}
+		items[exemption.ID] = data
+	}
+	return items, nil
+}
+
+// Apply stores an exemption added in another region
+func (e *Exemptions) Apply(ctx context.Context, id string, value []byte) error {
+	var exemption ratelimit.Exemption
+	if err := json.Unmarshal(value, &exemption); err != nil {
+		return err
+	}
+	return e.Exemptions.Replicate(ctx, &exemption)
+}
+
+// Remove deletes an exemption removed in another region
+func (e *Exemptions) Remove(ctx context.Context, id string) error {
+	return e.Exemptions.Forget(ctx, id)
+}
+
+// Penalties replicates penalty box entries, so a client banned in one region
+// is banned everywhere. Strike counts stay per region.
+type Penalties struct {
+	Box *penalty.Box
+}
+
+// Snapshot returns every boxed client
+func (p *Penalties) Snapshot(ctx context.Context) (map[string][]byte, error) {
+	entries, err := p.Box.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		items[entry.Client] = data
+	}
+	return items, nil
+}
+
+// Apply boxes a client boxed in another region
+func (p *Penalties) Apply(ctx context.Context, id string, value []byte) error {
+	var entry penalty.Entry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return err
+	}
+	return p.Box.Replicate(ctx, &entry)
+}
+
+// Remove releases a client released in another region
+func (p *Penalties) Remove(ctx context.Context, id string) error {
+	return p.Box.Forget(ctx, id)
+}
@@ -0,0 +1,323 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+	"api-gateway/proxy"
+)
+
+// Flag turns a feature on for some callers, some of the time
+type Flag struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Enabled     bool       `json:"enabled"`         // Off for everyone when false
+	Percent     float64    `json:"percent"`         // Share of callers the flag is on for, 0-100; each caller keeps their answer
+	Roles       []string   `json:"roles,omitempty"` // Limits the flag to callers holding one of these roles
+	Start       *time.Time `json:"start,omitempty"` // Off before this time
+	End         *time.Time `json:"end,omitempty"`   // Off from this time
+	Source      string     `json:"source"`          // "config", or "override" once changed through the admin API
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+var (
+	// ErrNotFound is returned for flags that do not exist or have no override
+	ErrNotFound = errors.New("flag not found")
+	// ErrInvalid is wrapped by errors for invalid flag settings
+	ErrInvalid = errors.New("invalid flag")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Validate checks the flag's settings
+func (f *Flag) Validate() error {
+	if !namePattern.MatchString(f.Name) {
+		return fmt.Errorf("%w: names must be 1-64 lowercase letters, digits, '-' or '_'", ErrInvalid)
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("%w: percent must be between 0 and 100", ErrInvalid)
+	}
+	if f.Start != nil && f.End != nil && !f.End.After(*f.Start) {
+		return fmt.Errorf("%w: end must be after start", ErrInvalid)
+	}
+	return nil
+}
+
+// On reports whether the flag is on for the caller at now. Callers are
+// placed in the percentage by a hash of their identity and the flag name, so
+// raising the percentage only adds callers.
+func (f *Flag) On(caller string, roles []string, now time.Time) bool {
+	if !f.Enabled {
+		return false
+	}
+	if f.Start != nil && now.Before(*f.Start) || f.End != nil && !now.Before(*f.End) {
+		return false
+	}
+	if len(f.Roles) > 0 && !hasAny(roles, f.Roles) {
+		return false
+	}
+	if f.Percent >= 100 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(f.Name + "\x00" + caller))
+	return float64(h.Sum64()%10000) < f.Percent*100
+}
+
+// Config represents feature flag configuration
+type Config struct {
+	Flags  []*Flag           // Defaults, until changed through the admin API
+	Routes map[string]string // Path prefix -> flag the route is only served with
+	Header string            // Request header listing the caller's flags for upstreams; empty disables
+	// Identify returns the caller flags are evaluated for and their roles
+	Identify func(r *http.Request) (caller string, roles []string)
+}
+
+// Manager evaluates feature flags. Flags come from configuration and can be
+// overridden through the admin API; overrides are kept in a store and
+// reloaded periodically, so changes made by other replicas are picked up.
+type Manager struct {
+	config   *Config
+	store    Store
+	defaults map[string]*Flag
+
+	mu        sync.RWMutex
+	writeMu   sync.Mutex // Serializes changes to overrides
+	overrides map[string]*Flag
+
+	evaluations *metrics.CounterVec
+}
+
+// NewManager loads the overrides from the store and reloads them at the
+// given interval; a zero interval disables reloading
+func NewManager(config *Config, store Store, refresh time.Duration, reg *metrics.Registry) (*Manager, error) {
+	m := &Manager{
+		config:   config,
+		store:    store,
+		defaults: make(map[string]*Flag, len(config.Flags)),
+		evaluations: reg.NewCounterVec("gateway_feature_flag_evaluations_total",
+			"Feature flag evaluations for gated routes and upstream headers, by flag and result (on or off).", "flag", "result"),
+	}
+	for _, flag := range config.Flags {
+		if err := flag.Validate(); err != nil {
+			return nil, fmt.Errorf("flag %s: %w", flag.Name, err)
+		}
+		flag.Source = "config"
+		m.defaults[flag.Name] = flag
+	}
+	if err := m.reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go m.refreshRoutine(refresh)
+	}
+
+	return m, nil
+}
+
+// List returns every flag sorted by name
+func (m *Manager) List() []*Flag {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]*Flag, 0, len(m.defaults)+len(m.overrides))
+	for name, flag := range m.defaults {
+		if _, overridden := m.overrides[name]; !overridden {
+			list = append(list, flag)
+		}
+	}
+	for _, flag := range m.overrides {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get returns a flag
+func (m *Manager) Get(name string) (*Flag, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.get(name)
+}
+
+func (m *Manager) get(name string) (*Flag, bool) {
+	if flag, ok := m.overrides[name]; ok {
+		return flag, true
+	}
+	flag, ok := m.defaults[name]
+	return flag, ok
+}
+
+// Set stores an override for a flag, creating the flag if it is new
+func (m *Manager) Set(ctx context.Context, flag *Flag) error {
+	if err := flag.Validate(); err != nil {
+		return err
+	}
+	flag.Source = "override"
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	if err := m.store.Save(ctx, flag); err != nil {
+		return err
+	}
+	log.Printf("Feature flag %s set by %s: enabled=%t percent=%g roles=%v", flag.Name, flag.UpdatedBy, flag.Enabled, flag.Percent, flag.Roles)
+	return m.reload(ctx)
+}
+
+// Reset removes a flag's override, restoring its configured settings or
+// removing it when it is not configured
+func (m *Manager) Reset(ctx context.Context, name string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.RLock()
+	_, overridden := m.overrides[name]
+	m.mu.RUnlock()
+	if !overridden {
+		return ErrNotFound
+	}
+	if err := m.store.Delete(ctx, name); err != nil {
+		return err
+	}
+	log.Printf("Feature flag %s reset to its configured settings", name)
+	return m.reload(ctx)
+}
+
+// Enabled reports whether the flag is on for the request's caller. Unknown
+// flags are off.
+func (m *Manager) Enabled(r *http.Request, name string) bool {
+	flag, ok := m.Get(name)
+	if !ok {
+		return false
+	}
+	caller, roles := m.config.Identify(r)
+	return flag.On(caller, roles, time.Now())
+}
+
+// EnabledFlags returns the names of the flags on for the request's caller
+func (m *Manager) EnabledFlags(r *http.Request) []string {
+	return m.enabledFlags(r, false)
+}
+
+// enabledFlags evaluates every flag for the request's caller, counting the
+// evaluations when record is set
+func (m *Manager) enabledFlags(r *http.Request, record bool) []string {
+	caller, roles := m.config.Identify(r)
+	now := time.Now()
+
+	var names []string
+	for _, flag := range m.List() {
+		on := flag.On(caller, roles, now)
+		if record {
+			m.record(flag.Name, on)
+		}
+		if on {
+			names = append(names, flag.Name)
+		}
+	}
+	return names
+}
+
+// Middleware returns the HTTP middleware function. Requests to a gated route
+// whose flag is off for the caller get 404, as if the route did not exist.
+func (m *Manager) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name := m.routeFlag(r.URL.Path); name != "" {
+				on := m.Enabled(r, name)
+				m.record(name, on)
+				if !on {
+					http.Error(w, `{"error":"Not found","details":"No route is available for this path"}`, http.StatusNotFound)
+					return
+				}
+			}
+
+			if m.config.Header != "" {
+				// Upstreams trust the header, so clients cannot set it
+				r.Header.Del(m.config.Header)
+				if enabled := m.enabledFlags(r, true); len(enabled) > 0 {
+					r.Header.Set(m.config.Header, strings.Join(enabled, ","))
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeFlag returns the flag gating the longest route prefix matching path, or ""
+func (m *Manager) routeFlag(path string) string {
+	name := ""
+	longest := -1
+	for prefix, flag := range m.config.Routes {
+		if proxy.HasPathPrefix(path, prefix) && len(prefix) > longest {
+			name, longest = flag, len(prefix)
+		}
+	}
+	return name
+}
+
+// record counts an evaluation
+func (m *Manager) record(name string, on bool) {
+	result := "off"
+	if on {
+		result = "on"
+	}
+	m.evaluations.Inc(name, result)
+}
+
+// reload replaces the cached overrides with the stored ones
+func (m *Manager) reload(ctx context.Context) error {
+	overrides, err := m.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	for _, flag := range overrides {
+		flag.Source = "override"
+	}
+
+	m.mu.Lock()
+	m.overrides = overrides
+	m.mu.Unlock()
+	return nil
+}
+
+// refreshRoutine periodically reloads the overrides
+func (m *Manager) refreshRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := m.reload(ctx); err != nil {
+			log.Printf("Failed to reload feature flags: %v", err)
+		}
+		cancel()
+	}
+}
+
+// hasAny reports whether values and wanted share an element
+func hasAny(values, wanted []string) bool {
+	for _, value := range values {
+		for _, want := range wanted {
+			if value == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package flags
+
+import "testing"
+
+func TestRouteFlagOnSegmentBoundaries(t *testing.T) {
+	m := &Manager{config: &Config{Routes: map[string]string{
+		"/api/beta":         "beta",
+		"/api/beta/reports": "beta-reports",
+	}}}
+	for path, want := range map[string]string{
+		"/api/beta":           "beta",
+		"/api/beta/items":     "beta",
+		"/api/beta/reports/1": "beta-reports",
+		"/api/beta/reportsx":  "beta",
+		"/api/betamax":        "",
+	} {
+		if got := m.routeFlag(path); got != want {
+			t.Errorf("routeFlag(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
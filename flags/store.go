@@ -0,0 +1,110 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists flag overrides
+type Store interface {
+	Load(ctx context.Context) (map[string]*Flag, error)
+	Save(ctx context.Context, flag *Flag) error
+	Delete(ctx context.Context, name string) error
+}
+
+// MemoryStore keeps overrides in memory; they are lost on restart
+type MemoryStore struct {
+	mu    sync.Mutex
+	flags map[string]*Flag
+}
+
+// NewMemoryStore creates a new in-memory flag store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		flags: make(map[string]*Flag),
+	}
+}
+
+// Load returns every override
+func (s *MemoryStore) Load(ctx context.Context) (map[string]*Flag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flags := make(map[string]*Flag, len(s.flags))
+	for name, flag := range s.flags {
+		flags[name] = flag
+	}
+	return flags, nil
+}
+
+// Save creates or replaces an override
+func (s *MemoryStore) Save(ctx context.Context, flag *Flag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags[flag.Name] = flag
+	return nil
+}
+
+// Delete removes an override
+func (s *MemoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.flags, name)
+	return nil
+}
+
+// redisFlagsKey is the hash holding every override, keyed by flag name
+const redisFlagsKey = "feature-flags"
+
+// RedisStore keeps overrides in Redis so every replica shares them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed flag store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Load returns every override
+func (s *RedisStore) Load(ctx context.Context) (map[string]*Flag, error) {
+	values, err := s.client.HGetAll(ctx, redisFlagsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	flags := make(map[string]*Flag, len(values))
+	for name, value := range values {
+		var flag Flag
+		if err := json.Unmarshal([]byte(value), &flag); err != nil {
+			return nil, fmt.Errorf("failed to decode feature flag %s: %w", name, err)
+		}
+		flags[name] = &flag
+	}
+	return flags, nil
+}
+
+// Save creates or replaces an override
+func (s *RedisStore) Save(ctx context.Context, flag *Flag) error {
+	data, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, redisFlagsKey, flag.Name, data).Err(); err != nil {
+		return fmt.Errorf("failed to save feature flag: %w", err)
+	}
+	return nil
+}
+
+// Delete removes an override
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	if err := s.client.HDel(ctx, redisFlagsKey, name).Err(); err != nil {
+		return fmt.Errorf("failed to delete feature flag: %w", err)
+	}
+	return nil
+}
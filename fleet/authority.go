@@ -0,0 +1,119 @@
+package fleet
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Authority is a certificate authority issuing client certificates to
+// devices. The gateway trusts it for client certificates presented over TLS.
+type Authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// LoadAuthority loads a CA certificate and its private key from PEM files
+func LoadAuthority(certFile, keyFile string) (*Authority, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse device CA: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("device CA certificate %s is not a CA", certFile)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("device CA key cannot sign")
+	}
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		key:     key,
+	}, nil
+}
+
+// Pool returns the CA as a pool for verifying client certificates
+func (a *Authority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.cert)
+	return pool
+}
+
+// CertificatePEM returns the CA certificate, PEM-encoded
+func (a *Authority) CertificatePEM() []byte {
+	return a.certPEM
+}
+
+// Sign issues a client certificate for a certificate signing request. The
+// certificate names the device as its common name, whatever the request
+// asks for, and never outlives the CA.
+func (a *Authority) Sign(csr *x509.CertificateRequest, deviceID string, validity time.Duration) (*x509.Certificate, []byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: deviceID},
+		NotBefore:    now.Add(-5 * time.Minute), // Tolerate device clock skew
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, csr.PublicKey, a.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign device certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// parseRequest decodes a PEM-encoded certificate signing request and checks
+// that the requester holds its key
+func parseRequest(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: csr must be a PEM-encoded certificate request", ErrInvalidDevice)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDevice, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: csr signature: %v", ErrInvalidDevice, err)
+	}
+	return csr, nil
+}
+
+// describe summarizes an issued certificate
+func describe(cert *x509.Certificate) *Certificate {
+	sum := sha256.Sum256(cert.Raw)
+	return &Certificate{
+		Serial:      cert.SerialNumber.String(),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotAfter:    cert.NotAfter,
+	}
+}
@@ -0,0 +1,662 @@
+// Package fleet keeps a registry of devices, such as IoT clients, each with
+// its own identity. Devices are created by administrators and enroll with a
+// one-time code, receiving an API key or a client certificate scoped to the
+// device; they can be revoked, and rate limits and quotas apply per device.
+//
+// The OAuth device authorization grant, by which people sign in to CLI tools,
+// is unrelated and lives in package device.
+package fleet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/metrics"
+	"api-gateway/ratelimit"
+	"api-gateway/storage"
+)
+
+var (
+	// ErrNotFound is returned for unknown devices
+	ErrNotFound = errors.New("device not found")
+	// ErrExists is returned when creating a device whose ID is taken
+	ErrExists = errors.New("device already exists")
+	// ErrInvalidDevice is returned for devices with invalid settings
+	ErrInvalidDevice = errors.New("invalid device")
+	// ErrInvalidCode is returned for enrollment codes that are unknown,
+	// expired, used or replaced by a newer one
+	ErrInvalidCode = errors.New("invalid or expired enrollment code")
+	// ErrRevoked is returned when enrolling a revoked device
+	ErrRevoked = errors.New("device is revoked")
+)
+
+// Device statuses
+const (
+	StatusPending = "pending" // Created, waiting to enroll
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
+// Credentials devices enroll for
+const (
+	CredentialAPIKey      = "api_key"
+	CredentialCertificate = "certificate"
+)
+
+// UserPrefix starts the user ID of devices, as in "device:thermostat-42"
+const UserPrefix = "device:"
+
+const (
+	deviceKeyPrefix     = "fleet:device:"
+	enrollmentKeyPrefix = "fleet:enrollment:"
+	quotaKeyPrefix      = "fleet:quota:"
+	storeTimeout        = 5 * time.Second
+)
+
+// validID limits device IDs to characters safe in user IDs, certificate
+// names and storage keys
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Device is a registered device
+type Device struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Credential  string            `json:"credential"` // "api_key" or "certificate"
+	Status      string            `json:"status"`     // "pending", "active" or "revoked"
+	Policy      Policy            `json:"policy"`
+	Certificate *Certificate      `json:"certificate,omitempty"` // Issued at the last enrollment
+	CreatedBy   string            `json:"created_by,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	EnrolledAt  *time.Time        `json:"enrolled_at,omitempty"`
+	RevokedAt   *time.Time        `json:"revoked_at,omitempty"`
+}
+
+// Policy limits a device's requests, including the MQTT messages it
+// publishes. Zero values fall back to the registry's defaults.
+type Policy struct {
+	RateCapacity   int   `json:"rate_capacity,omitempty"` // Requests in a burst
+	RateRefillRate int   `json:"rate_refill_rate,omitempty"`
+	Quota          int64 `json:"quota,omitempty"` // Requests per quota window
+}
+
+// Certificate describes a client certificate issued to a device
+type Certificate struct {
+	Serial      string    `json:"serial"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the DER encoding
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// Credentials are issued to a device when it enrolls; they are not stored
+// and cannot be retrieved again
+type Credentials struct {
+	APIKey        string    `json:"api_key,omitempty"`
+	Certificate   string    `json:"certificate,omitempty"`    // PEM
+	CACertificate string    `json:"ca_certificate,omitempty"` // PEM, for verifying the chain
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// record is a device as stored, with the secrets kept out of responses
+type record struct {
+	Device     *Device `json:"device"`
+	APIKey     string  `json:"api_key,omitempty"`    // The current key, revoked when replaced
+	Enrollment string  `json:"enrollment,omitempty"` // Hash of the outstanding enrollment code
+}
+
+// Config represents the registry's settings
+type Config struct {
+	Roles         []string      // Roles of device identities
+	KeyTTL        time.Duration // Lifetime of issued API keys
+	EnrollmentTTL time.Duration // Lifetime of enrollment codes
+	CertValidity  time.Duration // Lifetime of issued certificates
+	Defaults      Policy        // Applies to devices without their own limits
+	QuotaWindow   time.Duration
+}
+
+// Registry manages devices and their credentials
+type Registry struct {
+	config    *Config
+	kv        storage.Store
+	keys      *auth.APIKeyStore
+	authority *Authority // nil when certificates cannot be issued
+
+	buckets sync.Map // Device ID -> *bucket
+
+	enrollments *metrics.CounterVec
+	rejections  *metrics.CounterVec
+}
+
+// bucket is a device's token bucket with the policy it was built for
+type bucket struct {
+	capacity, refillRate int
+	tokens               *ratelimit.TokenBucket
+}
+
+// NewRegistry creates a registry issuing API keys from keys and, with an
+// authority, client certificates
+func NewRegistry(config *Config, kv storage.Store, keys *auth.APIKeyStore, authority *Authority, reg *metrics.Registry) *Registry {
+	return &Registry{
+		config:    config,
+		kv:        kv,
+		keys:      keys,
+		authority: authority,
+		enrollments: reg.NewCounterVec("gateway_device_enrollments_total",
+			"Device enrollments, by credential and result (enrolled, invalid or failed).", "credential", "result"),
+		rejections: reg.NewCounterVec("gateway_device_rejections_total",
+			"Device requests rejected by device policies, by reason (revoked, rate or quota).", "reason"),
+	}
+}
+
+// Authority returns the certificate authority, or nil
+func (r *Registry) Authority() *Authority {
+	return r.authority
+}
+
+// Create registers a device, returning the code it enrolls with
+func (r *Registry) Create(ctx context.Context, device *Device) (string, time.Time, error) {
+	if device.ID == "" {
+		device.ID = "dev_" + randomHex(8)
+	}
+	if !validID.MatchString(device.ID) {
+		return "", time.Time{}, fmt.Errorf("%w: id must be 1 to 128 letters, digits, dots, dashes or underscores", ErrInvalidDevice)
+	}
+	if device.Credential == "" {
+		device.Credential = CredentialAPIKey
+	}
+	if device.Credential != CredentialAPIKey && device.Credential != CredentialCertificate {
+		return "", time.Time{}, fmt.Errorf("%w: credential must be api_key or certificate", ErrInvalidDevice)
+	}
+	if device.Credential == CredentialCertificate && r.authority == nil {
+		return "", time.Time{}, fmt.Errorf("%w: no certificate authority is configured", ErrInvalidDevice)
+	}
+	if err := validatePolicy(device.Policy); err != nil {
+		return "", time.Time{}, err
+	}
+	if device.Name == "" {
+		device.Name = device.ID
+	}
+	device.Status = StatusPending
+	device.CreatedAt = time.Now()
+	device.Certificate, device.EnrolledAt, device.RevokedAt = nil, nil, nil
+
+	code, hash := newCode()
+	rec := &record{Device: device, Enrollment: hash}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	created, err := r.kv.SetNX(ctx, deviceKeyPrefix+device.ID, data, 0)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !created {
+		return "", time.Time{}, ErrExists
+	}
+	expiresAt, err := r.storeCode(ctx, device.ID, hash)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Printf("Audit: device %s created by %s", device.ID, device.CreatedBy)
+	return code, expiresAt, nil
+}
+
+// NewEnrollment issues a new enrollment code for a device, replacing any
+// outstanding one. Enrolling again replaces the device's credentials.
+func (r *Registry) NewEnrollment(ctx context.Context, id string) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	code, hash := newCode()
+	_, err := r.update(ctx, id, func(rec *record) error {
+		if rec.Device.Status == StatusRevoked {
+			return ErrRevoked
+		}
+		rec.Enrollment = hash
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt, err := r.storeCode(ctx, id, hash)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expiresAt, nil
+}
+
+// Enroll redeems an enrollment code, issuing the device's credentials:
+// an API key, or a certificate for the PEM-encoded certificate signing
+// request csr. Credentials issued at an earlier enrollment stop working. A
+// missing or invalid csr is refused before the code is used up.
+func (r *Registry) Enroll(ctx context.Context, code string, csr []byte) (*Device, *Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	hash := hashCode(code)
+	id, err := r.kv.Get(ctx, enrollmentKeyPrefix+hash)
+	if errors.Is(err, storage.ErrNotFound) {
+		r.enrollments.Inc("", "invalid")
+		return nil, nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// A bad request does not use up the code
+	current, err := r.load(ctx, string(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	var request *x509.CertificateRequest
+	if current.Device.Credential == CredentialCertificate {
+		if len(csr) == 0 {
+			r.enrollments.Inc(current.Device.Credential, "invalid")
+			return nil, nil, fmt.Errorf("%w: a certificate signing request is required", ErrInvalidDevice)
+		}
+		if request, err = parseRequest(csr); err != nil {
+			r.enrollments.Inc(current.Device.Credential, "invalid")
+			return nil, nil, err
+		}
+	}
+	// Codes are single use
+	if deleted, err := r.kv.Delete(ctx, enrollmentKeyPrefix+hash); err != nil {
+		return nil, nil, err
+	} else if !deleted {
+		r.enrollments.Inc("", "invalid")
+		return nil, nil, ErrInvalidCode
+	}
+
+	var credentials *Credentials
+	var replacedKey string
+	rec, err := r.update(ctx, string(id), func(rec *record) error {
+		if rec.Device.Status == StatusRevoked {
+			return ErrRevoked
+		}
+		if rec.Enrollment != hash {
+			return ErrInvalidCode
+		}
+		var err error
+		credentials, err = r.issue(rec, request)
+		if err != nil {
+			return err
+		}
+		replacedKey = rec.APIKey
+		rec.APIKey = credentials.APIKey
+		rec.Enrollment = ""
+		now := time.Now()
+		rec.Device.Status = StatusActive
+		rec.Device.EnrolledAt = &now
+		return nil
+	})
+	if err != nil {
+		result := "failed"
+		if errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrRevoked) || errors.Is(err, ErrInvalidDevice) {
+			result = "invalid"
+		}
+		r.enrollments.Inc("", result)
+		if credentials != nil && credentials.APIKey != "" {
+			// The key never reached the device
+			r.keys.RevokeAPIKey(credentials.APIKey)
+		}
+		return nil, nil, err
+	}
+	if replacedKey != "" {
+		if err := r.keys.RevokeAPIKey(replacedKey); err != nil {
+			log.Printf("Failed to revoke the replaced API key of device %s: %v", rec.Device.ID, err)
+		}
+	}
+	r.enrollments.Inc(rec.Device.Credential, "enrolled")
+	log.Printf("Audit: device %s enrolled for a new %s", rec.Device.ID, rec.Device.Credential)
+	return rec.Device, credentials, nil
+}
+
+// issue creates the credentials of an enrolling device
+func (r *Registry) issue(rec *record, csr *x509.CertificateRequest) (*Credentials, error) {
+	device := rec.Device
+	if device.Credential == CredentialCertificate {
+		if r.authority == nil || csr == nil {
+			return nil, fmt.Errorf("%w: no certificate authority is configured", ErrInvalidDevice)
+		}
+		cert, certPEM, err := r.authority.Sign(csr, device.ID, r.config.CertValidity)
+		if err != nil {
+			return nil, err
+		}
+		device.Certificate = describe(cert)
+		return &Credentials{
+			Certificate:   string(certPEM),
+			CACertificate: string(r.authority.CertificatePEM()),
+			ExpiresAt:     cert.NotAfter,
+		}, nil
+	}
+
+	key, err := r.keys.GenerateAPIKey(device.Name, UserPrefix+device.ID, r.config.Roles, 0, "", nil, r.config.KeyTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{APIKey: key.Key, ExpiresAt: key.ExpiresAt}, nil
+}
+
+// Get returns a device
+func (r *Registry) Get(ctx context.Context, id string) (*Device, error) {
+	rec, err := r.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Device, nil
+}
+
+// List returns every device, ordered by ID
+func (r *Registry) List(ctx context.Context) ([]*Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	keys, err := r.kv.Keys(ctx, deviceKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, 0, len(keys))
+	for _, key := range keys {
+		rec, err := r.load(ctx, strings.TrimPrefix(key, deviceKeyPrefix))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, rec.Device)
+	}
+	return devices, nil
+}
+
+// SetPolicy replaces a device's rate limits and quota
+func (r *Registry) SetPolicy(ctx context.Context, id string, policy Policy) (*Device, error) {
+	if err := validatePolicy(policy); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	rec, err := r.update(ctx, id, func(rec *record) error {
+		rec.Device.Policy = policy
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec.Device, nil
+}
+
+// Revoke stops a device's credentials from working. A revoked device cannot
+// enroll again; it has to be deleted and created anew.
+func (r *Registry) Revoke(ctx context.Context, id, revokedBy string) (*Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	var key string
+	rec, err := r.update(ctx, id, func(rec *record) error {
+		key = rec.APIKey
+		now := time.Now()
+		rec.Device.Status = StatusRevoked
+		rec.Device.RevokedAt = &now
+		rec.Enrollment = ""
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		if err := r.keys.RevokeAPIKey(key); err != nil {
+			log.Printf("Failed to revoke the API key of device %s: %v", id, err)
+		}
+	}
+	r.buckets.Delete(id)
+	log.Printf("Audit: device %s revoked by %s", id, revokedBy)
+	return rec.Device, nil
+}
+
+// Delete revokes a device's credentials and removes it
+func (r *Registry) Delete(ctx context.Context, id, deletedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	release, err := storage.Acquire(ctx, r.kv, "fleet:"+id, storeTimeout)
+	if err != nil {
+		return err
+	}
+	defer release(context.Background())
+
+	rec, err := r.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rec.APIKey != "" {
+		if err := r.keys.RevokeAPIKey(rec.APIKey); err != nil {
+			log.Printf("Failed to revoke the API key of device %s: %v", id, err)
+		}
+	}
+	if _, err := r.kv.Delete(ctx, deviceKeyPrefix+id); err != nil {
+		return err
+	}
+	r.buckets.Delete(id)
+	log.Printf("Audit: device %s deleted by %s", id, deletedBy)
+	return nil
+}
+
+// ResolveCertificate implements auth.CertificateResolver. Certificates
+// identify the device named by their common name, as long as the device is
+// active and the certificate is the one issued at its last enrollment. The
+// certificate is matched by fingerprint rather than serial, since another CA
+// trusted for TLS could issue one with the same name and serial.
+func (r *Registry) ResolveCertificate(cert *x509.Certificate) (*auth.UserContext, error) {
+	if r.authority == nil || cert.CheckSignatureFrom(r.authority.cert) != nil {
+		return nil, errors.New("certificate is not issued by the device CA")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	rec, err := r.load(ctx, cert.Subject.CommonName)
+	if err != nil {
+		return nil, err
+	}
+	device := rec.Device
+	if device.Status != StatusActive || device.Certificate == nil || device.Certificate.Fingerprint != describe(cert).Fingerprint {
+		return nil, errors.New("certificate is revoked or replaced")
+	}
+	return &auth.UserContext{
+		UserID:   UserPrefix + device.ID,
+		Username: device.Name,
+		Roles:    append([]string(nil), r.config.Roles...),
+	}, nil
+}
+
+// Middleware enforces device policies on requests authenticated as a device:
+// revoked devices are refused, and requests over the device's rate limit or
+// quota get 429. It must run after authentication.
+func (r *Registry) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			userCtx := auth.GetUserFromContext(req)
+			if userCtx == nil || !strings.HasPrefix(userCtx.UserID, UserPrefix) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			id := strings.TrimPrefix(userCtx.UserID, UserPrefix)
+			rec, err := r.load(req.Context(), id)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				http.Error(w, `{"error":"Failed to check device","details":"Device registry is unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil || rec.Device.Status != StatusActive {
+				r.rejections.Inc("revoked")
+				http.Error(w, `{"error":"Device revoked","details":"The device is not registered or has been revoked"}`, http.StatusForbidden)
+				return
+			}
+
+			policy := r.effectivePolicy(rec.Device.Policy)
+			if policy.RateCapacity > 0 && !r.bucket(id, policy).TryConsume(1) {
+				r.rejections.Inc("rate")
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, `{"error":"Rate limit exceeded","details":"The device is over its rate limit"}`, http.StatusTooManyRequests)
+				return
+			}
+			if policy.Quota > 0 {
+				used, resetIn, err := r.consumeQuota(req.Context(), id)
+				if err != nil {
+					log.Printf("Failed to count the quota of device %s: %v", id, err)
+				} else if used > policy.Quota {
+					r.rejections.Inc("quota")
+					w.Header().Set("Retry-After", strconv.Itoa(int(resetIn.Seconds())+1))
+					http.Error(w, `{"error":"Quota exceeded","details":"The device has used its quota for this period"}`, http.StatusTooManyRequests)
+					return
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// Usage returns the requests a device made in the current quota window
+func (r *Registry) Usage(ctx context.Context, id string) (int64, error) {
+	if r.config.QuotaWindow <= 0 {
+		return 0, nil
+	}
+	window := time.Now().UnixNano() / int64(r.config.QuotaWindow)
+	data, err := r.kv.Get(ctx, quotaKeyPrefix+id+":"+strconv.FormatInt(window, 10))
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// consumeQuota counts a request against a device's quota, returning the
+// requests made in the current window and the time until it ends
+func (r *Registry) consumeQuota(ctx context.Context, id string) (int64, time.Duration, error) {
+	now := time.Now()
+	window := now.UnixNano() / int64(r.config.QuotaWindow)
+	end := time.Unix(0, (window+1)*int64(r.config.QuotaWindow))
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	used, err := r.kv.Incr(ctx, quotaKeyPrefix+id+":"+strconv.FormatInt(window, 10), end.Sub(now)+time.Minute)
+	return used, end.Sub(now), err
+}
+
+// effectivePolicy fills in the defaults of a device's policy
+func (r *Registry) effectivePolicy(policy Policy) Policy {
+	if policy.RateCapacity == 0 {
+		policy.RateCapacity = r.config.Defaults.RateCapacity
+		policy.RateRefillRate = r.config.Defaults.RateRefillRate
+	}
+	if policy.Quota == 0 {
+		policy.Quota = r.config.Defaults.Quota
+	}
+	return policy
+}
+
+// bucket returns a device's token bucket, rebuilt when its policy changed
+func (r *Registry) bucket(id string, policy Policy) *ratelimit.TokenBucket {
+	if value, ok := r.buckets.Load(id); ok {
+		b := value.(*bucket)
+		if b.capacity == policy.RateCapacity && b.refillRate == policy.RateRefillRate {
+			return b.tokens
+		}
+	}
+	b := &bucket{
+		capacity:   policy.RateCapacity,
+		refillRate: policy.RateRefillRate,
+		tokens:     ratelimit.NewTokenBucket(policy.RateCapacity, policy.RateRefillRate),
+	}
+	r.buckets.Store(id, b)
+	return b.tokens
+}
+
+// storeCode saves an enrollment code's hash until it expires
+func (r *Registry) storeCode(ctx context.Context, id, hash string) (time.Time, error) {
+	if err := r.kv.Set(ctx, enrollmentKeyPrefix+hash, []byte(id), r.config.EnrollmentTTL); err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(r.config.EnrollmentTTL), nil
+}
+
+// load reads a device's record
+func (r *Registry) load(ctx context.Context, id string) (*record, error) {
+	data, err := r.kv.Get(ctx, deviceKeyPrefix+id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// update changes a device's record with apply, holding the device's lock so
+// concurrent changes on any replica do not overwrite each other
+func (r *Registry) update(ctx context.Context, id string, apply func(rec *record) error) (*record, error) {
+	release, err := storage.Acquire(ctx, r.kv, "fleet:"+id, storeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release(context.Background())
+
+	rec, err := r.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(rec); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.kv.Set(ctx, deviceKeyPrefix+id, data, 0); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// validatePolicy checks the limits of a policy
+func validatePolicy(policy Policy) error {
+	if policy.RateCapacity < 0 || policy.RateRefillRate < 0 || policy.Quota < 0 {
+		return fmt.Errorf("%w: limits must not be negative", ErrInvalidDevice)
+	}
+	if policy.RateCapacity > 0 && policy.RateRefillRate == 0 {
+		return fmt.Errorf("%w: rate_refill_rate is required with rate_capacity", ErrInvalidDevice)
+	}
+	return nil
+}
+
+// newCode returns a new enrollment code and its hash, which is all that is
+// stored
+func newCode() (string, string) {
+	code := "enr_" + randomHex(16)
+	return code, hashCode(code)
+}
+
+// hashCode hashes an enrollment code
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,288 @@
+package fleet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/metrics"
+	"api-gateway/storage"
+)
+
+// newAuthority creates a CA valid for a year
+func newAuthority(t *testing.T, name string) *Authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Authority{cert: cert, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key: key}
+}
+
+// newCSR returns a PEM-encoded certificate signing request
+func newCSR(t *testing.T, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func parseCertificate(t *testing.T, certPEM string) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatal("no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestRegistry(authority *Authority) *Registry {
+	return NewRegistry(&Config{
+		Roles:         []string{"device"},
+		KeyTTL:        time.Hour,
+		EnrollmentTTL: time.Hour,
+		CertValidity:  30 * 24 * time.Hour,
+	}, storage.NewMemoryStore(), auth.NewAPIKeyStore(0), authority, metrics.NewRegistry())
+}
+
+func TestEnrollmentCodeIsSingleUse(t *testing.T) {
+	r := newTestRegistry(nil)
+	ctx := context.Background()
+	code, _, err := r.Create(ctx, &Device{ID: "thermostat-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, creds, err := r.Enroll(ctx, code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.keys.ValidateAPIKey(creds.APIKey); err != nil {
+		t.Errorf("issued key does not validate: %v", err)
+	}
+	if _, _, err := r.Enroll(ctx, code, nil); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("second redemption: err = %v, want ErrInvalidCode", err)
+	}
+
+	// A replaced code no longer works, and re-enrolling revokes the old key
+	stale, _, err := r.NewEnrollment(ctx, "thermostat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, _, err := r.NewEnrollment(ctx, "thermostat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Enroll(ctx, stale, nil); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replaced code: err = %v, want ErrInvalidCode", err)
+	}
+	if _, _, err := r.Enroll(ctx, fresh, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.keys.ValidateAPIKey(creds.APIKey); err == nil {
+		t.Error("key from the earlier enrollment still validates")
+	}
+}
+
+func TestEnrollmentCodeConcurrentRedemption(t *testing.T) {
+	r := newTestRegistry(nil)
+	ctx := context.Background()
+	code, _, err := r.Create(ctx, &Device{ID: "thermostat-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var keys []string
+	start := make(chan struct{})
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, creds, err := r.Enroll(ctx, code, nil)
+			if err != nil {
+				if !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("err = %v, want ErrInvalidCode", err)
+				}
+				return
+			}
+			mu.Lock()
+			keys = append(keys, creds.APIKey)
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(keys) != 1 {
+		t.Fatalf("code redeemed %d times, want once", len(keys))
+	}
+	if _, err := r.keys.ValidateAPIKey(keys[0]); err != nil {
+		t.Errorf("issued key does not validate: %v", err)
+	}
+}
+
+func TestEnrollCertificate(t *testing.T) {
+	authority := newAuthority(t, "device CA")
+	r := newTestRegistry(authority)
+	ctx := context.Background()
+	code, _, err := r.Create(ctx, &Device{ID: "camera-7", Credential: CredentialCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing CSR is refused without using up the code
+	if _, _, err := r.Enroll(ctx, code, nil); !errors.Is(err, ErrInvalidDevice) {
+		t.Fatalf("no CSR: err = %v, want ErrInvalidDevice", err)
+	}
+	before := time.Now()
+	device, creds, err := r.Enroll(ctx, code, newCSR(t, "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCertificate(t, creds.Certificate)
+
+	if cert.Subject.CommonName != "camera-7" {
+		t.Errorf("common name %q, want the device ID", cert.Subject.CommonName)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("extended key usage %v, want client auth only", cert.ExtKeyUsage)
+	}
+	if cert.IsCA {
+		t.Error("device certificate is a CA")
+	}
+	if validity := cert.NotAfter.Sub(before); validity < 30*24*time.Hour-time.Minute || validity > 30*24*time.Hour+time.Minute {
+		t.Errorf("certificate valid for %v, want 30 days", validity)
+	}
+	if skew := before.Sub(cert.NotBefore); skew < 4*time.Minute || skew > 6*time.Minute {
+		t.Errorf("certificate valid from %v before issue, want 5 minutes", skew)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: authority.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("certificate does not verify for client auth: %v", err)
+	}
+	if !creds.ExpiresAt.Equal(cert.NotAfter) || device.Certificate.Serial != cert.SerialNumber.String() {
+		t.Errorf("credentials %+v do not describe the certificate", device.Certificate)
+	}
+
+	// Certificates never outlive the CA
+	r.config.CertValidity = 10 * 365 * 24 * time.Hour
+	again, _, err := r.NewEnrollment(ctx, "camera-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, creds, err = r.Enroll(ctx, again, newCSR(t, "camera-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notAfter := parseCertificate(t, creds.Certificate).NotAfter; !notAfter.Equal(authority.cert.NotAfter) {
+		t.Errorf("certificate expires %v, after the CA at %v", notAfter, authority.cert.NotAfter)
+	}
+}
+
+func TestResolveCertificate(t *testing.T) {
+	authority := newAuthority(t, "device CA")
+	r := newTestRegistry(authority)
+	ctx := context.Background()
+	enroll := func(id string) *x509.Certificate {
+		t.Helper()
+		code, _, err := r.Create(ctx, &Device{ID: id, Credential: CredentialCertificate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, creds, err := r.Enroll(ctx, code, newCSR(t, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseCertificate(t, creds.Certificate)
+	}
+	active := enroll("camera-1")
+	revoked := enroll("camera-2")
+	if _, err := r.Revoke(ctx, "camera-2", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another CA copies the name and serial of an active certificate
+	foreign := newAuthority(t, "other CA")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: active.SerialNumber,
+		Subject:      active.Subject,
+		NotBefore:    active.NotBefore,
+		NotAfter:     active.NotAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, foreign.cert, key.Public(), foreign.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := r.ResolveCertificate(active)
+	if err != nil {
+		t.Fatalf("active certificate: %v", err)
+	}
+	if user.UserID != "device:camera-1" || len(user.Roles) != 1 || user.Roles[0] != "device" {
+		t.Errorf("resolved %+v", user)
+	}
+	if _, err := r.ResolveCertificate(revoked); err == nil {
+		t.Error("revoked device's certificate resolved")
+	}
+	if _, err := r.ResolveCertificate(forged); err == nil {
+		t.Error("certificate from a foreign CA resolved")
+	}
+
+	// Re-enrolling replaces the certificate
+	code, _, err := r.NewEnrollment(ctx, "camera-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Enroll(ctx, code, newCSR(t, "camera-1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ResolveCertificate(active); err == nil {
+		t.Error("replaced certificate resolved")
+	}
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"api-gateway/admingrpc"
+	"api-gateway/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Version is the gateway build version reported by the gRPC admin API. The
+// gateway binary sets it from its own version.
+var Version = "dev"
+
+// newAdminGRPCServer creates the server of the gRPC admin API, over TLS with
+// the server's certificate when cfg.TLS is set
+func newAdminGRPCServer(cfg *config.AdminGRPCConfig, serverCfg config.ServerConfig, admin *admingrpc.Config) (*admingrpc.Server, error) {
+	var opts []grpc.ServerOption
+	if cfg.TLS {
+		cert, err := tls.LoadX509KeyPair(serverCfg.TLSCertFile, serverCfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	}
+	return admingrpc.NewServer(admin, opts...), nil
+}
+
+// serveAdminGRPC serves the gRPC admin API until the server is shut down. A
+// failing listener is logged rather than fatal, since the REST admin
+// endpoints keep working.
+func serveAdminGRPC(server *admingrpc.Server, cfg *config.AdminGRPCConfig) {
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("gRPC admin listener failed: %v", err)
+		return
+	}
+	log.Printf("Serving the gRPC admin API on %s", addr)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		log.Printf("gRPC admin listener stopped: %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/accesslog"
+	"api-gateway/admingrpc"
+	"api-gateway/app"
+	"api-gateway/auth"
+	"api-gateway/config"
+	"api-gateway/connlimit"
+	"api-gateway/httputil"
+	"api-gateway/mqtt"
+	"api-gateway/storage"
+	"api-gateway/warmrestart"
+
+	"github.com/gorilla/mux"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Gateway is an API gateway built from a configuration. Programs can embed it,
+// adding their own routes and middleware before it starts serving.
+type Gateway struct {
+	cfg        *config.Config
+	components *app.Container
+	warm       *warmrestart.Manager // Set with warm restarts
+	router     *mux.Router
+	chains     *httputil.Chains
+	embedded   *mux.Router // Holds the routes added with RegisterRoute
+	services   *services
+	authHooks  *auth.Hooks // Identity components the gateway's authentication uses
+
+	redis       *redisConnections
+	sqlStore    *storage.SQLStore    // Shared by subsystems with SQL storage
+	memoryStore *storage.MemoryStore // Shared by subsystems without Redis
+
+	sealOnce sync.Once
+	sealed   atomic.Bool
+	handler  http.Handler
+
+	listener net.Listener // Closed first on Stop
+	server   *http.Server
+	h3       *http3.Server
+	fresh    *freshConns
+	done     chan struct{} // Closed once the server stops serving
+	err      error         // Why the server stopped
+}
+
+// services are components that the gateway serves around the router
+type services struct {
+	accessLogger *accesslog.Logger
+	connLimiter  *connlimit.Limiter
+	mqtt         *mqtt.Server
+	admin        *admingrpc.Server
+	clientCAs    *x509.CertPool // Verifies device certificates; nil when none are issued
+}
+
+// New initializes every component enabled in cfg and registers the gateway
+// routes. Components are kept in a container, started when the gateway
+// starts serving and stopped in reverse order after it stops. Nothing is
+// served until Start or Serve is called.
+func New(cfg *config.Config) (*Gateway, error) {
+	g := &Gateway{cfg: cfg, components: app.New()}
+	g.components.Register("config", cfg)
+	if cfg.WarmRestart.Enabled {
+		warm, err := g.newWarmRestart(cfg.WarmRestart)
+		if err != nil {
+			return nil, err
+		}
+		g.warm = warm
+	}
+	if err := g.buildRouter(); err != nil {
+		// Release the connections opened so far
+		g.components.Stop(context.Background())
+		return nil, err
+	}
+	return g, nil
+}
+
+// Components returns the container holding the gateway's components. Each
+// enabled component is registered under the name of its configuration
+// section, such as "jwt", "api_keys", "penalty_box" and "proxy". Embedding
+// programs can look them up and register their own components, which start
+// and stop with the gateway.
+func (g *Gateway) Components() *app.Container {
+	return g.components
+}
+
+// Ready reports the health of the components that can check it, such as
+// Redis and SQL storage connections, by component name
+func (g *Gateway) Ready(ctx context.Context) map[string]error {
+	return g.components.Health(ctx)
+}
+
+// Router returns the gateway's router, for inspecting its routes. Routes
+// should be added with RegisterRoute.
+func (g *Gateway) Router() *mux.Router {
+	return g.router
+}
+
+// RegisterRoute serves path with handler. The route runs the gateway's
+// global middleware, such as rate limiting and metrics, but not
+// authentication; it takes precedence over proxied upstreams. The returned
+// route can be narrowed further, for example by method.
+func (g *Gateway) RegisterRoute(path string, handler http.Handler) *mux.Route {
+	g.checkOpen()
+	return g.embedded.Handle(path, handler)
+}
+
+// Use adds middleware run for every route after the gateway's own global
+// middleware, in the order added
+func (g *Gateway) Use(middlewares ...func(http.Handler) http.Handler) {
+	g.checkOpen()
+	g.chains.Use(g.router, middlewares...)
+}
+
+// checkOpen panics once routes and middleware can no longer change
+func (g *Gateway) checkOpen() {
+	if g.sealed.Load() {
+		panic("gateway: routes and middleware must be added before the gateway serves")
+	}
+}
+
+// Handler returns the handler serving the gateway's routes, for programs
+// that run their own server. Routes and middleware cannot be added afterwards.
+func (g *Gateway) Handler() http.Handler {
+	g.sealOnce.Do(func() {
+		g.sealed.Store(true)
+		g.chains.Wrap(g.router)
+		// Access logs wrap the router so unmatched requests are logged too
+		g.handler = g.router
+		if g.services.accessLogger != nil {
+			g.handler = g.services.accessLogger.Middleware()(g.router)
+		}
+	})
+	return g.handler
+}
+
+// Start listens on the configured address and serves in the background
+func (g *Gateway) Start() error {
+	listener, err := net.Listen("tcp", g.cfg.Server.Host+":"+g.cfg.Server.Port)
+	if err != nil {
+		return err
+	}
+	return g.Serve(listener, nil)
+}
+
+// Serve serves the gateway on listener in the background. A gateway taking
+// over the listener from a previous process passes released, closed once
+// that process has drained: the warm restart snapshot it saves is restored
+// and HTTP/3, whose UDP socket is not handed over, is started only then.
+// Otherwise released is nil.
+func (g *Gateway) Serve(listener net.Listener, released <-chan struct{}) error {
+	if g.server != nil {
+		return errors.New("gateway is already serving")
+	}
+	if err := g.components.Start(context.Background()); err != nil {
+		return err
+	}
+	handler := g.Handler()
+	cfg := g.cfg.Server
+
+	// Connection limits apply before requests are parsed
+	if g.services.connLimiter != nil {
+		listener = g.services.connLimiter.Listen(listener)
+	}
+
+	// HTTP/3 is served over QUIC next to the TCP listener, whose responses
+	// advertise it; QUIC needs TLS
+	if cfg.HTTP3Enabled {
+		if cfg.TLSEnabled() {
+			g.h3 = newHTTP3Server(cfg, handler)
+			handler = advertiseHTTP3(cfg, handler)
+		} else {
+			log.Printf("HTTP/3 requires TLS_CERT_FILE and TLS_KEY_FILE; serving without it")
+		}
+	}
+
+	if released == nil {
+		g.restore()
+	}
+
+	g.listener = listener
+	g.fresh = &freshConns{conns: make(map[net.Conn]bool)}
+	g.server = &http.Server{Addr: listener.Addr().String(), Handler: handler, ConnState: g.fresh.track}
+	// Devices may authenticate with certificates, other callers without one
+	if g.services.clientCAs != nil {
+		g.server.TLSConfig = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: g.services.clientCAs}
+	}
+	g.done = make(chan struct{})
+	go func() {
+		if cfg.TLSEnabled() {
+			g.err = g.server.ServeTLS(listener, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			g.err = g.server.Serve(listener)
+		}
+		close(g.done)
+	}()
+
+	if released == nil {
+		g.serveListeners(cfg)
+		return nil
+	}
+	go func() {
+		<-released
+		g.serveListeners(cfg)
+		g.restore()
+	}()
+	return nil
+}
+
+// serveListeners starts serving HTTP/3, MQTT and the gRPC admin API, whose
+// sockets are not handed over by warm restarts
+func (g *Gateway) serveListeners(cfg config.ServerConfig) {
+	if g.h3 != nil {
+		go serveHTTP3(g.h3, cfg)
+	}
+	if g.services.mqtt != nil {
+		go serveMQTT(g.services.mqtt, g.cfg.MQTT, cfg, g.services.clientCAs, g.Handler())
+	}
+	if g.services.admin != nil {
+		go serveAdminGRPC(g.services.admin, g.cfg.AdminGRPC)
+	}
+}
+
+// Done is closed once the server stops serving, after Stop or because it failed
+func (g *Gateway) Done() <-chan struct{} {
+	return g.done
+}
+
+// Err returns why the server stopped serving, once Done is closed
+func (g *Gateway) Err() error {
+	return g.err
+}
+
+// Stop stops accepting connections, drains in-flight requests until ctx is
+// done, saves the warm restart snapshot and then stops the components, such
+// as access logs and metrics exports, which flush what they buffered. It
+// reports requests that did not finish.
+func (g *Gateway) Stop(ctx context.Context) error {
+	var err error
+	if g.server != nil {
+		// Stop accepting before shutting down, since the server closes
+		// connections it accepts once shutdown has begun
+		g.listener.Close()
+		<-g.done
+		if !errors.Is(g.err, net.ErrClosed) {
+			log.Printf("Server stopped: %v", g.err)
+		}
+
+		g.fresh.wait(ctx)
+		err = g.server.Shutdown(ctx)
+		if g.h3 != nil {
+			if h3Err := g.h3.Shutdown(ctx); h3Err != nil {
+				err = errors.Join(err, fmt.Errorf("HTTP/3: %w", h3Err))
+			}
+		}
+		if g.services.mqtt != nil {
+			if mqttErr := g.services.mqtt.Shutdown(ctx); mqttErr != nil {
+				err = errors.Join(err, fmt.Errorf("MQTT: %w", mqttErr))
+			}
+		}
+		if g.services.admin != nil {
+			if adminErr := g.services.admin.Shutdown(ctx); adminErr != nil {
+				err = errors.Join(err, fmt.Errorf("gRPC admin API: %w", adminErr))
+			}
+		}
+	}
+
+	if g.warm != nil {
+		saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer saveCancel()
+		if saveErr := g.warm.Save(saveCtx); saveErr != nil {
+			log.Printf("Failed to save warm restart snapshot: %v", saveErr)
+		} else {
+			log.Printf("Saved warm restart snapshot")
+		}
+	}
+
+	if stopErr := g.components.Stop(ctx); stopErr != nil {
+		log.Printf("Failed to stop components: %v", stopErr)
+	}
+	return err
+}
+
+// restore restores the warm restart snapshot, if warm restarts are enabled
+func (g *Gateway) restore() {
+	if g.warm == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.warm.Restore(ctx); err != nil {
+		log.Printf("Failed to restore warm restart snapshot: %v", err)
+	}
+}
+
+// newWarmRestart creates the warm restart manager with its snapshot store
+func (g *Gateway) newWarmRestart(cfg *config.WarmRestartConfig) (*warmrestart.Manager, error) {
+	var store warmrestart.Store
+	if cfg.Store == "redis" {
+		redisManager, err := g.connectRedis(cfg.Redis)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize warm restart: %w", err)
+		}
+		store = warmrestart.NewRedisStore(redisManager.GetClient(), cfg.RedisKey, cfg.MaxAge)
+	} else {
+		store = warmrestart.NewFileStore(cfg.File)
+	}
+	return warmrestart.NewManager(store, cfg.MaxAge), nil
+}
+
+// freshConns tracks connections that have not finished their first request.
+// The server drops a connection that reads its first request after shutdown
+// has begun, so shutdown waits for these first.
+type freshConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// track is the server's ConnState hook
+func (f *freshConns) track(conn net.Conn, state http.ConnState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		f.conns[conn] = true
+	case http.StateIdle, http.StateHijacked, http.StateClosed:
+		delete(f.conns, conn)
+	}
+}
+
+// wait blocks until every tracked connection has finished its first request,
+// or ctx is done
+func (f *freshConns) wait(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		f.mu.Lock()
+		pending := len(f.conns)
+		f.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/app"
+	"api-gateway/auth"
+	"api-gateway/cache"
+	"api-gateway/config"
+	"api-gateway/flags"
+	"api-gateway/penalty"
+)
+
+// newTestGateway builds a gateway from the default configuration, with env
+// overriding it
+func newTestGateway(tb testing.TB, env map[string]string) *Gateway {
+	tb.Helper()
+	for name, value := range env {
+		tb.Setenv(name, value)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	g, err := New(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return g
+}
+
+// serve sends a request to handler and returns the recorded response
+func serve(handler http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestNew(t *testing.T) {
+	g := newTestGateway(t, nil)
+
+	names := strings.Join(g.Components().Names(), ",")
+	if !strings.HasPrefix(names, "config,jwt,api_keys") {
+		t.Errorf("components %s, want config, jwt and api_keys first", names)
+	}
+	if _, ok := app.Lookup[*auth.JWTManager](g.Components(), "jwt"); !ok {
+		t.Error("jwt is not a JWT manager")
+	}
+	if w := serve(g.Handler(), "GET", "/health"); w.Code != http.StatusOK {
+		t.Errorf("GET /health: status %d", w.Code)
+	}
+	if w := serve(g.Handler(), "GET", "/api/profile"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/profile without credentials: status %d", w.Code)
+	}
+}
+
+func TestComponents(t *testing.T) {
+	g := newTestGateway(t, map[string]string{
+		"PENALTY_BOX_ENABLED":   "true",
+		"FEATURE_FLAGS_ENABLED": "true",
+		"CACHE_ENABLED":         "false",
+	})
+	components := g.Components()
+	if _, ok := app.Lookup[*penalty.Box](components, "penalty_box"); !ok {
+		t.Error("penalty box not registered")
+	}
+	if _, ok := app.Lookup[*flags.Manager](components, "feature_flags"); !ok {
+		t.Error("feature flags not registered")
+	}
+	if _, ok := app.Lookup[*cache.Cache](components, "cache"); ok {
+		t.Error("disabled cache registered")
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	t.Setenv("UPSTREAMS", "echo")
+	t.Setenv("UPSTREAM_ECHO_URL", "not a url")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "invalid URL") {
+		t.Errorf("New with an invalid upstream URL: %v", err)
+	}
+}
+
+func TestRegisterRoute(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "upstream")
+	}))
+	defer backend.Close()
+	g := newTestGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":        "false",
+		"UPSTREAMS":                 "echo",
+		"UPSTREAM_ECHO_URL":         backend.URL,
+		"UPSTREAM_ECHO_PATH_PREFIX": "/echo",
+	})
+	g.RegisterRoute("/echo/local", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "embedded")
+	})).Methods("GET")
+	handler := g.Handler()
+
+	// Embedded routes take precedence over proxied upstreams, without authentication
+	if w := serve(handler, "GET", "/echo/local"); w.Code != http.StatusOK || w.Body.String() != "embedded" {
+		t.Errorf("GET /echo/local: status %d body %q", w.Code, w.Body.String())
+	}
+	// Global middleware, such as CORS, runs for them
+	if w := serve(handler, "GET", "/echo/local"); w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("GET /echo/local: CORS headers missing")
+	}
+	if w := serve(handler, "GET", "/echo/other"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /echo/other without credentials: status %d", w.Code)
+	}
+}
+
+func TestUse(t *testing.T) {
+	g := newTestGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":     "true",
+		"RATE_LIMIT_CAPACITY":    "1",
+		"RATE_LIMIT_REFILL_RATE": "1",
+		"RATE_LIMIT_WINDOW":      "1h",
+	})
+	var calls []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	g.Use(record("first"), record("second"))
+	g.Use(record("third"))
+	handler := g.Handler()
+
+	if w := serve(handler, "GET", "/health"); w.Code != http.StatusOK {
+		t.Fatalf("GET /health: status %d", w.Code)
+	}
+	if got := strings.Join(calls, ","); got != "first,second,third" {
+		t.Errorf("middleware ran as %s, want in the order added", got)
+	}
+
+	// Added middleware runs after rate limiting, so limited requests skip it
+	calls = nil
+	if w := serve(handler, "GET", "/health"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("GET /health over the limit: status %d", w.Code)
+	}
+	if len(calls) != 0 {
+		t.Errorf("middleware ran for a rate limited request: %v", calls)
+	}
+}
+
+func TestHandlerSeals(t *testing.T) {
+	g := newTestGateway(t, nil)
+	if g.Handler() != g.Handler() {
+		t.Error("Handler built twice")
+	}
+
+	expectPanic := func(name string, add func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s after Handler did not panic", name)
+			}
+		}()
+		add()
+	}
+	expectPanic("RegisterRoute", func() {
+		g.RegisterRoute("/late", http.NotFoundHandler())
+	})
+	expectPanic("Use", func() {
+		g.Use(func(next http.Handler) http.Handler { return next })
+	})
+}
+
+// startSlowGateway serves a gateway whose /slow route answers once release
+// is closed; started receives a value as each request arrives
+func startSlowGateway(t *testing.T) (g *Gateway, started chan struct{}, release chan struct{}) {
+	g = newTestGateway(t, map[string]string{"HOST": "127.0.0.1", "PORT": "0", "RATE_LIMIT_ENABLED": "false"})
+	started, release = make(chan struct{}, 1), make(chan struct{})
+	g.RegisterRoute("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		fmt.Fprint(w, "done")
+	}))
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(); err == nil {
+		t.Error("second Start succeeded")
+	}
+	return g, started, release
+}
+
+// get requests path from g in the background
+func get(g *Gateway, path string) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + g.listener.Addr().String() + path)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- fmt.Sprintf("%d %s", resp.StatusCode, body)
+	}()
+	return result
+}
+
+func TestStopDrains(t *testing.T) {
+	g, started, release := startSlowGateway(t)
+	address := g.listener.Addr().String()
+	response := get(g, "/slow")
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- g.Stop(ctx)
+	}()
+
+	// The gateway stops accepting, but waits for the request in flight
+	<-g.Done()
+	if conn, err := net.Dial("tcp", address); err == nil {
+		conn.Close()
+		t.Error("gateway still accepts connections while stopping")
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-response; got != "200 done" {
+		t.Errorf("request in flight: %s", got)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if !errors.Is(g.Err(), net.ErrClosed) {
+		t.Errorf("Err: %v", g.Err())
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	g, started, release := startSlowGateway(t)
+	response := get(g, "/slow")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := g.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop with a request in flight past the deadline: %v", err)
+	}
+	// The request is left to finish, before the next test builds a gateway
+	close(release)
+	<-response
+}
+
+func TestStopWithoutServing(t *testing.T) {
+	g := newTestGateway(t, nil)
+	if err := g.Stop(context.Background()); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+}
+
+func TestPenaltyBoxIgnoresForwardedFor(t *testing.T) {
+	g := newTestGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":       "false",
+		"PENALTY_BOX_ENABLED":      "true",
+		"PENALTY_BOX_STATUSES":     "401",
+		"PENALTY_BOX_LIMIT_AFTER":  "0",
+		"PENALTY_BOX_BAN_AFTER":    "2",
+		"PENALTY_BOX_BAN_DURATION": "1h",
+	})
+	handler := g.Handler()
+
+	// A banned client stays banned whatever address it claims to forward for
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusForbidden} {
+		r := httptest.NewRequest("GET", "/api/profile", nil)
+		r.RemoteAddr = "203.0.113.9:4000"
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i+1))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("request %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
+
+func TestExternalTokensGrantNoExemptions(t *testing.T) {
+	g := newTestGateway(t, map[string]string{
+		"RATE_LIMIT_ENABLED":        "true",
+		"RATE_LIMIT_CAPACITY":       "1",
+		"RATE_LIMIT_REFILL_RATE":    "1",
+		"RATE_LIMIT_WINDOW":         "1h",
+		"RATE_LIMIT_EXEMPT_ROLES":   "admin",
+		"JWT_TRUSTED_ISSUERS":       "partner",
+		"JWT_ISSUER_PARTNER_ISSUER": "https://idp.partner.example",
+		"JWT_ISSUER_PARTNER_SECRET": "partner-secret",
+	})
+	handler := g.Handler()
+	cfg := g.cfg
+	partner := auth.NewJWTManager("partner-secret", "https://idp.partner.example", cfg.JWT.Audience, time.Hour)
+	gateway := auth.NewJWTManager(cfg.JWT.Secret, cfg.JWT.Issuer, cfg.JWT.Audience, time.Hour)
+
+	tests := []struct {
+		name    string
+		manager *auth.JWTManager
+		address string
+		want    int
+	}{
+		// The partner's admin role is not the gateway's, so it is limited by address
+		{"partner admin", partner, "203.0.113.1:4000", http.StatusTooManyRequests},
+		{"gateway admin", gateway, "203.0.113.2:4000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		token, err := tt.manager.GenerateToken("admin-user", "admin", "admin@example.com", []string{"admin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var code int
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest("GET", "/health", nil)
+			r.RemoteAddr = tt.address
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			code = w.Code
+		}
+		if code != tt.want {
+			t.Errorf("%s: second request status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestGatewaysKeepTheirOwnAuthorizers(t *testing.T) {
+	restricted := newTestGateway(t, map[string]string{
+		"RBAC_ROLES":                  "admin",
+		"RBAC_ROLE_ADMIN_PERMISSIONS": "flags:read",
+	})
+	// A second gateway in the process, with the default permissions
+	open := newTestGateway(t, map[string]string{"RBAC_ROLES": ""})
+
+	for _, tt := range []struct {
+		name string
+		g    *Gateway
+		want int
+	}{
+		{"restricted", restricted, http.StatusForbidden},
+		{"default", open, http.StatusOK},
+	} {
+		jwtManager, _ := app.Lookup[*auth.JWTManager](tt.g.Components(), "jwt")
+		token, err := jwtManager.GenerateToken("1", "alice", "alice@example.com", []string{"admin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/api/admin/ratelimit/exemptions", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		tt.g.Handler().ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s gateway: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !race
+
+// The race detector allocates on its own, so allocations are measured
+// without it
+
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// healthConfigs are the configurations GET /health is measured under,
+// and the allocations each may take. Middleware wrapped per request instead
+// of once by httputil.Chains, or writers and identity slots allocated
+// instead of pooled, push a request over its budget.
+var healthConfigs = []struct {
+	name   string
+	env    map[string]string
+	budget float64
+}{
+	{"default", map[string]string{"RATE_LIMIT_ENABLED": "false"}, 19},
+	{"rate limited", map[string]string{"RATE_LIMIT_ENABLED": "true", "RATE_LIMIT_CAPACITY": "1000000000", "RATE_LIMIT_REFILL_RATE": "1000000000"}, 23},
+}
+
+// discardWriter is a response writer that keeps nothing, so measurements
+// leave out the test recorder
+type discardWriter http.Header
+
+func (w discardWriter) Header() http.Header         { return http.Header(w) }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+func BenchmarkHealth(b *testing.B) {
+	for _, tc := range healthConfigs {
+		b.Run(tc.name, func(b *testing.B) {
+			handler := newTestGateway(b, tc.env).Handler()
+			r := httptest.NewRequest(http.MethodGet, "/health", nil)
+			w := discardWriter{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				clear(w)
+				handler.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func TestHealthAllocations(t *testing.T) {
+	for _, tc := range healthConfigs {
+		handler := newTestGateway(t, tc.env).Handler()
+		r := httptest.NewRequest(http.MethodGet, "/health", nil)
+		w := discardWriter{}
+		allocs := testing.AllocsPerRun(100, func() {
+			clear(w)
+			handler.ServeHTTP(w, r)
+		})
+		if allocs > tc.budget {
+			t.Errorf("%s: GET /health takes %.0f allocations, over its budget of %.0f", tc.name, allocs, tc.budget)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"api-gateway/config"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server creates a server answering HTTP/3 requests over QUIC on the
+// UDP port. Requests reach the same handler as HTTP/1.1 and HTTP/2 ones, and
+// are proxied to upstreams over HTTP/1.1 or HTTP/2 as usual.
+func newHTTP3Server(cfg config.ServerConfig, handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:    cfg.Host + ":" + cfg.HTTP3Port,
+		Handler: handler,
+	}
+}
+
+// serveHTTP3 serves HTTP/3 until the server is shut down. A failing UDP
+// listener is logged rather than fatal, since HTTP/1.1 and HTTP/2 keep working.
+func serveHTTP3(server *http3.Server, cfg config.ServerConfig) {
+	log.Printf("Serving HTTP/3 on udp %s", server.Addr)
+	err := server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("HTTP/3 listener stopped: %v", err)
+	}
+}
+
+// advertiseHTTP3 adds an Alt-Svc header to responses, telling clients they
+// can switch to HTTP/3 on the UDP port
+func advertiseHTTP3(cfg config.ServerConfig, next http.Handler) http.Handler {
+	altSvc := []string{fmt.Sprintf(`h3=":%s"; ma=%d`, cfg.HTTP3Port, int(cfg.HTTP3AltSvcMaxAge.Seconds()))}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Alt-Svc"] = altSvc
+		next.ServeHTTP(w, r)
+	})
+}
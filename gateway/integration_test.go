@@ -0,0 +1,351 @@
+//go:build integration
+
+// End-to-end tests: gateways serve real connections with Redis-backed rate
+// limiting and API keys, in front of stub upstreams. Each test starts a Redis
+// container with docker compose, unless Redis is TEST_REDIS_ADDR=host:port or
+// TEST_REDIS=miniredis runs it in-process. Run them with
+// go test -tags integration ./gateway
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testRedis is the Redis the gateways of a test share. Stop and start are
+// nil when the test cannot control it
+type testRedis struct {
+	addr  string
+	stop  func()
+	start func()
+}
+
+// startRedis returns the Redis for a test: TEST_REDIS_ADDR, an in-process
+// miniredis with TEST_REDIS=miniredis, or otherwise a container removed when
+// the test ends
+func startRedis(t *testing.T) *testRedis {
+	t.Helper()
+	if addr := os.Getenv("TEST_REDIS_ADDR"); addr != "" {
+		return &testRedis{addr: addr}
+	}
+	if os.Getenv("TEST_REDIS") == "miniredis" {
+		server := miniredis.RunT(t)
+		return &testRedis{addr: server.Addr(), stop: server.Close, start: func() {
+			if err := server.Restart(); err != nil {
+				t.Fatal(err)
+			}
+		}}
+	}
+	return startRedisContainer(t)
+}
+
+// startRedisContainer runs testdata/redis-compose.yml as a project of its own
+// on a free local port, which the container keeps when it is restarted
+func startRedisContainer(t *testing.T) *testRedis {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Fatal("the integration tests need docker, or TEST_REDIS_ADDR or TEST_REDIS=miniredis")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	project := fmt.Sprintf("gateway-integration-%d-%d", os.Getpid(), rand.Int31())
+	compose := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("docker", append([]string{"compose", "-p", project, "-f", filepath.Join("testdata", "redis-compose.yml")}, args...)...)
+		cmd.Env = append(os.Environ(), "TEST_REDIS_PORT="+port)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("docker compose %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+	t.Cleanup(func() { compose("down", "--volumes", "--timeout", "1") })
+	compose("up", "--detach", "--wait")
+	return &testRedis{
+		addr:  net.JoinHostPort("127.0.0.1", port),
+		stop:  func() { compose("stop", "--timeout", "1", "redis") },
+		start: func() { compose("up", "--detach", "--wait", "redis") },
+	}
+}
+
+// startGateway serves a gateway configured from env on a local port and
+// returns its base URL; the gateway stops when the test ends
+func startGateway(t *testing.T, redisAddr string, env map[string]string) string {
+	t.Helper()
+	host, port, err := net.SplitHostPort(redisAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := map[string]string{
+		"JWT_SECRET":             "integration-test-secret-0123456789",
+		"REDIS_HOST":             host,
+		"REDIS_PORT":             port,
+		"RATE_LIMIT_USE_REDIS":   "true",
+		"RATE_LIMIT_CAPACITY":    "1000",
+		"RATE_LIMIT_REFILL_RATE": "1000",
+		"API_KEY_USE_REDIS":      "true",
+		// Clients are told apart by X-Forwarded-For
+		"TRUSTED_PROXIES": "127.0.0.1",
+	}
+	for name, value := range env {
+		defaults[name] = value
+	}
+	for name, value := range defaults {
+		t.Setenv(name, value)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Serve(listener, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		g.Stop(ctx)
+	})
+	return "http://" + listener.Addr().String()
+}
+
+// stubUpstream answers every request with its name
+func stubUpstream(t *testing.T, name string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", name)
+		fmt.Fprintf(w, "Hostname: %s\nPath: %s\n", name, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// client sends requests as one client address, so runs sharing a Redis do
+// not share rate limit buckets
+type client struct {
+	t       *testing.T
+	base    string
+	address string
+	headers map[string]string
+}
+
+func newClient(t *testing.T, base string) *client {
+	return &client{t: t, base: base, address: fmt.Sprintf("10.%d.%d.%d", rand.Intn(256), rand.Intn(256), rand.Intn(256))}
+}
+
+// with returns a client sending the header with every request
+func (c *client) with(name, value string) *client {
+	headers := map[string]string{name: value}
+	for k, v := range c.headers {
+		headers[k] = v
+	}
+	return &client{t: c.t, base: c.base, address: c.address, headers: headers}
+}
+
+func (c *client) do(method, path, body string) (*http.Response, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.base+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-For", c.address)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+// expect checks the status of a request and returns its body
+func (c *client) expect(method, path, body string, status int) string {
+	c.t.Helper()
+	resp, data := c.do(method, path, body)
+	if resp.StatusCode != status {
+		c.t.Errorf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, status, strings.TrimSpace(data))
+	}
+	return data
+}
+
+func (c *client) login() string {
+	c.t.Helper()
+	var login struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal([]byte(c.expect("POST", "/login", `{"username":"admin","password":"admin123"}`, http.StatusOK)), &login)
+	if login.Token == "" {
+		c.t.Fatal("login returned no token")
+	}
+	return login.Token
+}
+
+func TestIntegrationAuthentication(t *testing.T) {
+	redisAddr := startRedis(t).addr
+	anonymous := newClient(t, startGateway(t, redisAddr, nil))
+
+	anonymous.expect("POST", "/login", `{"username":"admin","password":"wrong"}`, http.StatusUnauthorized)
+	token := anonymous.login()
+	anonymous.expect("GET", "/api/profile", "", http.StatusUnauthorized)
+	user := anonymous.with("Authorization", "Bearer "+token)
+	user.expect("GET", "/api/profile", "", http.StatusOK)
+	anonymous.with("Authorization", "Bearer "+token+"x").expect("GET", "/api/profile", "", http.StatusUnauthorized)
+
+	var key struct {
+		APIKey struct {
+			Key string `json:"key"`
+		} `json:"api_key"`
+	}
+	json.Unmarshal([]byte(user.expect("POST", "/api/keys", `{"name":"integration","user_id":"1","roles":["user"]}`, http.StatusCreated)), &key)
+	if key.APIKey.Key == "" {
+		t.Fatal("API key not created")
+	}
+	anonymous.with("X-API-Key", key.APIKey.Key).expect("GET", "/api/profile", "", http.StatusOK)
+	anonymous.with("X-API-Key", key.APIKey.Key+"x").expect("GET", "/api/profile", "", http.StatusUnauthorized)
+
+	// Keys live in Redis, so another replica, or a restarted gateway, knows them
+	replica := newClient(t, startGateway(t, redisAddr, nil))
+	replica.with("X-API-Key", key.APIKey.Key).expect("GET", "/api/profile", "", http.StatusOK)
+}
+
+func TestIntegrationProxy(t *testing.T) {
+	redisAddr := startRedis(t).addr
+	backend1, backend2 := stubUpstream(t, "backend-1"), stubUpstream(t, "backend-2")
+	base := startGateway(t, redisAddr, map[string]string{
+		"UPSTREAMS":                              "echo",
+		"UPSTREAM_ECHO_BACKENDS":                 backend1.URL + "," + backend2.URL,
+		"UPSTREAM_ECHO_PATH_PREFIX":              "/echo",
+		"UPSTREAM_ECHO_STRIP_PREFIX":             "true",
+		"UPSTREAM_ECHO_TIMEOUT":                  "5s",
+		"UPSTREAM_ECHO_BALANCE_FAILURE_COOLDOWN": "30s",
+	})
+	anonymous := newClient(t, base)
+	anonymous.expect("GET", "/echo/", "", http.StatusUnauthorized)
+	user := anonymous.with("Authorization", "Bearer "+anonymous.login())
+
+	if body := user.expect("GET", "/echo/orders/7", "", http.StatusOK); !strings.Contains(body, "Path: /orders/7\n") {
+		t.Errorf("prefix not stripped: %s", body)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		resp, _ := user.do("GET", "/echo/", "")
+		seen[resp.Header.Get("X-Backend")] = true
+	}
+	if !seen["backend-1"] || !seen["backend-2"] {
+		t.Errorf("requests reached only %v", seen)
+	}
+
+	// The first requests to the stopped backend fail and put it in cooldown
+	backend1.Close()
+	for i := 0; i < 3; i++ {
+		user.do("GET", "/echo/", "")
+	}
+	for i := 0; i < 10; i++ {
+		resp, _ := user.do("GET", "/echo/", "")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Backend") != "backend-2" {
+			t.Errorf("with backend-1 down: status %d from %q", resp.StatusCode, resp.Header.Get("X-Backend"))
+		}
+	}
+}
+
+func TestIntegrationRedisOutage(t *testing.T) {
+	redis := startRedis(t)
+	if redis.stop == nil {
+		t.Skip("cannot stop the Redis in TEST_REDIS_ADDR")
+	}
+	redisAddr := redis.addr
+	backend := stubUpstream(t, "backend-1")
+	anonymous := newClient(t, startGateway(t, redisAddr, map[string]string{
+		"UPSTREAMS":                 "echo",
+		"UPSTREAM_ECHO_BACKENDS":    backend.URL,
+		"UPSTREAM_ECHO_PATH_PREFIX": "/echo",
+	}))
+	user := anonymous.with("Authorization", "Bearer "+anonymous.login())
+
+	if body := anonymous.expect("GET", "/ready", "", http.StatusOK); !strings.Contains(body, `"redis":"ok"`) {
+		t.Errorf("Redis not reported ready: %s", body)
+	}
+	redis.stop()
+	anonymous.expect("GET", "/ready", "", http.StatusServiceUnavailable)
+	user.expect("GET", "/echo/", "", http.StatusOK)
+
+	redis.start()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, _ := anonymous.do("GET", "/ready", "")
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readiness did not recover: status %d", resp.StatusCode)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestIntegrationRateLimit(t *testing.T) {
+	redisAddr := startRedis(t).addr
+	env := map[string]string{
+		"RATE_LIMIT_CAPACITY":    "5",
+		"RATE_LIMIT_REFILL_RATE": "1",
+		"RATE_LIMIT_WINDOW":      "1h",
+	}
+	c := newClient(t, startGateway(t, redisAddr, env))
+
+	for i := 0; i < 5; i++ {
+		resp, _ := c.do("GET", "/health", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, resp.StatusCode)
+		}
+		if resp.Header.Get("X-Ratelimit-Limit") != "5" || resp.Header.Get("X-Ratelimit-Remaining") != strconv.Itoa(4-i) {
+			t.Errorf("request %d: limit %q remaining %q", i+1, resp.Header.Get("X-Ratelimit-Limit"), resp.Header.Get("X-Ratelimit-Remaining"))
+		}
+	}
+	resp, _ := c.do("GET", "/health", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("over the capacity: status %d, want 429", resp.StatusCode)
+	}
+	if retry, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retry < 1 || retry > 3600 {
+		t.Errorf("Retry-After %q, want up to the refill window", resp.Header.Get("Retry-After"))
+	}
+	// Other clients have their own buckets
+	newClient(t, c.base).expect("GET", "/health", "", http.StatusOK)
+
+	// Buckets live in Redis, so a restarted gateway still limits the client
+	restarted := &client{t: t, base: startGateway(t, redisAddr, env), address: c.address}
+	restarted.expect("GET", "/health", "", http.StatusTooManyRequests)
+}
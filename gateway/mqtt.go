@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"api-gateway/app"
+	"api-gateway/auth"
+	"api-gateway/config"
+	"api-gateway/metrics"
+	"api-gateway/mqtt"
+	"api-gateway/ratelimit"
+)
+
+// newMQTTServer creates the server devices publish messages to. Devices
+// connect with an API key or a token as their password, or as their
+// username when they send no password, or over TLS with a client
+// certificate and no credentials; each credential is one device for rate
+// limiting. The credential is presented again with every message, so routes
+// authorize messages like other requests.
+func (g *Gateway) newMQTTServer(cfg *config.MQTTConfig, tokenValidator auth.TokenValidator, apiKeyStore *auth.APIKeyStore, hooks *auth.Hooks, reg *metrics.Registry) (*mqtt.Server, error) {
+	authenticate := func(username string, password []byte, state *tls.ConnectionState) (string, http.Header) {
+		credential := string(password)
+		if credential == "" {
+			credential = username
+		}
+		if credential == "" {
+			if state == nil {
+				return "", nil
+			}
+			userCtx := auth.PeekIdentity(&http.Request{Header: http.Header{}, TLS: state}, tokenValidator, apiKeyStore, hooks)
+			if userCtx == nil {
+				return "", nil
+			}
+			return "user:" + userCtx.UserID, http.Header{}
+		}
+		bearer := http.Header{}
+		bearer.Set("Authorization", "Bearer "+credential)
+		apiKey := http.Header{}
+		apiKey.Set("X-API-Key", credential)
+		for _, header := range []http.Header{bearer, apiKey} {
+			userCtx := auth.PeekIdentity(&http.Request{Header: header}, tokenValidator, apiKeyStore, hooks)
+			if userCtx == nil {
+				continue
+			}
+			if userCtx.APIKey != nil {
+				sum := sha256.Sum256([]byte(userCtx.APIKey.Key))
+				return "apikey:" + hex.EncodeToString(sum[:8]), header
+			}
+			return "user:" + userCtx.UserID, header
+		}
+		return "", nil
+	}
+
+	var limit mqtt.Limiter
+	if cfg.RateCapacity > 0 {
+		limiter, err := ratelimit.NewRateLimitMiddleware(&ratelimit.RateLimitMiddlewareConfig{
+			Config: &ratelimit.RateLimitConfig{
+				Capacity:   cfg.RateCapacity,
+				RefillRate: cfg.RateRefillRate,
+			},
+			UseRedis: cfg.UseRedis,
+			RedisConfig: &ratelimit.RedisConfig{
+				Host:     cfg.Redis.Host,
+				Port:     cfg.Redis.Port,
+				Password: cfg.Redis.Password,
+				DB:       cfg.Redis.DB,
+				PoolSize: cfg.Redis.PoolSize,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if g.warm != nil {
+			g.warm.Register("mqtt_rate_limit", limiter)
+		}
+		g.components.Register("mqtt_rate_limit", limiter, app.Hooks{
+			Stop: func(context.Context) error { return limiter.Close() },
+		})
+		limit = func(ctx context.Context, device string) (bool, error) {
+			result, err := limiter.Check(ctx, "mqtt:"+device, 1)
+			if err != nil {
+				return false, err
+			}
+			return result.Allowed, nil
+		}
+	}
+
+	return mqtt.NewServer(&mqtt.Config{
+		Routes:         cfg.Routes,
+		ContentType:    cfg.ContentType,
+		MaxMessageSize: cfg.MaxMessageSize,
+		MaxConnections: cfg.MaxConnections,
+		ConnectTimeout: cfg.ConnectTimeout,
+		MessageTimeout: cfg.MessageTimeout,
+	}, authenticate, limit, reg)
+}
+
+// serveMQTT serves MQTT until the server is shut down, bridging messages to
+// handler. A failing listener is logged rather than fatal, since HTTP keeps
+// working.
+func serveMQTT(server *mqtt.Server, cfg *config.MQTTConfig, serverCfg config.ServerConfig, clientCAs *x509.CertPool, handler http.Handler) {
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	listener, err := listenMQTT(addr, cfg.TLS, serverCfg, clientCAs)
+	if err != nil {
+		log.Printf("MQTT listener failed: %v", err)
+		return
+	}
+	log.Printf("Serving MQTT on %s", addr)
+	if err := server.Serve(listener, handler); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("MQTT listener stopped: %v", err)
+	}
+}
+
+// listenMQTT listens for MQTT connections, over TLS with the server's
+// certificate when useTLS is set. Client certificates issued by clientCAs
+// are verified when devices present them.
+func listenMQTT(addr string, useTLS bool, serverCfg config.ServerConfig, clientCAs *x509.CertPool) (net.Listener, error) {
+	if !useTLS {
+		return net.Listen("tcp", addr)
+	}
+	cert, err := tls.LoadX509KeyPair(serverCfg.TLSCertFile, serverCfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = clientCAs
+	}
+	return tls.Listen("tcp", addr, tlsConfig)
+}
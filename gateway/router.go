@@ -0,0 +1,2670 @@
+package gateway
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"api-gateway/accesslog"
+	"api-gateway/admingrpc"
+	"api-gateway/anomaly"
+	"api-gateway/anonymous"
+	"api-gateway/antireplay"
+	"api-gateway/app"
+	"api-gateway/async"
+	"api-gateway/auth"
+	"api-gateway/autoscale"
+	"api-gateway/broker"
+	"api-gateway/budget"
+	"api-gateway/cache"
+	"api-gateway/capture"
+	"api-gateway/chaos"
+	"api-gateway/chargeback"
+	"api-gateway/cluster"
+	"api-gateway/coalesce"
+	"api-gateway/compression"
+	"api-gateway/conditional"
+	"api-gateway/config"
+	"api-gateway/connlimit"
+	"api-gateway/csrf"
+	"api-gateway/debuglog"
+	"api-gateway/device"
+	"api-gateway/diagnostics"
+	_ "api-gateway/docs" // Import docs package for Swagger
+	"api-gateway/errorpages"
+	"api-gateway/experiments"
+	"api-gateway/fairqueue"
+	"api-gateway/federation"
+	"api-gateway/flags"
+	"api-gateway/fleet"
+	"api-gateway/groups"
+	"api-gateway/handlers"
+	"api-gateway/headers"
+	"api-gateway/httputil"
+	"api-gateway/idempotency"
+	"api-gateway/ldap"
+	"api-gateway/masking"
+	"api-gateway/metering"
+	"api-gateway/metrics"
+	"api-gateway/mqtt"
+	"api-gateway/pat"
+	"api-gateway/penalty"
+	"api-gateway/policy"
+	"api-gateway/product"
+	"api-gateway/proxy"
+	"api-gateway/ratelimit"
+	"api-gateway/redact"
+	"api-gateway/saml"
+	"api-gateway/scan"
+	"api-gateway/scim"
+	"api-gateway/shedding"
+	"api-gateway/slo"
+	"api-gateway/state"
+	"api-gateway/streamlimit"
+	"api-gateway/tailcapture"
+	"api-gateway/throttle"
+	"api-gateway/waf"
+	"api-gateway/webhook"
+
+	"github.com/gorilla/mux"
+)
+
+// built holds the components that the steps of buildRouter share. Disabled
+// components are nil.
+type built struct {
+	jwtManager     *auth.JWTManager
+	issuers        map[string]*auth.JWTManager // Keyed by configured name
+	tokenValidator auth.TokenValidator
+	apiKeyStore    *auth.APIKeyStore
+	authHooks      *auth.Hooks // The gateway's, filled in by the steps that build identity components
+
+	metricsRegistry *metrics.Registry
+	transferMetrics *metrics.TransferMetrics
+	statsdExporter  *metrics.StatsDExporter
+
+	proxies             *httputil.TrustedProxies
+	rateLimitMiddleware *ratelimit.RateLimitMiddleware
+	rateLimitExemptions *ratelimit.Exemptions
+	anonymousTier       *anonymous.Tier
+	penaltyBox          *penalty.Box
+
+	anomalyDetector    *anomaly.Detector
+	sloTracker         *slo.Tracker
+	budgetEnforcer     *budget.Enforcer
+	accessLogger       *accesslog.Logger
+	chargebackRecorder *chargeback.Recorder
+
+	policyMiddleware func(http.Handler) http.Handler
+	policyMode       policy.Mode
+	requestFirewall  *waf.WAF
+	products         []*product.Product
+	productCatalog   *product.Catalog
+
+	flagManager        *flags.Manager
+	experimentAssigner *experiments.Assigner
+
+	shedder       *shedding.Shedder
+	connLimiter   *connlimit.Limiter
+	streamLimiter *streamlimit.Limiter
+	uploadScanner *scan.Middleware
+	throttler     *throttle.Throttler
+	requestQueue  *fairqueue.Queue
+	loadTracker   *autoscale.Tracker
+
+	capturer      *capture.Capturer
+	debugLogger   *debuglog.Logger
+	tailRecorder  *tailcapture.Recorder
+	faultInjector *chaos.Injector
+
+	reverseProxy         *proxy.Proxy
+	responseCache        *cache.Cache
+	conditionalValidator *conditional.Validator
+	dispatcher           *async.Dispatcher
+
+	coordinator *cluster.Coordinator
+	replicator  *federation.Replicator
+	meter       *metering.Meter
+
+	authHandler          *handlers.AuthHandler
+	samlHandler          *handlers.SAMLHandler
+	deviceHandler        *handlers.DeviceHandler
+	deviceRegistry       *fleet.Registry
+	fleetHandler         *handlers.FleetHandler
+	clientCAs            *x509.CertPool
+	accountTokensHandler *handlers.AccountTokensHandler
+	groupManager         *groups.Manager
+	groupsHandler        *handlers.GroupsHandler
+	scimHandler          *handlers.SCIMHandler
+	impersonationHandler *handlers.ImpersonationHandler
+	csrfProtector        *csrf.Protector
+	csrfHandler          *handlers.CSRFHandler
+	protectedHandler     *handlers.ProtectedHandler
+
+	mqttServer  *mqtt.Server
+	adminServer *admingrpc.Server
+}
+
+// buildRouter initializes all components and registers the gateway routes.
+// Each step builds on the components of the steps before it. With warm
+// restarts, stateful components are registered with the gateway's warm
+// restart manager.
+func (g *Gateway) buildRouter() error {
+	g.authHooks = &auth.Hooks{}
+	b := &built{authHooks: g.authHooks}
+	steps := []func(*built) error{
+		g.buildAuth,
+		g.buildMetrics,
+		g.buildRateLimit,
+		g.buildObservability,
+		g.buildPolicy,
+		g.buildReleases,
+		g.buildTraffic,
+		g.buildDebugging,
+		g.buildUpstreams,
+		g.buildCluster,
+		g.buildIdentity,
+		g.buildRoutes,
+		g.buildMiddleware,
+		g.buildListeners,
+	}
+	for _, step := range steps {
+		if err := step(b); err != nil {
+			return err
+		}
+	}
+	g.services = &services{
+		accessLogger: b.accessLogger,
+		connLimiter:  b.connLimiter,
+		mqtt:         b.mqttServer,
+		admin:        b.adminServer,
+		clientCAs:    b.clientCAs,
+	}
+	return nil
+}
+
+// requireAuth requires a JWT accepted by validator or an API key, except
+// for unauthenticated requests to routes of the anonymous tier
+func (b *built) requireAuth(validator auth.TokenValidator) mux.MiddlewareFunc {
+	required := auth.RequireEither(validator, b.apiKeyStore, b.authHooks)
+	if b.anonymousTier == nil {
+		return required
+	}
+	return b.anonymousTier.Middleware(required)
+}
+
+// peekIdentity resolves the caller's identity ahead of authentication, from
+// gateway-issued tokens, personal access tokens, API keys and certificates
+func (b *built) peekIdentity(r *http.Request) *auth.UserContext {
+	return auth.PeekIdentity(r, b.tokenValidator, b.apiKeyStore, b.authHooks)
+}
+
+// requireRoles applies the built-in role check unless policies replace it
+func (b *built) requireRoles(roles ...string) mux.MiddlewareFunc {
+	if b.policyMiddleware != nil && b.policyMode == policy.ModeReplace {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return auth.RBACMiddleware(roles...)
+}
+
+// buildAuth initializes the gateway JWT manager, the trusted token issuers
+// and the API key store
+func (g *Gateway) buildAuth(b *built) error {
+	cfg := g.cfg
+
+	// Initialize JWT manager
+	b.jwtManager = auth.NewJWTManager(
+		cfg.JWT.Secret,
+		cfg.JWT.Issuer,
+		cfg.JWT.Audience,
+		cfg.JWT.Expiry,
+	)
+	g.components.Register("jwt", b.jwtManager)
+
+	// Initialize external token issuers that proxied routes may trust
+	b.issuers = map[string]*auth.JWTManager{config.GatewayIssuer: b.jwtManager}
+	for _, issuerConfig := range cfg.JWT.TrustedIssuers {
+		var issuer *auth.JWTManager
+		if issuerConfig.JWKSURL != "" {
+			keys := auth.NewJWKS(issuerConfig.JWKSURL, issuerConfig.JWKSRefresh)
+			issuer = auth.NewJWKSManager(keys, issuerConfig.Issuer, issuerConfig.Audience)
+		} else {
+			issuer = auth.NewJWTManager(issuerConfig.Secret, issuerConfig.Issuer, issuerConfig.Audience, 0)
+		}
+		b.issuers[issuerConfig.Name] = issuer
+	}
+	// Identity resolved ahead of routing grants exemptions, priorities and
+	// flags by role, so only the gateway's own tokens count; callers with
+	// tokens of other issuers are identified by their address until a route
+	// authenticates them
+	b.tokenValidator = b.jwtManager
+
+	// Initialize API key store
+	b.apiKeyStore = auth.NewAPIKeyStore(cfg.APIKeys.Retention)
+	// Keys are shared between replicas through Redis or SQL storage
+	if cfg.APIKeys.UseRedis || cfg.SQLStorage.Enabled {
+		store, err := g.openStore(cfg.APIKeys.UseRedis, cfg.APIKeys.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize API keys: %w", err)
+		}
+		if err := b.apiKeyStore.Persist(store, cfg.APIKeys.RefreshInterval); err != nil {
+			return fmt.Errorf("failed to load API keys: %w", err)
+		}
+	}
+	g.components.Register("api_keys", b.apiKeyStore)
+	return nil
+}
+
+// buildMetrics initializes the metrics registry and its StatsD export
+func (g *Gateway) buildMetrics(b *built) error {
+	cfg := g.cfg
+
+	// Initialize metrics
+	metricsConfig := cfg.Metrics
+	b.metricsRegistry = metrics.NewRegistry()
+	g.components.Register("metrics", b.metricsRegistry)
+	metrics.SetLabelLimits(&metrics.LabelLimit{
+		Name:      "route",
+		Allowlist: metricsConfig.RouteAllowlist,
+		Exempt:    []string{"unmatched"},
+		MaxValues: metricsConfig.MaxRoutes,
+	}, &metrics.LabelLimit{
+		Name:      "consumer",
+		Allowlist: metricsConfig.ConsumerAllowlist,
+		Exempt:    []string{"anonymous"},
+		MaxValues: metricsConfig.MaxConsumers,
+	})
+	b.transferMetrics = metrics.NewTransferMetrics(b.metricsRegistry)
+
+	// Push metrics to a StatsD or DogStatsD agent if enabled
+	if statsdConfig := cfg.StatsD; statsdConfig.Enabled {
+		var err error
+		b.statsdExporter, err = metrics.NewStatsDExporter(&metrics.StatsDConfig{
+			Network:       statsdConfig.Network,
+			Address:       statsdConfig.Address,
+			DogStatsD:     statsdConfig.Format == "dogstatsd",
+			Prefix:        statsdConfig.Prefix,
+			Tags:          statsdConfig.Tags,
+			FlushInterval: statsdConfig.FlushInterval,
+			SampleRate:    statsdConfig.SampleRate,
+			MaxPacketSize: statsdConfig.MaxPacketSize,
+		}, b.metricsRegistry)
+		if err != nil {
+			return fmt.Errorf("failed to initialize StatsD export: %w", err)
+		}
+		// Flushes buffered metrics on shutdown
+		g.components.Register("statsd", b.statsdExporter, app.Hooks{
+			Stop: func(context.Context) error {
+				b.statsdExporter.Close()
+				return nil
+			},
+		})
+	}
+	return nil
+}
+
+// buildRateLimit initializes rate limiting with its exemptions, the anonymous
+// tier and the penalty box
+func (g *Gateway) buildRateLimit(b *built) error {
+	cfg := g.cfg
+
+	// Clients are identified by forwarding headers only behind trusted proxies
+	var err error
+	b.proxies, err = httputil.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	// Initialize rate limiting
+	rateLimitConfig := cfg.RateLimit
+	if rateLimitConfig.Enabled {
+		// Convert config to middleware config
+		identifier := ratelimit.ClientByIP
+		switch rateLimitConfig.Identifier {
+		case "jwt":
+			identifier = ratelimit.ClientByJWTSubject
+		case "apikey":
+			identifier = ratelimit.ClientByAPIKey
+		case "user":
+			identifier = ratelimit.ClientByUserID
+		}
+
+		middlewareConfig := &ratelimit.RateLimitMiddlewareConfig{
+			Identifier: identifier,
+			Config: &ratelimit.RateLimitConfig{
+				Capacity:   rateLimitConfig.Capacity,
+				RefillRate: rateLimitConfig.RefillRate,
+				Window:     rateLimitConfig.Window,
+			},
+			UseRedis: rateLimitConfig.UseRedis,
+			RedisConfig: &ratelimit.RedisConfig{
+				Host:     rateLimitConfig.Redis.Host,
+				Port:     rateLimitConfig.Redis.Port,
+				Password: rateLimitConfig.Redis.Password,
+				DB:       rateLimitConfig.Redis.DB,
+				PoolSize: rateLimitConfig.Redis.PoolSize,
+			},
+			SkipSuccessful: rateLimitConfig.SkipSuccess,
+			SkipFailed:     rateLimitConfig.SkipFailed,
+			TrustedProxies: b.proxies,
+		}
+		if rateLimitConfig.Sync.Enabled {
+			middlewareConfig.Sync = &ratelimit.SyncConfig{
+				ListenAddr: rateLimitConfig.Sync.ListenAddr,
+				Peers:      rateLimitConfig.Sync.Peers,
+				Interval:   rateLimitConfig.Sync.Interval,
+				Key:        []byte(rateLimitConfig.Sync.Key),
+			}
+		}
+
+		var configured []*ratelimit.Exemption
+		for _, ip := range rateLimitConfig.Exempt.IPs {
+			configured = append(configured, &ratelimit.Exemption{Kind: ratelimit.ExemptIP, Value: ip})
+		}
+		for _, key := range rateLimitConfig.Exempt.APIKeys {
+			configured = append(configured, &ratelimit.Exemption{Kind: ratelimit.ExemptAPIKey, Value: key})
+		}
+		for _, role := range rateLimitConfig.Exempt.Roles {
+			configured = append(configured, &ratelimit.Exemption{Kind: ratelimit.ExemptRole, Value: role})
+		}
+		kv, err := g.openStore(rateLimitConfig.UseRedis, rateLimitConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize rate limit exemptions: %w", err)
+		}
+		var refresh time.Duration
+		if rateLimitConfig.UseRedis || cfg.SQLStorage.Enabled {
+			// Other replicas change the stored exemptions too
+			refresh = rateLimitConfig.Exempt.RefreshInterval
+		}
+		b.rateLimitExemptions, err = ratelimit.NewExemptions(configured, ratelimit.NewKVExemptionStore(kv), func(r *http.Request) (string, []string) {
+			userCtx := b.peekIdentity(r)
+			if userCtx == nil {
+				return "", nil
+			}
+			if userCtx.APIKey != nil {
+				return userCtx.APIKey.Key, userCtx.Roles
+			}
+			return "", userCtx.Roles
+		}, b.proxies, refresh)
+		if err != nil {
+			return fmt.Errorf("failed to initialize rate limit exemptions: %w", err)
+		}
+		g.components.Register("rate_limit_exemptions", b.rateLimitExemptions)
+		middlewareConfig.Exemptions = b.rateLimitExemptions
+
+		b.rateLimitMiddleware, err = ratelimit.NewRateLimitMiddleware(middlewareConfig)
+		if err != nil {
+			return fmt.Errorf("failed to initialize rate limiting: %w", err)
+		}
+		if g.warm != nil {
+			g.warm.Register("rate_limit", b.rateLimitMiddleware)
+		}
+		g.components.Register("rate_limit", b.rateLimitMiddleware, app.Hooks{
+			Stop: func(context.Context) error { return b.rateLimitMiddleware.Close() },
+		})
+	}
+
+	// Initialize the anonymous tier for unauthenticated requests
+	if anonymousConfig := cfg.Anonymous; anonymousConfig.Enabled {
+		tierConfig := &anonymous.Config{
+			Paths:              anonymousConfig.Paths,
+			FingerprintHeaders: anonymousConfig.FingerprintHeaders,
+			Quota:              anonymousConfig.Quota,
+			QuotaWindow:        anonymousConfig.QuotaWindow,
+			Proxies:            b.proxies,
+		}
+		var quotaStore anonymous.QuotaStore
+		if anonymousConfig.UseRedis {
+			redisManager, err := g.connectRedis(anonymousConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize anonymous tier: %w", err)
+			}
+			quotaStore = anonymous.NewRedisQuotaStore(redisManager.GetClient())
+		} else {
+			quotaStore = anonymous.NewMemoryQuotaStore()
+		}
+		anonymousLimiter, err := ratelimit.NewRateLimitMiddleware(&ratelimit.RateLimitMiddlewareConfig{
+			Config: &ratelimit.RateLimitConfig{
+				Capacity:   anonymousConfig.Capacity,
+				RefillRate: anonymousConfig.RefillRate,
+			},
+			UseRedis: anonymousConfig.UseRedis,
+			RedisConfig: &ratelimit.RedisConfig{
+				Host:     anonymousConfig.Redis.Host,
+				Port:     anonymousConfig.Redis.Port,
+				Password: anonymousConfig.Redis.Password,
+				DB:       anonymousConfig.Redis.DB,
+				PoolSize: anonymousConfig.Redis.PoolSize,
+			},
+			CustomKeyFunc: func(r *http.Request) string {
+				return anonymous.ClientKey(r, tierConfig)
+			},
+			Exemptions: b.rateLimitExemptions,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize anonymous tier: %w", err)
+		}
+		if g.warm != nil {
+			g.warm.Register("anonymous_rate_limit", anonymousLimiter)
+		}
+		b.anonymousTier = anonymous.NewTier(tierConfig, anonymousLimiter.Middleware(), quotaStore)
+		g.components.Register("anonymous", b.anonymousTier)
+	}
+
+	// Initialize the penalty box for repeatedly rejected clients
+	if penaltyConfig := cfg.PenaltyBox; penaltyConfig.Enabled {
+		identify := func(r *http.Request) string {
+			userCtx := b.peekIdentity(r)
+			if userCtx == nil {
+				return "ip:" + b.proxies.ClientIP(r)
+			}
+			if userCtx.APIKey != nil {
+				return "apikey:" + userCtx.APIKey.Key
+			}
+			return "user:" + userCtx.UserID
+		}
+		penaltyLimiter, err := ratelimit.NewRateLimitMiddleware(&ratelimit.RateLimitMiddlewareConfig{
+			Config: &ratelimit.RateLimitConfig{
+				Capacity:   penaltyConfig.LimitCapacity,
+				RefillRate: penaltyConfig.LimitRefillRate,
+			},
+			UseRedis: penaltyConfig.UseRedis,
+			RedisConfig: &ratelimit.RedisConfig{
+				Host:     penaltyConfig.Redis.Host,
+				Port:     penaltyConfig.Redis.Port,
+				Password: penaltyConfig.Redis.Password,
+				DB:       penaltyConfig.Redis.DB,
+				PoolSize: penaltyConfig.Redis.PoolSize,
+			},
+			CustomKeyFunc: func(r *http.Request) string {
+				return "penalty:" + identify(r)
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize penalty box: %w", err)
+		}
+		if g.warm != nil {
+			g.warm.Register("penalty_rate_limit", penaltyLimiter)
+		}
+		kv, err := g.openStore(penaltyConfig.UseRedis, penaltyConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize penalty box: %w", err)
+		}
+		penaltyStore := penalty.NewKVStore(kv)
+		boxConfig := &penalty.Config{
+			Statuses:      penaltyConfig.Statuses,
+			StrikeWindow:  penaltyConfig.StrikeWindow,
+			LimitAfter:    penaltyConfig.LimitAfter,
+			LimitDuration: penaltyConfig.LimitDuration,
+			BanAfter:      penaltyConfig.BanAfter,
+			BanDuration:   penaltyConfig.BanDuration,
+			History:       penaltyConfig.History,
+			MaxDuration:   penaltyConfig.MaxDuration,
+			Limit:         penaltyLimiter.Middleware(),
+			Identify:      identify,
+		}
+		// Callers exempt from rate limiting are never penalized either
+		if b.rateLimitExemptions != nil {
+			boxConfig.Exempt = func(r *http.Request) bool {
+				return b.rateLimitExemptions.Match(r) != nil
+			}
+		}
+		b.penaltyBox = penalty.NewBox(boxConfig, penaltyStore, b.metricsRegistry)
+		g.components.Register("penalty_box", b.penaltyBox)
+	}
+	return nil
+}
+
+// buildObservability initializes anomaly detection, SLO tracking, latency
+// budgets, access logging and chargeback reporting
+func (g *Gateway) buildObservability(b *built) error {
+	cfg := g.cfg
+
+	// Initialize anomaly detection on traffic patterns
+	if anomalyConfig := cfg.Anomaly; anomalyConfig.Enabled {
+		b.anomalyDetector = anomaly.NewDetector(&anomaly.Config{
+			Dimensions:     anomalyConfig.Dimensions,
+			Interval:       anomalyConfig.Interval,
+			Alpha:          anomalyConfig.Alpha,
+			Threshold:      anomalyConfig.Threshold,
+			Warmup:         anomalyConfig.Warmup,
+			MinRequests:    anomalyConfig.MinRequests,
+			Cooldown:       anomalyConfig.Cooldown,
+			MaxSeries:      anomalyConfig.MaxSeries,
+			WebhookURL:     anomalyConfig.WebhookURL,
+			WebhookTimeout: anomalyConfig.WebhookTimeout,
+		}, b.metricsRegistry)
+		g.components.Register("anomaly", b.anomalyDetector)
+	}
+
+	// Initialize per-route SLO tracking
+	if sloConfig := cfg.SLO; sloConfig.Enabled {
+		objectives := make([]*slo.Objective, 0, len(sloConfig.Objectives))
+		for _, objective := range sloConfig.Objectives {
+			objectives = append(objectives, &slo.Objective{
+				Name:          objective.Name,
+				Paths:         objective.Paths,
+				Methods:       objective.Methods,
+				Latency:       objective.Latency,
+				LatencyTarget: objective.LatencyTarget,
+				ErrorRate:     objective.ErrorRate,
+			})
+		}
+		b.sloTracker = slo.NewTracker(&slo.Config{
+			Objectives:     objectives,
+			Window:         sloConfig.Window,
+			FastBurnRate:   sloConfig.FastBurnRate,
+			SlowBurnRate:   sloConfig.SlowBurnRate,
+			Interval:       sloConfig.Interval,
+			WebhookURL:     sloConfig.WebhookURL,
+			WebhookTimeout: sloConfig.WebhookTimeout,
+		}, b.metricsRegistry)
+		g.components.Register("slo", b.sloTracker)
+		if g.warm != nil {
+			g.warm.Register("slo", b.sloTracker)
+		}
+	}
+
+	// Initialize latency budgets from route settings and SLO latency objectives
+	if budgetConfig := cfg.LatencyBudget; budgetConfig.Enabled {
+		var routes []*budget.Route
+		for prefix, routeBudget := range budgetConfig.Routes {
+			routes = append(routes, &budget.Route{Name: "route:" + prefix, Paths: []string{prefix}, Budget: routeBudget})
+		}
+		if budgetConfig.FromSLO && cfg.SLO.Enabled {
+			for _, objective := range cfg.SLO.Objectives {
+				if objective.Latency <= 0 {
+					continue
+				}
+				routes = append(routes, &budget.Route{
+					Name:    "slo:" + objective.Name,
+					Paths:   objective.Paths,
+					Methods: objective.Methods,
+					Budget:  time.Duration(float64(objective.Latency) * budgetConfig.SLOMultiplier),
+				})
+			}
+		}
+		sort.Slice(routes, func(i, j int) bool {
+			return routes[i].Name < routes[j].Name
+		})
+		b.budgetEnforcer = budget.NewEnforcer(&budget.Config{
+			Header:      budgetConfig.Header,
+			Default:     budgetConfig.Default,
+			Routes:      routes,
+			HonorClient: budgetConfig.HonorClient,
+		}, b.metricsRegistry)
+		g.components.Register("latency_budget", b.budgetEnforcer)
+	}
+
+	// Initialize access logging to the configured sinks
+	if accessLogConfig := cfg.AccessLog; accessLogConfig.Enabled {
+		sinks, err := newAccessLogSinks(accessLogConfig)
+		if err != nil {
+			return fmt.Errorf("failed to initialize access logging: %w", err)
+		}
+		b.accessLogger = accesslog.NewLogger(&accesslog.Config{
+			BufferSize:    accessLogConfig.BufferSize,
+			BatchSize:     accessLogConfig.BatchSize,
+			FlushInterval: accessLogConfig.FlushInterval,
+			WriteTimeout:  accessLogConfig.WriteTimeout,
+			MaxAttempts:   accessLogConfig.MaxAttempts,
+			Redactor:      newRedactor(cfg.Redaction, nil),
+		}, sinks, b.metricsRegistry)
+		// Flushes buffered entries on shutdown
+		g.components.Register("access_log", b.accessLogger, app.Hooks{
+			Stop: func(ctx context.Context) error {
+				b.accessLogger.Close(ctx)
+				return nil
+			},
+		})
+	}
+
+	// Initialize chargeback reporting of monthly usage per consumer
+	if chargebackConfig := cfg.Chargeback; chargebackConfig.Enabled {
+		var chargebackStore chargeback.Store
+		if chargebackConfig.UseRedis {
+			redisManager, err := g.connectRedis(chargebackConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize chargeback reporting: %w", err)
+			}
+			chargebackStore = chargeback.NewRedisStore(redisManager.GetClient(), chargebackConfig.RetentionMonths)
+		} else {
+			chargebackStore = chargeback.NewMemoryStore(chargebackConfig.RetentionMonths)
+		}
+		b.chargebackRecorder = chargeback.NewRecorder(&chargeback.Config{
+			UnitsPerRequest:       chargebackConfig.UnitsPerRequest,
+			UnitsPerGB:            chargebackConfig.UnitsPerGB,
+			UnitsPerComputeSecond: chargebackConfig.UnitsPerComputeSecond,
+			RouteWeights:          chargebackConfig.RouteWeights,
+			TenantClaim:           chargebackConfig.TenantClaim,
+			FlushInterval:         chargebackConfig.FlushInterval,
+		}, chargebackStore)
+		g.components.Register("chargeback", b.chargebackRecorder)
+	}
+	return nil
+}
+
+// buildPolicy initializes policy-based authorization, request inspection and
+// API products
+func (g *Gateway) buildPolicy(b *built) error {
+	cfg := g.cfg
+
+	// Initialize policy-based authorization
+	policyConfig := cfg.Policy
+	var opaClient *policy.OPAClient
+	if policyConfig.Enabled {
+		opaClient = policy.NewOPAClient(policyConfig.OPAURL, policyConfig.Path, policyConfig.Timeout)
+		b.policyMiddleware = policy.Middleware(opaClient, policy.MiddlewareConfig{
+			FailOpen: policyConfig.FailOpen,
+		})
+		b.policyMode = policy.Mode(policyConfig.Mode)
+	}
+
+	// Permission checks in handlers use the role permissions, combined with policies if enabled
+	rolePermissions := auth.RolePermissions(cfg.Permissions.Roles)
+	if opaClient != nil {
+		b.authHooks.Authorizer = policy.NewAuthorizer(opaClient, rolePermissions, b.policyMode, policy.MiddlewareConfig{
+			FailOpen: policyConfig.FailOpen,
+		})
+	} else {
+		b.authHooks.Authorizer = rolePermissions
+	}
+
+	// Initialize request inspection (WAF)
+	wafConfig := cfg.WAF
+	if wafConfig.Enabled {
+		routeModes := make(map[string]waf.Mode)
+		for prefix, mode := range wafConfig.RouteModes {
+			routeModes[prefix] = waf.Mode(mode)
+		}
+
+		b.requestFirewall = waf.New(&waf.Config{
+			Mode:          waf.Mode(wafConfig.Mode),
+			RouteModes:    routeModes,
+			MaxBodySize:   wafConfig.MaxBodySize,
+			Rules:         waf.DefaultRules(wafConfig.MaxJSONDepth, wafConfig.BannedContentTypes),
+			DisabledRules: wafConfig.DisabledRules,
+		})
+		g.components.Register("waf", b.requestFirewall)
+	}
+
+	// Initialize API products
+	b.products = make([]*product.Product, 0, len(cfg.Products))
+	for _, productConfig := range cfg.Products {
+		b.products = append(b.products, &product.Product{
+			Name:        productConfig.Name,
+			Description: productConfig.Description,
+			Paths:       productConfig.Paths,
+			Plans:       productConfig.Plans,
+			Quotas:      productConfig.Quotas,
+		})
+	}
+	b.productCatalog = product.NewCatalog(b.products, func(r *http.Request) *auth.APIKey {
+		if userCtx := b.peekIdentity(r); userCtx != nil {
+			return userCtx.APIKey
+		}
+		return nil
+	})
+	g.components.Register("products", b.productCatalog)
+	return nil
+}
+
+// buildReleases initializes feature flags and A/B experiments
+func (g *Gateway) buildReleases(b *built) error {
+	cfg := g.cfg
+
+	// Initialize feature flags
+	featureFlagsConfig := cfg.FeatureFlags
+	if featureFlagsConfig.Enabled {
+		configured := make([]*flags.Flag, 0, len(featureFlagsConfig.Flags))
+		for _, flagConfig := range featureFlagsConfig.Flags {
+			configured = append(configured, &flags.Flag{
+				Name:        flagConfig.Name,
+				Description: flagConfig.Description,
+				Enabled:     flagConfig.Enabled,
+				Percent:     flagConfig.Percent,
+				Roles:       flagConfig.Roles,
+				Start:       flagConfig.Start,
+				End:         flagConfig.End,
+			})
+		}
+		var flagStore flags.Store
+		var refresh time.Duration
+		if featureFlagsConfig.UseRedis {
+			redisManager, err := g.connectRedis(featureFlagsConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize feature flags: %w", err)
+			}
+			flagStore = flags.NewRedisStore(redisManager.GetClient())
+			// Other replicas change the stored overrides too
+			refresh = featureFlagsConfig.RefreshInterval
+		} else {
+			flagStore = flags.NewMemoryStore()
+		}
+		flagManager, err := flags.NewManager(&flags.Config{
+			Flags:  configured,
+			Routes: featureFlagsConfig.Routes,
+			Header: featureFlagsConfig.Header,
+			Identify: func(r *http.Request) (string, []string) {
+				userCtx := b.peekIdentity(r)
+				if userCtx == nil {
+					return "ip:" + b.proxies.ClientIP(r), nil
+				}
+				if userCtx.APIKey != nil {
+					return "apikey:" + userCtx.APIKey.Key, userCtx.Roles
+				}
+				return "user:" + userCtx.UserID, userCtx.Roles
+			},
+		}, flagStore, refresh, b.metricsRegistry)
+		if err != nil {
+			return fmt.Errorf("failed to initialize feature flags: %w", err)
+		}
+		b.flagManager = flagManager
+		g.components.Register("feature_flags", b.flagManager)
+	}
+
+	// Initialize A/B experiments
+	experimentsConfig := cfg.Experiments
+	if experimentsConfig.Enabled {
+		configured := make([]*experiments.Experiment, 0, len(experimentsConfig.Experiments))
+		for _, experimentConfig := range experimentsConfig.Experiments {
+			variants := make([]experiments.Variant, 0, len(experimentConfig.Variants))
+			for _, variantConfig := range experimentConfig.Variants {
+				variants = append(variants, experiments.Variant{Name: variantConfig.Name, Weight: variantConfig.Weight})
+			}
+			configured = append(configured, &experiments.Experiment{
+				Name:     experimentConfig.Name,
+				Paths:    experimentConfig.Paths,
+				Percent:  experimentConfig.Percent,
+				Variants: variants,
+			})
+		}
+		b.experimentAssigner = experiments.NewAssigner(&experiments.Config{
+			Experiments:       configured,
+			Header:            experimentsConfig.Header,
+			MaxTrackedCallers: experimentsConfig.MaxTrackedCallers,
+			Identify: func(r *http.Request) string {
+				userCtx := b.peekIdentity(r)
+				if userCtx == nil {
+					return "ip:" + b.proxies.ClientIP(r)
+				}
+				if userCtx.APIKey != nil {
+					return "apikey:" + userCtx.APIKey.Key
+				}
+				return "user:" + userCtx.UserID
+			},
+		}, b.metricsRegistry)
+		g.components.Register("experiments", b.experimentAssigner)
+	}
+	return nil
+}
+
+// buildTraffic initializes load shedding, connection and stream limits, upload
+// scanning, bandwidth throttling, request prioritization and autoscaling signals
+func (g *Gateway) buildTraffic(b *built) error {
+	cfg := g.cfg
+
+	// Initialize priority-based load shedding
+	sheddingConfig := cfg.Shedding
+	if sheddingConfig.Enabled {
+		b.shedder = shedding.NewShedder(&shedding.Config{
+			MaxCPU:          sheddingConfig.MaxCPU,
+			MaxGoroutines:   sheddingConfig.MaxGoroutines,
+			MaxInFlight:     sheddingConfig.MaxInFlight,
+			RoutePriorities: parsePriorities(sheddingConfig.RoutePriorities),
+			RolePriorities:  parsePriorities(sheddingConfig.RolePriorities),
+			DefaultPriority: parsePriority(sheddingConfig.DefaultPriority),
+			SampleInterval:  sheddingConfig.SampleInterval,
+			RolesOf: func(r *http.Request) []string {
+				if userCtx := b.peekIdentity(r); userCtx != nil {
+					return userCtx.Roles
+				}
+				return nil
+			},
+		}, b.metricsRegistry)
+		g.components.Register("shedding", b.shedder)
+	}
+
+	// Initialize listener-level connection limits, applied by serve
+	connLimitsConfig := cfg.ConnLimits
+	if connLimitsConfig.Enabled {
+		b.connLimiter = connlimit.NewLimiter(&connlimit.Config{
+			MaxConns:    connLimitsConfig.MaxConns,
+			MaxPerIP:    connLimitsConfig.MaxPerIP,
+			AcceptRate:  connLimitsConfig.AcceptRate,
+			AcceptBurst: connLimitsConfig.AcceptBurst,
+		}, b.metricsRegistry)
+		g.components.Register("conn_limits", b.connLimiter)
+	}
+
+	// Initialize stream limits for proxied routes
+	streamLimitsConfig := cfg.StreamLimits
+	if streamLimitsConfig.Enabled {
+		routes := make([]*streamlimit.Route, 0, len(streamLimitsConfig.Routes))
+		for _, routeConfig := range streamLimitsConfig.Routes {
+			routes = append(routes, &streamlimit.Route{
+				Name:             routeConfig.Name,
+				Paths:            routeConfig.Paths,
+				MaxRequestBytes:  routeConfig.MaxRequestBytes,
+				MaxResponseBytes: routeConfig.MaxResponseBytes,
+				MaxDuration:      routeConfig.MaxDuration,
+			})
+		}
+		var quotaStore streamlimit.QuotaStore
+		if streamLimitsConfig.UseRedis {
+			redisManager, err := g.connectRedis(streamLimitsConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize stream limits: %w", err)
+			}
+			quotaStore = streamlimit.NewRedisQuotaStore(redisManager.GetClient())
+		} else {
+			quotaStore = streamlimit.NewMemoryQuotaStore()
+		}
+		b.streamLimiter = streamlimit.NewLimiter(&streamlimit.Config{
+			Routes:      routes,
+			Quota:       streamLimitsConfig.Quota,
+			PlanQuotas:  streamLimitsConfig.PlanQuotas,
+			QuotaWindow: streamLimitsConfig.QuotaWindow,
+			Identify: func(r *http.Request) (string, string) {
+				userCtx := auth.GetUserFromContext(r)
+				if userCtx == nil {
+					return "ip:" + b.proxies.ClientIP(r), ""
+				}
+				if userCtx.APIKey != nil {
+					return "apikey:" + userCtx.APIKey.Key, userCtx.APIKey.Plan
+				}
+				// JWT users are on the plan named by one of their roles
+				for _, role := range userCtx.Roles {
+					if _, ok := streamLimitsConfig.PlanQuotas[role]; ok {
+						return "user:" + userCtx.UserID, role
+					}
+				}
+				return "user:" + userCtx.UserID, ""
+			},
+		}, quotaStore, b.metricsRegistry)
+		g.components.Register("stream_limits", b.streamLimiter)
+	}
+
+	// Initialize malware scanning of uploads on proxied routes
+	uploadScanConfig := cfg.UploadScan
+	if uploadScanConfig.Enabled {
+		routes := make([]*scan.Route, 0, len(uploadScanConfig.Routes))
+		for _, routeConfig := range uploadScanConfig.Routes {
+			var scanner scan.Scanner
+			if routeConfig.Scanner == "icap" {
+				icapScanner, err := scan.NewICAPScanner(routeConfig.Address)
+				if err != nil {
+					return fmt.Errorf("failed to initialize upload scanning for %s: %w", routeConfig.Name, err)
+				}
+				scanner = icapScanner
+			} else {
+				scanner = scan.NewClamdScanner(routeConfig.Address)
+			}
+			routes = append(routes, &scan.Route{
+				Name:     routeConfig.Name,
+				Paths:    routeConfig.Paths,
+				Scanner:  scanner,
+				Timeout:  routeConfig.Timeout,
+				FailOpen: routeConfig.FailOpen,
+			})
+		}
+		b.uploadScanner = scan.NewMiddleware(&scan.Config{
+			Routes:   routes,
+			SpoolDir: uploadScanConfig.SpoolDir,
+		}, b.metricsRegistry)
+		g.components.Register("upload_scan", b.uploadScanner)
+	}
+
+	// Initialize per-consumer bandwidth throttling
+	throttleConfig := cfg.Throttle
+	if throttleConfig.Enabled {
+		b.throttler = throttle.NewThrottler(&throttle.Config{
+			DefaultRate: throttleConfig.DefaultRate,
+			PlanRates:   throttleConfig.PlanRates,
+			Burst:       throttleConfig.Burst,
+			Identify: func(r *http.Request) (string, string) {
+				userCtx := b.peekIdentity(r)
+				if userCtx == nil {
+					return "ip:" + b.proxies.ClientIP(r), ""
+				}
+				if userCtx.APIKey != nil {
+					return "apikey:" + userCtx.APIKey.Key, userCtx.APIKey.Plan
+				}
+				// JWT users are on the plan named by one of their roles
+				for _, role := range userCtx.Roles {
+					if _, ok := throttleConfig.PlanRates[role]; ok {
+						return "user:" + userCtx.UserID, role
+					}
+				}
+				return "user:" + userCtx.UserID, ""
+			},
+		}, b.metricsRegistry)
+		g.components.Register("throttle", b.throttler)
+	}
+
+	// Initialize per-route request prioritization
+	queueConfig := cfg.Queue
+	if queueConfig.Enabled {
+		classes := make([]fairqueue.Class, 0, len(queueConfig.Classes))
+		for name, weight := range queueConfig.Classes {
+			classes = append(classes, fairqueue.Class{Name: name, Weight: weight})
+		}
+		sort.Slice(classes, func(i, j int) bool {
+			return classes[i].Name < classes[j].Name
+		})
+
+		b.requestQueue = fairqueue.New(&fairqueue.Config{
+			MaxConcurrent: queueConfig.MaxConcurrent,
+			MaxDepth:      queueConfig.MaxDepth,
+			MaxWait:       queueConfig.MaxWait,
+			Classes:       classes,
+			RouteClasses:  queueConfig.RouteClasses,
+			DefaultClass:  queueConfig.DefaultClass,
+		}, b.metricsRegistry)
+		g.components.Register("queue", b.requestQueue)
+	}
+
+	// Initialize autoscaling load signals
+	autoscaleConfig := cfg.Autoscale
+	if autoscaleConfig.Enabled {
+		var queueDepth func() int
+		if b.requestQueue != nil {
+			queueDepth = b.requestQueue.Depth
+		}
+		b.loadTracker = autoscale.NewTracker(&autoscale.Config{
+			TargetInFlight:   autoscaleConfig.TargetInFlight,
+			TargetQueueDepth: autoscaleConfig.TargetQueueDepth,
+			TargetP99:        autoscaleConfig.TargetP99,
+			TargetCPU:        autoscaleConfig.TargetCPU,
+			Window:           autoscaleConfig.Window,
+			SampleInterval:   autoscaleConfig.SampleInterval,
+			QueueDepth:       queueDepth,
+		}, b.metricsRegistry)
+		g.components.Register("autoscale", b.loadTracker)
+	}
+	return nil
+}
+
+// buildDebugging initializes traffic capture, debug logging, tail capture and
+// fault injection
+func (g *Gateway) buildDebugging(b *built) error {
+	cfg := g.cfg
+
+	// Initialize traffic capture
+	captureConfig := cfg.Capture
+	if captureConfig.Enabled {
+		var sink capture.Sink
+		if captureConfig.Storage == "redis" {
+			redisManager, err := g.connectRedis(captureConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize capture storage: %w", err)
+			}
+			sink = capture.NewRedisSink(redisManager.GetClient(), captureConfig.Retention)
+		} else {
+			fileSink, err := capture.NewFileSink(captureConfig.Dir)
+			if err != nil {
+				return fmt.Errorf("failed to initialize capture storage: %w", err)
+			}
+			sink = fileSink
+		}
+
+		b.capturer = capture.NewCapturer(&capture.Config{
+			MaxBodySize: captureConfig.MaxBodySize,
+			Redactor:    newRedactor(cfg.Redaction, captureConfig.RedactHeaders),
+		}, sink)
+		g.components.Register("capture", b.capturer)
+	}
+
+	// Initialize on-demand debug logging
+	debugLogConfig := cfg.DebugLog
+	if debugLogConfig.Enabled {
+		b.debugLogger = debuglog.NewLogger(&debuglog.Config{
+			MaxBodySize: debugLogConfig.MaxBodySize,
+			MaxDuration: debugLogConfig.MaxDuration,
+			Redactor:    newRedactor(cfg.Redaction, debugLogConfig.RedactHeaders),
+		})
+		g.components.Register("debug_log", b.debugLogger)
+	}
+
+	// Initialize tail-based capture of slow and failed requests
+	if tailConfig := cfg.TailCapture; tailConfig.Enabled {
+		b.tailRecorder = tailcapture.NewRecorder(&tailcapture.Config{
+			SlowThreshold: tailConfig.SlowThreshold,
+			MinStatus:     tailConfig.MinStatus,
+			Size:          tailConfig.Size,
+			MaxBodySize:   tailConfig.MaxBodySize,
+			Redactor:      newRedactor(cfg.Redaction, tailConfig.RedactHeaders),
+		}, b.metricsRegistry)
+		g.components.Register("tail_capture", b.tailRecorder)
+	}
+
+	// Initialize fault injection
+	chaosConfig := cfg.Chaos
+	if chaosConfig.Enabled {
+		b.faultInjector = chaos.NewInjector()
+		g.components.Register("chaos", b.faultInjector)
+	}
+	return nil
+}
+
+// buildUpstreams initializes upstream proxying with response caching,
+// conditional requests and async jobs
+func (g *Gateway) buildUpstreams(b *built) error {
+	cfg := g.cfg
+
+	// Initialize upstream proxying
+	upstreams, err := newUpstreams(cfg.Proxy, cfg.Redaction, b.metricsRegistry)
+	if err != nil {
+		return fmt.Errorf("failed to initialize upstreams: %w", err)
+	}
+	if len(upstreams) > 0 {
+		b.reverseProxy, err = proxy.New(&proxy.Config{
+			Upstreams:         upstreams,
+			TLSReloadInterval: cfg.Proxy.TLSReloadInterval,
+		}, b.metricsRegistry)
+		if err != nil {
+			return fmt.Errorf("failed to initialize upstreams: %w", err)
+		}
+		if g.warm != nil {
+			g.warm.Register("upstream_backends", b.reverseProxy)
+		}
+		g.components.Register("proxy", b.reverseProxy)
+	}
+
+	// Initialize caching of upstream responses
+	if cacheConfig := cfg.Cache; cacheConfig.Enabled && b.reverseProxy != nil {
+		kv, err := g.openStore(cacheConfig.UseRedis, cacheConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize response cache: %w", err)
+		}
+		b.responseCache = cache.NewCache(&cache.Config{
+			PathPrefixes: cacheConfig.PathPrefixes,
+			DefaultTTL:   cacheConfig.DefaultTTL,
+			MaxTTL:       cacheConfig.MaxTTL,
+			MaxBodySize:  cacheConfig.MaxBodySize,
+			TagHeader:    cacheConfig.TagHeader,
+
+			StaleWhileRevalidate: cacheConfig.StaleWhileRevalidate,
+			StaleIfError:         cacheConfig.StaleIfError,
+			Ranges:               cacheConfig.Ranges,
+			// Claim routes and experiments change what the upstream answers
+			// without the URL or the client's headers changing
+			Partition: func(r *http.Request) string {
+				partition := b.reverseProxy.ClaimPartition(r)
+				if b.experimentAssigner != nil {
+					partition += "\x00" + r.Header.Get(cfg.Experiments.Header)
+				}
+				return partition
+			},
+		}, kv, b.metricsRegistry)
+		g.components.Register("cache", b.responseCache)
+	}
+
+	// Initialize answering conditional requests at the gateway
+	if conditionalConfig := cfg.Conditional; conditionalConfig.Enabled && b.reverseProxy != nil {
+		b.conditionalValidator = conditional.NewValidator(&conditional.Config{
+			PathPrefixes: conditionalConfig.PathPrefixes,
+			WeakETags:    conditionalConfig.WeakETags,
+			MaxBodySize:  conditionalConfig.MaxBodySize,
+		}, b.metricsRegistry)
+		g.components.Register("conditional", b.conditionalValidator)
+	}
+
+	// Initialize queuing of requests to async upstreams
+	if asyncConfig := cfg.Async; asyncConfig.Enabled && b.reverseProxy != nil {
+		kv, err := g.openStore(asyncConfig.UseRedis, asyncConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize async jobs: %w", err)
+		}
+		var queue async.Queue
+		if asyncConfig.UseRedis {
+			redisManager, err := g.connectRedis(asyncConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize async queue: %w", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			// Jobs of a worker that died are taken over once they could
+			// no longer be running
+			queue, err = async.NewRedisQueue(ctx, redisManager.GetClient(), asyncConfig.Stream, int64(asyncConfig.QueueSize), asyncConfig.JobTimeout+time.Minute)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to initialize async queue: %w", err)
+			}
+		} else {
+			queue = async.NewMemoryQueue(asyncConfig.QueueSize)
+		}
+		b.dispatcher = async.NewDispatcher(&async.Config{
+			Workers:       asyncConfig.Workers,
+			MaxBodySize:   int64(asyncConfig.MaxBodySize),
+			MaxResultSize: asyncConfig.MaxResultSize,
+			ResultTTL:     asyncConfig.ResultTTL,
+			JobTimeout:    asyncConfig.JobTimeout,
+			StatusPath:    "/api/async/",
+		}, queue, kv, b.metricsRegistry)
+		g.components.Register("async", b.dispatcher, app.Hooks{
+			Start: func(context.Context) error {
+				b.dispatcher.Start()
+				return nil
+			},
+			Stop: b.dispatcher.Stop,
+		})
+	}
+	return nil
+}
+
+// buildCluster initializes coordination between replicas, replication between
+// regions and metering, whose jobs only the leader runs
+func (g *Gateway) buildCluster(b *built) error {
+	cfg := g.cfg
+
+	// Initialize coordination between gateway replicas
+	clusterConfig := cfg.Cluster
+	if clusterConfig.Enabled {
+		redisManager, err := g.connectRedis(clusterConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize cluster coordination: %w", err)
+		}
+		b.coordinator = cluster.New(redisManager.GetClient(), &cluster.Config{
+			InstanceID:        clusterConfig.InstanceID,
+			ConfigVersion:     cfg.Version(),
+			Prefix:            clusterConfig.Prefix,
+			LeaseTTL:          clusterConfig.LeaseTTL,
+			HeartbeatInterval: clusterConfig.HeartbeatInterval,
+			CleanupInterval:   clusterConfig.CleanupInterval,
+		}, b.metricsRegistry)
+
+		g.components.Register("cluster", b.coordinator)
+
+		if b.reverseProxy != nil {
+			b.coordinator.RunAsLeader("upstream_health", clusterConfig.HealthCheckInterval, func(ctx context.Context) (any, error) {
+				return b.reverseProxy.CheckHealth(ctx), nil
+			})
+		}
+	}
+
+	// Initialize replication of keys and policies between regions
+	if federationConfig := cfg.Federation; federationConfig.Enabled {
+		redisManager, err := g.connectRedis(federationConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize federation: %w", err)
+		}
+		b.replicator = federation.New(redisManager.GetClient(), &federation.Config{
+			Region:            federationConfig.Region,
+			Prefix:            federationConfig.Prefix,
+			Interval:          federationConfig.Interval,
+			HeartbeatInterval: federationConfig.HeartbeatInterval,
+			MaxLen:            int64(federationConfig.MaxLen),
+		}, b.metricsRegistry)
+		for _, resource := range federationConfig.Resources {
+			switch resource {
+			case "api_keys":
+				b.replicator.Register(resource, &federation.APIKeys{Store: b.apiKeyStore})
+			case "exemptions":
+				if b.rateLimitExemptions != nil {
+					b.replicator.Register(resource, &federation.Exemptions{Exemptions: b.rateLimitExemptions})
+				}
+			case "penalties":
+				if b.penaltyBox != nil {
+					b.replicator.Register(resource, &federation.Penalties{Box: b.penaltyBox})
+				}
+			}
+		}
+		// Only the leader syncs, so a region publishes each change once
+		if b.coordinator != nil {
+			b.coordinator.RunAsLeader("federation", federationConfig.Interval, b.replicator.Sync)
+			g.components.Register("federation", b.replicator)
+		} else {
+			g.components.Register("federation", b.replicator, app.Hooks{
+				Start: func(context.Context) error {
+					b.replicator.Start(federationConfig.Interval)
+					return nil
+				},
+			})
+		}
+	}
+
+	// Initialize pushing of metered usage to the billing provider
+	if meteringConfig := cfg.Metering; meteringConfig.Enabled {
+		if b.chargebackRecorder == nil {
+			return errors.New("METERING_ENABLED requires CHARGEBACK_ENABLED")
+		}
+		var provider metering.Provider
+		if meteringConfig.Provider == "http" {
+			provider = metering.NewHTTPProvider(meteringConfig.HTTPURL, meteringConfig.HTTPToken, meteringConfig.Timeout)
+		} else {
+			provider = metering.NewStripeProvider(meteringConfig.StripeURL, meteringConfig.StripeAPIKey, meteringConfig.SubscriptionItems, meteringConfig.Timeout)
+		}
+		var ledger metering.Ledger
+		if meteringConfig.UseRedis {
+			redisManager, err := g.connectRedis(meteringConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize metering: %w", err)
+			}
+			ledger = metering.NewRedisLedger(redisManager.GetClient())
+		} else {
+			ledger = metering.NewMemoryLedger()
+		}
+		b.meter = metering.NewMeter(&metering.Config{
+			Quantity:     meteringConfig.Quantity,
+			MaxRetries:   meteringConfig.MaxRetries,
+			RetryBackoff: meteringConfig.RetryBackoff,
+		}, b.chargebackRecorder, provider, ledger, b.metricsRegistry)
+		// Only the leader pushes, so replicas do not report the same usage
+		if b.coordinator != nil {
+			b.coordinator.RunAsLeader("metering", meteringConfig.Interval, b.meter.Run)
+			g.components.Register("metering", b.meter)
+		} else {
+			g.components.Register("metering", b.meter, app.Hooks{
+				Start: func(context.Context) error {
+					b.meter.Start(meteringConfig.Interval)
+					return nil
+				},
+			})
+		}
+	}
+	return nil
+}
+
+// buildIdentity initializes logins, with LDAP, SAML, the device flow, devices,
+// personal access tokens, groups, SCIM, impersonation and CSRF protection
+func (g *Gateway) buildIdentity(b *built) error {
+	cfg := g.cfg
+
+	// Initialize logins, with users from LDAP if enabled
+	b.authHandler = handlers.NewAuthHandler(b.jwtManager)
+	if ldapConfig := cfg.LDAP; ldapConfig.Enabled {
+		userStore, err := ldap.NewUserStore(&ldap.Config{
+			URL:                ldapConfig.URL,
+			StartTLS:           ldapConfig.StartTLS,
+			CAFile:             ldapConfig.CAFile,
+			InsecureSkipVerify: ldapConfig.InsecureSkipVerify,
+			BindDN:             ldapConfig.BindDN,
+			BindPassword:       ldapConfig.BindPassword,
+			BaseDN:             ldapConfig.BaseDN,
+			UserFilter:         ldapConfig.UserFilter,
+			IDAttribute:        ldapConfig.IDAttribute,
+			EmailAttribute:     ldapConfig.EmailAttribute,
+			GroupAttribute:     ldapConfig.GroupAttribute,
+			RoleGroups:         ldapConfig.RoleGroups,
+			DefaultRoles:       ldapConfig.DefaultRoles,
+			PoolSize:           ldapConfig.PoolSize,
+			Timeout:            ldapConfig.Timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize LDAP: %w", err)
+		}
+		b.authHandler.SetUserStore(userStore)
+	}
+	if samlConfig := cfg.SAML; samlConfig.Enabled {
+		sp, err := saml.NewServiceProvider(&saml.Config{
+			EntityID:          samlConfig.EntityID,
+			ACSURL:            samlConfig.ACSURL(),
+			IdPEntityID:       samlConfig.IdPEntityID,
+			IdPSSOURL:         samlConfig.IdPSSOURL,
+			IdPCertFile:       samlConfig.IdPCertFile,
+			UsernameAttribute: samlConfig.UsernameAttribute,
+			EmailAttribute:    samlConfig.EmailAttribute,
+			GroupsAttribute:   samlConfig.GroupsAttribute,
+			RoleGroups:        samlConfig.RoleGroups,
+			DefaultRoles:      samlConfig.DefaultRoles,
+			AllowIdPInitiated: samlConfig.AllowIdPInitiated,
+			ClockSkew:         samlConfig.ClockSkew,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize SAML: %w", err)
+		}
+		b.samlHandler = handlers.NewSAMLHandler(sp, b.authHandler)
+	}
+	if deviceConfig := cfg.DeviceFlow; deviceConfig.Enabled {
+		kv, err := g.openStore(deviceConfig.UseRedis, deviceConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize device flow: %w", err)
+		}
+		deviceStore := device.NewKVStore(kv)
+		ssoLoginURL := ""
+		if b.samlHandler != nil {
+			ssoLoginURL = "/saml/login"
+		}
+		b.deviceHandler = handlers.NewDeviceHandler(
+			device.NewManager(deviceStore, deviceConfig.CodeLifetime, deviceConfig.PollInterval),
+			b.authHandler, deviceConfig.Clients, deviceConfig.VerificationURL, ssoLoginURL)
+	}
+	// Initialize the device registry, whose devices enroll for API keys or
+	// client certificates of their own
+	if devicesConfig := cfg.Devices; devicesConfig.Enabled {
+		kv, err := g.openStore(devicesConfig.UseRedis, devicesConfig.Redis)
+		if err != nil {
+			return fmt.Errorf("failed to initialize device registry: %w", err)
+		}
+		var authority *fleet.Authority
+		if devicesConfig.CACertFile != "" {
+			authority, err = fleet.LoadAuthority(devicesConfig.CACertFile, devicesConfig.CAKeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to initialize device registry: %w", err)
+			}
+			b.clientCAs = authority.Pool()
+		}
+		b.deviceRegistry = fleet.NewRegistry(&fleet.Config{
+			Roles:         devicesConfig.Roles,
+			KeyTTL:        devicesConfig.KeyTTL,
+			EnrollmentTTL: devicesConfig.EnrollmentTTL,
+			CertValidity:  devicesConfig.CertValidity,
+			Defaults: fleet.Policy{
+				RateCapacity:   devicesConfig.RateCapacity,
+				RateRefillRate: devicesConfig.RateRefillRate,
+				Quota:          int64(devicesConfig.Quota),
+			},
+			QuotaWindow: devicesConfig.QuotaWindow,
+		}, kv, b.apiKeyStore, authority, b.metricsRegistry)
+		g.components.Register("devices", b.deviceRegistry)
+		if authority != nil {
+			b.authHooks.Certificates = b.deviceRegistry
+		}
+		b.fleetHandler = handlers.NewFleetHandler(b.deviceRegistry)
+	}
+	if tokensConfig := cfg.PersonalTokens; tokensConfig.Enabled {
+		var tokenStore pat.Store
+		if tokensConfig.UseRedis {
+			redisManager, err := g.connectRedis(tokensConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize personal access tokens: %w", err)
+			}
+			tokenStore = pat.NewRedisStore(redisManager.GetClient())
+		} else {
+			tokenStore = pat.NewFileStore(tokensConfig.File)
+		}
+		tokenManager, err := pat.NewManager(tokenStore, b.jwtManager.Issuer(),
+			tokensConfig.MaxPerUser, tokensConfig.MaxLifetime, tokensConfig.RefreshInterval)
+		if err != nil {
+			return fmt.Errorf("failed to initialize personal access tokens: %w", err)
+		}
+		g.components.Register("personal_tokens", tokenManager)
+		b.authHooks.Tokens = tokenManager
+		b.accountTokensHandler = handlers.NewAccountTokensHandler(tokenManager)
+	}
+
+	if groupsConfig := cfg.Groups; groupsConfig.Enabled {
+		var groupStore groups.Store
+		if groupsConfig.UseRedis {
+			redisManager, err := g.connectRedis(groupsConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize groups: %w", err)
+			}
+			groupStore = groups.NewRedisStore(redisManager.GetClient())
+		} else {
+			groupStore = groups.NewFileStore(groupsConfig.File)
+		}
+		groupManager, err := groups.NewManager(groupStore, groupsConfig.RefreshInterval)
+		if err != nil {
+			return fmt.Errorf("failed to initialize groups: %w", err)
+		}
+		b.groupManager = groupManager
+		g.components.Register("groups", b.groupManager)
+
+		// Group roles are either baked into issued tokens or added to every request
+		if groupsConfig.Resolve == "issuance" {
+			b.authHandler.SetGroupResolver(b.groupManager)
+		} else {
+			b.authHooks.Groups = b.groupManager
+		}
+		b.groupsHandler = handlers.NewGroupsHandler(b.groupManager)
+	}
+	if scimConfig := cfg.SCIM; scimConfig.Enabled {
+		var userStore scim.Store
+		if scimConfig.UseRedis {
+			redisManager, err := g.connectRedis(scimConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize SCIM: %w", err)
+			}
+			userStore = scim.NewRedisStore(redisManager.GetClient())
+		} else {
+			userStore = scim.NewFileStore(scimConfig.UsersFile)
+		}
+
+		var requiredIssuers []string
+		for _, name := range scimConfig.RequiredIssuers {
+			issuer, ok := b.issuers[name]
+			if !ok {
+				return fmt.Errorf("SCIM_REQUIRED_ISSUERS: unknown issuer %q", name)
+			}
+			requiredIssuers = append(requiredIssuers, issuer.Issuer())
+		}
+		directory, err := scim.NewDirectory(userStore, scimConfig.RefreshInterval, requiredIssuers)
+		if err != nil {
+			return fmt.Errorf("failed to initialize SCIM: %w", err)
+		}
+		g.components.Register("scim", directory)
+		b.authHooks.Directory = directory
+		b.scimHandler = handlers.NewSCIMHandler(directory, b.groupManager, scimConfig.Token)
+	}
+	if cfg.Impersonation.Enabled {
+		b.impersonationHandler = handlers.NewImpersonationHandler(b.authHandler, cfg.Impersonation.MaxLifetime)
+	}
+	if csrfConfig := cfg.CSRF; csrfConfig.Enabled {
+		b.csrfProtector = csrf.New(&csrf.Config{
+			Paths:          csrfConfig.Paths,
+			SessionCookies: csrfConfig.SessionCookies,
+			CookieName:     csrfConfig.CookieName,
+			HeaderName:     csrfConfig.HeaderName,
+			TTL:            csrfConfig.TTL,
+			Secure:         csrfConfig.CookieSecure,
+			Key:            []byte(csrfConfig.SigningKey),
+		})
+		g.components.Register("csrf", b.csrfProtector)
+		b.csrfHandler = handlers.NewCSRFHandler(b.csrfProtector)
+	}
+	return nil
+}
+
+// buildRoutes registers the gateway routes. Middleware is collected per router
+// and wrapped around each route once all routes are registered.
+func (g *Gateway) buildRoutes(b *built) error {
+	cfg := g.cfg
+	g.router, g.chains = mux.NewRouter(), httputil.NewChains()
+	router, chains := g.router, g.chains
+
+	b.protectedHandler = handlers.NewProtectedHandler()
+	swaggerHandler := handlers.NewSwaggerHandler(func() []handlers.RouteDoc {
+		return upstreamRouteDocs(b.reverseProxy, cfg.RateLimit)
+	})
+	apiKeyHandler := handlers.NewAPIKeyHandler(b.apiKeyStore)
+
+	// Public routes (no authentication required)
+	router.HandleFunc("/health", b.protectedHandler.HealthCheck).Methods("GET")
+	router.HandleFunc("/ready", handlers.NewReadinessHandler(g.Ready).Ready).Methods("GET")
+	router.HandleFunc("/login", b.authHandler.Login).Methods("POST")
+
+	// Swagger documentation routes
+	if cfg.Docs.Enabled {
+		router.HandleFunc("/swagger", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/swagger/", http.StatusMovedPermanently)
+		}).Methods("GET")
+		router.HandleFunc("/swagger/", swaggerHandler.SwaggerPage).Methods("GET")
+		router.HandleFunc("/swagger/index.html", swaggerHandler.SwaggerPage).Methods("GET")
+		router.HandleFunc("/swagger/doc.json", swaggerHandler.SwaggerJSON).Methods("GET")
+		router.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/swagger/", http.StatusMovedPermanently)
+		}).Methods("GET")
+
+		// Alternative Swagger UI endpoint
+		router.HandleFunc("/swagger-ui", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/swagger/", http.StatusMovedPermanently)
+		}).Methods("GET")
+	}
+
+	// Metrics endpoint
+	if cfg.Metrics.Enabled {
+		router.Handle(cfg.Metrics.Path, b.metricsRegistry.Handler()).Methods("GET")
+	}
+
+	// SCIM provisioning endpoints (SCIM bearer token required)
+	if b.scimHandler != nil {
+		scimRoutes := router.PathPrefix("/scim/v2").Subrouter()
+		chains.Use(scimRoutes, b.scimHandler.RequireToken)
+		scimRoutes.HandleFunc("/ServiceProviderConfig", b.scimHandler.ServiceProviderConfig).Methods("GET")
+		scimRoutes.HandleFunc("/Users", b.scimHandler.ListUsers).Methods("GET")
+		scimRoutes.HandleFunc("/Users", b.scimHandler.CreateUser).Methods("POST")
+		scimRoutes.HandleFunc("/Users/{id}", b.scimHandler.GetUser).Methods("GET")
+		scimRoutes.HandleFunc("/Users/{id}", b.scimHandler.ReplaceUser).Methods("PUT")
+		scimRoutes.HandleFunc("/Users/{id}", b.scimHandler.PatchUser).Methods("PATCH")
+		scimRoutes.HandleFunc("/Users/{id}", b.scimHandler.DeleteUser).Methods("DELETE")
+		if b.groupManager != nil {
+			scimRoutes.HandleFunc("/Groups", b.scimHandler.ListGroups).Methods("GET")
+			scimRoutes.HandleFunc("/Groups", b.scimHandler.CreateGroup).Methods("POST")
+			scimRoutes.HandleFunc("/Groups/{id}", b.scimHandler.GetGroup).Methods("GET")
+			scimRoutes.HandleFunc("/Groups/{id}", b.scimHandler.ReplaceGroup).Methods("PUT")
+			scimRoutes.HandleFunc("/Groups/{id}", b.scimHandler.PatchGroup).Methods("PATCH")
+			scimRoutes.HandleFunc("/Groups/{id}", b.scimHandler.DeleteGroup).Methods("DELETE")
+		}
+	}
+
+	// SAML single sign-on endpoints (no authentication required)
+	if b.samlHandler != nil {
+		router.HandleFunc("/saml/metadata", b.samlHandler.Metadata).Methods("GET")
+		router.HandleFunc("/saml/login", b.samlHandler.Login).Methods("GET")
+		router.HandleFunc("/saml/acs", b.samlHandler.ACS).Methods("POST")
+	}
+
+	// OAuth device flow endpoints (approving a device requires a JWT)
+	if b.deviceHandler != nil {
+		router.HandleFunc("/oauth/device", b.deviceHandler.Authorize).Methods("POST")
+		router.HandleFunc("/oauth/token", b.deviceHandler.Token).Methods("POST")
+		router.HandleFunc("/oauth/device/verify", b.deviceHandler.VerifyPage).Methods("GET")
+		router.Handle("/oauth/device/verify", auth.RequireJWT(b.jwtManager, b.authHooks)(http.HandlerFunc(b.deviceHandler.Verify))).Methods("POST")
+	}
+
+	// Device enrollment endpoint (authenticated by the enrollment code)
+	if b.fleetHandler != nil {
+		router.HandleFunc("/devices/enroll", b.fleetHandler.Enroll).Methods("POST")
+	}
+
+	// CSRF token endpoint (no authentication required)
+	if b.csrfHandler != nil {
+		router.HandleFunc("/api/csrf/token", b.csrfHandler.IssueToken).Methods("GET")
+	}
+
+	// API Key test endpoint (no authentication required)
+	router.HandleFunc("/api/keys/test", apiKeyHandler.TestAPIKey).Methods("GET")
+
+	// Rate limiting endpoints
+	if b.rateLimitMiddleware != nil {
+		rateLimitHandler := handlers.NewRateLimitHandler(b.rateLimitMiddleware, b.capturer)
+		router.HandleFunc("/api/ratelimit/headers", rateLimitHandler.GetRateLimitHeaders).Methods("GET")
+
+		// Rate limiting management endpoints (JWT required)
+		rateLimitRoutes := router.PathPrefix("/api/ratelimit").Subrouter()
+		chains.Use(rateLimitRoutes, auth.RequireJWT(b.jwtManager, b.authHooks))
+		if b.policyMiddleware != nil {
+			chains.Use(rateLimitRoutes, b.policyMiddleware)
+		}
+		rateLimitRoutes.HandleFunc("/stats", rateLimitHandler.GetStats).Methods("GET")
+		rateLimitRoutes.HandleFunc("/test", rateLimitHandler.TestRateLimit).Methods("POST")
+		rateLimitRoutes.HandleFunc("/status", rateLimitHandler.GetClientStatus).Methods("GET")
+		rateLimitRoutes.HandleFunc("/reset", rateLimitHandler.ResetClientRateLimit).Methods("POST")
+		rateLimitRoutes.HandleFunc("/simulate", rateLimitHandler.Simulate).Methods("POST")
+	}
+
+	// Routes added by programs embedding the gateway take precedence over
+	// proxied upstreams
+	g.embedded = router.NewRoute().Subrouter()
+	if err := g.buildProxyRoutes(b); err != nil {
+		return err
+	}
+
+	// Protected routes (JWT or API Key authentication required)
+	protected := router.PathPrefix("/api").Subrouter()
+	chains.Use(protected, b.requireAuth(b.jwtManager))
+	if b.policyMiddleware != nil {
+		chains.Use(protected, b.policyMiddleware)
+	}
+
+	// Authentication endpoints
+	protected.HandleFunc("/profile", b.authHandler.Profile).Methods("GET")
+	protected.HandleFunc("/refresh", b.authHandler.RefreshToken).Methods("POST")
+	if b.dispatcher != nil {
+		asyncHandler := handlers.NewAsyncHandler(b.dispatcher)
+		protected.HandleFunc("/async/{id}", asyncHandler.GetJob).Methods("GET")
+	}
+
+	// API Key management endpoints (JWT only)
+	apiKeyRoutes := router.PathPrefix("/api/keys").Subrouter()
+	chains.Use(apiKeyRoutes, auth.RequireJWT(b.jwtManager, b.authHooks))
+	if b.policyMiddleware != nil {
+		chains.Use(apiKeyRoutes, b.policyMiddleware)
+	}
+	apiKeyRoutes.HandleFunc("", apiKeyHandler.CreateAPIKey).Methods("POST")
+	apiKeyRoutes.HandleFunc("", apiKeyHandler.ListAPIKeys).Methods("GET")
+	apiKeyRoutes.HandleFunc("/stats", apiKeyHandler.GetAPIKeyStats).Methods("GET")
+	apiKeyRoutes.HandleFunc("/{key}", apiKeyHandler.GetAPIKey).Methods("GET")
+	apiKeyRoutes.HandleFunc("/{key}/revoke", apiKeyHandler.RevokeAPIKey).Methods("POST")
+	apiKeyRoutes.HandleFunc("/{key}", apiKeyHandler.DeleteAPIKey).Methods("DELETE")
+	apiKeyRoutes.HandleFunc("/{key}/restore", apiKeyHandler.RestoreAPIKey).Methods("POST")
+
+	// Personal access token endpoints (JWT only)
+	if b.accountTokensHandler != nil {
+		accountTokenRoutes := router.PathPrefix("/api/account/tokens").Subrouter()
+		chains.Use(accountTokenRoutes, auth.RequireJWT(b.jwtManager, b.authHooks))
+		if b.policyMiddleware != nil {
+			chains.Use(accountTokenRoutes, b.policyMiddleware)
+		}
+		accountTokenRoutes.HandleFunc("", b.accountTokensHandler.ListTokens).Methods("GET")
+		accountTokenRoutes.HandleFunc("", b.accountTokensHandler.CreateToken).Methods("POST")
+		accountTokenRoutes.HandleFunc("/{id}", b.accountTokensHandler.RevokeToken).Methods("DELETE")
+	}
+
+	// Developer portal endpoints (JWT only)
+	if cfg.Portal.Enabled {
+		portalHandler := handlers.NewPortalHandler(cfg.Portal, b.productCatalog, b.apiKeyStore, b.transferMetrics)
+		portalRoutes := router.PathPrefix("/api/portal").Subrouter()
+		chains.Use(portalRoutes, auth.RequireJWT(b.jwtManager, b.authHooks))
+		if b.policyMiddleware != nil {
+			chains.Use(portalRoutes, b.policyMiddleware)
+		}
+		portalRoutes.HandleFunc("/products", portalHandler.ListProducts).Methods("GET")
+		portalRoutes.HandleFunc("/keys", portalHandler.CreateKey).Methods("POST")
+		portalRoutes.HandleFunc("/keys", portalHandler.ListKeys).Methods("GET")
+		portalRoutes.HandleFunc("/keys/{key}/revoke", portalHandler.RevokeKey).Methods("POST")
+		portalRoutes.HandleFunc("/keys/{key}/products", portalHandler.Subscribe).Methods("PUT")
+		portalRoutes.HandleFunc("/usage", portalHandler.GetUsage).Methods("GET")
+	}
+
+	// Role-based protected routes
+	protected.HandleFunc("/user", b.protectedHandler.UserOnly).Methods("GET")
+	if b.flagManager != nil {
+		flagsHandler := handlers.NewFlagsHandler(b.flagManager)
+		protected.HandleFunc("/flags", flagsHandler.CallerFlags).Methods("GET")
+	}
+
+	// Moderator-only routes
+	moderatorRoutes := protected.PathPrefix("/moderator").Subrouter()
+	chains.Use(moderatorRoutes, b.requireRoles("moderator"))
+	moderatorRoutes.HandleFunc("", b.protectedHandler.ModeratorOnly).Methods("GET")
+
+	if err := g.buildAdmin(b, protected); err != nil {
+		return err
+	}
+
+	// Mixed role routes (admin or moderator)
+	mixedRoutes := protected.PathPrefix("/mixed").Subrouter()
+	chains.Use(mixedRoutes, b.requireRoles("admin", "moderator"))
+	mixedRoutes.HandleFunc("", b.protectedHandler.MixedRoles).Methods("GET")
+	return nil
+}
+
+// buildProxyRoutes registers the proxied upstream routes (JWT or API Key
+// authentication required)
+func (g *Gateway) buildProxyRoutes(b *built) error {
+	cfg := g.cfg
+	if b.reverseProxy == nil {
+		return nil
+	}
+
+	var proxyHandler http.Handler = b.reverseProxy
+	if b.uploadScanner != nil {
+		proxyHandler = b.uploadScanner.Handler()(proxyHandler)
+	}
+	if b.streamLimiter != nil {
+		proxyHandler = b.streamLimiter.Middleware()(proxyHandler)
+	}
+	// Inside authorization, so cached responses are only served to
+	// callers allowed to reach the route
+	if b.responseCache != nil {
+		proxyHandler = b.responseCache.Middleware()(proxyHandler)
+	}
+	// Outside the cache, so cached responses are answered with 304s too
+	if b.conditionalValidator != nil {
+		proxyHandler = b.conditionalValidator.Middleware()(proxyHandler)
+	}
+	// Each route accepts tokens only from the issuers it trusts
+	routeValidators := make(map[string]auth.TokenValidator, len(cfg.Proxy.Upstreams))
+	for _, upstreamConfig := range cfg.Proxy.Upstreams {
+		var trusted []*auth.JWTManager
+		for _, name := range upstreamConfig.JWT.Issuers {
+			issuer, ok := b.issuers[name]
+			if !ok {
+				return fmt.Errorf("upstream %s trusts unknown JWT issuer %q", upstreamConfig.Name, name)
+			}
+			if upstreamConfig.JWT.Audience != "" {
+				issuer = issuer.WithAudience(upstreamConfig.JWT.Audience)
+			}
+			trusted = append(trusted, issuer)
+		}
+		routeValidators[upstreamConfig.Name] = auth.NewTrustedIssuers(trusted...)
+	}
+	// Webhook routes are authenticated by their provider's signature
+	// instead of gateway credentials
+	var webhookReceiver *webhook.Receiver
+	webhookRoutes := make(map[string]func(http.Handler) http.Handler)
+	for _, upstreamConfig := range cfg.Proxy.Upstreams {
+		if upstreamConfig.Type != "webhook" {
+			continue
+		}
+		if webhookReceiver == nil {
+			kv, err := g.openStore(cfg.Webhooks.UseRedis, cfg.Webhooks.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize webhook deliveries: %w", err)
+			}
+			webhookReceiver = webhook.NewReceiver(kv, b.metricsRegistry)
+		}
+		webhookConfig := upstreamConfig.Webhook
+		verify, err := webhookReceiver.Middleware(&webhook.Route{
+			Name:        upstreamConfig.Name,
+			Provider:    webhookConfig.Provider,
+			Secrets:     webhookConfig.Secrets,
+			Tolerance:   webhookConfig.Tolerance,
+			DedupeTTL:   webhookConfig.DedupeTTL,
+			MaxBodySize: int64(webhookConfig.MaxBodySize),
+		})
+		if err != nil {
+			return fmt.Errorf("upstream %s: %w", upstreamConfig.Name, err)
+		}
+		webhookRoutes[upstreamConfig.Name] = verify
+	}
+	asyncModes := make(map[string]string, len(cfg.Proxy.Upstreams))
+	for _, upstreamConfig := range cfg.Proxy.Upstreams {
+		asyncModes[upstreamConfig.Name] = upstreamConfig.Async
+	}
+	for _, upstream := range b.reverseProxy.Upstreams() {
+		routeHandler := proxyHandler
+		// Requests are queued once authorized, and run as their caller
+		if mode := asyncModes[upstream.Name]; b.dispatcher != nil && mode != async.ModeOff {
+			routeHandler = b.dispatcher.Middleware(upstream.Name, mode)(routeHandler)
+		}
+		if b.policyMiddleware != nil {
+			routeHandler = b.policyMiddleware(routeHandler)
+		}
+		// Device limits apply to messages bridged from MQTT too
+		if b.deviceRegistry != nil {
+			routeHandler = b.deviceRegistry.Middleware()(routeHandler)
+		}
+		if verify, ok := webhookRoutes[upstream.Name]; ok {
+			routeHandler = verify(routeHandler)
+		} else {
+			routeHandler = b.requireAuth(routeValidators[upstream.Name])(routeHandler)
+		}
+		prefix := upstream.PathPrefix
+		g.router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+			return proxy.HasPathPrefix(r.URL.Path, prefix)
+		}).Handler(routeHandler)
+	}
+	return nil
+}
+
+// buildAdmin registers the admin-only routes under api and initializes the
+// gRPC admin API, which manages the same components
+func (g *Gateway) buildAdmin(b *built, api *mux.Router) error {
+	cfg := g.cfg
+	chains := g.chains
+
+	// Admin-only routes
+	adminRoutes := api.PathPrefix("/admin").Subrouter()
+	chains.Use(adminRoutes, b.requireRoles("admin"))
+	adminRoutes.HandleFunc("", b.protectedHandler.AdminOnly).Methods("GET")
+	adminRoutes.HandleFunc("/metrics/transfer", handlers.NewMetricsHandler(b.transferMetrics).GetTransferStats).Methods("GET")
+	if b.experimentAssigner != nil {
+		experimentsHandler := handlers.NewExperimentsHandler(b.experimentAssigner)
+		adminRoutes.HandleFunc("/metrics/experiments", experimentsHandler.GetExperimentStats).Methods("GET")
+	}
+	if b.anomalyDetector != nil {
+		anomaliesHandler := handlers.NewAnomaliesHandler(b.anomalyDetector)
+		adminRoutes.HandleFunc("/metrics/anomalies", anomaliesHandler.GetAnomalies).Methods("GET")
+	}
+	if b.sloTracker != nil {
+		sloHandler := handlers.NewSLOHandler(b.sloTracker)
+		adminRoutes.HandleFunc("/slo", sloHandler.GetStatus).Methods("GET")
+	}
+	adminRoutes.HandleFunc("/config", handlers.NewConfigHandler(cfg).GetConfig).Methods("GET")
+	stateHandler := handlers.NewStateHandler(state.NewManager(cfg, b.apiKeyStore, []byte(cfg.State.SigningKey)))
+	adminRoutes.HandleFunc("/state/export", stateHandler.ExportState).Methods("GET")
+	adminRoutes.HandleFunc("/state/import", stateHandler.ImportState).Methods("POST")
+	adminRoutes.HandleFunc("/apply", stateHandler.ApplySpec).Methods("PUT")
+	if b.groupsHandler != nil {
+		readGroups := func(handler http.HandlerFunc) http.Handler { return auth.Require("groups:read")(handler) }
+		writeGroups := func(handler http.HandlerFunc) http.Handler { return auth.Require("groups:write")(handler) }
+		adminRoutes.Handle("/groups", readGroups(b.groupsHandler.ListGroups)).Methods("GET")
+		adminRoutes.Handle("/groups", writeGroups(b.groupsHandler.CreateGroup)).Methods("POST")
+		adminRoutes.Handle("/groups/{name}", readGroups(b.groupsHandler.GetGroup)).Methods("GET")
+		adminRoutes.Handle("/groups/{name}", writeGroups(b.groupsHandler.UpdateGroup)).Methods("PUT")
+		adminRoutes.Handle("/groups/{name}", writeGroups(b.groupsHandler.DeleteGroup)).Methods("DELETE")
+		adminRoutes.Handle("/groups/{name}/members/{user_id}", writeGroups(b.groupsHandler.AddMember)).Methods("PUT")
+		adminRoutes.Handle("/groups/{name}/members/{user_id}", writeGroups(b.groupsHandler.RemoveMember)).Methods("DELETE")
+	}
+	if b.flagManager != nil {
+		flagsHandler := handlers.NewFlagsHandler(b.flagManager)
+		readFlags := func(handler http.HandlerFunc) http.Handler { return auth.Require("flags:read")(handler) }
+		writeFlags := func(handler http.HandlerFunc) http.Handler { return auth.Require("flags:write")(handler) }
+		adminRoutes.Handle("/flags", readFlags(flagsHandler.ListFlags)).Methods("GET")
+		adminRoutes.Handle("/flags/{name}", readFlags(flagsHandler.GetFlag)).Methods("GET")
+		adminRoutes.Handle("/flags/{name}", writeFlags(flagsHandler.UpdateFlag)).Methods("PUT")
+		adminRoutes.Handle("/flags/{name}", writeFlags(flagsHandler.ResetFlag)).Methods("DELETE")
+	}
+	if b.rateLimitExemptions != nil {
+		exemptionsHandler := handlers.NewExemptionsHandler(b.rateLimitExemptions)
+		adminRoutes.Handle("/ratelimit/exemptions", auth.Require("ratelimit:read")(http.HandlerFunc(exemptionsHandler.ListExemptions))).Methods("GET")
+		adminRoutes.Handle("/ratelimit/exemptions", auth.Require("ratelimit:write")(http.HandlerFunc(exemptionsHandler.AddExemption))).Methods("POST")
+		adminRoutes.Handle("/ratelimit/exemptions/{id}", auth.Require("ratelimit:write")(http.HandlerFunc(exemptionsHandler.RemoveExemption))).Methods("DELETE")
+	}
+	if b.fleetHandler != nil {
+		readDevices := func(handler http.HandlerFunc) http.Handler { return auth.Require("devices:read")(handler) }
+		writeDevices := func(handler http.HandlerFunc) http.Handler { return auth.Require("devices:write")(handler) }
+		adminRoutes.Handle("/devices", readDevices(b.fleetHandler.ListDevices)).Methods("GET")
+		adminRoutes.Handle("/devices", writeDevices(b.fleetHandler.CreateDevice)).Methods("POST")
+		adminRoutes.Handle("/devices/{id}", readDevices(b.fleetHandler.GetDevice)).Methods("GET")
+		adminRoutes.Handle("/devices/{id}", writeDevices(b.fleetHandler.DeleteDevice)).Methods("DELETE")
+		adminRoutes.Handle("/devices/{id}/policy", writeDevices(b.fleetHandler.SetDevicePolicy)).Methods("PUT")
+		adminRoutes.Handle("/devices/{id}/enrollment", writeDevices(b.fleetHandler.NewDeviceEnrollment)).Methods("POST")
+		adminRoutes.Handle("/devices/{id}/revoke", writeDevices(b.fleetHandler.RevokeDevice)).Methods("POST")
+	}
+	if b.chargebackRecorder != nil {
+		chargebackHandler := handlers.NewChargebackHandler(b.chargebackRecorder)
+		adminRoutes.Handle("/chargeback", auth.Require("chargeback:read")(http.HandlerFunc(chargebackHandler.GetChargeback))).Methods("GET")
+	}
+	if b.meter != nil {
+		meteringHandler := handlers.NewMeteringHandler(b.meter)
+		adminRoutes.Handle("/metering/reconciliation", auth.Require("chargeback:read")(http.HandlerFunc(meteringHandler.GetReconciliation))).Methods("GET")
+	}
+	if b.penaltyBox != nil {
+		penaltyHandler := handlers.NewPenaltyHandler(b.penaltyBox)
+		adminRoutes.Handle("/penalties", auth.Require("ratelimit:read")(http.HandlerFunc(penaltyHandler.ListPenalties))).Methods("GET")
+		adminRoutes.Handle("/penalties/{client}", auth.Require("ratelimit:write")(http.HandlerFunc(penaltyHandler.ReleasePenalty))).Methods("DELETE")
+	}
+	if b.impersonationHandler != nil {
+		adminRoutes.Handle("/impersonate", auth.Require("users:impersonate")(http.HandlerFunc(b.impersonationHandler.Impersonate))).Methods("POST")
+	}
+	if b.capturer != nil {
+		captureHandler := handlers.NewCaptureHandler(b.capturer, capture.NewReplayer(cfg.Capture.ReplayTimeout))
+		adminRoutes.HandleFunc("/capture", captureHandler.StartCapture).Methods("POST")
+		adminRoutes.HandleFunc("/capture", captureHandler.ListCaptures).Methods("GET")
+		adminRoutes.HandleFunc("/capture/{id}", captureHandler.GetCapture).Methods("GET")
+		adminRoutes.HandleFunc("/capture/{id}", captureHandler.DeleteCapture).Methods("DELETE")
+		adminRoutes.HandleFunc("/capture/{id}/stop", captureHandler.StopCapture).Methods("POST")
+		adminRoutes.HandleFunc("/capture/{id}/replay", captureHandler.ReplayCapture).Methods("POST")
+	}
+	if b.debugLogger != nil {
+		debugLogHandler := handlers.NewDebugLogHandler(b.debugLogger)
+		adminRoutes.HandleFunc("/debug/logging", debugLogHandler.EnableDebugLog).Methods("POST")
+		adminRoutes.HandleFunc("/debug/logging", debugLogHandler.ListDebugLogs).Methods("GET")
+		adminRoutes.HandleFunc("/debug/logging/{id}", debugLogHandler.DisableDebugLog).Methods("DELETE")
+	}
+	// Synthetic checks request proxied routes through the router itself
+	if diagnosticsConfig := cfg.Diagnostics; diagnosticsConfig.Enabled {
+		proxyPath := diagnosticsConfig.ProxyPath
+		if proxyPath == "" && len(cfg.Proxy.Upstreams) > 0 {
+			proxyPath = cfg.Proxy.Upstreams[0].PathPrefix
+		}
+		var limiter diagnostics.RateLimiter
+		if b.rateLimitMiddleware != nil {
+			limiter = b.rateLimitMiddleware
+		}
+		diagnosticsHandler := handlers.NewDiagnosticsHandler(diagnostics.NewRunner(diagnosticsConfig.Timeout,
+			diagnostics.TokenCheck(b.jwtManager),
+			diagnostics.ProxyCheck(g.router, proxyPath, b.jwtManager),
+			diagnostics.RateLimitCheck(limiter),
+			diagnostics.RedisCheck(func() map[string]diagnostics.Pinger {
+				connections := make(map[string]diagnostics.Pinger)
+				if g.redis != nil {
+					for address, conn := range g.redis.byAddress {
+						connections[address] = conn
+					}
+				}
+				return connections
+			}),
+		))
+		adminRoutes.HandleFunc("/diagnostics", diagnosticsHandler.RunDiagnostics).Methods("GET")
+	}
+	if b.tailRecorder != nil {
+		tailCaptureHandler := handlers.NewTailCaptureHandler(b.tailRecorder)
+		adminRoutes.HandleFunc("/debug/recent-errors", tailCaptureHandler.ListRecentErrors).Methods("GET")
+		adminRoutes.HandleFunc("/debug/recent-errors/{id}", tailCaptureHandler.GetRecentError).Methods("GET")
+	}
+	if b.faultInjector != nil {
+		chaosHandler := handlers.NewChaosHandler(b.faultInjector)
+		adminRoutes.HandleFunc("/chaos/faults", chaosHandler.AddFault).Methods("POST")
+		adminRoutes.HandleFunc("/chaos/faults", chaosHandler.ListFaults).Methods("GET")
+		adminRoutes.HandleFunc("/chaos/faults/{id}", chaosHandler.RemoveFault).Methods("DELETE")
+	}
+	if b.shedder != nil {
+		sheddingHandler := handlers.NewSheddingHandler(b.shedder)
+		adminRoutes.HandleFunc("/shedding", sheddingHandler.GetStatus).Methods("GET")
+	}
+	if b.connLimiter != nil {
+		connLimitHandler := handlers.NewConnLimitHandler(b.connLimiter)
+		adminRoutes.HandleFunc("/connections", connLimitHandler.GetStats).Methods("GET")
+	}
+	if b.loadTracker != nil {
+		autoscaleHandler := handlers.NewAutoscaleHandler(b.loadTracker)
+		adminRoutes.HandleFunc("/load", autoscaleHandler.GetLoad).Methods("GET")
+	}
+	if b.coordinator != nil {
+		clusterHandler := handlers.NewClusterHandler(b.coordinator)
+		adminRoutes.HandleFunc("/cluster", clusterHandler.GetStatus).Methods("GET")
+	}
+	if b.replicator != nil {
+		federationHandler := handlers.NewFederationHandler(b.replicator)
+		adminRoutes.HandleFunc("/federation", federationHandler.GetStatus).Methods("GET")
+	}
+	if b.responseCache != nil {
+		cacheHandler := handlers.NewCacheHandler(b.responseCache)
+		adminRoutes.Handle("/cache/purge", auth.Require("cache:purge")(http.HandlerFunc(cacheHandler.Purge))).Methods("POST")
+	}
+	if b.requestFirewall != nil {
+		wafHandler := handlers.NewWAFHandler(b.requestFirewall)
+		adminRoutes.HandleFunc("/waf/stats", wafHandler.GetStats).Methods("GET")
+	}
+
+	// Initialize the gRPC admin API, which manages the same components as
+	// the REST admin endpoints
+	if adminConfig := cfg.AdminGRPC; adminConfig.Enabled {
+		adminServer, err := newAdminGRPCServer(adminConfig, cfg.Server, &admingrpc.Config{
+			Gateway:       cfg,
+			Version:       Version,
+			Tokens:        b.jwtManager,
+			Hooks:         b.authHooks,
+			APIKeys:       b.apiKeyStore,
+			Proxy:         b.reverseProxy,
+			Cluster:       b.coordinator,
+			Exemptions:    b.rateLimitExemptions,
+			Penalties:     b.penaltyBox,
+			WatchInterval: adminConfig.WatchInterval,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize the gRPC admin API: %w", err)
+		}
+		b.adminServer = adminServer
+		g.components.Register("admin_grpc", b.adminServer)
+	}
+	return nil
+}
+
+// buildMiddleware adds the gateway's global middleware, run for every route
+// in the order added
+func (g *Gateway) buildMiddleware(b *built) error {
+	cfg := g.cfg
+	router, chains := g.router, g.chains
+
+	// Add CORS middleware
+	allowHeaders := "Content-Type, Authorization, X-API-Key, Idempotency-Key"
+	if cfg.Replay.Enabled {
+		allowHeaders += ", " + cfg.Replay.TimestampHeader + ", " + cfg.Replay.NonceHeader
+	}
+	if cfg.CSRF.Enabled {
+		allowHeaders += ", " + cfg.CSRF.HeaderName
+	}
+	// Values that never change are built once and shared by every response;
+	// the slices are full, so appending to one copies it
+	allowMethodsValue := []string{"GET, POST, PUT, DELETE, OPTIONS"}
+	allowHeadersValue := []string{allowHeaders}
+	corsHandler := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if origin := allowedOrigin(cfg.CORS, r.Header.Get("Origin")); origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if origin != "*" {
+					w.Header().Add("Vary", "Origin")
+				}
+				if cfg.CORS.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			w.Header()["Access-Control-Allow-Methods"] = allowMethodsValue
+			w.Header()["Access-Control-Allow-Headers"] = allowHeadersValue
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	// Track request/response transfer sizes
+	chains.Use(router, b.transferMetrics.Middleware())
+
+	// Send sampled request durations to StatsD if enabled
+	if b.statsdExporter != nil {
+		chains.Use(router, b.statsdExporter.Middleware())
+	}
+
+	// Trace requests and retain the slow and failed ones if enabled
+	if b.tailRecorder != nil {
+		chains.Use(router, b.tailRecorder.Middleware())
+	}
+
+	// Score traffic per route and consumer for anomalies if enabled
+	if b.anomalyDetector != nil {
+		chains.Use(router, b.anomalyDetector.Middleware())
+	}
+
+	// Count requests against per-route SLOs
+	if b.sloTracker != nil {
+		chains.Use(router, b.sloTracker.Middleware())
+	}
+
+	// Abort requests that exceed their latency budget with a diagnostic 504
+	if b.budgetEnforcer != nil {
+		chains.Use(router, b.budgetEnforcer.Middleware())
+	}
+
+	// Accrue monthly usage per consumer for chargeback if enabled
+	if b.chargebackRecorder != nil {
+		chains.Use(router, b.chargebackRecorder.Middleware())
+	}
+
+	// Rewrite response headers per route if enabled
+	headersConfig := cfg.Headers
+	if headersConfig.Enabled {
+		policies := make([]*headers.Policy, 0, len(headersConfig.Routes))
+		for _, policyConfig := range headersConfig.Routes {
+			policies = append(policies, newHeaderPolicy(policyConfig))
+		}
+		chains.Use(router, headers.NewRewriter(&headers.Config{
+			Default: newHeaderPolicy(headersConfig.Default),
+			Routes:  policies,
+		}).Middleware())
+	}
+
+	// Render 4xx/5xx responses from templates if enabled
+	errorPagesConfig := cfg.ErrorPages
+	if errorPagesConfig.Enabled {
+		errorPages := &errorpages.Config{Routes: make(map[string]*errorpages.Set)}
+		var err error
+		if errorPagesConfig.Dir != "" {
+			errorPages.Default, err = errorpages.Load(errorPagesConfig.Dir)
+			if err != nil {
+				return fmt.Errorf("failed to initialize error pages: %w", err)
+			}
+		}
+		for prefix, dir := range errorPagesConfig.Routes {
+			errorPages.Routes[prefix], err = errorpages.Load(dir)
+			if err != nil {
+				return fmt.Errorf("failed to initialize error pages for %s: %w", prefix, err)
+			}
+		}
+		chains.Use(router, errorpages.NewRenderer(errorPages).Middleware())
+	}
+
+	// Limit or ban clients that keep getting rejected, before they reach anything else
+	if b.penaltyBox != nil {
+		chains.Use(router, b.penaltyBox.Middleware())
+	}
+
+	// Hide routes behind feature flags and tell upstreams which flags are on
+	if b.flagManager != nil {
+		chains.Use(router, b.flagManager.Middleware())
+	}
+
+	// Assign callers to experiment variants and tell upstreams which they got
+	if b.experimentAssigner != nil {
+		chains.Use(router, b.experimentAssigner.Middleware())
+	}
+
+	// Measure load for autoscalers, including time spent queued
+	if b.loadTracker != nil {
+		chains.Use(router, b.loadTracker.Middleware())
+	}
+
+	// Reject low-priority traffic first when the gateway is overloaded
+	if b.shedder != nil {
+		chains.Use(router, b.shedder.Middleware())
+	}
+
+	// Queue requests by route priority once the concurrency limit is reached
+	if b.requestQueue != nil {
+		chains.Use(router, b.requestQueue.Middleware())
+	}
+
+	// Limit response bandwidth per consumer if enabled
+	if b.throttler != nil {
+		chains.Use(router, b.throttler.Middleware())
+	}
+
+	// Log matching requests in detail while debug rules are active
+	if b.debugLogger != nil {
+		chains.Use(router, b.debugLogger.Middleware())
+	}
+
+	// Inject configured faults if enabled
+	if b.faultInjector != nil {
+		chains.Use(router, b.faultInjector.Middleware())
+	}
+
+	// Require API keys to be subscribed to the products of the routes they call
+	if len(b.products) > 0 {
+		chains.Use(router, b.productCatalog.Middleware())
+	}
+
+	// Apply rate limiting middleware if enabled
+	if b.rateLimitMiddleware != nil {
+		chains.Use(router, b.rateLimitMiddleware.Middleware())
+	}
+
+	// Apply request inspection if enabled
+	if b.requestFirewall != nil {
+		chains.Use(router, b.requestFirewall.Middleware())
+	}
+
+	// Require a fresh timestamp and unused nonce on high-security routes if enabled
+	replayConfig := cfg.Replay
+	if replayConfig.Enabled {
+		var nonceStore antireplay.Store
+		if replayConfig.UseRedis {
+			redisManager, err := g.connectRedis(replayConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize replay protection: %w", err)
+			}
+			nonceStore = antireplay.NewRedisStore(redisManager.GetClient())
+		} else {
+			nonceStore = antireplay.NewMemoryStore()
+		}
+		chains.Use(router, antireplay.Middleware(nonceStore, &antireplay.Config{
+			Paths:           replayConfig.Paths,
+			TimestampHeader: replayConfig.TimestampHeader,
+			NonceHeader:     replayConfig.NonceHeader,
+			MaxSkew:         replayConfig.MaxSkew,
+			FailOpen:        replayConfig.FailOpen,
+		}))
+	}
+
+	// Require a CSRF token on cookie-authenticated state-changing requests if enabled
+	if b.csrfProtector != nil {
+		chains.Use(router, b.csrfProtector.Middleware())
+	}
+
+	// Apply CORS to all routes
+	chains.Use(router, corsHandler)
+
+	// Apply response compression if enabled
+	compressionConfig := cfg.Compression
+	if compressionConfig.Enabled {
+		chains.Use(router, compression.Middleware(&compression.Config{
+			MinSize:      compressionConfig.MinSize,
+			Level:        compressionConfig.Level,
+			ContentTypes: compressionConfig.ContentTypes,
+			Encodings:    compressionConfig.Encodings,
+		}))
+	}
+
+	// Mask personal data in JSON responses for callers without the unmasking
+	// roles; inside compression so bodies are inspected before encoding, and
+	// outside idempotency and coalescing so shared responses are masked per caller
+	maskingConfig := cfg.Masking
+	if maskingConfig.Enabled {
+		rules := make([]*masking.Rule, 0, len(maskingConfig.Rules))
+		for _, ruleConfig := range maskingConfig.Rules {
+			rule := &masking.Rule{
+				Name:        ruleConfig.Name,
+				Paths:       ruleConfig.Paths,
+				UnmaskRoles: ruleConfig.UnmaskRoles,
+				Mask:        ruleConfig.Mask,
+			}
+			for _, field := range ruleConfig.Fields {
+				selector, err := masking.ParseSelector(field)
+				if err != nil {
+					return fmt.Errorf("failed to initialize masking rule %s: %w", ruleConfig.Name, err)
+				}
+				rule.Fields = append(rule.Fields, selector)
+			}
+			rules = append(rules, rule)
+		}
+		chains.Use(router, masking.NewMasker(&masking.Config{Rules: rules}).Middleware())
+	}
+
+	// Record sampled traffic for active capture sessions
+	if b.capturer != nil {
+		chains.Use(router, b.capturer.Middleware())
+	}
+
+	// Apply Idempotency-Key handling if enabled
+	idempotencyConfig := cfg.Idempotency
+	if idempotencyConfig.Enabled {
+		var idempotencyStore idempotency.Store
+		if idempotencyConfig.UseRedis {
+			redisManager, err := g.connectRedis(idempotencyConfig.Redis)
+			if err != nil {
+				return fmt.Errorf("failed to initialize idempotency store: %w", err)
+			}
+			idempotencyStore = idempotency.NewRedisStore(redisManager.GetClient())
+		} else {
+			idempotencyStore = idempotency.NewMemoryStore()
+		}
+
+		chains.Use(router, idempotency.Middleware(idempotencyStore, &idempotency.Config{
+			TTL:            idempotencyConfig.TTL,
+			Methods:        idempotencyConfig.Methods,
+			MaxBodySize:    idempotencyConfig.MaxBodySize,
+			MaxRequestSize: idempotencyConfig.MaxRequestSize,
+			// Keys belong to the caller, however its retries authenticate
+			Identify: func(r *http.Request) string {
+				userCtx := b.peekIdentity(r)
+				if userCtx == nil {
+					return ""
+				}
+				if userCtx.APIKey != nil {
+					return "apikey:" + userCtx.APIKey.Key
+				}
+				return "user:" + userCtx.UserID
+			},
+		}))
+	}
+
+	// Coalesce identical in-flight GET requests if enabled
+	coalesceConfig := cfg.Coalesce
+	if coalesceConfig.Enabled {
+		coalescer := coalesce.NewCoalescer(&coalesce.Config{
+			PathPrefixes: coalesceConfig.PathPrefixes,
+		}, b.metricsRegistry)
+		chains.Use(router, coalescer.Middleware())
+	}
+	return nil
+}
+
+// buildListeners initializes the listeners served next to the HTTP server
+func (g *Gateway) buildListeners(b *built) error {
+	cfg := g.cfg
+
+	// Initialize the MQTT listener, which bridges device messages to routes
+	if mqttConfig := cfg.MQTT; mqttConfig.Enabled {
+		mqttServer, err := g.newMQTTServer(mqttConfig, b.tokenValidator, b.apiKeyStore, b.authHooks, b.metricsRegistry)
+		if err != nil {
+			return fmt.Errorf("failed to initialize MQTT: %w", err)
+		}
+		b.mqttServer = mqttServer
+		g.components.Register("mqtt", b.mqttServer)
+	}
+	return nil
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request origin, or ""
+func allowedOrigin(cors config.CORSConfig, origin string) string {
+	if cors.AllowsAnyOrigin() {
+		// Browsers reject "*" for credentialed requests, so echo the origin instead
+		if cors.AllowCredentials && origin != "" {
+			return origin
+		}
+		return "*"
+	}
+	for _, allowed := range cors.AllowedOrigins {
+		if origin == allowed {
+			return origin
+		}
+	}
+	return ""
+}
+
+// parsePriority converts a configured priority name, falling back to normal
+func parsePriority(name string) shedding.Priority {
+	priority, err := shedding.ParsePriority(name)
+	if err != nil {
+		log.Printf("Invalid shedding priority %q, using normal", name)
+	}
+	return priority
+}
+
+// parsePriorities converts configured priority names keyed by route or role
+func parsePriorities(names map[string]string) map[string]shedding.Priority {
+	priorities := make(map[string]shedding.Priority, len(names))
+	for key, name := range names {
+		priorities[key] = parsePriority(name)
+	}
+	return priorities
+}
+
+// newUpstreams builds the proxy upstreams with their outbound credentials, TLS, pool, load balancing and response validation settings
+func newUpstreams(cfg *config.ProxyConfig, redactionConfig *config.RedactionConfig, reg *metrics.Registry) ([]*proxy.Upstream, error) {
+	upstreams := make([]*proxy.Upstream, 0, len(cfg.Upstreams))
+	var brokerMetrics *broker.Metrics
+	for _, upstreamConfig := range cfg.Upstreams {
+		target, err := url.Parse(upstreamConfig.URL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("upstream %s: invalid URL %q", upstreamConfig.Name, upstreamConfig.URL)
+		}
+
+		pool := upstreamConfig.Pool
+		upstream := &proxy.Upstream{
+			Name:        upstreamConfig.Name,
+			Target:      target,
+			PathPrefix:  upstreamConfig.PathPrefix,
+			StripPrefix: upstreamConfig.StripPrefix,
+			Transport: proxy.TransportSettings{
+				MaxIdleConns:          pool.MaxIdleConns,
+				MaxIdleConnsPerHost:   pool.MaxIdleConnsPerHost,
+				MaxConnsPerHost:       pool.MaxConnsPerHost,
+				IdleConnTimeout:       pool.IdleConnTimeout,
+				TLSHandshakeTimeout:   pool.TLSHandshakeTimeout,
+				ResponseHeaderTimeout: upstreamConfig.Timeout,
+				DisableKeepAlives:     pool.DisableKeepAlives,
+				DialTimeout:           pool.DialTimeout,
+				IPFamily:              pool.IPFamily,
+				FallbackDelay:         pool.FallbackDelay,
+				DNSResolver:           pool.DNSResolver,
+				UnixSocket:            upstreamConfig.Socket,
+			},
+		}
+
+		if tlsConfig := upstreamConfig.TLS; tlsConfig.Enabled() {
+			upstream.TLS = &proxy.TLSFiles{
+				CAFile:     tlsConfig.CAFile,
+				CertFile:   tlsConfig.CertFile,
+				KeyFile:    tlsConfig.KeyFile,
+				ServerName: tlsConfig.ServerName,
+			}
+		}
+
+		if cookieConfig := upstreamConfig.Cookies; cookieConfig.Enabled() {
+			domainRewrites := make(map[string]string, len(cookieConfig.DomainRewrites))
+			for domain, replacement := range cookieConfig.DomainRewrites {
+				domainRewrites[strings.ToLower(strings.TrimPrefix(domain, "."))] = replacement
+			}
+			upstream.Cookies = &proxy.CookiePolicy{
+				Strip:          cookieConfig.Strip,
+				DomainRewrites: domainRewrites,
+				PathRewrites:   cookieConfig.PathRewrites,
+				Secure:         cookieConfig.Secure,
+				SameSite:       cookieConfig.SameSite,
+			}
+		}
+
+		for _, ruleConfig := range upstreamConfig.Rewrites {
+			match, err := regexp.Compile(ruleConfig.Match)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: rewrite %s: %w", upstreamConfig.Name, ruleConfig.Name, err)
+			}
+			upstream.Rewrites = append(upstream.Rewrites, &proxy.RewriteRule{
+				Name:        ruleConfig.Name,
+				Match:       match,
+				Replace:     ruleConfig.Replace,
+				AddQuery:    ruleConfig.AddQuery,
+				RemoveQuery: ruleConfig.RemoveQuery,
+			})
+		}
+
+		for _, routeConfig := range upstreamConfig.ClaimRoutes {
+			route := &proxy.ClaimRoute{
+				Name:    routeConfig.Name,
+				Claims:  routeConfig.Claims,
+				Headers: routeConfig.Headers,
+			}
+			if routeConfig.URL != "" {
+				route.Target, err = url.Parse(routeConfig.URL)
+				if err != nil || route.Target.Scheme == "" || route.Target.Host == "" {
+					return nil, fmt.Errorf("upstream %s: claim route %s: invalid URL %q", upstreamConfig.Name, routeConfig.Name, routeConfig.URL)
+				}
+			}
+			upstream.ClaimRoutes = append(upstream.ClaimRoutes, route)
+		}
+
+		if encryptionConfig := upstreamConfig.Encryption; encryptionConfig.Key != "" {
+			key, err := base64.StdEncoding.DecodeString(encryptionConfig.Key)
+			if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+				return nil, fmt.Errorf("upstream %s: encryption key must be a base64-encoded 16, 24 or 32 byte key", upstreamConfig.Name)
+			}
+			upstream.Encryption = &proxy.PayloadEncryption{
+				Key:   key,
+				KeyID: encryptionConfig.KeyID,
+				Paths: encryptionConfig.Paths,
+			}
+		}
+
+		if responseConfig := upstreamConfig.Response; responseConfig.Schema != "" {
+			document, err := proxy.LoadOpenAPI(responseConfig.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", upstreamConfig.Name, err)
+			}
+			upstream.Validation = &proxy.ResponseValidation{
+				Document:     document,
+				Enforce:      responseConfig.Validation == "enforce",
+				StripHeaders: responseConfig.StripHeaders,
+			}
+		}
+
+		authConfig := upstreamConfig.Auth
+		switch authConfig.Type {
+		case "none", "":
+		case "api_key":
+			upstream.Auth = &proxy.APIKeyAuth{Header: authConfig.APIKeyHeader, Key: authConfig.APIKey}
+		case "basic":
+			upstream.Auth = &proxy.BasicAuth{Username: authConfig.Username, Password: authConfig.Password}
+		case "oauth2":
+			upstream.Auth = proxy.NewClientCredentials(authConfig.TokenURL, authConfig.ClientID, authConfig.ClientSecret, authConfig.Scopes)
+		default:
+			return nil, fmt.Errorf("upstream %s: unknown auth type %q", upstreamConfig.Name, authConfig.Type)
+		}
+
+		if upstreamConfig.Type == "s3" {
+			s3 := upstreamConfig.S3
+			upstream.Objects = &proxy.ObjectStore{
+				Bucket:        s3.Bucket,
+				KeyPrefix:     s3.KeyPrefix,
+				PathStyle:     s3.PathStyle,
+				Presign:       s3.Mode == "presign",
+				PresignExpiry: s3.PresignExpiry,
+				Methods:       s3.Methods,
+				Signer: &proxy.SigV4Signer{
+					AccessKeyID:     s3.AccessKeyID,
+					SecretAccessKey: s3.SecretAccessKey,
+					SessionToken:    s3.SessionToken,
+					Region:          s3.Region,
+					Service:         "s3",
+				},
+			}
+		}
+
+		if upstreamConfig.Type == "echo" {
+			// Mask the credentials the gateway adds for the upstream
+			upstream.Echo = &proxy.EchoTarget{
+				Redactor:    newRedactor(redactionConfig, []string{"Authorization", upstreamConfig.Auth.APIKeyHeader}),
+				MaxBodySize: int64(upstreamConfig.Echo.MaxBodySize),
+			}
+		}
+
+		if upstreamConfig.Type == "inprocess" {
+			upstream.Handler = proxy.LookupHandler(upstreamConfig.Handler)
+			if upstream.Handler == nil {
+				return nil, fmt.Errorf("upstream %s: no in-process handler is registered as %q (registered: %s)",
+					upstreamConfig.Name, upstreamConfig.Handler, strings.Join(proxy.RegisteredHandlers(), ", "))
+			}
+		}
+
+		if upstreamConfig.Type == "broker" {
+			if brokerMetrics == nil {
+				brokerMetrics = broker.NewMetrics(reg)
+			}
+			bridge, err := newBridge(upstreamConfig, brokerMetrics)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", upstreamConfig.Name, err)
+			}
+			upstream.Handler = bridge
+		}
+
+		if len(upstreamConfig.Backends) > 0 {
+			zoned := len(upstreamConfig.BackendZones) == len(upstreamConfig.Backends) && (cfg.Region != "" || cfg.Zone != "")
+			backends := make([]*proxy.Backend, 0, len(upstreamConfig.Backends))
+			for i, backend := range upstreamConfig.Backends {
+				backendURL, err := url.Parse(backend)
+				if err != nil || backendURL.Scheme == "" || backendURL.Host == "" {
+					return nil, fmt.Errorf("upstream %s: invalid backend URL %q", upstreamConfig.Name, backend)
+				}
+				backends = append(backends, &proxy.Backend{URL: backendURL})
+				if zoned {
+					backends[i].Locality = proxy.ParseLocality(upstreamConfig.BackendZones[i])
+				}
+			}
+			balance := upstreamConfig.Balance
+			upstream.Balancer = proxy.NewBalancer(backends, balance.Strategy, balance.HashKey, balance.LoadFactor, balance.FailureCooldown)
+			upstream.Balancer.SlowStart = balance.SlowStart
+			if zoned {
+				upstream.Balancer.PreferLocality(proxy.Locality{Region: cfg.Region, Zone: cfg.Zone}, balance.ZoneOverprovision)
+			}
+			if outliers := balance.Outliers; outliers.Enabled {
+				upstream.Balancer.Outliers = &proxy.OutlierDetection{
+					Interval:           outliers.Interval,
+					MinRequests:        int64(outliers.MinRequests),
+					ErrorRate:          outliers.ErrorRate,
+					Latency:            outliers.Latency,
+					EjectionTime:       outliers.EjectionTime,
+					MaxEjectionTime:    outliers.MaxEjectionTime,
+					MaxEjectionPercent: outliers.MaxEjectionPercent,
+				}
+			}
+		}
+
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
+
+// newBridge builds the handler publishing a broker upstream's requests
+func newBridge(upstreamConfig *config.UpstreamConfig, m *broker.Metrics) (*broker.Bridge, error) {
+	brokerConfig := upstreamConfig.Broker
+	var publisher broker.Publisher
+	switch brokerConfig.Type {
+	case "kafka":
+		publisher = broker.NewKafkaPublisher(upstreamConfig.URL, brokerConfig.Username, brokerConfig.Password)
+	case "nats":
+		natsPublisher, err := broker.NewNATSPublisher(upstreamConfig.URL, brokerConfig.Username, brokerConfig.Password, brokerConfig.JetStream)
+		if err != nil {
+			return nil, err
+		}
+		publisher = natsPublisher
+	case "sqs":
+		publisher = broker.NewSQSPublisher(upstreamConfig.URL, &proxy.SigV4Signer{
+			AccessKeyID:     brokerConfig.AccessKeyID,
+			SecretAccessKey: brokerConfig.SecretAccessKey,
+			SessionToken:    brokerConfig.SessionToken,
+			Region:          brokerConfig.Region,
+			Service:         "sqs",
+		})
+	default:
+		return nil, fmt.Errorf("unknown broker type %q", brokerConfig.Type)
+	}
+
+	route := &broker.Route{
+		Name:        upstreamConfig.Name,
+		Topic:       brokerConfig.Topic,
+		Topics:      brokerConfig.Topics,
+		KeyHeader:   brokerConfig.KeyHeader,
+		MaxBodySize: int64(brokerConfig.MaxBodySize),
+		Timeout:     upstreamConfig.Timeout,
+	}
+	if brokerConfig.Schema != "" {
+		document, err := proxy.LoadOpenAPI(brokerConfig.Schema)
+		if err != nil {
+			return nil, err
+		}
+		route.Schema = document
+	}
+	return broker.NewBridge(route, publisher, m), nil
+}
+
+// upstreamRouteDocs describes the proxied upstream routes for the API documentation
+func upstreamRouteDocs(reverseProxy *proxy.Proxy, rateLimitConfig *config.RateLimitConfig) []handlers.RouteDoc {
+	if reverseProxy == nil {
+		return nil
+	}
+
+	extensions := make(map[string]interface{})
+	if rateLimitConfig.Enabled {
+		extensions["x-rate-limit"] = map[string]interface{}{
+			"capacity":    rateLimitConfig.Capacity,
+			"refill_rate": rateLimitConfig.RefillRate,
+			"window":      rateLimitConfig.Window.String(),
+			"identifier":  rateLimitConfig.Identifier,
+		}
+	}
+
+	var routes []handlers.RouteDoc
+	for _, upstream := range reverseProxy.Upstreams() {
+		routeExtensions := map[string]interface{}{"x-upstream": upstream.Name}
+		for key, value := range extensions {
+			routeExtensions[key] = value
+		}
+
+		prefix := strings.TrimSuffix(upstream.PathPrefix, "/")
+		for _, path := range []string{prefix, prefix + "/{path}"} {
+			routes = append(routes, handlers.RouteDoc{
+				Path:        path,
+				Methods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+				Summary:     "Proxy to " + upstream.Name,
+				Description: "Forwarded to the " + upstream.Name + " upstream",
+				Tags:        []string{"Upstreams"},
+				RequireAuth: true,
+				Extensions:  routeExtensions,
+			})
+		}
+	}
+	return routes
+}
+
+// newRedactor builds the redactor for one subsystem, masking its own headers
+// in addition to the shared ones
+func newRedactor(redactionConfig *config.RedactionConfig, extraHeaders []string) *redact.Redactor {
+	return redact.New(&redact.Config{
+		Headers:     append(append([]string(nil), redactionConfig.Headers...), extraHeaders...),
+		Fields:      redactionConfig.Fields,
+		QueryParams: redactionConfig.QueryParams,
+	})
+}
+
+// newAccessLogSinks creates the configured access log sinks
+func newAccessLogSinks(cfg *config.AccessLogConfig) ([]accesslog.Sink, error) {
+	sinks := make([]accesslog.Sink, 0, len(cfg.Sinks))
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "stdout":
+			sinks = append(sinks, accesslog.NewWriterSink("stdout", os.Stdout))
+		case "file":
+			sink, err := accesslog.NewFileSink(cfg.File, cfg.FileMaxSize, cfg.FileRotateEvery, cfg.FileMaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "syslog":
+			sink, err := accesslog.NewSyslogSink(cfg.SyslogNetwork, cfg.SyslogAddress, cfg.SyslogFacility, cfg.SyslogTag)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "kafka":
+			sinks = append(sinks, accesslog.NewKafkaSink(cfg.KafkaRESTURL, cfg.KafkaTopic, cfg.KafkaUsername, cfg.KafkaPassword))
+		case "loki":
+			sinks = append(sinks, accesslog.NewLokiSink(cfg.LokiURL, cfg.LokiLabels, cfg.LokiTenant, cfg.LokiUsername, cfg.LokiPassword))
+		default:
+			return nil, fmt.Errorf("unknown access log sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// newHeaderPolicy converts a configured response header policy
+func newHeaderPolicy(policyConfig *config.HeaderPolicyConfig) *headers.Policy {
+	return &headers.Policy{
+		Name:         policyConfig.Name,
+		Paths:        policyConfig.Paths,
+		Remove:       policyConfig.Remove,
+		Set:          policyConfig.Set,
+		CacheControl: policyConfig.CacheControl,
+		CacheStatus:  policyConfig.CacheStatus,
+	}
+}
+
+// redisConnections are the connections opened by connectRedis. The first
+// connection per address and database is pinged for health checks and
+// diagnostics; every connection is closed on shutdown.
+type redisConnections struct {
+	byAddress map[string]*ratelimit.RedisManager
+	all       []*ratelimit.RedisManager
+}
+
+// HealthCheck pings every address
+func (c *redisConnections) HealthCheck(ctx context.Context) error {
+	var err error
+	for address, conn := range c.byAddress {
+		if pingErr := conn.HealthCheck(ctx); pingErr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", address, pingErr))
+		}
+	}
+	return err
+}
+
+// Stop closes every connection
+func (c *redisConnections) Stop(ctx context.Context) error {
+	var err error
+	for _, conn := range c.all {
+		err = errors.Join(err, conn.Close())
+	}
+	return err
+}
+
+// connectRedis connects to Redis using the shared connection settings
+func (g *Gateway) connectRedis(cfg config.RedisConfig) (*ratelimit.RedisManager, error) {
+	redisManager, err := ratelimit.NewRedisManager(&ratelimit.RedisConfig{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if g.redis == nil {
+		g.redis = &redisConnections{byAddress: make(map[string]*ratelimit.RedisManager)}
+		g.components.Register("redis", g.redis)
+	}
+	address := fmt.Sprintf("%s:%d/%d", cfg.Host, cfg.Port, cfg.DB)
+	if _, exists := g.redis.byAddress[address]; !exists {
+		g.redis.byAddress[address] = redisManager
+	}
+	g.redis.all = append(g.redis.all, redisManager)
+	return redisManager, nil
+}
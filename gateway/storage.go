@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"api-gateway/app"
+	"api-gateway/config"
+	"api-gateway/storage"
+)
+
+// openStore returns the key-value store a subsystem keeps its state in. SQL
+// storage, when enabled, replaces Redis for every subsystem and is opened
+// once; otherwise the subsystem's own Redis setting applies. Subsystems
+// without either share one memory store, saved with warm restarts.
+func (g *Gateway) openStore(useRedis bool, redisConfig config.RedisConfig) (storage.Store, error) {
+	if sqlConfig := g.cfg.SQLStorage; sqlConfig.Enabled {
+		if g.sqlStore == nil {
+			db, err := sql.Open(sqlConfig.Driver, sqlConfig.DSN)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open SQL storage: %w", err)
+			}
+			db.SetMaxOpenConns(sqlConfig.MaxOpenConns)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := db.PingContext(ctx); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to connect to SQL storage: %w", err)
+			}
+			g.sqlStore = storage.NewSQLStore(db, &storage.SQLConfig{
+				Table:       sqlConfig.Table,
+				Placeholder: sqlConfig.Placeholder,
+			})
+			g.components.Register("sql_storage", g.sqlStore, app.Hooks{
+				Stop:   func(context.Context) error { return db.Close() },
+				Health: db.PingContext,
+			})
+		}
+		return g.sqlStore, nil
+	}
+
+	if useRedis {
+		redisManager, err := g.connectRedis(redisConfig)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewRedisStore(redisManager.GetClient()), nil
+	}
+
+	if g.memoryStore == nil {
+		g.memoryStore = storage.NewMemoryStore()
+		g.components.Register("memory_storage", g.memoryStore)
+		if g.warm != nil {
+			g.warm.Register("storage", g.memoryStore)
+		}
+	}
+	return g.memoryStore, nil
+}
@@ -0,0 +1,294 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Group grants its roles to every member
+type Group struct {
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name,omitempty"` // Shown to identity providers; defaults to Name
+	Description string    `json:"description,omitempty"`
+	Roles       []string  `json:"roles"`
+	Members     []string  `json:"members"` // User IDs
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+var (
+	// ErrNotFound is returned for operations on a group that does not exist
+	ErrNotFound = errors.New("group not found")
+	// ErrExists is returned when creating a group whose name is taken
+	ErrExists = errors.New("group already exists")
+	// ErrInvalidName is returned for names that are not lowercase identifiers
+	ErrInvalidName = errors.New("group names must be 1-64 lowercase letters, digits, '-' or '_'")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Manager manages groups and resolves the groups and roles of users. Groups
+// are cached in memory and reloaded periodically, so changes made by other
+// replicas sharing the store are picked up.
+type Manager struct {
+	store Store
+
+	mu       sync.RWMutex // Guards the cache
+	writeMu  sync.Mutex   // Serializes read-modify-write changes
+	groups   map[string]*Group
+	byMember map[string][]*Group
+}
+
+// NewManager loads the groups from the store and reloads them at the given
+// interval; a zero interval disables reloading
+func NewManager(store Store, refresh time.Duration) (*Manager, error) {
+	m := &Manager{store: store}
+	if err := m.reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go m.refreshRoutine(refresh)
+	}
+
+	return m, nil
+}
+
+// List returns every group sorted by name
+func (m *Manager) List() []*Group {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]*Group, 0, len(m.groups))
+	for _, group := range m.groups {
+		list = append(list, group)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get returns a group
+func (m *Manager) Get(name string) (*Group, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	group, ok := m.groups[name]
+	return group, ok
+}
+
+// Create adds a group
+func (m *Manager) Create(ctx context.Context, group *Group) error {
+	if !namePattern.MatchString(group.Name) {
+		return ErrInvalidName
+	}
+	return m.modify(ctx, group.Name, func(existing *Group) (*Group, error) {
+		if existing != nil {
+			return nil, ErrExists
+		}
+		now := time.Now()
+		created := &Group{
+			Name:        group.Name,
+			DisplayName: group.DisplayName,
+			Description: group.Description,
+			Roles:       dedupe(group.Roles),
+			Members:     dedupe(group.Members),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		return created, nil
+	})
+}
+
+// Update replaces the description, roles and members of a group
+func (m *Manager) Update(ctx context.Context, group *Group) error {
+	return m.modify(ctx, group.Name, func(existing *Group) (*Group, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		updated := *existing
+		if group.DisplayName != "" {
+			updated.DisplayName = group.DisplayName
+		}
+		updated.Description = group.Description
+		updated.Roles = dedupe(group.Roles)
+		updated.Members = dedupe(group.Members)
+		updated.UpdatedAt = time.Now()
+		return &updated, nil
+	})
+}
+
+// Sync replaces the display name and members of a group kept in step with an
+// identity provider, leaving the roles it grants untouched
+func (m *Manager) Sync(ctx context.Context, name, displayName string, members []string) error {
+	return m.modify(ctx, name, func(existing *Group) (*Group, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		updated := *existing
+		updated.DisplayName = displayName
+		updated.Members = dedupe(members)
+		updated.UpdatedAt = time.Now()
+		return &updated, nil
+	})
+}
+
+// AddMember adds a user to a group
+func (m *Manager) AddMember(ctx context.Context, name, userID string) error {
+	return m.modify(ctx, name, func(existing *Group) (*Group, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		updated := *existing
+		updated.Members = dedupe(append(append([]string(nil), existing.Members...), userID))
+		updated.UpdatedAt = time.Now()
+		return &updated, nil
+	})
+}
+
+// RemoveMember removes a user from a group
+func (m *Manager) RemoveMember(ctx context.Context, name, userID string) error {
+	return m.modify(ctx, name, func(existing *Group) (*Group, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		updated := *existing
+		updated.Members = nil
+		for _, member := range existing.Members {
+			if member != userID {
+				updated.Members = append(updated.Members, member)
+			}
+		}
+		updated.UpdatedAt = time.Now()
+		return &updated, nil
+	})
+}
+
+// RemoveUser removes a user from every group
+func (m *Manager) RemoveUser(ctx context.Context, userID string) error {
+	names, _ := m.GroupsOf(userID)
+	for _, name := range names {
+		if err := m.RemoveMember(ctx, name, userID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a group
+func (m *Manager) Delete(ctx context.Context, name string) error {
+	return m.modify(ctx, name, func(existing *Group) (*Group, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		return nil, nil
+	})
+}
+
+// GroupsOf returns the names of the user's groups and the roles they grant
+func (m *Manager) GroupsOf(userID string) ([]string, []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var names, roles []string
+	for _, group := range m.byMember[userID] {
+		names = append(names, group.Name)
+		roles = append(roles, group.Roles...)
+	}
+	return names, dedupe(roles)
+}
+
+// modify applies a change to the stored group, deleting it when change
+// returns nil, and refreshes the cache
+func (m *Manager) modify(ctx context.Context, name string, change func(existing *Group) (*Group, error)) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	stored, err := m.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	updated, err := change(stored[name])
+	if err != nil {
+		return err
+	}
+
+	if updated == nil {
+		err = m.store.Delete(ctx, name)
+		delete(stored, name)
+	} else {
+		err = m.store.Save(ctx, updated)
+		stored[name] = updated
+	}
+	if err != nil {
+		return err
+	}
+
+	m.index(stored)
+	return nil
+}
+
+// reload replaces the cache with the stored groups
+func (m *Manager) reload(ctx context.Context) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	stored, err := m.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	m.index(stored)
+	return nil
+}
+
+// index replaces the cache
+func (m *Manager) index(groups map[string]*Group) {
+	byMember := make(map[string][]*Group)
+	for _, group := range groups {
+		for _, member := range group.Members {
+			byMember[member] = append(byMember[member], group)
+		}
+	}
+	for _, memberGroups := range byMember {
+		sort.Slice(memberGroups, func(i, j int) bool {
+			return memberGroups[i].Name < memberGroups[j].Name
+		})
+	}
+
+	m.mu.Lock()
+	m.groups = groups
+	m.byMember = byMember
+	m.mu.Unlock()
+}
+
+// refreshRoutine periodically reloads the groups
+func (m *Manager) refreshRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := m.reload(ctx); err != nil {
+			log.Printf("Failed to reload groups: %v", err)
+		}
+		cancel()
+	}
+}
+
+// dedupe removes empty and repeated values, keeping the first occurrence
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package groups
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists groups
+type Store interface {
+	Load(ctx context.Context) (map[string]*Group, error)
+	Save(ctx context.Context, group *Group) error
+	Delete(ctx context.Context, name string) error
+}
+
+// FileStore keeps groups in a JSON file on local disk
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a store backed by the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Load reads every group. A missing file holds no groups.
+func (s *FileStore) Load(ctx context.Context) (map[string]*Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Save creates or replaces a group
+func (s *FileStore) Save(ctx context.Context, group *Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups, err := s.read()
+	if err != nil {
+		return err
+	}
+	groups[group.Name] = group
+	return s.write(groups)
+}
+
+// Delete removes a group
+func (s *FileStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(groups, name)
+	return s.write(groups)
+}
+
+func (s *FileStore) read() (map[string]*Group, error) {
+	groups := make(map[string]*Group)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return groups, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups: %w", err)
+	}
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", s.path, err)
+	}
+	return groups, nil
+}
+
+// write replaces the file atomically so a crash never leaves it truncated
+func (s *FileStore) write(groups map[string]*Group) error {
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".groups-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write groups: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write groups: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write groups: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write groups: %w", err)
+	}
+	return nil
+}
+
+// redisGroupsKey is the hash holding every group, keyed by name
+const redisGroupsKey = "groups"
+
+// RedisStore keeps groups in Redis so every replica shares them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed group store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Load reads every group
+func (s *RedisStore) Load(ctx context.Context) (map[string]*Group, error) {
+	values, err := s.client.HGetAll(ctx, redisGroupsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load groups: %w", err)
+	}
+	groups := make(map[string]*Group, len(values))
+	for name, value := range values {
+		var group Group
+		if err := json.Unmarshal([]byte(value), &group); err != nil {
+			return nil, fmt.Errorf("failed to decode group %s: %w", name, err)
+		}
+		groups[name] = &group
+	}
+	return groups, nil
+}
+
+// Save creates or replaces a group
+func (s *RedisStore) Save(ctx context.Context, group *Group) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, redisGroupsKey, group.Name, data).Err(); err != nil {
+		return fmt.Errorf("failed to save group: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a group
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	if err := s.client.HDel(ctx, redisGroupsKey, name).Err(); err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/pat"
+
+	"github.com/gorilla/mux"
+)
+
+// PersonalTokenRequest represents a request to create a personal access token
+type PersonalTokenRequest struct {
+	Name      string   `json:"name" example:"ci-deploy"`
+	Scopes    []string `json:"scopes" example:"orders:read"`
+	ExpiresIn string   `json:"expires_in,omitempty" example:"720h"` // Defaults to the maximum lifetime, or never
+}
+
+// PersonalToken describes a personal access token without its secret
+type PersonalToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+}
+
+// CreatedPersonalToken is returned once, when a token is created
+type CreatedPersonalToken struct {
+	PersonalToken
+	Token string `json:"token"` // Shown only in this response
+}
+
+// AccountTokensHandler lets users manage their personal access tokens
+type AccountTokensHandler struct {
+	manager *pat.Manager
+}
+
+// NewAccountTokensHandler creates a new personal access token handler
+func NewAccountTokensHandler(manager *pat.Manager) *AccountTokensHandler {
+	return &AccountTokensHandler{
+		manager: manager,
+	}
+}
+
+// ListTokens lists the caller's personal access tokens
+// @Summary List Personal Access Tokens
+// @Description List the personal access tokens of the signed-in user
+// @Tags Account
+// @Produce json
+// @Success 200 {array} PersonalToken
+// @Failure 401 {object} ErrorResponse
+// @Router /api/account/tokens [get]
+// @Security BearerAuth
+func (h *AccountTokensHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+	if userCtx == nil {
+		http.Error(w, `{"error":"Authentication required","details":"User context not found"}`, http.StatusUnauthorized)
+		return
+	}
+
+	tokens := make([]PersonalToken, 0)
+	for _, token := range h.manager.List(userCtx.UserID) {
+		tokens = append(tokens, personalToken(token))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateToken mints a personal access token for the caller
+// @Summary Create Personal Access Token
+// @Description Create a token acting as the signed-in user, limited to the given scopes ("resource:action", "resource:*", "roles:<role>" or "*"). The token is shown only once.
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param request body PersonalTokenRequest true "Token name, scopes and expiry"
+// @Success 201 {object} CreatedPersonalToken
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/account/tokens [post]
+// @Security BearerAuth
+func (h *AccountTokensHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+	if userCtx == nil {
+		http.Error(w, `{"error":"Authentication required","details":"User context not found"}`, http.StatusUnauthorized)
+		return
+	}
+	// A long-lived token would outlast the audited impersonation session
+	if userCtx.Impersonated() {
+		http.Error(w, `{"error":"Token creation not allowed","details":"Impersonation tokens cannot create personal access tokens"}`, http.StatusForbidden)
+		return
+	}
+
+	var req PersonalTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	var lifetime time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		lifetime, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || lifetime <= 0 {
+			http.Error(w, `{"error":"Invalid expires_in format","details":"Use a positive duration like '24h' or '720h'"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, value, err := h.manager.Create(r.Context(), userCtx, req.Name, req.Scopes, lifetime)
+	if err != nil {
+		writePersonalTokenError(w, err)
+		return
+	}
+
+	log.Printf("Personal access token %s (%q) created by user %s", token.ID, token.Name, userCtx.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreatedPersonalToken{
+		PersonalToken: personalToken(token),
+		Token:         value,
+	})
+}
+
+// RevokeToken revokes one of the caller's personal access tokens
+// @Summary Revoke Personal Access Token
+// @Description Revoke a personal access token of the signed-in user
+// @Tags Account
+// @Param id path string true "Token ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/account/tokens/{id} [delete]
+// @Security BearerAuth
+func (h *AccountTokensHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+	if userCtx == nil {
+		http.Error(w, `{"error":"Authentication required","details":"User context not found"}`, http.StatusUnauthorized)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := h.manager.Revoke(r.Context(), userCtx.UserID, id); err != nil {
+		writePersonalTokenError(w, err)
+		return
+	}
+	log.Printf("Personal access token %s revoked by user %s", id, userCtx.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// personalToken describes a token without its hash
+func personalToken(token *pat.Token) PersonalToken {
+	return PersonalToken{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		Expired:   token.Expired(),
+	}
+}
+
+// writePersonalTokenError maps personal access token errors to responses
+func writePersonalTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pat.ErrNotFound):
+		http.Error(w, `{"error":"Token not found","details":"No personal access token with that ID"}`, http.StatusNotFound)
+	case errors.Is(err, pat.ErrInvalidName), errors.Is(err, pat.ErrInvalidScope), errors.Is(err, pat.ErrLifetimeTooLong):
+		http.Error(w, `{"error":"Invalid token request","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+	case errors.Is(err, pat.ErrLimitReached):
+		http.Error(w, `{"error":"Token limit reached","details":"Revoke unused tokens before creating more"}`, http.StatusConflict)
+	default:
+		log.Printf("Failed to update personal access tokens: %v", err)
+		http.Error(w, `{"error":"Failed to update tokens","details":"Personal access tokens are temporarily unavailable"}`, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/anomaly"
+)
+
+// AnomaliesHandler handles traffic anomaly endpoints
+type AnomaliesHandler struct {
+	detector *anomaly.Detector
+}
+
+// NewAnomaliesHandler creates a new anomalies handler
+func NewAnomaliesHandler(detector *anomaly.Detector) *AnomaliesHandler {
+	return &AnomaliesHandler{
+		detector: detector,
+	}
+}
+
+// AnomaliesResponse represents recent anomalies response
+type AnomaliesResponse struct {
+	Anomalies []*anomaly.Alert `json:"anomalies"`
+}
+
+// GetAnomalies returns the most recent traffic anomalies
+// @Summary Get Traffic Anomalies
+// @Description Get the last 100 anomalies in request rate, error rate or latency detected per route and consumer by this instance, newest first
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} AnomaliesResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/admin/metrics/anomalies [get]
+// @Security BearerAuth
+func (h *AnomaliesHandler) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	response := AnomaliesResponse{
+		Anomalies: h.detector.Alerts(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
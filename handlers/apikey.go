@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"api-gateway/auth"
+
+	"github.com/gorilla/mux"
 )
 
 // APIKeyHandler handles API key management
@@ -26,6 +28,8 @@ type CreateAPIKeyRequest struct {
 	UserID    string   `json:"user_id" example:"user123"`
 	Roles     []string `json:"roles" example:"user,admin"`
 	RateLimit int      `json:"rate_limit" example:"100"`
+	Plan      string   `json:"plan" example:"premium"`
+	Products  []string `json:"products" example:"orders"`
 	ExpiresIn string   `json:"expires_in" example:"24h"`
 }
 
@@ -90,7 +94,7 @@ func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create API key
-	apiKey, err := h.apiKeyStore.GenerateAPIKey(req.Name, req.UserID, req.Roles, rateLimit, expiresIn)
+	apiKey, err := h.apiKeyStore.GenerateAPIKey(req.Name, req.UserID, req.Roles, rateLimit, req.Plan, req.Products, expiresIn)
 	if err != nil {
 		http.Error(w, `{"error":"Failed to create API key","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
 		return
@@ -112,6 +116,8 @@ func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 // @Description List all API keys for the authenticated user
 // @Tags API Keys
 // @Produce json
+// @Param user_id query string true "User ID"
+// @Param deleted query bool false "List deleted keys that can still be restored"
 // @Success 200 {object} ListAPIKeysResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /api/keys [get]
@@ -127,6 +133,9 @@ func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 	}
 
 	apiKeys := h.apiKeyStore.ListAPIKeys(userID)
+	if r.URL.Query().Get("deleted") == "true" {
+		apiKeys = h.apiKeyStore.ListDeletedAPIKeys(userID)
+	}
 
 	response := ListAPIKeysResponse{
 		APIKeys: apiKeys,
@@ -148,7 +157,7 @@ func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 // @Router /api/keys/{key} [get]
 // @Security BearerAuth
 func (h *APIKeyHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
-	key := r.URL.Path[len("/api/keys/"):]
+	key := mux.Vars(r)["key"]
 	if key == "" {
 		http.Error(w, `{"error":"Missing API key","details":"API key parameter is required"}`, http.StatusBadRequest)
 		return
@@ -175,8 +184,7 @@ func (h *APIKeyHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
 // @Router /api/keys/{key}/revoke [post]
 // @Security BearerAuth
 func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
-	key := r.URL.Path[len("/api/keys/"):]
-	key = key[:len(key)-len("/revoke")]
+	key := mux.Vars(r)["key"]
 
 	if key == "" {
 		http.Error(w, `{"error":"Missing API key","details":"API key parameter is required"}`, http.StatusBadRequest)
@@ -198,9 +206,9 @@ func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// DeleteAPIKey permanently deletes an API key
+// DeleteAPIKey soft-deletes an API key
 // @Summary Delete API Key
-// @Description Permanently delete an API key
+// @Description Delete an API key; it stops working immediately and can be restored until the retention window (API_KEY_RETENTION) has passed
 // @Tags API Keys
 // @Produce json
 // @Param key path string true "API Key"
@@ -209,7 +217,7 @@ func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 // @Router /api/keys/{key} [delete]
 // @Security BearerAuth
 func (h *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
-	key := r.URL.Path[len("/api/keys/"):]
+	key := mux.Vars(r)["key"]
 
 	if key == "" {
 		http.Error(w, `{"error":"Missing API key","details":"API key parameter is required"}`, http.StatusBadRequest)
@@ -231,6 +239,29 @@ func (h *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// RestoreAPIKey restores a deleted API key
+// @Summary Restore API Key
+// @Description Restore a deleted API key before it is permanently purged
+// @Tags API Keys
+// @Produce json
+// @Param key path string true "API Key"
+// @Success 200 {object} auth.APIKey
+// @Failure 404 {object} ErrorResponse
+// @Router /api/keys/{key}/restore [post]
+// @Security BearerAuth
+func (h *APIKeyHandler) RestoreAPIKey(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	apiKey, err := h.apiKeyStore.RestoreAPIKey(key)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to restore API key","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiKey)
+}
+
 // GetAPIKeyStats returns statistics about API keys
 // @Summary Get API Key Statistics
 // @Description Get statistics about API key usage
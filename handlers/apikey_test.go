@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+)
+
+func FuzzTestAPIKey(f *testing.F) {
+	store := auth.NewAPIKeyStore(time.Hour)
+	key, err := store.GenerateAPIKey("fuzz", "user1", []string{"user"}, 0, "", nil, time.Hour)
+	if err != nil {
+		f.Fatal(err)
+	}
+	handler := NewAPIKeyHandler(store)
+
+	f.Add(key.Key)
+	f.Add(key.Key + " ")
+	f.Add(key.Key[:len(key.Key)-1])
+	f.Add(`"},{"error":"x`)
+	f.Add("\\u0000")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, apiKey string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/keys/test", nil)
+		r.Header["X-Api-Key"] = []string{apiKey}
+		rec := httptest.NewRecorder()
+		handler.TestAPIKey(rec, r)
+
+		switch {
+		case apiKey == key.Key:
+			if rec.Code != http.StatusOK {
+				t.Fatalf("valid key: status %d", rec.Code)
+			}
+		case apiKey == "":
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("missing key: status %d", rec.Code)
+			}
+		default:
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("key %q: status %d, want 401", apiKey, rec.Code)
+			}
+		}
+		if !json.Valid(rec.Body.Bytes()) {
+			t.Fatalf("key %q: response is not JSON: %s", apiKey, rec.Body)
+		}
+	})
+}
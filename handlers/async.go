@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/async"
+	"api-gateway/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// AsyncHandler handles polling for asynchronous requests
+type AsyncHandler struct {
+	dispatcher *async.Dispatcher
+}
+
+// NewAsyncHandler creates a new asynchronous request handler
+func NewAsyncHandler(dispatcher *async.Dispatcher) *AsyncHandler {
+	return &AsyncHandler{
+		dispatcher: dispatcher,
+	}
+}
+
+// AsyncJobStatus represents an asynchronous request that has not completed
+type AsyncJobStatus struct {
+	ID         string     `json:"id"`
+	Route      string     `json:"route"`
+	Status     string     `json:"status"` // "queued", "running" or "failed"
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// GetJob returns the outcome of an asynchronous request
+// @Summary Poll Asynchronous Request
+// @Description Poll a request accepted with 202 by an async upstream route. While it is queued or running the status is returned with 202 and Retry-After. Once the upstream has answered, its response is returned as is, whatever its status; 502 means no response could be obtained. Only the caller who submitted the request can poll it.
+// @Tags User
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {string} string "The upstream's response"
+// @Success 202 {object} AsyncJobStatus
+// @Failure 404 {object} ErrorResponse
+// @Failure 502 {object} AsyncJobStatus
+// @Router /api/async/{id} [get]
+// @Security BearerAuth
+func (h *AsyncHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.dispatcher.Get(r.Context(), mux.Vars(r)["id"], auth.GetUserFromContext(r))
+	if err != nil {
+		http.Error(w, `{"error":"Failed to get job","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, `{"error":"Job not found","details":"The job does not exist, has expired or was submitted by someone else"}`, http.StatusNotFound)
+		return
+	}
+
+	if job.Status == async.StatusCompleted && job.Response != nil {
+		header := w.Header()
+		for name, values := range job.Response.Header {
+			header[name] = values
+		}
+		header.Set("Content-Length", strconv.Itoa(len(job.Response.Body)))
+		w.WriteHeader(job.Response.Status)
+		w.Write(job.Response.Body)
+		return
+	}
+
+	status := AsyncJobStatus{
+		ID:        job.ID,
+		Route:     job.Route,
+		Status:    job.Status,
+		Attempts:  job.Attempts,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+	}
+	if !job.StartedAt.IsZero() {
+		status.StartedAt = &job.StartedAt
+	}
+	if !job.FinishedAt.IsZero() {
+		status.FinishedAt = &job.FinishedAt
+	}
+	code := http.StatusAccepted
+	if job.Status == async.StatusFailed {
+		code = http.StatusBadGateway
+	} else {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -23,18 +26,20 @@ type LoginResponse struct {
 
 // UserInfo represents user information
 type UserInfo struct {
-	ID       string   `json:"id"`
-	Username string   `json:"username"`
-	Email    string   `json:"email"`
-	Roles    []string `json:"roles"`
+	ID       string      `json:"id"`
+	Username string      `json:"username"`
+	Email    string      `json:"email"`
+	Roles    []string    `json:"roles"`
+	Groups   []string    `json:"groups,omitempty"`
+	Actor    *auth.Actor `json:"actor,omitempty"` // Administrator acting as the user, if impersonating
 }
 
 // AuthHandler handles authentication-related endpoints
 type AuthHandler struct {
 	jwtManager *auth.JWTManager
-	// In a real application, you would have a user service/database
-	// For demo purposes, we'll use mock data
-	users map[string]UserData
+	groups     auth.GroupResolver // Adds group roles to issued tokens when set
+	// Demo users unless replaced with SetUserStore, e.g. by an LDAP directory
+	users auth.UserStore
 }
 
 // UserData represents user data for authentication
@@ -75,10 +80,60 @@ func NewAuthHandler(jwtManager *auth.JWTManager) *AuthHandler {
 
 	return &AuthHandler{
 		jwtManager: jwtManager,
-		users:      users,
+		users:      staticUsers(users),
 	}
 }
 
+// SetUserStore replaces the users that can log in
+func (h *AuthHandler) SetUserStore(store auth.UserStore) {
+	h.users = store
+}
+
+// staticUsers is an in-memory user store keyed by username
+type staticUsers map[string]UserData
+
+// Authenticate implements auth.UserStore
+func (s staticUsers) Authenticate(ctx context.Context, username, password string) (*auth.User, error) {
+	user, exists := s[username]
+	if !exists || user.Password != password {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return user.toUser(), nil
+}
+
+// Lookup implements auth.UserStore
+func (s staticUsers) Lookup(ctx context.Context, username string) (*auth.User, error) {
+	user, exists := s[username]
+	if !exists {
+		return nil, auth.ErrUserNotFound
+	}
+	return user.toUser(), nil
+}
+
+// toUser converts user data to an account without its password
+func (u UserData) toUser() *auth.User {
+	return &auth.User{
+		ID:       u.ID,
+		Username: u.Username,
+		Email:    u.Email,
+		Roles:    u.Roles,
+	}
+}
+
+// SetGroupResolver makes issued tokens carry the user's groups and group roles
+func (h *AuthHandler) SetGroupResolver(resolver auth.GroupResolver) {
+	h.groups = resolver
+}
+
+// withGroups adds the user's groups and group roles when resolved at issuance
+func (h *AuthHandler) withGroups(userID string, roles []string) ([]string, []string) {
+	if h.groups == nil {
+		return roles, nil
+	}
+	groups, groupRoles := h.groups.GroupsOf(userID)
+	return auth.MergeValues(roles, groupRoles), groups
+}
+
 // Login handles user login
 // @Summary User login
 // @Description Authenticate user and return JWT token
@@ -89,6 +144,7 @@ func NewAuthHandler(jwtManager *auth.JWTManager) *AuthHandler {
 // @Success 200 {object} LoginResponse "Login successful"
 // @Failure 400 {object} ErrorResponse "Invalid request body"
 // @Failure 401 {object} ErrorResponse "Invalid credentials"
+// @Failure 503 {object} ErrorResponse "Authentication backend unavailable"
 // @Router /login [post]
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
@@ -98,35 +154,49 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate user credentials
-	user, exists := h.users[req.Username]
-	if !exists || user.Password != req.Password {
+	user, err := h.users.Authenticate(r.Context(), req.Username, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to authenticate %q: %v", req.Username, err)
+		http.Error(w, "Authentication backend unavailable", http.StatusServiceUnavailable)
+		return
+	}
 
-	// Generate JWT token
-	token, err := h.jwtManager.GenerateToken(user.ID, user.Username, user.Email, user.Roles)
+	response, err := h.issue(user)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// issue generates a JWT token for an authenticated user
+func (h *AuthHandler) issue(user *auth.User) (*LoginResponse, error) {
+	roles, groups := h.withGroups(user.ID, user.Roles)
+	token, err := h.jwtManager.GenerateTokenWithGroups(user.ID, user.Username, user.Email, roles, groups)
+	if err != nil {
+		return nil, err
+	}
+
 	// Calculate expiration time
 	expiresAt := time.Now().Add(24 * time.Hour) // This should match your JWT expiry
 
-	response := LoginResponse{
+	return &LoginResponse{
 		Token:     token,
 		ExpiresAt: expiresAt,
 		User: UserInfo{
 			ID:       user.ID,
 			Username: user.Username,
 			Email:    user.Email,
-			Roles:    user.Roles,
+			Roles:    roles,
+			Groups:   groups,
 		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	}, nil
 }
 
 // Profile returns the current user's profile
@@ -150,6 +220,8 @@ func (h *AuthHandler) Profile(w http.ResponseWriter, r *http.Request) {
 		Username: userCtx.Username,
 		Email:    userCtx.Email,
 		Roles:    userCtx.Roles,
+		Groups:   userCtx.Groups,
+		Actor:    userCtx.Actor,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -164,6 +236,7 @@ func (h *AuthHandler) Profile(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 // @Success 200 {object} LoginResponse "Token refreshed successfully"
 // @Failure 401 {object} ErrorResponse "Authentication required"
+// @Failure 403 {object} ErrorResponse "Only gateway JWTs can be refreshed"
 // @Router /api/refresh [post]
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	userCtx := auth.GetUserFromContext(r)
@@ -172,8 +245,32 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate new token with same claims
-	token, err := h.jwtManager.GenerateToken(userCtx.UserID, userCtx.Username, userCtx.Email, userCtx.Roles)
+	// Refreshing would turn a short-lived impersonation token into a plain user token
+	if userCtx.Impersonated() {
+		http.Error(w, `{"error":"Refresh not allowed","details":"Impersonation tokens cannot be refreshed"}`, http.StatusForbidden)
+		return
+	}
+	// Refreshing would trade a scoped personal access token for an unscoped
+	// one, and an API key or device certificate for a token that outlives
+	// its revocation
+	switch userCtx.AuthType {
+	case "jwt":
+	case "pat":
+		http.Error(w, `{"error":"Refresh not allowed","details":"Personal access tokens cannot be refreshed"}`, http.StatusForbidden)
+		return
+	default:
+		http.Error(w, `{"error":"Refresh not allowed","details":"Only JWTs can be refreshed"}`, http.StatusForbidden)
+		return
+	}
+
+	// Generate new token with same claims. Known users get their current roles,
+	// so roles from groups they have left are not carried over.
+	roles := userCtx.Roles
+	if user, err := h.users.Lookup(r.Context(), userCtx.Username); err == nil && user.ID == userCtx.UserID {
+		roles = user.Roles
+	}
+	roles, groups := h.withGroups(userCtx.UserID, roles)
+	token, err := h.jwtManager.GenerateTokenWithGroups(userCtx.UserID, userCtx.Username, userCtx.Email, roles, groups)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -188,7 +285,8 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 			ID:       userCtx.UserID,
 			Username: userCtx.Username,
 			Email:    userCtx.Email,
-			Roles:    userCtx.Roles,
+			Roles:    roles,
+			Groups:   groups,
 		},
 	}
 
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+)
+
+func TestRefreshToken(t *testing.T) {
+	handler := NewAuthHandler(auth.NewJWTManager("secret", "api-gateway", "api-users", time.Hour))
+	tests := []struct {
+		name   string
+		user   *auth.UserContext
+		status int
+	}{
+		{"gateway JWT", &auth.UserContext{UserID: "2", Username: "user", AuthType: "jwt"}, http.StatusOK},
+		{"unauthenticated", nil, http.StatusUnauthorized},
+		{"impersonation token", &auth.UserContext{UserID: "2", Username: "user", AuthType: "jwt", Actor: &auth.Actor{Subject: "1"}}, http.StatusForbidden},
+		{"personal access token", &auth.UserContext{UserID: "2", Username: "user", AuthType: "pat"}, http.StatusForbidden},
+		{"API key", &auth.UserContext{UserID: "2", Username: "ci", AuthType: "apikey"}, http.StatusForbidden},
+		{"device certificate", &auth.UserContext{UserID: "device:d1", Username: "d1", AuthType: "certificate"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		if tt.user != nil {
+			r = auth.WithUser(r, tt.user)
+		}
+		rec := httptest.NewRecorder()
+		handler.RefreshToken(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api-gateway/autoscale"
+)
+
+// AutoscaleHandler handles autoscaling signal endpoints
+type AutoscaleHandler struct {
+	tracker *autoscale.Tracker
+}
+
+// NewAutoscaleHandler creates a new autoscaling signal handler
+func NewAutoscaleHandler(tracker *autoscale.Tracker) *AutoscaleHandler {
+	return &AutoscaleHandler{
+		tracker: tracker,
+	}
+}
+
+// LoadResponse represents the load of this replica
+type LoadResponse struct {
+	autoscale.Status
+	DesiredReplicas *int `json:"desired_replicas,omitempty"` // Only when the current replica count is given
+}
+
+// GetLoad returns the load signals of this replica for autoscalers
+// @Summary Get Autoscaling Load
+// @Description Get in-flight requests, queue depth, p99 latency and CPU, each relative to its per-replica target. Poll "load" with the KEDA metrics-api scaler, or pass the current replica count to get the replicas that would bring the load to 1.
+// @Tags Admin
+// @Produce json
+// @Param replicas query int false "Current replica count"
+// @Success 200 {object} LoadResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/load [get]
+// @Security BearerAuth
+func (h *AutoscaleHandler) GetLoad(w http.ResponseWriter, r *http.Request) {
+	response := LoadResponse{Status: h.tracker.Status()}
+
+	if value := r.URL.Query().Get("replicas"); value != "" {
+		replicas, err := strconv.Atoi(value)
+		if err != nil || replicas < 1 {
+			http.Error(w, `{"error":"Invalid replicas","details":"replicas must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		desired := response.Status.DesiredReplicas(replicas)
+		response.DesiredReplicas = &desired
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
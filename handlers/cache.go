@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"api-gateway/auth"
+	"api-gateway/cache"
+)
+
+// CacheHandler handles response cache endpoints
+type CacheHandler struct {
+	cache *cache.Cache
+}
+
+// NewCacheHandler creates a new response cache handler
+func NewCacheHandler(responseCache *cache.Cache) *CacheHandler {
+	return &CacheHandler{
+		cache: responseCache,
+	}
+}
+
+// PurgeRequest represents a request to purge cached responses
+type PurgeRequest struct {
+	Tags []string `json:"tags"` // Surrogate keys, such as "user:123"
+}
+
+// PurgeResponse represents the outcome of a purge
+type PurgeResponse struct {
+	Tags   []string `json:"tags"`
+	Purged int      `json:"purged"` // Entries removed
+}
+
+// Purge removes the cached responses tagged with any of the surrogate keys
+// @Summary Purge Cached Responses
+// @Description Remove every cached upstream response tagged with any of the given surrogate keys. Upstreams tag responses through the Surrogate-Key header and call this after writes that change the data behind them. The purge is audited.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body PurgeRequest true "Surrogate keys to purge"
+// @Success 200 {object} PurgeResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/cache/purge [post]
+// @Security BearerAuth
+func (h *CacheHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	var req PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Tags) == 0 {
+		http.Error(w, `{"error":"Missing required fields","details":"tags is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	purged, err := h.cache.Purge(r.Context(), req.Tags)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to purge cache","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+	purgedBy := ""
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		purgedBy = userCtx.Username
+	}
+	log.Printf("Audit: %s purged %d cached responses tagged %v", purgedBy, purged, req.Tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&PurgeResponse{Tags: req.Tags, Purged: purged})
+}
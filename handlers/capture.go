@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/capture"
+
+	"github.com/gorilla/mux"
+)
+
+// CaptureHandler handles traffic capture and replay endpoints
+type CaptureHandler struct {
+	capturer *capture.Capturer
+	replayer *capture.Replayer
+}
+
+// NewCaptureHandler creates a new capture handler
+func NewCaptureHandler(capturer *capture.Capturer, replayer *capture.Replayer) *CaptureHandler {
+	return &CaptureHandler{
+		capturer: capturer,
+		replayer: replayer,
+	}
+}
+
+// StartCaptureRequest represents the request to start a capture session
+type StartCaptureRequest struct {
+	PathPrefix string  `json:"path_prefix" example:"/api/user"`
+	SampleRate float64 `json:"sample_rate" example:"0.1"`
+	MaxEntries int     `json:"max_entries" example:"100"`
+	Duration   string  `json:"duration" example:"10m"`
+}
+
+// ReplayRequest represents the request to replay a capture session
+type ReplayRequest struct {
+	Target  string            `json:"target" example:"http://staging-backend:8080"`
+	Headers map[string]string `json:"headers"`
+}
+
+// CaptureSessionsResponse represents the response for listing capture sessions
+type CaptureSessionsResponse struct {
+	Sessions []*capture.Session `json:"sessions"`
+	Count    int                `json:"count"`
+}
+
+// CaptureEntriesResponse represents the response for a session's captured traffic
+type CaptureEntriesResponse struct {
+	Session *capture.Session `json:"session"`
+	Entries []*capture.Entry `json:"entries"`
+	Count   int              `json:"count"`
+}
+
+// StartCapture starts a capture session
+// @Summary Start Traffic Capture
+// @Description Start recording sampled request/response pairs for a path prefix (secrets redacted)
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body StartCaptureRequest true "Capture session request"
+// @Success 201 {object} capture.Session
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/capture [post]
+// @Security BearerAuth
+func (h *CaptureHandler) StartCapture(w http.ResponseWriter, r *http.Request) {
+	var req StartCaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.PathPrefix == "" {
+		http.Error(w, `{"error":"Missing required fields","details":"path_prefix is required"}`, http.StatusBadRequest)
+		return
+	}
+	if req.SampleRate == 0 {
+		req.SampleRate = 1
+	}
+	if req.MaxEntries == 0 {
+		req.MaxEntries = 100
+	}
+
+	duration := 10 * time.Minute
+	if req.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid duration format","details":"Use format like '10m', '1h'"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	createdBy := ""
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		createdBy = userCtx.Username
+	}
+
+	session, err := h.capturer.StartSession(req.PathPrefix, req.SampleRate, req.MaxEntries, duration, createdBy)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to start capture","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+// ListCaptures lists capture sessions
+// @Summary List Traffic Captures
+// @Description List active and finished capture sessions
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} CaptureSessionsResponse
+// @Router /api/admin/capture [get]
+// @Security BearerAuth
+func (h *CaptureHandler) ListCaptures(w http.ResponseWriter, r *http.Request) {
+	sessions := h.capturer.ListSessions()
+
+	response := CaptureSessionsResponse{
+		Sessions: sessions,
+		Count:    len(sessions),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetCapture returns a capture session with its entries
+// @Summary Get Traffic Capture
+// @Description Get a capture session and its recorded request/response pairs
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Capture session ID"
+// @Success 200 {object} CaptureEntriesResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/capture/{id} [get]
+// @Security BearerAuth
+func (h *CaptureHandler) GetCapture(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	session, exists := h.capturer.GetSession(id)
+	if !exists {
+		http.Error(w, `{"error":"Capture session not found","details":"The specified capture session does not exist"}`, http.StatusNotFound)
+		return
+	}
+
+	entries, err := h.capturer.Entries(r.Context(), id)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to read capture","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := CaptureEntriesResponse{
+		Session: session,
+		Entries: entries,
+		Count:   len(entries),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// StopCapture stops a capture session
+// @Summary Stop Traffic Capture
+// @Description Stop recording for a capture session, keeping captured entries
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Capture session ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/capture/{id}/stop [post]
+// @Security BearerAuth
+func (h *CaptureHandler) StopCapture(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.capturer.StopSession(id); err != nil {
+		http.Error(w, `{"error":"Failed to stop capture","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Capture stopped successfully",
+		"id":      id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteCapture deletes a capture session and its entries
+// @Summary Delete Traffic Capture
+// @Description Delete a capture session and all recorded entries
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Capture session ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/capture/{id} [delete]
+// @Security BearerAuth
+func (h *CaptureHandler) DeleteCapture(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.capturer.DeleteSession(r.Context(), id); err != nil {
+		http.Error(w, `{"error":"Failed to delete capture","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Capture deleted successfully",
+		"id":      id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ReplayCapture re-sends captured traffic against another upstream
+// @Summary Replay Traffic Capture
+// @Description Re-send a session's captured requests to a target upstream and compare responses
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Capture session ID"
+// @Param request body ReplayRequest true "Replay request"
+// @Success 200 {object} capture.ReplaySummary
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/capture/{id}/replay [post]
+// @Security BearerAuth
+func (h *CaptureHandler) ReplayCapture(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.capturer.Entries(r.Context(), id)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to read capture","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	summary, err := h.replayer.Replay(r.Context(), entries, req.Target, req.Headers)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to replay capture","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
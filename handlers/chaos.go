@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/chaos"
+
+	"github.com/gorilla/mux"
+)
+
+// ChaosHandler handles fault injection endpoints
+type ChaosHandler struct {
+	injector *chaos.Injector
+}
+
+// NewChaosHandler creates a new fault injection handler
+func NewChaosHandler(injector *chaos.Injector) *ChaosHandler {
+	return &ChaosHandler{
+		injector: injector,
+	}
+}
+
+// AddFaultRequest represents the request to inject faults into a route
+type AddFaultRequest struct {
+	PathPrefix     string  `json:"path_prefix" example:"/api/user"`
+	Latency        string  `json:"latency" example:"500ms"`
+	LatencyPercent float64 `json:"latency_percent" example:"50"`
+	ErrorStatus    int     `json:"error_status" example:"503"`
+	ErrorPercent   float64 `json:"error_percent" example:"10"`
+	DropPercent    float64 `json:"drop_percent" example:"0"`
+	Duration       string  `json:"duration" example:"30m"`
+}
+
+// FaultsResponse represents the response for listing faults
+type FaultsResponse struct {
+	Faults []*chaos.Fault `json:"faults"`
+	Count  int            `json:"count"`
+}
+
+// AddFault enables fault injection for a route
+// @Summary Add Chaos Fault
+// @Description Inject latency, error responses or dropped connections into a percentage of requests for a path prefix
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body AddFaultRequest true "Fault request"
+// @Success 201 {object} chaos.Fault
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/chaos/faults [post]
+// @Security BearerAuth
+func (h *ChaosHandler) AddFault(w http.ResponseWriter, r *http.Request) {
+	var req AddFaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	fault := &chaos.Fault{
+		PathPrefix:     req.PathPrefix,
+		LatencyPercent: req.LatencyPercent,
+		ErrorStatus:    req.ErrorStatus,
+		ErrorPercent:   req.ErrorPercent,
+		DropPercent:    req.DropPercent,
+	}
+	if fault.ErrorPercent > 0 && fault.ErrorStatus == 0 {
+		fault.ErrorStatus = http.StatusServiceUnavailable
+	}
+
+	if req.Latency != "" {
+		latency, err := time.ParseDuration(req.Latency)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid latency format","details":"Use format like '200ms', '2s'"}`, http.StatusBadRequest)
+			return
+		}
+		fault.Latency = latency
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid duration format","details":"Use format like '30m', '1h'"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		fault.CreatedBy = userCtx.Username
+	}
+
+	created, err := h.injector.AddFault(fault, duration)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to add fault","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListFaults lists active faults
+// @Summary List Chaos Faults
+// @Description List active faults and how often each was injected
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} FaultsResponse
+// @Router /api/admin/chaos/faults [get]
+// @Security BearerAuth
+func (h *ChaosHandler) ListFaults(w http.ResponseWriter, r *http.Request) {
+	faults := h.injector.ListFaults()
+
+	response := FaultsResponse{
+		Faults: faults,
+		Count:  len(faults),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RemoveFault disables a fault
+// @Summary Remove Chaos Fault
+// @Description Stop injecting a fault
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Fault ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/chaos/faults/{id} [delete]
+// @Security BearerAuth
+func (h *ChaosHandler) RemoveFault(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.injector.RemoveFault(id); err != nil {
+		http.Error(w, `{"error":"Failed to remove fault","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Fault removed successfully",
+		"id":      id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
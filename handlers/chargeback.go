@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/chargeback"
+)
+
+// ChargebackHandler handles chargeback reporting endpoints
+type ChargebackHandler struct {
+	recorder *chargeback.Recorder
+}
+
+// NewChargebackHandler creates a new chargeback handler
+func NewChargebackHandler(recorder *chargeback.Recorder) *ChargebackHandler {
+	return &ChargebackHandler{
+		recorder: recorder,
+	}
+}
+
+// ChargebackResponse represents a monthly chargeback report
+type ChargebackResponse struct {
+	Month     string              `json:"month"`
+	GroupBy   string              `json:"group_by"`
+	Usage     []*chargeback.Usage `json:"usage"`
+	CostUnits float64             `json:"cost_units"` // Sum over the report
+}
+
+// GetChargeback returns a month's usage per consumer or tenant
+// @Summary Get Chargeback Report
+// @Description Get a month's requests, bytes, route-weighted compute seconds and cost units per API key or token, or per tenant, as JSON or CSV
+// @Tags Admin
+// @Produce json
+// @Produce text/csv
+// @Param month query string false "Month as YYYY-MM, in UTC (default: current month)"
+// @Param group_by query string false "consumer (default) or tenant"
+// @Param format query string false "json (default) or csv"
+// @Success 200 {object} ChargebackResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/chargeback [get]
+// @Security BearerAuth
+func (h *ChargebackHandler) GetChargeback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	month := query.Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format(chargeback.MonthFormat)
+	} else if _, err := time.Parse(chargeback.MonthFormat, month); err != nil {
+		http.Error(w, `{"error":"Invalid month","details":"month must be YYYY-MM"}`, http.StatusBadRequest)
+		return
+	}
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		groupBy = "consumer"
+	}
+	if groupBy != "consumer" && groupBy != "tenant" {
+		http.Error(w, `{"error":"Invalid group_by","details":"group_by must be consumer or tenant"}`, http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, `{"error":"Invalid format","details":"format must be json or csv"}`, http.StatusBadRequest)
+		return
+	}
+
+	usages, err := h.recorder.Report(r.Context(), month, groupBy == "tenant")
+	if err != nil {
+		http.Error(w, `{"error":"Failed to build chargeback report","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="chargeback-`+month+`-`+groupBy+`.csv"`)
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"month", "tenant", "consumer", "name", "plan", "requests", "request_bytes", "response_bytes", "compute_seconds", "cost_units"})
+		for _, usage := range usages {
+			writer.Write([]string{
+				usage.Month,
+				usage.Tenant,
+				usage.Consumer,
+				usage.Name,
+				usage.Plan,
+				strconv.FormatInt(usage.Requests, 10),
+				strconv.FormatInt(usage.RequestBytes, 10),
+				strconv.FormatInt(usage.ResponseBytes, 10),
+				strconv.FormatFloat(usage.ComputeSeconds, 'f', 3, 64),
+				strconv.FormatFloat(usage.CostUnits, 'f', 4, 64),
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	response := ChargebackResponse{
+		Month:   month,
+		GroupBy: groupBy,
+		Usage:   usages,
+	}
+	for _, usage := range usages {
+		response.CostUnits += usage.CostUnits
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/cluster"
+)
+
+// ClusterHandler handles multi-instance coordination endpoints
+type ClusterHandler struct {
+	coordinator *cluster.Coordinator
+}
+
+// NewClusterHandler creates a new cluster handler
+func NewClusterHandler(coordinator *cluster.Coordinator) *ClusterHandler {
+	return &ClusterHandler{
+		coordinator: coordinator,
+	}
+}
+
+// GetStatus returns the cluster leader, members and leader job runs
+// @Summary Get Cluster Status
+// @Description Get the current leader, live gateway instances with their configuration versions, whether this instance's configuration matches the leader's, and the latest run of each leader-only job
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} cluster.Status
+// @Failure 503 {object} ErrorResponse
+// @Router /api/admin/cluster [get]
+// @Security BearerAuth
+func (h *ClusterHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.coordinator.Status(r.Context())
+	if err != nil {
+		http.Error(w, `{"error":"Cluster state unavailable","details":"`+err.Error()+`"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
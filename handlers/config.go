@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/config"
+)
+
+// ConfigHandler exposes the effective gateway configuration
+type ConfigHandler struct {
+	config *config.Config
+}
+
+// NewConfigHandler creates a new configuration handler
+func NewConfigHandler(cfg *config.Config) *ConfigHandler {
+	return &ConfigHandler{
+		config: cfg,
+	}
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+// @Summary Get Effective Configuration
+// @Description Get the configuration resolved from defaults, configuration files and environment overrides, with secrets redacted
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} config.Config
+// @Router /api/admin/config [get]
+// @Security BearerAuth
+func (h *ConfigHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.config.Redacted())
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/connlimit"
+)
+
+// ConnLimitHandler handles connection limit endpoints
+type ConnLimitHandler struct {
+	limiter *connlimit.Limiter
+}
+
+// NewConnLimitHandler creates a new connection limit handler
+func NewConnLimitHandler(limiter *connlimit.Limiter) *ConnLimitHandler {
+	return &ConnLimitHandler{
+		limiter: limiter,
+	}
+}
+
+// GetStats returns open connections and the connections held back by the limits
+// @Summary Get Connection Limit Stats
+// @Description Get open connections, the configured limits, and counts of accepts delayed and connections rejected by reason
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} connlimit.Stats
+// @Router /api/admin/connections [get]
+// @Security BearerAuth
+func (h *ConnLimitHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.limiter.Stats())
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/csrf"
+)
+
+// CSRFHandler handles CSRF token endpoints
+type CSRFHandler struct {
+	protector *csrf.Protector
+}
+
+// NewCSRFHandler creates a new CSRF token handler
+func NewCSRFHandler(protector *csrf.Protector) *CSRFHandler {
+	return &CSRFHandler{
+		protector: protector,
+	}
+}
+
+// IssueToken issues a CSRF token bound to the caller's session cookies
+// @Summary Issue CSRF Token
+// @Description Set a CSRF token cookie and return the token. Cookie-authenticated POST, PUT, PATCH and DELETE requests on protected routes must echo it in the returned header. Fetch a new token after the session cookie changes.
+// @Tags CSRF
+// @Produce json
+// @Success 200 {object} csrf.Token
+// @Failure 500 {object} ErrorResponse
+// @Router /api/csrf/token [get]
+func (h *CSRFHandler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	token, err := h.protector.Issue(w, r)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to issue CSRF token","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(token)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/debuglog"
+
+	"github.com/gorilla/mux"
+)
+
+// DebugLogHandler handles on-demand debug logging endpoints
+type DebugLogHandler struct {
+	logger *debuglog.Logger
+}
+
+// NewDebugLogHandler creates a new debug logging handler
+func NewDebugLogHandler(logger *debuglog.Logger) *DebugLogHandler {
+	return &DebugLogHandler{
+		logger: logger,
+	}
+}
+
+// EnableDebugLogRequest represents the request to enable debug logging
+type EnableDebugLogRequest struct {
+	PathPrefix string  `json:"path_prefix" example:"/api/user"`
+	APIKey     string  `json:"api_key" example:"ak_1234567890abcdef"`
+	UserID     string  `json:"user_id" example:"1"`
+	IP         string  `json:"ip" example:"203.0.113.10"`
+	SampleRate float64 `json:"sample_rate" example:"0.5"`
+	Duration   string  `json:"duration" example:"15m"`
+}
+
+// DebugLogRulesResponse represents the response for listing debug logging rules
+type DebugLogRulesResponse struct {
+	Rules []*debuglog.Rule `json:"rules"`
+	Count int              `json:"count"`
+}
+
+// EnableDebugLog enables debug logging for matching requests
+// @Summary Enable Debug Logging
+// @Description Log full request/response details for a route, API key, user or IP for a limited time
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body EnableDebugLogRequest true "Debug logging request"
+// @Success 201 {object} debuglog.Rule
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/debug/logging [post]
+// @Security BearerAuth
+func (h *DebugLogHandler) EnableDebugLog(w http.ResponseWriter, r *http.Request) {
+	var req EnableDebugLogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.SampleRate == 0 {
+		req.SampleRate = 1
+	}
+
+	duration := 15 * time.Minute
+	if req.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid duration format","details":"Use format like '15m', '1h'"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	rule := &debuglog.Rule{
+		PathPrefix: req.PathPrefix,
+		APIKey:     req.APIKey,
+		UserID:     req.UserID,
+		IP:         req.IP,
+		SampleRate: req.SampleRate,
+	}
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		rule.CreatedBy = userCtx.Username
+	}
+
+	created, err := h.logger.AddRule(rule, duration)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to enable debug logging","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ListDebugLogs lists active debug logging rules
+// @Summary List Debug Logging Rules
+// @Description List active debug logging rules and how many requests each has logged
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} DebugLogRulesResponse
+// @Router /api/admin/debug/logging [get]
+// @Security BearerAuth
+func (h *DebugLogHandler) ListDebugLogs(w http.ResponseWriter, r *http.Request) {
+	rules := h.logger.ListRules()
+
+	response := DebugLogRulesResponse{
+		Rules: rules,
+		Count: len(rules),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DisableDebugLog disables a debug logging rule
+// @Summary Disable Debug Logging
+// @Description Disable a debug logging rule before it expires
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Rule ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/debug/logging/{id} [delete]
+// @Security BearerAuth
+func (h *DebugLogHandler) DisableDebugLog(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.logger.RemoveRule(id); err != nil {
+		http.Error(w, `{"error":"Failed to disable debug logging","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Debug logging disabled successfully",
+		"id":      id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/device"
+	"api-gateway/static"
+)
+
+// deviceCodeGrantType is the grant_type polling for a device authorization
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// devicePage is the embedded page where users approve devices
+var devicePage = template.Must(template.New("device").Parse(static.DeviceHTML))
+
+// DeviceAuthorizationResponse is returned to a client starting the device flow
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceTokenResponse carries the gateway token issued to an approved device
+type DeviceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// DeviceVerifyRequest approves or denies a device by its user code
+type DeviceVerifyRequest struct {
+	UserCode string `json:"user_code"`
+	Approve  bool   `json:"approve"`
+}
+
+// DeviceHandler implements the OAuth device authorization grant (RFC 8628)
+type DeviceHandler struct {
+	manager         *device.Manager
+	auth            *AuthHandler
+	clients         map[string]bool // Allowed client IDs; any when empty
+	verificationURL string          // Derived from the request when empty
+	ssoLoginURL     string          // Offered on the verification page when set
+}
+
+// NewDeviceHandler creates a new device flow handler issuing tokens with authHandler
+func NewDeviceHandler(manager *device.Manager, authHandler *AuthHandler, clients []string, verificationURL, ssoLoginURL string) *DeviceHandler {
+	h := &DeviceHandler{
+		manager:         manager,
+		auth:            authHandler,
+		clients:         make(map[string]bool, len(clients)),
+		verificationURL: verificationURL,
+		ssoLoginURL:     ssoLoginURL,
+	}
+	for _, client := range clients {
+		h.clients[client] = true
+	}
+	return h
+}
+
+// Authorize starts a device authorization
+// @Summary Start device authorization
+// @Description Start the OAuth device flow. Show the user code and verification URI to the user, then poll /oauth/token.
+// @Tags Device Flow
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param client_id formData string true "Client ID"
+// @Param scope formData string false "Requested scope"
+// @Success 200 {object} DeviceAuthorizationResponse
+// @Failure 400 {object} map[string]string "invalid_request"
+// @Failure 401 {object} map[string]string "invalid_client"
+// @Router /oauth/device [post]
+func (h *DeviceHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form body")
+		return
+	}
+	clientID := r.PostForm.Get("client_id")
+	if clientID == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return
+	}
+	if len(h.clients) > 0 && !h.clients[clientID] {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Unknown client_id")
+		return
+	}
+
+	a, err := h.manager.Start(r.Context(), clientID, r.PostForm.Get("scope"))
+	if err != nil {
+		log.Printf("Failed to start device authorization: %v", err)
+		writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "Device authorization is temporarily unavailable")
+		return
+	}
+
+	verificationURI := h.verificationURL
+	if verificationURI == "" {
+		verificationURI = externalURL(r, "/oauth/device/verify")
+	}
+	writeOAuth(w, http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              a.DeviceCode,
+		UserCode:                a.FormattedUserCode(),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {a.FormattedUserCode()}}.Encode(),
+		ExpiresIn:               int(time.Until(a.ExpiresAt).Seconds()),
+		Interval:                int(h.manager.Interval().Seconds()),
+	})
+}
+
+// Token issues a gateway token once the user has approved the device
+// @Summary Poll for a device token
+// @Description Poll with the device code until the user approves or denies the request
+// @Tags Device Flow
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "urn:ietf:params:oauth:grant-type:device_code"
+// @Param device_code formData string true "Device code"
+// @Param client_id formData string true "Client ID"
+// @Success 200 {object} DeviceTokenResponse
+// @Failure 400 {object} map[string]string "authorization_pending, slow_down, access_denied, expired_token or invalid_grant"
+// @Router /oauth/token [post]
+func (h *DeviceHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form body")
+		return
+	}
+	if r.PostForm.Get("grant_type") != deviceCodeGrantType {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Only the device_code grant is supported")
+		return
+	}
+
+	a, err := h.manager.Poll(r.Context(), r.PostForm.Get("device_code"), r.PostForm.Get("client_id"))
+	switch {
+	case errors.Is(err, device.ErrAuthorizationPending), errors.Is(err, device.ErrSlowDown),
+		errors.Is(err, device.ErrAccessDenied), errors.Is(err, device.ErrExpiredToken):
+		writeOAuthError(w, http.StatusBadRequest, err.Error(), "")
+		return
+	case errors.Is(err, device.ErrNotFound):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Unknown device_code for this client")
+		return
+	case err != nil:
+		log.Printf("Failed to poll device authorization: %v", err)
+		writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "Device authorization is temporarily unavailable")
+		return
+	}
+
+	response, err := h.auth.issue(&auth.User{
+		ID:       a.Subject.UserID,
+		Username: a.Subject.Username,
+		Email:    a.Subject.Email,
+		Roles:    a.Subject.Roles,
+	})
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+	writeOAuth(w, http.StatusOK, DeviceTokenResponse{
+		AccessToken: response.Token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(response.ExpiresAt).Seconds()),
+		Scope:       a.Scope,
+	})
+}
+
+// VerifyPage serves the page where users sign in and approve a device
+// @Summary Device verification page
+// @Description Page where users enter the user code shown by the CLI and approve or deny it
+// @Tags Device Flow
+// @Produce html
+// @Param user_code query string false "User code"
+// @Success 200 {string} string "HTML page"
+// @Router /oauth/device/verify [get]
+func (h *DeviceHandler) VerifyPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	devicePage.Execute(w, struct {
+		UserCode    string
+		SSOLoginURL string
+	}{
+		UserCode:    r.URL.Query().Get("user_code"),
+		SSOLoginURL: h.ssoLoginURL,
+	})
+}
+
+// Verify approves or denies a device as the signed-in user
+// @Summary Approve or deny a device
+// @Description Approve a device so it receives a token for the current user, or deny it
+// @Tags Device Flow
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body DeviceVerifyRequest true "User code and decision"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} ErrorResponse "Invalid request body"
+// @Failure 401 {object} ErrorResponse "Authentication required"
+// @Failure 403 {object} ErrorResponse "Impersonation tokens cannot approve devices"
+// @Failure 404 {object} ErrorResponse "Unknown or expired code"
+// @Failure 409 {object} ErrorResponse "Code already used"
+// @Router /oauth/device/verify [post]
+func (h *DeviceHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+	if userCtx == nil {
+		http.Error(w, `{"error":"Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+	// Approving would hand a plain user token to whoever holds the device
+	if userCtx.Impersonated() {
+		http.Error(w, `{"error":"Approval not allowed","details":"Impersonation tokens cannot approve devices"}`, http.StatusForbidden)
+		return
+	}
+
+	var req DeviceVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserCode == "" {
+		http.Error(w, `{"error":"Invalid request body","details":"user_code is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	var a *device.Authorization
+	var err error
+	if req.Approve {
+		a, err = h.manager.Approve(r.Context(), req.UserCode, &device.Subject{
+			UserID:   userCtx.UserID,
+			Username: userCtx.Username,
+			Email:    userCtx.Email,
+			Roles:    userCtx.Roles,
+		})
+	} else {
+		a, err = h.manager.Deny(r.Context(), req.UserCode)
+	}
+	switch {
+	case errors.Is(err, device.ErrNotFound):
+		http.Error(w, `{"error":"Invalid code","details":"The code is unknown or has expired"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, device.ErrAlreadyDecided):
+		http.Error(w, `{"error":"Code already used","details":"The device was already approved or denied"}`, http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to decide device authorization: %v", err)
+		http.Error(w, `{"error":"Service unavailable","details":"Device authorization is temporarily unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Printf("Device authorization for client %q %s by user %s", a.ClientID, a.Status, userCtx.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    a.Status,
+		"client_id": a.ClientID,
+		"scope":     a.Scope,
+	})
+}
+
+// writeOAuth writes an OAuth JSON response, which must not be cached
+func writeOAuth(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeOAuthError writes an OAuth error response (RFC 6749 section 5.2)
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	writeOAuth(w, status, body)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/diagnostics"
+)
+
+// DiagnosticsHandler handles the self-diagnostics endpoint
+type DiagnosticsHandler struct {
+	runner *diagnostics.Runner
+}
+
+// NewDiagnosticsHandler creates a new diagnostics handler
+func NewDiagnosticsHandler(runner *diagnostics.Runner) *DiagnosticsHandler {
+	return &DiagnosticsHandler{
+		runner: runner,
+	}
+}
+
+// RunDiagnostics runs the synthetic checks
+// @Summary Run Diagnostics
+// @Description Run synthetic end-to-end checks on this instance: issue a token, request a proxied route, exercise the rate limiter and ping Redis. Answers 503 if a check failed.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} diagnostics.Report
+// @Failure 503 {object} diagnostics.Report
+// @Router /api/admin/diagnostics [get]
+// @Security BearerAuth
+func (h *DiagnosticsHandler) RunDiagnostics(w http.ResponseWriter, r *http.Request) {
+	report := h.runner.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "pass" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/ratelimit"
+
+	"github.com/gorilla/mux"
+)
+
+// ExemptionRequest represents a request to exempt callers from rate limiting
+type ExemptionRequest struct {
+	Kind   string `json:"kind" example:"ip"`               // "ip", "apikey" or "role"
+	Value  string `json:"value" example:"10.0.0.0/8"`      // IP address or CIDR range, API key or role
+	Reason string `json:"reason" example:"Health checker"` // Recorded in the audit log
+	TTL    string `json:"ttl,omitempty" example:"24h"`     // Lifetime such as "24h"; permanent when omitted
+}
+
+// ExemptionsHandler handles rate limit exemption endpoints
+type ExemptionsHandler struct {
+	exemptions *ratelimit.Exemptions
+}
+
+// NewExemptionsHandler creates a new rate limit exemptions handler
+func NewExemptionsHandler(exemptions *ratelimit.Exemptions) *ExemptionsHandler {
+	return &ExemptionsHandler{
+		exemptions: exemptions,
+	}
+}
+
+// ListExemptions lists rate limit exemptions
+// @Summary List Rate Limit Exemptions
+// @Description List the IPs, API keys and roles that bypass rate limiting, from configuration and the admin API
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} ratelimit.Exemption
+// @Router /api/admin/ratelimit/exemptions [get]
+// @Security BearerAuth
+func (h *ExemptionsHandler) ListExemptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.exemptions.List())
+}
+
+// AddExemption exempts callers from rate limiting
+// @Summary Add Rate Limit Exemption
+// @Description Let requests from an IP address or range, with an API key, or from callers holding a role bypass rate limiting. The change is audited.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body ExemptionRequest true "Exemption"
+// @Success 201 {object} ratelimit.Exemption
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/ratelimit/exemptions [post]
+// @Security BearerAuth
+func (h *ExemptionsHandler) AddExemption(w http.ResponseWriter, r *http.Request) {
+	var req ExemptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, `{"error":"Invalid request body","details":"reason is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, `{"error":"Invalid ttl","details":"ttl must be a positive duration like '24h'"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	exemption := &ratelimit.Exemption{
+		Kind:   req.Kind,
+		Value:  req.Value,
+		Reason: req.Reason,
+	}
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		exemption.CreatedBy = userCtx.Username
+	}
+	if err := h.exemptions.Add(r.Context(), exemption, ttl); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ratelimit.ErrInvalidExemption) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, `{"error":"Failed to add exemption","details":"`+err.Error()+`"}`, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exemption)
+}
+
+// RemoveExemption removes a rate limit exemption added through the API
+// @Summary Remove Rate Limit Exemption
+// @Description Remove an exemption added through the admin API. Configured exemptions are removed in configuration. The change is audited.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Exemption ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/admin/ratelimit/exemptions/{id} [delete]
+// @Security BearerAuth
+func (h *ExemptionsHandler) RemoveExemption(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	removedBy := ""
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		removedBy = userCtx.Username
+	}
+	if err := h.exemptions.Remove(r.Context(), id, removedBy); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ratelimit.ErrExemptionNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ratelimit.ErrConfiguredExemption):
+			status = http.StatusConflict
+		}
+		http.Error(w, `{"error":"Failed to remove exemption","details":"`+err.Error()+`"}`, status)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Exemption removed successfully",
+		"id":      id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
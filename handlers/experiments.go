@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/experiments"
+)
+
+// ExperimentsHandler handles experiment analytics endpoints
+type ExperimentsHandler struct {
+	assigner *experiments.Assigner
+}
+
+// NewExperimentsHandler creates a new experiments handler
+func NewExperimentsHandler(assigner *experiments.Assigner) *ExperimentsHandler {
+	return &ExperimentsHandler{
+		assigner: assigner,
+	}
+}
+
+// ExperimentStatsResponse represents experiment statistics response
+type ExperimentStatsResponse struct {
+	Experiments []*experiments.Summary `json:"experiments"`
+}
+
+// GetExperimentStats returns assignment and exposure counts per experiment variant
+// @Summary Get Experiment Statistics
+// @Description Get the callers assigned to and requests served under each experiment variant since the gateway started
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} ExperimentStatsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/admin/metrics/experiments [get]
+// @Security BearerAuth
+func (h *ExperimentsHandler) GetExperimentStats(w http.ResponseWriter, r *http.Request) {
+	response := ExperimentStatsResponse{
+		Experiments: h.assigner.Summary(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
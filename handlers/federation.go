@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/federation"
+)
+
+// FederationHandler handles replication status between regions
+type FederationHandler struct {
+	replicator *federation.Replicator
+}
+
+// NewFederationHandler creates a new federation handler
+func NewFederationHandler(replicator *federation.Replicator) *FederationHandler {
+	return &FederationHandler{
+		replicator: replicator,
+	}
+}
+
+// GetStatus returns replication lag from each other region
+// @Summary Get Federation Status
+// @Description Get this region's replicated resources, its latest sync, and for each other region the lag between publishing a change and reading it here, whether the region has gone quiet, and the changes applied and dropped as conflicts
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} federation.Status
+// @Failure 503 {object} ErrorResponse
+// @Router /api/admin/federation [get]
+// @Security BearerAuth
+func (h *FederationHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.replicator.Status(r.Context())
+	if err != nil {
+		http.Error(w, `{"error":"Federation state unavailable","details":"`+err.Error()+`"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/flags"
+
+	"github.com/gorilla/mux"
+)
+
+// FlagRequest represents a change to a feature flag; omitted fields keep
+// their current values
+type FlagRequest struct {
+	Description *string   `json:"description,omitempty"`
+	Enabled     *bool     `json:"enabled,omitempty" example:"true"`
+	Percent     *float64  `json:"percent,omitempty" example:"10"`
+	Roles       *[]string `json:"roles,omitempty"`
+	Start       *string   `json:"start,omitempty" example:"2025-01-31T09:00:00Z"` // RFC 3339; empty clears
+	End         *string   `json:"end,omitempty"`                                  // RFC 3339; empty clears
+}
+
+// CallerFlagsResponse represents the flags on for the caller
+type CallerFlagsResponse struct {
+	Flags []string `json:"flags"`
+}
+
+// FlagsHandler handles feature flag endpoints
+type FlagsHandler struct {
+	manager *flags.Manager
+}
+
+// NewFlagsHandler creates a new feature flag handler
+func NewFlagsHandler(manager *flags.Manager) *FlagsHandler {
+	return &FlagsHandler{
+		manager: manager,
+	}
+}
+
+// ListFlags lists every feature flag
+// @Summary List Feature Flags
+// @Description List feature flags with their rollout settings and whether they come from configuration or an override
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} flags.Flag
+// @Router /api/admin/flags [get]
+// @Security BearerAuth
+func (h *FlagsHandler) ListFlags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.manager.List())
+}
+
+// GetFlag returns a feature flag
+// @Summary Get Feature Flag
+// @Description Get a feature flag's rollout settings
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Flag name"
+// @Success 200 {object} flags.Flag
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/flags/{name} [get]
+// @Security BearerAuth
+func (h *FlagsHandler) GetFlag(w http.ResponseWriter, r *http.Request) {
+	flag, exists := h.manager.Get(mux.Vars(r)["name"])
+	if !exists {
+		http.Error(w, `{"error":"Flag not found","details":"No feature flag with that name"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+// UpdateFlag changes or creates a feature flag
+// @Summary Update Feature Flag
+// @Description Toggle a feature flag or change its rollout, overriding its configured settings. Unknown flags are created, on for everyone unless the request says otherwise.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param name path string true "Flag name"
+// @Param request body FlagRequest true "Changes"
+// @Success 200 {object} flags.Flag
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/flags/{name} [put]
+// @Security BearerAuth
+func (h *FlagsHandler) UpdateFlag(w http.ResponseWriter, r *http.Request) {
+	var req FlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	flag := &flags.Flag{Name: name, Enabled: true, Percent: 100}
+	if existing, ok := h.manager.Get(name); ok {
+		copied := *existing
+		flag = &copied
+	}
+
+	if req.Description != nil {
+		flag.Description = *req.Description
+	}
+	if req.Enabled != nil {
+		flag.Enabled = *req.Enabled
+	}
+	if req.Percent != nil {
+		flag.Percent = *req.Percent
+	}
+	if req.Roles != nil {
+		flag.Roles = *req.Roles
+	}
+	for _, field := range []struct {
+		value  *string
+		target **time.Time
+	}{{req.Start, &flag.Start}, {req.End, &flag.End}} {
+		if field.value == nil {
+			continue
+		}
+		if *field.value == "" {
+			*field.target = nil
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, *field.value)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid time format","details":"Use RFC 3339 format like '2025-01-31T09:00:00Z'"}`, http.StatusBadRequest)
+			return
+		}
+		*field.target = &t
+	}
+
+	now := time.Now()
+	flag.UpdatedAt = &now
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		flag.UpdatedBy = userCtx.Username
+	}
+
+	if err := h.manager.Set(r.Context(), flag); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, flags.ErrInvalid) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, `{"error":"Failed to update flag","details":"`+err.Error()+`"}`, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+// ResetFlag removes a feature flag's override
+// @Summary Reset Feature Flag
+// @Description Remove changes made through the API, restoring the flag's configured settings or removing a flag created through the API
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Flag name"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/flags/{name} [delete]
+// @Security BearerAuth
+func (h *FlagsHandler) ResetFlag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := h.manager.Reset(r.Context(), name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, flags.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, `{"error":"Failed to reset flag","details":"`+err.Error()+`"}`, status)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Flag reset successfully",
+		"name":    name,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CallerFlags lists the feature flags on for the caller
+// @Summary My Feature Flags
+// @Description List the feature flags that are on for the authenticated caller
+// @Tags Protected
+// @Produce json
+// @Success 200 {object} CallerFlagsResponse
+// @Router /api/flags [get]
+// @Security BearerAuth
+// @Security ApiKeyAuth
+func (h *FlagsHandler) CallerFlags(w http.ResponseWriter, r *http.Request) {
+	enabled := h.manager.EnabledFlags(r)
+	if enabled == nil {
+		enabled = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallerFlagsResponse{Flags: enabled})
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/fleet"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateDeviceRequest represents a request to register a device
+type CreateDeviceRequest struct {
+	ID         string            `json:"id,omitempty" example:"thermostat-42"` // Generated when omitted
+	Name       string            `json:"name,omitempty" example:"Lobby thermostat"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Credential string            `json:"credential,omitempty" example:"api_key"` // "api_key" (default) or "certificate"
+	Policy     fleet.Policy      `json:"policy"`
+}
+
+// EnrollmentResponse carries the one-time code a device enrolls with
+type EnrollmentResponse struct {
+	Device         *fleet.Device `json:"device"`
+	EnrollmentCode string        `json:"enrollment_code"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+}
+
+// EnrollRequest represents a device redeeming its enrollment code
+type EnrollRequest struct {
+	Code string `json:"code" example:"enr_3f2a..."`
+	CSR  string `json:"csr,omitempty"` // PEM certificate signing request, for certificate devices
+}
+
+// EnrollResponse carries the credentials issued to an enrolled device
+type EnrollResponse struct {
+	Device      *fleet.Device      `json:"device"`
+	Credentials *fleet.Credentials `json:"credentials"`
+}
+
+// DeviceResponse is a device with its usage of the current quota window
+type DeviceResponse struct {
+	*fleet.Device
+	QuotaUsed int64 `json:"quota_used"`
+}
+
+// FleetHandler handles the device registry endpoints
+type FleetHandler struct {
+	registry *fleet.Registry
+}
+
+// NewFleetHandler creates a new device registry handler
+func NewFleetHandler(registry *fleet.Registry) *FleetHandler {
+	return &FleetHandler{
+		registry: registry,
+	}
+}
+
+// CreateDevice registers a device
+// @Summary Register Device
+// @Description Register a device, returning the one-time code it enrolls with for an API key or a client certificate. The change is audited.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body CreateDeviceRequest true "Device"
+// @Success 201 {object} EnrollmentResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/admin/devices [post]
+// @Security BearerAuth
+func (h *FleetHandler) CreateDevice(w http.ResponseWriter, r *http.Request) {
+	var req CreateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	device := &fleet.Device{
+		ID:         req.ID,
+		Name:       req.Name,
+		Labels:     req.Labels,
+		Credential: req.Credential,
+		Policy:     req.Policy,
+	}
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		device.CreatedBy = userCtx.Username
+	}
+	code, expiresAt, err := h.registry.Create(r.Context(), device)
+	if err != nil {
+		writeFleetError(w, "Failed to register device", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(EnrollmentResponse{
+		Device:         device,
+		EnrollmentCode: code,
+		ExpiresAt:      expiresAt,
+	})
+}
+
+// ListDevices lists registered devices
+// @Summary List Devices
+// @Description List registered devices with their status, credential and policy
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} fleet.Device
+// @Router /api/admin/devices [get]
+// @Security BearerAuth
+func (h *FleetHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := h.registry.List(r.Context())
+	if err != nil {
+		writeFleetError(w, "Failed to list devices", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// GetDevice returns a device
+// @Summary Get Device
+// @Description Get a registered device and the requests it made in the current quota window
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Device ID"
+// @Success 200 {object} DeviceResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/devices/{id} [get]
+// @Security BearerAuth
+func (h *FleetHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	device, err := h.registry.Get(r.Context(), id)
+	if err != nil {
+		writeFleetError(w, "Failed to get device", err)
+		return
+	}
+	used, err := h.registry.Usage(r.Context(), id)
+	if err != nil {
+		writeFleetError(w, "Failed to get device", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceResponse{Device: device, QuotaUsed: used})
+}
+
+// SetDevicePolicy replaces a device's limits
+// @Summary Set Device Policy
+// @Description Replace a device's rate limit and quota. Zero values fall back to the configured defaults.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param request body fleet.Policy true "Policy"
+// @Success 200 {object} fleet.Device
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/devices/{id}/policy [put]
+// @Security BearerAuth
+func (h *FleetHandler) SetDevicePolicy(w http.ResponseWriter, r *http.Request) {
+	var policy fleet.Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	device, err := h.registry.SetPolicy(r.Context(), mux.Vars(r)["id"], policy)
+	if err != nil {
+		writeFleetError(w, "Failed to set device policy", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(device)
+}
+
+// NewDeviceEnrollment issues a new enrollment code for a device
+// @Summary Re-enroll Device
+// @Description Issue a new one-time enrollment code, replacing any outstanding one. Enrolling with it replaces the device's credentials.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Device ID"
+// @Success 200 {object} EnrollmentResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/admin/devices/{id}/enrollment [post]
+// @Security BearerAuth
+func (h *FleetHandler) NewDeviceEnrollment(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	code, expiresAt, err := h.registry.NewEnrollment(r.Context(), id)
+	if err != nil {
+		writeFleetError(w, "Failed to issue enrollment code", err)
+		return
+	}
+	device, err := h.registry.Get(r.Context(), id)
+	if err != nil {
+		writeFleetError(w, "Failed to issue enrollment code", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EnrollmentResponse{
+		Device:         device,
+		EnrollmentCode: code,
+		ExpiresAt:      expiresAt,
+	})
+}
+
+// RevokeDevice revokes a device's credentials
+// @Summary Revoke Device
+// @Description Revoke a device's API key or certificate and refuse its requests. Revoked devices cannot enroll again. The change is audited.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Device ID"
+// @Success 200 {object} fleet.Device
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/devices/{id}/revoke [post]
+// @Security BearerAuth
+func (h *FleetHandler) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	revokedBy := ""
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		revokedBy = userCtx.Username
+	}
+	device, err := h.registry.Revoke(r.Context(), mux.Vars(r)["id"], revokedBy)
+	if err != nil {
+		writeFleetError(w, "Failed to revoke device", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(device)
+}
+
+// DeleteDevice removes a device
+// @Summary Delete Device
+// @Description Revoke a device's credentials and remove it from the registry. The change is audited.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Device ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/devices/{id} [delete]
+// @Security BearerAuth
+func (h *FleetHandler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	deletedBy := ""
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		deletedBy = userCtx.Username
+	}
+	if err := h.registry.Delete(r.Context(), id, deletedBy); err != nil {
+		writeFleetError(w, "Failed to delete device", err)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Device deleted successfully",
+		"id":      id,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Enroll redeems an enrollment code for a device's credentials
+// @Summary Enroll Device
+// @Description Redeem a one-time enrollment code for the device's API key, or for a client certificate signed from the certificate request. Credentials are returned once and replace those of any earlier enrollment.
+// @Tags Devices
+// @Accept json
+// @Produce json
+// @Param request body EnrollRequest true "Enrollment"
+// @Success 201 {object} EnrollResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /devices/enroll [post]
+func (h *FleetHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	var req EnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" {
+		http.Error(w, `{"error":"Invalid request body","details":"code is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	device, credentials, err := h.registry.Enroll(r.Context(), req.Code, []byte(req.CSR))
+	if err != nil {
+		// Unknown, used and revoked codes look alike to callers
+		if errors.Is(err, fleet.ErrInvalidCode) || errors.Is(err, fleet.ErrRevoked) || errors.Is(err, fleet.ErrNotFound) {
+			http.Error(w, `{"error":"Enrollment failed","details":"`+fleet.ErrInvalidCode.Error()+`"}`, http.StatusUnauthorized)
+			return
+		}
+		writeFleetError(w, "Enrollment failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(EnrollResponse{
+		Device:      device,
+		Credentials: credentials,
+	})
+}
+
+// writeFleetError writes a device registry error with its status
+func writeFleetError(w http.ResponseWriter, message string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fleet.ErrInvalidDevice):
+		status = http.StatusBadRequest
+	case errors.Is(err, fleet.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, fleet.ErrExists), errors.Is(err, fleet.ErrRevoked):
+		status = http.StatusConflict
+	}
+	http.Error(w, `{"error":"`+message+`","details":"`+err.Error()+`"}`, status)
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"api-gateway/groups"
+
+	"github.com/gorilla/mux"
+)
+
+// GroupRequest represents the payload for creating or updating a group
+type GroupRequest struct {
+	Name        string   `json:"name,omitempty"` // Required when creating
+	DisplayName string   `json:"display_name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Roles       []string `json:"roles"`
+	Members     []string `json:"members"` // User IDs
+}
+
+// GroupsHandler handles group management endpoints
+type GroupsHandler struct {
+	manager *groups.Manager
+}
+
+// NewGroupsHandler creates a new group handler
+func NewGroupsHandler(manager *groups.Manager) *GroupsHandler {
+	return &GroupsHandler{
+		manager: manager,
+	}
+}
+
+// ListGroups lists every group
+// @Summary List Groups
+// @Description List groups with their roles and members
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} groups.Group
+// @Router /api/admin/groups [get]
+// @Security BearerAuth
+func (h *GroupsHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.manager.List())
+}
+
+// GetGroup returns a group
+// @Summary Get Group
+// @Description Get a group with its roles and members
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Group name"
+// @Success 200 {object} groups.Group
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/groups/{name} [get]
+// @Security BearerAuth
+func (h *GroupsHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	group, exists := h.manager.Get(mux.Vars(r)["name"])
+	if !exists {
+		http.Error(w, `{"error":"Group not found","details":"No group with that name"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// CreateGroup creates a group
+// @Summary Create Group
+// @Description Create a group whose roles are granted to its members
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body GroupRequest true "Group"
+// @Success 201 {object} groups.Group
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/admin/groups [post]
+// @Security BearerAuth
+func (h *GroupsHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	err := h.manager.Create(r.Context(), &groups.Group{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Roles:       req.Roles,
+		Members:     req.Members,
+	})
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	group, _ := h.manager.Get(req.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// UpdateGroup replaces the description, roles and members of a group
+// @Summary Update Group
+// @Description Replace the description, roles and members of a group
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param name path string true "Group name"
+// @Param request body GroupRequest true "Group"
+// @Success 200 {object} groups.Group
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/groups/{name} [put]
+// @Security BearerAuth
+func (h *GroupsHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	err := h.manager.Update(r.Context(), &groups.Group{
+		Name:        name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Roles:       req.Roles,
+		Members:     req.Members,
+	})
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	group, _ := h.manager.Get(name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// DeleteGroup deletes a group
+// @Summary Delete Group
+// @Description Delete a group; its members lose the roles it granted
+// @Tags Admin
+// @Param name path string true "Group name"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/groups/{name} [delete]
+// @Security BearerAuth
+func (h *GroupsHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if err := h.manager.Delete(r.Context(), mux.Vars(r)["name"]); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddMember adds a user to a group
+// @Summary Add Group Member
+// @Description Add a user to a group
+// @Tags Admin
+// @Param name path string true "Group name"
+// @Param user_id path string true "User ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/groups/{name}/members/{user_id} [put]
+// @Security BearerAuth
+func (h *GroupsHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.manager.AddMember(r.Context(), vars["name"], vars["user_id"]); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMember removes a user from a group
+// @Summary Remove Group Member
+// @Description Remove a user from a group
+// @Tags Admin
+// @Param name path string true "Group name"
+// @Param user_id path string true "User ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/groups/{name}/members/{user_id} [delete]
+// @Security BearerAuth
+func (h *GroupsHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.manager.RemoveMember(r.Context(), vars["name"], vars["user_id"]); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeGroupError maps group errors to responses
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, groups.ErrNotFound):
+		http.Error(w, `{"error":"Group not found","details":"No group with that name"}`, http.StatusNotFound)
+	case errors.Is(err, groups.ErrExists):
+		http.Error(w, `{"error":"Group already exists","details":"Choose another name or update the group"}`, http.StatusConflict)
+	case errors.Is(err, groups.ErrInvalidName):
+		http.Error(w, `{"error":"Invalid group name","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+	default:
+		http.Error(w, `{"error":"Failed to update groups","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+	}
+}
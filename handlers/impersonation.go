@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// ImpersonationRequest represents a request to act as another user
+type ImpersonationRequest struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`        // Recorded in the audit log
+	TTL      string `json:"ttl,omitempty"` // Token lifetime such as "15m"; defaults to the maximum lifetime
+}
+
+// ImpersonationHandler handles impersonation token endpoints
+type ImpersonationHandler struct {
+	authHandler *AuthHandler
+	maxLifetime time.Duration
+}
+
+// NewImpersonationHandler creates a new impersonation handler
+func NewImpersonationHandler(authHandler *AuthHandler, maxLifetime time.Duration) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		authHandler: authHandler,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// Impersonate mints a short-lived token for acting as another user
+// @Summary Impersonate User
+// @Description Issue a token carrying the user's identity and roles with an "act" claim naming the administrator. Every request made with it is audited; it cannot be refreshed or used to impersonate again.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body ImpersonationRequest true "User to act as and the reason"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/impersonate [post]
+// @Security BearerAuth
+func (h *ImpersonationHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	actorCtx := auth.GetUserFromContext(r)
+	if actorCtx == nil {
+		http.Error(w, `{"error":"Authentication required","details":"User context not found"}`, http.StatusUnauthorized)
+		return
+	}
+	if actorCtx.Impersonated() {
+		http.Error(w, `{"error":"Impersonation not allowed","details":"Impersonation tokens cannot be used to impersonate again"}`, http.StatusForbidden)
+		return
+	}
+
+	var req ImpersonationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" || req.Reason == "" {
+		http.Error(w, `{"error":"Invalid request body","details":"username and reason are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	lifetime := h.maxLifetime
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || ttl > h.maxLifetime {
+			http.Error(w, `{"error":"Invalid ttl","details":"ttl must be a positive duration of at most `+h.maxLifetime.String()+`"}`, http.StatusBadRequest)
+			return
+		}
+		lifetime = ttl
+	}
+
+	user, err := h.authHandler.users.Lookup(r.Context(), req.Username)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		http.Error(w, `{"error":"User not found","details":"No user with that username"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up %q for impersonation: %v", req.Username, err)
+		http.Error(w, `{"error":"User lookup failed","details":"The user directory is unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+	if user.ID == actorCtx.UserID {
+		http.Error(w, `{"error":"Invalid request body","details":"Cannot impersonate yourself"}`, http.StatusBadRequest)
+		return
+	}
+
+	actor := &auth.Actor{Subject: actorCtx.UserID, Username: actorCtx.Username}
+	roles, groups := h.authHandler.withGroups(user.ID, user.Roles)
+	token, err := h.authHandler.jwtManager.GenerateImpersonationToken(user.ID, user.Username, user.Email, roles, groups, actor, lifetime)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to generate token","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Audit: %s (%s) issued an impersonation token for %s (%s) valid for %s, reason %q",
+		actor.Username, actor.Subject, user.Username, user.ID, lifetime, req.Reason)
+
+	response := LoginResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(lifetime),
+		User: UserInfo{
+			ID:       user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			Roles:    roles,
+			Groups:   groups,
+			Actor:    actor,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
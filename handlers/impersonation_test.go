@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// unavailableUsers is a user directory that cannot be reached
+type unavailableUsers struct{}
+
+func (unavailableUsers) Authenticate(ctx context.Context, username, password string) (*auth.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (unavailableUsers) Lookup(ctx context.Context, username string) (*auth.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestImpersonateDenies(t *testing.T) {
+	admin := &auth.UserContext{UserID: "1", Username: "admin", Roles: []string{"admin"}, AuthType: "jwt"}
+	tests := []struct {
+		name      string
+		actor     *auth.UserContext
+		body      string
+		directory auth.UserStore
+		status    int
+	}{
+		{name: "impersonating", actor: admin, body: `{"username":"user","reason":"ticket 42","ttl":"15m"}`, status: http.StatusOK},
+		{name: "unauthenticated", body: `{"username":"user","reason":"ticket 42"}`, status: http.StatusUnauthorized},
+		{name: "impersonation token", actor: &auth.UserContext{UserID: "3", Username: "moderator", AuthType: "jwt", Actor: &auth.Actor{Subject: "1", Username: "admin"}},
+			body: `{"username":"user","reason":"ticket 42"}`, status: http.StatusForbidden},
+		{name: "malformed body", actor: admin, body: `{"username":`, status: http.StatusBadRequest},
+		{name: "missing username", actor: admin, body: `{"reason":"ticket 42"}`, status: http.StatusBadRequest},
+		{name: "missing reason", actor: admin, body: `{"username":"user"}`, status: http.StatusBadRequest},
+		{name: "ttl over the maximum", actor: admin, body: `{"username":"user","reason":"ticket 42","ttl":"2h"}`, status: http.StatusBadRequest},
+		{name: "negative ttl", actor: admin, body: `{"username":"user","reason":"ticket 42","ttl":"-5m"}`, status: http.StatusBadRequest},
+		{name: "malformed ttl", actor: admin, body: `{"username":"user","reason":"ticket 42","ttl":"soon"}`, status: http.StatusBadRequest},
+		{name: "unknown user", actor: admin, body: `{"username":"nobody","reason":"ticket 42"}`, status: http.StatusNotFound},
+		{name: "oneself", actor: admin, body: `{"username":"admin","reason":"ticket 42"}`, status: http.StatusBadRequest},
+		{name: "directory unavailable", actor: admin, body: `{"username":"user","reason":"ticket 42"}`, directory: unavailableUsers{}, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtManager := auth.NewJWTManager("secret", "api-gateway", "api-users", time.Hour)
+			authHandler := NewAuthHandler(jwtManager)
+			if tt.directory != nil {
+				authHandler.SetUserStore(tt.directory)
+			}
+			handler := NewImpersonationHandler(authHandler, time.Hour)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/impersonate", strings.NewReader(tt.body))
+			if tt.actor != nil {
+				r = auth.WithUser(r, tt.actor)
+			}
+			rec := httptest.NewRecorder()
+			handler.Impersonate(rec, r)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var response LoginResponse
+			json.Unmarshal(rec.Body.Bytes(), &response)
+			if tt.status != http.StatusOK {
+				if response.Token != "" {
+					t.Errorf("denied request returned a token: %s", rec.Body)
+				}
+				return
+			}
+			claims, err := jwtManager.ValidateToken(response.Token)
+			if err != nil || claims.UserID != "2" || claims.Actor == nil || claims.Actor.Subject != "1" {
+				t.Errorf("token claims %+v, %v; want user 2 acted as by 1", claims, err)
+			}
+		})
+	}
+}
+
+func TestImpersonationTokensAreDenied(t *testing.T) {
+	impersonated := &auth.UserContext{UserID: "2", Username: "user", Roles: []string{"user"}, AuthType: "jwt", Actor: &auth.Actor{Subject: "1", Username: "admin"}}
+	authHandler := NewAuthHandler(auth.NewJWTManager("secret", "api-gateway", "api-users", time.Hour))
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"impersonating again", NewImpersonationHandler(authHandler, time.Hour).Impersonate, `{"username":"moderator","reason":"ticket 42"}`},
+		{"refreshing", authHandler.RefreshToken, ""},
+		{"creating a personal access token", NewAccountTokensHandler(nil).CreateToken, `{"name":"ci","scopes":["*"]}`},
+		{"approving a device", NewDeviceHandler(nil, authHandler, nil, "", "").Verify, `{"user_code":"ABCD-EFGH","approve":true}`},
+	}
+	for _, tt := range tests {
+		r := auth.WithUser(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)), impersonated)
+		rec := httptest.NewRecorder()
+		tt.handler(rec, r)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403: %s", tt.name, rec.Code, rec.Body)
+		}
+	}
+}
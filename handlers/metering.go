@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"api-gateway/chargeback"
+	"api-gateway/metering"
+)
+
+// MeteringHandler handles metered billing endpoints
+type MeteringHandler struct {
+	meter *metering.Meter
+}
+
+// NewMeteringHandler creates a new metering handler
+func NewMeteringHandler(meter *metering.Meter) *MeteringHandler {
+	return &MeteringHandler{
+		meter: meter,
+	}
+}
+
+// MeteringReconciliationResponse represents a month's metering reconciliation
+type MeteringReconciliationResponse struct {
+	Month     string                     `json:"month"`
+	Consumers []*metering.Reconciliation `json:"consumers"`
+	LastRun   *metering.RunResult        `json:"last_run,omitempty"` // Latest run on this instance
+}
+
+// GetReconciliation compares metered usage with what was reported to the billing provider
+// @Summary Get Metering Reconciliation
+// @Description Get, per consumer, the usage metered in a month, the quantity reported to the billing provider, what is still pending and the last push error
+// @Tags Admin
+// @Produce json
+// @Param month query string false "Month as YYYY-MM, in UTC (default: current month)"
+// @Success 200 {object} MeteringReconciliationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/metering/reconciliation [get]
+// @Security BearerAuth
+func (h *MeteringHandler) GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format(chargeback.MonthFormat)
+	} else if _, err := time.Parse(chargeback.MonthFormat, month); err != nil {
+		http.Error(w, `{"error":"Invalid month","details":"month must be YYYY-MM"}`, http.StatusBadRequest)
+		return
+	}
+
+	consumers, err := h.meter.Reconcile(r.Context(), month)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to reconcile metering","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := MeteringReconciliationResponse{
+		Month:     month,
+		Consumers: consumers,
+		LastRun:   h.meter.LastRun(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/metrics"
+)
+
+// MetricsHandler handles analytics endpoints backed by gateway metrics
+type MetricsHandler struct {
+	transfer *metrics.TransferMetrics
+}
+
+// NewMetricsHandler creates a new metrics handler
+func NewMetricsHandler(transfer *metrics.TransferMetrics) *MetricsHandler {
+	return &MetricsHandler{
+		transfer: transfer,
+	}
+}
+
+// TransferStatsResponse represents transfer statistics response
+type TransferStatsResponse struct {
+	Transfer map[string]map[string]*metrics.TransferSummary `json:"transfer"`
+}
+
+// GetTransferStats returns request/response byte totals per route and consumer
+// @Summary Get Transfer Statistics
+// @Description Get request and response body byte totals grouped by route and consumer
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} TransferStatsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/admin/metrics/transfer [get]
+// @Security BearerAuth
+func (h *MetricsHandler) GetTransferStats(w http.ResponseWriter, r *http.Request) {
+	response := TransferStatsResponse{
+		Transfer: h.transfer.Summary(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
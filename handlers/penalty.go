@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"api-gateway/auth"
+	"api-gateway/penalty"
+
+	"github.com/gorilla/mux"
+)
+
+// PenaltyHandler handles penalty box endpoints
+type PenaltyHandler struct {
+	box *penalty.Box
+}
+
+// NewPenaltyHandler creates a new penalty box handler
+func NewPenaltyHandler(box *penalty.Box) *PenaltyHandler {
+	return &PenaltyHandler{
+		box: box,
+	}
+}
+
+// ListPenalties lists the clients in the penalty box
+// @Summary List Penalized Clients
+// @Description List clients whose repeated 401/429 responses got them limited or banned, with when they are released
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} penalty.Entry
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/penalties [get]
+// @Security BearerAuth
+func (h *PenaltyHandler) ListPenalties(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.box.List(r.Context())
+	if err != nil {
+		http.Error(w, `{"error":"Failed to list penalties","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*penalty.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ReleasePenalty releases a client from the penalty box
+// @Summary Release Penalized Client
+// @Description Release a client from the penalty box and forget its strikes and earlier offences. The release is audited.
+// @Tags Admin
+// @Produce json
+// @Param client path string true "Client, such as ip:203.0.113.7 or user:42"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/penalties/{client} [delete]
+// @Security BearerAuth
+func (h *PenaltyHandler) ReleasePenalty(w http.ResponseWriter, r *http.Request) {
+	client := mux.Vars(r)["client"]
+	releasedBy := ""
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		releasedBy = userCtx.Username
+	}
+	if err := h.box.Release(r.Context(), client, releasedBy); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, penalty.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, `{"error":"Failed to release client","details":"`+err.Error()+`"}`, status)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Client released successfully",
+		"client":  client,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/config"
+	"api-gateway/metrics"
+	"api-gateway/product"
+
+	"github.com/gorilla/mux"
+)
+
+// PortalHandler handles developer portal endpoints
+type PortalHandler struct {
+	config          *config.PortalConfig
+	catalog         *product.Catalog
+	apiKeyStore     *auth.APIKeyStore
+	transferMetrics *metrics.TransferMetrics
+}
+
+// NewPortalHandler creates a new developer portal handler
+func NewPortalHandler(cfg *config.PortalConfig, catalog *product.Catalog, apiKeyStore *auth.APIKeyStore, transferMetrics *metrics.TransferMetrics) *PortalHandler {
+	return &PortalHandler{
+		config:          cfg,
+		catalog:         catalog,
+		apiKeyStore:     apiKeyStore,
+		transferMetrics: transferMetrics,
+	}
+}
+
+// PortalPlan represents a self-service plan
+type PortalPlan struct {
+	Name      string `json:"name" example:"free"`
+	RateLimit int    `json:"rate_limit" example:"60"` // Requests per minute
+}
+
+// PortalProductsResponse represents the response for listing API products
+type PortalProductsResponse struct {
+	Products []*product.Product `json:"products"`
+	Plans    []PortalPlan       `json:"plans"`
+	Count    int                `json:"count"`
+}
+
+// PortalCreateKeyRequest represents a developer's request for a new API key
+type PortalCreateKeyRequest struct {
+	Name     string   `json:"name" example:"My App"`
+	Plan     string   `json:"plan" example:"free"`
+	Products []string `json:"products" example:"orders"` // Defaults to every product offered on the plan
+}
+
+// PortalSubscribeRequest represents the request to change a key's product subscriptions
+type PortalSubscribeRequest struct {
+	Products []string `json:"products" example:"orders,billing"`
+}
+
+// PortalKeyUsage represents the usage of one of the developer's keys
+type PortalKeyUsage struct {
+	Key        string    `json:"key"`
+	Name       string    `json:"name"`
+	Plan       string    `json:"plan"`
+	Products   []string  `json:"products"`
+	RateLimit  int       `json:"rate_limit"`
+	Requests   int64     `json:"requests"`
+	IsActive   bool      `json:"is_active"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// PortalUsageResponse represents the response for a developer's usage
+type PortalUsageResponse struct {
+	Keys     []PortalKeyUsage         `json:"keys"`
+	Requests int64                    `json:"requests"` // Requests across all keys
+	Transfer *metrics.TransferSummary `json:"transfer"` // Traffic of the developer's keys and tokens
+}
+
+// ListProducts lists the API products available for self-service
+// @Summary List API Products
+// @Description List API products (route groups) and the plans developers can sign up for
+// @Tags Developer Portal
+// @Produce json
+// @Success 200 {object} PortalProductsResponse
+// @Router /api/portal/products [get]
+// @Security BearerAuth
+func (h *PortalHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
+	plans := make([]PortalPlan, 0, len(h.config.Plans))
+	for name, rateLimit := range h.config.Plans {
+		plans = append(plans, PortalPlan{Name: name, RateLimit: rateLimit})
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Name < plans[j].Name
+	})
+
+	products := h.catalog.Products()
+	if products == nil {
+		products = []*product.Product{}
+	}
+
+	response := PortalProductsResponse{
+		Products: products,
+		Plans:    plans,
+		Count:    len(products),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListKeys lists the authenticated developer's API keys
+// @Summary List My API Keys
+// @Description List the API keys owned by the authenticated developer
+// @Tags Developer Portal
+// @Produce json
+// @Success 200 {object} ListAPIKeysResponse
+// @Router /api/portal/keys [get]
+// @Security BearerAuth
+func (h *PortalHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+
+	apiKeys := h.apiKeyStore.ListAPIKeys(userCtx.UserID)
+	if apiKeys == nil {
+		apiKeys = []*auth.APIKey{}
+	}
+
+	response := ListAPIKeysResponse{
+		APIKeys: apiKeys,
+		Count:   len(apiKeys),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateKey creates an API key for the authenticated developer
+// @Summary Create My API Key
+// @Description Generate an API key on one of the self-service plans, subscribed to products offered on that plan
+// @Tags Developer Portal
+// @Accept json
+// @Produce json
+// @Param request body PortalCreateKeyRequest true "Key request"
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/portal/keys [post]
+// @Security BearerAuth
+func (h *PortalHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+
+	var req PortalCreateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" || req.Plan == "" {
+		http.Error(w, `{"error":"Missing required fields","details":"name and plan are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	rateLimit, ok := h.config.Plans[req.Plan]
+	if !ok {
+		http.Error(w, `{"error":"Unknown plan","details":"The plan is not available for self-service"}`, http.StatusBadRequest)
+		return
+	}
+
+	products := req.Products
+	if len(products) == 0 {
+		products = h.catalog.OfferedOn(req.Plan)
+	} else if err := h.checkProducts(products, req.Plan); err != "" {
+		http.Error(w, `{"error":"Invalid products","details":"`+err+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	active := 0
+	for _, key := range h.apiKeyStore.ListAPIKeys(userCtx.UserID) {
+		if key.IsActive && time.Now().Before(key.ExpiresAt) {
+			active++
+		}
+	}
+	if active >= h.config.MaxKeys {
+		http.Error(w, `{"error":"Key limit reached","details":"Revoke an existing key before creating another"}`, http.StatusForbidden)
+		return
+	}
+
+	apiKey, err := h.apiKeyStore.GenerateAPIKey(req.Name, userCtx.UserID, h.config.KeyRoles, rateLimit, req.Plan, products, h.config.KeyTTL)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to create API key","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := CreateAPIKeyResponse{
+		APIKey:    apiKey,
+		Message:   "API key created successfully",
+		CreatedAt: time.Now(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeKey revokes one of the authenticated developer's API keys
+// @Summary Revoke My API Key
+// @Description Revoke an API key owned by the authenticated developer
+// @Tags Developer Portal
+// @Produce json
+// @Param key path string true "API Key"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} ErrorResponse
+// @Router /api/portal/keys/{key}/revoke [post]
+// @Security BearerAuth
+func (h *PortalHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+	key := mux.Vars(r)["key"]
+
+	// Keys of other developers are reported as missing rather than forbidden
+	apiKey, exists := h.apiKeyStore.GetAPIKey(key)
+	if !exists || apiKey.Deleted() || apiKey.UserID != userCtx.UserID {
+		http.Error(w, `{"error":"API key not found","details":"The specified API key does not exist"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := h.apiKeyStore.RevokeAPIKey(key); err != nil {
+		http.Error(w, `{"error":"Failed to revoke API key","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{
+		"message": "API key revoked successfully",
+		"key":     key,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Subscribe replaces the product subscriptions of one of the developer's keys
+// @Summary Subscribe My API Key to Products
+// @Description Replace the API products an API key owned by the authenticated developer is subscribed to
+// @Tags Developer Portal
+// @Accept json
+// @Produce json
+// @Param key path string true "API Key"
+// @Param request body PortalSubscribeRequest true "Subscriptions"
+// @Success 200 {object} auth.APIKey
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/portal/keys/{key}/products [put]
+// @Security BearerAuth
+func (h *PortalHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+	key := mux.Vars(r)["key"]
+
+	apiKey, exists := h.apiKeyStore.GetAPIKey(key)
+	if !exists || apiKey.Deleted() || apiKey.UserID != userCtx.UserID {
+		http.Error(w, `{"error":"API key not found","details":"The specified API key does not exist"}`, http.StatusNotFound)
+		return
+	}
+
+	var req PortalSubscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.checkProducts(req.Products, apiKey.Plan); err != "" {
+		http.Error(w, `{"error":"Invalid products","details":"`+err+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.apiKeyStore.SetProducts(key, req.Products)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to update subscriptions","details":"`+err.Error()+`"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// checkProducts returns a description of the first product that does not
+// exist or is not offered on the plan, or ""
+func (h *PortalHandler) checkProducts(names []string, plan string) string {
+	for _, name := range names {
+		p, exists := h.catalog.Get(name)
+		if !exists {
+			return "Unknown product " + name
+		}
+		if !p.Offers(plan) {
+			return "Product " + name + " is not offered on plan " + plan
+		}
+	}
+	return ""
+}
+
+// GetUsage returns the authenticated developer's usage
+// @Summary Get My Usage
+// @Description Get request counts per key and total traffic for the authenticated developer
+// @Tags Developer Portal
+// @Produce json
+// @Success 200 {object} PortalUsageResponse
+// @Router /api/portal/usage [get]
+// @Security BearerAuth
+func (h *PortalHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	userCtx := auth.GetUserFromContext(r)
+
+	response := PortalUsageResponse{
+		Keys:     []PortalKeyUsage{},
+		Transfer: &metrics.TransferSummary{},
+	}
+	for _, key := range h.apiKeyStore.ListAPIKeys(userCtx.UserID) {
+		response.Keys = append(response.Keys, PortalKeyUsage{
+			Key:        key.Key,
+			Name:       key.Name,
+			Plan:       key.Plan,
+			Products:   key.Products,
+			RateLimit:  key.RateLimit,
+			Requests:   key.Requests,
+			IsActive:   key.IsActive,
+			LastUsedAt: key.LastUsedAt,
+			ExpiresAt:  key.ExpiresAt,
+		})
+		response.Requests += key.Requests
+	}
+	sort.Slice(response.Keys, func(i, j int) bool {
+		return response.Keys[i].Name < response.Keys[j].Name
+	})
+
+	byConsumer := h.transferMetrics.Summary()["consumers"]
+	for _, consumer := range []string{"jwt:" + userCtx.UserID, "apikey:" + userCtx.UserID} {
+		if summary, ok := byConsumer[consumer]; ok {
+			response.Transfer.Requests += summary.Requests
+			response.Transfer.RequestBytes += summary.RequestBytes
+			response.Transfer.ResponseBytes += summary.ResponseBytes
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
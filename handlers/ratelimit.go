@@ -2,21 +2,25 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
+	"api-gateway/capture"
 	"api-gateway/ratelimit"
 )
 
 // RateLimitHandler handles rate limiting management and monitoring
 type RateLimitHandler struct {
 	middleware *ratelimit.RateLimitMiddleware
+	capturer   *capture.Capturer // Optional; supplies recorded traffic to simulations
 }
 
 // NewRateLimitHandler creates a new rate limiting handler
-func NewRateLimitHandler(middleware *ratelimit.RateLimitMiddleware) *RateLimitHandler {
+func NewRateLimitHandler(middleware *ratelimit.RateLimitMiddleware, capturer *capture.Capturer) *RateLimitHandler {
 	return &RateLimitHandler{
 		middleware: middleware,
+		capturer:   capturer,
 	}
 }
 
@@ -40,6 +44,117 @@ type RateLimitTestResponse struct {
 	Limit      int     `json:"limit" example:"100"`
 }
 
+// maxSimulatedRequests bounds the traffic sample of one simulation
+const maxSimulatedRequests = 100000
+
+// RateLimitSimulationRequest represents a what-if rate limiting simulation.
+// The sample is either a capture session or a list of requests.
+type RateLimitSimulationRequest struct {
+	Policy    ratelimit.SimulationPolicy    `json:"policy"`
+	SessionID string                        `json:"session_id,omitempty" example:"3f2a9c1d4e5b6a70"`
+	Requests  []*ratelimit.SimulatedRequest `json:"requests,omitempty"`
+	Top       int                           `json:"top,omitempty" example:"10"` // Clients listed per result
+}
+
+// RateLimitSimulationResponse compares the current policy with the proposed one
+type RateLimitSimulationResponse struct {
+	Sample   RateLimitSample             `json:"sample"`
+	Current  *ratelimit.SimulationResult `json:"current"`
+	Proposed *ratelimit.SimulationResult `json:"proposed"`
+}
+
+// RateLimitSample describes the traffic a simulation replayed
+type RateLimitSample struct {
+	Source   string     `json:"source" example:"capture"` // "capture" or "requests"
+	Requests int        `json:"requests"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+}
+
+// Simulate replays a traffic sample against a hypothetical policy
+// @Summary Simulate Rate Limiting Policy
+// @Description Replay recorded traffic (a capture session or a list of requests) against a hypothetical token bucket policy and the current one, and report how many requests each would have rejected. Nothing is consumed from the live buckets.
+// @Tags Rate Limiting
+// @Accept json
+// @Produce json
+// @Param request body RateLimitSimulationRequest true "Policy and traffic sample"
+// @Success 200 {object} RateLimitSimulationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/ratelimit/simulate [post]
+// @Security BearerAuth
+func (h *RateLimitHandler) Simulate(w http.ResponseWriter, r *http.Request) {
+	var req RateLimitSimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if err := req.Policy.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"Invalid policy","details":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if (req.SessionID == "") == (len(req.Requests) == 0) {
+		http.Error(w, `{"error":"Invalid sample","details":"Provide either session_id or requests"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Top <= 0 {
+		req.Top = 10
+	}
+
+	sample := RateLimitSample{Source: "requests"}
+	requests := req.Requests
+	if req.SessionID != "" {
+		if h.capturer == nil {
+			http.Error(w, `{"error":"Traffic capture is disabled","details":"Enable CAPTURE_ENABLED to simulate recorded sessions"}`, http.StatusNotFound)
+			return
+		}
+		if _, exists := h.capturer.GetSession(req.SessionID); !exists {
+			http.Error(w, `{"error":"Capture session not found","details":"No capture session with this ID"}`, http.StatusNotFound)
+			return
+		}
+		entries, err := h.capturer.Entries(r.Context(), req.SessionID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"Failed to read capture session","details":%q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		sample.Source = "capture"
+		requests = make([]*ratelimit.SimulatedRequest, 0, len(entries))
+		for _, entry := range entries {
+			requests = append(requests, &ratelimit.SimulatedRequest{
+				Time:     entry.Timestamp,
+				ClientIP: entry.ClientIP,
+				Consumer: entry.Consumer,
+				Method:   entry.Method,
+				Path:     entry.Path,
+			})
+		}
+	}
+	if len(requests) > maxSimulatedRequests {
+		http.Error(w, fmt.Sprintf(`{"error":"Sample too large","details":"Simulations replay at most %d requests"}`, maxSimulatedRequests), http.StatusBadRequest)
+		return
+	}
+
+	sample.Requests = len(requests)
+	for _, request := range requests {
+		if sample.From == nil || request.Time.Before(*sample.From) {
+			sample.From = &request.Time
+		}
+		if sample.To == nil || request.Time.After(*sample.To) {
+			sample.To = &request.Time
+		}
+	}
+
+	response := RateLimitSimulationResponse{
+		Sample:   sample,
+		Current:  ratelimit.Simulate(h.middleware.SimulationPolicy(), requests, req.Top),
+		Proposed: ratelimit.Simulate(req.Policy, requests, req.Top),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // GetStats returns rate limiting statistics
 // @Summary Get Rate Limiting Statistics
 // @Description Get current rate limiting statistics and configuration
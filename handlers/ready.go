@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ReadinessHandler reports whether the gateway's components are usable
+type ReadinessHandler struct {
+	check func(ctx context.Context) map[string]error
+}
+
+// NewReadinessHandler creates a new readiness handler around a check that
+// returns the health of each component by name
+func NewReadinessHandler(check func(ctx context.Context) map[string]error) *ReadinessHandler {
+	return &ReadinessHandler{
+		check: check,
+	}
+}
+
+// ReadinessResponse is the health of the gateway's components
+type ReadinessResponse struct {
+	Status     string            `json:"status"`     // "ready" or "unavailable"
+	Components map[string]string `json:"components"` // "ok" or the error
+}
+
+// Ready handles the readiness check endpoint (no auth required)
+// @Summary Readiness check
+// @Description Check the components that can report their health, such as Redis and SQL storage connections. Answers 503 if one is unavailable.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} ReadinessResponse
+// @Failure 503 {object} ReadinessResponse
+// @Router /ready [get]
+func (h *ReadinessHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{
+		Status:     "ready",
+		Components: make(map[string]string),
+	}
+	for name, err := range h.check(r.Context()) {
+		if err != nil {
+			response.Status = "unavailable"
+			response.Components[name] = err.Error()
+		} else {
+			response.Components[name] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
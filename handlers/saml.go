@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"api-gateway/saml"
+)
+
+// maxSAMLResponseSize bounds the form posted to the ACS
+const maxSAMLResponseSize = 1 << 20
+
+// SAMLHandler implements SAML 2.0 single sign-on, issuing gateway tokens
+// to users authenticated by an enterprise identity provider
+type SAMLHandler struct {
+	sp   *saml.ServiceProvider
+	auth *AuthHandler
+}
+
+// NewSAMLHandler creates a new SAML handler issuing tokens with authHandler
+func NewSAMLHandler(sp *saml.ServiceProvider, authHandler *AuthHandler) *SAMLHandler {
+	return &SAMLHandler{
+		sp:   sp,
+		auth: authHandler,
+	}
+}
+
+// Metadata returns the service provider metadata
+// @Summary SAML SP metadata
+// @Description Metadata to register the gateway as a service provider with the identity provider
+// @Tags SAML
+// @Produce xml
+// @Success 200 {string} string "SP metadata"
+// @Router /saml/metadata [get]
+func (h *SAMLHandler) Metadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(h.sp.Metadata())
+}
+
+// Login redirects to the identity provider to start single sign-on
+// @Summary Start SAML login
+// @Description Redirect to the identity provider. After login, the ACS redirects to relay_state when it is a local path.
+// @Tags SAML
+// @Param relay_state query string false "Local path to return to with the token"
+// @Success 302 "Redirect to the identity provider"
+// @Router /saml/login [get]
+func (h *SAMLHandler) Login(w http.ResponseWriter, r *http.Request) {
+	target, err := h.sp.AuthnRequestURL(r.URL.Query().Get("relay_state"))
+	if err != nil {
+		http.Error(w, `{"error":"Failed to create SAML request"}`, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// ACS consumes the identity provider's response and issues a gateway token
+// @Summary SAML assertion consumer service
+// @Description Validate a SAML response posted by the identity provider and issue a JWT token. With a local RelayState, redirects there with the token in the URL fragment.
+// @Tags SAML
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string false "Relay state"
+// @Success 200 {object} LoginResponse "Login successful"
+// @Success 303 "Redirect to the relay state"
+// @Failure 400 {object} ErrorResponse "Missing SAML response"
+// @Failure 401 {object} ErrorResponse "Invalid SAML response"
+// @Router /saml/acs [post]
+func (h *SAMLHandler) ACS(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSAMLResponseSize)
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("SAMLResponse") == "" {
+		http.Error(w, `{"error":"Missing SAML response"}`, http.StatusBadRequest)
+		return
+	}
+
+	assertion, err := h.sp.ParseResponse(r.PostForm.Get("SAMLResponse"))
+	if err != nil {
+		log.Printf("Rejected SAML response: %v", err)
+		http.Error(w, `{"error":"Invalid SAML response"}`, http.StatusUnauthorized)
+		return
+	}
+
+	response, err := h.auth.issue(h.sp.User(assertion))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Browser logins return to a local page, never to another site
+	if relayState := r.PostForm.Get("RelayState"); strings.HasPrefix(relayState, "/") && !strings.HasPrefix(relayState, "//") && !strings.HasPrefix(relayState, "/\\") {
+		fragment := url.Values{"token": {response.Token}}.Encode()
+		http.Redirect(w, r, relayState+"#"+fragment, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
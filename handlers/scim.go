@@ -0,0 +1,803 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api-gateway/groups"
+	"api-gateway/httputil"
+	"api-gateway/scim"
+
+	"github.com/gorilla/mux"
+)
+
+// SCIMHandler implements the SCIM 2.0 Users and Groups endpoints used by
+// identity providers to provision gateway users and group memberships
+type SCIMHandler struct {
+	directory *scim.Directory
+	groups    *groups.Manager // Nil when groups are disabled
+	token     string
+}
+
+// NewSCIMHandler creates a new SCIM handler authenticating providers with a bearer token
+func NewSCIMHandler(directory *scim.Directory, groupManager *groups.Manager, token string) *SCIMHandler {
+	return &SCIMHandler{
+		directory: directory,
+		groups:    groupManager,
+		token:     token,
+	}
+}
+
+// RequireToken allows only requests carrying the SCIM bearer token
+func (h *SCIMHandler) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := httputil.BearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			writeSCIMError(w, http.StatusUnauthorized, "", "Valid SCIM bearer token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServiceProviderConfig describes the supported SCIM features
+// @Summary SCIM Service Provider Config
+// @Description Describe the SCIM features supported by the gateway
+// @Tags SCIM
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /scim/v2/ServiceProviderConfig [get]
+func (h *SCIMHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(enabled bool) map[string]bool { return map[string]bool{"supported": enabled} }
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scim.ServiceProviderConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": 0},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Static token configured with SCIM_TOKEN",
+		}},
+	})
+}
+
+// ListUsers lists provisioned users
+// @Summary List SCIM Users
+// @Description List provisioned users, optionally filtered with userName, externalId or id eq "value"
+// @Tags SCIM
+// @Produce json
+// @Param filter query string false "Filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Maximum number of results"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Router /scim/v2/Users [get]
+func (h *SCIMHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users := h.directory.List()
+
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		attribute, value, err := scim.ParseFilter(filter)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		var match func(user *scim.User) bool
+		switch strings.ToLower(attribute) {
+		case "username":
+			match = func(user *scim.User) bool { return strings.EqualFold(user.UserName, value) }
+		case "externalid":
+			match = func(user *scim.User) bool { return user.ExternalID == value }
+		case "id":
+			match = func(user *scim.User) bool { return user.ID == value }
+		default:
+			writeSCIMError(w, http.StatusBadRequest, "invalidFilter", "Users can be filtered by userName, externalId or id")
+			return
+		}
+		var filtered []*scim.User
+		for _, user := range users {
+			if match(user) {
+				filtered = append(filtered, user)
+			}
+		}
+		users = filtered
+	}
+
+	resources := make([]any, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, h.userResource(r, user))
+	}
+	writeSCIM(w, http.StatusOK, paginate(r, resources))
+}
+
+// GetUser returns a provisioned user
+// @Summary Get SCIM User
+// @Description Get a provisioned user
+// @Tags SCIM
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} scim.UserResource
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [get]
+func (h *SCIMHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, exists := h.directory.Get(mux.Vars(r)["id"])
+	if !exists {
+		writeSCIMError(w, http.StatusNotFound, "", "User not found")
+		return
+	}
+	writeSCIM(w, http.StatusOK, h.userResource(r, user))
+}
+
+// CreateUser provisions a user
+// @Summary Create SCIM User
+// @Description Provision a user
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param request body scim.UserResource true "User"
+// @Success 201 {object} scim.UserResource
+// @Failure 400 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users [post]
+func (h *SCIMHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var resource scim.UserResource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	user, err := h.directory.Create(r.Context(), userFromResource(&resource))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusCreated, h.userResource(r, user))
+}
+
+// ReplaceUser replaces a provisioned user
+// @Summary Replace SCIM User
+// @Description Replace the attributes of a provisioned user
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body scim.UserResource true "User"
+// @Success 200 {object} scim.UserResource
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users/{id} [put]
+func (h *SCIMHandler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	var resource scim.UserResource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	replacement := userFromResource(&resource)
+	replacement.ID = mux.Vars(r)["id"]
+	user, err := h.directory.Replace(r.Context(), replacement)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, h.userResource(r, user))
+}
+
+// PatchUser changes attributes of a provisioned user, such as deactivating it
+// @Summary Patch SCIM User
+// @Description Apply add, replace and remove operations to a provisioned user
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body scim.PatchRequest true "Operations"
+// @Success 200 {object} scim.UserResource
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [patch]
+func (h *SCIMHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	var patch scim.PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	existing, exists := h.directory.Get(mux.Vars(r)["id"])
+	if !exists {
+		writeSCIMError(w, http.StatusNotFound, "", "User not found")
+		return
+	}
+
+	patched := *existing
+	for _, operation := range patch.Operations {
+		if err := patchUser(&patched, operation); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+
+	user, err := h.directory.Replace(r.Context(), &patched)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, h.userResource(r, user))
+}
+
+// DeleteUser deprovisions a user and removes it from every group
+// @Summary Delete SCIM User
+// @Description Deprovision a user
+// @Tags SCIM
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [delete]
+func (h *SCIMHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := h.directory.Delete(r.Context(), id); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if h.groups != nil {
+		if err := h.groups.RemoveUser(r.Context(), id); err != nil {
+			log.Printf("Failed to remove deprovisioned user %s from groups: %v", id, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListGroups lists groups
+// @Summary List SCIM Groups
+// @Description List groups, optionally filtered with displayName or id eq "value"
+// @Tags SCIM
+// @Produce json
+// @Param filter query string false "Filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Maximum number of results"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Router /scim/v2/Groups [get]
+func (h *SCIMHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	list := h.groups.List()
+
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		attribute, value, err := scim.ParseFilter(filter)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		var match func(group *groups.Group) bool
+		switch strings.ToLower(attribute) {
+		case "displayname":
+			match = func(group *groups.Group) bool { return strings.EqualFold(groupDisplayName(group), value) }
+		case "id":
+			match = func(group *groups.Group) bool { return group.Name == value }
+		default:
+			writeSCIMError(w, http.StatusBadRequest, "invalidFilter", "Groups can be filtered by displayName or id")
+			return
+		}
+		var filtered []*groups.Group
+		for _, group := range list {
+			if match(group) {
+				filtered = append(filtered, group)
+			}
+		}
+		list = filtered
+	}
+
+	resources := make([]any, 0, len(list))
+	for _, group := range list {
+		resources = append(resources, h.groupResource(r, group))
+	}
+	writeSCIM(w, http.StatusOK, paginate(r, resources))
+}
+
+// GetGroup returns a group
+// @Summary Get SCIM Group
+// @Description Get a group with its members
+// @Tags SCIM
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} scim.GroupResource
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [get]
+func (h *SCIMHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	group, exists := h.groups.Get(mux.Vars(r)["id"])
+	if !exists {
+		writeSCIMError(w, http.StatusNotFound, "", "Group not found")
+		return
+	}
+	writeSCIM(w, http.StatusOK, h.groupResource(r, group))
+}
+
+// CreateGroup creates a group named after its display name
+// @Summary Create SCIM Group
+// @Description Create a group; its ID is derived from the display name
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param request body scim.GroupResource true "Group"
+// @Success 201 {object} scim.GroupResource
+// @Failure 400 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Groups [post]
+func (h *SCIMHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var resource scim.GroupResource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	name := scim.GroupName(resource.DisplayName)
+	err := h.groups.Create(r.Context(), &groups.Group{
+		Name:        name,
+		DisplayName: resource.DisplayName,
+		Members:     memberIDs(resource.Members),
+	})
+	if err != nil {
+		writeSCIMGroupError(w, err)
+		return
+	}
+
+	group, _ := h.groups.Get(name)
+	writeSCIM(w, http.StatusCreated, h.groupResource(r, group))
+}
+
+// ReplaceGroup replaces the display name and members of a group
+// @Summary Replace SCIM Group
+// @Description Replace the display name and members of a group; its roles are kept
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param request body scim.GroupResource true "Group"
+// @Success 200 {object} scim.GroupResource
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [put]
+func (h *SCIMHandler) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	var resource scim.GroupResource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	name := mux.Vars(r)["id"]
+	if err := h.groups.Sync(r.Context(), name, resource.DisplayName, memberIDs(resource.Members)); err != nil {
+		writeSCIMGroupError(w, err)
+		return
+	}
+
+	group, _ := h.groups.Get(name)
+	writeSCIM(w, http.StatusOK, h.groupResource(r, group))
+}
+
+// PatchGroup changes the display name or members of a group
+// @Summary Patch SCIM Group
+// @Description Add, replace or remove members and rename a group
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param request body scim.PatchRequest true "Operations"
+// @Success 200 {object} scim.GroupResource
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [patch]
+func (h *SCIMHandler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	var patch scim.PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	name := mux.Vars(r)["id"]
+	existing, exists := h.groups.Get(name)
+	if !exists {
+		writeSCIMError(w, http.StatusNotFound, "", "Group not found")
+		return
+	}
+
+	displayName := groupDisplayName(existing)
+	members := append([]string(nil), existing.Members...)
+	for _, operation := range patch.Operations {
+		var err error
+		displayName, members, err = patchGroup(displayName, members, operation)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+
+	if err := h.groups.Sync(r.Context(), name, displayName, members); err != nil {
+		writeSCIMGroupError(w, err)
+		return
+	}
+
+	group, _ := h.groups.Get(name)
+	writeSCIM(w, http.StatusOK, h.groupResource(r, group))
+}
+
+// DeleteGroup deletes a group
+// @Summary Delete SCIM Group
+// @Description Delete a group; its members lose the roles it granted
+// @Tags SCIM
+// @Param id path string true "Group ID"
+// @Success 204
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [delete]
+func (h *SCIMHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if err := h.groups.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeSCIMGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userResource converts a provisioned user to its SCIM representation
+func (h *SCIMHandler) userResource(r *http.Request, user *scim.User) *scim.UserResource {
+	active := user.Active
+	resource := &scim.UserResource{
+		Schemas:     []string{scim.UserSchema},
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.UserName,
+		DisplayName: user.DisplayName,
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     scimLocation(r, "Users", user.ID),
+		},
+	}
+	if user.GivenName != "" || user.FamilyName != "" {
+		resource.Name = &scim.Name{
+			Formatted:  strings.TrimSpace(user.GivenName + " " + user.FamilyName),
+			GivenName:  user.GivenName,
+			FamilyName: user.FamilyName,
+		}
+	}
+	if user.Email != "" {
+		resource.Emails = []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	if h.groups != nil {
+		names, _ := h.groups.GroupsOf(user.ID)
+		for _, name := range names {
+			value := scim.MultiValue{Value: name, Display: name, Ref: scimLocation(r, "Groups", name)}
+			if group, ok := h.groups.Get(name); ok {
+				value.Display = groupDisplayName(group)
+			}
+			resource.Groups = append(resource.Groups, value)
+		}
+	}
+	return resource
+}
+
+// groupResource converts a group to its SCIM representation
+func (h *SCIMHandler) groupResource(r *http.Request, group *groups.Group) *scim.GroupResource {
+	resource := &scim.GroupResource{
+		Schemas:     []string{scim.GroupSchema},
+		ID:          group.Name,
+		DisplayName: groupDisplayName(group),
+		Members:     []scim.MultiValue{},
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     scimLocation(r, "Groups", group.Name),
+		},
+	}
+	for _, member := range group.Members {
+		value := scim.MultiValue{Value: member, Ref: scimLocation(r, "Users", member)}
+		if user, ok := h.directory.Get(member); ok {
+			value.Display = user.UserName
+		}
+		resource.Members = append(resource.Members, value)
+	}
+	return resource
+}
+
+// userFromResource converts a SCIM user to a provisioned user; users are
+// active unless the resource says otherwise
+func userFromResource(resource *scim.UserResource) *scim.User {
+	user := &scim.User{
+		ExternalID:  resource.ExternalID,
+		UserName:    resource.UserName,
+		DisplayName: resource.DisplayName,
+		Email:       primaryValue(resource.Emails),
+		Active:      resource.Active == nil || *resource.Active,
+	}
+	if resource.Name != nil {
+		user.GivenName = resource.Name.GivenName
+		user.FamilyName = resource.Name.FamilyName
+	}
+	return user
+}
+
+// patchUser applies a patch operation to a user. Attributes the gateway does
+// not keep are ignored, as they are when provisioning.
+func patchUser(user *scim.User, operation scim.PatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return errors.New("unsupported patch operation " + operation.Op)
+	}
+
+	if operation.Path == "" {
+		if op == "remove" {
+			return errors.New("remove operations require a path")
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(operation.Value, &values); err != nil {
+			return errors.New("value must be an object when no path is given")
+		}
+		for path, value := range values {
+			if err := setUserAttribute(user, path, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if op == "remove" {
+		return setUserAttribute(user, operation.Path, nil)
+	}
+	return setUserAttribute(user, operation.Path, operation.Value)
+}
+
+// setUserAttribute sets a user attribute from a JSON value, clearing it when
+// value is nil
+func setUserAttribute(user *scim.User, path string, value json.RawMessage) error {
+	path = strings.TrimPrefix(strings.ToLower(path), strings.ToLower(scim.UserSchema)+":")
+
+	var text string
+	if value != nil && strings.HasPrefix(strings.TrimSpace(string(value)), `"`) {
+		if err := json.Unmarshal(value, &text); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case path == "active":
+		if value == nil {
+			return errors.New("active cannot be removed")
+		}
+		// Some providers send booleans as strings
+		active, err := strconv.ParseBool(strings.Trim(string(value), `" `))
+		if err != nil {
+			return errors.New("active must be a boolean")
+		}
+		user.Active = active
+	case path == "username":
+		user.UserName = text
+	case path == "displayname":
+		user.DisplayName = text
+	case path == "externalid":
+		user.ExternalID = text
+	case path == "name.givenname":
+		user.GivenName = text
+	case path == "name.familyname":
+		user.FamilyName = text
+	case path == "name":
+		var name scim.Name
+		if value != nil {
+			if err := json.Unmarshal(value, &name); err != nil {
+				return errors.New("name must be an object")
+			}
+		}
+		user.GivenName = name.GivenName
+		user.FamilyName = name.FamilyName
+	case path == "emails":
+		var emails []scim.MultiValue
+		if value != nil {
+			if err := json.Unmarshal(value, &emails); err != nil {
+				return errors.New("emails must be a list")
+			}
+		}
+		user.Email = primaryValue(emails)
+	case strings.HasPrefix(path, "emails["):
+		user.Email = text
+	}
+	return nil
+}
+
+// patchGroup applies a patch operation to a group's display name and members
+func patchGroup(displayName string, members []string, operation scim.PatchOperation) (string, []string, error) {
+	op := strings.ToLower(operation.Op)
+	path := strings.TrimPrefix(operation.Path, scim.GroupSchema+":")
+
+	if userID, ok := scim.MemberPath(path); ok {
+		if op != "remove" {
+			return "", nil, errors.New("members can only be removed by value filter")
+		}
+		return displayName, removeValues(members, []string{userID}), nil
+	}
+
+	var values []scim.MultiValue
+	switch strings.ToLower(path) {
+	case "":
+		var group struct {
+			DisplayName *string           `json:"displayName"`
+			Members     []scim.MultiValue `json:"members"`
+		}
+		if op == "remove" || json.Unmarshal(operation.Value, &group) != nil {
+			return "", nil, errors.New("value must be a group object when no path is given")
+		}
+		if group.DisplayName != nil {
+			displayName = *group.DisplayName
+		}
+		if group.Members != nil {
+			if op == "replace" {
+				members = memberIDs(group.Members)
+			} else {
+				members = append(members, memberIDs(group.Members)...)
+			}
+		}
+	case "displayname":
+		if op == "remove" || json.Unmarshal(operation.Value, &displayName) != nil {
+			return "", nil, errors.New("displayName must be a string")
+		}
+	case "members":
+		if operation.Value != nil {
+			if err := json.Unmarshal(operation.Value, &values); err != nil {
+				return "", nil, errors.New("members must be a list")
+			}
+		}
+		switch op {
+		case "add":
+			members = append(members, memberIDs(values)...)
+		case "replace":
+			members = memberIDs(values)
+		case "remove":
+			if values == nil {
+				members = nil
+			} else {
+				members = removeValues(members, memberIDs(values))
+			}
+		default:
+			return "", nil, errors.New("unsupported patch operation " + operation.Op)
+		}
+	case "externalid":
+		// Groups are matched by display name; external IDs are not kept
+	default:
+		return "", nil, errors.New("unsupported path " + operation.Path)
+	}
+	return displayName, members, nil
+}
+
+// groupDisplayName returns the display name of a group, defaulting to its name
+func groupDisplayName(group *groups.Group) string {
+	if group.DisplayName != "" {
+		return group.DisplayName
+	}
+	return group.Name
+}
+
+// memberIDs returns the user IDs of group members
+func memberIDs(members []scim.MultiValue) []string {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Value)
+	}
+	return ids
+}
+
+// removeValues returns values without the removed ones
+func removeValues(values, removed []string) []string {
+	drop := make(map[string]bool, len(removed))
+	for _, value := range removed {
+		drop[value] = true
+	}
+	var kept []string
+	for _, value := range values {
+		if !drop[value] {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// primaryValue returns the primary value of a multi-valued attribute, or the first
+func primaryValue(values []scim.MultiValue) string {
+	for _, value := range values {
+		if value.Primary {
+			return value.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+// paginate returns a page of resources selected by startIndex and count
+func paginate(r *http.Request, resources []any) *scim.ListResponse {
+	start, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 {
+		count = len(resources)
+	}
+
+	page := []any{}
+	if start <= len(resources) {
+		end := start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[start-1 : end]
+	}
+
+	return &scim.ListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// scimLocation returns the URL of a resource
+func scimLocation(r *http.Request, resourceType, id string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/scim/v2/" + resourceType + "/" + id
+}
+
+// writeSCIM writes a SCIM response
+func writeSCIM(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeSCIMError writes a SCIM error response
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeSCIM(w, status, &scim.Error{
+		Schemas:  []string{scim.ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// writeUserError maps directory errors to SCIM responses
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scim.ErrNotFound):
+		writeSCIMError(w, http.StatusNotFound, "", "User not found")
+	case errors.Is(err, scim.ErrUserNameTaken):
+		writeSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, scim.ErrUserNameRequired):
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		writeSCIMError(w, http.StatusInternalServerError, "", "Failed to update users")
+	}
+}
+
+// writeSCIMGroupError maps group errors to SCIM responses
+func writeSCIMGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, groups.ErrNotFound):
+		writeSCIMError(w, http.StatusNotFound, "", "Group not found")
+	case errors.Is(err, groups.ErrExists):
+		writeSCIMError(w, http.StatusConflict, "uniqueness", "A group with this displayName already exists")
+	case errors.Is(err, groups.ErrInvalidName):
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", "displayName must contain letters or digits")
+	default:
+		writeSCIMError(w, http.StatusInternalServerError, "", "Failed to update groups")
+	}
+}
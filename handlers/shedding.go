@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/shedding"
+)
+
+// SheddingHandler handles load shedding endpoints
+type SheddingHandler struct {
+	shedder *shedding.Shedder
+}
+
+// NewSheddingHandler creates a new load shedding handler
+func NewSheddingHandler(shedder *shedding.Shedder) *SheddingHandler {
+	return &SheddingHandler{
+		shedder: shedder,
+	}
+}
+
+// GetStatus returns the current load and which priorities are being shed
+// @Summary Get Load Shedding Status
+// @Description Get the current CPU, goroutine and in-flight load, the priorities being rejected and rejection counts
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} shedding.Status
+// @Router /api/admin/shedding [get]
+// @Security BearerAuth
+func (h *SheddingHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.shedder.Status())
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/slo"
+)
+
+// SLOHandler handles SLO status endpoints
+type SLOHandler struct {
+	tracker *slo.Tracker
+}
+
+// NewSLOHandler creates a new SLO handler
+func NewSLOHandler(tracker *slo.Tracker) *SLOHandler {
+	return &SLOHandler{
+		tracker: tracker,
+	}
+}
+
+// SLOStatusResponse represents SLO status response
+type SLOStatusResponse struct {
+	Objectives []*slo.Status `json:"objectives"`
+	Alerts     []*slo.Alert  `json:"alerts"`
+}
+
+// GetStatus returns compliance and burn rates of every SLO
+// @Summary Get SLO Status
+// @Description Get compliance, remaining error budget and burn rates of each per-route objective over the SLO window, and the last 100 burn rate alerts of this instance, newest first
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} SLOStatusResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/admin/slo [get]
+// @Security BearerAuth
+func (h *SLOHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	response := SLOStatusResponse{
+		Objectives: h.tracker.Status(),
+		Alerts:     h.tracker.Alerts(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"api-gateway/state"
+)
+
+// StateHandler handles gateway state export and import endpoints
+type StateHandler struct {
+	manager *state.Manager
+}
+
+// NewStateHandler creates a new state handler
+func NewStateHandler(manager *state.Manager) *StateHandler {
+	return &StateHandler{
+		manager: manager,
+	}
+}
+
+// ExportState exports the gateway state as a signed bundle
+// @Summary Export Gateway State
+// @Description Export routes, API keys, policies, plans and products as a signed JSON bundle for migration or disaster recovery. The bundle contains API key secrets.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} state.Bundle
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/state/export [get]
+// @Security BearerAuth
+func (h *StateHandler) ExportState(w http.ResponseWriter, r *http.Request) {
+	bundle, err := h.manager.Export()
+	if err != nil {
+		http.Error(w, `{"error":"Failed to export state","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="gateway-state-`+time.Now().UTC().Format("20060102T150405Z")+`.json"`)
+	json.NewEncoder(w).Encode(bundle)
+}
+
+// ImportState imports a signed state bundle
+// @Summary Import Gateway State
+// @Description Verify a signed state bundle and load its API keys. Configuration-backed sections that differ are reported as warnings.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body state.Bundle true "State bundle"
+// @Param overwrite query bool false "Replace existing keys with the same value"
+// @Param dry_run query bool false "Report changes without applying them"
+// @Success 200 {object} state.ImportResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/state/import [post]
+// @Security BearerAuth
+func (h *StateHandler) ImportState(w http.ResponseWriter, r *http.Request) {
+	var bundle state.Bundle
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	result, err := h.manager.Import(&bundle, overwrite, dryRun)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to import state","details":"`+err.Error()+`"}`, stateErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ApplySpec applies a declarative gateway spec
+// @Summary Apply Declarative Gateway Spec
+// @Description Diff a declarative spec of API keys, routes, plans, policies and products against the gateway and apply the changes all or nothing, returning a change summary. Specs that change configuration-backed sections are rejected with the settings to change.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body state.Spec true "Gateway spec"
+// @Param prune query bool false "Delete API keys missing from the spec"
+// @Param dry_run query bool false "Report changes without applying them"
+// @Success 200 {object} state.ApplyResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} state.ApplyResult
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/apply [put]
+// @Security BearerAuth
+func (h *StateHandler) ApplySpec(w http.ResponseWriter, r *http.Request) {
+	var spec state.Spec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	prune := r.URL.Query().Get("prune") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	result, err := h.manager.Apply(&spec, prune, dryRun)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to apply spec","details":"`+err.Error()+`"}`, stateErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// stateErrorStatus returns 500 when API keys could not be saved, and 400 for
+// bundles and specs that were rejected
+func stateErrorStatus(err error) int {
+	if errors.Is(err, state.ErrNotSaved) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
@@ -1,20 +1,49 @@
 package handlers
 
 import (
+	"encoding/json"
+	"html/template"
 	"net/http"
 	"strings"
 
 	"api-gateway/docs"
+	"api-gateway/static"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
+// swaggerPage is the embedded Swagger UI page
+var swaggerPage = template.Must(template.New("swagger").Parse(static.SwaggerHTML))
+
+// RouteDoc describes a route registered from configuration rather than code,
+// so it can be added to the served OpenAPI spec
+type RouteDoc struct {
+	Path        string
+	Methods     []string
+	Summary     string
+	Description string
+	Tags        []string
+	RequireAuth bool
+	Extensions  map[string]interface{} // Vendor extensions, keys must start with "x-"
+}
+
 // SwaggerHandler handles Swagger documentation endpoints
-type SwaggerHandler struct{}
+type SwaggerHandler struct {
+	routes func() []RouteDoc
+}
+
+// NewSwaggerHandler creates a new Swagger handler. routes returns the live
+// dynamic routes merged into the spec on every request and may be nil.
+func NewSwaggerHandler(routes func() []RouteDoc) *SwaggerHandler {
+	return &SwaggerHandler{
+		routes: routes,
+	}
+}
 
-// NewSwaggerHandler creates a new Swagger handler
-func NewSwaggerHandler() *SwaggerHandler {
-	return &SwaggerHandler{}
+// SwaggerPage serves the embedded Swagger UI page
+func (h *SwaggerHandler) SwaggerPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	swaggerPage.Execute(w, struct{ DocURL string }{DocURL: docURL(r)})
 }
 
 // SwaggerUI serves the Swagger UI
@@ -26,16 +55,118 @@ func (h *SwaggerHandler) SwaggerUI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handler := httpSwagger.Handler(
-		httpSwagger.URL("http://localhost:8080/swagger/doc.json"),
+		httpSwagger.URL(docURL(r)),
 		httpSwagger.DocExpansion("list"),
 		httpSwagger.DomID("swagger-ui"),
 	)
 	handler.ServeHTTP(w, r)
 }
 
-// SwaggerJSON serves the Swagger JSON
+// SwaggerJSON serves the Swagger JSON, including dynamically configured routes
 func (h *SwaggerHandler) SwaggerJSON(w http.ResponseWriter, r *http.Request) {
+	spec := []byte(docs.SwaggerInfo.ReadDoc())
+	if h.routes != nil {
+		if routes := h.routes(); len(routes) > 0 {
+			merged, err := mergeRouteDocs(spec, routes)
+			if err != nil {
+				http.Error(w, `{"error":"Failed to build API documentation","details":"`+err.Error()+`"}`, http.StatusInternalServerError)
+				return
+			}
+			spec = merged
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(docs.SwaggerInfo.ReadDoc()))
+	w.Write(spec)
+}
+
+// mergeRouteDocs adds path entries for dynamic routes to a Swagger 2.0 spec.
+// Paths documented in code take precedence over generated entries.
+func mergeRouteDocs(spec []byte, routes []RouteDoc) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	if paths == nil {
+		paths = make(map[string]interface{})
+		doc["paths"] = paths
+	}
+
+	needsAuth := false
+	for _, route := range routes {
+		if _, exists := paths[route.Path]; exists {
+			continue
+		}
+
+		item := make(map[string]interface{})
+		for _, method := range route.Methods {
+			operation := map[string]interface{}{
+				"summary":     route.Summary,
+				"description": route.Description,
+				"tags":        route.Tags,
+				"responses": map[string]interface{}{
+					"default": map[string]interface{}{"description": "Upstream response"},
+				},
+			}
+			if strings.Contains(route.Path, "{path}") {
+				operation["parameters"] = []interface{}{
+					map[string]interface{}{
+						"name":        "path",
+						"in":          "path",
+						"required":    true,
+						"type":        "string",
+						"description": "Remaining path forwarded to the upstream",
+					},
+				}
+			}
+			if route.RequireAuth {
+				needsAuth = true
+				operation["security"] = []interface{}{
+					map[string]interface{}{"BearerAuth": []string{}},
+					map[string]interface{}{"ApiKeyAuth": []string{}},
+				}
+			}
+			for key, value := range route.Extensions {
+				operation[key] = value
+			}
+			item[strings.ToLower(method)] = operation
+		}
+		paths[route.Path] = item
+	}
+
+	if needsAuth {
+		definitions, _ := doc["securityDefinitions"].(map[string]interface{})
+		if definitions == nil {
+			definitions = make(map[string]interface{})
+			doc["securityDefinitions"] = definitions
+		}
+		if _, exists := definitions["BearerAuth"]; !exists {
+			definitions["BearerAuth"] = map[string]interface{}{"type": "apiKey", "in": "header", "name": "Authorization"}
+		}
+		if _, exists := definitions["ApiKeyAuth"]; !exists {
+			definitions["ApiKeyAuth"] = map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"}
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// docURL builds the absolute doc.json URL for the host the request was sent to
+func docURL(r *http.Request) string {
+	return externalURL(r, "/swagger/doc.json")
+}
+
+// externalURL returns the absolute URL of a gateway path as seen by the client
+func externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + path
 }
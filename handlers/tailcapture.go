@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api-gateway/tailcapture"
+
+	"github.com/gorilla/mux"
+)
+
+// TailCaptureHandler handles endpoints for requests retained for triage
+type TailCaptureHandler struct {
+	recorder *tailcapture.Recorder
+}
+
+// NewTailCaptureHandler creates a new tail capture handler
+func NewTailCaptureHandler(recorder *tailcapture.Recorder) *TailCaptureHandler {
+	return &TailCaptureHandler{
+		recorder: recorder,
+	}
+}
+
+// RecentErrorsResponse represents the response for listing retained requests
+type RecentErrorsResponse struct {
+	Captures []*tailcapture.Capture `json:"captures"`
+	Count    int                    `json:"count"`
+}
+
+// ListRecentErrors returns recent slow and failed requests
+// @Summary List Recent Errors
+// @Description List the slow and failed requests retained by this instance, newest first, with their trace and redacted request and response
+// @Tags Admin
+// @Produce json
+// @Param reason query string false "error or slow"
+// @Param path query string false "Path prefix"
+// @Param limit query int false "Maximum number of captures"
+// @Success 200 {object} RecentErrorsResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /api/admin/debug/recent-errors [get]
+// @Security BearerAuth
+func (h *TailCaptureHandler) ListRecentErrors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := tailcapture.Filter{
+		Reason:     query.Get("reason"),
+		PathPrefix: query.Get("path"),
+	}
+	if filter.Reason != "" && filter.Reason != "error" && filter.Reason != "slow" {
+		http.Error(w, `{"error":"Invalid reason","details":"Use error or slow"}`, http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"Invalid limit","details":"Must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	captures := h.recorder.Recent(filter)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecentErrorsResponse{
+		Captures: captures,
+		Count:    len(captures),
+	})
+}
+
+// GetRecentError returns one retained request
+// @Summary Get Recent Error
+// @Description Get a slow or failed request retained by this instance
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Capture ID"
+// @Success 200 {object} tailcapture.Capture
+// @Failure 404 {object} ErrorResponse
+// @Router /api/admin/debug/recent-errors/{id} [get]
+// @Security BearerAuth
+func (h *TailCaptureHandler) GetRecentError(w http.ResponseWriter, r *http.Request) {
+	capture, ok := h.recorder.Get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, `{"error":"Capture not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capture)
+}
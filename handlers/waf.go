@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api-gateway/waf"
+)
+
+// WAFHandler handles WAF monitoring endpoints
+type WAFHandler struct {
+	waf *waf.WAF
+}
+
+// NewWAFHandler creates a new WAF handler
+func NewWAFHandler(w *waf.WAF) *WAFHandler {
+	return &WAFHandler{
+		waf: w,
+	}
+}
+
+// WAFStatsResponse represents WAF statistics response
+type WAFStatsResponse struct {
+	Stats map[string]interface{} `json:"stats"`
+}
+
+// GetStats returns WAF rule hit statistics
+// @Summary Get WAF Statistics
+// @Description Get request inspection rule hits and block counts
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} WAFStatsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/admin/waf/stats [get]
+// @Security BearerAuth
+func (h *WAFHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	response := WAFStatsResponse{
+		Stats: h.waf.GetStats(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
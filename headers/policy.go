@@ -0,0 +1,154 @@
+package headers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"api-gateway/httputil"
+)
+
+// cacheStatusName identifies the gateway in Cache-Status headers (RFC 9211)
+const cacheStatusName = "api-gateway"
+
+// Policy describes response header changes for a set of routes
+type Policy struct {
+	Name         string
+	Paths        []string          // Route prefixes; empty applies to every route
+	Remove       []string          // Headers stripped from responses, e.g. Server
+	Set          map[string]string // Headers added or replaced
+	CacheControl string            // Overrides Cache-Control when set
+	CacheStatus  bool              // Adds a Cache-Status header
+}
+
+// Config represents response header policy configuration
+type Config struct {
+	Default *Policy   // Applied to every response
+	Routes  []*Policy // Applied after the default to matching routes, most specific last
+}
+
+// Rewriter applies response header policies
+type Rewriter struct {
+	config *Config
+}
+
+// NewRewriter creates a response header rewriter
+func NewRewriter(config *Config) *Rewriter {
+	routes := append([]*Policy(nil), config.Routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return longestPath(routes[i]) < longestPath(routes[j])
+	})
+
+	return &Rewriter{
+		config: &Config{Default: config.Default, Routes: routes},
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (rw *Rewriter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policies := rw.policies(r.URL.Path)
+			if len(policies) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = httputil.WithCacheSlot(r)
+			next.ServeHTTP(&policyWriter{ResponseWriter: w, request: r, policies: policies}, r)
+		})
+	}
+}
+
+// policies returns the policies applying to a path in application order
+func (rw *Rewriter) policies(path string) []*Policy {
+	var policies []*Policy
+	if rw.config.Default != nil {
+		policies = append(policies, rw.config.Default)
+	}
+	for _, policy := range rw.config.Routes {
+		if policy.matches(path) {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// matches reports whether the policy covers the path
+func (p *Policy) matches(path string) bool {
+	if len(p.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range p.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply rewrites the response headers before they are sent
+func (p *Policy) apply(header http.Header, r *http.Request) {
+	for _, name := range p.Remove {
+		header.Del(name)
+	}
+	for name, value := range p.Set {
+		header.Set(name, value)
+	}
+	if p.CacheControl != "" {
+		header.Set("Cache-Control", p.CacheControl)
+	}
+	if p.CacheStatus {
+		status := cacheStatusName + "; fwd=uri-miss"
+		if httputil.CacheHit(r) {
+			status = cacheStatusName + "; hit"
+		}
+		header.Set("Cache-Status", status)
+	}
+}
+
+// longestPath returns the length of the policy's most specific prefix
+func longestPath(p *Policy) int {
+	longest := 0
+	for _, path := range p.Paths {
+		if len(path) > longest {
+			longest = len(path)
+		}
+	}
+	return longest
+}
+
+// policyWriter applies policies when the response headers are written
+type policyWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	policies    []*Policy
+	wroteHeader bool
+}
+
+func (pw *policyWriter) WriteHeader(code int) {
+	if !pw.wroteHeader {
+		pw.wroteHeader = true
+		for _, policy := range pw.policies {
+			policy.apply(pw.Header(), pw.request)
+		}
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *policyWriter) Write(data []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	return pw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher
+func (pw *policyWriter) Flush() {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := pw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,26 @@
+package httputil
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BearerToken extracts the token of a Bearer Authorization header. The scheme
+// is matched case-insensitively; headers with any other scheme, no token or
+// whitespace inside the token are rejected.
+func BearerToken(r *http.Request) (string, bool) {
+	return ParseBearer(r.Header.Get("Authorization"))
+}
+
+// ParseBearer extracts the token of a Bearer Authorization header value
+func ParseBearer(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimLeft(token, " ")
+	if token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return "", false
+	}
+	return token, true
+}
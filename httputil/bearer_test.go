@@ -0,0 +1,56 @@
+package httputil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBearer(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc.def.ghi", "abc.def.ghi", true},
+		{"bearer abc", "abc", true},
+		{"  Bearer   abc  ", "abc", true},
+		{"Bearer", "", false},
+		{"Bearer ", "", false},
+		{"Bearer a b", "", false},
+		{"Bearer a\tb", "", false},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Bearerabc", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		token, ok := ParseBearer(tt.header)
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("ParseBearer(%q) = %q, %v, want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
+
+func FuzzParseBearer(f *testing.F) {
+	for _, header := range []string{"Bearer abc.def.ghi", "bearer x", "BEARER  y ", "Basic z", "Bearer a b", "Bearer\ta", ""} {
+		f.Add(header)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		token, ok := ParseBearer(header)
+		if !ok {
+			if token != "" {
+				t.Fatalf("rejected header %q returned token %q", header, token)
+			}
+			return
+		}
+		if token == "" || strings.ContainsAny(token, " \t\r\n") {
+			t.Fatalf("ParseBearer(%q) accepted token %q", header, token)
+		}
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(header)), "bearer ") {
+			t.Fatalf("ParseBearer(%q) accepted a non-Bearer scheme", header)
+		}
+		// The extracted token round-trips
+		if again, ok := ParseBearer("Bearer " + token); !ok || again != token {
+			t.Fatalf("token %q does not round-trip: %q, %v", token, again, ok)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+)
+
+type cacheSlotKey struct{}
+
+// cacheSlot lets inner middleware report that a response was served from a
+// stored or shared response rather than by the handler
+type cacheSlot struct {
+	hit bool
+}
+
+// WithCacheSlot returns a request carrying a slot for MarkCacheHit
+func WithCacheSlot(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cacheSlotKey{}, &cacheSlot{}))
+}
+
+// MarkCacheHit records that the response is served from a stored or shared response
+func MarkCacheHit(r *http.Request) {
+	if slot, ok := r.Context().Value(cacheSlotKey{}).(*cacheSlot); ok {
+		slot.hit = true
+	}
+}
+
+// CacheHit reports whether MarkCacheHit was called for the request
+func CacheHit(r *http.Request) bool {
+	slot, ok := r.Context().Value(cacheSlotKey{}).(*cacheSlot)
+	return ok && slot.hit
+}
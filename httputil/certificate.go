@@ -0,0 +1,17 @@
+package httputil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// ClientCertificate returns the SHA-256 fingerprint of the client
+// certificate verified by the TLS handshake, or "" when there is none
+func ClientCertificate(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.VerifiedChains[0][0].Raw)
+	return hex.EncodeToString(sum[:])
+}
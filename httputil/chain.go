@@ -0,0 +1,55 @@
+package httputil
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Chains collects the middleware of a router and its subrouters and wraps
+// each route's handler with its chain once. Middleware added with mux's Use
+// is instead rebuilt around the handler on every request, allocating a
+// closure per middleware.
+type Chains struct {
+	middlewares map[*mux.Router][]func(http.Handler) http.Handler
+}
+
+// NewChains creates an empty set of middleware chains
+func NewChains() *Chains {
+	return &Chains{middlewares: make(map[*mux.Router][]func(http.Handler) http.Handler)}
+}
+
+// Use appends middleware to a router's chain, like the router's Use
+func (c *Chains) Use(router *mux.Router, middlewares ...func(http.Handler) http.Handler) {
+	c.middlewares[router] = append(c.middlewares[router], middlewares...)
+}
+
+// Wrap wraps the handler of every route under root with the middleware of
+// the routers above it, outermost router first, in the order mux would.
+// Routes must all be registered before it is called.
+func (c *Chains) Wrap(root *mux.Router) {
+	routers := make(map[*mux.Route]*mux.Router)
+	root.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		routers[route] = router
+		handler := route.GetHandler()
+		if handler == nil {
+			return nil
+		}
+
+		handler = c.wrap(router, handler)
+		for i := len(ancestors) - 1; i >= 0; i-- {
+			handler = c.wrap(routers[ancestors[i]], handler)
+		}
+		route.Handler(handler)
+		return nil
+	})
+}
+
+// wrap wraps handler with a router's middleware, the first added outermost
+func (c *Chains) wrap(router *mux.Router, handler http.Handler) http.Handler {
+	middlewares := c.middlewares[router]
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,116 @@
+package httputil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP extracts the client IP address, preferring proxy headers. Any
+// client can set those headers, so the address is only fit for logs; use
+// TrustedProxies for decisions based on it.
+func ClientIP(r *http.Request) string {
+	// Check X-Forwarded-For header first
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(first)
+	}
+
+	// Check X-Real-IP header, by its canonical key so the lookup does not
+	// canonicalize (and allocate) on every request
+	if xri := r.Header.Get("X-Real-Ip"); xri != "" {
+		return xri
+	}
+
+	return PeerIP(r)
+}
+
+// PeerIP returns the address of the connection's peer
+func PeerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// TrustedProxies resolves client addresses behind reverse proxies. Forwarding
+// headers are only believed when the peer is one of the proxies; a nil
+// TrustedProxies trusts none, so the client is always the peer.
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// ParseTrustedProxies parses the addresses and CIDR ranges of trusted proxies
+func ParseTrustedProxies(values []string) (*TrustedProxies, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	t := &TrustedProxies{}
+	for _, value := range values {
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			t.networks = append(t.networks, network)
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("%s is not an IP address or CIDR range", value)
+		}
+		bits := 8 * len(ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		t.networks = append(t.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return t, nil
+}
+
+// trusts reports whether the address belongs to a trusted proxy
+func (t *TrustedProxies) trusts(address string) bool {
+	if t == nil {
+		return false
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address of a request. Behind trusted proxies
+// it is the last X-Forwarded-For entry that no trusted proxy added, or
+// X-Real-Ip when there is none; otherwise it is the peer.
+func (t *TrustedProxies) ClientIP(r *http.Request) string {
+	peer := PeerIP(r)
+	if !t.trusts(peer) {
+		return peer
+	}
+
+	// Each proxy appends the address it received the request from, so the
+	// entries left of the last untrusted one may be forged by the client
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		entries := strings.Split(strings.Join(values, ","), ",")
+		client := peer
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := strings.TrimSpace(entries[i])
+			if net.ParseIP(entry) == nil {
+				break
+			}
+			client = entry
+			if !t.trusts(entry) {
+				break
+			}
+		}
+		return client
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(xri) != nil {
+		return xri
+	}
+	return peer
+}
@@ -0,0 +1,54 @@
+package httputil
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		proxies *TrustedProxies
+		peer    string
+		xff     []string
+		realIP  string
+		want    string
+	}{
+		{"no proxies trusted", nil, "203.0.113.5:1234", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.5"},
+		{"untrusted peer", proxies, "203.0.113.5:1234", []string{"10.1.1.1"}, "", "203.0.113.5"},
+		{"untrusted peer with X-Real-Ip", proxies, "203.0.113.5:1234", nil, "10.1.1.1", "203.0.113.5"},
+		{"trusted peer", proxies, "10.0.0.2:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"forged entry left of the client", proxies, "10.0.0.2:1234", []string{"10.9.9.9, 198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of trusted proxies", proxies, "10.0.0.2:1234", []string{"198.51.100.1, 192.0.2.1", "10.0.0.3"}, "", "198.51.100.1"},
+		{"only trusted entries", proxies, "10.0.0.2:1234", []string{"10.0.0.4"}, "", "10.0.0.4"},
+		{"malformed entry", proxies, "10.0.0.2:1234", []string{"198.51.100.1, garbage"}, "", "10.0.0.2"},
+		{"X-Real-Ip from trusted peer", proxies, "192.0.2.1:1234", nil, "198.51.100.1", "198.51.100.1"},
+		{"no headers", proxies, "10.0.0.2:1234", nil, "", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		for _, value := range tt.xff {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-Ip", tt.realIP)
+		}
+		if got := tt.proxies.ClientIP(r); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if proxies, err := ParseTrustedProxies(nil); proxies != nil || err != nil {
+		t.Errorf("ParseTrustedProxies(nil) = %v, %v", proxies, err)
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/8", "proxy.internal"}); err == nil {
+		t.Error("host name accepted as a trusted proxy")
+	}
+}
@@ -0,0 +1,75 @@
+package httputil
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// PeekBody reads up to limit bytes of the request body and restores it so
+// downstream handlers still see the full, unconsumed body
+func PeekBody(r *http.Request, limit int64) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	prefix, _ := io.ReadAll(io.LimitReader(r.Body, limit))
+	r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(prefix), r.Body), Closer: r.Body}
+	return prefix
+}
+
+// replayBody re-exposes a partially consumed request body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// TeeRecorder passes a response through while keeping its status code and
+// the first Limit bytes of its body
+type TeeRecorder struct {
+	http.ResponseWriter
+	StatusCode  int
+	Body        bytes.Buffer
+	Limit       int
+	wroteHeader bool
+}
+
+// NewTeeRecorder creates a recorder keeping at most limit body bytes
+func NewTeeRecorder(w http.ResponseWriter, limit int) *TeeRecorder {
+	return &TeeRecorder{
+		ResponseWriter: w,
+		StatusCode:     http.StatusOK,
+		Limit:          limit,
+	}
+}
+
+// WriteHeader records the status code and forwards it
+func (rec *TeeRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.StatusCode = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the body prefix and forwards the data
+func (rec *TeeRecorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if remaining := rec.Limit - rec.Body.Len(); remaining > 0 {
+		if len(data) > remaining {
+			rec.Body.Write(data[:remaining])
+		} else {
+			rec.Body.Write(data)
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher
+func (rec *TeeRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
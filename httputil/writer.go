@@ -0,0 +1,79 @@
+package httputil
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// StatusWriter passes a response through while recording its status code and
+// the number of body bytes written. It implements http.Flusher and
+// http.Hijacker when the wrapped writer does, and Unwrap, so WebSocket
+// upgrades, streamed responses and http.ResponseController keep working
+// behind middleware that observes responses.
+type StatusWriter struct {
+	http.ResponseWriter
+	Status  int   // Status code sent; 0 until the header is written
+	Written int64 // Body bytes written
+}
+
+// NewStatusWriter wraps a response writer
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+// StatusCode returns the status code sent, which is 200 when the handler
+// wrote nothing
+func (sw *StatusWriter) StatusCode() int {
+	if sw.Status == 0 {
+		return http.StatusOK
+	}
+	return sw.Status
+}
+
+// WriteHeader records the status code and forwards it. Informational
+// responses other than 101 are forwarded without being recorded, since the
+// final status follows them.
+func (sw *StatusWriter) WriteHeader(code int) {
+	if sw.Status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		sw.Status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the body bytes and forwards them
+func (sw *StatusWriter) Write(b []byte) (int, error) {
+	if sw.Status == 0 {
+		sw.Status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.Written += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (sw *StatusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. A hijacked connection is recorded as
+// switching protocols.
+func (sw *StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && sw.Status == 0 {
+		sw.Status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (sw *StatusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusWriterRecordsStatusAndBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter)
+		status  int
+		written int64
+	}{
+		{"nothing written", func(w http.ResponseWriter) {}, http.StatusOK, 0},
+		{"implicit 200", func(w http.ResponseWriter) { w.Write([]byte("hello")) }, http.StatusOK, 5},
+		{"explicit status", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("tea"))
+		}, http.StatusTeapot, 3},
+		{"first status wins", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusNotFound, 0},
+		{"informational skipped", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusCreated)
+		}, http.StatusCreated, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := NewStatusWriter(httptest.NewRecorder())
+			tt.handler(sw)
+			if sw.StatusCode() != tt.status {
+				t.Errorf("status = %d, want %d", sw.StatusCode(), tt.status)
+			}
+			if sw.Written != tt.written {
+				t.Errorf("written = %d, want %d", sw.Written, tt.written)
+			}
+		})
+	}
+}
+
+func TestStatusWriterResponseController(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := NewStatusWriter(NewStatusWriter(rec))
+	if err := http.NewResponseController(sw).Flush(); err != nil {
+		t.Fatalf("Flush through nested writers: %v", err)
+	}
+	if !rec.Flushed {
+		t.Error("underlying recorder was not flushed")
+	}
+}
+
+func TestStatusWriterHijack(t *testing.T) {
+	var recorded int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := NewStatusWriter(w)
+		conn, rw, err := http.NewResponseController(sw).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		recorded = sw.StatusCode()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if recorded != http.StatusSwitchingProtocols {
+		t.Errorf("recorded status = %d, want 101", recorded)
+	}
+}
+
+func TestStatusWriterHijackUnsupported(t *testing.T) {
+	sw := NewStatusWriter(httptest.NewRecorder())
+	if _, _, err := sw.Hijack(); err == nil {
+		t.Fatal("Hijack succeeded on a writer that cannot hijack")
+	}
+}
@@ -0,0 +1,204 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/httputil"
+)
+
+// HeaderName is the request header carrying the client's idempotency key
+const HeaderName = "Idempotency-Key"
+
+// Config represents idempotency middleware configuration
+type Config struct {
+	TTL            time.Duration `json:"ttl"`
+	Methods        []string      `json:"methods"`
+	MaxBodySize    int           `json:"max_body_size"`    // Largest response body that is stored
+	MaxRequestSize int64         `json:"max_request_size"` // Largest request body accepted with an Idempotency-Key
+	// Identify returns the authenticated caller that keys belong to, or ""
+	// when the request does not authenticate, if set
+	Identify func(r *http.Request) string `json:"-"`
+}
+
+// Middleware replays stored responses for retried requests with the same Idempotency-Key
+func Middleware(store Store, config *Config) func(http.Handler) http.Handler {
+	methods := make(map[string]bool)
+	for _, method := range config.Methods {
+		methods[strings.ToUpper(method)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get(HeaderName)
+			if idempotencyKey == "" || !methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idempotencyKey) > 255 {
+				http.Error(w, `{"error":"Invalid Idempotency-Key","details":"Key must be at most 255 characters"}`, http.StatusBadRequest)
+				return
+			}
+
+			// The body is hashed, so it is buffered, but only up to the limit
+			body, err := io.ReadAll(io.LimitReader(r.Body, config.MaxRequestSize+1))
+			if err != nil {
+				http.Error(w, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > config.MaxRequestSize {
+				http.Error(w, `{"error":"Request body too large","details":"Requests with an Idempotency-Key must not exceed `+strconv.FormatInt(config.MaxRequestSize, 10)+` bytes"}`, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := scopedKey(r, idempotencyKey, config.Identify)
+			bodyHash := hashBytes([]byte(r.Method), []byte(r.URL.RequestURI()), body)
+
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			existing, reserved, err := store.Reserve(ctx, key, &Record{
+				BodyHash:  bodyHash,
+				CreatedAt: time.Now(),
+			}, config.TTL)
+			cancel()
+			if err != nil {
+				// If the store fails, log error but process the request normally
+				log.Printf("Idempotency check failed: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !reserved {
+				switch {
+				case existing.BodyHash != bodyHash:
+					http.Error(w, `{"error":"Idempotency key reused","details":"Idempotency-Key was already used with a different request"}`, http.StatusUnprocessableEntity)
+				case !existing.Completed:
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, `{"error":"Request in progress","details":"A request with this Idempotency-Key is still being processed"}`, http.StatusConflict)
+				default:
+					httputil.MarkCacheHit(r)
+					replay(w, existing)
+				}
+				return
+			}
+
+			outerHeader := w.Header().Clone()
+			rec := &recorder{ResponseWriter: w, statusCode: http.StatusOK, limit: config.MaxBodySize}
+			next.ServeHTTP(rec, r)
+
+			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// Server errors and oversized responses are not stored so clients can retry
+			if rec.statusCode >= http.StatusInternalServerError || rec.overflow {
+				if err := store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release idempotency key: %v", err)
+				}
+				return
+			}
+
+			record := &Record{
+				BodyHash:   bodyHash,
+				Completed:  true,
+				StatusCode: rec.statusCode,
+				Header:     handlerHeader(outerHeader, w.Header()),
+				Body:       rec.body.Bytes(),
+				CreatedAt:  time.Now(),
+			}
+			if err := store.Complete(ctx, key, record, config.TTL); err != nil {
+				log.Printf("Failed to store idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+// handlerHeader returns the headers set by the wrapped handler, leaving out
+// those set by outer middleware (rate limit, CORS) which are recomputed on replay
+func handlerHeader(outer, final http.Header) http.Header {
+	header := make(http.Header)
+	for name, values := range final {
+		if strings.Join(outer[name], ",") != strings.Join(values, ",") {
+			header[name] = values
+		}
+	}
+	return header
+}
+
+// replay writes a stored response
+func replay(w http.ResponseWriter, record *Record) {
+	for name, values := range record.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// scopedKey binds an idempotency key to the authenticated caller so
+// different clients can't collide or read each other's responses, and a
+// client's retries match whichever of its credentials they carry. Requests
+// that do not authenticate are bound to their raw credentials, client
+// certificates included.
+func scopedKey(r *http.Request, idempotencyKey string, identify func(r *http.Request) string) string {
+	if identify != nil {
+		if caller := identify(r); caller != "" {
+			return hashBytes([]byte("caller"), []byte(caller))[:32] + ":" + idempotencyKey
+		}
+	}
+	credential := r.Header.Get("Authorization")
+	if credential == "" {
+		credential = r.Header.Get("X-API-Key")
+	}
+	return hashBytes([]byte(credential), []byte(httputil.ClientCertificate(r)))[:32] + ":" + idempotencyKey
+}
+
+// hashBytes returns the hex-encoded SHA-256 of the concatenated parts
+func hashBytes(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder captures the response while passing it through to the client
+type recorder struct {
+	http.ResponseWriter
+	statusCode  int
+	body        bytes.Buffer
+	limit       int
+	overflow    bool
+	wroteHeader bool
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.statusCode = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(data) > rec.limit {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(data)
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
@@ -0,0 +1,179 @@
+package idempotency
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig() *Config {
+	return &Config{TTL: time.Hour, Methods: []string{"POST"}, MaxBodySize: 1 << 20, MaxRequestSize: 64}
+}
+
+// post sends a POST with an Idempotency-Key, and the Authorization header if set
+func post(handler http.Handler, key, authorization, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/orders", strings.NewReader(body))
+	r.Header.Set(HeaderName, key)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestReplayAndConflict(t *testing.T) {
+	calls := 0
+	handler := Middleware(NewMemoryStore(), testConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Order", strconv.Itoa(calls))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+
+	steps := []struct {
+		name     string
+		key      string
+		body     string
+		status   int
+		replayed bool
+		calls    int
+	}{
+		{"first request", "order-1", `{"item":"a"}`, http.StatusCreated, false, 1},
+		{"retry", "order-1", `{"item":"a"}`, http.StatusCreated, true, 1},
+		{"same key with another body", "order-1", `{"item":"b"}`, http.StatusUnprocessableEntity, false, 1},
+		{"another key", "order-2", `{"item":"a"}`, http.StatusCreated, false, 2},
+		{"key over 255 characters", strings.Repeat("k", 256), `{"item":"a"}`, http.StatusBadRequest, false, 2},
+		{"body over the limit", "order-3", strings.Repeat("x", 65), http.StatusRequestEntityTooLarge, false, 2},
+		{"body at the limit", "order-4", strings.Repeat("x", 64), http.StatusCreated, false, 3},
+	}
+	for _, step := range steps {
+		w := post(handler, step.key, "", step.body)
+		replayed := w.Header().Get("Idempotent-Replayed") == "true"
+		if w.Code != step.status || replayed != step.replayed || calls != step.calls {
+			t.Errorf("%s: status %d replayed %v upstream calls %d, want %d %v %d",
+				step.name, w.Code, replayed, calls, step.status, step.replayed, step.calls)
+		}
+		if step.replayed && (w.Body.String() != step.body || w.Header().Get("X-Order") != "1") {
+			t.Errorf("%s: replayed body %q X-Order %q", step.name, w.Body.String(), w.Header().Get("X-Order"))
+		}
+	}
+}
+
+func TestInProgressAndServerErrors(t *testing.T) {
+	store := NewMemoryStore()
+	entered, release := make(chan struct{}), make(chan struct{})
+	status := http.StatusInternalServerError
+	handler := Middleware(store, testConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Slow") != "" {
+			close(entered)
+			<-release
+		}
+		w.WriteHeader(status)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r := httptest.NewRequest("POST", "/api/orders", strings.NewReader("{}"))
+		r.Header.Set(HeaderName, "slow")
+		r.Header.Set("X-Slow", "true")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	<-entered
+	if w := post(handler, "slow", "", "{}"); w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Errorf("retry while in progress: status %d Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	close(release)
+	<-done
+
+	// The server error was not stored, so the retry reaches the upstream
+	status = http.StatusOK
+	if w := post(handler, "slow", "", "{}"); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after a server error: status %d replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestKeysScopedToCaller(t *testing.T) {
+	config := testConfig()
+	// Each token stands for a caller, as authentication would resolve it
+	config.Identify = func(r *http.Request) string {
+		switch r.Header.Get("Authorization") {
+		case "Bearer alice-1", "Bearer alice-2":
+			return "user:alice"
+		case "Bearer bob":
+			return "user:bob"
+		}
+		return ""
+	}
+	calls := 0
+	handler := Middleware(NewMemoryStore(), config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+
+	steps := []struct {
+		authorization string
+		body          string
+		replayed      bool
+		calls         int
+	}{
+		{"Bearer alice-1", "Bearer alice-1", false, 1},
+		// A retry with a refreshed token is the same caller
+		{"Bearer alice-2", "Bearer alice-1", true, 1},
+		{"Bearer bob", "Bearer bob", false, 2},
+		// Unidentified callers are kept apart by their credentials
+		{"Bearer unknown-1", "Bearer unknown-1", false, 3},
+		{"Bearer unknown-2", "Bearer unknown-2", false, 4},
+	}
+	for i, step := range steps {
+		w := post(handler, "order-1", step.authorization, "{}")
+		replayed := w.Header().Get("Idempotent-Replayed") == "true"
+		if w.Body.String() != step.body || replayed != step.replayed || calls != step.calls {
+			t.Errorf("step %d (%s): body %q replayed %v upstream calls %d, want %q %v %d",
+				i, step.authorization, w.Body.String(), replayed, calls, step.body, step.replayed, step.calls)
+		}
+	}
+}
+
+func TestKeysScopedToClientCertificates(t *testing.T) {
+	calls := 0
+	handler := Middleware(NewMemoryStore(), testConfig())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write(r.TLS.VerifiedChains[0][0].Raw)
+		}))
+	post := func(device string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/readings", strings.NewReader(`{"temperature":21}`))
+		r.Header.Set(HeaderName, "reading-1")
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Raw: []byte(device)}}}}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	steps := []struct {
+		device   string
+		replayed bool
+		calls    int
+	}{
+		{"device-1", false, 1},
+		{"device-2", false, 2},
+		{"device-1", true, 2},
+	}
+	for i, step := range steps {
+		w := post(step.device)
+		replayed := w.Header().Get("Idempotent-Replayed") == "true"
+		if w.Body.String() != step.device || replayed != step.replayed || calls != step.calls {
+			t.Errorf("step %d (%s): body %q replayed %v upstream calls %d, want replayed %v calls %d",
+				i, step.device, w.Body.String(), replayed, calls, step.replayed, step.calls)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Record represents a stored response for an idempotency key
+type Record struct {
+	BodyHash   string      `json:"body_hash"`
+	Completed  bool        `json:"completed"` // False while the original request is in flight
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// Store persists idempotency records
+type Store interface {
+	// Reserve stores an in-flight record if the key is unused. It returns
+	// the existing record and false when the key is already taken.
+	Reserve(ctx context.Context, key string, record *Record, ttl time.Duration) (*Record, bool, error)
+	// Complete replaces the in-flight record with the final response
+	Complete(ctx context.Context, key string, record *Record, ttl time.Duration) error
+	// Release removes a key so the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps idempotency records in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryEntry
+}
+
+// memoryEntry is a record with its expiry time
+type memoryEntry struct {
+	record    *Record
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		records: make(map[string]*memoryEntry),
+	}
+
+	go store.cleanupRoutine()
+
+	return store
+}
+
+// Reserve stores an in-flight record if the key is unused
+func (s *MemoryStore) Reserve(ctx context.Context, key string, record *Record, ttl time.Duration) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, exists := s.records[key]; exists && time.Now().Before(entry.expiresAt) {
+		return entry.record, false, nil
+	}
+
+	s.records[key] = &memoryEntry{record: record, expiresAt: time.Now().Add(ttl)}
+	return nil, true, nil
+}
+
+// Complete replaces the in-flight record with the final response
+func (s *MemoryStore) Complete(ctx context.Context, key string, record *Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = &memoryEntry{record: record, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release removes a key
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// cleanupRoutine periodically removes expired records
+func (s *MemoryStore) cleanupRoutine() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for key, entry := range s.records {
+			if now.After(entry.expiresAt) {
+				delete(s.records, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisStore keeps idempotency records in Redis so retries can hit any replica
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Reserve stores an in-flight record if the key is unused
+func (s *RedisStore) Reserve(ctx context.Context, key string, record *Record, ttl time.Duration) (*Record, bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	reserved, err := s.client.SetNX(ctx, s.redisKey(key), data, ttl).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if reserved {
+		return nil, true, nil
+	}
+
+	existing, err := s.client.Get(ctx, s.redisKey(key)).Bytes()
+	if err == redis.Nil {
+		// Expired between SETNX and GET; let the caller retry the reservation
+		return nil, false, fmt.Errorf("idempotency key expired during reservation")
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+
+	var stored Record
+	if err := json.Unmarshal(existing, &stored); err != nil {
+		return nil, false, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	return &stored, false, nil
+}
+
+// Complete replaces the in-flight record with the final response
+func (s *RedisStore) Complete(ctx context.Context, key string, record *Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+	return s.client.Set(ctx, s.redisKey(key), data, ttl).Err()
+}
+
+// Release removes a key
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.redisKey(key)).Err()
+}
+
+// redisKey namespaces idempotency keys
+func (s *RedisStore) redisKey(key string) string {
+	return "idempotency:" + key
+}
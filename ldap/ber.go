@@ -0,0 +1,214 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by LDAPv3 (RFC 4511). Every tag fits in a single byte.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// maxElementLength bounds the size of a single response element
+const maxElementLength = 16 << 20
+
+// maxDepth bounds the nesting of constructed elements. LDAP responses nest
+// a handful of levels; a hostile server could otherwise nest millions.
+const maxDepth = 32
+
+// readChunk is how much of an element is allocated before its bytes arrive
+const readChunk = 4 << 10
+
+// element is a decoded BER tag-length-value
+type element struct {
+	tag      byte
+	value    []byte
+	children []*element // Set for constructed elements
+}
+
+// encode returns the BER encoding of a tag and its contents
+func encode(tag byte, contents ...[]byte) []byte {
+	length := 0
+	for _, content := range contents {
+		length += len(content)
+	}
+
+	out := []byte{tag}
+	out = append(out, encodeLength(length)...)
+	for _, content := range contents {
+		out = append(out, content...)
+	}
+	return out
+}
+
+// encodeLength returns a definite BER length
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var digits []byte
+	for n := length; n > 0; n >>= 8 {
+		digits = append([]byte{byte(n)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
+
+// encodeInt returns a BER integer or enumerated value with the given tag
+func encodeInt(tag byte, value int64) []byte {
+	var digits []byte
+	for {
+		digits = append([]byte{byte(value)}, digits...)
+		value >>= 8
+		// Stop once the remaining bits are pure sign extension of the top byte
+		if (value == 0 && digits[0]&0x80 == 0) || (value == -1 && digits[0]&0x80 != 0) {
+			break
+		}
+	}
+	return encode(tag, digits)
+}
+
+// encodeString returns a BER octet string with the given tag
+func encodeString(tag byte, value string) []byte {
+	return encode(tag, []byte(value))
+}
+
+// encodeBool returns a BER boolean
+func encodeBool(value bool) []byte {
+	if value {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0x00})
+}
+
+// readElement reads one BER element from a stream
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	// Grow the value as bytes arrive, rather than trusting the length
+	var value bytes.Buffer
+	value.Grow(min(length, readChunk))
+	if _, err := io.CopyN(&value, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return parseElement(tag, value.Bytes(), 0)
+}
+
+// readLength reads a definite BER length
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, errors.New("ldap: unsupported BER length")
+	}
+	length := 0
+	for i := 0; i < count; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > maxElementLength {
+		return 0, fmt.Errorf("ldap: element of %d bytes exceeds limit", length)
+	}
+	return length, nil
+}
+
+// parseElement decodes the children of constructed elements
+func parseElement(tag byte, value []byte, depth int) (*element, error) {
+	e := &element{tag: tag, value: value}
+	if tag&constructed == 0 {
+		return e, nil
+	}
+	if depth >= maxDepth {
+		return nil, errors.New("ldap: BER elements nested too deeply")
+	}
+
+	for rest := value; len(rest) > 0; {
+		if len(rest) < 2 {
+			return nil, errors.New("ldap: truncated BER element")
+		}
+		childTag := rest[0]
+		length, header, err := parseLength(rest[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + header
+		if len(rest) < start+length {
+			return nil, errors.New("ldap: truncated BER element")
+		}
+		child, err := parseElement(childTag, rest[start:start+length], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		e.children = append(e.children, child)
+		rest = rest[start+length:]
+	}
+	return e, nil
+}
+
+// parseLength decodes a definite BER length from a buffer, returning it and
+// the number of bytes it used
+func parseLength(b []byte) (int, int, error) {
+	if b[0] < 0x80 {
+		return int(b[0]), 1, nil
+	}
+	count := int(b[0] & 0x7f)
+	if count == 0 || count > 4 || len(b) < 1+count {
+		return 0, 0, errors.New("ldap: unsupported BER length")
+	}
+	length := 0
+	for _, digit := range b[1 : 1+count] {
+		length = length<<8 | int(digit)
+	}
+	if length > maxElementLength {
+		return 0, 0, fmt.Errorf("ldap: element of %d bytes exceeds limit", length)
+	}
+	return length, 1 + count, nil
+}
+
+// int decodes an integer or enumerated element
+func (e *element) int() int64 {
+	var value int64
+	for i, b := range e.value {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+// string decodes an octet string element
+func (e *element) string() string {
+	return string(e.value)
+}
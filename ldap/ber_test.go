@@ -0,0 +1,129 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestReadElement(t *testing.T) {
+	message := encode(tagSequence, encodeInt(tagInteger, 7), encode(opBindResponse,
+		encodeInt(tagEnumerated, 49), encodeString(tagOctetString, ""), encodeString(tagOctetString, "bad password")))
+
+	tests := []struct {
+		name  string
+		input []byte
+		err   bool
+	}{
+		{"response", message, false},
+		{"long form length", append([]byte{tagOctetString, 0x81, 0x03}, "abc"...), false},
+		{"non-minimal length", append([]byte{tagOctetString, 0x82, 0x00, 0x03}, "abc"...), false},
+		{"empty", nil, true},
+		{"no length", []byte{tagSequence}, true},
+		{"truncated value", message[:len(message)-3], true},
+		{"truncated long form length", []byte{tagOctetString, 0x82, 0x01}, true},
+		{"indefinite length", []byte{tagSequence, 0x80, 0x00, 0x00}, true},
+		{"five byte length", []byte{tagOctetString, 0x85, 0, 0, 0, 0, 1}, true},
+		{"over the limit", []byte{tagOctetString, 0x84, 0x01, 0x00, 0x00, 0x01}, true},
+		{"child over the parent", []byte{tagSequence, 0x03, tagOctetString, 0x05, 'a'}, true},
+		{"child with a lone tag", []byte{tagSequence, 0x01, tagOctetString}, true},
+		{"child length overlong", []byte{tagSequence, 0x06, tagOctetString, 0x84, 0xff, 0xff, 0xff, 0xff}, true},
+		{"child length truncated", []byte{tagSequence, 0x03, tagOctetString, 0x82, 0x01}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := readElement(bufio.NewReader(bytes.NewReader(tt.input)))
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err == nil && e.tag == tagOctetString && e.string() != "abc" {
+				t.Errorf("value %q, want abc", e.string())
+			}
+		})
+	}
+
+	e, err := readElement(bufio.NewReader(bytes.NewReader(message)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.children) != 2 || e.children[0].int() != 7 || result(e.children[1]).Error() != "ldap: result code 49: bad password" {
+		t.Errorf("decoded %+v", e)
+	}
+}
+
+func TestReadElementTruncated(t *testing.T) {
+	_, err := readElement(bufio.NewReader(bytes.NewReader([]byte{tagOctetString, 0x05, 'a', 'b'})))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+// TestReadElementAllocatesWhatArrives announces an element at the length
+// limit without sending it: the client must not allocate it up front
+func TestReadElementAllocatesWhatArrives(t *testing.T) {
+	input := append([]byte{tagOctetString, 0x84, 0x00, 0xff, 0xff, 0xff}, make([]byte, 100)...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readElement(bufio.NewReader(bytes.NewReader(input)))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes for 100 received", allocated)
+	}
+}
+
+func TestReadElementDepth(t *testing.T) {
+	nest := func(depth int) []byte {
+		e := encodeString(tagOctetString, "leaf")
+		for i := 0; i < depth; i++ {
+			e = encode(tagSequence, e)
+		}
+		return e
+	}
+	if _, err := readElement(bufio.NewReader(bytes.NewReader(nest(maxDepth)))); err != nil {
+		t.Errorf("%d levels: %v", maxDepth, err)
+	}
+	if _, err := readElement(bufio.NewReader(bytes.NewReader(nest(maxDepth + 1)))); err == nil {
+		t.Errorf("%d levels decoded", maxDepth+1)
+	}
+}
+
+func TestEncodeInt(t *testing.T) {
+	for value, want := range map[int64][]byte{
+		0:    {0x00},
+		127:  {0x7f},
+		128:  {0x00, 0x80},
+		256:  {0x01, 0x00},
+		-1:   {0xff},
+		-128: {0x80},
+		-129: {0xff, 0x7f},
+	} {
+		encoded := encodeInt(tagInteger, value)
+		if !bytes.Equal(encoded[2:], want) {
+			t.Errorf("encodeInt(%d) = % x, want % x", value, encoded[2:], want)
+		}
+		e, err := readElement(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil || e.int() != value {
+			t.Errorf("%d decoded as %d: %v", value, e.int(), err)
+		}
+	}
+}
+
+func TestEncodeLength(t *testing.T) {
+	for length, want := range map[int][]byte{
+		0:       {0x00},
+		0x7f:    {0x7f},
+		0x80:    {0x81, 0x80},
+		0x100:   {0x82, 0x01, 0x00},
+		1 << 20: {0x83, 0x10, 0x00, 0x00},
+	} {
+		if got := encodeLength(length); !bytes.Equal(got, want) {
+			t.Errorf("encodeLength(%d) = % x, want % x", length, got, want)
+		}
+	}
+}
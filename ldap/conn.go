@@ -0,0 +1,286 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Protocol operation tags (RFC 4511 section 4.2 onwards)
+const (
+	opBindRequest        = classApplication | constructed | 0
+	opBindResponse       = classApplication | constructed | 1
+	opUnbindRequest      = classApplication | 2
+	opSearchRequest      = classApplication | constructed | 3
+	opSearchEntry        = classApplication | constructed | 4
+	opSearchDone         = classApplication | constructed | 5
+	opSearchReference    = classApplication | constructed | 19
+	opExtendedRequest    = classApplication | constructed | 23
+	opExtendedResponse   = classApplication | constructed | 24
+	startTLSOID          = "1.3.6.1.4.1.1466.20037"
+	scopeWholeSubtree    = 2
+	derefAliasesNever    = 0
+	resultSuccess        = 0
+	resultSizeLimitHit   = 4
+	simpleAuthentication = classContext | 0
+)
+
+// ResultInvalidCredentials is the result code of a bind with a wrong password
+const ResultInvalidCredentials = 49
+
+// ResultError is an unsuccessful LDAP result
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is a search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // Keyed by lowercased attribute name
+}
+
+// Get returns the first value of an attribute
+func (e *Entry) Get(attribute string) string {
+	if values := e.Attributes[strings.ToLower(attribute)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns every value of an attribute
+func (e *Entry) Values(attribute string) []string {
+	return e.Attributes[strings.ToLower(attribute)]
+}
+
+// Conn is a connection to an LDAP server. Operations are synchronous, so a
+// Conn must not be used by several goroutines at once.
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	nextID  int64
+}
+
+// Dial connects to an LDAP server at host:port. With useTLS the connection
+// is TLS from the start (ldaps); with startTLS it is upgraded after connecting.
+func Dial(address string, useTLS, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+	if startTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Bind authenticates the connection with a DN and password. An empty
+// password is rejected, as servers treat it as an anonymous bind that succeeds.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &ResultError{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+	return c.bind(dn, password)
+}
+
+// bind sends a simple bind request; empty credentials bind anonymously
+func (c *Conn) bind(dn, password string) error {
+	request := encode(opBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(simpleAuthentication, password),
+	)
+	response, err := c.roundTrip(request, opBindResponse)
+	if err != nil {
+		return err
+	}
+	return result(response)
+}
+
+// Search returns the entries under baseDN matching a filter, with the given
+// attributes. At most sizeLimit entries are returned; zero means no limit.
+func (c *Conn) Search(baseDN, filter string, attributes []string, sizeLimit int) ([]*Entry, error) {
+	encodedFilter, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var encodedAttributes [][]byte
+	for _, attribute := range attributes {
+		encodedAttributes = append(encodedAttributes, encodeString(tagOctetString, attribute))
+	}
+	request := encode(opSearchRequest,
+		encodeString(tagOctetString, baseDN),
+		encodeInt(tagEnumerated, scopeWholeSubtree),
+		encodeInt(tagEnumerated, derefAliasesNever),
+		encodeInt(tagInteger, int64(sizeLimit)),
+		encodeInt(tagInteger, int64(c.timeout/time.Second)),
+		encodeBool(false),
+		encodedFilter,
+		encode(tagSequence, encodedAttributes...),
+	)
+
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			// Referrals to other servers are not followed
+		case opSearchDone:
+			if err := result(op); err != nil {
+				var resultErr *ResultError
+				if errors.As(err, &resultErr) && resultErr.Code == resultSizeLimitHit {
+					return entries, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", op.tag)
+		}
+	}
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.send(encode(opUnbindRequest))
+	return c.conn.Close()
+}
+
+// startTLS upgrades the connection to TLS with the StartTLS extended operation
+func (c *Conn) startTLS(tlsConfig *tls.Config) error {
+	request := encode(opExtendedRequest, encodeString(classContext|0, startTLSOID))
+	response, err := c.roundTrip(request, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := result(response); err != nil {
+		return fmt.Errorf("ldap: StartTLS refused: %w", err)
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// roundTrip sends a request and reads its single response
+func (c *Conn) roundTrip(request []byte, responseTag byte) (*element, error) {
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if response.tag != responseTag {
+		return nil, fmt.Errorf("ldap: unexpected response 0x%02x", response.tag)
+	}
+	return response, nil
+}
+
+// send writes a request in a new message, returning the message ID
+func (c *Conn) send(request []byte) (int64, error) {
+	c.nextID++
+	message := encode(tagSequence, encodeInt(tagInteger, c.nextID), request)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(message); err != nil {
+		return 0, err
+	}
+	return c.nextID, nil
+}
+
+// receive reads the protocol operation of the next response to a message
+func (c *Conn) receive(id int64) (*element, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	message, err := readElement(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if message.tag != tagSequence || len(message.children) < 2 {
+		return nil, errors.New("ldap: malformed response")
+	}
+	if message.children[0].int() != id {
+		return nil, fmt.Errorf("ldap: response to message %d while waiting for %d", message.children[0].int(), id)
+	}
+	return message.children[1], nil
+}
+
+// result returns the error of an unsuccessful LDAPResult
+func result(op *element) error {
+	if len(op.children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	code := op.children[0].int()
+	if code == resultSuccess {
+		return nil
+	}
+	return &ResultError{Code: code, Message: op.children[2].string()}
+}
+
+// parseEntry decodes a search result entry
+func parseEntry(op *element) (*Entry, error) {
+	if len(op.children) < 2 {
+		return nil, errors.New("ldap: malformed search entry")
+	}
+	entry := &Entry{
+		DN:         op.children[0].string(),
+		Attributes: make(map[string][]string),
+	}
+	for _, attribute := range op.children[1].children {
+		if len(attribute.children) < 2 {
+			return nil, errors.New("ldap: malformed attribute")
+		}
+		name := strings.ToLower(attribute.children[0].string())
+		for _, value := range attribute.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.string())
+		}
+	}
+	return entry, nil
+}
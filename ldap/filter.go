@@ -0,0 +1,184 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511 section 4.5.1)
+const (
+	filterAnd        = classContext | constructed | 0
+	filterOr         = classContext | constructed | 1
+	filterNot        = classContext | constructed | 2
+	filterEquality   = classContext | constructed | 3
+	filterSubstrings = classContext | constructed | 4
+	filterGreater    = classContext | constructed | 5
+	filterLess       = classContext | constructed | 6
+	filterPresent    = classContext | 7
+	filterApprox     = classContext | constructed | 8
+)
+
+// EscapeFilter escapes a value for use in a search filter, so user input
+// cannot change the filter's structure
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter encodes a string filter such as (&(objectClass=person)(uid=jo*))
+func compileFilter(filter string) ([]byte, error) {
+	encoded, rest, err := parseFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return encoded, nil
+}
+
+// parseFilter encodes the filter at the start of s and returns the rest
+func parseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(': %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+
+	var encoded []byte
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		var children [][]byte
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			s = rest
+		}
+		encoded = encode(tag, children...)
+	case '!':
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		encoded = encode(filterNot, child)
+		s = rest
+	default:
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		item, err := encodeItem(s[:end])
+		if err != nil {
+			return nil, "", err
+		}
+		encoded = item
+		s = s[end:]
+	}
+
+	if !strings.HasPrefix(s, ")") {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	return encoded, s[1:], nil
+}
+
+// encodeItem encodes a simple filter such as uid=jo*, mail=*, or age>=21
+func encodeItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attribute, value := item[:eq], item[eq+1:]
+
+	tag := byte(filterEquality)
+	switch attribute[len(attribute)-1] {
+	case '>':
+		tag, attribute = filterGreater, attribute[:len(attribute)-1]
+	case '<':
+		tag, attribute = filterLess, attribute[:len(attribute)-1]
+	case '~':
+		tag, attribute = filterApprox, attribute[:len(attribute)-1]
+	}
+	if attribute == "" {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+
+	if tag == filterEquality && value == "*" {
+		return encodeString(filterPresent, attribute), nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		return encodeSubstrings(attribute, value)
+	}
+
+	unescaped, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return encode(tag, encodeString(tagOctetString, attribute), encodeString(tagOctetString, unescaped)), nil
+}
+
+// encodeSubstrings encodes a value with wildcards, such as jo*n*
+func encodeSubstrings(attribute, value string) ([]byte, error) {
+	parts := strings.Split(value, "*")
+	var substrings [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		unescaped, err := unescapeValue(part)
+		if err != nil {
+			return nil, err
+		}
+		var choice byte = classContext | 1 // any
+		switch i {
+		case 0:
+			choice = classContext | 0 // initial
+		case len(parts) - 1:
+			choice = classContext | 2 // final
+		}
+		substrings = append(substrings, encodeString(choice, unescaped))
+	}
+	return encode(filterSubstrings, encodeString(tagOctetString, attribute), encode(tagSequence, substrings...)), nil
+}
+
+// unescapeValue decodes \XX escapes in a filter value
+func unescapeValue(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
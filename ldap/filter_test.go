@@ -0,0 +1,74 @@
+package ldap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEscapeFilter(t *testing.T) {
+	tests := map[string]string{
+		"alice":          "alice",
+		"*":              `\2a`,
+		"(":              `\28`,
+		")":              `\29`,
+		`\`:              `\5c`,
+		"\x00":           `\00`,
+		"a\x00b":         `a\00b`,
+		"*)(uid=*":       `\2a\29\28uid=\2a`,
+		`\2a`:            `\5c2a`,
+		"admin)(|(uid=*": `admin\29\28|\28uid=\2a`,
+		"jöhn.doe@ex":    "jöhn.doe@ex",
+	}
+	for value, want := range tests {
+		if got := EscapeFilter(value); got != want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// TestEscapedFilterMatchesLiterally checks that an escaped value compiles to
+// a single equality match on exactly the input, whatever it contains
+func TestEscapedFilterMatchesLiterally(t *testing.T) {
+	for _, value := range []string{"alice", "*", "*)(uid=*", `\`, "a\x00b", "x)(|(objectClass=*)", `\2a`} {
+		filter := "(&(objectClass=person)(uid=" + EscapeFilter(value) + "))"
+		got, err := compileFilter(filter)
+		if err != nil {
+			t.Errorf("%q: %v", value, err)
+			continue
+		}
+		want := encode(filterAnd,
+			encode(filterEquality, encodeString(tagOctetString, "objectClass"), encodeString(tagOctetString, "person")),
+			encode(filterEquality, encodeString(tagOctetString, "uid"), encodeString(tagOctetString, value)))
+		if !bytes.Equal(got, want) {
+			t.Errorf("%q: filter %s does not match the value literally", value, filter)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(uid=*)", encodeString(filterPresent, "uid")},
+		{"(age>=21)", encode(filterGreater, encodeString(tagOctetString, "age"), encodeString(tagOctetString, "21"))},
+		{"(!(uid=a))", encode(filterNot, encode(filterEquality, encodeString(tagOctetString, "uid"), encodeString(tagOctetString, "a")))},
+		{"(uid=jo*n*)", encode(filterSubstrings, encodeString(tagOctetString, "uid"), encode(tagSequence,
+			encodeString(classContext|0, "jo"), encodeString(classContext|1, "n")))},
+	}
+	for _, tt := range tests {
+		got, err := compileFilter(tt.filter)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("compileFilter(%q) = % x, %v, want % x", tt.filter, got, err, tt.want)
+		}
+	}
+
+	for _, filter := range []string{"", "uid=a", "(uid=a", "(uid=a))", "(=a)", "(>=a)", `(uid=\2)`, `(uid=\zz)`, "(&(uid=a)"} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) succeeded", filter)
+		} else if !strings.HasPrefix(err.Error(), "ldap: ") {
+			t.Errorf("compileFilter(%q): unprefixed error %v", filter, err)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// Config configures an LDAP or Active Directory user store
+type Config struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   // Upgrade ldap:// connections with StartTLS
+	CAFile             string // CA bundle verifying the server; system roots when empty
+	InsecureSkipVerify bool
+	BindDN             string // Service account searching for users; anonymous when empty
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // %s is replaced with the escaped username
+	IDAttribute        string // Becomes the user ID; the DN is used when missing
+	EmailAttribute     string
+	GroupAttribute     string              // Lists the DNs of the user's groups, e.g. memberOf
+	RoleGroups         map[string][]string // Role to the common names of groups granting it
+	DefaultRoles       []string            // Granted to every directory user
+	PoolSize           int
+	Timeout            time.Duration
+}
+
+// UserStore authenticates users by binding to a directory with their
+// password and maps their groups to roles
+type UserStore struct {
+	config     *Config
+	groupRoles map[string][]string // Lowercased group name to roles
+	pool       *pool
+}
+
+// NewUserStore creates an LDAP user store. Connections are opened on demand.
+func NewUserStore(config *Config) (*UserStore, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+	useTLS := u.Scheme == "ldaps"
+	if !useTLS && u.Scheme != "ldap" {
+		return nil, fmt.Errorf("LDAP URL scheme must be ldap or ldaps, not %q", u.Scheme)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if useTLS {
+			port = "636"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	s := &UserStore{
+		config:     config,
+		groupRoles: make(map[string][]string),
+	}
+	for role, groups := range config.RoleGroups {
+		for _, group := range groups {
+			key := strings.ToLower(group)
+			s.groupRoles[key] = append(s.groupRoles[key], role)
+		}
+	}
+	s.pool = newPool(config.PoolSize, func() (*Conn, error) {
+		conn, err := Dial(address, useTLS, config.StartTLS, tlsConfig, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.bindService(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+	return s, nil
+}
+
+// Authenticate implements auth.UserStore by binding as the user's entry
+func (s *UserStore) Authenticate(ctx context.Context, username, password string) (*auth.User, error) {
+	if username == "" || password == "" {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	conn, entry, err := s.search(ctx, username)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, auth.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	bindErr := conn.Bind(entry.DN, password)
+	// Restore the service identity before the connection is reused
+	if err := s.bindService(conn); err != nil {
+		s.pool.put(conn, false)
+	} else {
+		s.pool.put(conn, true)
+	}
+
+	var resultErr *ResultError
+	if errors.As(bindErr, &resultErr) && resultErr.Code == ResultInvalidCredentials {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if bindErr != nil {
+		return nil, bindErr
+	}
+	return s.user(username, entry), nil
+}
+
+// Lookup implements auth.UserStore
+func (s *UserStore) Lookup(ctx context.Context, username string) (*auth.User, error) {
+	conn, entry, err := s.search(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	s.pool.put(conn, true)
+	return s.user(username, entry), nil
+}
+
+// Close closes the idle connections
+func (s *UserStore) Close() {
+	s.pool.close()
+}
+
+// search finds a user on a pooled connection, which the caller must return
+// to the pool on success. A failure on an idle connection, such as one the
+// server has since closed, is retried once on a new connection.
+func (s *UserStore) search(ctx context.Context, username string) (*Conn, *Entry, error) {
+	conn, reused, err := s.pool.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := s.find(conn, username)
+	if err != nil && reused && !healthy(err) {
+		if conn, err = s.pool.replace(conn); err != nil {
+			return nil, nil, err
+		}
+		entry, err = s.find(conn, username)
+	}
+	if err != nil {
+		s.pool.put(conn, healthy(err))
+		return nil, nil, err
+	}
+	return conn, entry, nil
+}
+
+// find returns the single entry matching the user filter
+func (s *UserStore) find(conn *Conn, username string) (*Entry, error) {
+	filter := strings.ReplaceAll(s.config.UserFilter, "%s", EscapeFilter(username))
+	attributes := []string{s.config.IDAttribute, s.config.EmailAttribute, s.config.GroupAttribute}
+	entries, err := conn.Search(s.config.BaseDN, filter, attributes, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, auth.ErrUserNotFound
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, fmt.Errorf("ldap: %q matches several entries", username)
+	}
+}
+
+// bindService binds a connection as the service account, or anonymously
+func (s *UserStore) bindService(conn *Conn) error {
+	if s.config.BindDN == "" {
+		return conn.bind("", "")
+	}
+	return conn.Bind(s.config.BindDN, s.config.BindPassword)
+}
+
+// user converts a directory entry to an account
+func (s *UserStore) user(username string, entry *Entry) *auth.User {
+	user := &auth.User{
+		ID:       entry.Get(s.config.IDAttribute),
+		Username: username,
+		Email:    entry.Get(s.config.EmailAttribute),
+		Roles:    append([]string(nil), s.config.DefaultRoles...),
+	}
+	if user.ID == "" {
+		user.ID = entry.DN
+	}
+	for _, groupDN := range entry.Values(s.config.GroupAttribute) {
+		user.Roles = auth.MergeValues(user.Roles, s.groupRoles[strings.ToLower(commonName(groupDN))])
+	}
+	return user
+}
+
+// commonName returns the value of the first RDN of a DN, such as "admins"
+// for "cn=admins,ou=groups,dc=example,dc=com"
+func commonName(dn string) string {
+	_, value, ok := strings.Cut(dn, "=")
+	if !ok {
+		return dn
+	}
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',', '+':
+			return strings.ReplaceAll(value[:i], `\`, "")
+		}
+	}
+	return strings.ReplaceAll(value, `\`, "")
+}
+
+// healthy reports whether a connection can be reused after an operation
+// error; only directory results leave the connection in a known state
+func healthy(err error) bool {
+	var resultErr *ResultError
+	return err == nil || errors.Is(err, auth.ErrUserNotFound) || errors.As(err, &resultErr)
+}
+
+// pool bounds the number of open connections and keeps idle ones for reuse
+type pool struct {
+	dial  func() (*Conn, error)
+	idle  chan *Conn
+	slots chan struct{}
+}
+
+// newPool creates a pool of at most size connections
+func newPool(size int, dial func() (*Conn, error)) *pool {
+	if size < 1 {
+		size = 1
+	}
+	return &pool{
+		dial:  dial,
+		idle:  make(chan *Conn, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// get returns an idle connection or opens one, waiting while all are in use.
+// It reports whether the connection was idle in the pool.
+func (p *pool) get(ctx context.Context) (*Conn, bool, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+
+	select {
+	case conn := <-p.idle:
+		return conn, true, nil
+	default:
+	}
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, false, err
+	}
+	return conn, false, nil
+}
+
+// replace closes a broken connection and opens a new one in its place
+func (p *pool) replace(conn *Conn) (*Conn, error) {
+	conn.Close()
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// put returns a connection to the pool, closing it unless it is reusable
+func (p *pool) put(conn *Conn, reusable bool) {
+	if reusable {
+		select {
+		case p.idle <- conn:
+		default:
+			conn.Close()
+		}
+	} else {
+		conn.Close()
+	}
+	<-p.slots
+}
+
+// close closes the idle connections
+func (p *pool) close() {
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+)
+
+const aliceDN = "uid=alice,ou=people,dc=example,dc=com"
+
+// directory is a fake LDAP server with one service account and one user.
+// Like real servers, it accepts a bind with an empty password as anonymous.
+type directory struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	binds []string // DNs bound to, anonymous binds included
+}
+
+func newDirectory(t *testing.T) *directory {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &directory{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *directory) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(id int64, ops ...[]byte) {
+		for _, op := range ops {
+			conn.Write(encode(tagSequence, encodeInt(tagInteger, id), op))
+		}
+	}
+	done := func(tag byte, code int64) []byte {
+		return encode(tag, encodeInt(tagEnumerated, code), encodeString(tagOctetString, ""), encodeString(tagOctetString, ""))
+	}
+	for {
+		message, err := readElement(reader)
+		if err != nil || len(message.children) < 2 {
+			return
+		}
+		id, op := message.children[0].int(), message.children[1]
+		switch op.tag {
+		case opBindRequest:
+			dn, password := op.children[1].string(), op.children[2].string()
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+			code := int64(ResultInvalidCredentials)
+			if password == "" || dn == "cn=gateway" && password == "service" || dn == aliceDN && password == "secret" {
+				code = resultSuccess
+			}
+			reply(id, done(opBindResponse, code))
+		case opSearchRequest:
+			// The user filter is (&(objectClass=person)(uid=%s))
+			filter := op.children[6]
+			if len(filter.children) == 2 && filter.children[1].tag == filterEquality &&
+				filter.children[1].children[1].string() == "alice" {
+				reply(id, encode(opSearchEntry, encodeString(tagOctetString, aliceDN), encode(tagSequence,
+					encode(tagSequence, encodeString(tagOctetString, "uid"), encode(tagSet, encodeString(tagOctetString, "alice"))),
+					encode(tagSequence, encodeString(tagOctetString, "memberOf"), encode(tagSet,
+						encodeString(tagOctetString, "cn=admins,ou=groups,dc=example,dc=com"))),
+				)))
+			}
+			reply(id, done(opSearchDone, resultSuccess))
+		default:
+			return
+		}
+	}
+}
+
+// userBinds counts the binds to anything but the service account
+func (d *directory) userBinds() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, dn := range d.binds {
+		if dn != "cn=gateway" {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestStore(t *testing.T, d *directory) *UserStore {
+	s, err := NewUserStore(&Config{
+		URL:            "ldap://" + d.listener.Addr().String(),
+		BindDN:         "cn=gateway",
+		BindPassword:   "service",
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(&(objectClass=person)(uid=%s))",
+		IDAttribute:    "uid",
+		GroupAttribute: "memberOf",
+		RoleGroups:     map[string][]string{"admin": {"admins"}},
+		DefaultRoles:   []string{"user"},
+		Timeout:        5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := newDirectory(t)
+	s := newTestStore(t, d)
+	ctx := context.Background()
+
+	user, err := s.Authenticate(ctx, "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "alice" || len(user.Roles) != 2 || user.Roles[0] != "user" || user.Roles[1] != "admin" {
+		t.Errorf("authenticated %+v", user)
+	}
+
+	for _, tt := range []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"bob", "secret"},
+		{"*", "secret"},
+		{"*)(uid=alice", "secret"},
+	} {
+		if _, err := s.Authenticate(ctx, tt.username, tt.password); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("%q with %q: err = %v, want ErrInvalidCredentials", tt.username, tt.password, err)
+		}
+	}
+}
+
+// TestAuthenticateEmptyPassword guards against unauthenticated binds: the
+// directory accepts an empty password, so it must never be sent
+func TestAuthenticateEmptyPassword(t *testing.T) {
+	d := newDirectory(t)
+	s := newTestStore(t, d)
+	ctx := context.Background()
+
+	for _, username := range []string{"alice", ""} {
+		if _, err := s.Authenticate(ctx, username, ""); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("%q: err = %v, want ErrInvalidCredentials", username, err)
+		}
+	}
+	if n := d.userBinds(); n != 0 {
+		t.Errorf("directory saw %d user binds, want none", n)
+	}
+
+	conn, err := Dial(d.listener.Addr().String(), false, false, nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var resultErr *ResultError
+	if err := conn.Bind(aliceDN, ""); !errors.As(err, &resultErr) || resultErr.Code != ResultInvalidCredentials {
+		t.Errorf("Bind with an empty password: err = %v, want invalid credentials", err)
+	}
+	if n := d.userBinds(); n != 0 {
+		t.Errorf("directory saw %d user binds, want none", n)
+	}
+}
@@ -0,0 +1,296 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target is one request of the path mix
+type Target struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Weight int    `json:"weight"` // Relative share of requests
+}
+
+// Config represents a load test
+type Config struct {
+	BaseURL     string        // Gateway base URL
+	Targets     []Target      // Path mix
+	RPS         float64       // Requests started per second; 0 sends as fast as the workers allow
+	Concurrency int           // Requests in flight at most
+	Duration    time.Duration // How long requests are started
+	Timeout     time.Duration // Per request
+	// Credentials returns the headers authenticating the request of a
+	// synthetic user numbered from 0 to Users-1; nil sends requests anonymously
+	Credentials func(user int) http.Header
+	Users       int
+}
+
+// ParseTargets parses a path mix such as "GET /api/profile=3,POST /api/orders=1".
+// The method defaults to GET and the weight to 1.
+func ParseTargets(spec string) ([]Target, error) {
+	var targets []Target
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target := Target{Method: http.MethodGet, Weight: 1}
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			weight, err := strconv.Atoi(entry[i+1:])
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q", entry)
+			}
+			target.Weight, entry = weight, entry[:i]
+		}
+		if method, path, ok := strings.Cut(entry, " "); ok {
+			target.Method, entry = strings.ToUpper(method), strings.TrimSpace(path)
+		}
+		if !strings.HasPrefix(entry, "/") {
+			return nil, fmt.Errorf("path %q must start with /", entry)
+		}
+		target.Path = entry
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least one path is required")
+	}
+	return targets, nil
+}
+
+// TargetResult summarizes the requests of one target
+type TargetResult struct {
+	Method   string         `json:"method"`
+	Path     string         `json:"path"`
+	Requests int            `json:"requests"`
+	Statuses map[string]int `json:"statuses"` // By status code, or "error" for transport failures
+	Latency  Latency        `json:"latency"`
+}
+
+// Latency summarizes response times in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Result is the outcome of a load test
+type Result struct {
+	Duration    float64         `json:"duration_seconds"`
+	Requests    int             `json:"requests"`
+	Dropped     int             `json:"dropped"` // Requests not started because every worker was busy
+	RPS         float64         `json:"rps"`     // Achieved
+	Statuses    map[string]int  `json:"statuses"`
+	RateLimited int             `json:"rate_limited"` // 429 responses
+	Errors      int             `json:"errors"`       // Transport failures and timeouts
+	ErrorSample []string        `json:"error_sample,omitempty"`
+	Latency     Latency         `json:"latency"`
+	Targets     []*TargetResult `json:"targets"`
+}
+
+// sample is the outcome of one request
+type sample struct {
+	target  int
+	status  int // 0 for transport failures
+	latency time.Duration
+	err     error
+}
+
+// Run generates traffic until the duration elapses or ctx is canceled and
+// waits for requests in flight. With an RPS, requests are started on a fixed
+// schedule regardless of how fast the gateway answers, so slow responses do
+// not lower the offered load; starts finding every worker busy are dropped.
+func Run(ctx context.Context, config *Config) *Result {
+	client := &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        config.Concurrency,
+			MaxIdleConnsPerHost: config.Concurrency,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	defer client.CloseIdleConnections()
+
+	totalWeight := 0
+	for _, target := range config.Targets {
+		totalWeight += target.Weight
+	}
+	pick := func(rnd *rand.Rand) int {
+		n := rnd.Intn(totalWeight)
+		for i, target := range config.Targets {
+			if n < target.Weight {
+				return i
+			}
+			n -= target.Weight
+		}
+		return len(config.Targets) - 1
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	jobs := make(chan int)
+	samples := make(chan sample, config.Concurrency)
+	var workers sync.WaitGroup
+	for w := 0; w < config.Concurrency; w++ {
+		workers.Add(1)
+		go func(w int) {
+			defer workers.Done()
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			for n := range jobs {
+				target := pick(rnd)
+				samples <- send(client, config, target, n)
+			}
+		}(w)
+	}
+
+	var collected []sample
+	collectorDone := make(chan struct{})
+	go func() {
+		defer close(collectorDone)
+		for s := range samples {
+			collected = append(collected, s)
+		}
+	}()
+
+	start := time.Now()
+	dropped := 0
+	if config.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / config.RPS))
+	schedule:
+		for n := 0; ; n++ {
+			select {
+			case <-ctx.Done():
+				break schedule
+			case <-ticker.C:
+			}
+			select {
+			case jobs <- n:
+			default:
+				dropped++
+			}
+		}
+		ticker.Stop()
+	} else {
+	flood:
+		for n := 0; ; n++ {
+			select {
+			case <-ctx.Done():
+				break flood
+			case jobs <- n:
+			}
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	close(samples)
+	<-collectorDone
+
+	return summarize(config, collected, dropped, time.Since(start))
+}
+
+// send makes one request as the nth synthetic request
+func send(client *http.Client, config *Config, target, n int) sample {
+	t := config.Targets[target]
+	req, err := http.NewRequest(t.Method, strings.TrimSuffix(config.BaseURL, "/")+t.Path, nil)
+	if err != nil {
+		return sample{target: target, err: err}
+	}
+	req.Header.Set("User-Agent", "api-gateway-loadtest")
+	if config.Credentials != nil {
+		for name, values := range config.Credentials(n % max(config.Users, 1)) {
+			req.Header[name] = values
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return sample{target: target, latency: time.Since(start), err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return sample{target: target, status: resp.StatusCode, latency: time.Since(start)}
+}
+
+// summarize aggregates the samples
+func summarize(config *Config, samples []sample, dropped int, elapsed time.Duration) *Result {
+	result := &Result{
+		Duration: elapsed.Seconds(),
+		Requests: len(samples),
+		Dropped:  dropped,
+		Statuses: make(map[string]int),
+	}
+	if elapsed > 0 {
+		result.RPS = float64(len(samples)) / elapsed.Seconds()
+	}
+
+	all := make([]time.Duration, 0, len(samples))
+	byTarget := make([][]time.Duration, len(config.Targets))
+	for _, target := range config.Targets {
+		result.Targets = append(result.Targets, &TargetResult{Method: target.Method, Path: target.Path, Statuses: make(map[string]int)})
+	}
+	errorsSeen := make(map[string]bool)
+	for _, s := range samples {
+		status := strconv.Itoa(s.status)
+		if s.err != nil {
+			status = "error"
+			result.Errors++
+			if msg := s.err.Error(); !errorsSeen[msg] && len(result.ErrorSample) < 5 {
+				errorsSeen[msg] = true
+				result.ErrorSample = append(result.ErrorSample, msg)
+			}
+		}
+		if s.status == http.StatusTooManyRequests {
+			result.RateLimited++
+		}
+		result.Statuses[status]++
+		result.Targets[s.target].Requests++
+		result.Targets[s.target].Statuses[status]++
+		all = append(all, s.latency)
+		byTarget[s.target] = append(byTarget[s.target], s.latency)
+	}
+
+	result.Latency = summarizeLatency(all)
+	for i, latencies := range byTarget {
+		result.Targets[i].Latency = summarizeLatency(latencies)
+	}
+	return result
+}
+
+// summarizeLatency computes the mean and percentiles of latencies
+func summarizeLatency(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	percentile := func(p float64) float64 {
+		return milliseconds(latencies[min(len(latencies)-1, int(p*float64(len(latencies))))])
+	}
+	return Latency{
+		Mean: milliseconds(total / time.Duration(len(latencies))),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
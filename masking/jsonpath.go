@@ -0,0 +1,167 @@
+package masking
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Selector is a JSONPath expression selecting the fields to mask. Supported
+// syntax: $.name, $['name'], $.list[0], $.list[*], $.*, and $..name for
+// recursive descent.
+type Selector struct {
+	expr     string
+	segments []segment
+}
+
+// segment is one step of a selector
+type segment struct {
+	recursive bool // Matches at any depth below the current node
+	wildcard  bool
+	name      string
+	index     int // Array index when isIndex is set
+	isIndex   bool
+}
+
+// ParseSelector parses a JSONPath expression
+func ParseSelector(expr string) (*Selector, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(expr), "$")
+	if !ok {
+		return nil, fmt.Errorf("JSONPath %q must start with $", expr)
+	}
+
+	selector := &Selector{expr: expr}
+	for rest != "" {
+		var seg segment
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			seg.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			rest = parseName(rest, &seg)
+		case strings.HasPrefix(rest, "."):
+			rest = parseName(rest[1:], &seg)
+		case !strings.HasPrefix(rest, "["):
+			return nil, fmt.Errorf("JSONPath %q: unexpected %q", expr, rest)
+		}
+
+		if seg.name == "" && !seg.wildcard {
+			end := strings.Index(rest, "]")
+			if !strings.HasPrefix(rest, "[") || end < 0 {
+				return nil, fmt.Errorf("JSONPath %q: expected a field name or [...]", expr)
+			}
+			if err := parseBracket(rest[1:end], &seg); err != nil {
+				return nil, fmt.Errorf("JSONPath %q: %w", expr, err)
+			}
+			rest = rest[end+1:]
+		}
+		selector.segments = append(selector.segments, seg)
+	}
+
+	if len(selector.segments) == 0 {
+		return nil, fmt.Errorf("JSONPath %q selects the whole document", expr)
+	}
+	return selector, nil
+}
+
+// parseName reads a dotted field name or wildcard
+func parseName(rest string, seg *segment) string {
+	end := strings.IndexAny(rest, ".[")
+	if end < 0 {
+		end = len(rest)
+	}
+	if name := rest[:end]; name == "*" {
+		seg.wildcard = true
+	} else {
+		seg.name = name
+	}
+	return rest[end:]
+}
+
+// parseBracket reads a quoted name, an index or a wildcard
+func parseBracket(content string, seg *segment) error {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "*":
+		seg.wildcard = true
+	case len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0]:
+		seg.name = content[1 : len(content)-1]
+	default:
+		index, err := strconv.Atoi(content)
+		if err != nil || index < 0 {
+			return fmt.Errorf("invalid subscript [%s]", content)
+		}
+		seg.index, seg.isIndex = index, true
+	}
+	if seg.name == "" && !seg.wildcard && !seg.isIndex {
+		return fmt.Errorf("empty subscript")
+	}
+	return nil
+}
+
+// String returns the expression
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Mask replaces every selected non-null value in a decoded JSON document and
+// returns how many were replaced
+func (s *Selector) Mask(document interface{}, mask string) int {
+	return maskSegments(document, s.segments, mask)
+}
+
+// maskSegments applies the first segment to a node and continues with the
+// rest on each match, replacing matches of the last segment
+func maskSegments(node interface{}, segments []segment, mask string) int {
+	seg, rest := segments[0], segments[1:]
+	count := 0
+
+	replace := func(value interface{}) (interface{}, bool) {
+		if len(rest) > 0 {
+			count += maskSegments(value, rest, mask)
+			return value, false
+		}
+		if value == nil {
+			return nil, false
+		}
+		count++
+		return mask, true
+	}
+
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if !seg.isIndex && (seg.wildcard || key == seg.name) {
+				if masked, ok := replace(child); ok {
+					v[key] = masked
+				}
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if seg.wildcard || (seg.isIndex && seg.index == i) {
+				if masked, ok := replace(child); ok {
+					v[i] = masked
+				}
+			}
+		}
+	}
+
+	// Recursive descent applies the same segment to every descendant
+	if seg.recursive {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for _, child := range v {
+				count += maskSegments(child, segments, mask)
+			}
+		case []interface{}:
+			for _, child := range v {
+				count += maskSegments(child, segments, mask)
+			}
+		}
+	}
+
+	return count
+}
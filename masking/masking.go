@@ -0,0 +1,207 @@
+package masking
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api-gateway/auth"
+	"api-gateway/proxy"
+)
+
+// Rule masks JSON response fields on matching routes for callers that hold
+// none of the unmasking roles
+type Rule struct {
+	Name        string
+	Paths       []string // Route prefixes the rule applies to
+	Fields      []*Selector
+	UnmaskRoles []string // Roles that see the original values, e.g. "pii:read"
+	Mask        string   // Replacement for masked values
+}
+
+// Config represents response masking configuration
+type Config struct {
+	Rules []*Rule
+}
+
+// Masker masks personal data in JSON responses according to the caller's roles
+type Masker struct {
+	config *Config
+}
+
+// NewMasker creates a response masker
+func NewMasker(config *Config) *Masker {
+	return &Masker{config: config}
+}
+
+// Middleware returns the HTTP middleware function
+func (m *Masker) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rules := m.match(r.URL.Path)
+			if len(rules) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Masked values depend on the caller
+			w.Header().Add("Vary", "Authorization")
+			w.Header().Add("Vary", "X-API-Key")
+
+			// Ask for an unencoded response so it can be inspected; outer
+			// middleware compresses it for the client
+			r = r.Clone(r.Context())
+			r.Header.Del("Accept-Encoding")
+			r = auth.WithIdentitySlot(r)
+
+			mw := &maskWriter{ResponseWriter: w, request: r, rules: rules}
+			next.ServeHTTP(mw, r)
+			if mw.buffering {
+				mw.flushMasked()
+			}
+		})
+	}
+}
+
+// match returns the rules applying to a path
+func (m *Masker) match(path string) []*Rule {
+	var rules []*Rule
+	for _, rule := range m.config.Rules {
+		for _, prefix := range rule.Paths {
+			if proxy.HasPathPrefix(path, prefix) {
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// maskWriter holds back JSON responses that need masking for the caller
+type maskWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	rules   []*Rule
+
+	wroteHeader bool
+	buffering   bool
+	masked      []*Rule // Rules whose fields the caller may not see
+	status      int
+	body        bytes.Buffer
+}
+
+func (mw *maskWriter) WriteHeader(code int) {
+	if mw.wroteHeader {
+		return
+	}
+	mw.wroteHeader = true
+
+	// Authentication has run by now, so the caller's roles are known
+	mediaType, _, _ := mime.ParseMediaType(mw.Header().Get("Content-Type"))
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		userCtx := auth.GetResolvedIdentity(mw.request)
+		for _, rule := range mw.rules {
+			if !hasAnyRole(userCtx, rule.UnmaskRoles) {
+				mw.masked = append(mw.masked, rule)
+			}
+		}
+	}
+	if len(mw.masked) > 0 && code != http.StatusNoContent && code != http.StatusNotModified {
+		mw.buffering = true
+		mw.status = code
+		return
+	}
+	mw.ResponseWriter.WriteHeader(code)
+}
+
+func (mw *maskWriter) Write(data []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	if mw.buffering {
+		return mw.body.Write(data)
+	}
+	return mw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher
+func (mw *maskWriter) Flush() {
+	if mw.buffering {
+		return
+	}
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// flushMasked writes the buffered response with the selected fields masked.
+// Responses that cannot be inspected are withheld rather than leaked.
+func (mw *maskWriter) flushMasked() {
+	header := mw.ResponseWriter.Header()
+	body := mw.body.Bytes()
+
+	if header.Get("Content-Encoding") != "" {
+		mw.fail()
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := decoder.Decode(&document); err != nil {
+			mw.fail()
+			return
+		}
+	}
+
+	count := 0
+	for _, rule := range mw.masked {
+		for _, field := range rule.Fields {
+			count += field.Mask(document, rule.Mask)
+		}
+	}
+
+	if count > 0 {
+		masked, err := json.Marshal(document)
+		if err != nil {
+			mw.fail()
+			return
+		}
+		body = masked
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		header.Del("ETag")
+	}
+
+	mw.ResponseWriter.WriteHeader(mw.status)
+	mw.ResponseWriter.Write(body)
+}
+
+// fail replaces a response that could not be masked
+func (mw *maskWriter) fail() {
+	header := mw.ResponseWriter.Header()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Del("ETag")
+	header.Set("Content-Type", "application/json")
+	mw.ResponseWriter.WriteHeader(http.StatusBadGateway)
+	mw.ResponseWriter.Write([]byte(`{"error":"Bad Gateway","details":"Response could not be masked for this caller"}` + "\n"))
+}
+
+// hasAnyRole reports whether the caller holds one of the roles
+func hasAnyRole(userCtx *auth.UserContext, roles []string) bool {
+	if userCtx == nil {
+		return false
+	}
+	for _, role := range roles {
+		for _, held := range userCtx.Roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package masking
+
+import "testing"
+
+func TestMatchOnSegmentBoundaries(t *testing.T) {
+	rule := &Rule{Name: "customers", Paths: []string{"/api/customers"}}
+	m := NewMasker(&Config{Rules: []*Rule{rule}})
+	for path, want := range map[string]bool{
+		"/api/customers":         true,
+		"/api/customers/42":      true,
+		"/api/customers-public":  false,
+		"/api/customersearch/42": false,
+	} {
+		if got := len(m.match(path)) == 1; got != want {
+			t.Errorf("match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package metering
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"api-gateway/chargeback"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ledgerMonths is how many months of reported quantities are kept; runs only
+// push the current and previous month
+const ledgerMonths = 3
+
+// Ledger records the quantity reported to the billing provider per month,
+// tenant and consumer
+type Ledger interface {
+	// Reported returns the month's reported quantities by tenant and consumer
+	Reported(ctx context.Context, month string) (map[string]int64, error)
+	// Add records a quantity as reported
+	Add(ctx context.Context, month, id string, quantity int64) error
+}
+
+// MemoryLedger keeps reported quantities in memory; after a restart usage is
+// reported again, relying on the provider to deduplicate it
+type MemoryLedger struct {
+	mu     sync.Mutex
+	months map[string]map[string]int64
+}
+
+// NewMemoryLedger creates a new in-memory ledger
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		months: make(map[string]map[string]int64),
+	}
+}
+
+// Reported returns the month's reported quantities
+func (l *MemoryLedger) Reported(ctx context.Context, month string) (map[string]int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reported := make(map[string]int64, len(l.months[month]))
+	for id, quantity := range l.months[month] {
+		reported[id] = quantity
+	}
+	return reported, nil
+}
+
+// Add records a quantity as reported, forgetting old months
+func (l *MemoryLedger) Add(ctx context.Context, month, id string, quantity int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.months[month] == nil {
+		l.months[month] = make(map[string]int64)
+		oldest := time.Now().UTC().AddDate(0, 1-ledgerMonths, 0).Format(chargeback.MonthFormat)
+		for m := range l.months {
+			if m < oldest {
+				delete(l.months, m)
+			}
+		}
+	}
+	l.months[month][id] += quantity
+	return nil
+}
+
+// redisLedgerPrefix namespaces ledger keys; each month is a hash of quantities
+const redisLedgerPrefix = "metering:reported:"
+
+// RedisLedger keeps reported quantities in Redis, so they survive restarts
+// and leader changes
+type RedisLedger struct {
+	client *redis.Client
+}
+
+// NewRedisLedger creates a new Redis-backed ledger
+func NewRedisLedger(client *redis.Client) *RedisLedger {
+	return &RedisLedger{
+		client: client,
+	}
+}
+
+// Reported returns the month's reported quantities
+func (l *RedisLedger) Reported(ctx context.Context, month string) (map[string]int64, error) {
+	values, err := l.client.HGetAll(ctx, redisLedgerPrefix+month).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reported usage: %w", err)
+	}
+	reported := make(map[string]int64, len(values))
+	for id, value := range values {
+		reported[id], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return reported, nil
+}
+
+// Add records a quantity as reported, expiring the month's hash once it is
+// past retention
+func (l *RedisLedger) Add(ctx context.Context, month, id string, quantity int64) error {
+	start, err := time.Parse(chargeback.MonthFormat, month)
+	if err != nil {
+		return fmt.Errorf("invalid month %q: %w", month, err)
+	}
+	pipe := l.client.Pipeline()
+	pipe.HIncrBy(ctx, redisLedgerPrefix+month, id, quantity)
+	pipe.ExpireAt(ctx, redisLedgerPrefix+month, start.AddDate(0, ledgerMonths, 0))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record reported usage: %w", err)
+	}
+	return nil
+}
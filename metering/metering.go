@@ -0,0 +1,352 @@
+package metering
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"api-gateway/chargeback"
+	"api-gateway/metrics"
+)
+
+// Quantities that can be reported to the billing provider
+const (
+	QuantityRequests  = "requests"
+	QuantityBytes     = "bytes" // Request and response body bytes
+	QuantityCostUnits = "cost_units"
+)
+
+// Record is usage reported to the billing provider: the quantity a consumer
+// used since the previous report
+type Record struct {
+	Month          string    `json:"month"`
+	Tenant         string    `json:"tenant"`
+	Consumer       string    `json:"consumer"`
+	Name           string    `json:"name,omitempty"` // API key name
+	Plan           string    `json:"plan,omitempty"` // API key plan
+	Quantity       int64     `json:"quantity"`
+	Timestamp      time.Time `json:"timestamp"`
+	IdempotencyKey string    `json:"idempotency_key"` // Stays the same when a record is retried
+}
+
+// Provider sends usage records to a billing provider's metering API
+type Provider interface {
+	// Push reports one record. It returns ErrSkip when the provider has no
+	// place for the record's consumer, and wraps ErrPermanent when retrying
+	// cannot help.
+	Push(ctx context.Context, record *Record) error
+}
+
+var (
+	// ErrSkip is returned by providers for records they do not bill
+	ErrSkip = errors.New("consumer is not billed by the provider")
+	// ErrPermanent is wrapped by provider errors that retrying cannot fix
+	ErrPermanent = errors.New("permanent metering error")
+)
+
+// Config represents metered billing configuration
+type Config struct {
+	Quantity     string // "requests", "bytes" or "cost_units"
+	MaxRetries   int    // Retries of a failed push within one run
+	RetryBackoff time.Duration
+}
+
+// Reconciliation compares the usage metered for a consumer with what was
+// reported to the billing provider
+type Reconciliation struct {
+	Tenant    string `json:"tenant"`
+	Consumer  string `json:"consumer"`
+	Name      string `json:"name,omitempty"`
+	Plan      string `json:"plan,omitempty"`
+	Metered   int64  `json:"metered"`
+	Reported  int64  `json:"reported"`
+	Pending   int64  `json:"pending"` // Metered but not yet reported
+	Status    string `json:"status"`  // "reconciled", "pending", "failed" or "skipped"
+	LastError string `json:"last_error,omitempty"`
+}
+
+// RunResult summarizes one run of pushes
+type RunResult struct {
+	RanAt    time.Time `json:"ran_at"`
+	Pushed   int       `json:"pushed"`
+	Quantity int64     `json:"quantity"`
+	Failed   int       `json:"failed"`
+	Skipped  int       `json:"skipped"`
+}
+
+// Meter periodically pushes the chargeback usage of each consumer to a
+// billing provider, reporting only what was not reported before
+type Meter struct {
+	config   *Config
+	usage    *chargeback.Recorder
+	provider Provider
+	ledger   Ledger
+
+	mu          sync.Mutex
+	outstanding map[string]*Record // Month + tenant and consumer -> record that failed to push
+	errors      map[string]string  // Month + tenant and consumer -> last push error
+	skipped     map[string]bool
+	lastRun     *RunResult
+
+	records *metrics.CounterVec
+}
+
+// NewMeter creates a meter. Runs are started by the caller, either with Start
+// or as a cluster leader job.
+func NewMeter(config *Config, usage *chargeback.Recorder, provider Provider, ledger Ledger, reg *metrics.Registry) *Meter {
+	return &Meter{
+		config:      config,
+		usage:       usage,
+		provider:    provider,
+		ledger:      ledger,
+		outstanding: make(map[string]*Record),
+		errors:      make(map[string]string),
+		skipped:     make(map[string]bool),
+		records: reg.NewCounterVec("gateway_metering_records_total",
+			"Usage records sent to the billing provider, by result (pushed, failed or skipped).", "result"),
+	}
+}
+
+// Start pushes usage every interval
+func (m *Meter) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if _, err := m.Run(ctx); err != nil {
+				log.Printf("Metering run failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// Run pushes the unreported usage of the current and previous month, so the
+// tail of a month is reported after it ends
+func (m *Meter) Run(ctx context.Context) (any, error) {
+	now := time.Now().UTC()
+	months := []string{now.AddDate(0, -1, 0).Format(chargeback.MonthFormat), now.Format(chargeback.MonthFormat)}
+	m.forgetBefore(months[0])
+
+	result := &RunResult{RanAt: now}
+	var firstErr error
+	for _, month := range months {
+		if err := m.push(ctx, month, now, result); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if result.Failed > 0 && firstErr == nil {
+		firstErr = fmt.Errorf("%d usage records failed to push", result.Failed)
+	}
+
+	m.mu.Lock()
+	m.lastRun = result
+	m.mu.Unlock()
+	if result.Pushed > 0 || result.Failed > 0 {
+		log.Printf("Metering: pushed %d records (quantity %d), %d failed", result.Pushed, result.Quantity, result.Failed)
+	}
+	return result, firstErr
+}
+
+// push reports the month's unreported usage
+func (m *Meter) push(ctx context.Context, month string, now time.Time, result *RunResult) error {
+	usages, err := m.usage.Report(ctx, month, false)
+	if err != nil {
+		return err
+	}
+	reported, err := m.ledger.Reported(ctx, month)
+	if err != nil {
+		return err
+	}
+
+	for _, usage := range usages {
+		if usage.Consumer == "anonymous" {
+			continue
+		}
+		id := usage.Tenant + "\x00" + usage.Consumer
+		key := month + "\x00" + id
+
+		// A record that failed is retried unchanged, since providers reject
+		// an idempotency key reused with other parameters; newer usage
+		// follows in a later run
+		m.mu.Lock()
+		record := m.outstanding[key]
+		m.mu.Unlock()
+		if record == nil {
+			delta := m.quantity(usage) - reported[id]
+			if delta <= 0 {
+				continue
+			}
+			record = &Record{
+				Month:     month,
+				Tenant:    usage.Tenant,
+				Consumer:  usage.Consumer,
+				Name:      usage.Name,
+				Plan:      usage.Plan,
+				Quantity:  delta,
+				Timestamp: now,
+				// Derived from what was already reported, so a push that
+				// succeeded without being recorded is deduplicated by the provider
+				IdempotencyKey: idempotencyKey(month, id, reported[id], delta),
+			}
+		}
+
+		err := m.pushWithRetry(ctx, record)
+		m.mu.Lock()
+		delete(m.outstanding, key)
+		delete(m.skipped, key)
+		delete(m.errors, key)
+		switch {
+		case errors.Is(err, ErrSkip):
+			m.skipped[key] = true
+			result.Skipped++
+			m.records.Inc("skipped")
+		case err != nil:
+			if !errors.Is(err, ErrPermanent) {
+				m.outstanding[key] = record
+			}
+			m.errors[key] = err.Error()
+			result.Failed++
+			m.records.Inc("failed")
+			log.Printf("Failed to push usage of %s to the billing provider: %v", usage.Consumer, err)
+		}
+		m.mu.Unlock()
+		if err != nil {
+			continue
+		}
+
+		if err := m.ledger.Add(ctx, month, id, record.Quantity); err != nil {
+			return err
+		}
+		result.Pushed++
+		result.Quantity += record.Quantity
+		m.records.Inc("pushed")
+	}
+	return nil
+}
+
+// pushWithRetry pushes a record, backing off exponentially between attempts
+func (m *Meter) pushWithRetry(ctx context.Context, record *Record) error {
+	backoff := m.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := m.provider.Push(ctx, record)
+		if err == nil || errors.Is(err, ErrSkip) || errors.Is(err, ErrPermanent) || attempt >= m.config.MaxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// quantity returns the usage's total in the configured quantity, rounded
+// down so fractions are reported once they add up
+func (m *Meter) quantity(usage *chargeback.Usage) int64 {
+	switch m.config.Quantity {
+	case QuantityBytes:
+		return usage.RequestBytes + usage.ResponseBytes
+	case QuantityCostUnits:
+		return int64(math.Floor(usage.CostUnits))
+	default:
+		return usage.Requests
+	}
+}
+
+// Reconcile compares the month's metered usage with what was reported
+func (m *Meter) Reconcile(ctx context.Context, month string) ([]*Reconciliation, error) {
+	usages, err := m.usage.Report(ctx, month, false)
+	if err != nil {
+		return nil, err
+	}
+	reported, err := m.ledger.Reported(ctx, month)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reconciliations := make([]*Reconciliation, 0, len(usages))
+	for _, usage := range usages {
+		if usage.Consumer == "anonymous" {
+			continue
+		}
+		id := usage.Tenant + "\x00" + usage.Consumer
+		rec := &Reconciliation{
+			Tenant:    usage.Tenant,
+			Consumer:  usage.Consumer,
+			Name:      usage.Name,
+			Plan:      usage.Plan,
+			Metered:   m.quantity(usage),
+			Reported:  reported[id],
+			LastError: m.errors[month+"\x00"+id],
+		}
+		rec.Pending = rec.Metered - rec.Reported
+		switch {
+		case m.skipped[month+"\x00"+id]:
+			rec.Status = "skipped"
+		case rec.LastError != "":
+			rec.Status = "failed"
+		case rec.Pending > 0:
+			rec.Status = "pending"
+		default:
+			rec.Status = "reconciled"
+		}
+		reconciliations = append(reconciliations, rec)
+	}
+	sort.Slice(reconciliations, func(i, j int) bool {
+		if reconciliations[i].Tenant != reconciliations[j].Tenant {
+			return reconciliations[i].Tenant < reconciliations[j].Tenant
+		}
+		return reconciliations[i].Consumer < reconciliations[j].Consumer
+	})
+	return reconciliations, nil
+}
+
+// forgetBefore drops failed records, push errors and skips recorded for
+// months before month
+func (m *Meter) forgetBefore(month string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.outstanding {
+		if key[:len(month)] < month {
+			delete(m.outstanding, key)
+		}
+	}
+	for key := range m.errors {
+		if key[:len(month)] < month {
+			delete(m.errors, key)
+		}
+	}
+	for key := range m.skipped {
+		if key[:len(month)] < month {
+			delete(m.skipped, key)
+		}
+	}
+}
+
+// LastRun returns the result of this instance's latest run, or nil
+func (m *Meter) LastRun() *RunResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastRun
+}
+
+// idempotencyKey derives a record's idempotency key
+func idempotencyKey(month, id string, reportedBefore, quantity int64) string {
+	sum := sha256.Sum256([]byte(month + "\x00" + id + "\x00" + strconv.FormatInt(reportedBefore, 10) + "\x00" + strconv.FormatInt(quantity, 10)))
+	return "gw-" + hex.EncodeToString(sum[:16])
+}
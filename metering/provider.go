@@ -0,0 +1,116 @@
+package metering
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeProvider reports usage as Stripe usage records on metered
+// subscription items
+type StripeProvider struct {
+	baseURL string
+	apiKey  string
+	// items maps consumers, or else tenants, to subscription item IDs
+	items  map[string]string
+	client *http.Client
+}
+
+// NewStripeProvider creates a Stripe metering provider
+func NewStripeProvider(baseURL, apiKey string, items map[string]string, timeout time.Duration) *StripeProvider {
+	return &StripeProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		items:   items,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Push increments the subscription item's usage by the record's quantity
+func (p *StripeProvider) Push(ctx context.Context, record *Record) error {
+	item, ok := p.items[record.Consumer]
+	if !ok {
+		if item, ok = p.items[record.Tenant]; !ok {
+			return ErrSkip
+		}
+	}
+
+	form := url.Values{
+		"quantity":  {strconv.FormatInt(record.Quantity, 10)},
+		"timestamp": {strconv.FormatInt(record.Timestamp.Unix(), 10)},
+		"action":    {"increment"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.baseURL+"/v1/subscription_items/"+url.PathEscape(item)+"/usage_records", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", record.IdempotencyKey)
+
+	return send(p.client, req)
+}
+
+// HTTPProvider posts usage records as JSON to a metering endpoint
+type HTTPProvider struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPProvider creates an HTTP metering provider; token, if set, is sent as
+// a bearer token
+func NewHTTPProvider(url, token string, timeout time.Duration) *HTTPProvider {
+	return &HTTPProvider{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Push posts the record
+func (p *HTTPProvider) Push(ctx context.Context, record *Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", record.IdempotencyKey)
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	return send(p.client, req)
+}
+
+// send performs a provider request. Client errors other than 409 and 429 are
+// permanent; retrying a request the provider rejected cannot help.
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("provider answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// OtherLabel is the label value of values outside the allowlist or beyond
+// the limit
+const OtherLabel = "other"
+
+// LabelLimit bounds the distinct values of a label, so dynamic values such
+// as consumer IDs cannot grow the number of series without bound
+type LabelLimit struct {
+	Name      string   // Label name, used in log messages
+	Allowlist []string // Values, or prefixes ending in "*", kept as is; empty allows every value
+	Exempt    []string // Values kept regardless of the allowlist and limit
+	MaxValues int      // Distinct values kept before the rest become "other"; 0 is unlimited
+
+	mu        sync.Mutex
+	seen      map[string]bool
+	overflown bool
+}
+
+// Value returns value if it may be used as a label, or "other"
+func (l *LabelLimit) Value(value string) string {
+	if l == nil {
+		return value
+	}
+	for _, exempt := range l.Exempt {
+		if value == exempt {
+			return value
+		}
+	}
+	if !l.allowed(value) {
+		return OtherLabel
+	}
+	if l.MaxValues <= 0 {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[value] {
+		return value
+	}
+	if len(l.seen) >= l.MaxValues {
+		if !l.overflown {
+			l.overflown = true
+			log.Printf("Metrics: more than %d %s label values, labeling the rest %q", l.MaxValues, l.Name, OtherLabel)
+		}
+		return OtherLabel
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	l.seen[value] = true
+	return value
+}
+
+// allowed reports whether the allowlist covers value
+func (l *LabelLimit) allowed(value string) bool {
+	if len(l.Allowlist) == 0 {
+		return true
+	}
+	for _, entry := range l.Allowlist {
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.HasPrefix(value, prefix) {
+				return true
+			}
+		} else if value == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// routeLimit and consumerLimit bound RouteLabel and ConsumerLabel
+var routeLimit, consumerLimit *LabelLimit
+
+// SetLabelLimits bounds the values of RouteLabel and ConsumerLabel. It must be
+// called before requests are served; nil leaves a label unbounded.
+func SetLabelLimits(routes, consumers *LabelLimit) {
+	routeLimit, consumerLimit = routes, consumers
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metric families and renders them in the Prometheus text format
+type Registry struct {
+	mu       sync.RWMutex
+	families []family
+}
+
+// family is a metric family that can render itself
+type family interface {
+	write(w io.Writer)
+	gather() []Series
+}
+
+// NewRegistry creates a new metrics registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec creates and registers a counter with the given labels
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labels)}
+	reg.register(c)
+	return c
+}
+
+// NewGaugeVec creates and registers a gauge with the given labels
+func (reg *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", labels)}
+	reg.register(g)
+	return g
+}
+
+// register adds a family to the registry
+func (reg *Registry) register(f family) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.families = append(reg.families, f)
+}
+
+// Handler returns an HTTP handler exposing all metrics
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
+
+// Write renders all metrics in the Prometheus text format
+func (reg *Registry) Write(w io.Writer) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	for _, f := range reg.families {
+		f.write(w)
+	}
+}
+
+// Series is the current value of one labeled series
+type Series struct {
+	Name   string
+	Kind   string   // "counter" or "gauge"
+	Labels []string // Label names
+	Values []string // Label values, in the order of Labels
+	Value  float64
+}
+
+// Gather returns every series of every family
+func (reg *Registry) Gather() []Series {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	var series []Series
+	for _, f := range reg.families {
+		series = append(series, f.gather()...)
+	}
+	return series
+}
+
+// vec stores labeled float values for a metric family
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.RWMutex
+	values map[string]*float64 // joined label values -> value
+}
+
+// labelSeparator joins label values into a map key
+const labelSeparator = "\xff"
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]*float64),
+	}
+}
+
+// keyBufferSize is the stack buffer keys are built in; longer keys spill to the heap
+const keyBufferSize = 128
+
+// appendKey appends the map key for a set of label values to buf
+func (v *vec) appendKey(buf []byte, labelValues []string) []byte {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	for i, value := range labelValues {
+		if i > 0 {
+			buf = append(buf, labelSeparator...)
+		}
+		buf = append(buf, value...)
+	}
+	return buf
+}
+
+// series returns the value of the series with the key, creating it if needed;
+// the caller must hold the write lock. Looking up an existing series with a
+// key converted from bytes does not allocate.
+func (v *vec) series(key []byte) *float64 {
+	value := v.values[string(key)]
+	if value == nil {
+		value = new(float64)
+		v.values[string(key)] = value
+	}
+	return value
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	var buf [keyBufferSize]byte
+	key := v.appendKey(buf[:0], labelValues)
+	v.mu.Lock()
+	*v.series(key) += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	var buf [keyBufferSize]byte
+	key := v.appendKey(buf[:0], labelValues)
+	v.mu.Lock()
+	*v.series(key) = value
+	v.mu.Unlock()
+}
+
+// Snapshot returns the current values keyed by their label values
+func (v *vec) Snapshot() map[string]float64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	snapshot := make(map[string]float64, len(v.values))
+	for key, value := range v.values {
+		snapshot[key] = *value
+	}
+	return snapshot
+}
+
+// write renders the family in the Prometheus text format
+func (v *vec) write(w io.Writer) {
+	snapshot := v.Snapshot()
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.formatLabels(key), strconv.FormatFloat(snapshot[key], 'g', -1, 64))
+	}
+}
+
+// gather returns the family's series
+func (v *vec) gather() []Series {
+	snapshot := v.Snapshot()
+	series := make([]Series, 0, len(snapshot))
+	for key, value := range snapshot {
+		var values []string
+		if len(v.labels) > 0 {
+			values = SplitKey(key)
+		}
+		series = append(series, Series{Name: v.name, Kind: v.kind, Labels: v.labels, Values: values, Value: value})
+	}
+	return series
+}
+
+// formatLabels renders the label set for a key
+func (v *vec) formatLabels(key string) string {
+	if len(v.labels) == 0 {
+		return ""
+	}
+
+	values := strings.Split(key, labelSeparator)
+	pairs := make([]string, len(v.labels))
+	for i, label := range v.labels {
+		pairs[i] = label + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue escapes a label value for the text format
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// SplitKey splits a snapshot key back into its label values
+func SplitKey(key string) []string {
+	return strings.Split(key, labelSeparator)
+}
+
+// CounterVec is a monotonically increasing labeled metric
+type CounterVec struct {
+	*vec
+}
+
+// Add increments the counter for the label values by delta
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.add(delta, labelValues)
+}
+
+// Inc increments the counter for the label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// GaugeVec is a labeled metric that can go up and down
+type GaugeVec struct {
+	*vec
+}
+
+// Set sets the gauge for the label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add adds delta to the gauge for the label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
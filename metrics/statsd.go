@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/httputil"
+)
+
+// StatsDConfig represents StatsD/DogStatsD export configuration
+type StatsDConfig struct {
+	Network       string            // "udp" or "unixgram"
+	Address       string            // Agent address, e.g. localhost:8125
+	DogStatsD     bool              // Send labels as DogStatsD tags instead of name segments
+	Prefix        string            // Prepended to every metric name
+	Tags          map[string]string // Added to every metric (DogStatsD only)
+	FlushInterval time.Duration
+	SampleRate    float64 // Share of requests whose duration is sent, in (0, 1]
+	MaxPacketSize int     // Bytes per datagram
+}
+
+// StatsDExporter pushes the registry's metrics to a StatsD agent every flush
+// interval: counters as the increase since the last flush, gauges as their
+// current value. It also sends sampled request durations as timers.
+type StatsDExporter struct {
+	config *StatsDConfig
+	reg    *Registry
+	conn   net.Conn
+	tags   string // Rendered constant tags
+
+	mu      sync.Mutex
+	pending [][]byte           // Sampled timer lines waiting for the next flush
+	sent    map[string]float64 // Counter values as of the last flush
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// maxPendingTimers bounds the timer lines buffered between flushes
+const maxPendingTimers = 10000
+
+// NewStatsDExporter creates an exporter and starts flushing to the agent
+func NewStatsDExporter(config *StatsDConfig, reg *Registry) (*StatsDExporter, error) {
+	conn, err := net.Dial(config.Network, config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD agent: %w", err)
+	}
+
+	e := &StatsDExporter{
+		config: config,
+		reg:    reg,
+		conn:   conn,
+		sent:   make(map[string]float64),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if config.DogStatsD {
+		keys := make([]string, 0, len(config.Tags))
+		for key := range config.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		tags := make([]string, 0, len(keys))
+		for _, key := range keys {
+			tags = append(tags, tagValue(key)+":"+tagValue(config.Tags[key]))
+		}
+		e.tags = strings.Join(tags, ",")
+	}
+
+	go e.flushRoutine()
+	return e, nil
+}
+
+// Middleware returns middleware that sends the duration of sampled requests
+// as gateway_request_duration_ms, by route, method and status
+func (e *StatsDExporter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if e.config.SampleRate < 1 && rand.Float64() >= e.config.SampleRate {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			sw := httputil.NewStatusWriter(w)
+			next.ServeHTTP(sw, r)
+
+			ms := strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', -1, 64)
+			suffix := "|ms"
+			if e.config.SampleRate < 1 {
+				suffix += "|@" + strconv.FormatFloat(e.config.SampleRate, 'f', -1, 64)
+			}
+			line := e.line("gateway_request_duration_ms",
+				[]string{"route", "method", "status"},
+				[]string{RouteLabel(r), r.Method, strconv.Itoa(sw.StatusCode())},
+				ms, suffix)
+
+			e.mu.Lock()
+			if len(e.pending) < maxPendingTimers {
+				e.pending = append(e.pending, line)
+			}
+			e.mu.Unlock()
+		})
+	}
+}
+
+// Close sends a final flush and stops the exporter
+func (e *StatsDExporter) Close() {
+	close(e.stop)
+	<-e.done
+	e.conn.Close()
+}
+
+// flushRoutine flushes every flush interval until the exporter is closed
+func (e *StatsDExporter) flushRoutine() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	var lastError time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.stop:
+			e.flush()
+			return
+		}
+		if err := e.flush(); err != nil && time.Since(lastError) > time.Minute {
+			// Log failures at most once a minute
+			log.Printf("StatsD: failed to send metrics: %v", err)
+			lastError = time.Now()
+		}
+	}
+}
+
+// flush sends the registry's series and the pending timers
+func (e *StatsDExporter) flush() error {
+	e.mu.Lock()
+	lines := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+
+	for _, s := range e.reg.Gather() {
+		value := s.Value
+		suffix := "|g"
+		if s.Kind == "counter" {
+			key := s.Name + labelSeparator + strings.Join(s.Values, labelSeparator)
+			value -= e.sent[key]
+			e.sent[key] = s.Value
+			if value <= 0 {
+				continue
+			}
+			suffix = "|c"
+		}
+		lines = append(lines, e.line(s.Name, s.Labels, s.Values, strconv.FormatFloat(value, 'f', -1, 64), suffix))
+	}
+
+	// Pack lines into datagrams no larger than the maximum packet size
+	var firstErr error
+	var packet bytes.Buffer
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(packet.Bytes()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > e.config.MaxPacketSize {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.Write(line)
+	}
+	send()
+	return firstErr
+}
+
+// line renders one metric line. DogStatsD carries labels as tags; plain
+// StatsD appends the label values to the name.
+func (e *StatsDExporter) line(name string, labels, values []string, value, suffix string) []byte {
+	var b strings.Builder
+	b.WriteString(e.config.Prefix)
+	b.WriteString(name)
+	if !e.config.DogStatsD {
+		for _, v := range values {
+			b.WriteByte('.')
+			b.WriteString(nameSegment(v))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteString(suffix)
+
+	if e.config.DogStatsD && (e.tags != "" || len(labels) > 0) {
+		b.WriteString("|#")
+		b.WriteString(e.tags)
+		for i, label := range labels {
+			if i > 0 || e.tags != "" {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteByte(':')
+			b.WriteString(tagValue(values[i]))
+		}
+	}
+	return []byte(b.String())
+}
+
+// tagValue replaces the characters that delimit DogStatsD tags
+func tagValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// nameSegment reduces a label value to characters safe in a StatsD name
+func nameSegment(value string) string {
+	segment := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.Trim(value, "/"))
+	if segment == "" {
+		return "_"
+	}
+	return segment
+}
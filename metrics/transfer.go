@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+
+	"github.com/gorilla/mux"
+)
+
+// TransferMetrics tracks request and response body sizes per route and consumer
+type TransferMetrics struct {
+	requests      *CounterVec
+	requestBytes  *CounterVec
+	responseBytes *CounterVec
+}
+
+// NewTransferMetrics creates transfer metrics registered with the registry
+func NewTransferMetrics(reg *Registry) *TransferMetrics {
+	return &TransferMetrics{
+		requests: reg.NewCounterVec("gateway_transfer_requests_total",
+			"Requests observed by transfer accounting", "route", "consumer"),
+		requestBytes: reg.NewCounterVec("gateway_request_bytes_total",
+			"Request body bytes received from clients", "route", "consumer"),
+		responseBytes: reg.NewCounterVec("gateway_response_bytes_total",
+			"Response body bytes sent to clients", "route", "consumer"),
+	}
+}
+
+// Middleware returns middleware that counts bytes in and out of each request
+func (tm *TransferMetrics) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = auth.WithIdentitySlot(r)
+
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			cw := countingWriters.Get().(*httputil.StatusWriter)
+			cw.ResponseWriter = w
+
+			next.ServeHTTP(cw, r)
+
+			route := RouteLabel(r)
+			consumer := ConsumerLabel(r)
+			tm.requests.Inc(route, consumer)
+			tm.requestBytes.Add(float64(body.n), route, consumer)
+			tm.responseBytes.Add(float64(cw.Written), route, consumer)
+
+			*cw = httputil.StatusWriter{}
+			countingWriters.Put(cw)
+		})
+	}
+}
+
+// TransferSummary represents aggregated transfer totals for one dimension value
+type TransferSummary struct {
+	Requests      int64 `json:"requests"`
+	RequestBytes  int64 `json:"request_bytes"`
+	ResponseBytes int64 `json:"response_bytes"`
+}
+
+// Summary aggregates transfer totals by route and by consumer
+func (tm *TransferMetrics) Summary() map[string]map[string]*TransferSummary {
+	byRoute := make(map[string]*TransferSummary)
+	byConsumer := make(map[string]*TransferSummary)
+
+	entry := func(m map[string]*TransferSummary, key string) *TransferSummary {
+		if m[key] == nil {
+			m[key] = &TransferSummary{}
+		}
+		return m[key]
+	}
+
+	for key, value := range tm.requests.Snapshot() {
+		labels := SplitKey(key)
+		entry(byRoute, labels[0]).Requests += int64(value)
+		entry(byConsumer, labels[1]).Requests += int64(value)
+	}
+	for key, value := range tm.requestBytes.Snapshot() {
+		labels := SplitKey(key)
+		entry(byRoute, labels[0]).RequestBytes += int64(value)
+		entry(byConsumer, labels[1]).RequestBytes += int64(value)
+	}
+	for key, value := range tm.responseBytes.Snapshot() {
+		labels := SplitKey(key)
+		entry(byRoute, labels[0]).ResponseBytes += int64(value)
+		entry(byConsumer, labels[1]).ResponseBytes += int64(value)
+	}
+
+	return map[string]map[string]*TransferSummary{
+		"routes":    byRoute,
+		"consumers": byConsumer,
+	}
+}
+
+// RouteLabel returns the matched route template rather than the raw path,
+// bounded by the route label limit
+func RouteLabel(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return routeLimit.Value(template)
+		}
+	}
+	return "unmatched"
+}
+
+// ConsumerLabel identifies the authenticated consumer of a request, bounded
+// by the consumer label limit
+func ConsumerLabel(r *http.Request) string {
+	return consumerLimit.Value(Consumer(r))
+}
+
+// Consumer identifies the authenticated consumer of a request
+func Consumer(r *http.Request) string {
+	userCtx := auth.GetResolvedIdentity(r)
+	if userCtx == nil {
+		return "anonymous"
+	}
+	return userCtx.AuthType + ":" + userCtx.UserID
+}
+
+// countingReader counts bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingWriters recycles the writers counting response bytes, which every
+// request needs
+var countingWriters = sync.Pool{
+	New: func() any { return new(httputil.StatusWriter) },
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransferWritersNotSharedWhileHeld(t *testing.T) {
+	mw := NewTransferMetrics(NewRegistry()).Middleware()
+	serve := func(handler http.HandlerFunc) {
+		mw(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	// A handler still running keeps its writer
+	held := make(chan http.ResponseWriter)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			held <- w
+			<-release
+		})
+	}()
+	holding := <-held
+	for i := 0; i < 100; i++ {
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			if w == holding {
+				t.Fatal("writer handed out while its handler still runs")
+			}
+		})
+	}
+	close(release)
+	<-done
+
+	// A panicking handler may have leaked its writer, so it is not put back
+	var panicked http.ResponseWriter
+	func() {
+		defer func() { recover() }()
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			panicked = w
+			panic("handler failed")
+		})
+	}()
+	for i := 0; i < 100; i++ {
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			if w == panicked {
+				t.Fatal("writer of a panicked handler was reused")
+			}
+		})
+	}
+}
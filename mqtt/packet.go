@@ -0,0 +1,347 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Control packet types of MQTT 3.1.1
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typePubrec      = 5
+	typePubrel      = 6
+	typePubcomp     = 7
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	connackAccepted           = 0
+	connackBadProtocol        = 1
+	connackIdentifierRejected = 2
+	connackUnavailable        = 3
+	connackNotAuthorized      = 5
+)
+
+var (
+	errTooLarge         = errors.New("packet exceeds the maximum message size")
+	errMalformed        = errors.New("malformed packet")
+	errProtocolVersion  = errors.New("unsupported protocol version")
+	errInvalidTopicName = errors.New("invalid topic name")
+)
+
+// bodyChunk is the buffer first allocated for a packet body
+const bodyChunk = 4 << 10
+
+// packet is a control packet with its fixed header split off
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one control packet, refusing bodies over maxSize bytes
+func readPacket(reader *bufio.Reader, maxSize int) (*packet, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	// The remaining length is encoded in up to four bytes, seven bits each
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMalformed
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxSize {
+		return nil, errTooLarge
+	}
+	// The body grows as it arrives, so a client announcing a large packet
+	// cannot make the server allocate it without sending it
+	var body bytes.Buffer
+	body.Grow(min(length, bodyChunk))
+	if _, err := io.CopyN(&body, reader, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &packet{kind: first >> 4, flags: first & 0x0f, body: body.Bytes()}, nil
+}
+
+// encodePacket builds a control packet from its type, flags and body
+func encodePacket(kind, flags byte, body []byte) []byte {
+	encoded := []byte{kind<<4 | flags}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		encoded = append(encoded, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(encoded, body...)
+}
+
+// encodeAck builds a PUBACK, PUBREC, PUBCOMP or UNSUBACK for a packet ID
+func encodeAck(kind byte, id uint16) []byte {
+	return encodePacket(kind, 0, binary.BigEndian.AppendUint16(nil, id))
+}
+
+// decoder reads the fields of a packet body
+type decoder struct {
+	body []byte
+	err  error
+}
+
+// uint16 reads a two-byte integer
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.body) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	value := binary.BigEndian.Uint16(d.body)
+	d.body = d.body[2:]
+	return value
+}
+
+// byte reads a single byte
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.body) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	value := d.body[0]
+	d.body = d.body[1:]
+	return value
+}
+
+// binary reads length-prefixed data
+func (d *decoder) binary() []byte {
+	length := int(d.uint16())
+	if d.err != nil || len(d.body) < length {
+		d.err = errMalformed
+		return nil
+	}
+	value := d.body[:length]
+	d.body = d.body[length:]
+	return value
+}
+
+// string reads a length-prefixed UTF-8 string
+func (d *decoder) string() string {
+	value := d.binary()
+	if d.err == nil && (!utf8.Valid(value) || strings.ContainsRune(string(value), 0)) {
+		d.err = errMalformed
+	}
+	return string(value)
+}
+
+// connect is a decoded CONNECT packet
+type connect struct {
+	clientID     string
+	cleanSession bool
+	keepAlive    uint16 // Seconds; 0 disables keep alive
+	will         *message
+	username     string
+	password     []byte
+}
+
+// message is a published application message
+type message struct {
+	topic   string
+	qos     byte
+	id      uint16 // Packet ID of QoS 1 and 2 messages
+	payload []byte
+}
+
+// parseConnect decodes a CONNECT packet. Only protocol level 4, MQTT 3.1.1,
+// is accepted.
+func parseConnect(p *packet) (*connect, error) {
+	d := &decoder{body: p.body}
+	name := d.string()
+	level := d.byte()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if name != "MQTT" || level != 4 {
+		return nil, errProtocolVersion
+	}
+	flags := d.byte()
+	c := &connect{
+		cleanSession: flags&0x02 != 0,
+		keepAlive:    d.uint16(),
+	}
+	c.clientID = d.string()
+	if flags&0x04 != 0 {
+		c.will = &message{qos: flags >> 3 & 0x03}
+		c.will.topic = d.string()
+		c.will.payload = d.binary()
+		if d.err == nil && validateTopicName(c.will.topic) != nil {
+			return nil, errInvalidTopicName
+		}
+	}
+	if flags&0x80 != 0 {
+		c.username = d.string()
+	}
+	if flags&0x40 != 0 {
+		c.password = d.binary()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	// The reserved flag must be clear, a will needs a valid QoS and its
+	// QoS and retain flags need a will, and a password needs a username
+	if flags&0x01 != 0 || (c.will != nil && c.will.qos > 2) || (c.will == nil && flags&0x38 != 0) ||
+		(flags&0x40 != 0 && flags&0x80 == 0) {
+		return nil, errMalformed
+	}
+	return c, nil
+}
+
+// parsePublish decodes a PUBLISH packet
+func parsePublish(p *packet) (*message, error) {
+	m := &message{qos: p.flags >> 1 & 0x03}
+	if m.qos > 2 {
+		return nil, errMalformed
+	}
+	d := &decoder{body: p.body}
+	m.topic = d.string()
+	if m.qos > 0 {
+		m.id = d.uint16()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := validateTopicName(m.topic); err != nil {
+		return nil, err
+	}
+	m.payload = d.body
+	return m, nil
+}
+
+// parseFilters decodes the packet ID and topic filters of a SUBSCRIBE or
+// UNSUBSCRIBE packet, returning the number of filters
+func parseFilters(p *packet) (uint16, int, error) {
+	if p.flags != 0x02 {
+		return 0, 0, errMalformed
+	}
+	d := &decoder{body: p.body}
+	id := d.uint16()
+	count := 0
+	for d.err == nil && len(d.body) > 0 {
+		if validateTopicFilter(d.string()) != nil {
+			return 0, 0, errMalformed
+		}
+		// The requested QoS has its upper six bits reserved
+		if p.kind == typeSubscribe && d.byte() > 2 {
+			return 0, 0, errMalformed
+		}
+		count++
+	}
+	if d.err != nil || count == 0 {
+		return 0, 0, errMalformed
+	}
+	return id, count, nil
+}
+
+// validateTopicName checks the topic of a published message, which cannot
+// contain wildcards
+func validateTopicName(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("%w %q", errInvalidTopicName, topic)
+	}
+	return nil
+}
+
+// validateTopicFilter checks a topic filter, whose "+" wildcards must fill a
+// whole level and whose "#" wildcard must be the last level
+func validateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("empty topic filter")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return fmt.Errorf("wildcards must fill a whole level of %q", filter)
+		}
+		if level == "#" && i != len(levels)-1 {
+			return fmt.Errorf("# must be the last level of %q", filter)
+		}
+	}
+	return nil
+}
+
+// matchTopic reports whether a topic filter matches a topic name. Wildcards
+// in the first level do not match topics starting with "$", which are
+// reserved for the server.
+func matchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// moreSpecific orders topic filters so that the first one matching a topic
+// is the most specific: level by level, names come before "+" and "+"
+// before "#", and a filter ending where another continues comes first
+func moreSpecific(a, b string) bool {
+	aLevels := strings.Split(a, "/")
+	bLevels := strings.Split(b, "/")
+	rank := func(level string) int {
+		switch level {
+		case "#":
+			return 2
+		case "+":
+			return 1
+		}
+		return 0
+	}
+	for i := 0; i < len(aLevels) && i < len(bLevels); i++ {
+		if ra, rb := rank(aLevels[i]), rank(bLevels[i]); ra != rb {
+			return ra < rb
+		}
+		if aLevels[i] != bLevels[i] {
+			return aLevels[i] < bLevels[i]
+		}
+	}
+	return len(aLevels) < len(bLevels)
+}
@@ -0,0 +1,289 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// str encodes a length-prefixed string
+func str(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// connectBody builds a CONNECT body with the given flags and payload fields
+func connectBody(flags byte, fields ...string) []byte {
+	body := append(str("MQTT"), 4, flags, 0, 60)
+	for _, field := range fields {
+		body = append(body, str(field)...)
+	}
+	return body
+}
+
+func reader(b []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(b))
+}
+
+func TestReadPacket(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		kind  byte
+		body  int
+		err   error
+	}{
+		{"empty body", []byte{0xc0, 0x00}, typePingreq, 0, nil},
+		{"one byte length", append([]byte{0x30, 0x05}, "hello"...), typePublish, 5, nil},
+		{"two byte length", append([]byte{0x30, 0x80, 0x01}, make([]byte, 128)...), typePublish, 128, nil},
+		{"four byte length", []byte{0x30, 0xff, 0xff, 0xff, 0x7f}, 0, 0, errTooLarge},
+		{"five byte length", []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}, 0, 0, errMalformed},
+		{"over the maximum", append([]byte{0x30, 0x81, 0x08}, make([]byte, 1025)...), 0, 0, errTooLarge},
+		{"truncated length", []byte{0x30, 0x80}, 0, 0, io.EOF},
+		{"truncated body", append([]byte{0x30, 0x0a}, "short"...), 0, 0, io.ErrUnexpectedEOF},
+		{"no body", []byte{0x30, 0x0a}, 0, 0, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := readPacket(reader(tt.input), 1024)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && (p.kind != tt.kind || len(p.body) != tt.body) {
+				t.Errorf("packet type %d with %d bytes, want type %d with %d", p.kind, len(p.body), tt.kind, tt.body)
+			}
+		})
+	}
+}
+
+// TestReadPacketAllocatesWhatArrives announces the largest packet MQTT can
+// encode without sending it: the server must not allocate it up front
+func TestReadPacketAllocatesWhatArrives(t *testing.T) {
+	input := append([]byte{0x30, 0xff, 0xff, 0xff, 0x7f}, make([]byte, 100)...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readPacket(reader(input), 1<<28)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes for 100 received", allocated)
+	}
+}
+
+func TestParseConnect(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		err  error
+	}{
+		{"minimal", connectBody(0x02, "device-1"), nil},
+		{"credentials", connectBody(0xc2, "device-1", "user", "secret"), nil},
+		{"will at QoS 2", connectBody(0x14, "device-1", "status/device-1", "offline"), nil},
+		{"MQTT 3.1", append(str("MQIsdp"), 3, 0x02, 0, 60), errProtocolVersion},
+		{"MQTT 5", append(str("MQTT"), 5, 0x02, 0, 60), errProtocolVersion},
+		{"reserved flag", connectBody(0x03, "device-1"), errMalformed},
+		{"will QoS 3", connectBody(0x1c, "device-1", "status", "offline"), errMalformed},
+		{"will QoS without will", connectBody(0x08, "device-1"), errMalformed},
+		{"will retain without will", connectBody(0x20, "device-1"), errMalformed},
+		{"password without username", append(connectBody(0x40, "device-1"), str("secret")...), errMalformed},
+		{"wildcard will topic", connectBody(0x04, "device-1", "status/#", "offline"), errInvalidTopicName},
+		{"NUL in will topic", connectBody(0x04, "device-1", "status\x00", "offline"), errMalformed},
+		{"truncated client ID", connectBody(0x02, "device-1")[:14], errMalformed},
+		{"missing username", connectBody(0x80, "device-1"), errMalformed},
+		{"invalid UTF-8 client ID", connectBody(0x02, "\xff\xfe"), errMalformed},
+		{"no keep alive", append(str("MQTT"), 4, 0x02), errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConnect(&packet{kind: typeConnect, body: tt.body})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	c, err := parseConnect(&packet{kind: typeConnect, body: connectBody(0xd6, "device-1", "status", "offline", "user", "secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.clientID != "device-1" || !c.cleanSession || c.keepAlive != 60 || c.username != "user" || string(c.password) != "secret" ||
+		c.will == nil || c.will.topic != "status" || c.will.qos != 2 || string(c.will.payload) != "offline" {
+		t.Errorf("unexpected CONNECT %+v, will %+v", c, c.will)
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags byte
+		body  []byte
+		err   error
+	}{
+		{"QoS 0", 0x00, append(str("devices/1/temp"), "21.5"...), nil},
+		{"QoS 1", 0x02, append(append(str("devices/1/temp"), 0, 7), "21.5"...), nil},
+		{"QoS 3", 0x06, append(append(str("devices/1/temp"), 0, 7), "21.5"...), errMalformed},
+		{"wildcard topic", 0x00, str("devices/+/temp"), errInvalidTopicName},
+		{"empty topic", 0x00, str(""), errInvalidTopicName},
+		{"NUL in topic", 0x00, str("devices/\x00"), errMalformed},
+		{"truncated topic", 0x00, str("devices/1/temp")[:5], errMalformed},
+		{"missing packet ID", 0x02, str("devices/1/temp"), errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePublish(&packet{kind: typePublish, flags: tt.flags, body: tt.body})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	subscribe := func(filters ...string) []byte {
+		body := []byte{0, 9}
+		for _, filter := range filters {
+			body = append(append(body, str(filter)...), 1)
+		}
+		return body
+	}
+	tests := []struct {
+		name  string
+		kind  byte
+		flags byte
+		body  []byte
+		count int
+		err   error
+	}{
+		{"subscribe", typeSubscribe, 0x02, subscribe("devices/+/temp", "alerts/#"), 2, nil},
+		{"unsubscribe", typeUnsubscribe, 0x02, append([]byte{0, 9}, str("alerts/#")...), 1, nil},
+		{"flags 0", typeSubscribe, 0x00, subscribe("alerts/#"), 0, errMalformed},
+		{"flags 3", typeSubscribe, 0x03, subscribe("alerts/#"), 0, errMalformed},
+		{"no filters", typeSubscribe, 0x02, []byte{0, 9}, 0, errMalformed},
+		{"requested QoS 3", typeSubscribe, 0x02, append(append([]byte{0, 9}, str("alerts/#")...), 3), 0, errMalformed},
+		{"reserved QoS bits", typeSubscribe, 0x02, append(append([]byte{0, 9}, str("alerts/#")...), 0x81), 0, errMalformed},
+		{"missing QoS", typeSubscribe, 0x02, append([]byte{0, 9}, str("alerts/#")...), 0, errMalformed},
+		{"partial wildcard", typeSubscribe, 0x02, subscribe("alerts/a#"), 0, errMalformed},
+		{"NUL in filter", typeSubscribe, 0x02, subscribe("alerts/\x00"), 0, errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, count, err := parseFilters(&packet{kind: tt.kind, flags: tt.flags, body: tt.body})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && (id != 9 || count != tt.count) {
+				t.Errorf("id %d with %d filters, want 9 with %d", id, count, tt.count)
+			}
+		})
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"devices/+/temp", "devices/1/temp", true},
+		{"devices/+/temp", "devices/1/2/temp", false},
+		{"devices/#", "devices", true},
+		{"devices/#", "devices/1/temp", true},
+		{"devices/+", "devices", false},
+		{"#", "devices/1", true},
+		{"+/+", "/finance", true},
+		{"#", "$SYS/broker/uptime", false},
+		{"+/broker/uptime", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+		{"$SYS/+/uptime", "$SYS/broker/uptime", true},
+		{"devices/#", "$SYS/devices", false},
+	}
+	for _, tt := range tests {
+		if got := matchTopic(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	filters := []string{"#", "devices/#", "$SYS/#", "devices/+/temp", "devices/1/temp", "+/1/temp", "devices/+", "$SYS/broker/+"}
+	sort.Slice(filters, func(i, j int) bool { return moreSpecific(filters[i], filters[j]) })
+	want := "$SYS/broker/+ $SYS/# devices/1/temp devices/+ devices/+/temp devices/# +/1/temp #"
+	if got := strings.Join(filters, " "); got != want {
+		t.Errorf("order %s, want %s", got, want)
+	}
+
+	// The first filter matching a topic is the most specific one
+	first := func(topic string) string {
+		for _, filter := range filters {
+			if matchTopic(filter, topic) {
+				return filter
+			}
+		}
+		return ""
+	}
+	for topic, want := range map[string]string{
+		"devices/1/temp":     "devices/1/temp",
+		"devices/2/temp":     "devices/+/temp",
+		"devices/2":          "devices/+",
+		"$SYS/broker/uptime": "$SYS/broker/+",
+		"$SYS/clients":       "$SYS/#",
+		"other/1/temp":       "+/1/temp",
+	} {
+		if got := first(topic); got != want {
+			t.Errorf("%s first matches %s, want %s", topic, got, want)
+		}
+	}
+}
+
+func FuzzReadPacket(f *testing.F) {
+	f.Add([]byte{0xc0, 0x00})
+	f.Add(append([]byte{0x30, 0x05}, "hello"...))
+	f.Add([]byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01})
+	f.Add([]byte{0x30, 0xff, 0xff, 0xff, 0x7f})
+	f.Add(append([]byte{0x10, 0x10}, connectBody(0x02, "device")...))
+	f.Fuzz(func(t *testing.T, input []byte) {
+		p, err := readPacket(reader(input), 1024)
+		if err != nil {
+			return
+		}
+		if len(p.body) > 1024 {
+			t.Fatalf("read a %d byte body over the 1024 byte limit", len(p.body))
+		}
+		// A packet read back re-encodes to the bytes it was read from, less
+		// any non-minimal length encoding
+		encoded := encodePacket(p.kind, p.flags, p.body)
+		again, err := readPacket(reader(encoded), 1024)
+		if err != nil || again.kind != p.kind || again.flags != p.flags || !bytes.Equal(again.body, p.body) {
+			t.Fatalf("packet does not round-trip: %v", err)
+		}
+	})
+}
+
+func FuzzParseConnect(f *testing.F) {
+	f.Add(connectBody(0x02, "device-1"))
+	f.Add(connectBody(0xd6, "device-1", "status", "offline", "user", "secret"))
+	f.Add(connectBody(0x1c, "device-1", "status", "offline"))
+	f.Add(connectBody(0x44, "device-1", "status/#", "offline", "secret"))
+	f.Add(append(str("MQTT"), 5))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		c, err := parseConnect(&packet{kind: typeConnect, body: body})
+		if err != nil {
+			return
+		}
+		if c.will != nil && (c.will.qos > 2 || validateTopicName(c.will.topic) != nil) {
+			t.Fatalf("accepted will %+v", c.will)
+		}
+		if c.password != nil && c.username == "" && len(body) > 7 && body[7]&0x80 == 0 {
+			t.Fatal("accepted a password without a username")
+		}
+		if strings.ContainsRune(c.clientID, 0) || strings.ContainsRune(c.username, 0) {
+			t.Fatal("accepted a NUL in a string")
+		}
+	})
+}
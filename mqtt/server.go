@@ -0,0 +1,520 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// writeTimeout bounds writing a packet to a device
+const writeTimeout = 10 * time.Second
+
+// maxConnectSize bounds CONNECT packets, which carry tokens and wills and
+// are not limited by the maximum message size
+const maxConnectSize = 64 << 10
+
+// Authenticator checks the credentials a device connects with, or the
+// client certificate of connections over TLS, whose state is nil otherwise.
+// It returns the device's identity, which rate limits apply to, and the
+// request header presenting the credentials with every message bridged for
+// the device; an empty identity refuses the connection.
+type Authenticator func(username string, password []byte, state *tls.ConnectionState) (device string, header http.Header)
+
+// Limiter reports whether a device may publish another message
+type Limiter func(ctx context.Context, device string) (bool, error)
+
+// Config represents how the server bridges messages
+type Config struct {
+	Routes         map[string]string // Topic filter -> gateway path messages are posted to
+	ContentType    string            // Content-Type of bridged messages
+	MaxMessageSize int
+	MaxConnections int
+	ConnectTimeout time.Duration
+	MessageTimeout time.Duration
+}
+
+// Server accepts MQTT 3.1.1 connections from devices and bridges the
+// messages they publish to the gateway's routes. Each message is posted to
+// the path of the most specific route matching its topic, with the device's
+// credentials, so it is authenticated, authorized and proxied like any
+// other request, whether the upstream is an HTTP service or a broker.
+//
+// Messages are delivered in order, one at a time per connection. QoS 1 and
+// 2 messages are acknowledged once the route has accepted them; when it
+// fails or the device is over its rate limit, the connection is closed
+// without acknowledging, so the device publishes them again after
+// reconnecting. QoS 0 messages are dropped instead. Subscriptions are not
+// supported: the server only receives messages.
+type Server struct {
+	config       *Config
+	filters      []string // Keys of config.Routes, most specific first
+	authenticate Authenticator
+	limit        Limiter // nil without rate limits
+	handler      http.Handler
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	sessions map[string]*session // By device and client ID
+	closing  bool
+	wg       sync.WaitGroup
+
+	connects    *metrics.CounterVec
+	connections *metrics.GaugeVec
+	messages    *metrics.CounterVec
+}
+
+// session is an authenticated connection
+type session struct {
+	conn      net.Conn
+	device    string
+	clientID  string
+	header    http.Header
+	tls       *tls.ConnectionState // Presented with bridged messages; nil without TLS
+	keepAlive time.Duration
+	will      *message
+	released  map[uint16]bool // QoS 2 messages delivered whose PUBREL is awaited
+}
+
+// NewServer creates a server bridging messages with the given routes. limit
+// is nil to accept messages at any rate.
+func NewServer(config *Config, authenticate Authenticator, limit Limiter, reg *metrics.Registry) (*Server, error) {
+	filters := make([]string, 0, len(config.Routes))
+	for filter := range config.Routes {
+		if err := validateTopicFilter(filter); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	sort.Slice(filters, func(i, j int) bool {
+		return moreSpecific(filters[i], filters[j])
+	})
+	return &Server{
+		config:       config,
+		filters:      filters,
+		authenticate: authenticate,
+		limit:        limit,
+		conns:        make(map[net.Conn]struct{}),
+		sessions:     make(map[string]*session),
+		connects: reg.NewCounterVec("gateway_mqtt_connects_total",
+			"MQTT connection attempts, by result (accepted, unauthorized, rejected or unavailable).", "result"),
+		connections: reg.NewGaugeVec("gateway_mqtt_connections",
+			"Open MQTT connections."),
+		messages: reg.NewCounterVec("gateway_mqtt_messages_total",
+			"MQTT messages, by route topic filter and result (delivered, rejected, failed, limited or unrouted).", "route", "result"),
+	}, nil
+}
+
+// Serve accepts connections on listener, bridging messages to handler,
+// until the server is shut down
+func (s *Server) Serve(listener net.Listener, handler http.Handler) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.handler = handler
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		full := s.config.MaxConnections > 0 && len(s.conns) > s.config.MaxConnections
+		s.wg.Add(1)
+		s.mu.Unlock()
+		s.connections.Add(1)
+		go s.serveConn(conn, full)
+	}
+}
+
+// Shutdown stops accepting connections and lets each connection finish the
+// message it is delivering until ctx is done, then closes them. Wills are
+// not published for connections closed this way.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		// Unblocks reads; a delivery in progress completes first
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// serveConn authenticates a connection and reads its packets until it closes
+func (s *Server) serveConn(conn net.Conn, full bool) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.connections.Add(-1)
+	}()
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(s.config.ConnectTimeout))
+	p, err := readPacket(reader, maxConnectSize)
+	if err != nil || p.kind != typeConnect {
+		s.connects.Inc("rejected")
+		return
+	}
+	c, err := parseConnect(p)
+	switch {
+	case errors.Is(err, errProtocolVersion):
+		s.connects.Inc("rejected")
+		s.write(conn, encodePacket(typeConnack, 0, []byte{0, connackBadProtocol}))
+		return
+	case err != nil:
+		s.connects.Inc("rejected")
+		return
+	case c.clientID == "" && !c.cleanSession:
+		// Sessions of clients without an ID could never be resumed
+		s.connects.Inc("rejected")
+		s.write(conn, encodePacket(typeConnack, 0, []byte{0, connackIdentifierRejected}))
+		return
+	case full:
+		s.connects.Inc("unavailable")
+		s.write(conn, encodePacket(typeConnack, 0, []byte{0, connackUnavailable}))
+		return
+	}
+
+	var state *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		connState := tlsConn.ConnectionState()
+		state = &connState
+	}
+	device, header := s.authenticate(c.username, c.password, state)
+	if device == "" {
+		s.connects.Inc("unauthorized")
+		s.write(conn, encodePacket(typeConnack, 0, []byte{0, connackNotAuthorized}))
+		return
+	}
+	if c.clientID == "" {
+		b := make([]byte, 12)
+		rand.Read(b)
+		c.clientID = "auto-" + hex.EncodeToString(b)
+	}
+	sess := &session{
+		conn:      conn,
+		device:    device,
+		clientID:  c.clientID,
+		header:    header,
+		tls:       state,
+		keepAlive: time.Duration(c.keepAlive) * time.Second,
+		will:      c.will,
+		released:  make(map[uint16]bool),
+	}
+	if !s.register(sess) {
+		return
+	}
+	defer s.unregister(sess)
+	s.connects.Inc("accepted")
+	// Sessions are not kept between connections
+	if s.write(conn, encodePacket(typeConnack, 0, []byte{0, connackAccepted})) != nil {
+		return
+	}
+
+	if !s.readPackets(sess, reader) && sess.will != nil && !s.shuttingDown() {
+		s.dispatch(sess, sess.will)
+	}
+}
+
+// readPackets handles a session's packets. It returns true when the device
+// disconnected cleanly or the server is shutting down, and false when the
+// connection was lost or closed for an error, in which case the will is
+// published.
+func (s *Server) readPackets(sess *session, reader *bufio.Reader) bool {
+	for {
+		if sess.keepAlive > 0 {
+			// Devices are given one and a half keep alive periods
+			sess.conn.SetReadDeadline(time.Now().Add(sess.keepAlive * 3 / 2))
+		} else {
+			sess.conn.SetReadDeadline(time.Time{})
+		}
+		if s.shuttingDown() {
+			return true
+		}
+		p, err := readPacket(reader, s.config.MaxMessageSize)
+		if err != nil {
+			if errors.Is(err, errTooLarge) {
+				log.Printf("MQTT client %s of %s sent a packet over %d bytes", sess.clientID, sess.device, s.config.MaxMessageSize)
+			}
+			return s.shuttingDown()
+		}
+
+		switch p.kind {
+		case typePublish:
+			if !s.publish(sess, p) {
+				return false
+			}
+		case typePubrel:
+			if p.flags != 0x02 || len(p.body) != 2 {
+				return false
+			}
+			id := uint16(p.body[0])<<8 | uint16(p.body[1])
+			delete(sess.released, id)
+			if s.write(sess.conn, encodeAck(typePubcomp, id)) != nil {
+				return false
+			}
+		case typeSubscribe:
+			id, count, err := parseFilters(p)
+			if err != nil {
+				return false
+			}
+			// Every subscription is refused
+			body := []byte{byte(id >> 8), byte(id)}
+			body = append(body, bytes.Repeat([]byte{0x80}, count)...)
+			if s.write(sess.conn, encodePacket(typeSuback, 0, body)) != nil {
+				return false
+			}
+		case typeUnsubscribe:
+			id, _, err := parseFilters(p)
+			if err != nil {
+				return false
+			}
+			if s.write(sess.conn, encodeAck(typeUnsuback, id)) != nil {
+				return false
+			}
+		case typePingreq:
+			if s.write(sess.conn, encodePacket(typePingresp, 0, nil)) != nil {
+				return false
+			}
+		case typeDisconnect:
+			return true
+		default:
+			// A protocol violation, such as a second CONNECT
+			return false
+		}
+	}
+}
+
+// publish handles a PUBLISH packet, returning false when the connection
+// must be closed
+func (s *Server) publish(sess *session, p *packet) bool {
+	m, err := parsePublish(p)
+	if err != nil {
+		return false
+	}
+	if m.qos == 2 && sess.released[m.id] {
+		// A retransmission of a message already delivered
+		return s.write(sess.conn, encodeAck(typePubrec, m.id)) == nil
+	}
+
+	if !s.dispatch(sess, m) && m.qos > 0 {
+		return false
+	}
+	switch m.qos {
+	case 1:
+		return s.write(sess.conn, encodeAck(typePuback, m.id)) == nil
+	case 2:
+		sess.released[m.id] = true
+		return s.write(sess.conn, encodeAck(typePubrec, m.id)) == nil
+	}
+	return true
+}
+
+// dispatch bridges a message to its route. It returns false when the
+// message should be published again: the device is over its rate limit,
+// or the route failed or stopped accepting the device's credentials.
+// Messages the route rejects are not published again.
+func (s *Server) dispatch(sess *session, m *message) bool {
+	filter, path := s.route(m.topic)
+	if path == "" {
+		s.messages.Inc("", "unrouted")
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.MessageTimeout)
+	defer cancel()
+	if s.limit != nil {
+		allowed, err := s.limit(ctx, sess.device)
+		if err != nil {
+			// Messages are not held up by an unavailable limiter
+			log.Printf("MQTT rate limit check for %s failed: %v", sess.device, err)
+		} else if !allowed {
+			s.messages.Inc(filter, "limited")
+			return false
+		}
+	}
+
+	status := s.deliver(ctx, sess, m, path)
+	switch {
+	case status >= 200 && status < 300:
+		s.messages.Inc(filter, "delivered")
+		return true
+	case status == http.StatusUnauthorized:
+		// The credentials were revoked or expired since the device connected
+		log.Printf("MQTT client %s of %s is no longer authorized; disconnecting", sess.clientID, sess.device)
+		s.messages.Inc(filter, "failed")
+		sess.will = nil
+		sess.conn.Close()
+		return false
+	case status == http.StatusTooManyRequests || status >= 500 || status == 0:
+		s.messages.Inc(filter, "failed")
+		return false
+	default:
+		log.Printf("MQTT message from %s on %s was rejected by %s with status %d", sess.clientID, m.topic, path, status)
+		s.messages.Inc(filter, "rejected")
+		return true
+	}
+}
+
+// deliver posts a message to path, returning the response status, or 0 if
+// the handler panicked
+func (s *Server) deliver(ctx context.Context, sess *session, m *message, path string) (status int) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(m.payload))
+	if err != nil {
+		return 0
+	}
+	req.Host = sess.conn.LocalAddr().String()
+	req.RemoteAddr = sess.conn.RemoteAddr().String()
+	req.TLS = sess.tls
+	for name, values := range sess.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", s.config.ContentType)
+	req.Header.Set("X-MQTT-Topic", m.topic)
+	req.Header.Set("X-MQTT-Client-ID", sess.clientID)
+	req.Header.Set("X-MQTT-QoS", strconv.Itoa(int(m.qos)))
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("MQTT message from %s on %s panicked: %v", sess.clientID, m.topic, recovered)
+			status = 0
+		}
+	}()
+	recorder := &statusRecorder{header: make(http.Header)}
+	s.handler.ServeHTTP(recorder, req)
+	if recorder.status == 0 {
+		return http.StatusOK
+	}
+	return recorder.status
+}
+
+// route returns the most specific route matching a topic and its path
+func (s *Server) route(topic string) (string, string) {
+	for _, filter := range s.filters {
+		if matchTopic(filter, topic) {
+			return filter, s.config.Routes[filter]
+		}
+	}
+	return "", ""
+}
+
+// register adds a session, closing the connection of an earlier session of
+// the same device and client ID. It returns false once shutting down.
+func (s *Server) register(sess *session) bool {
+	key := sess.device + "\x00" + sess.clientID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if previous, ok := s.sessions[key]; ok {
+		previous.conn.Close()
+	}
+	s.sessions[key] = sess
+	return true
+}
+
+// unregister removes a session unless another one has replaced it
+func (s *Server) unregister(sess *session) {
+	key := sess.device + "\x00" + sess.clientID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[key] == sess {
+		delete(s.sessions, key)
+	}
+}
+
+// shuttingDown reports whether Shutdown has been called
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// write sends a packet to a device
+func (s *Server) write(conn net.Conn, packet []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := conn.Write(packet)
+	if err != nil {
+		return fmt.Errorf("failed to write MQTT packet: %w", err)
+	}
+	return nil
+}
+
+// statusRecorder keeps the status of a bridged message's response and
+// discards its body
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *statusRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return len(b), nil
+}
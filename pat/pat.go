@@ -0,0 +1,304 @@
+// Package pat implements personal access tokens: long-lived bearer tokens
+// users mint for scripts and CI, bound to their identity and limited to the
+// scopes they select
+package pat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// Token is a personal access token. Only the hash of its secret is kept.
+type Token struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	UserID    string     `json:"user_id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email,omitempty"`
+	Roles     []string   `json:"roles"` // Roles of the owner when the token was created
+	Scopes    []string   `json:"scopes"`
+	Hash      string     `json:"hash"` // Hex SHA-256 of the whole token
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Nil when the token never expires
+}
+
+// Expired reports whether the token has expired
+func (t *Token) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+var (
+	// ErrNotFound is returned for tokens that do not exist or belong to someone else
+	ErrNotFound = errors.New("personal access token not found")
+	// ErrInvalidToken is returned when authenticating with an unknown, expired or malformed token
+	ErrInvalidToken = errors.New("invalid personal access token")
+	// ErrInvalidName is returned for empty or overlong token names
+	ErrInvalidName = errors.New("token names must be 1-100 characters")
+	// ErrInvalidScope is returned for scopes that are not resource:action, resource:* or *
+	ErrInvalidScope = errors.New("scopes must be resource:action, resource:* or *")
+	// ErrLifetimeTooLong is returned when the requested expiry exceeds the maximum lifetime
+	ErrLifetimeTooLong = errors.New("token lifetime exceeds the maximum")
+	// ErrLimitReached is returned when the user already holds the maximum number of tokens
+	ErrLimitReached = errors.New("personal access token limit reached")
+)
+
+var scopePattern = regexp.MustCompile(`^(\*|[a-z0-9_.-]+:([a-z0-9_.-]+|\*))$`)
+
+// Manager creates, lists, revokes and authenticates personal access tokens.
+// Tokens are cached in memory and reloaded periodically, so revocations made
+// by other replicas sharing the store are picked up.
+type Manager struct {
+	store       Store
+	issuer      string // Issuer of the identities tokens authenticate as
+	maxPerUser  int
+	maxLifetime time.Duration
+
+	mu      sync.RWMutex // Guards the cache
+	writeMu sync.Mutex   // Serializes read-modify-write changes
+	tokens  map[string]*Token
+}
+
+// NewManager loads the tokens from the store and reloads them at the given
+// interval; a zero interval disables reloading. Authenticated tokens carry
+// the gateway's issuer. A zero maxLifetime allows tokens that never expire.
+func NewManager(store Store, issuer string, maxPerUser int, maxLifetime, refresh time.Duration) (*Manager, error) {
+	m := &Manager{
+		store:       store,
+		issuer:      issuer,
+		maxPerUser:  maxPerUser,
+		maxLifetime: maxLifetime,
+	}
+	if err := m.reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go m.refreshRoutine(refresh)
+	}
+
+	return m, nil
+}
+
+// Create mints a token for a user, returning it along with the secret token
+// string, which is not stored and cannot be shown again. A zero lifetime
+// creates a token expiring after the maximum lifetime, if any.
+func (m *Manager) Create(ctx context.Context, user *auth.UserContext, name string, scopes []string, lifetime time.Duration) (*Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, "", ErrInvalidName
+	}
+	scopes = dedupe(scopes)
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	if lifetime == 0 {
+		lifetime = m.maxLifetime
+	}
+	if lifetime < 0 || (m.maxLifetime > 0 && lifetime > m.maxLifetime) {
+		return nil, "", ErrLifetimeTooLong
+	}
+
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	value := auth.PersonalTokenPrefix + id + "_" + secret
+
+	now := time.Now()
+	token := &Token{
+		ID:        id,
+		Name:      name,
+		UserID:    user.UserID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Roles:     append([]string(nil), user.Roles...),
+		Scopes:    scopes,
+		Hash:      hash(value),
+		CreatedAt: now,
+	}
+	if lifetime > 0 {
+		expiresAt := now.Add(lifetime)
+		token.ExpiresAt = &expiresAt
+	}
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	stored, err := m.store.Load(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	held := 0
+	for _, existing := range stored {
+		if existing.UserID == user.UserID && !existing.Expired() {
+			held++
+		}
+	}
+	if held >= m.maxPerUser {
+		return nil, "", ErrLimitReached
+	}
+	if err := m.store.Save(ctx, token); err != nil {
+		return nil, "", err
+	}
+	stored[token.ID] = token
+	m.index(stored)
+	return token, value, nil
+}
+
+// List returns a user's tokens, newest first
+func (m *Manager) List(userID string) []*Token {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var list []*Token
+	for _, token := range m.tokens {
+		if token.UserID == userID {
+			list = append(list, token)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Revoke deletes one of a user's tokens
+func (m *Manager) Revoke(ctx context.Context, userID, id string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	stored, err := m.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	token, exists := stored[id]
+	if !exists || token.UserID != userID {
+		return ErrNotFound
+	}
+	if err := m.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	delete(stored, id)
+	m.index(stored)
+	return nil
+}
+
+// Authenticate implements auth.PersonalTokens. Tokens created on another
+// replica are looked up in the store until the next reload caches them.
+func (m *Manager) Authenticate(ctx context.Context, value string) (*auth.UserContext, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(value, auth.PersonalTokenPrefix), "_")
+	if !ok || !strings.HasPrefix(value, auth.PersonalTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+
+	m.mu.RLock()
+	token, exists := m.tokens[id]
+	m.mu.RUnlock()
+	if !exists {
+		stored, err := m.store.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		token = stored
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash(value)), []byte(token.Hash)) != 1 || token.Expired() {
+		return nil, ErrInvalidToken
+	}
+	return &auth.UserContext{
+		UserID:   token.UserID,
+		Username: token.Username,
+		Email:    token.Email,
+		Roles:    append([]string(nil), token.Roles...),
+		Issuer:   m.issuer,
+		TokenID:  token.ID,
+		Scopes:   append([]string{}, token.Scopes...),
+	}, nil
+}
+
+// reload replaces the cache with the stored tokens
+func (m *Manager) reload(ctx context.Context) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	stored, err := m.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	m.index(stored)
+	return nil
+}
+
+// index replaces the cache
+func (m *Manager) index(tokens map[string]*Token) {
+	m.mu.Lock()
+	m.tokens = tokens
+	m.mu.Unlock()
+}
+
+// refreshRoutine periodically reloads the tokens
+func (m *Manager) refreshRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := m.reload(ctx); err != nil {
+			log.Printf("Failed to reload personal access tokens: %v", err)
+		}
+		cancel()
+	}
+}
+
+// hash returns the hex SHA-256 of a token
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes encoded with encode
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+// dedupe removes empty and repeated values, keeping the first occurrence
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package pat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+)
+
+func newManager(t *testing.T, maxPerUser int) *Manager {
+	m, err := NewManager(NewFileStore(filepath.Join(t.TempDir(), "tokens.json")), "api-gateway", maxPerUser, 24*time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+var alice = &auth.UserContext{UserID: "7", Username: "alice", Roles: []string{"user", "moderator"}}
+
+func TestCreateDenies(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokName  string
+		scopes   []string
+		lifetime time.Duration
+		err      error
+	}{
+		{"valid", "ci", []string{"orders:read", "roles:user"}, time.Hour, nil},
+		{"empty name", "  ", []string{"orders:read"}, time.Hour, ErrInvalidName},
+		{"overlong name", strings.Repeat("n", 101), []string{"orders:read"}, time.Hour, ErrInvalidName},
+		{"no scopes", "ci", nil, time.Hour, ErrInvalidScope},
+		{"only empty scopes", "ci", []string{""}, time.Hour, ErrInvalidScope},
+		{"scope without action", "ci", []string{"orders"}, time.Hour, ErrInvalidScope},
+		{"uppercase scope", "ci", []string{"Orders:read"}, time.Hour, ErrInvalidScope},
+		{"scope with three parts", "ci", []string{"orders:read:all"}, time.Hour, ErrInvalidScope},
+		{"wildcard resource", "ci", []string{"*:read"}, time.Hour, ErrInvalidScope},
+		{"lifetime over the maximum", "ci", []string{"orders:read"}, 25 * time.Hour, ErrLifetimeTooLong},
+		{"negative lifetime", "ci", []string{"orders:read"}, -time.Hour, ErrLifetimeTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newManager(t, 5)
+			token, value, err := m.Create(context.Background(), alice, tt.tokName, tt.scopes, tt.lifetime)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Create error %v, want %v", err, tt.err)
+			}
+			if err != nil && (token != nil || value != "" || len(m.List(alice.UserID)) != 0) {
+				t.Errorf("rejected token was kept: %+v", token)
+			}
+		})
+	}
+}
+
+func TestCreateLimitsTokensPerUser(t *testing.T) {
+	m := newManager(t, 2)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, _, err := m.Create(ctx, alice, "ci", []string{"*"}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := m.Create(ctx, alice, "ci", []string{"*"}, time.Hour); !errors.Is(err, ErrLimitReached) {
+		t.Errorf("third token: %v, want %v", err, ErrLimitReached)
+	}
+	// The limit is per user
+	if _, _, err := m.Create(ctx, &auth.UserContext{UserID: "8", Username: "bob"}, "ci", []string{"*"}, time.Hour); err != nil {
+		t.Errorf("another user's token: %v", err)
+	}
+}
+
+func TestAuthenticateDenies(t *testing.T) {
+	m := newManager(t, 5)
+	ctx := context.Background()
+	token, value, err := m.Create(ctx, alice, "ci", []string{"orders:read"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, revoked, _ := m.Create(ctx, alice, "old", []string{"orders:read"}, time.Hour)
+	revokedID := strings.SplitN(strings.TrimPrefix(revoked, auth.PersonalTokenPrefix), "_", 2)[0]
+	if err := m.Revoke(ctx, "8", revokedID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking another user's token: %v, want %v", err, ErrNotFound)
+	}
+	if err := m.Revoke(ctx, alice.UserID, revokedID); err != nil {
+		t.Fatal(err)
+	}
+	expired, expiredValue, _ := m.Create(ctx, alice, "expired", []string{"orders:read"}, time.Hour)
+	// The manager caches the token it returned
+	past := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &past
+
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"valid", value, true},
+		{"wrong secret", auth.PersonalTokenPrefix + token.ID + "_" + strings.Repeat("A", 43), false},
+		{"unknown ID", auth.PersonalTokenPrefix + "0000000000000000_" + strings.Repeat("A", 43), false},
+		{"missing prefix", strings.TrimPrefix(value, auth.PersonalTokenPrefix), false},
+		{"no secret", auth.PersonalTokenPrefix + token.ID, false},
+		{"revoked", revoked, false},
+		{"expired", expiredValue, false},
+	}
+	for _, tt := range tests {
+		userCtx, err := m.Authenticate(ctx, tt.value)
+		if tt.ok {
+			if err != nil || userCtx.UserID != alice.UserID || userCtx.TokenID != token.ID {
+				t.Errorf("%s: %+v, %v", tt.name, userCtx, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidToken) || userCtx != nil {
+			t.Errorf("%s: %+v, %v; want %v", tt.name, userCtx, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestScopesLimitAccess(t *testing.T) {
+	m := newManager(t, 5)
+	ctx := context.Background()
+	_, value, err := m.Create(ctx, alice, "ci", []string{"orders:read", "roles:user"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := auth.NewJWTManager("secret", "api-gateway", "api-users", time.Hour)
+	partner := auth.NewJWTManager("secret", "partner", "api-users", time.Hour)
+	hooks := &auth.Hooks{Tokens: m, Authorizer: auth.RolePermissions{"user": {"orders:read", "orders:write"}}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		status  int
+	}{
+		{"permission within the scopes", auth.RequireEither(gateway, nil, hooks)(auth.Require("orders:read")(ok)), http.StatusOK},
+		{"permission outside the scopes", auth.RequireEither(gateway, nil, hooks)(auth.Require("orders:write")(ok)), http.StatusForbidden},
+		{"permission the user lacks", auth.RequireEither(gateway, nil, hooks)(auth.Require("users:read")(ok)), http.StatusForbidden},
+		{"role within the scopes", auth.RequireEither(gateway, nil, hooks)(auth.RBACMiddleware("user")(ok)), http.StatusOK},
+		{"role outside the scopes", auth.RequireEither(gateway, nil, hooks)(auth.RBACMiddleware("moderator")(ok)), http.StatusForbidden},
+		{"JWT-only route", auth.RequireJWT(gateway, hooks)(ok), http.StatusUnauthorized},
+		{"route trusting another issuer", auth.RequireEither(partner, nil, hooks)(ok), http.StatusUnauthorized},
+		{"tokens disabled", auth.RequireEither(gateway, nil, &auth.Hooks{})(ok), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+value)
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists personal access tokens
+type Store interface {
+	Load(ctx context.Context) (map[string]*Token, error)
+	// Get returns a token, or ErrNotFound
+	Get(ctx context.Context, id string) (*Token, error)
+	Save(ctx context.Context, token *Token) error
+	Delete(ctx context.Context, id string) error
+}
+
+// FileStore keeps tokens in a JSON file on local disk
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a store backed by the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Load reads every token. A missing file holds no tokens.
+func (s *FileStore) Load(ctx context.Context) (map[string]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Get returns a token
+func (s *FileStore) Get(ctx context.Context, id string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	token, exists := tokens[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return token, nil
+}
+
+// Save creates or replaces a token
+func (s *FileStore) Save(ctx context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+	tokens[token.ID] = token
+	return s.write(tokens)
+}
+
+// Delete removes a token
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(tokens, id)
+	return s.write(tokens)
+}
+
+func (s *FileStore) read() (map[string]*Token, error) {
+	tokens := make(map[string]*Token)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read personal access tokens: %w", err)
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", s.path, err)
+	}
+	return tokens, nil
+}
+
+// write replaces the file atomically so a crash never leaves it truncated.
+// The file holds only token hashes but is still readable by its owner alone.
+func (s *FileStore) write(tokens map[string]*Token) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".personal-tokens-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write personal access tokens: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write personal access tokens: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write personal access tokens: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write personal access tokens: %w", err)
+	}
+	return nil
+}
+
+// redisTokensKey is the hash holding every token, keyed by ID
+const redisTokensKey = "pat:tokens"
+
+// RedisStore keeps tokens in Redis so every replica shares them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed token store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Load reads every token
+func (s *RedisStore) Load(ctx context.Context) (map[string]*Token, error) {
+	values, err := s.client.HGetAll(ctx, redisTokensKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load personal access tokens: %w", err)
+	}
+	tokens := make(map[string]*Token, len(values))
+	for id, value := range values {
+		var token Token
+		if err := json.Unmarshal([]byte(value), &token); err != nil {
+			return nil, fmt.Errorf("failed to decode personal access token %s: %w", id, err)
+		}
+		tokens[id] = &token
+	}
+	return tokens, nil
+}
+
+// Get returns a token
+func (s *RedisStore) Get(ctx context.Context, id string) (*Token, error) {
+	value, err := s.client.HGet(ctx, redisTokensKey, id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get personal access token: %w", err)
+	}
+	var token Token
+	if err := json.Unmarshal(value, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode personal access token %s: %w", id, err)
+	}
+	return &token, nil
+}
+
+// Save creates or replaces a token
+func (s *RedisStore) Save(ctx context.Context, token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, redisTokensKey, token.ID, data).Err(); err != nil {
+		return fmt.Errorf("failed to save personal access token: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a token
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	if err := s.client.HDel(ctx, redisTokensKey, id).Err(); err != nil {
+		return fmt.Errorf("failed to delete personal access token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package penalty
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+)
+
+// Penalty levels, from least to most severe
+const (
+	LevelLimited = "limited" // Requests pass through a much smaller rate limit
+	LevelBanned  = "banned"  // Requests are refused
+)
+
+// Entry is a client held in the penalty box
+type Entry struct {
+	Client   string    `json:"client"`
+	Level    string    `json:"level"`
+	Strikes  int       `json:"strikes"`  // Strikes counted when the client was boxed
+	Offences int       `json:"offences"` // Times the client was boxed at this level within the history period, this time included
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+// Config represents penalty box configuration
+type Config struct {
+	Statuses      []int         // Response statuses counted as strikes, such as 401 and 429
+	StrikeWindow  time.Duration // Period strikes are counted over
+	LimitAfter    int           // Strikes within the window before a client is limited; 0 disables
+	LimitDuration time.Duration
+	BanAfter      int // Strikes within the window before a client is banned; 0 disables
+	BanDuration   time.Duration
+	History       time.Duration // How long offences count towards longer penalties
+	MaxDuration   time.Duration // Cap on escalated penalties
+	// Limit wraps handlers with the reduced rate limit of limited clients
+	Limit func(http.Handler) http.Handler
+	// Identify returns the client a request is counted against
+	Identify func(r *http.Request) string
+	// Exempt reports requests that are never counted or penalized, if set
+	Exempt func(r *http.Request) bool
+}
+
+// Box tracks clients that are repeatedly rejected and penalizes them: first
+// with a reduced rate limit, then with a temporary ban. Each repeat offence at
+// a level within the history period doubles the penalty.
+type Box struct {
+	config *Config
+	store  Store
+
+	boxed      *metrics.CounterVec
+	rejections *metrics.CounterVec
+}
+
+// NewBox creates a penalty box
+func NewBox(config *Config, store Store, reg *metrics.Registry) *Box {
+	return &Box{
+		config: config,
+		store:  store,
+		boxed: reg.NewCounterVec("gateway_penalty_box_entries_total",
+			"Clients placed in the penalty box, by level.", "level"),
+		rejections: reg.NewCounterVec("gateway_penalty_box_rejections_total",
+			"Requests of penalized clients that were refused, by level.", "level"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (b *Box) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := b.config.Limit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if b.config.Exempt != nil && b.config.Exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := b.config.Identify(r)
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			entry, err := b.store.Get(ctx, client)
+			cancel()
+			if err != nil {
+				// The penalty box fails open rather than refusing everyone
+				log.Printf("Penalty box lookup failed: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			handler := next
+			if entry != nil {
+				switch entry.Level {
+				case LevelBanned:
+					b.rejections.Inc(LevelBanned)
+					w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(entry.Until).Seconds())+1))
+					http.Error(w, `{"error":"Client banned","details":"Too many rejected requests; try again later"}`, http.StatusForbidden)
+					return
+				case LevelLimited:
+					handler = limited
+				}
+			}
+
+			sw := httputil.NewStatusWriter(w)
+			handler.ServeHTTP(sw, r)
+
+			if entry != nil && entry.Level == LevelLimited && sw.StatusCode() == http.StatusTooManyRequests {
+				b.rejections.Inc(LevelLimited)
+			}
+			if b.isStrike(sw.StatusCode()) {
+				b.strike(client, entry)
+			}
+		})
+	}
+}
+
+// isStrike reports whether a response status counts as a strike
+func (b *Box) isStrike(status int) bool {
+	for _, s := range b.config.Statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// strike counts a strike against the client and boxes it once a threshold is
+// reached. current is the client's entry, if any.
+func (b *Box) strike(client string, current *Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	strikes, err := b.store.Incr(ctx, "strikes:"+client, b.config.StrikeWindow)
+	if err != nil {
+		log.Printf("Failed to count penalty box strike: %v", err)
+		return
+	}
+
+	level, base := "", time.Duration(0)
+	switch {
+	case b.config.BanAfter > 0 && strikes >= b.config.BanAfter:
+		level, base = LevelBanned, b.config.BanDuration
+	case b.config.LimitAfter > 0 && strikes >= b.config.LimitAfter:
+		level, base = LevelLimited, b.config.LimitDuration
+	default:
+		return
+	}
+	if current != nil && (current.Level == level || current.Level == LevelBanned) {
+		return
+	}
+
+	offences, err := b.store.Incr(ctx, "offences:"+level+":"+client, b.config.History)
+	if err != nil {
+		log.Printf("Failed to count penalty box offence: %v", err)
+		return
+	}
+	duration := base
+	for i := 1; i < offences && duration < b.config.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > b.config.MaxDuration {
+		duration = b.config.MaxDuration
+	}
+
+	now := time.Now()
+	entry := &Entry{
+		Client:   client,
+		Level:    level,
+		Strikes:  strikes,
+		Offences: offences,
+		Since:    now,
+		Until:    now.Add(duration),
+	}
+	if err := b.store.Put(ctx, entry); err != nil {
+		log.Printf("Failed to place %s in the penalty box: %v", client, err)
+		return
+	}
+	b.boxed.Inc(level)
+	log.Printf("Penalty box: %s %s for %s after %d strikes (offence %d)", client, level, duration, strikes, offences)
+}
+
+// List returns the clients in the penalty box
+func (b *Box) List(ctx context.Context) ([]*Entry, error) {
+	return b.store.List(ctx)
+}
+
+// Release removes a client from the penalty box and forgets its strikes and
+// offences. It returns ErrNotFound when the client is not boxed.
+func (b *Box) Release(ctx context.Context, client, releasedBy string) error {
+	entry, err := b.store.Get(ctx, client)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return ErrNotFound
+	}
+	if err := b.store.Delete(ctx, client); err != nil {
+		return err
+	}
+	log.Printf("Audit: %s released %s from the penalty box (%s until %s)",
+		releasedBy, client, entry.Level, entry.Until.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// Replicate boxes a client boxed in another region, until the entry's Until
+// time
+func (b *Box) Replicate(ctx context.Context, entry *Entry) error {
+	return b.store.Put(ctx, entry)
+}
+
+// Forget removes a client released in another region, without an audit
+// entry of its own
+func (b *Box) Forget(ctx context.Context, client string) error {
+	return b.store.Delete(ctx, client)
+}
@@ -0,0 +1,129 @@
+package penalty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"api-gateway/storage"
+)
+
+// ErrNotFound is returned when releasing a client that is not boxed
+var ErrNotFound = errors.New("client is not in the penalty box")
+
+// Store keeps penalty box entries and counters, expiring them on their own
+type Store interface {
+	// Incr counts an event, returning the events counted in the current
+	// window, including this one
+	Incr(ctx context.Context, key string, window time.Duration) (int, error)
+	// Get returns the client's entry, or nil when it is not boxed
+	Get(ctx context.Context, client string) (*Entry, error)
+	// Put boxes a client until the entry's Until time
+	Put(ctx context.Context, entry *Entry) error
+	// Delete removes the client's entry and counters
+	Delete(ctx context.Context, client string) error
+	// List returns every entry, soonest release first
+	List(ctx context.Context) ([]*Entry, error)
+}
+
+// redisPrefix namespaces penalty box keys
+const redisPrefix = "penalty:"
+
+// KVStore keeps entries and counters in a key-value store with TTLs. With a
+// shared store, such as Redis or SQL, every replica shares them.
+type KVStore struct {
+	kv storage.KeyValueStore
+}
+
+// NewKVStore creates a new penalty box store on a key-value store
+func NewKVStore(kv storage.KeyValueStore) *KVStore {
+	return &KVStore{
+		kv: kv,
+	}
+}
+
+// Incr counts an event, starting its window on the first event
+func (s *KVStore) Incr(ctx context.Context, key string, window time.Duration) (int, error) {
+	count, err := s.kv.Incr(ctx, redisPrefix+key, window)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count penalty box event: %w", err)
+	}
+	return int(count), nil
+}
+
+// Get returns the client's entry
+func (s *KVStore) Get(ctx context.Context, client string) (*Entry, error) {
+	return s.load(ctx, redisPrefix+"entry:"+client)
+}
+
+// load reads an entry, returning nil when it has expired
+func (s *KVStore) load(ctx context.Context, key string) (*Entry, error) {
+	data, err := s.kv.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load penalty box entry: %w", err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode penalty box entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// Put boxes a client, expiring the entry at its Until time
+func (s *KVStore) Put(ctx context.Context, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(entry.Until)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.kv.Set(ctx, redisPrefix+"entry:"+entry.Client, data, ttl); err != nil {
+		return fmt.Errorf("failed to save penalty box entry: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the client's entry and counters
+func (s *KVStore) Delete(ctx context.Context, client string) error {
+	for _, key := range []string{"entry:", "strikes:", "offences:" + LevelLimited + ":", "offences:" + LevelBanned + ":"} {
+		if _, err := s.kv.Delete(ctx, redisPrefix+key+client); err != nil {
+			return fmt.Errorf("failed to delete penalty box entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns every entry
+func (s *KVStore) List(ctx context.Context) ([]*Entry, error) {
+	keys, err := s.kv.Keys(ctx, redisPrefix+"entry:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list penalty box entries: %w", err)
+	}
+	entries := make([]*Entry, 0, len(keys))
+	for _, key := range keys {
+		entry, err := s.load(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil { // Expired since the listing otherwise
+			entries = append(entries, entry)
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// sortEntries orders entries by release time
+func sortEntries(entries []*Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Until.Before(entries[j].Until)
+	})
+}
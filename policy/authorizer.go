@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"context"
+	"log"
+
+	"api-gateway/auth"
+)
+
+// PermissionInput names the permission being checked by auth.Require or
+// UserContext.Can. Policies receive it as input.permission, with no request.
+type PermissionInput struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+// Authorizer answers permission checks with OPA, combined with the built-in
+// role permissions according to the mode
+type Authorizer struct {
+	client   *OPAClient
+	roles    auth.Authorizer // Built-in role permissions
+	mode     Mode
+	failOpen bool
+}
+
+// NewAuthorizer creates a policy-backed authorizer. In augment mode a
+// permission needs both the built-in roles and the policy; in replace mode
+// only the policy decides.
+func NewAuthorizer(client *OPAClient, roles auth.Authorizer, mode Mode, config MiddlewareConfig) *Authorizer {
+	return &Authorizer{
+		client:   client,
+		roles:    roles,
+		mode:     mode,
+		failOpen: config.FailOpen,
+	}
+}
+
+// Authorize implements auth.Authorizer
+func (a *Authorizer) Authorize(ctx context.Context, user *auth.UserContext, resource, action string) (bool, error) {
+	if a.mode != ModeReplace {
+		allowed, err := a.roles.Authorize(ctx, user, resource, action)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+
+	decision, err := a.client.Evaluate(ctx, &Input{
+		User:       userInput(user),
+		Permission: &PermissionInput{Resource: resource, Action: action},
+	})
+	if err != nil {
+		if a.failOpen {
+			log.Printf("Policy evaluation failed: %v", err)
+			return true, nil
+		}
+		return false, err
+	}
+	return decision.Allow, nil
+}
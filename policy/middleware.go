@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+)
+
+// Mode controls how policy evaluation interacts with the built-in role checks
+type Mode string
+
+const (
+	// ModeAugment evaluates policies in addition to the role checks
+	ModeAugment Mode = "augment"
+	// ModeReplace evaluates policies instead of the role checks
+	ModeReplace Mode = "replace"
+)
+
+// MiddlewareConfig represents configuration for the policy middleware
+type MiddlewareConfig struct {
+	FailOpen bool // Allow requests when OPA cannot be reached
+}
+
+// sensitiveHeaders are never forwarded to the policy engine
+var sensitiveHeaders = map[string]bool{
+	"authorization": true,
+	"x-api-key":     true,
+	"cookie":        true,
+}
+
+// Middleware creates a middleware that authorizes requests using OPA.
+// It must run after authentication so that the user context is available.
+func Middleware(client *OPAClient, config MiddlewareConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision, err := client.Evaluate(r.Context(), BuildInput(r))
+			if err != nil {
+				log.Printf("Policy evaluation failed: %v", err)
+				if config.FailOpen {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, `{"error":"Authorization unavailable","details":"Policy engine could not be reached"}`, http.StatusServiceUnavailable)
+				return
+			}
+
+			if !decision.Allow {
+				details := "Denied by policy"
+				if decision.Reason != "" {
+					details = decision.Reason
+				}
+				http.Error(w, `{"error":"Insufficient permissions","details":"`+details+`"}`, http.StatusForbidden)
+				return
+			}
+
+			// Apply obligations returned by the policy
+			for name, value := range decision.RequestHeaders {
+				r.Header.Set(name, value)
+			}
+			for name, value := range decision.ResponseHeaders {
+				w.Header().Set(name, value)
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), decisionContextKey, decision))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// contextKey is a custom type for context keys
+type contextKey string
+
+const decisionContextKey contextKey = "policy_decision"
+
+// GetDecisionFromContext returns the policy decision made for the request
+func GetDecisionFromContext(r *http.Request) *Decision {
+	decision, ok := r.Context().Value(decisionContextKey).(*Decision)
+	if !ok {
+		return nil
+	}
+	return decision
+}
+
+// BuildInput builds the policy input document for a request
+func BuildInput(r *http.Request) *Input {
+	headers := make(map[string]string)
+	for name, values := range r.Header {
+		lower := strings.ToLower(name)
+		if sensitiveHeaders[lower] {
+			continue
+		}
+		headers[lower] = strings.Join(values, ", ")
+	}
+
+	input := &Input{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Segments: strings.Split(strings.Trim(r.URL.Path, "/"), "/"),
+		Query:    r.URL.Query(),
+		Headers:  headers,
+		ClientIP: httputil.ClientIP(r),
+	}
+
+	input.User = userInput(auth.GetUserFromContext(r))
+
+	return input
+}
+
+// userInput converts the authenticated identity, returning nil when anonymous
+func userInput(userCtx *auth.UserContext) *UserInput {
+	if userCtx == nil {
+		return nil
+	}
+	return &UserInput{
+		ID:       userCtx.UserID,
+		Username: userCtx.Username,
+		Email:    userCtx.Email,
+		Roles:    userCtx.Roles,
+		Groups:   userCtx.Groups,
+		AuthType: userCtx.AuthType,
+		Actor:    userCtx.Actor,
+		Scopes:   userCtx.Scopes,
+	}
+}
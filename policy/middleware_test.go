@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// opaServer answers every query with the status and body, and counts the queries
+func opaServer(t *testing.T, status int, body string) (*OPAClient, *int) {
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewOPAClient(server.URL, "v1/data/gateway/allow", time.Second), &queries
+}
+
+func TestMiddlewareDenies(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		down     bool
+		failOpen bool
+		want     int
+		details  string
+	}{
+		{name: "allowed", status: http.StatusOK, body: `{"result":true}`, want: http.StatusOK},
+		{name: "denied", status: http.StatusOK, body: `{"result":false}`, want: http.StatusForbidden, details: "Denied by policy"},
+		{name: "denied with reason", status: http.StatusOK, body: `{"result":{"allow":false,"reason":"outside business hours"}}`, want: http.StatusForbidden, details: "outside business hours"},
+		{name: "undefined decision", status: http.StatusOK, body: `{}`, want: http.StatusForbidden, details: "policy decision is undefined"},
+		{name: "null decision", status: http.StatusOK, body: `{"result":null}`, want: http.StatusForbidden, details: "policy decision is undefined"},
+		{name: "invalid decision", status: http.StatusOK, body: `{"result":"yes"}`, want: http.StatusServiceUnavailable},
+		{name: "invalid response", status: http.StatusOK, body: `not json`, want: http.StatusServiceUnavailable},
+		{name: "server error", status: http.StatusInternalServerError, body: `{}`, want: http.StatusServiceUnavailable},
+		{name: "unreachable", down: true, want: http.StatusServiceUnavailable},
+		{name: "server error failing open", status: http.StatusInternalServerError, body: `{}`, failOpen: true, want: http.StatusOK},
+		{name: "denied failing open", status: http.StatusOK, body: `{"result":false}`, failOpen: true, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := opaServer(t, tt.status, tt.body)
+			if tt.down {
+				client = NewOPAClient("http://127.0.0.1:1", "v1/data/gateway/allow", time.Second)
+			}
+			served := false
+			handler := Middleware(client, MiddlewareConfig{FailOpen: tt.failOpen})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if served != (tt.want == http.StatusOK) {
+				t.Errorf("request served %v with status %d", served, rec.Code)
+			}
+			if tt.details != "" && !strings.Contains(rec.Body.String(), `"details":"`+tt.details+`"`) {
+				t.Errorf("body %s, want details %q", rec.Body.String(), tt.details)
+			}
+		})
+	}
+}
+
+func TestAuthorizerDenies(t *testing.T) {
+	roles := auth.RolePermissions{"admin": {"*"}, "user": {"orders:read"}}
+	tests := []struct {
+		name     string
+		mode     Mode
+		roles    []string
+		status   int
+		body     string
+		failOpen bool
+		allowed  bool
+		err      bool
+		queried  bool
+	}{
+		{name: "augment, allowed by both", mode: ModeAugment, roles: []string{"user"}, status: http.StatusOK, body: `{"result":true}`, allowed: true, queried: true},
+		{name: "augment, denied by roles", mode: ModeAugment, roles: []string{"guest"}, status: http.StatusOK, body: `{"result":true}`},
+		{name: "augment, denied by policy", mode: ModeAugment, roles: []string{"admin"}, status: http.StatusOK, body: `{"result":false}`, queried: true},
+		{name: "augment, undefined decision", mode: ModeAugment, roles: []string{"user"}, status: http.StatusOK, body: `{}`, queried: true},
+		{name: "augment, server error", mode: ModeAugment, roles: []string{"user"}, status: http.StatusInternalServerError, body: `{}`, err: true, queried: true},
+		{name: "augment, failing open", mode: ModeAugment, roles: []string{"user"}, status: http.StatusInternalServerError, body: `{}`, failOpen: true, allowed: true, queried: true},
+		{name: "augment, failing open denied by roles", mode: ModeAugment, roles: []string{"guest"}, status: http.StatusInternalServerError, body: `{}`, failOpen: true},
+		{name: "replace, allowed without roles", mode: ModeReplace, roles: []string{"guest"}, status: http.StatusOK, body: `{"result":true}`, allowed: true, queried: true},
+		{name: "replace, denied despite roles", mode: ModeReplace, roles: []string{"admin"}, status: http.StatusOK, body: `{"result":{"allow":false}}`, queried: true},
+		{name: "replace, invalid decision", mode: ModeReplace, roles: []string{"admin"}, status: http.StatusOK, body: `{"result":1}`, err: true, queried: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, queries := opaServer(t, tt.status, tt.body)
+			authorizer := NewAuthorizer(client, roles, tt.mode, MiddlewareConfig{FailOpen: tt.failOpen})
+			user := &auth.UserContext{UserID: "1", Username: "alice", Roles: tt.roles}
+
+			allowed, err := authorizer.Authorize(context.Background(), user, "orders", "read")
+			if allowed != tt.allowed || (err != nil) != tt.err {
+				t.Errorf("Authorize = %v, %v; want %v with error %v", allowed, err, tt.allowed, tt.err)
+			}
+			if (*queries > 0) != tt.queried {
+				t.Errorf("%d policy queries, want queried %v", *queries, tt.queried)
+			}
+		})
+	}
+}
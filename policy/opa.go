@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// Input represents the document sent to OPA for evaluation
+type Input struct {
+	Method   string              `json:"method"`
+	Path     string              `json:"path"`
+	Segments []string            `json:"path_segments"`
+	Query    map[string][]string `json:"query"`
+	Headers  map[string]string   `json:"headers"`
+	ClientIP string              `json:"client_ip"`
+	User     *UserInput          `json:"user"`
+
+	Permission *PermissionInput `json:"permission,omitempty"` // Set for permission checks instead of request fields
+}
+
+// UserInput represents the authenticated identity passed to OPA
+type UserInput struct {
+	ID       string      `json:"id"`
+	Username string      `json:"username"`
+	Email    string      `json:"email"`
+	Roles    []string    `json:"roles"`
+	Groups   []string    `json:"groups,omitempty"`
+	AuthType string      `json:"auth_type"`
+	Actor    *auth.Actor `json:"actor,omitempty"`  // Set when an administrator is impersonating the user
+	Scopes   []string    `json:"scopes,omitempty"` // Set for personal access tokens
+}
+
+// Decision represents the outcome of a policy evaluation
+type Decision struct {
+	Allow           bool              `json:"allow"`
+	Reason          string            `json:"reason,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`  // Obligations: headers added to the request
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"` // Obligations: headers added to the response
+}
+
+// OPAClient evaluates policies against an OPA server (usually a sidecar)
+type OPAClient struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewOPAClient creates a new OPA client for the given server URL and decision path
+func NewOPAClient(baseURL, path string, timeout time.Duration) *OPAClient {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return &OPAClient{
+		url: strings.TrimSuffix(baseURL, "/") + path,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// opaRequest is the body of an OPA data API query
+type opaRequest struct {
+	Input *Input `json:"input"`
+}
+
+// opaResponse is the body returned by the OPA data API
+type opaResponse struct {
+	Result json.RawMessage `json:"result"`
+}
+
+// Evaluate queries OPA with the given input and returns its decision
+func (c *OPAClient) Evaluate(ctx context.Context, input *Input) (*Decision, error) {
+	body, err := json.Marshal(opaRequest{Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode policy input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("policy query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy query failed: unexpected status %d", resp.StatusCode)
+	}
+
+	var result opaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode policy response: %w", err)
+	}
+
+	return parseResult(result.Result)
+}
+
+// parseResult converts an OPA result into a decision. The policy may return
+// either a plain boolean or an object with allow, reason and header obligations.
+// An undefined result is treated as a deny.
+func parseResult(raw json.RawMessage) (*Decision, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return &Decision{Allow: false, Reason: "policy decision is undefined"}, nil
+	}
+
+	var allow bool
+	if err := json.Unmarshal(raw, &allow); err == nil {
+		return &Decision{Allow: allow}, nil
+	}
+
+	var decision Decision
+	if err := json.Unmarshal(raw, &decision); err != nil {
+		return nil, fmt.Errorf("invalid policy result: %w", err)
+	}
+
+	return &decision, nil
+}
@@ -0,0 +1,203 @@
+package product
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/proxy"
+)
+
+// Product groups routes that API keys subscribe to
+type Product struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Paths       []string       `json:"paths"`
+	Plans       []string       `json:"plans"`            // Empty offers the product on every plan
+	Quotas      map[string]int `json:"quotas,omitempty"` // Plan -> requests per minute per key
+}
+
+// Offers reports whether the product is available on a plan
+func (p *Product) Offers(plan string) bool {
+	if len(p.Plans) == 0 {
+		return true
+	}
+	for _, offered := range p.Plans {
+		if offered == plan {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the length of the longest product path matching the request path, or -1
+func (p *Product) match(path string) int {
+	longest := -1
+	for _, prefix := range p.Paths {
+		if proxy.HasPathPrefix(path, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+		}
+	}
+	return longest
+}
+
+// window counts requests of one key to one product in the current minute
+type window struct {
+	start time.Time
+	count int
+}
+
+// Catalog holds the configured products and enforces subscriptions and quotas
+type Catalog struct {
+	products []*Product
+	byName   map[string]*Product
+
+	identify func(r *http.Request) *auth.APIKey // Returns the API key of a request, or nil
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+// NewCatalog creates a product catalog. identify resolves the API key of a
+// request ahead of authentication middleware.
+func NewCatalog(products []*Product, identify func(r *http.Request) *auth.APIKey) *Catalog {
+	c := &Catalog{
+		products: products,
+		byName:   make(map[string]*Product),
+		identify: identify,
+		windows:  make(map[string]*window),
+	}
+	for _, p := range products {
+		c.byName[p.Name] = p
+	}
+
+	go c.cleanupRoutine()
+
+	return c
+}
+
+// Products returns all products
+func (c *Catalog) Products() []*Product {
+	return c.products
+}
+
+// Get returns a product by name
+func (c *Catalog) Get(name string) (*Product, bool) {
+	p, exists := c.byName[name]
+	return p, exists
+}
+
+// OfferedOn returns the names of the products available on a plan
+func (c *Catalog) OfferedOn(plan string) []string {
+	var names []string
+	for _, p := range c.products {
+		if p.Offers(plan) {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// Middleware returns middleware that requires API keys calling a product route
+// to be subscribed to a product including it, and applies the product quota
+func (c *Catalog) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.covers(r.URL.Path) || r.Header.Get("X-API-Key") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey := c.identify(r)
+			if apiKey == nil {
+				// Invalid keys are rejected by authentication
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			p := c.subscribed(apiKey, r.URL.Path)
+			if p == nil {
+				http.Error(w, `{"error":"Product subscription required","details":"The API key is not subscribed to a product including this route"}`, http.StatusForbidden)
+				return
+			}
+
+			if quota, ok := p.Quotas[apiKey.Plan]; ok {
+				if retryAfter, allowed := c.take(apiKey.Key, p.Name, quota); !allowed {
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+					w.WriteHeader(http.StatusTooManyRequests)
+					w.Write([]byte(`{"error":"Product quota exceeded","details":"The quota of product ` + p.Name + ` for plan ` + apiKey.Plan + ` is exhausted"}`))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// covers reports whether any product includes the path
+func (c *Catalog) covers(path string) bool {
+	for _, p := range c.products {
+		if p.match(path) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribed returns the most specific product including the path that the
+// key subscribes to on its plan, or nil
+func (c *Catalog) subscribed(apiKey *auth.APIKey, path string) *Product {
+	var best *Product
+	longest := -1
+	for _, name := range apiKey.Products {
+		p, exists := c.byName[name]
+		if !exists || !p.Offers(apiKey.Plan) {
+			continue
+		}
+		if length := p.match(path); length > longest {
+			longest = length
+			best = p
+		}
+	}
+	return best
+}
+
+// take counts a request against a key's per-minute product quota
+func (c *Catalog) take(key, product string, quota int) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	id := key + "|" + product
+	win, exists := c.windows[id]
+	if !exists || now.Sub(win.start) >= time.Minute {
+		win = &window{start: now}
+		c.windows[id] = win
+	}
+	if win.count >= quota {
+		return win.start.Add(time.Minute).Sub(now), false
+	}
+	win.count++
+	return 0, true
+}
+
+// cleanupRoutine removes finished quota windows
+func (c *Catalog) cleanupRoutine() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		c.mu.Lock()
+		for id, win := range c.windows {
+			if now.Sub(win.start) >= time.Minute {
+				delete(c.windows, id)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,18 @@
+package product
+
+import "testing"
+
+func TestMatchOnSegmentBoundaries(t *testing.T) {
+	p := &Product{Name: "orders", Paths: []string{"/api", "/api/orders"}}
+	for path, want := range map[string]int{
+		"/api/orders":        len("/api/orders"),
+		"/api/orders/7":      len("/api/orders"),
+		"/api/orders-export": len("/api"),
+		"/api/ordersx":       len("/api"),
+		"/apiv2/orders":      -1,
+	} {
+		if got := p.match(path); got != want {
+			t.Errorf("match(%q) = %d, want %d", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator attaches service-to-service credentials to an outbound request
+type Authenticator interface {
+	Apply(req *http.Request) error
+}
+
+// APIKeyAuth sends a static API key in a header
+type APIKeyAuth struct {
+	Header string
+	Key    string
+}
+
+// Apply sets the API key header
+func (a *APIKeyAuth) Apply(req *http.Request) error {
+	req.Header.Set(a.Header, a.Key)
+	return nil
+}
+
+// BasicAuth sends HTTP Basic credentials
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Apply sets the Authorization header
+func (a *BasicAuth) Apply(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// ClientCredentials obtains and caches an OAuth2 access token using the
+// client-credentials grant, refreshing it shortly before it expires
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	client *http.Client
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenRefreshMargin is how long before expiry a token is refreshed
+const tokenRefreshMargin = 30 * time.Second
+
+// NewClientCredentials creates an OAuth2 client-credentials authenticator
+func NewClientCredentials(tokenURL, clientID, clientSecret string, scopes []string) *ClientCredentials {
+	return &ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Apply sets a bearer token, fetching a new one when needed
+func (c *ClientCredentials) Apply(req *http.Request) error {
+	token, err := c.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns a valid access token
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Add(tokenRefreshMargin).Before(c.expiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+
+	c.token = body.AccessToken
+	c.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	if body.ExpiresIn == 0 {
+		// No lifetime given; refresh hourly
+		c.expiry = time.Now().Add(time.Hour)
+	}
+	return c.token, nil
+}
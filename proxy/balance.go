@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gatewayhttputil "api-gateway/httputil"
+)
+
+// slowStartMinWeight is the share of traffic a backend receives as soon as
+// it returns, before its warm-up ramps it further
+const slowStartMinWeight = 0.1
+
+// ringReplicas is the number of points each backend takes on the hash ring;
+// more points spread keys more evenly
+const ringReplicas = 160
+
+// Backend is one target of a load-balanced upstream
+type Backend struct {
+	URL      *url.URL
+	Locality Locality
+
+	rank         int // Distance from the gateway's locality
+	inFlight     atomic.Int64
+	downUntil    atomic.Int64 // Unix nanoseconds until which the backend is skipped
+	ejectedUntil atomic.Int64 // Unix nanoseconds until which outlier detection keeps the backend out
+	stats        outlierStats
+}
+
+// healthy reports whether the backend is outside its failure cooldown and
+// not ejected as an outlier
+func (b *Backend) healthy(now time.Time) bool {
+	return b.downUntil.Load() <= now.UnixNano() && b.ejectedUntil.Load() <= now.UnixNano()
+}
+
+// Balancer spreads an upstream's requests over several backends
+type Balancer struct {
+	Backends        []*Backend
+	Strategy        string            // "round_robin" or "consistent_hash"
+	HashKey         string            // "ip", "cookie:<name>" or "header:<name>"
+	LoadFactor      float64           // Caps a backend at this multiple of the average load; 0 disables
+	FailureCooldown time.Duration     // How long a failing backend is skipped
+	Outliers        *OutlierDetection // Optional ejection of backends with high error rates or latency
+	SlowStart       time.Duration     // Window over which a recovered backend ramps up to its full share; 0 disables
+	Local           Locality          // The gateway's locality; empty treats all backends alike
+	Overprovision   float64           // How far a partly healthy locality is trusted before traffic spills over
+
+	pools []*pool // Ordered from the gateway's locality outwards
+}
+
+// pool is a group of backends equally close to the gateway
+type pool struct {
+	rank     int
+	backends []*Backend
+	ring     []ringPoint
+	next     atomic.Uint64
+}
+
+// ringPoint places a backend on the hash ring
+type ringPoint struct {
+	hash    uint64
+	backend *Backend
+}
+
+// NewBalancer creates a balancer over the backends
+func NewBalancer(backends []*Backend, strategy, hashKey string, loadFactor float64, failureCooldown time.Duration) *Balancer {
+	return &Balancer{
+		Backends:        backends,
+		Strategy:        strategy,
+		HashKey:         hashKey,
+		LoadFactor:      loadFactor,
+		FailureCooldown: failureCooldown,
+		pools:           []*pool{newPool(sameZone, backends)},
+	}
+}
+
+// newPool builds a pool and its hash ring
+func newPool(rank int, backends []*Backend) *pool {
+	p := &pool{rank: rank, backends: backends}
+	for _, backend := range backends {
+		for i := 0; i < ringReplicas; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hashString(backend.URL.String() + "#" + strconv.Itoa(i)), backend: backend})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p
+}
+
+// Pick chooses the backend for a request. It starts from a pool chosen by
+// locality health and moves on to the next pools when every backend of one
+// is unavailable or at the load bound.
+func (b *Balancer) Pick(r *http.Request) *Backend {
+	now := time.Now()
+	bound := b.loadBound(now)
+
+	var key uint64
+	spill := rand.Float64()
+	if b.Strategy == "consistent_hash" {
+		// Derive the pool from the key too so clients stay put while health is stable
+		key = hashString(b.key(r))
+		spill = float64(key>>32) / (1 << 32)
+	}
+
+	first := b.choosePool(spill, now)
+	var fallback *Backend
+	for i := range b.pools {
+		p := b.pools[(first+i)%len(b.pools)]
+		var backend, healthy *Backend
+		if b.Strategy == "consistent_hash" {
+			backend, healthy = b.pickConsistent(p, key, bound, now)
+		} else {
+			backend, healthy = b.pickRoundRobin(p, bound, now)
+		}
+		if backend != nil {
+			return backend
+		}
+		if fallback == nil {
+			fallback = healthy
+		}
+	}
+	if fallback == nil {
+		// Every backend is cooling down; trying one beats failing outright
+		p := b.pools[first]
+		fallback = p.ring[sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= key })%len(p.ring)].backend
+	}
+	return fallback
+}
+
+// pickRoundRobin takes the pool's backends in turn, skipping unavailable and
+// overloaded ones. It also returns the first healthy backend as a fallback.
+func (b *Balancer) pickRoundRobin(p *pool, bound int64, now time.Time) (*Backend, *Backend) {
+	start := p.next.Add(1)
+	var fallback *Backend
+	for i := range p.backends {
+		backend := p.backends[(start+uint64(i))%uint64(len(p.backends))]
+		if !backend.healthy(now) {
+			continue
+		}
+		if fallback == nil {
+			fallback = backend
+		}
+		if rand.Float64() < b.warmth(backend, now) && underBound(backend, bound) {
+			return backend, fallback
+		}
+	}
+	return nil, fallback
+}
+
+// pickConsistent walks the pool's ring clockwise from the key and takes the
+// first healthy backend below the load bound. Only keys of a failed or
+// overloaded backend move, and they return once it recovers; while it warms
+// up, a growing share of them returns, each key consistently. It also
+// returns the first healthy backend as a fallback.
+func (b *Balancer) pickConsistent(p *pool, key uint64, bound int64, now time.Time) (*Backend, *Backend) {
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= key })
+
+	var fallback *Backend
+	seen := make(map[*Backend]bool, len(p.backends))
+	for i := 0; i < len(p.ring) && len(seen) < len(p.backends); i++ {
+		backend := p.ring[(start+i)%len(p.ring)].backend
+		if seen[backend] {
+			continue
+		}
+		seen[backend] = true
+		if !backend.healthy(now) {
+			continue
+		}
+		if fallback == nil {
+			fallback = backend
+		}
+		if float64(key%1024)/1024 >= b.warmth(backend, now) {
+			continue
+		}
+		if underBound(backend, bound) {
+			return backend, fallback
+		}
+	}
+	return nil, fallback
+}
+
+// underBound reports whether the backend may take another request
+func underBound(backend *Backend, bound int64) bool {
+	return bound == 0 || backend.inFlight.Load() < bound
+}
+
+// warmth returns the share of its normal traffic a backend may receive,
+// ramping linearly from slowStartMinWeight to 1 over the slow-start window
+// after it returns from a failure cooldown or ejection
+func (b *Balancer) warmth(backend *Backend, now time.Time) float64 {
+	if b.SlowStart <= 0 {
+		return 1
+	}
+	returned := backend.downUntil.Load()
+	if ejected := backend.ejectedUntil.Load(); ejected > returned {
+		returned = ejected
+	}
+	if returned == 0 {
+		return 1
+	}
+	elapsed := now.UnixNano() - returned
+	if elapsed >= int64(b.SlowStart) {
+		return 1
+	}
+	return math.Max(slowStartMinWeight, float64(elapsed)/float64(b.SlowStart))
+}
+
+// loadBound returns the most in-flight requests a healthy backend may hold
+// when the next request is added, or 0 when loads are not bounded
+func (b *Balancer) loadBound(now time.Time) int64 {
+	if b.LoadFactor <= 0 {
+		return 0
+	}
+	var total, healthy int64
+	for _, backend := range b.Backends {
+		total += backend.inFlight.Load()
+		if backend.healthy(now) {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return 0
+	}
+	return int64(math.Ceil(b.LoadFactor * float64(total+1) / float64(healthy)))
+}
+
+// key returns the value requests are hashed on; requests without the
+// configured cookie or header fall back to the client IP
+func (b *Balancer) key(r *http.Request) string {
+	kind, name, _ := strings.Cut(b.HashKey, ":")
+	switch kind {
+	case "cookie":
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	case "header":
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return gatewayhttputil.ClientIP(r)
+}
+
+// fail skips the backend for the failure cooldown
+func (b *Balancer) fail(backend *Backend, err error) {
+	if b.FailureCooldown <= 0 {
+		return
+	}
+	if backend.healthy(time.Now()) {
+		log.Printf("Backend %s failed and is skipped for %s: %v", backend.URL.Redacted(), b.FailureCooldown, err)
+	}
+	backend.downUntil.Store(time.Now().Add(b.FailureCooldown).UnixNano())
+}
+
+// hashString hashes s onto the ring. FNV-1a alone clusters similar short
+// strings, so its result is mixed with the splitmix64 finalizer.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type backendContextKey struct{}
+
+// pickedBackend is the backend chosen for a request and when it was chosen
+type pickedBackend struct {
+	backend *Backend
+	start   time.Time
+}
+
+// withBackend records the backend chosen for a request
+func withBackend(ctx context.Context, backend *Backend) context.Context {
+	return context.WithValue(ctx, backendContextKey{}, &pickedBackend{backend: backend, start: time.Now()})
+}
+
+// backendFromContext returns the backend chosen for a request and when it was
+// chosen, or nil
+func backendFromContext(ctx context.Context) (*Backend, time.Time) {
+	picked, _ := ctx.Value(backendContextKey{}).(*pickedBackend)
+	if picked == nil {
+		return nil, time.Time{}
+	}
+	return picked.backend, picked.start
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+
+	"api-gateway/auth"
+)
+
+// ClaimRoute sends requests whose token claims match to another target, and
+// tags them with headers for the upstream
+type ClaimRoute struct {
+	Name    string
+	Claims  map[string]string // Claim -> required value; "*" only requires the claim
+	Target  *url.URL          // Replaces the upstream URL when set
+	Headers map[string]string // Set on the upstream request; "{claim}" is replaced by the claim's value
+}
+
+// claimPlaceholder matches "{claim}" in header values
+var claimPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.:-]+)\}`)
+
+// matches reports whether every condition of the route holds. A claim holding
+// a list matches when any element has the required value.
+func (route *ClaimRoute) matches(claims map[string]interface{}) bool {
+	for name, want := range route.Claims {
+		value, ok := claims[name]
+		if !ok {
+			return false
+		}
+		if want == "*" {
+			continue
+		}
+		matched := false
+		for _, got := range claimValues(value) {
+			if got == want {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// tag sets the route's headers on the upstream request
+func (route *ClaimRoute) tag(header http.Header, claims map[string]interface{}) {
+	for name, template := range route.Headers {
+		header.Set(name, claimPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+			values := claimValues(claims[placeholder[1:len(placeholder)-1]])
+			if len(values) == 0 {
+				return ""
+			}
+			return values[0]
+		}))
+	}
+}
+
+// routeByClaims returns the first claim route matching the request's token,
+// after removing the routes' headers so clients cannot set them
+func routeByClaims(routes []*ClaimRoute, in *http.Request, out http.Header) *ClaimRoute {
+	for _, route := range routes {
+		for name := range route.Headers {
+			out.Del(name)
+		}
+	}
+
+	route := matchClaimRoute(routes, in)
+	if route != nil {
+		route.tag(out, auth.GetUserFromContext(in).Claims)
+	}
+	return route
+}
+
+// matchClaimRoute returns the first claim route matching the request's token, or nil
+func matchClaimRoute(routes []*ClaimRoute, in *http.Request) *ClaimRoute {
+	userCtx := auth.GetUserFromContext(in)
+	if userCtx == nil || userCtx.Claims == nil {
+		return nil
+	}
+	for _, route := range routes {
+		if route.matches(userCtx.Claims) {
+			return route
+		}
+	}
+	return nil
+}
+
+// ClaimPartition identifies how the upstream serving the request handles it
+// under its claim routes: the matching route's name and the headers it tags
+// the request with, or "" when no route matches. Responses cached for one
+// partition must not be served to another.
+func (p *Proxy) ClaimPartition(r *http.Request) string {
+	upstream := p.Match(r.URL.Path)
+	if upstream == nil {
+		return ""
+	}
+	route := matchClaimRoute(upstream.ClaimRoutes, r)
+	if route == nil {
+		return ""
+	}
+	header := make(http.Header, len(route.Headers))
+	route.tag(header, auth.GetUserFromContext(r).Claims)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	partition := upstream.Name + "/" + route.Name
+	for _, name := range names {
+		partition += "\x00" + name + ":" + header.Get(name)
+	}
+	return partition
+}
+
+// claimValues formats a claim as strings: scalars become one value, lists
+// one value per element
+func claimValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			values = append(values, claimValues(element)...)
+		}
+		return values
+	case map[string]interface{}:
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"api-gateway/auth"
+)
+
+func TestClaimPartition(t *testing.T) {
+	p := &Proxy{upstreams: []*Upstream{{
+		Name:       "orders",
+		PathPrefix: "/orders",
+		ClaimRoutes: []*ClaimRoute{
+			{Name: "beta", Claims: map[string]string{"beta": "true"}, Target: &url.URL{Scheme: "http", Host: "beta:8080"}},
+			{Name: "tenant", Claims: map[string]string{"tenant": "*"}, Headers: map[string]string{"X-Tenant": "{tenant}"}},
+		},
+	}}}
+	request := func(path string, claims map[string]interface{}) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if claims != nil {
+			r = auth.WithUser(r, &auth.UserContext{UserID: "u1", Claims: claims})
+		}
+		return r
+	}
+
+	partitions := map[string]string{
+		"anonymous":     p.ClaimPartition(request("/orders/1", nil)),
+		"no match":      p.ClaimPartition(request("/orders/1", map[string]interface{}{"sub": "u1"})),
+		"other path":    p.ClaimPartition(request("/users/1", map[string]interface{}{"beta": "true"})),
+		"beta":          p.ClaimPartition(request("/orders/1", map[string]interface{}{"beta": "true"})),
+		"tenant acme":   p.ClaimPartition(request("/orders/1", map[string]interface{}{"tenant": "acme"})),
+		"tenant globex": p.ClaimPartition(request("/orders/1", map[string]interface{}{"tenant": "globex"})),
+	}
+	for _, name := range []string{"anonymous", "no match", "other path"} {
+		if partitions[name] != "" {
+			t.Errorf("%s: partition %q, want none", name, partitions[name])
+		}
+	}
+	seen := map[string]string{}
+	for _, name := range []string{"beta", "tenant acme", "tenant globex"} {
+		partition := partitions[name]
+		if partition == "" {
+			t.Errorf("%s: no partition", name)
+		}
+		if other, ok := seen[partition]; ok {
+			t.Errorf("%s and %s share partition %q", name, other, partition)
+		}
+		seen[partition] = name
+	}
+}
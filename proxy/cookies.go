@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CookiePolicy adapts cookies exchanged with an upstream, typically a
+// legacy application unaware that it runs behind the gateway
+type CookiePolicy struct {
+	Strip          []string          // Request cookies removed before proxying
+	DomainRewrites map[string]string // Set-Cookie domain -> replacement; empty drops the Domain attribute
+	PathRewrites   map[string]string // Set-Cookie path prefix -> replacement
+	Secure         bool              // Force the Secure attribute
+	SameSite       string            // Force SameSite: "lax", "strict" or "none"; empty keeps the upstream's
+}
+
+// stripRequestCookies removes the configured cookies from an outbound request
+func (p *CookiePolicy) stripRequestCookies(req *http.Request) {
+	if len(p.Strip) == 0 || req.Header.Get("Cookie") == "" {
+		return
+	}
+
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if !p.strips(cookie.Name) {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// strips reports whether the named cookie is removed from requests
+func (p *CookiePolicy) strips(name string) bool {
+	for _, strip := range p.Strip {
+		if strip == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteResponseCookies applies the policy to the upstream's Set-Cookie headers
+func (p *CookiePolicy) rewriteResponseCookies(resp *http.Response) {
+	lines := resp.Header.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+
+	resp.Header.Del("Set-Cookie")
+	for _, line := range lines {
+		cookies := (&http.Response{Header: http.Header{"Set-Cookie": {line}}}).Cookies()
+		if len(cookies) == 0 {
+			// Keep what cannot be parsed rather than silently dropping it
+			resp.Header.Add("Set-Cookie", line)
+			continue
+		}
+		cookie := cookies[0]
+		p.rewrite(cookie)
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+}
+
+// rewrite applies domain, path and attribute changes to one cookie
+func (p *CookiePolicy) rewrite(cookie *http.Cookie) {
+	if cookie.Domain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+		if replacement, ok := p.DomainRewrites[domain]; ok {
+			cookie.Domain = replacement
+		}
+	}
+
+	if cookie.Path != "" {
+		longest := ""
+		for prefix := range p.PathRewrites {
+			if strings.HasPrefix(cookie.Path, prefix) && len(prefix) > len(longest) {
+				longest = prefix
+			}
+		}
+		if longest != "" {
+			cookie.Path = p.PathRewrites[longest] + strings.TrimPrefix(cookie.Path, longest)
+		}
+	}
+
+	if p.Secure {
+		cookie.Secure = true
+	}
+	switch strings.ToLower(p.SameSite) {
+	case "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers reject SameSite=None cookies without Secure
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true
+	}
+}
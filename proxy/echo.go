@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+
+	"api-gateway/auth"
+	"api-gateway/policy"
+	"api-gateway/redact"
+
+	"github.com/gorilla/mux"
+)
+
+// EchoTarget answers an upstream's requests inside the gateway by reflecting
+// them, as they would have been sent upstream, back to the caller
+type EchoTarget struct {
+	Redactor    *redact.Redactor // Masks credentials in the reflected headers and body
+	MaxBodySize int64            // Bytes of the body reflected
+}
+
+// EchoResponse describes the request the gateway would have sent upstream
+type EchoResponse struct {
+	Upstream      string              `json:"upstream"`
+	Method        string              `json:"method"`
+	URL           string              `json:"url"`
+	Route         string              `json:"route,omitempty"`
+	Headers       map[string][]string `json:"headers"`
+	Body          string              `json:"body,omitempty"`
+	BodyBase64    string              `json:"body_base64,omitempty"` // Set instead of Body for binary bodies
+	BodyBytes     int64               `json:"body_bytes"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+	Identity      *EchoIdentity       `json:"identity"`
+	Policies      []string            `json:"policies"` // Gateway policies applied to the request, in order
+}
+
+// EchoIdentity is the identity the gateway derived for the caller
+type EchoIdentity struct {
+	UserID   string                 `json:"user_id"`
+	Username string                 `json:"username,omitempty"`
+	AuthType string                 `json:"auth_type"`
+	Issuer   string                 `json:"issuer,omitempty"`
+	Roles    []string               `json:"roles"`
+	Groups   []string               `json:"groups,omitempty"`
+	Scopes   []string               `json:"scopes,omitempty"`
+	TokenID  string                 `json:"token_id,omitempty"`
+	Actor    *auth.Actor            `json:"actor,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+}
+
+type echoContextKey struct{}
+
+// echoRecord collects the policies applied to a request bound for an echo target
+type echoRecord struct {
+	mu       sync.Mutex
+	policies []string
+}
+
+// withEchoRecord returns a request carrying an empty echo record
+func withEchoRecord(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), echoContextKey{}, &echoRecord{}))
+}
+
+// echoRecordFromContext returns the request's echo record, or nil
+func echoRecordFromContext(ctx context.Context) *echoRecord {
+	record, _ := ctx.Value(echoContextKey{}).(*echoRecord)
+	return record
+}
+
+// add records an applied policy; it does nothing on a nil record
+func (record *echoRecord) add(format string, args ...interface{}) {
+	if record == nil {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.policies = append(record.policies, fmt.Sprintf(format, args...))
+}
+
+// snapshot returns a copy of the recorded policies
+func (record *echoRecord) snapshot() []string {
+	if record == nil {
+		return []string{}
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	return append([]string{}, record.policies...)
+}
+
+// echoTransport answers outbound requests with their own description instead
+// of sending them over the network
+type echoTransport struct {
+	upstream *Upstream
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *echoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	echo := t.upstream.Echo
+	record := echoRecordFromContext(req.Context())
+	if t.upstream.Auth != nil {
+		record.add("upstream_auth %s", authenticatorKind(t.upstream.Auth))
+	}
+
+	var body []byte
+	var size int64
+	if req.Body != nil && req.Body != http.NoBody {
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, io.LimitReader(req.Body, echo.MaxBodySize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		rest, err := io.Copy(io.Discard, req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body.Close()
+		body, size = buf.Bytes(), n+rest
+	}
+
+	response := &EchoResponse{
+		Upstream:      t.upstream.Name,
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		Headers:       echo.Redactor.Header(req.Header),
+		BodyBytes:     size,
+		BodyTruncated: int64(len(body)) < size,
+		Identity:      echoIdentity(auth.GetUserFromContext(req)),
+		Policies:      record.snapshot(),
+	}
+	if route := mux.CurrentRoute(req); route != nil {
+		response.Route, _ = route.GetPathTemplate()
+	}
+	if host := req.Host; host != "" && host != req.URL.Host {
+		response.Headers["Host"] = []string{host}
+	}
+	if len(body) > 0 {
+		masked := echo.Redactor.Body(body, req.Header.Get("Content-Type"))
+		if utf8.Valid(masked) {
+			response.Body = string(masked)
+		} else {
+			response.BodyBase64 = base64.StdEncoding.EncodeToString(masked)
+		}
+	}
+
+	encoded, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}, "Cache-Control": {"no-store"}},
+		Body:          io.NopCloser(bytes.NewReader(encoded)),
+		ContentLength: int64(len(encoded)),
+		Request:       req,
+	}, nil
+}
+
+// echoIdentity describes an authenticated caller, or returns nil
+func echoIdentity(userCtx *auth.UserContext) *EchoIdentity {
+	if userCtx == nil {
+		return nil
+	}
+	return &EchoIdentity{
+		UserID:   userCtx.UserID,
+		Username: userCtx.Username,
+		AuthType: userCtx.AuthType,
+		Issuer:   userCtx.Issuer,
+		Roles:    userCtx.Roles,
+		Groups:   userCtx.Groups,
+		Scopes:   userCtx.Scopes,
+		TokenID:  userCtx.TokenID,
+		Actor:    userCtx.Actor,
+		Claims:   userCtx.Claims,
+	}
+}
+
+// recordPolicyDecision records the OPA decision made for the request, if any
+func recordPolicyDecision(record *echoRecord, r *http.Request) {
+	decision := policy.GetDecisionFromContext(r)
+	if record == nil || decision == nil {
+		return
+	}
+	if decision.Reason != "" {
+		record.add("opa allow (%s)", decision.Reason)
+	} else {
+		record.add("opa allow")
+	}
+}
+
+// authenticatorKind names the kind of upstream credentials
+func authenticatorKind(a Authenticator) string {
+	switch a.(type) {
+	case *APIKeyAuth:
+		return "api_key"
+	case *BasicAuth:
+		return "basic"
+	case *ClientCredentials:
+		return "oauth2"
+	}
+	return fmt.Sprintf("%T", a)
+}
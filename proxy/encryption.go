@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxEncryptedBody bounds the bodies encrypted or decrypted in memory
+const maxEncryptedBody = 32 << 20
+
+// joseMediaType is the content type of JWE compact serialized bodies
+const joseMediaType = "application/jose"
+
+// PayloadEncryption encrypts request bodies sent to an upstream and decrypts
+// its responses, using JWE compact serialization with a direct AES-GCM key
+// ("alg":"dir"). The upstream must encrypt its responses with the same key.
+type PayloadEncryption struct {
+	Key   []byte   // 16, 24 or 32 bytes for A128GCM, A192GCM or A256GCM
+	KeyID string   // Optional "kid" header identifying the key
+	Paths []string // Upstream path prefixes to encrypt; empty encrypts every route
+}
+
+// jweHeader is the protected header of an encrypted body
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	ContentType string `json:"cty,omitempty"`
+	KeyID       string `json:"kid,omitempty"`
+}
+
+// applies reports whether a path relative to the upstream is encrypted
+func (e *PayloadEncryption) applies(path string) bool {
+	if len(e.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range e.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryption returns the JWE "enc" value for the key size
+func (e *PayloadEncryption) encryption() string {
+	return fmt.Sprintf("A%dGCM", len(e.Key)*8)
+}
+
+// Encrypt seals a body as a JWE compact serialization, recording its content type
+func (e *PayloadEncryption) Encrypt(plaintext []byte, contentType string) (string, error) {
+	gcm, err := e.aead()
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(&jweHeader{
+		Algorithm:   "dir",
+		Encryption:  e.encryption(),
+		ContentType: contentType,
+		KeyID:       e.KeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(header)
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", fmt.Errorf("failed to generate IV: %w", err)
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		protected,
+		"", // No encrypted key with direct encryption
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypt opens a JWE compact serialization, returning the body and its content type
+func (e *PayloadEncryption) Decrypt(compact string) ([]byte, string, error) {
+	parts := strings.Split(strings.TrimSpace(compact), ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return nil, "", errors.New("not a direct-encryption JWE")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, "", errors.New("invalid JWE header")
+	}
+	var header jweHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, "", errors.New("invalid JWE header")
+	}
+	if header.Algorithm != "dir" || header.Encryption != e.encryption() {
+		return nil, "", fmt.Errorf("unsupported JWE algorithm %s/%s", header.Algorithm, header.Encryption)
+	}
+	if e.KeyID != "" && header.KeyID != "" && header.KeyID != e.KeyID {
+		return nil, "", fmt.Errorf("unknown JWE key %q", header.KeyID)
+	}
+
+	var decoded [3][]byte
+	for i, part := range parts[2:] {
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, "", errors.New("invalid JWE encoding")
+		}
+	}
+	iv, ciphertext, tag := decoded[0], decoded[1], decoded[2]
+
+	gcm, err := e.aead()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, "", errors.New("invalid JWE IV or tag")
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, "", errors.New("JWE decryption failed")
+	}
+	return plaintext, header.ContentType, nil
+}
+
+func (e *PayloadEncryption) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptionTransport encrypts outbound bodies and decrypts upstream
+// responses for the routes covered by the upstream's payload encryption
+type encryptionTransport struct {
+	base     http.RoundTripper
+	upstream *Upstream
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *encryptionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	encryption := t.upstream.Encryption
+	path := strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(t.upstream.Target.Path, "/"))
+	if !encryption.applies(path) {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	// Let the transport negotiate compression so encrypted responses arrive decoded
+	req.Header.Del("Accept-Encoding")
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := readLimited(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		compact, err := encryption.Encrypt(body, req.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt request body: %w", err)
+		}
+		req.Body = io.NopCloser(strings.NewReader(compact))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(compact)), nil
+		}
+		req.ContentLength = int64(len(compact))
+		req.Header.Set("Content-Type", joseMediaType)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := decryptResponse(encryption, resp); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("upstream response: %w", err)
+	}
+	return resp, nil
+}
+
+// decryptResponse replaces an encrypted response body with its plaintext.
+// Plaintext responses are rejected so they never pass unnoticed.
+func decryptResponse(encryption *PayloadEncryption, resp *http.Response) error {
+	body, err := readLimited(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) == 0 {
+		return nil
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != joseMediaType {
+		return errors.New("body is not encrypted")
+	}
+	plaintext, contentType, err := encryption.Decrypt(string(body))
+	if err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(plaintext))
+	resp.ContentLength = int64(len(plaintext))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(plaintext)))
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	} else {
+		resp.Header.Del("Content-Type")
+	}
+	return nil
+}
+
+// readLimited reads a body that is processed in memory
+func readLimited(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxEncryptedBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxEncryptedBody {
+		return nil, fmt.Errorf("body exceeds %d bytes", maxEncryptedBody)
+	}
+	return data, nil
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckHealth probes every upstream and reports "healthy" or the failure for each
+func (p *Proxy) CheckHealth(ctx context.Context) map[string]string {
+	results := make(map[string]string, len(p.upstreams))
+	for _, upstream := range p.upstreams {
+		results[upstream.Name] = "healthy"
+		if err := upstream.probe(ctx); err != nil {
+			results[upstream.Name] = err.Error()
+		}
+	}
+	return results
+}
+
+// probe checks the upstream's base URL, or each backend of a load-balanced
+// upstream; failing backends are skipped by the balancer until they recover
+func (u *Upstream) probe(ctx context.Context) error {
+	if u.Balancer == nil {
+		return u.probeURL(ctx, u.Target)
+	}
+
+	var failures []string
+	for _, backend := range u.Balancer.Backends {
+		if err := u.probeURL(ctx, backend.URL); err != nil {
+			u.Balancer.fail(backend, err)
+			failures = append(failures, backend.URL.Redacted()+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d backends unhealthy: %s", len(failures), len(u.Balancer.Backends), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// probeURL sends a GET to target with the upstream's credentials and TLS
+// settings; any response below 500 counts as healthy
+func (u *Upstream) probeURL(ctx context.Context, target *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.handler.Transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unhealthy: status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// handlers holds the Go handlers that inprocess upstreams can serve
+var handlers = struct {
+	sync.RWMutex
+	byName map[string]http.Handler
+}{byName: make(map[string]http.Handler)}
+
+// RegisterHandler makes a handler available to inprocess upstreams under a
+// name. Registering a name again replaces its handler for upstreams created
+// afterwards.
+func RegisterHandler(name string, handler http.Handler) {
+	handlers.Lock()
+	defer handlers.Unlock()
+	handlers.byName[name] = handler
+}
+
+// LookupHandler returns the handler registered under a name, or nil
+func LookupHandler(name string) http.Handler {
+	handlers.RLock()
+	defer handlers.RUnlock()
+	return handlers.byName[name]
+}
+
+// RegisteredHandlers returns the names of the registered handlers, sorted
+func RegisteredHandlers() []string {
+	handlers.RLock()
+	defer handlers.RUnlock()
+	names := make([]string, 0, len(handlers.byName))
+	for name := range handlers.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handlerTransport serves outbound requests with a Go handler in the gateway
+// process instead of sending them over the network. The response body is
+// streamed from the handler as it writes.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Handlers expect a server request
+	in := req.Clone(req.Context())
+	in.RequestURI = req.URL.RequestURI()
+	if in.Body == nil {
+		in.Body = http.NoBody
+	}
+
+	body, pipe := io.Pipe()
+	w := &pipeResponseWriter{
+		header: make(http.Header),
+		pipe:   pipe,
+		ready:  make(chan struct{}),
+	}
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				w.fail(fmt.Errorf("in-process handler panicked: %v", p))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			pipe.Close()
+		}()
+		t.handler.ServeHTTP(w, in)
+	}()
+
+	select {
+	case <-w.ready:
+	case <-req.Context().Done():
+		body.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	resp := &http.Response{
+		Status:        strconv.Itoa(w.status) + " " + http.StatusText(w.status),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          body,
+		ContentLength: -1,
+		Request:       req,
+	}
+	if length, err := strconv.ParseInt(w.sent.Get("Content-Length"), 10, 64); err == nil {
+		resp.ContentLength = length
+	}
+	return resp, nil
+}
+
+// pipeResponseWriter passes a handler's response to the transport. The
+// headers are handed over once written and the body through a pipe.
+type pipeResponseWriter struct {
+	header http.Header
+	pipe   *io.PipeWriter
+	ready  chan struct{} // Closed once the status and headers are set, or the handler failed first
+
+	once   sync.Once
+	status int
+	sent   http.Header // Headers as they were when the status was written
+	err    error       // Set when the handler failed before writing headers
+}
+
+// Header implements http.ResponseWriter
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.sent = w.header.Clone()
+		close(w.ready)
+	})
+}
+
+// Write implements http.ResponseWriter
+func (w *pipeResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pipe.Write(p)
+}
+
+// Flush implements http.Flusher. Writes reach the reader unbuffered.
+func (w *pipeResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// fail reports a handler failure as a transport error before the headers are
+// written, or cuts the body short after
+func (w *pipeResponseWriter) fail(err error) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.ready)
+	})
+	w.pipe.CloseWithError(err)
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// Locality places the gateway or a backend in a region and zone
+type Locality struct {
+	Region string
+	Zone   string
+}
+
+// ParseLocality parses "region/zone", or a bare "zone"
+func ParseLocality(s string) Locality {
+	if region, zone, ok := strings.Cut(s, "/"); ok {
+		return Locality{Region: region, Zone: zone}
+	}
+	return Locality{Zone: s}
+}
+
+// Distances between localities, from the gateway's own zone outwards
+const (
+	sameZone = iota
+	sameRegion
+	remote
+)
+
+// localityLabels names the distances in metrics
+var localityLabels = []string{"same_zone", "same_region", "remote"}
+
+// rank returns how far other is from l. Zones match when either side leaves
+// the region out, as zone names usually include it.
+func (l Locality) rank(other Locality) int {
+	if l.Zone != "" && l.Zone == other.Zone && (l.Region == other.Region || l.Region == "" || other.Region == "") {
+		return sameZone
+	}
+	if l.Region != "" && l.Region == other.Region {
+		return sameRegion
+	}
+	return remote
+}
+
+// PreferLocality groups the backends by their distance from local so that
+// requests go to the closest healthy ones, spilling over to farther ones as
+// health degrades. Call it before the balancer serves requests.
+func (b *Balancer) PreferLocality(local Locality, overprovision float64) {
+	b.Local = local
+	b.Overprovision = overprovision
+
+	groups := make([][]*Backend, remote+1)
+	for _, backend := range b.Backends {
+		backend.rank = local.rank(backend.Locality)
+		groups[backend.rank] = append(groups[backend.rank], backend)
+	}
+	b.pools = nil
+	for rank, backends := range groups {
+		if len(backends) > 0 {
+			b.pools = append(b.pools, newPool(rank, backends))
+		}
+	}
+}
+
+// choosePool returns the pool a request starts from, for spill in [0, 1).
+// Each pool takes the share of traffic its healthy backends can carry, scaled
+// by the overprovisioning factor, and passes the rest to the next pool; a
+// pool with 1/overprovision of its backends healthy still takes everything.
+func (b *Balancer) choosePool(spill float64, now time.Time) int {
+	if len(b.pools) == 1 {
+		return 0
+	}
+
+	carried := 0.0
+	for i, p := range b.pools {
+		carried += math.Min(1-carried, p.healthyShare(now)*b.Overprovision)
+		if spill < carried {
+			return i
+		}
+	}
+	return 0
+}
+
+// healthyShare returns the fraction of the pool's backends that are healthy
+func (p *pool) healthyShare(now time.Time) float64 {
+	healthy := 0
+	for _, backend := range p.backends {
+		if backend.healthy(now) {
+			healthy++
+		}
+	}
+	return float64(healthy) / float64(len(p.backends))
+}
+
+// LocalityMetrics reports how far proxied requests travel from the gateway
+type LocalityMetrics struct {
+	requests *metrics.CounterVec
+}
+
+// NewLocalityMetrics registers the locality metrics
+func NewLocalityMetrics(reg *metrics.Registry) *LocalityMetrics {
+	return &LocalityMetrics{
+		requests: reg.NewCounterVec("gateway_upstream_locality_requests_total",
+			"Requests sent to backends in the gateway's zone (same_zone), another zone of its region (same_region) or another region (remote).", "upstream", "locality"),
+	}
+}
+
+// record counts a request to backend when the upstream prefers local backends
+func (m *LocalityMetrics) record(upstream string, b *Balancer, backend *Backend) {
+	if b.Local == (Locality{}) {
+		return
+	}
+	m.requests.Inc(upstream, localityLabels[backend.rank])
+}
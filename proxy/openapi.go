@@ -0,0 +1,447 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxViolations bounds the schema violations reported for one response
+const maxViolations = 10
+
+// OpenAPIDocument is the subset of an OpenAPI 3 document describing an
+// upstream's request and response bodies
+type OpenAPIDocument struct {
+	paths         []*pathItem
+	schemas       map[string]*Schema
+	responses     map[string]*apiResponse
+	requestBodies map[string]*requestBody
+}
+
+// pathItem is one templated path with its operations by lowercase method
+type pathItem struct {
+	template   string
+	pattern    *regexp.Regexp
+	params     int
+	operations map[string]*operation
+}
+
+type operation struct {
+	RequestBody *requestBody            `json:"requestBody"`
+	Responses   map[string]*apiResponse `json:"responses"`
+}
+
+type requestBody struct {
+	Ref      string                `json:"$ref"`
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type apiResponse struct {
+	Ref     string                     `json:"$ref"`
+	Headers map[string]json.RawMessage `json:"headers"`
+	Content map[string]*mediaType      `json:"content"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema used to validate response bodies
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaTypes        `json:"type"`
+	Nullable             bool               `json:"nullable"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []json.RawMessage  `json:"enum"`
+	AllOf                []*Schema          `json:"allOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+}
+
+// schemaTypes accepts both the OpenAPI 3.0 single type and the 3.1 type list
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// LoadOpenAPI reads an OpenAPI 3 document in JSON format
+func LoadOpenAPI(path string) (*OpenAPIDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI document: %w", err)
+	}
+
+	var raw struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas       map[string]*Schema      `json:"schemas"`
+			Responses     map[string]*apiResponse `json:"responses"`
+			RequestBodies map[string]*requestBody `json:"requestBodies"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document %s: %w", path, err)
+	}
+	if !strings.HasPrefix(raw.OpenAPI, "3.") {
+		return nil, fmt.Errorf("invalid OpenAPI document %s: only OpenAPI 3 is supported", path)
+	}
+
+	doc := &OpenAPIDocument{
+		schemas:       raw.Components.Schemas,
+		responses:     raw.Components.Responses,
+		requestBodies: raw.Components.RequestBodies,
+	}
+	for template, item := range raw.Paths {
+		parsed := &pathItem{template: template, operations: make(map[string]*operation)}
+		for method, data := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			default:
+				continue // Path-level parameters, summary and extensions
+			}
+			var op operation
+			if err := json.Unmarshal(data, &op); err != nil {
+				return nil, fmt.Errorf("invalid OpenAPI document %s: %s %s: %w", path, method, template, err)
+			}
+			parsed.operations[method] = &op
+		}
+		parsed.pattern, parsed.params = compilePathTemplate(template)
+		doc.paths = append(doc.paths, parsed)
+	}
+
+	// Concrete paths take precedence over templated ones
+	sort.Slice(doc.paths, func(i, j int) bool {
+		if doc.paths[i].params != doc.paths[j].params {
+			return doc.paths[i].params < doc.paths[j].params
+		}
+		return len(doc.paths[i].template) > len(doc.paths[j].template)
+	})
+
+	return doc, nil
+}
+
+// compilePathTemplate turns a path such as /users/{id} into a regular
+// expression, returning it with the number of parameters
+func compilePathTemplate(template string) (*regexp.Regexp, int) {
+	var pattern strings.Builder
+	params := 0
+	pattern.WriteString("^")
+	for rest := template; rest != ""; {
+		open := strings.Index(rest, "{")
+		end := strings.Index(rest, "}")
+		if open < 0 || end < open {
+			pattern.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		pattern.WriteString(regexp.QuoteMeta(rest[:open]))
+		pattern.WriteString("[^/]+")
+		params++
+		rest = rest[end+1:]
+	}
+	pattern.WriteString("/?$")
+	return regexp.MustCompile(pattern.String()), params
+}
+
+// operation finds the documented operation for a request path and method
+func (d *OpenAPIDocument) operation(path, method string) (*operation, error) {
+	for _, item := range d.paths {
+		if item.pattern.MatchString(path) {
+			op := item.operations[strings.ToLower(method)]
+			if op == nil {
+				return nil, fmt.Errorf("%s %s is not documented", method, item.template)
+			}
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("path %s is not documented", path)
+}
+
+// ValidateRequest checks a JSON request body against the schema documented
+// for the path and method. It returns the violations found, or an error when
+// the operation is not documented or the body is not JSON.
+func (d *OpenAPIDocument) ValidateRequest(path, method string, body []byte) ([]string, error) {
+	op, err := d.operation(path, method)
+	if err != nil {
+		return nil, err
+	}
+	documented := op.RequestBody
+	for i := 0; documented != nil && documented.Ref != "" && i < 10; i++ {
+		documented = d.requestBodies[strings.TrimPrefix(documented.Ref, "#/components/requestBodies/")]
+	}
+	if documented == nil {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return []string{"$ no request body is documented"}, nil
+		}
+		return nil, nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if documented.Required {
+			return []string{"$ is required"}, nil
+		}
+		return nil, nil
+	}
+
+	var schema *Schema
+	for mediaType, content := range documented.Content {
+		if isJSON(mediaType) {
+			schema = content.Schema
+			break
+		}
+	}
+	var value interface{}
+	if err := decodeJSON(body, &value); err != nil {
+		return nil, fmt.Errorf("request body is not valid JSON: %w", err)
+	}
+	check := &schemaCheck{doc: d}
+	check.sanitize(value, schema, "$")
+	return check.violations, nil
+}
+
+// response finds the documented response for a request path, method and
+// status, trying the exact status, its class (2XX) and then default
+func (d *OpenAPIDocument) response(path, method string, status int) (*apiResponse, error) {
+	op, err := d.operation(path, method)
+	if err != nil {
+		return nil, err
+	}
+
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + "XX", code[:1] + "xx", "default"} {
+		if response, ok := op.Responses[key]; ok {
+			for i := 0; response.Ref != "" && i < 10; i++ {
+				response = d.responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+				if response == nil {
+					return nil, fmt.Errorf("unresolved response reference %s", op.Responses[key].Ref)
+				}
+			}
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("status %d is not documented for %s %s", status, method, path)
+}
+
+// resolve follows schema references to components
+func (d *OpenAPIDocument) resolve(schema *Schema) *Schema {
+	for i := 0; schema != nil && schema.Ref != "" && i < 10; i++ {
+		schema = d.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// schemaCheck validates one JSON value and removes undeclared properties
+type schemaCheck struct {
+	doc        *OpenAPIDocument
+	violations []string
+	stripped   int
+}
+
+// sanitize validates a decoded JSON value against a schema and returns it
+// with the properties the schema does not declare removed
+func (c *schemaCheck) sanitize(value interface{}, schema *Schema, at string) interface{} {
+	schema = c.doc.resolve(schema)
+	if schema == nil {
+		return value
+	}
+
+	if value == nil {
+		if !schema.Nullable && len(schema.Type) > 0 && !schema.allows("null") {
+			c.violate(at, "must not be null")
+		}
+		return value
+	}
+
+	// A value described by alternatives is sanitized by the one it matches
+	if len(schema.AnyOf) > 0 || len(schema.OneOf) > 0 {
+		alternatives := make([]*Schema, 0, len(schema.AnyOf)+len(schema.OneOf))
+		alternatives = append(append(alternatives, schema.AnyOf...), schema.OneOf...)
+		return c.sanitizeAlternatives(value, alternatives, at)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if !c.checkType(schema, "object", at) {
+			return value
+		}
+		return c.sanitizeObject(v, schema, at)
+	case []interface{}:
+		if !c.checkType(schema, "array", at) {
+			return value
+		}
+		items := c.itemSchema(schema)
+		for i, item := range v {
+			v[i] = c.sanitize(item, items, fmt.Sprintf("%s[%d]", at, i))
+		}
+	case string:
+		c.checkType(schema, "string", at)
+	case bool:
+		c.checkType(schema, "boolean", at)
+	case json.Number:
+		typ := "number"
+		if f, ok := new(big.Float).SetString(v.String()); ok && f.IsInt() {
+			typ = "integer"
+		}
+		c.checkType(schema, typ, at)
+	}
+
+	if len(schema.Enum) > 0 {
+		encoded, _ := json.Marshal(value)
+		matched := false
+		for _, allowed := range schema.Enum {
+			var normalized interface{}
+			json.Unmarshal(allowed, &normalized)
+			if candidate, _ := json.Marshal(normalized); string(candidate) == string(encoded) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			c.violate(at, "is not one of the allowed values")
+		}
+	}
+
+	return value
+}
+
+// sanitizeObject checks required properties and removes undeclared ones.
+// Once a schema declares properties, others are only kept when
+// additionalProperties is true or a schema; free-form objects are kept whole
+// unless additionalProperties is false.
+func (c *schemaCheck) sanitizeObject(object map[string]interface{}, schema *Schema, at string) map[string]interface{} {
+	properties := make(map[string]*Schema)
+	var required []string
+	var additional json.RawMessage
+	c.collectObject(schema, properties, &required, &additional, 0)
+
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			c.violate(at+"."+name, "is required")
+		}
+	}
+
+	var additionalSchema *Schema
+	allowAdditional := len(properties) == 0
+	if len(additional) > 0 {
+		if json.Unmarshal(additional, &allowAdditional) != nil {
+			allowAdditional = json.Unmarshal(additional, &additionalSchema) == nil
+		}
+	}
+
+	for name, property := range object {
+		if declared, ok := properties[name]; ok {
+			object[name] = c.sanitize(property, declared, at+"."+name)
+			continue
+		}
+		switch {
+		case additionalSchema != nil:
+			object[name] = c.sanitize(property, additionalSchema, at+"."+name)
+		case !allowAdditional:
+			delete(object, name)
+			c.stripped++
+		}
+	}
+	return object
+}
+
+// collectObject merges the properties of a schema and its allOf members
+func (c *schemaCheck) collectObject(schema *Schema, properties map[string]*Schema, required *[]string, additional *json.RawMessage, depth int) {
+	schema = c.doc.resolve(schema)
+	if schema == nil || depth > 10 {
+		return
+	}
+	for name, property := range schema.Properties {
+		properties[name] = property
+	}
+	*required = append(*required, schema.Required...)
+	if len(schema.AdditionalProperties) > 0 {
+		*additional = schema.AdditionalProperties
+	}
+	for _, member := range schema.AllOf {
+		c.collectObject(member, properties, required, additional, depth+1)
+	}
+}
+
+// sanitizeAlternatives keeps the first anyOf/oneOf alternative the value
+// matches, sanitized by that alternative
+func (c *schemaCheck) sanitizeAlternatives(value interface{}, alternatives []*Schema, at string) interface{} {
+	original, _ := json.Marshal(value)
+	for _, alternative := range alternatives {
+		var candidate interface{}
+		decodeJSON(original, &candidate)
+
+		trial := &schemaCheck{doc: c.doc}
+		candidate = trial.sanitize(candidate, alternative, at)
+		if len(trial.violations) == 0 {
+			c.stripped += trial.stripped
+			return candidate
+		}
+	}
+	c.violate(at, "does not match any of the allowed schemas")
+	return value
+}
+
+// itemSchema returns the array item schema, including from allOf members
+func (c *schemaCheck) itemSchema(schema *Schema) *Schema {
+	if schema.Items != nil {
+		return schema.Items
+	}
+	for _, member := range schema.AllOf {
+		if member = c.doc.resolve(member); member != nil && member.Items != nil {
+			return member.Items
+		}
+	}
+	return nil
+}
+
+// checkType records a violation when the schema does not allow the type
+func (c *schemaCheck) checkType(schema *Schema, typ, at string) bool {
+	if len(schema.Type) == 0 || schema.allows(typ) {
+		return true
+	}
+	c.violate(at, fmt.Sprintf("must be %s, not %s", strings.Join(schema.Type, " or "), typ))
+	return false
+}
+
+func (c *schemaCheck) violate(at, message string) {
+	if len(c.violations) < maxViolations {
+		c.violations = append(c.violations, at+" "+message)
+	}
+}
+
+// allows reports whether the schema declares the type; integers are numbers
+func (s *Schema) allows(typ string) bool {
+	for _, declared := range s.Type {
+		if declared == typ || (declared == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSON decodes keeping numbers exact so re-encoded bodies are unchanged
+func decodeJSON(data []byte, value *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
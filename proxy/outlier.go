@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// OutlierDetection ejects backends whose error rate or latency over an
+// interval crosses a threshold. It works from proxied traffic alone,
+// independently of health probes.
+type OutlierDetection struct {
+	Interval           time.Duration // How often backends are evaluated
+	MinRequests        int64         // Requests a backend needs in an interval to be evaluated
+	ErrorRate          float64       // Fraction of 5xx responses and connection errors that ejects; 0 disables
+	Latency            time.Duration // Mean response latency that ejects; 0 disables
+	EjectionTime       time.Duration // Ejection length, multiplied by consecutive ejections
+	MaxEjectionTime    time.Duration // Upper bound for a single ejection
+	MaxEjectionPercent int           // Share of backends that may be ejected at once
+}
+
+// outlierStats counts a backend's requests in the current interval
+type outlierStats struct {
+	requests atomic.Int64
+	failures atomic.Int64
+	latency  atomic.Int64 // Sum of response latencies in nanoseconds
+
+	ejections int // Consecutive ejections; only touched by the detector
+}
+
+// record counts one response or failed request to the backend
+func (s *outlierStats) record(latency time.Duration, failed bool) {
+	s.requests.Add(1)
+	s.latency.Add(int64(latency))
+	if failed {
+		s.failures.Add(1)
+	}
+}
+
+// OutlierMetrics reports backend ejections
+type OutlierMetrics struct {
+	ejections *metrics.CounterVec
+	ejected   *metrics.GaugeVec
+}
+
+// NewOutlierMetrics registers the outlier detection metrics
+func NewOutlierMetrics(reg *metrics.Registry) *OutlierMetrics {
+	return &OutlierMetrics{
+		ejections: reg.NewCounterVec("gateway_upstream_backend_ejections_total",
+			"Backends ejected by outlier detection, by upstream and backend.", "upstream", "backend"),
+		ejected: reg.NewGaugeVec("gateway_upstream_backend_ejected",
+			"Whether a backend is currently ejected by outlier detection (1) or not (0).", "upstream", "backend"),
+	}
+}
+
+// detectOutliers evaluates the upstream's backends every interval
+func (u *Upstream) detectOutliers(m *OutlierMetrics) {
+	ticker := time.NewTicker(u.Balancer.Outliers.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		u.Balancer.evaluateOutliers(u.Name, now, m)
+	}
+}
+
+// evaluateOutliers ejects backends that crossed a threshold in the last
+// interval and resets the interval's counters
+func (b *Balancer) evaluateOutliers(upstream string, now time.Time, m *OutlierMetrics) {
+	detection := b.Outliers
+	maxEjected := len(b.Backends) * detection.MaxEjectionPercent / 100
+
+	ejected := 0
+	for _, backend := range b.Backends {
+		if backend.ejectedUntil.Load() > now.UnixNano() {
+			ejected++
+		} else {
+			m.ejected.Set(0, upstream, backend.URL.Redacted())
+		}
+	}
+
+	for _, backend := range b.Backends {
+		requests := backend.stats.requests.Swap(0)
+		failures := backend.stats.failures.Swap(0)
+		latency := time.Duration(backend.stats.latency.Swap(0))
+
+		if backend.ejectedUntil.Load() > now.UnixNano() || requests < detection.MinRequests || requests == 0 {
+			continue
+		}
+
+		var reason string
+		if rate := float64(failures) / float64(requests); detection.ErrorRate > 0 && rate >= detection.ErrorRate {
+			reason = fmt.Sprintf("error rate %.0f%% over %d requests", rate*100, requests)
+		} else if mean := latency / time.Duration(requests); detection.Latency > 0 && mean >= detection.Latency {
+			reason = fmt.Sprintf("mean latency %s over %d requests", mean.Round(time.Millisecond), requests)
+		}
+		if reason == "" {
+			backend.stats.ejections = 0
+			continue
+		}
+
+		if ejected >= maxEjected {
+			log.Printf("Backend %s of upstream %s is an outlier (%s) but is kept; %d%% of backends are already ejected",
+				backend.URL.Redacted(), upstream, reason, detection.MaxEjectionPercent)
+			continue
+		}
+
+		backend.stats.ejections++
+		duration := detection.EjectionTime * time.Duration(backend.stats.ejections)
+		if detection.MaxEjectionTime > 0 && duration > detection.MaxEjectionTime {
+			duration = detection.MaxEjectionTime
+		}
+		backend.ejectedUntil.Store(now.Add(duration).UnixNano())
+		ejected++
+
+		m.ejections.Inc(upstream, backend.URL.Redacted())
+		m.ejected.Set(1, upstream, backend.URL.Redacted())
+		log.Printf("Ejected backend %s of upstream %s for %s: %s", backend.URL.Redacted(), upstream, duration, reason)
+	}
+}
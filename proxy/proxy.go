@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"api-gateway/budget"
+	"api-gateway/metrics"
+)
+
+// Upstream represents a backend service behind the gateway
+type Upstream struct {
+	Name        string
+	Target      *url.URL
+	PathPrefix  string
+	StripPrefix bool
+	Auth        Authenticator // Optional service-to-service credentials
+	TLS         *TLSFiles     // Optional mutual TLS settings
+	Cookies     *CookiePolicy // Optional cookie handling
+	Rewrites    []*RewriteRule
+	ClaimRoutes []*ClaimRoute       // Checked in order; the first match wins
+	Validation  *ResponseValidation // Optional OpenAPI response validation
+	Encryption  *PayloadEncryption  // Optional payload encryption for untrusted networks
+	Balancer    *Balancer           // Optional; spreads requests over several backends instead of Target
+	Objects     *ObjectStore        // Optional; serves objects of an S3-compatible bucket at Target
+	Echo        *EchoTarget         // Optional; reflects requests back instead of sending them to Target
+	Handler     http.Handler        // Optional; serves requests in process instead of sending them to Target
+	Transport   TransportSettings
+
+	handler         *httputil.ReverseProxy
+	localityMetrics *LocalityMetrics
+}
+
+// Config represents reverse proxy configuration
+type Config struct {
+	Upstreams         []*Upstream
+	TLSReloadInterval time.Duration // How often TLS certificate files are checked for rotation
+}
+
+// Proxy routes requests to upstreams by longest matching path prefix
+type Proxy struct {
+	upstreams []*Upstream
+}
+
+// New creates a reverse proxy for the configured upstreams
+func New(config *Config, reg *metrics.Registry) (*Proxy, error) {
+	sorted := append([]*Upstream(nil), config.Upstreams...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+	})
+
+	poolMetrics := NewPoolMetrics(reg)
+	validationMetrics := NewValidationMetrics(reg)
+	outlierMetrics := NewOutlierMetrics(reg)
+	localityMetrics := NewLocalityMetrics(reg)
+	for _, upstream := range sorted {
+		upstream := upstream
+		newTransport := func() *http.Transport {
+			transport := upstream.Transport.newTransport()
+			poolMetrics.instrument(upstream.Name, transport)
+			return transport
+		}
+
+		var base http.RoundTripper
+		if upstream.Echo != nil {
+			base = &echoTransport{upstream: upstream}
+		} else if upstream.Handler != nil {
+			base = &handlerTransport{handler: upstream.Handler}
+		} else if upstream.TLS != nil {
+			tlsTransport, err := NewTLSTransport(*upstream.TLS, newTransport, config.TLSReloadInterval)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", upstream.Name, err)
+			}
+			base = tlsTransport
+		} else {
+			base = newTransport()
+		}
+
+		upstream.handler = newReverseProxy(upstream, &meteredTransport{
+			base:     base,
+			upstream: upstream.Name,
+			metrics:  poolMetrics,
+		}, validationMetrics)
+		upstream.localityMetrics = localityMetrics
+
+		if upstream.Balancer != nil && upstream.Balancer.Outliers != nil {
+			go upstream.detectOutliers(outlierMetrics)
+		}
+	}
+
+	return &Proxy{upstreams: sorted}, nil
+}
+
+// Upstreams returns the configured upstreams
+func (p *Proxy) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Match returns the upstream serving the path, or nil
+func (p *Proxy) Match(path string) *Upstream {
+	for _, upstream := range p.upstreams {
+		if HasPathPrefix(path, upstream.PathPrefix) {
+			return upstream
+		}
+	}
+	return nil
+}
+
+// HasPathPrefix reports whether path lies under prefix, matching whole path
+// segments so a prefix of /api does not take /apix
+func HasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// ServeHTTP forwards the request to the matching upstream
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream := p.Match(r.URL.Path)
+	if upstream == nil {
+		http.Error(w, `{"error":"No upstream","details":"No upstream is configured for this path"}`, http.StatusNotFound)
+		return
+	}
+	upstream.serve(w, r)
+}
+
+// serve forwards the request, choosing a backend first when the upstream is
+// load balanced. Requests sent elsewhere by a claim route skip the balancer.
+func (u *Upstream) serve(w http.ResponseWriter, r *http.Request) {
+	if u.Objects != nil {
+		u.serveObject(w, r)
+		return
+	}
+	if u.Echo != nil {
+		r = withEchoRecord(r)
+	}
+	if u.Balancer != nil {
+		if route := matchClaimRoute(u.ClaimRoutes, r); route == nil || route.Target == nil {
+			backend := u.Balancer.Pick(r)
+			u.localityMetrics.record(u.Name, u.Balancer, backend)
+			backend.inFlight.Add(1)
+			defer backend.inFlight.Add(-1)
+			r = r.WithContext(withBackend(r.Context(), backend))
+		}
+	}
+	u.handler.ServeHTTP(w, r)
+}
+
+// newReverseProxy builds the reverse proxy for one upstream
+func newReverseProxy(upstream *Upstream, roundTripper http.RoundTripper, validationMetrics *ValidationMetrics) *httputil.ReverseProxy {
+	if upstream.Encryption != nil {
+		roundTripper = &encryptionTransport{base: roundTripper, upstream: upstream}
+	}
+	if upstream.Auth != nil {
+		roundTripper = &authTransport{base: roundTripper, auth: upstream.Auth}
+	}
+	if upstream.Objects != nil {
+		roundTripper = &authTransport{base: roundTripper, auth: upstream.Objects.Signer}
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			record := echoRecordFromContext(pr.In.Context())
+			recordPolicyDecision(record, pr.In)
+			if upstream.StripPrefix {
+				pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, upstream.PathPrefix)
+				pr.Out.URL.RawPath = ""
+				record.add("strip_prefix %s", upstream.PathPrefix)
+			}
+			if rule := rewriteURL(upstream.Rewrites, pr.Out.URL); rule != nil {
+				record.add("rewrite %s", rule.Name)
+			}
+			target := upstream.Target
+			if backend, _ := backendFromContext(pr.In.Context()); backend != nil {
+				target = backend.URL
+			}
+			if route := routeByClaims(upstream.ClaimRoutes, pr.In, pr.Out.Header); route != nil {
+				record.add("claim_route %s", route.Name)
+				if route.Target != nil {
+					target = route.Target
+				}
+			}
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			budget.SetHeader(pr.In.Context(), pr.Out.Header)
+
+			// Client credentials were consumed by the gateway and are not
+			// forwarded; upstreams authenticate the gateway instead
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("X-API-Key")
+
+			if upstream.Objects != nil {
+				upstream.Objects.rewriteObjectRequest(pr, target)
+			}
+
+			if upstream.Cookies != nil {
+				upstream.Cookies.stripRequestCookies(pr.Out)
+				record.add("cookie_policy")
+			}
+
+			// Let the transport negotiate and decode compression so validated
+			// bodies can be read; the gateway compresses responses itself
+			if upstream.Validation != nil {
+				pr.Out.Header.Del("Accept-Encoding")
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if backend, start := backendFromContext(resp.Request.Context()); backend != nil {
+				backend.stats.record(time.Since(start), resp.StatusCode >= http.StatusInternalServerError)
+			}
+			if upstream.Validation != nil {
+				if err := upstream.Validation.validateResponse(upstream, resp, validationMetrics); err != nil {
+					return err
+				}
+			}
+			if upstream.Cookies != nil {
+				upstream.Cookies.rewriteResponseCookies(resp)
+			}
+			return nil
+		},
+		Transport: roundTripper,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var invalid *invalidResponseError
+			if errors.As(err, &invalid) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadGateway)
+				fmt.Fprintf(w, `{"error":"Bad Gateway","details":"Upstream %s returned a response that does not match its API description"}`, upstream.Name)
+				return
+			}
+
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				fmt.Fprintf(w, `{"error":"Request body too large","details":"Request bodies on this route are limited to %d bytes"}`, tooLarge.Limit)
+				return
+			}
+
+			log.Printf("Proxy error for upstream %s: %v", upstream.Name, err)
+			// Requests canceled by the client or cut off by the gateway say
+			// nothing about the backend
+			if backend, start := backendFromContext(r.Context()); backend != nil && r.Context().Err() == nil {
+				backend.stats.record(time.Since(start), true)
+				upstream.Balancer.fail(backend, err)
+			}
+
+			status := http.StatusBadGateway
+			if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) || r.Context().Err() == context.DeadlineExceeded {
+				status = http.StatusGatewayTimeout
+			}
+			details := fmt.Sprintf("Upstream %s is unavailable", upstream.Name)
+			if cause := context.Cause(r.Context()); cause != nil && cause != r.Context().Err() {
+				// The gateway gave the request a deadline with its own explanation
+				details = cause.Error()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"error":"%s","details":%q}`, http.StatusText(status), details)
+		},
+	}
+}
+
+// authTransport attaches upstream credentials to each outbound request
+type authTransport struct {
+	base http.RoundTripper
+	auth Authenticator
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := t.auth.Apply(req); err != nil {
+		return nil, fmt.Errorf("failed to authenticate to upstream: %w", err)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
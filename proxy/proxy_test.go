@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHasPathPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix string
+		want         bool
+	}{
+		{"/api", "/api", true},
+		{"/api/users", "/api", true},
+		{"/apix", "/api", false},
+		{"/api/", "/api/", true},
+		{"/api/users", "/api/", true},
+		{"/ap", "/api", false},
+		{"/anything", "/", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := HasPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("HasPathPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func FuzzHasPathPrefix(f *testing.F) {
+	f.Add("/api/users", "/api")
+	f.Add("/apix", "/api")
+	f.Add("/api/", "/api/")
+	f.Add("/", "")
+	f.Fuzz(func(t *testing.T, path, prefix string) {
+		got := HasPathPrefix(path, prefix)
+		if got && !strings.HasPrefix(path, prefix) {
+			t.Fatalf("HasPathPrefix(%q, %q) matched a path without the prefix", path, prefix)
+		}
+		// A match ends the prefix on a segment boundary
+		if got && len(path) > len(prefix) && !strings.HasSuffix(prefix, "/") && path[len(prefix)] != '/' {
+			t.Fatalf("HasPathPrefix(%q, %q) matched inside a segment", path, prefix)
+		}
+		if !HasPathPrefix(prefix, prefix) || !HasPathPrefix(prefix+"/"+path, prefix) {
+			t.Fatalf("prefix %q does not match itself or its subpaths", prefix)
+		}
+	})
+}
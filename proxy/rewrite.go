@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/url"
+	"regexp"
+)
+
+// RewriteRule maps public paths to upstream paths. Replacements and added
+// query values may reference capture groups as $1 or ${name}.
+type RewriteRule struct {
+	Name        string
+	Match       *regexp.Regexp    // Matched against the path sent upstream, after prefix stripping
+	Replace     string            // New path; empty keeps the path
+	AddQuery    map[string]string // Query parameters set on the upstream request
+	RemoveQuery []string          // Query parameters removed from the upstream request
+}
+
+// apply rewrites the URL if the rule matches and reports whether it did
+func (rule *RewriteRule) apply(u *url.URL) bool {
+	match := rule.Match.FindStringSubmatchIndex(u.Path)
+	if match == nil {
+		return false
+	}
+
+	path := u.Path
+	if rule.Replace != "" {
+		u.Path = string(rule.Match.ExpandString(nil, rule.Replace, path, match))
+		u.RawPath = ""
+	}
+
+	if len(rule.AddQuery) > 0 || len(rule.RemoveQuery) > 0 {
+		query := u.Query()
+		for _, name := range rule.RemoveQuery {
+			query.Del(name)
+		}
+		for name, value := range rule.AddQuery {
+			query.Set(name, string(rule.Match.ExpandString(nil, value, path, match)))
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return true
+}
+
+// rewriteURL applies the first matching rule and returns it, or nil
+func rewriteURL(rules []*RewriteRule, u *url.URL) *RewriteRule {
+	for _, rule := range rules {
+		if rule.apply(u) {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testRewriteRules version user orders and drop a debug flag
+var testRewriteRules = []*RewriteRule{
+	{
+		Name:        "orders",
+		Match:       regexp.MustCompile(`^/users/(?P<id>[0-9]+)/orders$`),
+		Replace:     "/v2/orders",
+		AddQuery:    map[string]string{"user": "${id}"},
+		RemoveQuery: []string{"debug"},
+	},
+	{
+		Name:    "legacy",
+		Match:   regexp.MustCompile(`^/legacy/(.*)$`),
+		Replace: "/$1",
+	},
+}
+
+func TestRewriteURL(t *testing.T) {
+	tests := []struct {
+		in, want, rule string
+	}{
+		{"/users/42/orders?debug=1&page=2", "/v2/orders?page=2&user=42", "orders"},
+		{"/users/abc/orders", "/users/abc/orders", ""},
+		{"/legacy/a/b?x=1", "/a/b?x=1", "legacy"},
+		{"/other", "/other", ""},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.in)
+		rule := rewriteURL(testRewriteRules, u)
+		name := ""
+		if rule != nil {
+			name = rule.Name
+		}
+		if u.RequestURI() != tt.want || name != tt.rule {
+			t.Errorf("rewrite %s = %s by %q, want %s by %q", tt.in, u.RequestURI(), name, tt.want, tt.rule)
+		}
+	}
+}
+
+func FuzzRewriteURL(f *testing.F) {
+	f.Add("/users/42/orders", "debug=1&page=2")
+	f.Add("/users/42/orders", "user=7&user=8")
+	f.Add("/legacy/a%2Fb", "")
+	f.Add("/legacy/", "%zz")
+	f.Add("/users//orders", ";")
+	f.Fuzz(func(t *testing.T, path, rawQuery string) {
+		u := &url.URL{Path: path, RawQuery: rawQuery}
+		rule := rewriteURL(testRewriteRules, u)
+		switch {
+		case rule == nil:
+			if u.Path != path || u.RawQuery != rawQuery {
+				t.Fatalf("unmatched %q?%q was rewritten to %q?%q", path, rawQuery, u.Path, u.RawQuery)
+			}
+		case rule.Name == "orders":
+			id := strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/orders")
+			query := u.Query()
+			if u.Path != "/v2/orders" || query.Get("user") != id || len(query["user"]) != 1 || query.Has("debug") {
+				t.Fatalf("%q?%q rewritten to %q?%q", path, rawQuery, u.Path, u.RawQuery)
+			}
+		case rule.Name == "legacy":
+			if u.Path != "/"+strings.TrimPrefix(path, "/legacy/") || u.RawQuery != rawQuery {
+				t.Fatalf("%q?%q rewritten to %q?%q", path, rawQuery, u.Path, u.RawQuery)
+			}
+		}
+	})
+}
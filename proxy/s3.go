@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// unsignedPayload stands in for the body hash so bodies stream unbuffered
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// ObjectStore exposes the objects of an S3-compatible bucket under an
+// upstream's path prefix. The gateway signs requests with its own
+// credentials, so callers never see them.
+type ObjectStore struct {
+	Bucket        string
+	KeyPrefix     string        // Prepended to object keys; "{claim}" is replaced by the caller's claim
+	PathStyle     bool          // Address the bucket as <endpoint>/<bucket> instead of <bucket>.<endpoint host>
+	Presign       bool          // Redirect callers to presigned URLs instead of proxying objects
+	PresignExpiry time.Duration // Lifetime of presigned URLs
+	Methods       []string      // Allowed methods, such as GET, HEAD and PUT
+	Signer        *SigV4Signer
+}
+
+type objectKeyContextKey struct{}
+
+// serveObject checks an object request and either redirects it to a
+// presigned URL or passes it on to be proxied
+func (u *Upstream) serveObject(w http.ResponseWriter, r *http.Request) {
+	store := u.Objects
+	allowed := false
+	for _, method := range store.Methods {
+		if r.Method == method {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		w.Header().Set("Allow", strings.Join(store.Methods, ", "))
+		http.Error(w, fmt.Sprintf(`{"error":"Method not allowed","details":"Objects on this route allow %s"}`, strings.Join(store.Methods, ", ")), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, err := u.objectKey(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"Invalid object key","details":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	prefix, ok := store.keyPrefix(r)
+	if !ok {
+		http.Error(w, `{"error":"Forbidden","details":"The token lacks the claims that name this caller's objects"}`, http.StatusForbidden)
+		return
+	}
+	key = prefix + key
+
+	if store.Presign {
+		target := u.Target
+		if route := matchClaimRoute(u.ClaimRoutes, r); route != nil && route.Target != nil {
+			target = route.Target
+		}
+		location := store.Signer.Presign(r.Method, store.objectURL(target, key), store.PresignExpiry, time.Now())
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, location, http.StatusTemporaryRedirect)
+		return
+	}
+
+	u.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), objectKeyContextKey{}, key)))
+}
+
+// objectKey returns the object key a request addresses: its path below the
+// upstream's prefix, after rewrites
+func (u *Upstream) objectKey(r *http.Request) (string, error) {
+	path := *r.URL
+	path.Path = strings.TrimPrefix(r.URL.Path, u.PathPrefix)
+	path.RawPath = ""
+	rewriteURL(u.Rewrites, &path)
+
+	key := strings.TrimPrefix(path.Path, "/")
+	if key == "" {
+		return "", fmt.Errorf("the path names no object")
+	}
+	// Keys are literal in S3, but dot segments could escape the caller's
+	// prefix wherever paths get cleaned
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("object keys must not contain . or .. segments")
+		}
+	}
+	return key, nil
+}
+
+// keyPrefix returns the key prefix with the caller's claims filled in. It
+// reports false when a referenced claim is missing.
+func (s *ObjectStore) keyPrefix(r *http.Request) (string, bool) {
+	if !strings.Contains(s.KeyPrefix, "{") {
+		return s.KeyPrefix, true
+	}
+	var claims map[string]interface{}
+	if userCtx := auth.GetUserFromContext(r); userCtx != nil {
+		claims = userCtx.Claims
+	}
+	ok := true
+	prefix := claimPlaceholder.ReplaceAllStringFunc(s.KeyPrefix, func(placeholder string) string {
+		values := claimValues(claims[placeholder[1:len(placeholder)-1]])
+		if len(values) == 0 || values[0] == "" || strings.Contains(values[0], "/") || values[0] == "." || values[0] == ".." {
+			ok = false
+			return ""
+		}
+		return values[0]
+	})
+	return prefix, ok
+}
+
+// objectURL returns the URL of the object under key at the endpoint
+func (s *ObjectStore) objectURL(endpoint *url.URL, key string) *url.URL {
+	u := *endpoint
+	basePath := strings.TrimSuffix(endpoint.Path, "/")
+	if s.PathStyle {
+		u.Path = basePath + "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + endpoint.Host
+		u.Path = basePath + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	return &u
+}
+
+// rewriteObjectRequest points an outbound request at its object and drops
+// client headers that would change what the gateway's credentials do
+func (s *ObjectStore) rewriteObjectRequest(pr *httputil.ProxyRequest, target *url.URL) {
+	key, _ := pr.In.Context().Value(objectKeyContextKey{}).(string)
+	pr.Out.URL = s.objectURL(target, key)
+	pr.Out.Host = ""
+	for name := range pr.Out.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			pr.Out.Header.Del(name)
+		}
+	}
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Optional, for temporary credentials
+	Region          string
+	Service         string // "s3" for object storage, "sqs" for queues
+}
+
+// Apply implements Authenticator by signing the request's headers. The
+// payload is left unsigned so bodies stream through.
+func (s *SigV4Signer) Apply(req *http.Request) error {
+	return s.sign(req, unsignedPayload)
+}
+
+// ApplyPayload signs the request's headers and its body, for services that
+// do not accept unsigned payloads
+func (s *SigV4Signer) ApplyPayload(req *http.Request, body []byte) error {
+	sum := sha256.Sum256(body)
+	return s.sign(req, hex.EncodeToString(sum[:]))
+}
+
+// sign signs the request's headers with the payload hash
+func (s *SigV4Signer) sign(req *http.Request, payloadHash string) error {
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + req.Header.Get("X-Amz-Date") + "\n"
+	if s.SessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+		canonicalHeaders += "x-amz-security-token:" + s.SessionToken + "\n"
+	}
+
+	// Send the path exactly as it is signed
+	req.URL.RawPath = sigV4Escape(req.URL.Path, false)
+	signature := s.signature(now, req.Method, req.URL.RawPath, canonicalQuery(req.URL.Query()), canonicalHeaders, strings.Join(signed, ";"), payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, s.scope(now), strings.Join(signed, ";"), signature))
+	return nil
+}
+
+// Presign returns u with query parameters that authorize method on it until
+// expiry has passed
+func (s *SigV4Signer) Presign(method string, u *url.URL, expiry time.Duration, now time.Time) string {
+	now = now.UTC()
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKeyID+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	path := sigV4Escape(u.Path, false)
+	signature := s.signature(now, method, path, canonicalQuery(query), "host:"+u.Host+"\n", "host", unsignedPayload)
+	return u.Scheme + "://" + u.Host + path + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + signature
+}
+
+// signature computes the signature of a canonical request
+func (s *SigV4Signer) signature(now time.Time, method, path, query, headers, signedHeaders, payloadHash string) string {
+	canonicalRequest := strings.Join([]string{method, path, query, headers, signedHeaders, payloadHash}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// scope returns the credential scope for the day of now
+func (s *SigV4Signer) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.Region + "/" + s.Service + "/aws4_request"
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(name, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set
+func sigV4Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
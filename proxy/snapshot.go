@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BackendState is the saved failure cooldown and outlier ejection of a backend
+type BackendState struct {
+	DownUntil    time.Time `json:"down_until"`
+	EjectedUntil time.Time `json:"ejected_until"`
+}
+
+// Snapshot returns the backends that are cooling down after failures or
+// ejected as outliers, by upstream name and backend URL
+func (p *Proxy) Snapshot() (any, error) {
+	now := time.Now().UnixNano()
+	states := make(map[string]map[string]BackendState)
+	for _, upstream := range p.upstreams {
+		if upstream.Balancer == nil {
+			continue
+		}
+		for _, backend := range upstream.Balancer.Backends {
+			var state BackendState
+			if until := backend.downUntil.Load(); until > now {
+				state.DownUntil = time.Unix(0, until)
+			}
+			if until := backend.ejectedUntil.Load(); until > now {
+				state.EjectedUntil = time.Unix(0, until)
+			}
+			if state.DownUntil.IsZero() && state.EjectedUntil.IsZero() {
+				continue
+			}
+			if states[upstream.Name] == nil {
+				states[upstream.Name] = make(map[string]BackendState)
+			}
+			states[upstream.Name][backend.URL.String()] = state
+		}
+	}
+	return states, nil
+}
+
+// Restore keeps backends out until their saved cooldowns and ejections end.
+// Backends no longer configured are ignored.
+func (p *Proxy) Restore(data json.RawMessage) error {
+	var states map[string]map[string]BackendState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+
+	for _, upstream := range p.upstreams {
+		if upstream.Balancer == nil {
+			continue
+		}
+		for _, backend := range upstream.Balancer.Backends {
+			state, ok := states[upstream.Name][backend.URL.String()]
+			if !ok {
+				continue
+			}
+			if !state.DownUntil.IsZero() {
+				backend.downUntil.Store(state.DownUntil.UnixNano())
+			}
+			if !state.EjectedUntil.IsZero() {
+				backend.ejectedUntil.Store(state.EjectedUntil.UnixNano())
+			}
+		}
+	}
+	return nil
+}
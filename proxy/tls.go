@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TLSFiles locates the certificates for a mutual-TLS upstream connection
+type TLSFiles struct {
+	CAFile     string // PEM bundle of CAs trusted for the upstream; system roots when empty
+	CertFile   string // Client certificate presented to the upstream
+	KeyFile    string
+	ServerName string // Overrides the name verified in the upstream certificate
+}
+
+// TLSTransport is an HTTP transport for upstream TLS that reloads its
+// certificates when the files change on disk, so rotated certificates take
+// effect without a restart
+type TLSTransport struct {
+	files        TLSFiles
+	newTransport func() *http.Transport
+
+	current  atomic.Pointer[http.Transport]
+	modTimes map[string]time.Time
+}
+
+// NewTLSTransport loads the certificates and starts watching them for changes.
+// newTransport creates the underlying transport with its pool settings.
+func NewTLSTransport(files TLSFiles, newTransport func() *http.Transport, reloadInterval time.Duration) (*TLSTransport, error) {
+	t := &TLSTransport{
+		files:        files,
+		newTransport: newTransport,
+	}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go t.watch(reloadInterval)
+	}
+
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current.Load().RoundTrip(req)
+}
+
+// reload builds a new transport from the current certificate files
+func (t *TLSTransport) reload() error {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: t.files.ServerName,
+	}
+
+	if t.files.CAFile != "" {
+		pem, err := os.ReadFile(t.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", t.files.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.files.CertFile != "" || t.files.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.files.CertFile, t.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := t.newTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	if previous := t.current.Swap(transport); previous != nil {
+		previous.CloseIdleConnections()
+	}
+	t.modTimes = t.fileModTimes()
+	return nil
+}
+
+// watch polls the certificate files and reloads them when they change
+func (t *TLSTransport) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !t.changed() {
+			continue
+		}
+		if err := t.reload(); err != nil {
+			// Keep serving with the previous certificates until the files are valid again
+			log.Printf("Failed to reload upstream TLS certificates: %v", err)
+			continue
+		}
+		log.Printf("Reloaded upstream TLS certificates (%s)", t.files.CertFile)
+	}
+}
+
+// changed reports whether any certificate file was modified since the last load
+func (t *TLSTransport) changed() bool {
+	current := t.fileModTimes()
+	for path, modTime := range current {
+		if !modTime.Equal(t.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileModTimes returns the modification time of each configured file
+func (t *TLSTransport) fileModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{t.files.CAFile, t.files.CertFile, t.files.KeyFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
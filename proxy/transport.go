@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// TransportSettings tunes the connection pool used for an upstream
+type TransportSettings struct {
+	MaxIdleConns          int           // Idle connections kept across all hosts
+	MaxIdleConnsPerHost   int           // Idle connections kept per host
+	MaxConnsPerHost       int           // Total connections per host; 0 means unlimited
+	IdleConnTimeout       time.Duration // How long idle connections are kept
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	DisableKeepAlives     bool
+
+	DialTimeout   time.Duration
+	IPFamily      string        // "dual" (default), "ipv4" or "ipv6"
+	FallbackDelay time.Duration // Happy-eyeballs delay before racing the other family; negative disables racing
+	DNSResolver   string        // "host:port" of a DNS server; system resolver when empty
+	UnixSocket    string        // Path of a Unix domain socket dialed for every connection instead of the target's host
+}
+
+// newTransport creates an HTTP transport with the pool and dial settings applied
+func (s TransportSettings) newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:       s.DialTimeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: s.FallbackDelay,
+	}
+	if s.DNSResolver != "" {
+		server := s.DNSResolver
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: s.DialTimeout}).DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	network := "tcp"
+	switch s.IPFamily {
+	case "ipv4":
+		network = "tcp4"
+	case "ipv6":
+		network = "tcp6"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if s.UnixSocket != "" {
+		socket := s.UnixSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	transport.MaxIdleConns = s.MaxIdleConns
+	transport.MaxIdleConnsPerHost = s.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = s.MaxConnsPerHost
+	transport.IdleConnTimeout = s.IdleConnTimeout
+	transport.TLSHandshakeTimeout = s.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = s.ResponseHeaderTimeout
+	transport.DisableKeepAlives = s.DisableKeepAlives
+	return transport
+}
+
+// PoolMetrics tracks upstream connection pool utilization
+type PoolMetrics struct {
+	open         *metrics.GaugeVec
+	inFlight     *metrics.GaugeVec
+	connections  *metrics.CounterVec
+	dialFailures *metrics.CounterVec
+}
+
+// NewPoolMetrics registers the connection pool metrics
+func NewPoolMetrics(reg *metrics.Registry) *PoolMetrics {
+	return &PoolMetrics{
+		open: reg.NewGaugeVec("gateway_upstream_connections_open",
+			"Open connections to each upstream (idle and in use).", "upstream"),
+		inFlight: reg.NewGaugeVec("gateway_upstream_requests_in_flight",
+			"Requests currently being sent to each upstream.", "upstream"),
+		connections: reg.NewCounterVec("gateway_upstream_connections_total",
+			"Connections obtained for upstream requests, by whether a pooled connection was reused.", "upstream", "reused"),
+		dialFailures: reg.NewCounterVec("gateway_upstream_dial_failures_total",
+			"Failed connection attempts to each upstream, by address family (ipv4, ipv6, unix or dns).", "upstream", "family"),
+	}
+}
+
+// instrument wraps a transport's dialer to count open connections and dial failures
+func (m *PoolMetrics) instrument(upstream string, transport *http.Transport) {
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			m.dialFailures.Inc(upstream, addressFamily(err, addr))
+			return nil, err
+		}
+		m.open.Add(1, upstream)
+		return &countedConn{Conn: conn, onClose: func() { m.open.Add(-1, upstream) }}, nil
+	}
+}
+
+// countedConn reports when a connection is closed
+type countedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+// Close closes the connection and reports it once
+func (c *countedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+// meteredTransport records in-flight requests and connection reuse
+type meteredTransport struct {
+	base     http.RoundTripper
+	upstream string
+	metrics  *PoolMetrics
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.metrics.inFlight.Add(1, t.upstream)
+	defer t.metrics.inFlight.Add(-1, t.upstream)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused := "false"
+			if info.Reused {
+				reused = "true"
+			}
+			t.metrics.connections.Inc(t.upstream, reused)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return t.base.RoundTrip(req)
+}
+
+// addressFamily classifies a dial failure by the address family that failed
+func addressFamily(err error, addr string) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+
+	var ip net.IP
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Addr != nil {
+		switch opAddr := opErr.Addr.(type) {
+		case *net.TCPAddr:
+			ip = opAddr.IP
+		case *net.UnixAddr:
+			return "unix"
+		}
+	}
+	if ip == nil {
+		host, _, _ := net.SplitHostPort(addr)
+		ip = net.ParseIP(host)
+	}
+
+	switch {
+	case ip == nil:
+		return "unknown"
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api-gateway/metrics"
+)
+
+// maxValidatedBody bounds the response bodies read for validation
+const maxValidatedBody = 8 << 20
+
+// standardResponseHeaders are kept even when an operation does not declare them
+var standardResponseHeaders = []string{
+	"Accept-Ranges", "Cache-Control", "Content-Encoding", "Content-Language", "Content-Length",
+	"Content-Range", "Content-Type", "Date", "ETag", "Expires", "Last-Modified", "Retry-After", "Vary",
+}
+
+// ResponseValidation checks upstream responses against the OpenAPI document
+// describing the upstream and removes what the document does not declare
+type ResponseValidation struct {
+	Document     *OpenAPIDocument
+	Enforce      bool // Replace non-conforming responses with 502 instead of only logging them
+	StripHeaders bool // Remove response headers the operation does not declare
+}
+
+// invalidResponseError rejects an upstream response that does not match its schema
+type invalidResponseError struct {
+	violations []string
+}
+
+func (e *invalidResponseError) Error() string {
+	return "response does not match schema: " + strings.Join(e.violations, "; ")
+}
+
+// ValidationMetrics counts schema violations and removed response data
+type ValidationMetrics struct {
+	violations *metrics.CounterVec
+	stripped   *metrics.CounterVec
+}
+
+// NewValidationMetrics registers the response validation metrics
+func NewValidationMetrics(reg *metrics.Registry) *ValidationMetrics {
+	return &ValidationMetrics{
+		violations: reg.NewCounterVec("gateway_upstream_response_violations_total",
+			"Upstream responses that did not match the upstream's OpenAPI document.", "upstream"),
+		stripped: reg.NewCounterVec("gateway_upstream_response_stripped_total",
+			"Undeclared fields and headers removed from upstream responses, by kind (field or header).", "upstream", "kind"),
+	}
+}
+
+// validateResponse sanitizes an upstream response, returning an
+// invalidResponseError when it does not conform and validation is enforced
+func (v *ResponseValidation) validateResponse(upstream *Upstream, resp *http.Response, m *ValidationMetrics) error {
+	req := resp.Request
+	path := strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(upstream.Target.Path, "/"))
+
+	violations, err := v.sanitize(upstream.Name, path, resp, m)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	m.violations.Inc(upstream.Name)
+	log.Printf("Upstream %s response to %s %s does not match its schema: %s",
+		upstream.Name, req.Method, path, strings.Join(violations, "; "))
+	if v.Enforce {
+		return &invalidResponseError{violations: violations}
+	}
+	return nil
+}
+
+// sanitize removes undeclared headers and body fields, returning the
+// schema violations found
+func (v *ResponseValidation) sanitize(name, path string, resp *http.Response, m *ValidationMetrics) ([]string, error) {
+	documented, err := v.Document.response(path, resp.Request.Method, resp.StatusCode)
+	if err != nil && resp.StatusCode == http.StatusPartialContent {
+		// A byte range of a response is described by the full response
+		documented, err = v.Document.response(path, resp.Request.Method, http.StatusOK)
+	}
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+
+	if v.StripHeaders {
+		if removed := stripUndeclaredHeaders(resp.Header, documented); removed > 0 {
+			m.stripped.Add(float64(removed), name, "header")
+		}
+	}
+
+	if resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	// Partial bodies are fragments that cannot match a schema
+	if resp.StatusCode == http.StatusPartialContent {
+		return nil, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	content, declared := documented.Content[mediaType]
+	if !declared {
+		content, declared = documented.Content["*/*"]
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxValidatedBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxValidatedBody {
+		// Pass the rest of a large body through unread
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	} else {
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	switch {
+	case len(body) == 0:
+		return nil, nil
+	case !declared:
+		return []string{fmt.Sprintf("content type %q is not documented for status %d", mediaType, resp.StatusCode)}, nil
+	case content == nil || content.Schema == nil || !isJSON(mediaType):
+		return nil, nil
+	case resp.Header.Get("Content-Encoding") != "":
+		return []string{"encoded response body cannot be validated"}, nil
+	case len(body) > maxValidatedBody:
+		return []string{"response body is too large to validate"}, nil
+	}
+
+	var value interface{}
+	if err := decodeJSON(body, &value); err != nil {
+		return []string{"response body is not valid JSON"}, nil
+	}
+
+	check := &schemaCheck{doc: v.Document}
+	value = check.sanitize(value, content.Schema, "$")
+	if check.stripped > 0 {
+		m.stripped.Add(float64(check.stripped), name, "field")
+
+		sanitized, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(sanitized))
+		resp.ContentLength = int64(len(sanitized))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(sanitized)))
+	}
+	return check.violations, nil
+}
+
+// stripUndeclaredHeaders removes the headers a documented response does not
+// declare, keeping standard entity headers, and returns how many were removed
+func stripUndeclaredHeaders(header http.Header, documented *apiResponse) int {
+	keep := make(map[string]bool, len(documented.Headers)+len(standardResponseHeaders))
+	for name := range documented.Headers {
+		keep[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range standardResponseHeaders {
+		keep[http.CanonicalHeaderKey(name)] = true
+	}
+
+	removed := 0
+	for name := range header {
+		if !keep[name] {
+			header.Del(name)
+			removed++
+		}
+	}
+	return removed
+}
+
+// isJSON reports whether a media type carries JSON
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
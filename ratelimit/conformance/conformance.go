@@ -0,0 +1,306 @@
+// Package conformance holds the behavior every rate limiter backend must
+// share. Backends run it from their tests with Run.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/ratelimit"
+)
+
+// Limiter is a rate limiter backend under test, such as the rate limit
+// middleware with buckets in memory or in Redis. Every backend must pass the
+// same cases, so buckets behave identically wherever they are kept.
+type Limiter interface {
+	// Check takes tokens from the bucket of key
+	Check(ctx context.Context, key string, tokens int) (*ratelimit.RateLimitResult, error)
+	// Reset forgets the bucket of key, so it is full again
+	Reset(ctx context.Context, key string) error
+}
+
+// testCase checks one behavior every backend must share, using keys starting
+// with prefix
+type testCase struct {
+	name   string
+	config ratelimit.RateLimitConfig
+	run    func(ctx context.Context, limiter Limiter, prefix string) error
+}
+
+// cases are run in order against every backend
+var cases = []testCase{
+	{"burst", ratelimit.RateLimitConfig{Capacity: 5, RefillRate: 1}, checkBurst},
+	{"token_cost", ratelimit.RateLimitConfig{Capacity: 5, RefillRate: 1}, checkTokenCost},
+	{"refill_accuracy", ratelimit.RateLimitConfig{Capacity: 5, RefillRate: 20}, checkRefill},
+	{"capacity_cap", ratelimit.RateLimitConfig{Capacity: 3, RefillRate: 100}, checkCapacityCap},
+	{"key_isolation", ratelimit.RateLimitConfig{Capacity: 2, RefillRate: 1}, checkIsolation},
+	{"reset", ratelimit.RateLimitConfig{Capacity: 3, RefillRate: 1}, checkReset},
+	{"concurrency", ratelimit.RateLimitConfig{Capacity: 50, RefillRate: 1}, checkConcurrency},
+}
+
+// caseTimeout bounds each case
+const caseTimeout = 30 * time.Second
+
+// Run runs every case as a subtest, each on a new limiter created with the
+// case's limits. Limiters implementing io.Closer are closed once their case
+// has run. Keys are unique to the run, so backends sharing state, such as
+// one Redis, can be checked in parallel.
+func Run(t *testing.T, newLimiter func(config *ratelimit.RateLimitConfig) Limiter) {
+	t.Helper()
+	runID := make([]byte, 6)
+	rand.Read(runID)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := c.config
+			config.Window = time.Minute
+			limiter := newLimiter(&config)
+			if closer, ok := limiter.(io.Closer); ok {
+				t.Cleanup(func() { closer.Close() })
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), caseTimeout)
+			defer cancel()
+			if err := c.run(ctx, limiter, fmt.Sprintf("conformance:%s:%s:", hex.EncodeToString(runID), c.name)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// check takes tokens, failing on backend errors
+func check(ctx context.Context, limiter Limiter, key string, tokens int) (*ratelimit.RateLimitResult, error) {
+	result, err := limiter.Check(ctx, key, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("check failed: %w", err)
+	}
+	if result == nil {
+		return nil, errors.New("check returned no result")
+	}
+	return result, nil
+}
+
+// drain takes every token from a bucket, returning how many were allowed
+// before the first denial
+func drain(ctx context.Context, limiter Limiter, key string, max int) (int, error) {
+	for allowed := 0; allowed <= max; allowed++ {
+		result, err := check(ctx, limiter, key, 1)
+		if err != nil {
+			return 0, err
+		}
+		if !result.Allowed {
+			return allowed, nil
+		}
+	}
+	return 0, fmt.Errorf("more than %d requests allowed", max)
+}
+
+// checkBurst verifies a new bucket allows its capacity at once, counting
+// down the remaining tokens, then denies with a retry hint
+func checkBurst(ctx context.Context, limiter Limiter, prefix string) error {
+	key := prefix + "client"
+	for i := 1; i <= 5; i++ {
+		result, err := check(ctx, limiter, key, 1)
+		if err != nil {
+			return err
+		}
+		if !result.Allowed {
+			return fmt.Errorf("request %d of a burst within capacity 5 was denied", i)
+		}
+		if result.Remaining != 5-i {
+			return fmt.Errorf("request %d left %d tokens, want %d", i, result.Remaining, 5-i)
+		}
+	}
+
+	result, err := check(ctx, limiter, key, 1)
+	if err != nil {
+		return err
+	}
+	if result.Allowed {
+		return errors.New("request beyond capacity 5 was allowed")
+	}
+	if result.Remaining != 0 {
+		return fmt.Errorf("denied request reports %d tokens left, want 0", result.Remaining)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		return fmt.Errorf("denied request says retry after %s, want within one token interval of 1s", result.RetryAfter)
+	}
+	if !result.ResetTime.After(time.Now().Add(-time.Second)) {
+		return fmt.Errorf("denied request reports reset time %s in the past", result.ResetTime)
+	}
+	return nil
+}
+
+// checkTokenCost verifies requests costing several tokens take all of them or
+// none, and requests costing more than the capacity are never allowed
+func checkTokenCost(ctx context.Context, limiter Limiter, prefix string) error {
+	key := prefix + "client"
+	result, err := check(ctx, limiter, key, 3)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed || result.Remaining != 2 {
+		return fmt.Errorf("taking 3 of 5 tokens gave allowed=%t remaining=%d, want allowed with 2 left", result.Allowed, result.Remaining)
+	}
+	result, err = check(ctx, limiter, key, 3)
+	if err != nil {
+		return err
+	}
+	if result.Allowed || result.Remaining != 2 {
+		return fmt.Errorf("taking 3 of 2 tokens gave allowed=%t remaining=%d, want denied with 2 left", result.Allowed, result.Remaining)
+	}
+
+	result, err = check(ctx, limiter, prefix+"greedy", 6)
+	if err != nil {
+		return err
+	}
+	if result.Allowed {
+		return errors.New("taking 6 tokens from a bucket of 5 was allowed")
+	}
+	return nil
+}
+
+// checkRefill verifies an empty bucket gains exactly one token per refill
+// interval, allowing for the time the requests themselves take
+func checkRefill(ctx context.Context, limiter Limiter, prefix string) error {
+	const interval = 50 * time.Millisecond // Capacity 5 refilling 20 per second
+	key := prefix + "client"
+
+	drainStart := time.Now()
+	if _, err := drain(ctx, limiter, key, 5); err != nil {
+		return err
+	}
+	drainEnd := time.Now()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(3*interval + interval/2):
+	}
+
+	countStart := time.Now()
+	allowed, err := drain(ctx, limiter, key, 5)
+	if err != nil {
+		return err
+	}
+	countEnd := time.Now()
+
+	// The bucket emptied during the first drain and was counted during the
+	// second, so the refill time lies between these bounds
+	least := int(countStart.Sub(drainEnd) / interval)
+	most := int(countEnd.Sub(drainStart) / interval)
+	if allowed < least || allowed > most {
+		return fmt.Errorf("bucket refilled %d tokens, want between %d and %d", allowed, least, most)
+	}
+	return nil
+}
+
+// checkCapacityCap verifies idle time never fills a bucket beyond capacity
+func checkCapacityCap(ctx context.Context, limiter Limiter, prefix string) error {
+	key := prefix + "client"
+	if _, err := check(ctx, limiter, key, 1); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond): // Worth 10 tokens
+	}
+
+	result, err := check(ctx, limiter, key, 1)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed || result.Remaining != 2 {
+		return fmt.Errorf("idle bucket of capacity 3 gave allowed=%t remaining=%d, want allowed with 2 left", result.Allowed, result.Remaining)
+	}
+	return nil
+}
+
+// checkIsolation verifies an empty bucket leaves other keys untouched
+func checkIsolation(ctx context.Context, limiter Limiter, prefix string) error {
+	if _, err := drain(ctx, limiter, prefix+"noisy", 2); err != nil {
+		return err
+	}
+	result, err := check(ctx, limiter, prefix+"quiet", 1)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed || result.Remaining != 1 {
+		return fmt.Errorf("another client's empty bucket gave allowed=%t remaining=%d, want allowed with 1 left", result.Allowed, result.Remaining)
+	}
+	return nil
+}
+
+// checkReset verifies a reset bucket is full again and resetting unknown keys
+// succeeds
+func checkReset(ctx context.Context, limiter Limiter, prefix string) error {
+	key := prefix + "client"
+	if _, err := drain(ctx, limiter, key, 3); err != nil {
+		return err
+	}
+	if err := limiter.Reset(ctx, key); err != nil {
+		return fmt.Errorf("reset failed: %w", err)
+	}
+	result, err := check(ctx, limiter, key, 1)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed || result.Remaining != 2 {
+		return fmt.Errorf("reset bucket gave allowed=%t remaining=%d, want allowed with 2 left", result.Allowed, result.Remaining)
+	}
+
+	if err := limiter.Reset(ctx, prefix+"unknown"); err != nil {
+		return fmt.Errorf("resetting an unknown key failed: %w", err)
+	}
+	return nil
+}
+
+// checkConcurrency verifies concurrent requests on one bucket never take more
+// tokens than it holds
+func checkConcurrency(ctx context.Context, limiter Limiter, prefix string) error {
+	const workers, requests = 20, 10 // 200 requests against capacity 50
+	key := prefix + "client"
+
+	var allowed atomic.Int64
+	var failures atomic.Pointer[error]
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				result, err := check(ctx, limiter, key, 1)
+				if err != nil {
+					failures.CompareAndSwap(nil, &err)
+					return
+				}
+				if result.Allowed {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if err := failures.Load(); err != nil {
+		return *err
+	}
+	// One token per second refills while the requests run
+	most := int64(50 + elapsed/time.Second)
+	if got := allowed.Load(); got < 50 || got > most {
+		return fmt.Errorf("%d concurrent requests were allowed, want between 50 and %d", got, most)
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/storage"
+)
+
+// Exemption kinds
+const (
+	ExemptIP     = "ip"     // Client IP address or CIDR range
+	ExemptAPIKey = "apikey" // API key
+	ExemptRole   = "role"   // Role held by the caller
+)
+
+var (
+	// ErrExemptionNotFound is returned for exemptions that do not exist
+	ErrExemptionNotFound = errors.New("exemption not found")
+	// ErrConfiguredExemption is returned when removing an exemption that comes from configuration
+	ErrConfiguredExemption = errors.New("configured exemptions can only be removed in configuration")
+	// ErrInvalidExemption is wrapped by errors for invalid exemption settings
+	ErrInvalidExemption = errors.New("invalid exemption")
+)
+
+// Exemption lets matching requests bypass rate limiting
+type Exemption struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"` // "ip", "apikey" or "role"
+	Value     string     `json:"value"`
+	Reason    string     `json:"reason,omitempty"`
+	Source    string     `json:"source"` // "config" or "api"
+	CreatedBy string     `json:"created_by,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	network *net.IPNet
+}
+
+// validate checks the exemption and parses IP ranges
+func (e *Exemption) validate() error {
+	switch e.Kind {
+	case ExemptIP:
+		network, err := parseNetwork(e.Value)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidExemption, err)
+		}
+		e.network = network
+	case ExemptAPIKey, ExemptRole:
+		if e.Value == "" {
+			return fmt.Errorf("%w: value must not be empty", ErrInvalidExemption)
+		}
+	default:
+		return fmt.Errorf("%w: kind must be ip, apikey or role", ErrInvalidExemption)
+	}
+	return nil
+}
+
+// parseNetwork parses an IP address or CIDR range
+func parseNetwork(value string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not an IP address or CIDR range", value)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// ExemptionStore persists exemptions added through the admin API
+type ExemptionStore interface {
+	Load(ctx context.Context) ([]*Exemption, error)
+	Save(ctx context.Context, exemption *Exemption) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Exemptions decides which requests bypass rate limiting. Exemptions come
+// from configuration and from the admin API; those added through the API are
+// kept in a store and reloaded periodically, so changes made by other
+// replicas are picked up.
+type Exemptions struct {
+	configured []*Exemption
+	store      ExemptionStore
+	// Identify returns the request's API key and roles, if any
+	identify func(r *http.Request) (apiKey string, roles []string)
+	proxies  *httputil.TrustedProxies
+
+	// Configured exemptions followed by those added through the API; a
+	// reload replaces the slice, so requests read it without copying
+	snapshot atomic.Pointer[[]*Exemption]
+}
+
+// NewExemptions loads the exemptions added through the API from the store and
+// reloads them at the given interval; a zero interval disables reloading. IP
+// exemptions match the peer address, or the client behind trusted proxies.
+func NewExemptions(configured []*Exemption, store ExemptionStore, identify func(r *http.Request) (string, []string), proxies *httputil.TrustedProxies, refresh time.Duration) (*Exemptions, error) {
+	for _, exemption := range configured {
+		if err := exemption.validate(); err != nil {
+			return nil, fmt.Errorf("exemption %s %s: %w", exemption.Kind, exemption.Value, err)
+		}
+		exemption.ID = exemption.Kind + ":" + exemption.Value
+		exemption.Source = "config"
+	}
+
+	e := &Exemptions{
+		configured: configured,
+		store:      store,
+		identify:   identify,
+		proxies:    proxies,
+	}
+	if err := e.reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go e.refreshRoutine(refresh)
+	}
+
+	return e, nil
+}
+
+// Match returns the exemption the request matches, or nil
+func (e *Exemptions) Match(r *http.Request) *Exemption {
+	list := *e.snapshot.Load()
+	if len(list) == 0 {
+		return nil
+	}
+
+	var ip net.IP
+	parsed := false
+	apiKey, roles := "", []string(nil)
+	identified := false
+	now := time.Now()
+	for _, exemption := range list {
+		if exemption.ExpiresAt != nil && !now.Before(*exemption.ExpiresAt) {
+			continue
+		}
+		switch exemption.Kind {
+		case ExemptIP:
+			if !parsed {
+				ip = net.ParseIP(e.proxies.ClientIP(r))
+				parsed = true
+			}
+			if ip != nil && exemption.network.Contains(ip) {
+				return exemption
+			}
+		case ExemptAPIKey, ExemptRole:
+			if !identified {
+				apiKey, roles = e.identify(r)
+				identified = true
+			}
+			if exemption.Kind == ExemptAPIKey && apiKey != "" && apiKey == exemption.Value {
+				return exemption
+			}
+			if exemption.Kind == ExemptRole {
+				for _, role := range roles {
+					if role == exemption.Value {
+						return exemption
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// List returns the configured exemptions followed by unexpired ones added
+// through the API, oldest first
+func (e *Exemptions) List() []*Exemption {
+	now := time.Now()
+	var list []*Exemption
+	for _, exemption := range *e.snapshot.Load() {
+		if exemption.ExpiresAt == nil || now.Before(*exemption.ExpiresAt) {
+			list = append(list, exemption)
+		}
+	}
+	return list
+}
+
+// Add stores a new exemption, valid for ttl when it is positive. The change is
+// written to the audit log.
+func (e *Exemptions) Add(ctx context.Context, exemption *Exemption, ttl time.Duration) error {
+	if err := exemption.validate(); err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return fmt.Errorf("%w: ttl must not be negative", ErrInvalidExemption)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate id: %w", err)
+	}
+	now := time.Now()
+	exemption.ID = hex.EncodeToString(b)
+	exemption.Source = "api"
+	exemption.CreatedAt = &now
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		exemption.ExpiresAt = &expiresAt
+	}
+
+	if err := e.store.Save(ctx, exemption); err != nil {
+		return err
+	}
+	log.Printf("Audit: %s added rate limit exemption %s for %s %s (expires %s), reason %q",
+		exemption.CreatedBy, exemption.ID, exemption.Kind, exemption.describe(), expiry(exemption.ExpiresAt), exemption.Reason)
+	return e.reload(ctx)
+}
+
+// Remove deletes an exemption added through the API. The change is written
+// to the audit log.
+func (e *Exemptions) Remove(ctx context.Context, id, removedBy string) error {
+	var found *Exemption
+	for _, exemption := range e.List() {
+		if exemption.ID == id {
+			found = exemption
+			break
+		}
+	}
+	if found == nil {
+		return ErrExemptionNotFound
+	}
+	if found.Source == "config" {
+		return ErrConfiguredExemption
+	}
+
+	if err := e.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("Audit: %s removed rate limit exemption %s for %s %s", removedBy, id, found.Kind, found.describe())
+	return e.reload(ctx)
+}
+
+// Replicate stores an exemption added in another region, keeping its ID
+func (e *Exemptions) Replicate(ctx context.Context, exemption *Exemption) error {
+	if err := exemption.validate(); err != nil {
+		return err
+	}
+	exemption.Source = "api"
+	if err := e.store.Save(ctx, exemption); err != nil {
+		return err
+	}
+	return e.reload(ctx)
+}
+
+// Forget deletes an exemption removed in another region
+func (e *Exemptions) Forget(ctx context.Context, id string) error {
+	if err := e.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	return e.reload(ctx)
+}
+
+// describe returns the exemption's value for logs, shortening API keys
+func (e *Exemption) describe() string {
+	if e.Kind == ExemptAPIKey && len(e.Value) > 12 {
+		return e.Value[:12] + "..."
+	}
+	return e.Value
+}
+
+// expiry formats an expiry time for logs
+func expiry(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// reload replaces the cached exemptions with the stored ones, deleting
+// expired ones from the store
+func (e *Exemptions) reload(ctx context.Context) error {
+	added, err := e.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	valid := added[:0]
+	for _, exemption := range added {
+		if exemption.ExpiresAt != nil && !now.Before(*exemption.ExpiresAt) {
+			if err := e.store.Delete(ctx, exemption.ID); err != nil {
+				log.Printf("Failed to delete expired rate limit exemption %s: %v", exemption.ID, err)
+			}
+			continue
+		}
+		if err := exemption.validate(); err != nil {
+			log.Printf("Skipping stored rate limit exemption %s: %v", exemption.ID, err)
+			continue
+		}
+		exemption.Source = "api"
+		valid = append(valid, exemption)
+	}
+	sort.Slice(valid, func(i, j int) bool {
+		return valid[i].CreatedAt.Before(*valid[j].CreatedAt)
+	})
+
+	list := make([]*Exemption, 0, len(e.configured)+len(valid))
+	list = append(append(list, e.configured...), valid...)
+	e.snapshot.Store(&list)
+	return nil
+}
+
+// refreshRoutine periodically reloads the exemptions added through the API
+func (e *Exemptions) refreshRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := e.reload(ctx); err != nil {
+			log.Printf("Failed to reload rate limit exemptions: %v", err)
+		}
+		cancel()
+	}
+}
+
+// exemptionPrefix namespaces exemptions in a key-value store, keyed by ID
+const exemptionPrefix = "ratelimit:exemption:"
+
+// KVExemptionStore keeps exemptions in a key-value store, expiring them with
+// the exemption. With a shared store, such as Redis or SQL, every replica
+// shares them.
+type KVExemptionStore struct {
+	kv storage.KeyValueStore
+}
+
+// NewKVExemptionStore creates a new exemption store on a key-value store
+func NewKVExemptionStore(kv storage.KeyValueStore) *KVExemptionStore {
+	return &KVExemptionStore{
+		kv: kv,
+	}
+}
+
+// Load returns every exemption
+func (s *KVExemptionStore) Load(ctx context.Context) ([]*Exemption, error) {
+	keys, err := s.kv.Keys(ctx, exemptionPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate limit exemptions: %w", err)
+	}
+	exemptions := make([]*Exemption, 0, len(keys))
+	for _, key := range keys {
+		data, err := s.kv.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // Expired since the listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rate limit exemptions: %w", err)
+		}
+		var exemption Exemption
+		if err := json.Unmarshal(data, &exemption); err != nil {
+			return nil, fmt.Errorf("failed to decode rate limit exemption %s: %w", key[len(exemptionPrefix):], err)
+		}
+		exemptions = append(exemptions, &exemption)
+	}
+	return exemptions, nil
+}
+
+// Save stores an exemption
+func (s *KVExemptionStore) Save(ctx context.Context, exemption *Exemption) error {
+	data, err := json.Marshal(exemption)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if exemption.ExpiresAt != nil {
+		if ttl = time.Until(*exemption.ExpiresAt); ttl <= 0 {
+			return nil
+		}
+	}
+	if err := s.kv.Set(ctx, exemptionPrefix+exemption.ID, data, ttl); err != nil {
+		return fmt.Errorf("failed to save rate limit exemption: %w", err)
+	}
+	return nil
+}
+
+// Delete removes an exemption
+func (s *KVExemptionStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.kv.Delete(ctx, exemptionPrefix+id); err != nil {
+		return fmt.Errorf("failed to delete rate limit exemption: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/storage"
+)
+
+func TestExemptionsMatchIP(t *testing.T) {
+	proxies, err := httputil.ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured := []*Exemption{{Kind: ExemptIP, Value: "192.0.2.0/24"}}
+	exemptions, err := NewExemptions(configured, NewKVExemptionStore(storage.NewMemoryStore()), func(*http.Request) (string, []string) {
+		return "", nil
+	}, proxies, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		exempt  bool
+	}{
+		{"exempt peer", "192.0.2.7:4000", nil, true},
+		{"other peer", "203.0.113.9:4000", nil, false},
+		{"spoofed X-Forwarded-For", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "192.0.2.7"}, false},
+		{"spoofed X-Real-Ip", "203.0.113.9:4000", map[string]string{"X-Real-Ip": "192.0.2.7"}, false},
+		{"exempt client behind a trusted proxy", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "192.0.2.7"}, true},
+		{"client forging an entry through a trusted proxy", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "192.0.2.7, 203.0.113.9"}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := exemptions.Match(r) != nil; got != tt.exempt {
+			t.Errorf("%s: exempt %v, want %v", tt.name, got, tt.exempt)
+		}
+	}
+}
+
+func TestMiddlewareKeysSpoofedClients(t *testing.T) {
+	config := DefaultRateLimitMiddlewareConfig()
+	config.Config = &RateLimitConfig{Capacity: 1, RefillRate: 1, Window: time.Hour}
+	rl, err := NewRateLimitMiddleware(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	handler := rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Changing X-Forwarded-For does not give a client a fresh bucket
+	for i, xff := range []string{"198.51.100.1", "198.51.100.2"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "203.0.113.9:4000"
+		r.Header.Set("X-Forwarded-For", xff)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; w.Code != want {
+			t.Errorf("request %d with X-Forwarded-For %s: status %d, want %d", i+1, xff, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package ratelimit
+
+// Exported for the external tests, which run the conformance cases
+var ParseScriptResult = parseScriptResult
+
+// ConsumeAt takes tokens as of now, in Unix nanoseconds
+func (tb *TokenBucket) ConsumeAt(tokens int, now int64) (bool, int) {
+	return tb.consume(tokens, now)
+}
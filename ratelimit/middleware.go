@@ -2,12 +2,17 @@ package ratelimit
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
+
+	"api-gateway/httputil"
 )
 
 // ClientIdentifier represents different ways to identify clients
@@ -29,6 +34,9 @@ type RateLimitMiddlewareConfig struct {
 	SkipSuccessful bool                       `json:"skip_successful"` // Don't count successful requests
 	SkipFailed     bool                       `json:"skip_failed"`     // Don't count failed requests
 	CustomKeyFunc  func(*http.Request) string `json:"-"`               // Custom key generation function
+	Sync           *SyncConfig                `json:"sync"`            // Share in-memory usage with peer replicas
+	Exemptions     *Exemptions                `json:"-"`               // Requests bypassing rate limiting
+	TrustedProxies *httputil.TrustedProxies   `json:"-"`               // Proxies whose forwarding headers identify clients
 }
 
 // DefaultRateLimitMiddlewareConfig returns default configuration
@@ -49,8 +57,14 @@ type RateLimitMiddleware struct {
 	limiter      *RateLimiter
 	redisLimiter *RedisRateLimiter
 	redisManager *RedisManager
+	syncer       *Syncer
+	stop         chan struct{}
+	limitHeader  string // X-RateLimit-Limit value, formatted once
 }
 
+// cleanupInterval is how often full in-memory buckets are removed
+const cleanupInterval = time.Minute
+
 // NewRateLimitMiddleware creates a new rate limiting middleware
 func NewRateLimitMiddleware(config *RateLimitMiddlewareConfig) (*RateLimitMiddleware, error) {
 	if config == nil {
@@ -58,7 +72,9 @@ func NewRateLimitMiddleware(config *RateLimitMiddlewareConfig) (*RateLimitMiddle
 	}
 
 	rl := &RateLimitMiddleware{
-		config: config,
+		config:      config,
+		stop:        make(chan struct{}),
+		limitHeader: strconv.Itoa(config.Config.Capacity),
 	}
 
 	// Initialize in-memory limiter
@@ -73,29 +89,48 @@ func NewRateLimitMiddleware(config *RateLimitMiddlewareConfig) (*RateLimitMiddle
 		}
 
 		rl.redisLimiter = NewRedisRateLimiter(rl.redisManager.GetClient(), config.Config)
+	} else if config.Sync != nil {
+		var err error
+		rl.syncer, err = NewSyncer(rl.limiter, config.Sync)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rl.redisLimiter == nil {
+		go rl.cleanupRoutine()
 	}
 
 	return rl, nil
 }
 
+// cleanupRoutine removes full in-memory buckets until the middleware is closed
+func (rl *RateLimitMiddleware) cleanupRoutine() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rl.limiter.Cleanup()
+		case <-rl.stop:
+			return
+		}
+	}
+}
+
 // Middleware returns the HTTP middleware function
 func (rl *RateLimitMiddleware) Middleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rl.config.Exemptions != nil && rl.config.Exemptions.Match(r) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Generate client key
 			key := rl.generateClientKey(r)
 
 			// Check rate limit
-			var result *RateLimitResult
-			var err error
-
-			if rl.config.UseRedis && rl.redisLimiter != nil {
-				ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-				defer cancel()
-				result, err = rl.redisLimiter.Allow(ctx, key, 1)
-			} else {
-				result = rl.limiter.CheckRateLimit(key, 1)
-			}
+			result, err := rl.Check(r.Context(), key, 1)
 
 			if err != nil {
 				// If Redis fails, log error but allow request
@@ -114,20 +149,51 @@ func (rl *RateLimitMiddleware) Middleware() func(http.Handler) http.Handler {
 			}
 
 			// Create a custom response writer to track status codes
-			rw := &responseWriter{
-				ResponseWriter: w,
-				statusCode:     200,
-			}
+			rw := responseWriters.Get().(*httputil.StatusWriter)
+			rw.ResponseWriter = w
 
 			// Call next handler
 			next.ServeHTTP(rw, r)
 
 			// Check if we should count this request based on status code
-			_ = rl.shouldCountRequest(rw.statusCode)
+			_ = rl.shouldCountRequest(rw.StatusCode())
+
+			*rw = httputil.StatusWriter{}
+			responseWriters.Put(rw)
 		})
 	}
 }
 
+// Check takes tokens from a client's bucket with whichever backend is in use
+func (rl *RateLimitMiddleware) Check(ctx context.Context, key string, tokens int) (*RateLimitResult, error) {
+	if rl.config.UseRedis && rl.redisLimiter != nil {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return rl.redisLimiter.Allow(ctx, key, tokens)
+	}
+	if rl.syncer != nil {
+		return rl.syncer.CheckRateLimit(key, tokens), nil
+	}
+	return rl.limiter.CheckRateLimit(key, tokens), nil
+}
+
+// Reset forgets a client's bucket
+func (rl *RateLimitMiddleware) Reset(ctx context.Context, key string) error {
+	if rl.config.UseRedis && rl.redisLimiter != nil {
+		return rl.redisLimiter.Reset(ctx, key)
+	}
+	if rl.syncer != nil {
+		key = hashClientKey(key)
+	}
+	rl.limiter.Remove(key)
+	return nil
+}
+
+// Capacity returns the number of tokens in a full bucket
+func (rl *RateLimitMiddleware) Capacity() int {
+	return rl.config.Config.Capacity
+}
+
 // generateClientKey generates a unique key for the client
 func (rl *RateLimitMiddleware) generateClientKey(r *http.Request) string {
 	// Use custom key function if provided
@@ -162,40 +228,17 @@ func (rl *RateLimitMiddleware) generateClientKey(r *http.Request) string {
 
 // getClientIP extracts the client IP address
 func (rl *RateLimitMiddleware) getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+	return rl.config.TrustedProxies.ClientIP(r)
 }
 
 // getJWTSubject extracts the JWT subject
 func (rl *RateLimitMiddleware) getJWTSubject(r *http.Request) string {
 	// For JWT-based rate limiting, we need to extract the JWT from the header directly
 	// since the authentication middleware might not have run yet
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-		// Use a hash of the JWT token as the key to avoid storing the full token
-		// For now, we'll use a simple approach: take first 20 chars of the token
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if len(token) > 20 {
-			token = token[:20]
-		}
-		return "jwt:" + token
+	if token, ok := httputil.BearerToken(r); ok {
+		// Use a hash of the JWT token as the key to avoid storing the full
+		// token; a prefix would be the header shared by every token
+		return "jwt:" + credentialHash(token)
 	}
 	// If no JWT available, fall back to IP
 	return rl.getClientIP(r)
@@ -215,27 +258,24 @@ func (rl *RateLimitMiddleware) getAPIKey(r *http.Request) string {
 func (rl *RateLimitMiddleware) getUserID(r *http.Request) string {
 	// For user-based rate limiting, we need to extract from headers directly
 	// since the authentication middleware might not have run yet
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
+	if token, ok := httputil.BearerToken(r); ok {
 		// Use a hash of the JWT token as the key
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if len(token) > 20 {
-			token = token[:20]
-		}
-		return "user:" + token
+		return "user:" + credentialHash(token)
 	}
 	apiKey := r.Header.Get("X-API-Key")
 	if apiKey != "" {
-		// Use first 20 chars of API key
-		if len(apiKey) > 20 {
-			apiKey = apiKey[:20]
-		}
-		return "user:" + apiKey
+		return "user:" + credentialHash(apiKey)
 	}
 	// If no authentication available, fall back to IP
 	return rl.getClientIP(r)
 }
 
+// credentialHash identifies a credential in a bucket key without storing it
+func credentialHash(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:16])
+}
+
 // shouldCountRequest determines if a request should be counted based on status code
 func (rl *RateLimitMiddleware) shouldCountRequest(statusCode int) bool {
 	if rl.config.SkipSuccessful && statusCode >= 200 && statusCode < 300 {
@@ -247,14 +287,24 @@ func (rl *RateLimitMiddleware) shouldCountRequest(statusCode int) bool {
 	return true
 }
 
-// addRateLimitHeaders adds rate limiting headers to the response
+// addRateLimitHeaders adds rate limiting headers to the response. The keys
+// are already canonical and the values share one backing array, so setting
+// them skips Header.Set's canonicalization and per-value allocations.
 func (rl *RateLimitMiddleware) addRateLimitHeaders(w http.ResponseWriter, result *RateLimitResult) {
-	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rl.config.Config.Capacity))
-	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
-	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetTime.Unix(), 10))
+	values := [3]string{
+		rl.limitHeader,
+		strconv.Itoa(result.Remaining),
+		strconv.FormatInt(result.ResetTime.Unix(), 10),
+	}
+	shared := values[:]
+	header := w.Header()
+	header["X-Ratelimit-Limit"] = shared[0:1:1]
+	header["X-Ratelimit-Remaining"] = shared[1:2:2]
+	header["X-Ratelimit-Reset"] = shared[2:3:3]
 
 	if !result.Allowed {
-		w.Header().Set("Retry-After", strconv.FormatFloat(result.RetryAfter.Seconds(), 'f', 0, 64))
+		// Retry-After is in whole seconds; round up so clients do not retry too early
+		w.Header().Set("Retry-After", strconv.FormatFloat(math.Ceil(result.RetryAfter.Seconds()), 'f', 0, 64))
 	}
 }
 
@@ -273,21 +323,17 @@ func (rl *RateLimitMiddleware) writeRateLimitResponse(w http.ResponseWriter, res
 	}
 
 	fmt.Fprintf(w, `{"error":"Rate limit exceeded","message":"Too many requests","retry_after":%.0f,"reset_time":"%s","limit":%d,"remaining":%d}`,
-		result.RetryAfter.Seconds(),
+		math.Ceil(result.RetryAfter.Seconds()),
 		result.ResetTime.Format(time.RFC3339),
 		rl.config.Config.Capacity,
 		result.Remaining)
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
-	rw.ResponseWriter.WriteHeader(code)
+// responseWriters recycles status-capturing writers across requests. A
+// writer is only put back once the handler holding it has returned, and not
+// at all when the handler panics.
+var responseWriters = sync.Pool{
+	New: func() any { return new(httputil.StatusWriter) },
 }
 
 // GetStats returns rate limiting statistics
@@ -316,17 +362,33 @@ func (rl *RateLimitMiddleware) GetStats() (map[string]interface{}, error) {
 		}
 	} else {
 		stats["in_memory"] = map[string]interface{}{
-			"buckets": len(rl.limiter.buckets),
+			"buckets": rl.limiter.Len(),
+		}
+		if rl.syncer != nil {
+			stats["sync"] = rl.syncer.Stats()
 		}
 	}
 
 	return stats, nil
 }
 
+// Snapshot returns the state of the in-memory buckets; Redis buckets persist
+// on their own
+func (rl *RateLimitMiddleware) Snapshot() (any, error) {
+	return rl.limiter.Snapshot()
+}
+
+// Restore loads the state of the in-memory buckets
+func (rl *RateLimitMiddleware) Restore(data json.RawMessage) error {
+	return rl.limiter.Restore(data)
+}
+
 // Close closes the rate limiter and cleans up resources
 func (rl *RateLimitMiddleware) Close() error {
-	if rl.limiter != nil {
-		rl.limiter.Stop()
+	close(rl.stop)
+
+	if rl.syncer != nil {
+		rl.syncer.Close()
 	}
 
 	if rl.redisManager != nil {
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareWritersNotSharedWhileHeld(t *testing.T) {
+	config := DefaultRateLimitMiddlewareConfig()
+	config.Config = &RateLimitConfig{Capacity: 1 << 20, RefillRate: 1 << 20}
+	rl, err := NewRateLimitMiddleware(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	mw := rl.Middleware()
+	serve := func(handler http.HandlerFunc) {
+		mw(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	// A handler still running keeps its writer
+	held := make(chan http.ResponseWriter)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			held <- w
+			<-release
+		})
+	}()
+	holding := <-held
+	for i := 0; i < 100; i++ {
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			if w == holding {
+				t.Fatal("writer handed out while its handler still runs")
+			}
+		})
+	}
+	close(release)
+	<-done
+
+	// A panicking handler may have leaked its writer, so it is not put back
+	var panicked http.ResponseWriter
+	func() {
+		defer func() { recover() }()
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			panicked = w
+			panic("handler failed")
+		})
+	}()
+	for i := 0; i < 100; i++ {
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			if w == panicked {
+				t.Fatal("writer of a panicked handler was reused")
+			}
+		})
+	}
+}
+
+func TestMiddlewareWriterSupportsFlush(t *testing.T) {
+	rl, err := NewRateLimitMiddleware(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	rec := httptest.NewRecorder()
+	rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush through the rate limiter: %v", err)
+		}
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !rec.Flushed {
+		t.Error("response was not flushed")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -27,105 +28,131 @@ func NewRedisRateLimiter(client *redis.Client, config *RateLimitConfig) *RedisRa
 	}
 }
 
-// RedisBucketData represents bucket data stored in Redis
+// RedisBucketData represents bucket data stored in Redis by the rate limit
+// script, which encodes every field as a Lua number
 type RedisBucketData struct {
-	Tokens     int       `json:"tokens"`
-	LastRefill time.Time `json:"last_refill"`
+	Tokens     float64 `json:"tokens"`
+	LastRefill float64 `json:"last_refill"` // Unix milliseconds
 }
 
+// bucketSuffix is appended to the keys of buckets stored with times in
+// milliseconds. Earlier versions stored buckets in seconds under the bare
+// key; keeping the formats apart stops replicas of either version from
+// misreading each other's buckets during a rolling upgrade, where an old
+// replica would read a millisecond time as being far in the future and never
+// refill the bucket.
+const bucketSuffix = ":ms"
+
+// bucketKey returns the Redis key of a client's bucket
+func bucketKey(key string) string {
+	return key + bucketSuffix
+}
+
+// allowScript takes tokens from a bucket stored as JSON. Times are Unix
+// milliseconds, and the refill time of a partial token is kept, so buckets
+// refill as accurately as in-memory ones. A bucket expires once it would be
+// full again, being then the same as a new one.
+var allowScript = redis.NewScript(`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local interval = 1000 / math.max(tonumber(ARGV[2]), 1)
+	local tokens = tonumber(ARGV[3])
+	local now = tonumber(ARGV[4])
+
+	local bucket
+	local data = redis.call('GET', key)
+	if data then
+		bucket = cjson.decode(data)
+	else
+		bucket = {tokens = capacity, last_refill = now}
+	end
+
+	-- Refill whole tokens, keeping the time accrued toward the next one
+	local elapsed = now - bucket.last_refill
+	if elapsed > 0 then
+		local added = math.floor(elapsed / interval)
+		bucket.tokens = bucket.tokens + added
+		bucket.last_refill = bucket.last_refill + added * interval
+	end
+	if bucket.tokens >= capacity then
+		bucket.tokens = capacity
+		bucket.last_refill = now
+	end
+
+	local allowed = 0
+	local retryAfter = 0
+	if bucket.tokens >= tokens then
+		bucket.tokens = bucket.tokens - tokens
+		allowed = 1
+	else
+		retryAfter = math.ceil((tokens - bucket.tokens) * interval)
+	end
+
+	local untilFull = math.ceil((capacity - bucket.tokens) * interval)
+	if untilFull > 0 then
+		redis.call('SET', key, cjson.encode(bucket), 'PX', untilFull)
+	else
+		redis.call('DEL', key)
+	end
+
+	local resetTime = now + untilFull
+	if allowed == 0 then
+		resetTime = now + retryAfter
+	end
+	return {allowed, bucket.tokens, resetTime, retryAfter}
+`)
+
 // Allow checks if a request is allowed using Redis
 func (rl *RedisRateLimiter) Allow(ctx context.Context, key string, tokens int) (*RateLimitResult, error) {
-	// Use Lua script for atomic operations
-	script := `
-		local key = KEYS[1]
-		local capacity = tonumber(ARGV[1])
-		local refillRate = tonumber(ARGV[2])
-		local tokens = tonumber(ARGV[3])
-		local now = tonumber(ARGV[4])
-		
-		-- Get current bucket data
-		local data = redis.call('GET', key)
-		local bucket
-		
-		if data then
-			bucket = cjson.decode(data)
-		else
-			bucket = {
-				tokens = capacity,
-				last_refill = now
-			}
-		end
-		
-		-- Calculate tokens to add based on elapsed time
-		local elapsed = now - bucket.last_refill
-		local tokensToAdd = math.floor(elapsed * refillRate)
-		
-		-- Refill tokens
-		bucket.tokens = bucket.tokens + tokensToAdd
-		if bucket.tokens > capacity then
-			bucket.tokens = capacity
-		end
-		bucket.last_refill = now
-		
-		-- Check if we can consume tokens
-		local allowed = false
-		if bucket.tokens >= tokens then
-			bucket.tokens = bucket.tokens - tokens
-			allowed = true
-		end
-		
-		-- Store updated bucket data
-		redis.call('SET', key, cjson.encode(bucket), 'EX', 3600)
-		
-		-- Calculate reset time
-		local resetTime
-		local retryAfter = 0
-		
-		if allowed then
-			-- When bucket will be full
-			resetTime = now + (capacity - bucket.tokens) / refillRate
-		else
-			-- When enough tokens will be available
-			retryAfter = (tokens - bucket.tokens) / refillRate
-			resetTime = now + retryAfter
-		end
-		
-		return {allowed, bucket.tokens, resetTime, retryAfter}
-	`
-
-	now := time.Now().Unix()
-	result, err := rl.client.Eval(ctx, script, []string{key},
+	result, err := allowScript.Run(ctx, rl.client, []string{bucketKey(key)},
 		rl.config.Capacity,
 		rl.config.RefillRate,
 		tokens,
-		now).Result()
+		time.Now().UnixMilli()).Result()
 
 	if err != nil {
 		return nil, fmt.Errorf("redis rate limit check failed: %w", err)
 	}
 
-	// Parse result
+	return parseScriptResult(result)
+}
+
+// parseScriptResult reads the {allowed, remaining, reset time, retry after}
+// reply of the rate limit script, with times in milliseconds. Redis truncates
+// Lua numbers to integers and turns false into nil, so every field arrives as
+// an integer or nil.
+func parseScriptResult(result interface{}) (*RateLimitResult, error) {
 	results, ok := result.([]interface{})
 	if !ok || len(results) != 4 {
 		return nil, fmt.Errorf("invalid redis script result")
 	}
 
-	allowed, _ := results[0].(int64)
-	remaining, _ := results[1].(int64)
-	resetTimeUnix, _ := results[2].(float64)
-	retryAfterFloat, _ := results[3].(float64)
+	var fields [4]int64
+	for i, value := range results {
+		switch value := value.(type) {
+		case int64:
+			fields[i] = value
+		case nil:
+		default:
+			return nil, fmt.Errorf("invalid redis script result: field %d is %T", i, value)
+		}
+	}
+	allowed, remaining, resetTimeUnix, retryAfter := fields[0], fields[1], fields[2], fields[3]
+	if remaining < 0 || resetTimeUnix < 0 || retryAfter < 0 {
+		return nil, fmt.Errorf("invalid redis script result: negative field")
+	}
 
 	return &RateLimitResult{
 		Allowed:    allowed == 1,
 		Remaining:  int(remaining),
-		ResetTime:  time.Unix(int64(resetTimeUnix), 0),
-		RetryAfter: time.Duration(retryAfterFloat) * time.Second,
+		ResetTime:  time.UnixMilli(resetTimeUnix),
+		RetryAfter: time.Duration(retryAfter) * time.Millisecond,
 	}, nil
 }
 
 // GetStatus gets the current status of a bucket from Redis
 func (rl *RedisRateLimiter) GetStatus(ctx context.Context, key string) (int, int, int, error) {
-	data, err := rl.client.Get(ctx, key).Result()
+	data, err := rl.client.Get(ctx, bucketKey(key)).Result()
 	if err == redis.Nil {
 		// Bucket doesn't exist, return full capacity
 		return rl.config.Capacity, rl.config.Capacity, rl.config.RefillRate, nil
@@ -139,22 +166,21 @@ func (rl *RedisRateLimiter) GetStatus(ctx context.Context, key string) (int, int
 		return 0, 0, 0, fmt.Errorf("failed to unmarshal bucket data: %w", err)
 	}
 
-	// Refill tokens based on elapsed time
-	now := time.Now()
-	elapsed := now.Sub(bucket.LastRefill)
-	tokensToAdd := int(elapsed.Seconds()) * rl.config.RefillRate
-
-	tokens := bucket.Tokens + tokensToAdd
-	if tokens > rl.config.Capacity {
-		tokens = rl.config.Capacity
+	// Refill tokens based on elapsed time, as the script does
+	interval := 1000 / float64(max(rl.config.RefillRate, 1))
+	elapsed := max(float64(time.Now().UnixMilli())-bucket.LastRefill, 0)
+	tokens := int(min(bucket.Tokens+math.Floor(elapsed/interval), float64(rl.config.Capacity)))
+	if tokens < 0 {
+		tokens = 0
 	}
 
 	return tokens, rl.config.Capacity, rl.config.RefillRate, nil
 }
 
-// Reset resets a bucket in Redis
+// Reset resets a bucket in Redis, along with any bucket an earlier version
+// stored for the client
 func (rl *RedisRateLimiter) Reset(ctx context.Context, key string) error {
-	return rl.client.Del(ctx, key).Err()
+	return rl.client.Del(ctx, bucketKey(key), key).Err()
 }
 
 // Cleanup removes expired keys (Redis TTL handles this automatically)
@@ -0,0 +1,154 @@
+package ratelimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"api-gateway/ratelimit"
+	"api-gateway/ratelimit/conformance"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseScriptResult(t *testing.T) {
+	result, err := ratelimit.ParseScriptResult([]interface{}{int64(1), int64(4), int64(1700000000500), nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 4 || result.ResetTime.UnixMilli() != 1700000000500 || result.RetryAfter != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	for _, reply := range []interface{}{
+		nil,
+		"OK",
+		[]interface{}{int64(1), int64(2), int64(3)},
+		[]interface{}{int64(1), "2", int64(3), int64(4)},
+		[]interface{}{int64(0), int64(-1), int64(3), int64(4)},
+	} {
+		if _, err := ratelimit.ParseScriptResult(reply); err == nil {
+			t.Errorf("ratelimit.ParseScriptResult(%#v) succeeded", reply)
+		}
+	}
+}
+
+// scriptReply builds a script reply of n fields, each an integer, nil or
+// string as selected by two bits of kinds
+func scriptReply(kinds uint16, n uint8, values [4]int64) []interface{} {
+	reply := make([]interface{}, int(n)%6)
+	for i := range reply {
+		switch kinds >> (2 * i) & 3 {
+		case 0, 1:
+			reply[i] = values[i%4]
+		case 2:
+			reply[i] = nil
+		case 3:
+			reply[i] = "1"
+		}
+	}
+	return reply
+}
+
+func FuzzParseScriptResult(f *testing.F) {
+	f.Add(uint16(0), uint8(4), int64(1), int64(9), int64(1700000000000), int64(0))
+	f.Add(uint16(0x80), uint8(4), int64(0), int64(0), int64(1700000000000), int64(0))
+	f.Add(uint16(0), uint8(4), int64(1), int64(-1), int64(0), int64(0))
+	f.Add(uint16(0xc0), uint8(4), int64(1), int64(1), int64(1), int64(1))
+	f.Add(uint16(0), uint8(5), int64(1), int64(1), int64(1), int64(1))
+	f.Fuzz(func(t *testing.T, kinds uint16, n uint8, allowed, remaining, reset, retryAfter int64) {
+		result, err := ratelimit.ParseScriptResult(scriptReply(kinds, n, [4]int64{allowed, remaining, reset, retryAfter}))
+		if err != nil {
+			return
+		}
+		if result.Remaining < 0 || result.RetryAfter < 0 || result.ResetTime.Before(time.UnixMilli(0)) {
+			t.Fatalf("accepted invalid result %+v", result)
+		}
+		if result.Allowed && allowed != 1 {
+			t.Fatalf("allowed %d parsed as allowed", allowed)
+		}
+	})
+}
+
+// redisConfig points at the Redis in TEST_REDIS_ADDR, or at an in-process
+// miniredis when it is unset
+func redisConfig(t *testing.T) *ratelimit.RedisConfig {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = miniredis.RunT(t).Addr()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ratelimit.DefaultRedisConfig()
+	config.Host = host
+	config.Port, err = strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestRedisConformance(t *testing.T) {
+	redisConf := redisConfig(t)
+	conformance.Run(t, func(config *ratelimit.RateLimitConfig) conformance.Limiter {
+		limiter, err := ratelimit.NewRateLimitMiddleware(&ratelimit.RateLimitMiddlewareConfig{Config: config, UseRedis: true, RedisConfig: redisConf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return limiter
+	})
+}
+
+// TestRedisBucketFormat checks buckets are stored in milliseconds under their
+// own keys, leaving buckets stored in seconds by earlier versions alone
+func TestRedisBucketFormat(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	rl := ratelimit.NewRedisRateLimiter(client, &ratelimit.RateLimitConfig{Capacity: 2, RefillRate: 1})
+	ctx := context.Background()
+
+	// An empty bucket an earlier version stored in seconds
+	legacy := fmt.Sprintf(`{"tokens":0,"last_refill":%d}`, time.Now().Unix())
+	mr.Set("client", legacy)
+
+	result, err := rl.Allow(ctx, "client", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("new-format bucket gave allowed=%t remaining=%d, want allowed with 1 left", result.Allowed, result.Remaining)
+	}
+	if got, _ := mr.Get("client"); got != legacy {
+		t.Errorf("legacy bucket changed to %s", got)
+	}
+	data, err := mr.Get("client:ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bucket ratelimit.RedisBucketData
+	if err := json.Unmarshal([]byte(data), &bucket); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.LastRefill < float64(time.Now().Add(-time.Minute).UnixMilli()) {
+		t.Errorf("last_refill %v is not in Unix milliseconds", bucket.LastRefill)
+	}
+	if ttl := mr.TTL("client:ms"); ttl <= 0 || ttl > time.Second {
+		t.Errorf("bucket expires in %s, want once it would be full again, within 1s", ttl)
+	}
+
+	if err := rl.Reset(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("client") || mr.Exists("client:ms") {
+		t.Error("reset left a bucket behind")
+	}
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SimulationPolicy is a hypothetical rate limiting policy
+type SimulationPolicy struct {
+	Capacity    int      `json:"capacity" example:"100"`
+	RefillRate  float64  `json:"refill_rate" example:"10"` // Tokens per second
+	Identifiers []string `json:"identifiers" example:"ip"` // Request attributes that together name a bucket
+}
+
+// SimulatedRequest is one request of a traffic sample
+type SimulatedRequest struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip"`
+	Consumer string    `json:"consumer"` // Authenticated consumer, such as "jwt:42"; empty for anonymous requests
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+}
+
+// SimulationClient is the outcome for one bucket
+type SimulationClient struct {
+	Key           string     `json:"key"`
+	Requests      int        `json:"requests"`
+	Rejected      int        `json:"rejected"`
+	FirstRejected *time.Time `json:"first_rejected,omitempty"`
+}
+
+// SimulationResult is the outcome of replaying a sample against a policy
+type SimulationResult struct {
+	Policy         SimulationPolicy    `json:"policy"`
+	Requests       int                 `json:"requests"`
+	Allowed        int                 `json:"allowed"`
+	Rejected       int                 `json:"rejected"`
+	RejectionRate  float64             `json:"rejection_rate"`
+	Clients        int                 `json:"clients"`         // Distinct buckets
+	LimitedClients int                 `json:"limited_clients"` // Buckets with at least one rejection
+	TopClients     []*SimulationClient `json:"top_clients"`     // Most rejected buckets first
+}
+
+// simulationIdentifiers are the request attributes a simulated bucket may be keyed by
+var simulationIdentifiers = map[string]func(*SimulatedRequest) string{
+	"ip": func(r *SimulatedRequest) string { return r.ClientIP },
+	"consumer": func(r *SimulatedRequest) string {
+		if r.Consumer == "" {
+			return "anonymous"
+		}
+		return r.Consumer
+	},
+	"method": func(r *SimulatedRequest) string { return r.Method },
+	"path":   func(r *SimulatedRequest) string { return r.Path },
+}
+
+// Validate checks that the policy can be simulated
+func (p *SimulationPolicy) Validate() error {
+	if p.Capacity <= 0 {
+		return fmt.Errorf("capacity must be positive")
+	}
+	if p.RefillRate < 0 {
+		return fmt.Errorf("refill_rate must not be negative")
+	}
+	if len(p.Identifiers) == 0 {
+		return fmt.Errorf("at least one identifier is required")
+	}
+	for _, identifier := range p.Identifiers {
+		if simulationIdentifiers[identifier] == nil {
+			return fmt.Errorf("unknown identifier %q; use ip, consumer, method or path", identifier)
+		}
+	}
+	return nil
+}
+
+// Simulate replays requests in time order against token buckets of the
+// policy, each starting full and refilling continuously, and reports how many
+// would have been rejected. topClients bounds the clients listed.
+func Simulate(policy SimulationPolicy, requests []*SimulatedRequest, topClients int) *SimulationResult {
+	sorted := append([]*SimulatedRequest(nil), requests...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	type bucket struct {
+		tokens float64
+		last   time.Time
+		client *SimulationClient
+	}
+	buckets := make(map[string]*bucket)
+	result := &SimulationResult{Policy: policy, Requests: len(sorted)}
+
+	parts := make([]string, len(policy.Identifiers))
+	for _, req := range sorted {
+		for i, identifier := range policy.Identifiers {
+			parts[i] = simulationIdentifiers[identifier](req)
+		}
+		key := strings.Join(parts, "|")
+
+		b := buckets[key]
+		if b == nil {
+			b = &bucket{tokens: float64(policy.Capacity), last: req.Time, client: &SimulationClient{Key: key}}
+			buckets[key] = b
+		}
+		b.tokens = min(float64(policy.Capacity), b.tokens+req.Time.Sub(b.last).Seconds()*policy.RefillRate)
+		b.last = req.Time
+		b.client.Requests++
+
+		if b.tokens >= 1 {
+			b.tokens--
+			result.Allowed++
+			continue
+		}
+		result.Rejected++
+		if b.client.Rejected == 0 {
+			first := req.Time
+			b.client.FirstRejected = &first
+			result.LimitedClients++
+		}
+		b.client.Rejected++
+	}
+
+	result.Clients = len(buckets)
+	if result.Requests > 0 {
+		result.RejectionRate = float64(result.Rejected) / float64(result.Requests)
+	}
+
+	result.TopClients = []*SimulationClient{}
+	for _, b := range buckets {
+		if b.client.Rejected > 0 {
+			result.TopClients = append(result.TopClients, b.client)
+		}
+	}
+	sort.Slice(result.TopClients, func(i, j int) bool {
+		a, b := result.TopClients[i], result.TopClients[j]
+		if a.Rejected != b.Rejected {
+			return a.Rejected > b.Rejected
+		}
+		return a.Key < b.Key
+	})
+	if len(result.TopClients) > topClients {
+		result.TopClients = result.TopClients[:topClients]
+	}
+	return result
+}
+
+// SimulationPolicy returns the gateway's own policy in simulation terms.
+// Token-based identifiers become the consumer.
+func (rl *RateLimitMiddleware) SimulationPolicy() SimulationPolicy {
+	identifier := "consumer"
+	if rl.config.Identifier == ClientByIP {
+		identifier = "ip"
+	}
+	return SimulationPolicy{
+		Capacity:    rl.config.Config.Capacity,
+		RefillRate:  float64(rl.config.Config.RefillRate),
+		Identifiers: []string{identifier},
+	}
+}
@@ -0,0 +1,295 @@
+package ratelimit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncConfig represents gossip-based usage sharing between in-memory limiters
+type SyncConfig struct {
+	ListenAddr string        `json:"listen_addr"` // UDP address receiving usage reports from peers
+	Peers      []string      `json:"peers"`       // UDP addresses of the replicas; may include this one
+	Interval   time.Duration `json:"interval"`    // How often local usage is broadcast
+	Key        []byte        `json:"-"`           // Shared HMAC key authenticating usage reports
+}
+
+// maxReportEntries bounds the clients per datagram to stay well below UDP limits
+const maxReportEntries = 200
+
+// maxReportAge rejects stale or replayed usage reports
+const maxReportAge = time.Minute
+
+// peerTTL is how long a silent peer's last sequence is kept. It outlasts the
+// reports accepted from the peer, allowing for clock skew either way, so a
+// forgotten peer's earlier reports cannot be replayed.
+const peerTTL = 3 * maxReportAge
+
+// usageReport carries the tokens consumed on one replica since its last broadcast.
+// Client keys are hashed so API keys and tokens are never sent over the network.
+type usageReport struct {
+	Node   string         `json:"node"`
+	Seq    uint64         `json:"seq"`
+	SentAt time.Time      `json:"sent_at"`
+	Usage  map[string]int `json:"usage"`
+}
+
+// Syncer shares token usage between replicas so each in-memory limiter also
+// drains the budget its peers consumed. Limits are approximate: global
+// overshoot is bounded by what the other replicas admit within one interval.
+type Syncer struct {
+	limiter *RateLimiter
+	config  *SyncConfig
+	conn    *net.UDPConn
+	node    string
+
+	mu       sync.Mutex
+	usage    map[string]int       // Hashed client key -> tokens consumed since the last broadcast
+	lastSeq  map[string]uint64    // Peer node -> latest sequence applied
+	lastSeen map[string]time.Time // Peer node -> when it was last heard from
+	seq      uint64
+
+	done      chan struct{} // Closed by Close to stop broadcasting
+	closeOnce sync.Once
+
+	sent     atomic.Int64
+	received atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewSyncer starts listening for peer usage reports and broadcasting local usage
+func NewSyncer(limiter *RateLimiter, config *SyncConfig) (*Syncer, error) {
+	if len(config.Key) == 0 {
+		return nil, fmt.Errorf("a sync key is required to authenticate usage reports")
+	}
+	addr, err := net.ResolveUDPAddr("udp", config.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sync listen address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for rate limit sync: %w", err)
+	}
+
+	node := make([]byte, 8)
+	rand.Read(node)
+
+	s := &Syncer{
+		limiter:  limiter,
+		config:   config,
+		conn:     conn,
+		node:     hex.EncodeToString(node),
+		usage:    make(map[string]int),
+		lastSeq:  make(map[string]uint64),
+		lastSeen: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+
+	go s.receiveRoutine()
+	go s.broadcastRoutine()
+
+	return s, nil
+}
+
+// CheckRateLimit checks the client's bucket and records admitted usage for peers
+func (s *Syncer) CheckRateLimit(key string, tokens int) *RateLimitResult {
+	bucketKey := hashClientKey(key)
+	result := s.limiter.CheckRateLimit(bucketKey, tokens)
+	if result.Allowed {
+		s.mu.Lock()
+		s.usage[bucketKey] += tokens
+		s.mu.Unlock()
+	}
+	return result
+}
+
+// Stats returns sync counters and when each peer was last heard from
+func (s *Syncer) Stats() map[string]interface{} {
+	s.mu.Lock()
+	peers := make(map[string]string, len(s.lastSeen))
+	for node, seen := range s.lastSeen {
+		peers[node] = seen.Format(time.RFC3339)
+	}
+	s.mu.Unlock()
+
+	return map[string]interface{}{
+		"node":             s.node,
+		"peers":            s.config.Peers,
+		"interval":         s.config.Interval.String(),
+		"reports_sent":     s.sent.Load(),
+		"reports_received": s.received.Load(),
+		"reports_rejected": s.rejected.Load(),
+		"peers_heard":      peers,
+	}
+}
+
+// Close stops broadcasting and listening for peer reports
+func (s *Syncer) Close() error {
+	err := net.ErrClosed
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// broadcastRoutine periodically sends local usage to every peer and forgets
+// peers that went silent, until the syncer is closed
+func (s *Syncer) broadcastRoutine() {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.broadcast()
+			s.prune(now)
+		}
+	}
+}
+
+// prune forgets peers not heard from within peerTTL, such as replicas that
+// were replaced, whose node IDs are never seen again
+func (s *Syncer) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for node, seen := range s.lastSeen {
+		if now.Sub(seen) > peerTTL {
+			delete(s.lastSeen, node)
+			delete(s.lastSeq, node)
+		}
+	}
+}
+
+// broadcast sends the usage recorded since the last broadcast, split into
+// datagrams of at most maxReportEntries clients
+func (s *Syncer) broadcast() {
+	s.mu.Lock()
+	usage := s.usage
+	s.usage = make(map[string]int)
+	s.mu.Unlock()
+
+	if len(usage) == 0 {
+		return
+	}
+
+	// Peers are resolved on every broadcast so replaced replicas are picked up
+	var peers []*net.UDPAddr
+	for _, peer := range s.config.Peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			log.Printf("Rate limit sync: failed to resolve peer %s: %v", peer, err)
+			continue
+		}
+		peers = append(peers, addr)
+	}
+
+	chunk := make(map[string]int)
+	for key, tokens := range usage {
+		chunk[key] = tokens
+		if len(chunk) == maxReportEntries {
+			s.send(peers, chunk)
+			chunk = make(map[string]int)
+		}
+	}
+	if len(chunk) > 0 {
+		s.send(peers, chunk)
+	}
+}
+
+// send signs one usage report and sends it to the peers
+func (s *Syncer) send(peers []*net.UDPAddr, usage map[string]int) {
+	s.mu.Lock()
+	s.seq++
+	report := &usageReport{Node: s.node, Seq: s.seq, SentAt: time.Now(), Usage: usage}
+	s.mu.Unlock()
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return
+	}
+	datagram := append(s.sign(payload), payload...)
+
+	for _, peer := range peers {
+		if _, err := s.conn.WriteToUDP(datagram, peer); err != nil {
+			log.Printf("Rate limit sync: failed to send to %s: %v", peer, err)
+			continue
+		}
+		s.sent.Add(1)
+	}
+}
+
+// receiveRoutine applies usage reports from peers until the connection closes
+func (s *Syncer) receiveRoutine() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !s.apply(buf[:n]) {
+			s.rejected.Add(1)
+		}
+	}
+}
+
+// apply verifies a datagram and drains the reported usage from local
+// buckets, ignoring this replica's own reports
+func (s *Syncer) apply(datagram []byte) bool {
+	if len(datagram) <= sha256.Size {
+		return false
+	}
+	signature, payload := datagram[:sha256.Size], datagram[sha256.Size:]
+	if !hmac.Equal(signature, s.sign(payload)) {
+		return false
+	}
+
+	var report usageReport
+	if err := json.Unmarshal(payload, &report); err != nil {
+		return false
+	}
+	if report.Node == s.node {
+		return true
+	}
+	if age := time.Since(report.SentAt); age > maxReportAge || age < -maxReportAge {
+		return false
+	}
+
+	s.mu.Lock()
+	if report.Seq <= s.lastSeq[report.Node] {
+		s.mu.Unlock()
+		return false
+	}
+	s.lastSeq[report.Node] = report.Seq
+	s.lastSeen[report.Node] = time.Now()
+	s.mu.Unlock()
+
+	for key, tokens := range report.Usage {
+		s.limiter.GetBucket(key).Drain(tokens)
+	}
+	s.received.Add(1)
+	return true
+}
+
+// sign computes the HMAC-SHA256 of a payload
+func (s *Syncer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.config.Key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// hashClientKey derives the bucket key shared between replicas
+func hashClientKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// newTestSyncer creates a syncer on a loopback port that never broadcasts
+// during a test
+func newTestSyncer(t *testing.T, key string) *Syncer {
+	t.Helper()
+	limiter := NewRateLimiter(&RateLimitConfig{Capacity: 10, RefillRate: 1})
+	s, err := NewSyncer(limiter, &SyncConfig{
+		ListenAddr: "127.0.0.1:0",
+		Interval:   time.Hour,
+		Key:        []byte(key),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// datagram builds a signed usage report as a peer with key would send it
+func datagram(t *testing.T, peer *Syncer, report *usageReport) []byte {
+	t.Helper()
+	payload, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(peer.sign(payload), payload...)
+}
+
+func TestSyncerAppliesSignedReports(t *testing.T) {
+	s := newTestSyncer(t, "shared")
+	peer := newTestSyncer(t, "shared")
+
+	report := &usageReport{Node: peer.node, Seq: 1, SentAt: time.Now(), Usage: map[string]int{"client": 4}}
+	if !s.apply(datagram(t, peer, report)) {
+		t.Fatal("valid report rejected")
+	}
+	if tokens := s.limiter.GetBucket("client").GetTokens(); tokens != 6 {
+		t.Errorf("tokens after draining 4 of 10 = %d, want 6", tokens)
+	}
+}
+
+func TestSyncerRejectsTamperedAndReplayedReports(t *testing.T) {
+	s := newTestSyncer(t, "shared")
+	peer := newTestSyncer(t, "shared")
+	outsider := newTestSyncer(t, "other")
+
+	valid := datagram(t, peer, &usageReport{Node: peer.node, Seq: 5, SentAt: time.Now(), Usage: map[string]int{"client": 1}})
+	tampered := append([]byte(nil), valid...)
+	tampered[len(tampered)-3] ^= 0x01
+	badSignature := append([]byte(nil), valid...)
+	badSignature[0] ^= 0x01
+
+	tests := []struct {
+		name     string
+		datagram []byte
+		want     bool
+	}{
+		{"too short", []byte("short"), false},
+		{"tampered payload", tampered, false},
+		{"tampered signature", badSignature, false},
+		{"foreign key", datagram(t, outsider, &usageReport{Node: outsider.node, Seq: 1, SentAt: time.Now(), Usage: map[string]int{"client": 1}}), false},
+		{"stale", datagram(t, peer, &usageReport{Node: peer.node, Seq: 9, SentAt: time.Now().Add(-2 * maxReportAge)}), false},
+		{"from the future", datagram(t, peer, &usageReport{Node: peer.node, Seq: 9, SentAt: time.Now().Add(2 * maxReportAge)}), false},
+		{"valid", valid, true},
+		{"replayed", valid, false},
+		{"older sequence", datagram(t, peer, &usageReport{Node: peer.node, Seq: 4, SentAt: time.Now()}), false},
+		{"newer sequence", datagram(t, peer, &usageReport{Node: peer.node, Seq: 6, SentAt: time.Now()}), true},
+	}
+	for _, tt := range tests {
+		if got := s.apply(tt.datagram); got != tt.want {
+			t.Errorf("%s: apply = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if tokens := s.limiter.GetBucket("client").GetTokens(); tokens != 9 {
+		t.Errorf("tokens = %d, want 9: only the valid report may drain the bucket", tokens)
+	}
+}
+
+func TestSyncerPrunesSilentPeers(t *testing.T) {
+	s := newTestSyncer(t, "shared")
+	peer := newTestSyncer(t, "shared")
+	if !s.apply(datagram(t, peer, &usageReport{Node: peer.node, Seq: 1, SentAt: time.Now()})) {
+		t.Fatal("valid report rejected")
+	}
+
+	s.prune(time.Now())
+	if len(s.lastSeq) != 1 {
+		t.Fatal("peer heard from just now was pruned")
+	}
+	s.prune(time.Now().Add(peerTTL + time.Second))
+	if len(s.lastSeq) != 0 || len(s.lastSeen) != 0 {
+		t.Errorf("silent peer kept: lastSeq %v, lastSeen %v", s.lastSeq, s.lastSeen)
+	}
+}
+
+func TestSyncerCloseStopsBroadcasting(t *testing.T) {
+	limiter := NewRateLimiter(&RateLimitConfig{Capacity: 10, RefillRate: 1})
+	s, err := NewSyncer(limiter, &SyncConfig{
+		ListenAddr: "127.0.0.1:0",
+		Peers:      []string{"127.0.0.1:9"},
+		Interval:   5 * time.Millisecond,
+		Key:        []byte("shared"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CheckRateLimit("client", 1)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Close(); err == nil {
+		t.Error("second Close succeeded")
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("done channel not closed")
+	}
+
+	// Usage recorded after closing is never sent
+	sent := s.sent.Load()
+	s.CheckRateLimit("client", 1)
+	time.Sleep(20 * time.Millisecond)
+	if s.sent.Load() != sent {
+		t.Error("closed syncer kept broadcasting")
+	}
+}
+
+func TestSyncerRequiresKey(t *testing.T) {
+	limiter := NewRateLimiter(&RateLimitConfig{Capacity: 10, RefillRate: 1})
+	if _, err := NewSyncer(limiter, &SyncConfig{
+		ListenAddr: "127.0.0.1:0",
+		Peers:      []string{"127.0.0.1:9"},
+		Interval:   time.Second,
+	}); err == nil {
+		t.Error("NewSyncer without a key succeeded")
+	}
+}
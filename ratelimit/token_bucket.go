@@ -1,113 +1,91 @@
 package ratelimit
 
 import (
+	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// TokenBucket represents a token bucket rate limiter
+// TokenBucket represents a token bucket rate limiter. Its whole state is one
+// atomic timestamp: the time at which the bucket was, or will be, empty.
+// Tokens accrue continuously from then on, so the bucket refills lazily when
+// used and needs no ticker, and concurrent requests update it without locks.
 type TokenBucket struct {
-	capacity     int           // Maximum number of tokens
-	tokens       int           // Current number of tokens
-	refillRate   int           // Tokens added per second
-	lastRefill   time.Time     // Last time tokens were refilled
-	mutex        sync.Mutex    // Protects the bucket state
-	refillTicker *time.Ticker  // Periodic refill ticker
-	stopChan     chan struct{} // Channel to stop the ticker
+	capacity   int   // Maximum number of tokens
+	refillRate int   // Tokens added per second
+	interval   int64 // Nanoseconds per token
+	empty      atomic.Int64
 }
 
 // NewTokenBucket creates a new token bucket
 func NewTokenBucket(capacity, refillRate int) *TokenBucket {
 	tb := &TokenBucket{
 		capacity:   capacity,
-		tokens:     capacity, // Start with full bucket
 		refillRate: refillRate,
-		lastRefill: time.Now(),
-		stopChan:   make(chan struct{}),
+		interval:   int64(time.Second) / int64(max(refillRate, 1)),
 	}
-
-	// Start the refill ticker
-	tb.refillTicker = time.NewTicker(time.Second)
-	go tb.refillLoop()
-
+	tb.empty.Store(time.Now().UnixNano() - tb.fullSpan()) // Start with full bucket
 	return tb
 }
 
-// refillLoop continuously refills tokens
-func (tb *TokenBucket) refillLoop() {
-	for {
-		select {
-		case <-tb.refillTicker.C:
-			tb.refill()
-		case <-tb.stopChan:
-			tb.refillTicker.Stop()
-			return
-		}
-	}
+// fullSpan is how long an empty bucket takes to fill
+func (tb *TokenBucket) fullSpan() int64 {
+	return int64(tb.capacity) * tb.interval
 }
 
-// refill adds tokens to the bucket based on elapsed time
-func (tb *TokenBucket) refill() {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
-
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill)
-	tokensToAdd := int(elapsed.Seconds()) * tb.refillRate
+// tokensAt returns the tokens in a bucket that was empty at empty, which may
+// be negative for a bucket in debt
+func (tb *TokenBucket) tokensAt(empty, now int64) int {
+	elapsed := now - empty
+	if elapsed >= tb.fullSpan() {
+		return tb.capacity
+	}
+	if elapsed < 0 {
+		return -int((-elapsed + tb.interval - 1) / tb.interval)
+	}
+	return int(elapsed / tb.interval)
+}
 
-	if tokensToAdd > 0 {
-		tb.tokens += tokensToAdd
-		if tb.tokens > tb.capacity {
-			tb.tokens = tb.capacity
+// consume takes tokens if the bucket holds enough and returns the tokens left
+func (tb *TokenBucket) consume(tokens int, now int64) (bool, int) {
+	for {
+		empty := tb.empty.Load()
+		// Tokens beyond the capacity are not kept
+		base := max(empty, now-tb.fullSpan())
+		available := tb.tokensAt(base, now)
+		if available < tokens {
+			return false, available
+		}
+		if tb.empty.CompareAndSwap(empty, base+int64(tokens)*tb.interval) {
+			return true, available - tokens
 		}
-		tb.lastRefill = now
 	}
 }
 
 // TryConsume attempts to consume a token from the bucket
 func (tb *TokenBucket) TryConsume(tokens int) bool {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
-
-	// Refill tokens before checking (inline refill to avoid deadlock)
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill)
-	tokensToAdd := int(elapsed.Seconds()) * tb.refillRate
+	allowed, _ := tb.consume(tokens, time.Now().UnixNano())
+	return allowed
+}
 
-	if tokensToAdd > 0 {
-		tb.tokens += tokensToAdd
-		if tb.tokens > tb.capacity {
-			tb.tokens = tb.capacity
+// Drain removes tokens consumed elsewhere, such as on other replicas. The
+// bucket may go into debt of up to its capacity, delaying further requests
+// until the shared budget has refilled.
+func (tb *TokenBucket) Drain(tokens int) {
+	now := time.Now().UnixNano()
+	for {
+		empty := tb.empty.Load()
+		drained := min(max(empty, now-tb.fullSpan())+int64(tokens)*tb.interval, now+tb.fullSpan())
+		if tb.empty.CompareAndSwap(empty, drained) {
+			return
 		}
-		tb.lastRefill = now
 	}
-
-	if tb.tokens >= tokens {
-		tb.tokens -= tokens
-		return true
-	}
-	return false
 }
 
 // GetTokens returns the current number of tokens
 func (tb *TokenBucket) GetTokens() int {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
-
-	// Inline refill to avoid deadlock
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill)
-	tokensToAdd := int(elapsed.Seconds()) * tb.refillRate
-
-	if tokensToAdd > 0 {
-		tb.tokens += tokensToAdd
-		if tb.tokens > tb.capacity {
-			tb.tokens = tb.capacity
-		}
-		tb.lastRefill = now
-	}
-
-	return tb.tokens
+	return tb.tokensAt(tb.empty.Load(), time.Now().UnixNano())
 }
 
 // GetCapacity returns the bucket capacity
@@ -120,9 +98,9 @@ func (tb *TokenBucket) GetRefillRate() int {
 	return tb.refillRate
 }
 
-// Stop stops the token bucket ticker
-func (tb *TokenBucket) Stop() {
-	close(tb.stopChan)
+// full reports whether the bucket is full, and so the same as a new one
+func (tb *TokenBucket) full(now int64) bool {
+	return now-tb.empty.Load() >= tb.fullSpan()
 }
 
 // RateLimitConfig represents configuration for rate limiting
@@ -141,10 +119,11 @@ func DefaultRateLimitConfig() *RateLimitConfig {
 	}
 }
 
-// RateLimiter manages multiple token buckets
+// RateLimiter manages multiple token buckets. Buckets are kept in a sync.Map,
+// which serves lookups of existing keys without locking.
 type RateLimiter struct {
-	buckets map[string]*TokenBucket
-	mutex   sync.RWMutex
+	buckets sync.Map // Key to *TokenBucket
+	count   atomic.Int64
 	config  *RateLimitConfig
 }
 
@@ -155,23 +134,20 @@ func NewRateLimiter(config *RateLimitConfig) *RateLimiter {
 	}
 
 	return &RateLimiter{
-		buckets: make(map[string]*TokenBucket),
-		config:  config,
+		config: config,
 	}
 }
 
 // GetBucket gets or creates a token bucket for a key
 func (rl *RateLimiter) GetBucket(key string) *TokenBucket {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
-
-	bucket, exists := rl.buckets[key]
-	if !exists {
-		bucket = NewTokenBucket(rl.config.Capacity, rl.config.RefillRate)
-		rl.buckets[key] = bucket
+	if bucket, ok := rl.buckets.Load(key); ok {
+		return bucket.(*TokenBucket)
 	}
-
-	return bucket
+	bucket, loaded := rl.buckets.LoadOrStore(key, NewTokenBucket(rl.config.Capacity, rl.config.RefillRate))
+	if !loaded {
+		rl.count.Add(1)
+	}
+	return bucket.(*TokenBucket)
 }
 
 // Allow checks if a request is allowed for the given key
@@ -180,29 +156,71 @@ func (rl *RateLimiter) Allow(key string, tokens int) bool {
 	return bucket.TryConsume(tokens)
 }
 
+// Remove forgets the bucket of a key
+func (rl *RateLimiter) Remove(key string) {
+	if _, loaded := rl.buckets.LoadAndDelete(key); loaded {
+		rl.count.Add(-1)
+	}
+}
+
+// Len returns the number of buckets
+func (rl *RateLimiter) Len() int {
+	return int(rl.count.Load())
+}
+
 // GetStatus returns the current status of a bucket
 func (rl *RateLimiter) GetStatus(key string) (tokens int, capacity int, refillRate int) {
 	bucket := rl.GetBucket(key)
 	return bucket.GetTokens(), bucket.GetCapacity(), bucket.GetRefillRate()
 }
 
-// Cleanup removes old buckets (for memory management)
+// Cleanup removes full buckets, which are the same as new ones, to bound
+// memory. A request racing with the removal may go uncounted.
 func (rl *RateLimiter) Cleanup() {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+	now := time.Now().UnixNano()
+	rl.buckets.Range(func(key, bucket any) bool {
+		if bucket.(*TokenBucket).full(now) && rl.buckets.CompareAndDelete(key, bucket) {
+			rl.count.Add(-1)
+		}
+		return true
+	})
+}
+
+// BucketState is the saved state of a token bucket
+type BucketState struct {
+	Tokens     int       `json:"tokens"`
+	LastRefill time.Time `json:"last_refill"`
+}
 
-	// This is a simple cleanup - in production, you might want more sophisticated logic
-	// For now, we'll keep all buckets as they might be used again
+// Snapshot returns the state of every bucket that is not full; full buckets
+// are the same as new ones
+func (rl *RateLimiter) Snapshot() (any, error) {
+	now := time.Now()
+	states := make(map[string]BucketState)
+	rl.buckets.Range(func(key, value any) bool {
+		bucket := value.(*TokenBucket)
+		if tokens := bucket.tokensAt(bucket.empty.Load(), now.UnixNano()); tokens < bucket.capacity {
+			states[key.(string)] = BucketState{Tokens: tokens, LastRefill: now}
+		}
+		return true
+	})
+	return states, nil
 }
 
-// Stop stops all token buckets
-func (rl *RateLimiter) Stop() {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+// Restore loads saved bucket states. Buckets refill for the time the gateway
+// was down on their next use.
+func (rl *RateLimiter) Restore(data json.RawMessage) error {
+	var states map[string]BucketState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
 
-	for _, bucket := range rl.buckets {
-		bucket.Stop()
+	for key, state := range states {
+		bucket := rl.GetBucket(key)
+		tokens := min(state.Tokens, bucket.capacity)
+		bucket.empty.Store(state.LastRefill.UnixNano() - int64(tokens)*bucket.interval)
 	}
+	return nil
 }
 
 // RateLimitResult represents the result of a rate limit check
@@ -216,26 +234,24 @@ type RateLimitResult struct {
 // CheckRateLimit checks rate limiting and returns detailed result
 func (rl *RateLimiter) CheckRateLimit(key string, tokens int) *RateLimitResult {
 	bucket := rl.GetBucket(key)
-	allowed := bucket.TryConsume(tokens)
-	remaining := bucket.GetTokens()
-
-	// Calculate reset time (when bucket will be full again)
-	capacity := bucket.GetCapacity()
-	refillRate := bucket.GetRefillRate()
+	now := time.Now()
+	allowed, remaining := bucket.consume(tokens, now.UnixNano())
 
 	var resetTime time.Time
 	var retryAfter time.Duration
 
 	if !allowed {
 		// Calculate when enough tokens will be available
-		tokensNeeded := tokens - remaining
-		secondsToWait := float64(tokensNeeded) / float64(refillRate)
-		retryAfter = time.Duration(secondsToWait) * time.Second
-		resetTime = time.Now().Add(retryAfter)
+		retryAfter = time.Duration(int64(tokens-remaining) * bucket.interval)
+		resetTime = now.Add(retryAfter)
 	} else {
 		// Calculate when bucket will be full
-		secondsToFull := float64(capacity-remaining) / float64(refillRate)
-		resetTime = time.Now().Add(time.Duration(secondsToFull) * time.Second)
+		resetTime = now.Add(time.Duration(int64(bucket.capacity-remaining) * bucket.interval))
+	}
+
+	// A bucket drained by other replicas can be in debt
+	if remaining < 0 {
+		remaining = 0
 	}
 
 	return &RateLimitResult{
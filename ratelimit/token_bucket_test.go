@@ -0,0 +1,117 @@
+package ratelimit_test
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/ratelimit"
+	"api-gateway/ratelimit/conformance"
+)
+
+// TestTokenBucketConcurrentBurst races many goroutines on one bucket at a
+// fixed instant, so nothing refills: the CAS on empty must admit exactly the
+// burst, however the attempts interleave
+func TestTokenBucketConcurrentBurst(t *testing.T) {
+	for _, tokens := range []int{1, 3} {
+		tb := ratelimit.NewTokenBucket(100, 10)
+		now := time.Now().UnixNano()
+
+		var admitted atomic.Int64
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := 0; i < 64; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for j := 0; j < 50; j++ {
+					if allowed, remaining := tb.ConsumeAt(tokens, now); allowed {
+						admitted.Add(int64(tokens))
+						if remaining < 0 {
+							t.Errorf("admitted leaving %d tokens", remaining)
+						}
+					}
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if want := int64(100 / tokens * tokens); admitted.Load() != want {
+			t.Errorf("%d tokens per request: admitted %d tokens, want %d", tokens, admitted.Load(), want)
+		}
+	}
+}
+
+// TestTokenBucketConcurrentRefill checks the same bound against the clock:
+// no more than the burst plus what refilled while the goroutines ran
+func TestTokenBucketConcurrentRefill(t *testing.T) {
+	const capacity, rate = 50, 1000
+	tb := ratelimit.NewTokenBucket(capacity, rate)
+	began := time.Now()
+
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Since(began) < 50*time.Millisecond {
+				if tb.TryConsume(1) {
+					admitted.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	limit := int64(capacity) + int64(time.Since(began).Seconds()*rate) + 1
+	if admitted.Load() > limit {
+		t.Errorf("admitted %d requests, more than the burst and refill of %d", admitted.Load(), limit)
+	}
+}
+
+func BenchmarkCheckRateLimit(b *testing.B) {
+	config := &ratelimit.RateLimitConfig{Capacity: 1 << 30, RefillRate: 1 << 20}
+
+	b.Run("single key", func(b *testing.B) {
+		rl := ratelimit.NewRateLimiter(config)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				rl.CheckRateLimit("client", 1)
+			}
+		})
+	})
+
+	b.Run("10k keys", func(b *testing.B) {
+		rl := ratelimit.NewRateLimiter(config)
+		keys := make([]string, 10000)
+		for i := range keys {
+			keys[i] = "client-" + strconv.Itoa(i)
+			rl.GetBucket(keys[i])
+		}
+		var next atomic.Uint64
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			i := next.Add(7919) // Goroutines start at different keys
+			for pb.Next() {
+				rl.CheckRateLimit(keys[i%uint64(len(keys))], 1)
+				i++
+			}
+		})
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	conformance.Run(t, func(config *ratelimit.RateLimitConfig) conformance.Limiter {
+		limiter, err := ratelimit.NewRateLimitMiddleware(&ratelimit.RateLimitMiddlewareConfig{Config: config})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return limiter
+	})
+}
@@ -0,0 +1,138 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Placeholder replaces masked values
+const Placeholder = "[REDACTED]"
+
+// Config represents what is masked before request data is logged or stored
+type Config struct {
+	Headers     []string `json:"headers"`      // Header names, case-insensitive
+	Fields      []string `json:"fields"`       // JSON and form field names at any depth, case-insensitive
+	QueryParams []string `json:"query_params"` // Query parameter names, case-insensitive
+}
+
+// Redactor masks secrets in headers, bodies and query strings
+type Redactor struct {
+	headers map[string]bool
+	fields  map[string]bool
+	query   map[string]bool
+
+	// fieldPattern masks fields in JSON that cannot be parsed, such as
+	// bodies truncated to a size limit
+	fieldPattern *regexp.Regexp
+}
+
+// New creates a redactor
+func New(config *Config) *Redactor {
+	r := &Redactor{
+		headers: make(map[string]bool),
+		fields:  make(map[string]bool),
+		query:   make(map[string]bool),
+	}
+	for _, name := range config.Headers {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	quoted := make([]string, 0, len(config.Fields))
+	for _, name := range config.Fields {
+		r.fields[strings.ToLower(name)] = true
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	for _, name := range config.QueryParams {
+		r.query[strings.ToLower(name)] = true
+	}
+	if len(quoted) > 0 {
+		r.fieldPattern = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+	}
+	return r
+}
+
+// Header returns a copy of a header with secret values masked
+func (r *Redactor) Header(header http.Header) map[string][]string {
+	copied := make(map[string][]string, len(header))
+	for name, values := range header {
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			copied[name] = []string{Placeholder}
+			continue
+		}
+		copied[name] = append([]string(nil), values...)
+	}
+	return copied
+}
+
+// Query masks the values of secret parameters in a raw query string, keeping
+// the order and encoding of the others
+func (r *Redactor) Query(rawQuery string) string {
+	if len(r.query) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+	return maskPairs(rawQuery, r.query)
+}
+
+// Body masks secret fields in a JSON or form body. Other content types are
+// returned unchanged.
+func (r *Redactor) Body(body []byte, contentType string) []byte {
+	if len(r.fields) == 0 || len(body) == 0 {
+		return body
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(maskPairs(string(body), r.fields))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if decoder.Decode(&value) == nil {
+			if masked, err := json.Marshal(r.maskValue(value)); err == nil {
+				return masked
+			}
+		}
+		return r.fieldPattern.ReplaceAll(body, []byte(`${1}"`+Placeholder+`"`))
+	}
+	return body
+}
+
+// maskValue replaces the values of secret fields in decoded JSON
+func (r *Redactor) maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if r.fields[strings.ToLower(key)] {
+				v[key] = Placeholder
+			} else {
+				v[key] = r.maskValue(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.maskValue(item)
+		}
+	}
+	return value
+}
+
+// maskPairs masks the values of the named pairs in a URL-encoded string
+func maskPairs(encoded string, names map[string]bool) string {
+	pairs := strings.Split(encoded, "&")
+	for i, pair := range pairs {
+		key, _, hasValue := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if hasValue && names[strings.ToLower(name)] {
+			pairs[i] = key + "=" + url.QueryEscape(Placeholder)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
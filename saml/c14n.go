@@ -0,0 +1,143 @@
+package saml
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// canonicalize serializes an element with Exclusive XML Canonicalization
+// without comments (https://www.w3.org/TR/xml-exc-c14n/). The omitted
+// element, if any, is left out, as the enveloped signature transform
+// requires. Prefixes in inclusive are rendered as in inclusive
+// canonicalization; "#default" names the default namespace.
+func canonicalize(n *node, omit *node, inclusive []string) string {
+	var b strings.Builder
+	c := &canonicalizer{omit: omit, inclusive: make(map[string]bool, len(inclusive))}
+	for _, prefix := range inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		c.inclusive[prefix] = true
+	}
+	c.write(&b, n, map[string]string{})
+	return b.String()
+}
+
+type canonicalizer struct {
+	omit      *node
+	inclusive map[string]bool
+}
+
+// write serializes an element given the namespaces rendered by its output ancestors
+func (c *canonicalizer) write(b *strings.Builder, n *node, rendered map[string]string) {
+	// Namespaces visibly utilized by the element and its attributes
+	used := map[string]bool{n.prefix: true}
+	var attrs []xml.Attr
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		if attr.Name.Space != "" {
+			used[attr.Name.Space] = true
+		}
+		attrs = append(attrs, attr)
+	}
+	for prefix := range c.inclusive {
+		if _, ok := n.lookupNamespace(prefix); ok {
+			used[prefix] = true
+		}
+	}
+
+	type declaration struct{ prefix, uri string }
+	var declarations []declaration
+	scope, copied := rendered, false
+	for prefix := range used {
+		if prefix == "xml" {
+			continue
+		}
+		uri, _ := n.lookupNamespace(prefix)
+		current, ok := rendered[prefix]
+		if prefix == "" && !ok {
+			// The default namespace starts out empty
+			current, ok = "", true
+		}
+		if ok && current == uri {
+			continue
+		}
+		if !copied {
+			scope = make(map[string]string, len(rendered)+1)
+			for k, v := range rendered {
+				scope[k] = v
+			}
+			copied = true
+		}
+		scope[prefix] = uri
+		declarations = append(declarations, declaration{prefix, uri})
+	}
+	sort.Slice(declarations, func(i, j int) bool {
+		return declarations[i].prefix < declarations[j].prefix
+	})
+
+	// Attributes are sorted by namespace URI, then local name
+	attrNamespace := func(attr xml.Attr) string {
+		if attr.Name.Space == "" {
+			return ""
+		}
+		uri, _ := n.lookupNamespace(attr.Name.Space)
+		return uri
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		si, sj := attrNamespace(attrs[i]), attrNamespace(attrs[j])
+		if si != sj {
+			return si < sj
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	name := qualifiedName(n.prefix, n.local)
+	b.WriteString("<" + name)
+	for _, d := range declarations {
+		if d.prefix == "" {
+			b.WriteString(` xmlns="`)
+		} else {
+			b.WriteString(` xmlns:` + d.prefix + `="`)
+		}
+		b.WriteString(escapeAttr(d.uri) + `"`)
+	}
+	for _, attr := range attrs {
+		b.WriteString(" " + qualifiedName(attr.Name.Space, attr.Name.Local) + `="` + escapeAttr(attr.Value) + `"`)
+	}
+	b.WriteString(">")
+
+	for _, child := range n.children {
+		switch child := child.(type) {
+		case string:
+			b.WriteString(escapeText(child))
+		case *node:
+			if child != c.omit {
+				c.write(b, child, scope)
+			}
+		}
+	}
+	b.WriteString("</" + name + ">")
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
@@ -0,0 +1,103 @@
+package saml
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		inclusive []string
+		want      string
+	}{
+		{
+			"empty elements are expanded",
+			`<a><b/></a>`,
+			nil,
+			`<a><b></b></a>`,
+		},
+		{
+			"namespaces come first, then attributes by namespace and name",
+			`<a xmlns:y="urn:y" xmlns:x="urn:x" z="1" y:b="2" x:c="3" a="4"/>`,
+			nil,
+			`<a xmlns:x="urn:x" xmlns:y="urn:y" a="4" z="1" x:c="3" y:b="2"></a>`,
+		},
+		{
+			"unused namespaces are dropped",
+			`<p:a xmlns:p="urn:p" xmlns:q="urn:q"><p:b/></p:a>`,
+			nil,
+			`<p:a xmlns:p="urn:p"><p:b></p:b></p:a>`,
+		},
+		{
+			"namespaces are declared where first used",
+			`<a xmlns:q="urn:q"><b><q:c/><q:d/></b></a>`,
+			nil,
+			`<a><b><q:c xmlns:q="urn:q"></q:c><q:d xmlns:q="urn:q"></q:d></b></a>`,
+		},
+		{
+			"inclusive prefixes are declared on the apex",
+			`<a xmlns:q="urn:q"><q:c/></a>`,
+			[]string{"q"},
+			`<a xmlns:q="urn:q"><q:c></q:c></a>`,
+		},
+		{
+			"default namespace",
+			`<a xmlns="urn:d"><b xmlns=""/></a>`,
+			nil,
+			`<a xmlns="urn:d"><b xmlns=""></b></a>`,
+		},
+		{
+			"comments and processing instructions are dropped",
+			`<a>one<!-- comment -->two<?pi x?></a>`,
+			nil,
+			`<a>onetwo</a>`,
+		},
+		{
+			"text and attributes are escaped",
+			"<a b=\"&lt;&amp;&quot;&#9;&#10;'&gt;\">&lt;&amp;&gt;\"'&#13;</a>",
+			nil,
+			"<a b=\"&lt;&amp;&quot;&#x9;&#xA;'>\">&lt;&amp;&gt;\"'&#xD;</a>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseDocument([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := canonicalize(n, nil, tt.inclusive); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCanonicalizeSubtree checks a subtree renders the namespaces it
+// inherits, and that the omitted element leaves its siblings' text alone
+func TestCanonicalizeSubtree(t *testing.T) {
+	doc, err := parseDocument([]byte(`<p:r xmlns:p="urn:p" xmlns:s="urn:s"><p:a ID="x"> <s:sig/> <p:b/></p:a></p:r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := doc.element("urn:p", "a")
+	sig := a.element("urn:s", "sig")
+	if got, want := canonicalize(a, sig, nil), `<p:a xmlns:p="urn:p" ID="x">  <p:b></p:b></p:a>`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestParseDocumentRejectsDTD(t *testing.T) {
+	for _, input := range []string{
+		`<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+		`<!DOCTYPE a SYSTEM "http://example.com/a.dtd"><a/>`,
+		`<?xml version="1.0"?><!DOCTYPE a><a/>`,
+	} {
+		if _, err := parseDocument([]byte(input)); err == nil {
+			t.Errorf("parsed %s", input)
+		}
+	}
+	for _, input := range []string{``, `<a>`, `<a/><b/>`, `<a></b>`} {
+		if _, err := parseDocument([]byte(input)); err == nil {
+			t.Errorf("parsed malformed %q", input)
+		}
+	}
+}
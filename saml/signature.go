@@ -0,0 +1,156 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Algorithms accepted in signatures
+const (
+	algEnvelopedSignature = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algExcC14N            = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algSHA256             = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512             = "http://www.w3.org/2001/04/xmlenc#sha512"
+	algRSASHA256          = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512          = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+)
+
+var digestAlgorithms = map[string]crypto.Hash{
+	algSHA256: crypto.SHA256,
+	algSHA512: crypto.SHA512,
+}
+
+var signatureAlgorithms = map[string]crypto.Hash{
+	algRSASHA256: crypto.SHA256,
+	algRSASHA512: crypto.SHA512,
+}
+
+// errNotSigned is returned for elements without a signature
+var errNotSigned = errors.New("saml: element is not signed")
+
+// verifySignature checks the enveloped signature of an element against the
+// IdP certificate. Keys embedded in the signature are ignored, and the
+// reference must point at the element itself, so a signature over another
+// part of the document cannot vouch for it.
+func verifySignature(e *node, cert *x509.Certificate) error {
+	signatures := e.elements(nsDSig, "Signature")
+	if len(signatures) == 0 {
+		return errNotSigned
+	}
+	if len(signatures) > 1 {
+		return errors.New("saml: element has several signatures")
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.element(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return errors.New("saml: signature has no SignedInfo")
+	}
+	c14nMethod := signedInfo.element(nsDSig, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attr("Algorithm") != algExcC14N {
+		return errors.New("saml: unsupported canonicalization method")
+	}
+	signatureMethod := signedInfo.element(nsDSig, "SignatureMethod")
+	if signatureMethod == nil {
+		return errors.New("saml: signature has no SignatureMethod")
+	}
+	signatureHash, ok := signatureAlgorithms[signatureMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported signature method %q", signatureMethod.attr("Algorithm"))
+	}
+
+	reference := signedInfo.element(nsDSig, "Reference")
+	if reference == nil {
+		return errors.New("saml: signature must have exactly one reference")
+	}
+	id := e.attr("ID")
+	if id == "" || reference.attr("URI") != "#"+id {
+		return errors.New("saml: signature does not reference the signed element")
+	}
+
+	// Only the enveloped signature and exclusive canonicalization transforms
+	// are accepted; the element is canonicalized even if they are omitted
+	var prefixes []string
+	if transforms := reference.element(nsDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.elements(nsDSig, "Transform") {
+			switch transform.attr("Algorithm") {
+			case algEnvelopedSignature:
+			case algExcC14N:
+				prefixes = inclusivePrefixes(transform)
+			default:
+				return fmt.Errorf("saml: unsupported transform %q", transform.attr("Algorithm"))
+			}
+		}
+	}
+
+	digestMethod := reference.element(nsDSig, "DigestMethod")
+	if digestMethod == nil {
+		return errors.New("saml: reference has no DigestMethod")
+	}
+	digestHash, ok := digestAlgorithms[digestMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported digest method %q", digestMethod.attr("Algorithm"))
+	}
+	digestValue := reference.element(nsDSig, "DigestValue")
+	if digestValue == nil {
+		return errors.New("saml: reference has no DigestValue")
+	}
+	expected, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return fmt.Errorf("saml: invalid digest value: %w", err)
+	}
+	if subtle.ConstantTimeCompare(hash(digestHash, canonicalize(e, signature, prefixes)), expected) != 1 {
+		return errors.New("saml: digest mismatch")
+	}
+
+	signatureValue := signature.element(nsDSig, "SignatureValue")
+	if signatureValue == nil {
+		return errors.New("saml: signature has no SignatureValue")
+	}
+	value, err := decodeBase64(signatureValue.text())
+	if err != nil {
+		return fmt.Errorf("saml: invalid signature value: %w", err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("saml: IdP certificate must have an RSA key")
+	}
+	digest := hash(signatureHash, canonicalize(signedInfo, nil, inclusivePrefixes(c14nMethod)))
+	if err := rsa.VerifyPKCS1v15(key, signatureHash, digest, value); err != nil {
+		return errors.New("saml: invalid signature")
+	}
+	return nil
+}
+
+// inclusivePrefixes returns the InclusiveNamespaces PrefixList of a
+// canonicalization method
+func inclusivePrefixes(method *node) []string {
+	if inclusive := method.element(nsExcC14N, "InclusiveNamespaces"); inclusive != nil {
+		return strings.Fields(inclusive.attr("PrefixList"))
+	}
+	return nil
+}
+
+func hash(h crypto.Hash, data string) []byte {
+	switch h {
+	case crypto.SHA512:
+		sum := sha512.Sum512([]byte(data))
+		return sum[:]
+	default:
+		sum := sha256.Sum256([]byte(data))
+		return sum[:]
+	}
+}
+
+// decodeBase64 decodes base64 that may be wrapped over several lines
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,144 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIdP is an identity provider key and certificate, generated once as
+// RSA keys are slow to make
+var testIdP = sync.OnceValues(func() (*rsa.PrivateKey, *x509.Certificate) {
+	return newIdP("idp.example.com")
+})
+
+func newIdP(name string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return key, cert
+}
+
+// sign adds an enveloped signature to the element with the given ID, in
+// place of the <!--sig:ID--> marker in the document. It signs with the
+// package's own canonicalization, so it tests verification, not c14n.
+func sign(t *testing.T, doc, id string, key *rsa.PrivateKey) string {
+	t.Helper()
+	marker := "<!--sig:" + id + "-->"
+	if !strings.Contains(doc, marker) {
+		t.Fatalf("no signature marker for %s", id)
+	}
+	root, err := parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := findID(root, id)
+	if signed == nil {
+		t.Fatalf("no element with ID %s", id)
+	}
+	digest := sha256.Sum256([]byte(canonicalize(signed, nil, nil)))
+
+	signature := `<ds:Signature xmlns:ds="` + nsDSig + `"><ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + algRSASHA256 + `"/>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnvelopedSignature + `"/><ds:Transform Algorithm="` + algExcC14N + `"/>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="` + algSHA256 + `"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo><ds:SignatureValue>pending</ds:SignatureValue></ds:Signature>`
+	doc = strings.Replace(doc, marker, signature, 1)
+
+	root, err = parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedInfo := findID(root, id).element(nsDSig, "Signature").element(nsDSig, "SignedInfo")
+	sum := sha256.Sum256([]byte(canonicalize(signedInfo, nil, nil)))
+	value, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(doc, "<ds:SignatureValue>pending<", "<ds:SignatureValue>"+base64.StdEncoding.EncodeToString(value)+"<", 1)
+}
+
+// findID returns the element with an ID attribute
+func findID(n *node, id string) *node {
+	if n.attr("ID") == id {
+		return n
+	}
+	for _, child := range n.children {
+		if child, ok := child.(*node); ok {
+			if found := findID(child, id); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func TestVerifySignature(t *testing.T) {
+	key, cert := testIdP()
+	const doc = `<x:Doc xmlns:x="urn:x" ID="_d"><x:Value>42</x:Value><!--sig:_d--></x:Doc>`
+	signed := sign(t, doc, "_d", key)
+	otherKey, otherCert := newIdP("other.example.com")
+
+	tests := []struct {
+		name string
+		doc  string
+		cert *x509.Certificate
+		err  string
+	}{
+		{"valid", signed, cert, ""},
+		{"another IdP's certificate", signed, otherCert, "invalid signature"},
+		{"signed by another key", sign(t, doc, "_d", otherKey), cert, "invalid signature"},
+		{"changed content", strings.Replace(signed, ">42<", ">43<", 1), cert, "digest mismatch"},
+		{"comment in content", strings.Replace(signed, ">42<", ">4<!--x-->2<", 1), cert, ""},
+		{"changed ID", strings.Replace(signed, `ID="_d"`, `ID="_e"`, 1), cert, "does not reference"},
+		{"unsigned", strings.Replace(doc, "<!--sig:_d-->", "", 1), cert, "not signed"},
+		{"two signatures", strings.Replace(signed, "</x:Doc>", signed[strings.Index(signed, "<ds:Signature"):strings.Index(signed, "</x:Doc>")]+"</x:Doc>", 1), cert, "several signatures"},
+		{"SHA-1 digest", strings.Replace(signed, algSHA256, "http://www.w3.org/2000/09/xmldsig#sha1", 1), cert, "unsupported digest"},
+		{"RSA-SHA1 signature", strings.Replace(signed, algRSASHA256, "http://www.w3.org/2000/09/xmldsig#rsa-sha1", 1), cert, "unsupported signature method"},
+		{"XPath transform", strings.Replace(signed, algEnvelopedSignature, "http://www.w3.org/TR/1999/REC-xpath-19991116", 1), cert, "unsupported transform"},
+		{"inclusive canonicalization", strings.Replace(signed, `<ds:CanonicalizationMethod Algorithm="`+algExcC14N, `<ds:CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315`, 1), cert, "unsupported canonicalization"},
+		{"two references", strings.Replace(signed, "</ds:SignedInfo>", `<ds:Reference URI="#_d"/></ds:SignedInfo>`, 1), cert, "exactly one reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseDocument([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verifySignature(root, tt.cert)
+			if tt.err == "" && err != nil {
+				t.Fatalf("err = %v, want none", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// Protocol identifiers
+const (
+	bindingHTTPPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	statusSuccess       = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	nameIDUnspecified   = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	requestLifetime     = 5 * time.Minute
+	defaultAssertionTTL = 5 * time.Minute
+)
+
+// Config configures the gateway as a SAML service provider
+type Config struct {
+	EntityID          string
+	ACSURL            string // Where the IdP posts responses
+	IdPEntityID       string
+	IdPSSOURL         string // IdP endpoint receiving AuthnRequests with the HTTP-Redirect binding
+	IdPCertFile       string // PEM certificate the IdP signs with
+	UsernameAttribute string // The NameID is used when empty or missing
+	EmailAttribute    string
+	GroupsAttribute   string
+	RoleGroups        map[string][]string // Role to the groups granting it
+	DefaultRoles      []string            // Granted to every SSO user
+	AllowIdPInitiated bool                // Accept responses not answering an AuthnRequest
+	ClockSkew         time.Duration
+}
+
+// Assertion is the validated content of an IdP assertion
+type Assertion struct {
+	ID         string
+	NameID     string
+	Attributes map[string][]string
+}
+
+// ServiceProvider validates SAML responses posted by an identity provider
+type ServiceProvider struct {
+	config     *Config
+	cert       *x509.Certificate
+	groupRoles map[string][]string // Lowercased group name to roles
+
+	mu         sync.Mutex
+	requests   map[string]time.Time // Outstanding AuthnRequest IDs to their expiry
+	assertions map[string]time.Time // Consumed assertion IDs to their expiry
+}
+
+// NewServiceProvider creates a service provider trusting the configured IdP certificate
+func NewServiceProvider(config *Config) (*ServiceProvider, error) {
+	if u, err := url.Parse(config.ACSURL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid ACS URL %q", config.ACSURL)
+	}
+	if u, err := url.Parse(config.IdPSSOURL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid IdP SSO URL %q", config.IdPSSOURL)
+	}
+	if config.IdPEntityID == "" {
+		return nil, errors.New("IdP entity ID is required")
+	}
+	data, err := os.ReadFile(config.IdPCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IdP certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", config.IdPCertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IdP certificate: %w", err)
+	}
+
+	sp := &ServiceProvider{
+		config:     config,
+		cert:       cert,
+		groupRoles: make(map[string][]string),
+		requests:   make(map[string]time.Time),
+		assertions: make(map[string]time.Time),
+	}
+	for role, groups := range config.RoleGroups {
+		for _, group := range groups {
+			key := strings.ToLower(group)
+			sp.groupRoles[key] = append(sp.groupRoles[key], role)
+		}
+	}
+	return sp, nil
+}
+
+// Metadata returns the SP metadata document to register with the IdP
+func (sp *ServiceProvider) Metadata() []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<md:EntityDescriptor xmlns:md="%s" entityID="%s">`, nsMetadata, escapeAttr(sp.config.EntityID))
+	fmt.Fprintf(&b, `<md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">`, nsProtocol)
+	fmt.Fprintf(&b, `<md:NameIDFormat>%s</md:NameIDFormat>`, nameIDUnspecified)
+	fmt.Fprintf(&b, `<md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>`, bindingHTTPPOST, escapeAttr(sp.config.ACSURL))
+	b.WriteString(`</md:SPSSODescriptor></md:EntityDescriptor>` + "\n")
+	return b.Bytes()
+}
+
+// AuthnRequestURL returns the IdP URL starting a login, with the request
+// encoded for the HTTP-Redirect binding. The IdP returns relayState as is.
+func (sp *ServiceProvider) AuthnRequestURL(relayState string) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	request := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`+
+		`<saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`,
+		nsProtocol, nsAssertion, id, time.Now().UTC().Format(time.RFC3339),
+		escapeAttr(sp.config.IdPSSOURL), escapeAttr(sp.config.ACSURL), bindingHTTPPOST, escapeText(sp.config.EntityID))
+
+	var deflated bytes.Buffer
+	writer, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	writer.Write([]byte(request))
+	writer.Close()
+
+	u, err := url.Parse(sp.config.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	u.RawQuery = query.Encode()
+
+	now := time.Now()
+	sp.mu.Lock()
+	expire(sp.requests, now)
+	sp.requests[id] = now.Add(requestLifetime)
+	sp.mu.Unlock()
+	return u.String(), nil
+}
+
+// ParseResponse validates a base64 encoded Response posted to the ACS and
+// returns its assertion. The response or the assertion must be signed by
+// the IdP, and each assertion is accepted only once.
+func (sp *ServiceProvider) ParseResponse(encoded string) (*Assertion, error) {
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("saml: invalid response encoding: %w", err)
+	}
+	response, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !response.is(nsProtocol, "Response") {
+		return nil, errors.New("saml: document is not a Response")
+	}
+	if destination := response.attr("Destination"); destination != "" && destination != sp.config.ACSURL {
+		return nil, fmt.Errorf("saml: response is destined for %q", destination)
+	}
+	if issuer := response.element(nsAssertion, "Issuer"); issuer != nil && issuer.text() != sp.config.IdPEntityID {
+		return nil, fmt.Errorf("saml: response issued by unknown IdP %q", issuer.text())
+	}
+	if status := statusCode(response); status != statusSuccess {
+		return nil, fmt.Errorf("saml: login failed with status %q", status)
+	}
+
+	if len(response.elements(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("saml: encrypted assertions are not supported")
+	}
+	assertion := response.element(nsAssertion, "Assertion")
+	if assertion == nil {
+		return nil, errors.New("saml: response must contain exactly one assertion")
+	}
+
+	// A signed response covers its assertion; otherwise the assertion must be signed
+	responseErr := verifySignature(response, sp.cert)
+	if responseErr != nil && responseErr != errNotSigned {
+		return nil, responseErr
+	}
+	if err := verifySignature(assertion, sp.cert); err != nil && (err != errNotSigned || responseErr != nil) {
+		return nil, err
+	}
+
+	if issuer := assertion.element(nsAssertion, "Issuer"); issuer == nil || issuer.text() != sp.config.IdPEntityID {
+		return nil, errors.New("saml: assertion is not issued by the IdP")
+	}
+	id := assertion.attr("ID")
+	if id == "" {
+		return nil, errors.New("saml: assertion has no ID")
+	}
+
+	now := time.Now()
+	if err := sp.checkConditions(assertion, now); err != nil {
+		return nil, err
+	}
+	subject := assertion.element(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, errors.New("saml: assertion has no subject")
+	}
+	nameID := subject.element(nsAssertion, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return nil, errors.New("saml: assertion has no NameID")
+	}
+	inResponseTo, expiry, err := sp.checkConfirmation(subject, response.attr("InResponseTo"), now)
+	if err != nil {
+		return nil, err
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	expire(sp.requests, now)
+	expire(sp.assertions, now)
+	if inResponseTo != "" {
+		if _, ok := sp.requests[inResponseTo]; !ok {
+			return nil, errors.New("saml: response to an unknown or expired request")
+		}
+	} else if !sp.config.AllowIdPInitiated {
+		return nil, errors.New("saml: IdP-initiated login is not allowed")
+	}
+	if _, seen := sp.assertions[id]; seen {
+		return nil, errors.New("saml: assertion has already been used")
+	}
+	delete(sp.requests, inResponseTo)
+	sp.assertions[id] = expiry
+
+	return &Assertion{
+		ID:         id,
+		NameID:     nameID.text(),
+		Attributes: attributes(assertion),
+	}, nil
+}
+
+// User converts an assertion to an account, mapping its groups to roles
+func (sp *ServiceProvider) User(a *Assertion) *auth.User {
+	user := &auth.User{
+		ID:       a.NameID,
+		Username: a.attribute(sp.config.UsernameAttribute),
+		Email:    a.attribute(sp.config.EmailAttribute),
+		Roles:    append([]string(nil), sp.config.DefaultRoles...),
+	}
+	if user.Username == "" {
+		user.Username = a.NameID
+	}
+	if sp.config.GroupsAttribute != "" {
+		for _, group := range a.Attributes[sp.config.GroupsAttribute] {
+			user.Roles = auth.MergeValues(user.Roles, sp.groupRoles[strings.ToLower(group)])
+		}
+	}
+	return user
+}
+
+// attribute returns the first value of an attribute
+func (a *Assertion) attribute(name string) string {
+	if values := a.Attributes[name]; name != "" && len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// checkConditions checks the validity window and audience of an assertion
+func (sp *ServiceProvider) checkConditions(assertion *node, now time.Time) error {
+	conditions := assertion.element(nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil
+	}
+	if notBefore := conditions.attr("NotBefore"); notBefore != "" {
+		t, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil || now.Add(sp.config.ClockSkew).Before(t) {
+			return errors.New("saml: assertion is not yet valid")
+		}
+	}
+	if notOnOrAfter := conditions.attr("NotOnOrAfter"); notOnOrAfter != "" {
+		t, err := time.Parse(time.RFC3339, notOnOrAfter)
+		if err != nil || !now.Add(-sp.config.ClockSkew).Before(t) {
+			return errors.New("saml: assertion has expired")
+		}
+	}
+	// Every audience restriction must name this SP
+	for _, restriction := range conditions.elements(nsAssertion, "AudienceRestriction") {
+		allowed := false
+		for _, audience := range restriction.elements(nsAssertion, "Audience") {
+			if audience.text() == sp.config.EntityID {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return errors.New("saml: assertion is intended for another audience")
+		}
+	}
+	return nil
+}
+
+// checkConfirmation finds a bearer confirmation of the subject that is valid
+// at the ACS, returning the request it answers and when it expires
+func (sp *ServiceProvider) checkConfirmation(subject *node, inResponseTo string, now time.Time) (string, time.Time, error) {
+	for _, confirmation := range subject.elements(nsAssertion, "SubjectConfirmation") {
+		if confirmation.attr("Method") != confirmationBearer {
+			continue
+		}
+		data := confirmation.element(nsAssertion, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != sp.config.ACSURL || data.attr("InResponseTo") != inResponseTo {
+			continue
+		}
+		expiry := now.Add(defaultAssertionTTL)
+		if notOnOrAfter := data.attr("NotOnOrAfter"); notOnOrAfter != "" {
+			t, err := time.Parse(time.RFC3339, notOnOrAfter)
+			if err != nil || !now.Add(-sp.config.ClockSkew).Before(t) {
+				continue
+			}
+			expiry = t.Add(sp.config.ClockSkew)
+		}
+		return inResponseTo, expiry, nil
+	}
+	return "", time.Time{}, errors.New("saml: no valid bearer subject confirmation for this SP")
+}
+
+// statusCode returns the top-level status code of a response
+func statusCode(response *node) string {
+	status := response.element(nsProtocol, "Status")
+	if status == nil {
+		return ""
+	}
+	code := status.element(nsProtocol, "StatusCode")
+	if code == nil {
+		return ""
+	}
+	return code.attr("Value")
+}
+
+// attributes collects the attribute statements of an assertion by name,
+// also indexing attributes by their friendly name
+func attributes(assertion *node) map[string][]string {
+	values := make(map[string][]string)
+	for _, statement := range assertion.elements(nsAssertion, "AttributeStatement") {
+		for _, attribute := range statement.elements(nsAssertion, "Attribute") {
+			var texts []string
+			for _, value := range attribute.elements(nsAssertion, "AttributeValue") {
+				texts = append(texts, value.text())
+			}
+			for _, name := range []string{attribute.attr("Name"), attribute.attr("FriendlyName")} {
+				if name != "" {
+					values[name] = append(values[name], texts...)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// expire removes the entries that have expired
+func expire(entries map[string]time.Time, now time.Time) {
+	for id, expiry := range entries {
+		if !now.Before(expiry) {
+			delete(entries, id)
+		}
+	}
+}
+
+// newID returns a random identifier, which must not start with a digit
+func newID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
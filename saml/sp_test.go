@@ -0,0 +1,259 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testACS    = "https://gateway.example.com/auth/saml/acs"
+	testEntity = "https://gateway.example.com/saml"
+	testIssuer = "https://idp.example.com"
+)
+
+func newTestSP(t *testing.T, idpInitiated bool) *ServiceProvider {
+	t.Helper()
+	_, cert := testIdP()
+	certFile := filepath.Join(t.TempDir(), "idp.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sp, err := NewServiceProvider(&Config{
+		EntityID:          testEntity,
+		ACSURL:            testACS,
+		IdPEntityID:       testIssuer,
+		IdPSSOURL:         "https://idp.example.com/sso",
+		IdPCertFile:       certFile,
+		GroupsAttribute:   "groups",
+		RoleGroups:        map[string][]string{"admin": {"Admins"}},
+		DefaultRoles:      []string{"user"},
+		AllowIdPInitiated: idpInitiated,
+		ClockSkew:         time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// response returns an IdP-initiated response with one assertion. Fields
+// replace the placeholders of the template, and <!--sig:_resp--> and
+// <!--sig:_a1--> mark where signatures go.
+func response(fields map[string]string) string {
+	now := time.Now().UTC()
+	values := map[string]string{
+		"{ACS}":          testACS,
+		"{RECIPIENT}":    testACS,
+		"{AUDIENCE}":     testEntity,
+		"{ISSUER}":       testIssuer,
+		"{NAMEID}":       "alice@example.com",
+		"{IN_RESPONSE}":  "",
+		"{NOT_BEFORE}":   now.Add(-time.Minute).Format(time.RFC3339),
+		"{NOT_ON_AFTER}": now.Add(5 * time.Minute).Format(time.RFC3339),
+		"{CONFIRM_BY}":   now.Add(5 * time.Minute).Format(time.RFC3339),
+	}
+	for k, v := range fields {
+		values[k] = v
+	}
+	var replacements []string
+	for k, v := range values {
+		replacements = append(replacements, k, v)
+	}
+	return strings.NewReplacer(replacements...).Replace(`<samlp:Response xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `" ID="_resp" Version="2.0" Destination="{ACS}" InResponseTo="{IN_RESPONSE}">` +
+		`<saml:Issuer>{ISSUER}</saml:Issuer><!--sig:_resp-->` +
+		`<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"/></samlp:Status>` +
+		`<saml:Assertion ID="_a1" Version="2.0">` +
+		`<saml:Issuer>{ISSUER}</saml:Issuer><!--sig:_a1-->` +
+		`<saml:Subject><saml:NameID>{NAMEID}</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + confirmationBearer + `">` +
+		`<saml:SubjectConfirmationData Recipient="{RECIPIENT}" NotOnOrAfter="{CONFIRM_BY}" InResponseTo="{IN_RESPONSE}"/>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="{NOT_BEFORE}" NotOnOrAfter="{NOT_ON_AFTER}">` +
+		`<saml:AudienceRestriction><saml:Audience>{AUDIENCE}</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`<saml:AttributeStatement><saml:Attribute Name="urn:oid:groups" FriendlyName="groups">` +
+		`<saml:AttributeValue>Admins</saml:AttributeValue><saml:AttributeValue>Staff</saml:AttributeValue>` +
+		`</saml:Attribute></saml:AttributeStatement>` +
+		`</saml:Assertion></samlp:Response>`)
+}
+
+// signAssertion signs the assertion only, as most IdPs do
+func signAssertion(t *testing.T, doc string) string {
+	key, _ := testIdP()
+	return sign(t, doc, "_a1", key)
+}
+
+func post(doc string) string {
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+// assertionOf returns the assertion element of a document as written
+func assertionOf(doc string) string {
+	return doc[strings.Index(doc, "<saml:Assertion") : strings.Index(doc, "</saml:Assertion>")+len("</saml:Assertion>")]
+}
+
+func TestParseResponse(t *testing.T) {
+	key, _ := testIdP()
+	for name, doc := range map[string]string{
+		"signed assertion": signAssertion(t, response(map[string]string{"{NAMEID}": "a1"})),
+		"signed response":  sign(t, response(map[string]string{"{NAMEID}": "a2"}), "_resp", key),
+		"signed both":      sign(t, signAssertion(t, response(map[string]string{"{NAMEID}": "a3"})), "_resp", key),
+	} {
+		// Each response carries assertion _a1, so each needs a fresh SP
+		assertion, err := newTestSP(t, true).ParseResponse(post(doc))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if assertion.ID != "_a1" || assertion.NameID == "" {
+			t.Errorf("%s: parsed %+v", name, assertion)
+		}
+	}
+
+	sp := newTestSP(t, true)
+	assertion, err := sp.ParseResponse(post(signAssertion(t, response(nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := sp.User(assertion)
+	if user.ID != "alice@example.com" || user.Username != "alice@example.com" || strings.Join(user.Roles, ",") != "user,admin" {
+		t.Errorf("user %+v", user)
+	}
+}
+
+// TestParseResponseCommentInNameID checks a comment an attacker inserts in a
+// signed NameID, which canonicalization ignores, cannot truncate it: the
+// NameID is read whole, as it was signed
+func TestParseResponseCommentInNameID(t *testing.T) {
+	signed := signAssertion(t, response(map[string]string{"{NAMEID}": "admin@example.com.evil.example"}))
+	injected := strings.Replace(signed, "admin@example.com.evil.example", "admin@example.com<!---->.evil.example", 1)
+	assertion, err := newTestSP(t, true).ParseResponse(post(injected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.NameID != "admin@example.com.evil.example" {
+		t.Errorf("NameID %q, want the signed value", assertion.NameID)
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	key, _ := testIdP()
+	otherKey, _ := newIdP("other.example.com")
+	signed := signAssertion(t, response(nil))
+	original := assertionOf(signed)
+	evil := assertionOf(response(map[string]string{"{NAMEID}": "admin@example.com"}))
+	evil = strings.Replace(strings.Replace(evil, `ID="_a1"`, `ID="_evil"`, 1), "<!--sig:_a1-->", "", 1)
+	signature := original[strings.Index(original, "<ds:Signature") : strings.Index(original, "</ds:Signature>")+len("</ds:Signature>")]
+	signedResponse := sign(t, response(nil), "_resp", key)
+
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"unsigned", response(nil), "not signed"},
+		{"signed by another key", sign(t, response(nil), "_a1", otherKey), "invalid signature"},
+		{"changed NameID", strings.Replace(signed, "alice@example.com", "admin@example.com", 1), "digest mismatch"},
+		{"changed signed response", strings.Replace(signedResponse, "alice@example.com", "admin@example.com", 1), "digest mismatch"},
+		{"DTD", `<!DOCTYPE r [<!ENTITY e "x">]>` + signed, "DTD"},
+		{
+			// The signed assertion is hidden inside an unsigned one
+			"wrapped in the evil assertion",
+			strings.Replace(signed, original, strings.Replace(evil, "</saml:Assertion>", "<saml:Advice>"+original+"</saml:Advice></saml:Assertion>", 1), 1),
+			"not signed",
+		},
+		{
+			// The evil assertion carries the signature over the genuine one
+			"reference to another ID",
+			strings.Replace(signed, original, strings.Replace(evil, "</saml:Issuer>", "</saml:Issuer>"+signature, 1), 1),
+			"does not reference",
+		},
+		{
+			"evil assertion beside the signed one",
+			strings.Replace(signed, original, evil+original, 1),
+			"exactly one assertion",
+		},
+		{
+			"evil assertion in a signed response's extensions",
+			strings.Replace(signedResponse, "<samlp:Status>", "<samlp:Extensions>"+evil+"</samlp:Extensions><samlp:Status>", 1),
+			"digest mismatch",
+		},
+		{"two signatures", strings.Replace(signed, signature, signature+signature, 1), "several signatures"},
+		{"wrong audience", signAssertion(t, response(map[string]string{"{AUDIENCE}": "https://other.example.com"})), "another audience"},
+		{"wrong recipient", signAssertion(t, response(map[string]string{"{RECIPIENT}": "https://other.example.com/acs"})), "subject confirmation"},
+		{"wrong destination", signAssertion(t, response(map[string]string{"{ACS}": "https://other.example.com/acs"})), "destined for"},
+		{"wrong issuer", signAssertion(t, response(map[string]string{"{ISSUER}": "https://evil.example.com"})), "unknown IdP"},
+		{"expired", signAssertion(t, response(map[string]string{"{NOT_ON_AFTER}": time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC3339)})), "expired"},
+		{"not yet valid", signAssertion(t, response(map[string]string{"{NOT_BEFORE}": time.Now().Add(2 * time.Minute).UTC().Format(time.RFC3339)})), "not yet valid"},
+		{"confirmation expired", signAssertion(t, response(map[string]string{"{CONFIRM_BY}": time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC3339)})), "subject confirmation"},
+		{"unknown request", signAssertion(t, response(map[string]string{"{IN_RESPONSE}": "_unknown"})), "unknown or expired request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestSP(t, true).ParseResponse(post(tt.doc))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseResponseReplay(t *testing.T) {
+	sp := newTestSP(t, true)
+	doc := post(signAssertion(t, response(nil)))
+	if _, err := sp.ParseResponse(doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.ParseResponse(doc); err == nil || !strings.Contains(err.Error(), "already been used") {
+		t.Errorf("replay: err = %v, want already used", err)
+	}
+}
+
+func TestParseResponseInResponseTo(t *testing.T) {
+	sp := newTestSP(t, false)
+	if _, err := sp.ParseResponse(post(signAssertion(t, response(nil)))); err == nil || !strings.Contains(err.Error(), "IdP-initiated") {
+		t.Fatalf("unsolicited response: err = %v, want IdP-initiated refused", err)
+	}
+
+	login, err := sp.AuthnRequestURL("/dashboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := regexp.MustCompile(` ID="([^"]+)"`).FindSubmatch(request)
+	if id == nil || u.Query().Get("RelayState") != "/dashboard" {
+		t.Fatalf("request %s with relay state %q", request, u.Query().Get("RelayState"))
+	}
+
+	doc := post(signAssertion(t, response(map[string]string{"{IN_RESPONSE}": string(id[1])})))
+	if _, err := sp.ParseResponse(doc); err != nil {
+		t.Fatal(err)
+	}
+	// The request is answered; another assertion cannot answer it again
+	key, _ := testIdP()
+	again := strings.NewReplacer(`ID="_a1"`, `ID="_a2"`, "<!--sig:_a1-->", "<!--sig:_a2-->").
+		Replace(response(map[string]string{"{IN_RESPONSE}": string(id[1])}))
+	if _, err := sp.ParseResponse(post(sign(t, again, "_a2", key))); err == nil || !strings.Contains(err.Error(), "unknown or expired request") {
+		t.Errorf("second response to the request: err = %v, want unknown request", err)
+	}
+}
@@ -0,0 +1,146 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Namespaces of the SAML and XML Signature elements
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+)
+
+// node is an element of a parsed document. Unlike encoding/xml's decoded
+// names, it keeps the prefixes written in the document, which
+// canonicalization needs.
+type node struct {
+	prefix   string
+	local    string
+	attrs    []xml.Attr // Name.Space holds the prefix; namespace declarations included
+	children []any      // *node or string
+	parent   *node
+}
+
+// parseDocument parses a document into a tree. Documents with a DTD are
+// rejected, so entity expansion cannot be abused.
+func parseDocument(data []byte) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *node
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{prefix: t.Name.Space, local: t.Name.Local, attrs: t.Attr, parent: current}
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("saml: multiple root elements")
+				}
+				root = n
+			} else {
+				current.children = append(current.children, n)
+			}
+			current = n
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.prefix || t.Name.Local != current.local {
+				return nil, fmt.Errorf("saml: unexpected end element %s", t.Name.Local)
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, string(t))
+			}
+		case xml.Directive:
+			return nil, errors.New("saml: documents with a DTD are not accepted")
+		}
+	}
+	if root == nil || current != nil {
+		return nil, errors.New("saml: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNamespace returns the namespace bound to a prefix in scope at the
+// element; the empty prefix is the default namespace
+func (n *node) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+	for e := n; e != nil; e = e.parent {
+		for _, attr := range e.attrs {
+			if prefix == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+				return attr.Value, true
+			}
+			if prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix {
+				return attr.Value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// namespace returns the namespace of the element
+func (n *node) namespace() string {
+	uri, _ := n.lookupNamespace(n.prefix)
+	return uri
+}
+
+// is reports whether the element has the given namespace and local name
+func (n *node) is(namespace, local string) bool {
+	return n.local == local && n.namespace() == namespace
+}
+
+// attr returns an unprefixed attribute
+func (n *node) attr(local string) string {
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "" && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// elements returns the child elements with the given namespace and local name
+func (n *node) elements(namespace, local string) []*node {
+	var matches []*node
+	for _, child := range n.children {
+		if e, ok := child.(*node); ok && e.is(namespace, local) {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
+// element returns the only child element with the given name, or nil
+func (n *node) element(namespace, local string) *node {
+	if matches := n.elements(namespace, local); len(matches) == 1 {
+		return matches[0]
+	}
+	return nil
+}
+
+// text returns the element's character data
+func (n *node) text() string {
+	var b strings.Builder
+	for _, child := range n.children {
+		if s, ok := child.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// clamdChunkSize is the largest chunk streamed to clamd at once
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans content with clamd's INSTREAM command
+type ClamdScanner struct {
+	Address string // "host:port", or "unix:<path>" for a local socket
+}
+
+// NewClamdScanner creates a scanner for the clamd daemon at address
+func NewClamdScanner(address string) *ClamdScanner {
+	return &ClamdScanner{Address: address}
+}
+
+// Scan implements Scanner
+func (c *ClamdScanner) Scan(ctx context.Context, content io.Reader) (string, error) {
+	network, address := "tcp", c.Address
+	if path, ok := strings.CutPrefix(c.Address, "unix:"); ok {
+		network, address = "unix", path
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// clamd stops reading when the stream exceeds its size limit, so a
+	// failed write still leaves its reply to read
+	readErr, writeErr := c.stream(conn, content)
+	if readErr != nil {
+		return "", readErr
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("clamd: %w", ctx.Err())
+		}
+		if writeErr != nil {
+			return "", fmt.Errorf("clamd: %w", writeErr)
+		}
+		return "", fmt.Errorf("clamd: reading reply: %w", err)
+	}
+	reply = strings.TrimSuffix(reply, "\x00")
+
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		threat := strings.TrimSuffix(reply, " FOUND")
+		if _, name, ok := strings.Cut(threat, ": "); ok {
+			threat = name
+		}
+		return threat, nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// stream sends content as INSTREAM chunks, each prefixed with its length
+// and ended by an empty chunk. It returns errors reading content apart from
+// errors writing to clamd.
+func (c *ClamdScanner) stream(conn net.Conn, content io.Reader) (readErr, writeErr error) {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := content.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, writeErr := conn.Write(buf[:4+n]); writeErr != nil {
+				return nil, writeErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return nil, err
+}
@@ -0,0 +1,138 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// icapResponseHeader is the HTTP response the scanned content is wrapped in
+const icapResponseHeader = "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+
+// ICAPScanner scans content with an ICAP antivirus service (RFC 3507),
+// sending it as the body of an HTTP response to modify
+type ICAPScanner struct {
+	URL *url.URL // icap://host[:port]/service
+}
+
+// NewICAPScanner creates a scanner for the ICAP service at rawURL
+func NewICAPScanner(rawURL string) (*ICAPScanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("ICAP service URL %q must be icap://host[:port]/service", rawURL)
+	}
+	return &ICAPScanner{URL: u}, nil
+}
+
+// Scan implements Scanner
+func (s *ICAPScanner) Scan(ctx context.Context, content io.Reader) (string, error) {
+	address := s.URL.Host
+	if s.URL.Port() == "" {
+		address = net.JoinHostPort(s.URL.Hostname(), "1344")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// The service may answer before it has read everything, so a failed
+	// write still leaves its reply to read
+	readErr, writeErr := s.send(conn, content)
+	if readErr != nil {
+		return "", readErr
+	}
+
+	reply := textproto.NewReader(bufio.NewReader(conn))
+	status, err := reply.ReadLine()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("icap: %w", ctx.Err())
+		}
+		if writeErr != nil {
+			return "", fmt.Errorf("icap: %w", writeErr)
+		}
+		return "", fmt.Errorf("icap: reading reply: %w", err)
+	}
+	header, err := reply.ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("icap: reading reply headers: %w", err)
+	}
+
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return "", fmt.Errorf("icap: malformed status line %q", status)
+	}
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+		// The service replaced the content, which antivirus services do
+		// with a block page; the threat is named in one of several headers
+		return icapThreat(header), nil
+	default:
+		return "", fmt.Errorf("icap: %s", status)
+	}
+}
+
+// send writes a RESPMOD request carrying content as a chunked body. It
+// returns errors reading content apart from errors writing to the service.
+func (s *ICAPScanner) send(conn net.Conn, content io.Reader) (readErr, writeErr error) {
+	w := bufio.NewWriterSize(conn, clamdChunkSize+16)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", s.URL.String())
+	fmt.Fprintf(w, "Host: %s\r\n", s.URL.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(icapResponseHeader))
+	w.WriteString(icapResponseHeader)
+
+	buf := make([]byte, clamdChunkSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			w.WriteString(strconv.FormatInt(int64(n), 16) + "\r\n")
+			w.Write(buf[:n])
+			if _, writeErr := w.WriteString("\r\n"); writeErr != nil {
+				return nil, writeErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	return nil, w.Flush()
+}
+
+// icapThreat returns the threat an ICAP service reported, from the
+// X-Infection-Found, X-Violations-Found or X-Virus-ID header
+func icapThreat(header textproto.MIMEHeader) string {
+	// X-Infection-Found: Type=0; Resolution=2; Threat=<name>;
+	for _, field := range strings.Split(header.Get("X-Infection-Found"), ";") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(field), "Threat="); ok && name != "" {
+			return name
+		}
+	}
+	// X-Violations-Found: <count>, then filename, threat, id and disposition lines
+	if lines := strings.Fields(header.Get("X-Violations-Found")); len(lines) >= 3 {
+		return lines[2]
+	}
+	if id := strings.TrimSpace(header.Get("X-Virus-ID")); id != "" {
+		return id
+	}
+	return "a threat reported by the ICAP service"
+}
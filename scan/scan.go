@@ -0,0 +1,264 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"api-gateway/metrics"
+	"api-gateway/proxy"
+)
+
+// Scanner checks uploaded content for malware
+type Scanner interface {
+	// Scan reads content to the end and returns the name of the threat
+	// found, or "" when the content is clean
+	Scan(ctx context.Context, content io.Reader) (string, error)
+}
+
+// Route scans the uploads of requests matching its path prefixes
+type Route struct {
+	Name     string
+	Paths    []string
+	Scanner  Scanner
+	Timeout  time.Duration // Limit for scanning one file
+	FailOpen bool          // Let uploads through when the scanner fails
+}
+
+// Config represents upload scanning configuration
+type Config struct {
+	Routes   []*Route
+	SpoolDir string // Directory uploads are held in while scanned; "" uses the system default
+}
+
+// Middleware scans uploads on configured routes before they are proxied.
+// Uploads are spooled to disk while their files stream to the scanner, and
+// only clean uploads are replayed to the backend; other requests stream
+// through untouched.
+type Middleware struct {
+	config *Config
+
+	scans *metrics.CounterVec
+}
+
+// NewMiddleware creates a new upload scanning middleware
+func NewMiddleware(config *Config, reg *metrics.Registry) *Middleware {
+	return &Middleware{
+		config: config,
+		scans: reg.NewCounterVec("gateway_upload_scans_total",
+			"Uploads scanned for malware, by route and result (clean, infected or error).", "route", "result"),
+	}
+}
+
+// Handler returns the HTTP middleware function
+func (m *Middleware) Handler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := m.match(r.URL.Path)
+			if route == nil || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			spool, err := os.CreateTemp(m.config.SpoolDir, "upload-*")
+			if err != nil {
+				log.Printf("Failed to spool upload for scanning: %v", err)
+				http.Error(w, `{"error":"Upload scan unavailable","details":"The upload could not be held for scanning"}`, http.StatusServiceUnavailable)
+				return
+			}
+			body := &spooledBody{File: spool}
+			defer body.Close()
+
+			tee := io.TeeReader(r.Body, body)
+			file, threat, err := m.scan(r, route, tee)
+			if err == nil && threat == "" || err != nil && route.FailOpen && !isUploadError(err) {
+				// Take in whatever the scan left unread so the backend gets the whole body
+				if _, drainErr := io.Copy(io.Discard, tee); drainErr != nil {
+					err = &uploadError{err: drainErr}
+				}
+			}
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case body.err != nil:
+				log.Printf("Failed to spool upload for scanning: %v", body.err)
+				http.Error(w, `{"error":"Upload scan unavailable","details":"The upload could not be held for scanning"}`, http.StatusServiceUnavailable)
+				return
+			case errors.As(err, &maxBytesErr):
+				http.Error(w, fmt.Sprintf(`{"error":"Request body too large","details":"Request bodies on this route are limited to %d bytes"}`, maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+				return
+			case isUploadError(err):
+				http.Error(w, fmt.Sprintf(`{"error":"Invalid request body","details":%q}`, err.Error()), http.StatusBadRequest)
+				return
+			case err != nil:
+				m.scans.Inc(route.Name, "error")
+				if !route.FailOpen {
+					log.Printf("Upload to %s rejected: scanning failed: %v", r.URL.Path, err)
+					http.Error(w, `{"error":"Upload scan unavailable","details":"The upload could not be scanned for malware"}`, http.StatusServiceUnavailable)
+					return
+				}
+				log.Printf("Upload to %s passed unscanned: scanning failed: %v", r.URL.Path, err)
+			case threat != "":
+				m.scans.Inc(route.Name, "infected")
+				log.Printf("Upload to %s rejected: file %q contains %s", r.URL.Path, file, threat)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprintf(w, `{"error":"Upload rejected","details":%q}`, fmt.Sprintf("File %q contains %s", file, threat))
+				return
+			default:
+				m.scans.Inc(route.Name, "clean")
+			}
+
+			size, err := spool.Seek(0, io.SeekCurrent)
+			if err == nil {
+				_, err = spool.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				log.Printf("Failed to replay spooled upload: %v", err)
+				http.Error(w, `{"error":"Upload scan unavailable","details":"The upload could not be replayed"}`, http.StatusServiceUnavailable)
+				return
+			}
+
+			// The whole body is known now, so it is sent with its length
+			r.Body = body
+			r.ContentLength = size
+			r.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+			r.TransferEncoding = nil
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errInvalidUpload marks multipart bodies that cannot be parsed
+var errInvalidUpload = errors.New("invalid multipart body")
+
+// uploadError wraps a failure to read the upload itself, as opposed to a
+// failure of the scanner
+type uploadError struct {
+	err error
+}
+
+func (e *uploadError) Error() string { return e.err.Error() }
+func (e *uploadError) Unwrap() error { return e.err }
+
+// isUploadError reports whether err stems from the upload rather than the scanner
+func isUploadError(err error) bool {
+	var uploadErr *uploadError
+	return errors.As(err, &uploadErr) || errors.Is(err, errInvalidUpload)
+}
+
+// scan reads the body from tee while streaming its files to the route's
+// scanner. It returns the name of the first infected file and its threat; a
+// body that is not multipart/form-data is scanned as one file.
+func (m *Middleware) scan(r *http.Request, route *Route, tee io.Reader) (string, string, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		threat, err := m.scanFile(r.Context(), route, tee)
+		return "request body", threat, err
+	}
+	if params["boundary"] == "" {
+		return "", "", fmt.Errorf("%w: no boundary", errInvalidUpload)
+	}
+
+	reader := multipart.NewReader(tee, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", "", nil
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return "", "", &uploadError{err: err}
+			}
+			return "", "", fmt.Errorf("%w: %v", errInvalidUpload, err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		threat, err := m.scanFile(r.Context(), route, part)
+		if err != nil || threat != "" {
+			return part.FileName(), threat, err
+		}
+	}
+}
+
+// scanFile scans one file within the route's timeout
+func (m *Middleware) scanFile(ctx context.Context, route *Route, content io.Reader) (string, error) {
+	if route.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+		defer cancel()
+	}
+	reader := &trackingReader{Reader: content}
+	threat, err := route.Scanner.Scan(ctx, reader)
+	if reader.err != nil {
+		// Errors reading the upload itself take precedence over the
+		// scanner's reaction to them
+		return "", &uploadError{err: reader.err}
+	}
+	return threat, err
+}
+
+// match returns the route with the longest path prefix matching path, or nil
+func (m *Middleware) match(path string) *Route {
+	var matched *Route
+	longest := -1
+	for _, route := range m.config.Routes {
+		for _, prefix := range route.Paths {
+			if proxy.HasPathPrefix(path, prefix) && len(prefix) > longest {
+				matched, longest = route, len(prefix)
+			}
+		}
+	}
+	return matched
+}
+
+// trackingReader remembers the first error other than io.EOF its reader returns
+type trackingReader struct {
+	io.Reader
+	err error
+}
+
+// Read implements io.Reader
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	if err != nil && err != io.EOF && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// spooledBody holds an upload while it is scanned, replays it and removes
+// it once closed
+type spooledBody struct {
+	*os.File
+	err    error // First error writing the spool
+	closed bool
+}
+
+// Write spools upload data, remembering the first failure
+func (b *spooledBody) Write(p []byte) (int, error) {
+	n, err := b.File.Write(p)
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close closes and removes the spool file; it is safe to call twice
+func (b *spooledBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.File.Close()
+	return os.Remove(b.File.Name())
+}
@@ -0,0 +1,24 @@
+package scan
+
+import (
+	"testing"
+
+	"api-gateway/metrics"
+)
+
+func TestMatchOnSegmentBoundaries(t *testing.T) {
+	uploads := &Route{Name: "uploads", Paths: []string{"/api/uploads"}}
+	avatars := &Route{Name: "avatars", Paths: []string{"/api/uploads/avatars"}}
+	m := NewMiddleware(&Config{Routes: []*Route{uploads, avatars}}, metrics.NewRegistry())
+	for path, want := range map[string]*Route{
+		"/api/uploads":            uploads,
+		"/api/uploads/1":          uploads,
+		"/api/uploads/avatars/me": avatars,
+		"/api/uploads/avatarsx":   uploads,
+		"/api/uploadsx":           nil,
+	} {
+		if got := m.match(path); got != want {
+			t.Errorf("match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
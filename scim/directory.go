@@ -0,0 +1,285 @@
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/auth"
+)
+
+// User is a user provisioned by an identity provider
+type User struct {
+	ID          string    `json:"id"`
+	ExternalID  string    `json:"external_id,omitempty"` // The identity provider's ID for the user
+	UserName    string    `json:"user_name"`
+	DisplayName string    `json:"display_name,omitempty"`
+	GivenName   string    `json:"given_name,omitempty"`
+	FamilyName  string    `json:"family_name,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+var (
+	// ErrNotFound is returned for operations on a user that does not exist
+	ErrNotFound = errors.New("user not found")
+	// ErrUserNameTaken is returned when another user has the same userName
+	ErrUserNameTaken = errors.New("userName is already in use")
+	// ErrUserNameRequired is returned for users without a userName
+	ErrUserNameRequired = errors.New("userName is required")
+	// ErrDeactivated is returned when authenticating a deactivated user
+	ErrDeactivated = errors.New("user is deactivated")
+	// ErrNotProvisioned is returned when an issuer's users must be provisioned
+	// and the user is not
+	ErrNotProvisioned = errors.New("user is not provisioned")
+)
+
+// Directory holds the provisioned users and checks authenticated users
+// against them. Users are cached in memory and reloaded periodically, so
+// changes made by other replicas sharing the store are picked up.
+type Directory struct {
+	store    Store
+	required map[string]bool // "iss" values whose users must be provisioned
+
+	mu           sync.RWMutex // Guards the cache
+	writeMu      sync.Mutex   // Serializes read-modify-write changes
+	users        map[string]*User
+	byUserName   map[string]*User // Lowercased, as userName is case-insensitive
+	byExternalID map[string]*User
+}
+
+// NewDirectory loads the users from the store and reloads them at the given
+// interval; a zero interval disables reloading. Tokens whose "iss" claim is
+// one of requiredIssuers are accepted only for active provisioned users.
+func NewDirectory(store Store, refresh time.Duration, requiredIssuers []string) (*Directory, error) {
+	d := &Directory{
+		store:    store,
+		required: make(map[string]bool, len(requiredIssuers)),
+	}
+	for _, issuer := range requiredIssuers {
+		d.required[issuer] = true
+	}
+	if err := d.reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go d.refreshRoutine(refresh)
+	}
+
+	return d, nil
+}
+
+// Resolve implements auth.Directory. Provisioned users are identified by the
+// token subject (their ID or external ID) or, for tokens, their userName; the
+// user context is then keyed by the provisioned ID so group memberships apply.
+func (d *Directory) Resolve(userCtx *auth.UserContext) error {
+	user := d.lookup(userCtx)
+	if user == nil {
+		if userCtx.Issuer != "" && d.required[userCtx.Issuer] {
+			return ErrNotProvisioned
+		}
+		return nil
+	}
+	if !user.Active {
+		return ErrDeactivated
+	}
+
+	userCtx.UserID = user.ID
+	if userCtx.Username == "" {
+		userCtx.Username = user.UserName
+	}
+	if userCtx.Email == "" {
+		userCtx.Email = user.Email
+	}
+	return nil
+}
+
+// lookup finds the provisioned account of an authenticated user
+func (d *Directory) lookup(userCtx *auth.UserContext) *User {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if user, ok := d.users[userCtx.UserID]; ok {
+		return user
+	}
+	if user, ok := d.byExternalID[userCtx.UserID]; ok {
+		return user
+	}
+	// API keys carry the key name rather than a username
+	if userCtx.APIKey == nil && userCtx.Username != "" {
+		return d.byUserName[strings.ToLower(userCtx.Username)]
+	}
+	return nil
+}
+
+// List returns every user sorted by userName
+func (d *Directory) List() []*User {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	list := make([]*User, 0, len(d.users))
+	for _, user := range d.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].UserName) < strings.ToLower(list[j].UserName)
+	})
+	return list
+}
+
+// Get returns a user
+func (d *Directory) Get(id string) (*User, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	user, ok := d.users[id]
+	return user, ok
+}
+
+// Create provisions a user, assigning its ID
+func (d *Directory) Create(ctx context.Context, user *User) (*User, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	created := *user
+	created.ID = id
+	created.CreatedAt = now
+	created.UpdatedAt = now
+	if err := d.modify(ctx, id, func(existing *User) (*User, error) {
+		return &created, nil
+	}); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Replace replaces every attribute of a user except its ID and creation time
+func (d *Directory) Replace(ctx context.Context, user *User) (*User, error) {
+	var replaced User
+	err := d.modify(ctx, user.ID, func(existing *User) (*User, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		replaced = *user
+		replaced.CreatedAt = existing.CreatedAt
+		replaced.UpdatedAt = time.Now()
+		return &replaced, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &replaced, nil
+}
+
+// Delete removes a user
+func (d *Directory) Delete(ctx context.Context, id string) error {
+	return d.modify(ctx, id, func(existing *User) (*User, error) {
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		return nil, nil
+	})
+}
+
+// modify applies a change to the stored user, deleting it when change returns
+// nil, and refreshes the cache
+func (d *Directory) modify(ctx context.Context, id string, change func(existing *User) (*User, error)) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	stored, err := d.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	updated, err := change(stored[id])
+	if err != nil {
+		return err
+	}
+
+	if updated == nil {
+		err = d.store.Delete(ctx, id)
+		delete(stored, id)
+	} else {
+		if updated.UserName == "" {
+			return ErrUserNameRequired
+		}
+		for _, other := range stored {
+			if other.ID != id && strings.EqualFold(other.UserName, updated.UserName) {
+				return ErrUserNameTaken
+			}
+		}
+		err = d.store.Save(ctx, updated)
+		stored[id] = updated
+	}
+	if err != nil {
+		return err
+	}
+
+	d.index(stored)
+	return nil
+}
+
+// reload replaces the cache with the stored users
+func (d *Directory) reload(ctx context.Context) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	stored, err := d.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	d.index(stored)
+	return nil
+}
+
+// index replaces the cache
+func (d *Directory) index(users map[string]*User) {
+	byUserName := make(map[string]*User, len(users))
+	byExternalID := make(map[string]*User, len(users))
+	for _, user := range users {
+		byUserName[strings.ToLower(user.UserName)] = user
+		if user.ExternalID != "" {
+			byExternalID[user.ExternalID] = user
+		}
+	}
+
+	d.mu.Lock()
+	d.users = users
+	d.byUserName = byUserName
+	d.byExternalID = byExternalID
+	d.mu.Unlock()
+}
+
+// refreshRoutine periodically reloads the users
+func (d *Directory) refreshRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := d.reload(ctx); err != nil {
+			log.Printf("Failed to reload SCIM users: %v", err)
+		}
+		cancel()
+	}
+}
+
+// newID generates a random user ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
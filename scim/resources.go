@@ -0,0 +1,142 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Schema URNs of the SCIM 2.0 resources and messages
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// Meta describes a resource
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// Name is the components of a user's name
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is an entry of a multi-valued attribute such as emails or members
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// UserResource is the SCIM representation of a user
+type UserResource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *Name        `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"` // Defaults to true when provisioning
+	Groups      []MultiValue `json:"groups,omitempty"` // Read-only; managed through groups
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// GroupResource is the SCIM representation of a group
+type GroupResource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of query results
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// PatchRequest is a list of changes to apply to a resource
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single change. Op is add, remove or replace in any case.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Error is a SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// ErrInvalidFilter is returned for filters other than `attribute eq "value"`
+var ErrInvalidFilter = errors.New(`only filters of the form attribute eq "value" are supported`)
+
+var filterPattern = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// ParseFilter parses an equality filter such as `userName eq "alice"`,
+// the only form identity providers need to look up existing resources
+func ParseFilter(filter string) (attribute, value string, err error) {
+	match := filterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", ErrInvalidFilter
+	}
+	if err := json.Unmarshal([]byte(`"`+match[2]+`"`), &value); err != nil {
+		return "", "", ErrInvalidFilter
+	}
+	return match[1], value, nil
+}
+
+// memberPathPattern matches paths selecting one member, like members[value eq "id"]
+var memberPathPattern = regexp.MustCompile(`^members\[\s*value\s+(?i:eq)\s+"([^"]*)"\s*\]$`)
+
+// MemberPath returns the user ID selected by a members[value eq "id"] path
+func MemberPath(path string) (string, bool) {
+	match := memberPathPattern.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// GroupName derives a gateway group name from a display name, such as
+// "platform-team" from "Platform Team"
+func GroupName(displayName string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(displayName), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 64 {
+		name = strings.TrimRight(name[:64], "-")
+	}
+	return name
+}
@@ -0,0 +1,158 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists provisioned users
+type Store interface {
+	Load(ctx context.Context) (map[string]*User, error)
+	Save(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id string) error
+}
+
+// FileStore keeps users in a JSON file on local disk
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a store backed by the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Load reads every user. A missing file holds no users.
+func (s *FileStore) Load(ctx context.Context) (map[string]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Save creates or replaces a user
+func (s *FileStore) Save(ctx context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.read()
+	if err != nil {
+		return err
+	}
+	users[user.ID] = user
+	return s.write(users)
+}
+
+// Delete removes a user
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(users, id)
+	return s.write(users)
+}
+
+func (s *FileStore) read() (map[string]*User, error) {
+	users := make(map[string]*User)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return users, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", s.path, err)
+	}
+	return users, nil
+}
+
+// write replaces the file atomically so a crash never leaves it truncated
+func (s *FileStore) write(users map[string]*User) error {
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".scim-users-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	return nil
+}
+
+// redisUsersKey is the hash holding every user, keyed by ID
+const redisUsersKey = "scim:users"
+
+// RedisStore keeps users in Redis so every replica shares them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed user store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Load reads every user
+func (s *RedisStore) Load(ctx context.Context) (map[string]*User, error) {
+	values, err := s.client.HGetAll(ctx, redisUsersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	users := make(map[string]*User, len(values))
+	for id, value := range values {
+		var user User
+		if err := json.Unmarshal([]byte(value), &user); err != nil {
+			return nil, fmt.Errorf("failed to decode user %s: %w", id, err)
+		}
+		users[id] = &user
+	}
+	return users, nil
+}
+
+// Save creates or replaces a user
+func (s *RedisStore) Save(ctx context.Context, user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, redisUsersKey, user.ID, data).Err(); err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a user
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	if err := s.client.HDel(ctx, redisUsersKey, id).Err(); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package shedding
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gwmetrics "api-gateway/metrics"
+)
+
+// Priority orders traffic for shedding; lower priorities are rejected first
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical // Never shed
+)
+
+// ParsePriority converts a priority name to a Priority
+func ParsePriority(name string) (Priority, error) {
+	switch strings.ToLower(name) {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	case "critical":
+		return PriorityCritical, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q", name)
+}
+
+// String returns the priority name
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return "normal"
+}
+
+// Config represents load shedding configuration
+type Config struct {
+	MaxCPU          float64             // CPU utilization (0-1) considered full load
+	MaxGoroutines   int                 // Goroutine count considered full load; 0 disables the signal
+	MaxInFlight     int                 // Concurrent requests considered full load; 0 disables the signal
+	RoutePriorities map[string]Priority // Path prefix -> priority
+	RolePriorities  map[string]Priority // Consumer role -> priority
+	DefaultPriority Priority
+	SampleInterval  time.Duration
+	// RolesOf resolves the consumer roles of a request before authentication
+	// middleware runs; nil disables role-based priorities
+	RolesOf func(r *http.Request) []string
+}
+
+// thresholds is the pressure at which each priority starts being shed
+var thresholds = map[Priority]float64{
+	PriorityLow:    0.8,
+	PriorityNormal: 0.9,
+	PriorityHigh:   1.0,
+}
+
+// Status reports the current load signals
+type Status struct {
+	Pressure   float64          `json:"pressure"` // Highest signal relative to its limit
+	CPU        float64          `json:"cpu"`
+	Goroutines int              `json:"goroutines"`
+	InFlight   int64            `json:"in_flight"`
+	Shedding   []string         `json:"shedding"` // Priorities currently rejected
+	Shed       map[string]int64 `json:"shed"`     // Rejected requests per priority
+}
+
+// Shedder rejects low-priority requests while the gateway is overloaded
+type Shedder struct {
+	config   *Config
+	inFlight atomic.Int64
+	pressure atomic.Uint64 // math.Float64bits of the current pressure
+
+	mu         sync.Mutex
+	cpu        float64
+	goroutines int
+	shed       map[Priority]int64
+
+	shedTotal     *gwmetrics.CounterVec
+	pressureGauge *gwmetrics.GaugeVec
+}
+
+// NewShedder creates a load shedder and starts sampling load signals
+func NewShedder(config *Config, reg *gwmetrics.Registry) *Shedder {
+	s := &Shedder{
+		config: config,
+		shed:   make(map[Priority]int64),
+		shedTotal: reg.NewCounterVec("gateway_shed_requests_total",
+			"Requests rejected by load shedding, by priority.", "priority"),
+		pressureGauge: reg.NewGaugeVec("gateway_load_pressure",
+			"Current load relative to configured limits, by signal.", "signal"),
+	}
+
+	go s.sampleRoutine()
+
+	return s
+}
+
+// Middleware returns the HTTP middleware function
+func (s *Shedder) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inFlight := s.inFlight.Add(1)
+			defer s.inFlight.Add(-1)
+
+			pressure := math.Float64frombits(s.pressure.Load())
+			if s.config.MaxInFlight > 0 {
+				pressure = math.Max(pressure, float64(inFlight)/float64(s.config.MaxInFlight))
+			}
+
+			if priority := s.priority(r); shouldShed(priority, pressure) {
+				s.mu.Lock()
+				s.shed[priority]++
+				s.mu.Unlock()
+				s.shedTotal.Inc(priority.String())
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":"Service overloaded","details":"The gateway is shedding load; retry later"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Status returns the current load signals
+func (s *Shedder) Status() Status {
+	pressure := math.Float64frombits(s.pressure.Load())
+	inFlight := s.inFlight.Load()
+	if s.config.MaxInFlight > 0 {
+		pressure = math.Max(pressure, float64(inFlight)/float64(s.config.MaxInFlight))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Pressure:   pressure,
+		CPU:        s.cpu,
+		Goroutines: s.goroutines,
+		InFlight:   inFlight,
+		Shedding:   []string{},
+		Shed:       make(map[string]int64),
+	}
+	for _, priority := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		if shouldShed(priority, pressure) {
+			status.Shedding = append(status.Shedding, priority.String())
+		}
+	}
+	for priority, count := range s.shed {
+		status.Shed[priority.String()] = count
+	}
+	return status
+}
+
+// priority classifies a request by route and consumer, taking the higher of both
+func (s *Shedder) priority(r *http.Request) Priority {
+	priority := s.config.DefaultPriority
+
+	longest := -1
+	for prefix, routePriority := range s.config.RoutePriorities {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+			priority = routePriority
+		}
+	}
+
+	if s.config.RolesOf != nil && len(s.config.RolePriorities) > 0 {
+		for _, role := range s.config.RolesOf(r) {
+			if rolePriority, ok := s.config.RolePriorities[role]; ok && rolePriority > priority {
+				priority = rolePriority
+			}
+		}
+	}
+	return priority
+}
+
+// shouldShed reports whether a priority is rejected at the given pressure
+func shouldShed(priority Priority, pressure float64) bool {
+	threshold, ok := thresholds[priority]
+	return ok && pressure >= threshold
+}
+
+// sampleRoutine periodically samples CPU and goroutine load
+func (s *Shedder) sampleRoutine() {
+	ticker := time.NewTicker(s.config.SampleInterval)
+	defer ticker.Stop()
+
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	lastTotal, lastIdle := samples[0].Value.Float64(), samples[1].Value.Float64()
+
+	for range ticker.C {
+		metrics.Read(samples)
+		total, idle := samples[0].Value.Float64(), samples[1].Value.Float64()
+
+		cpu := 0.0
+		if elapsed := total - lastTotal; elapsed > 0 {
+			cpu = math.Max(0, 1-(idle-lastIdle)/elapsed)
+		}
+		lastTotal, lastIdle = total, idle
+
+		goroutines := runtime.NumGoroutine()
+
+		pressure := 0.0
+		if s.config.MaxCPU > 0 {
+			pressure = cpu / s.config.MaxCPU
+			s.pressureGauge.Set(pressure, "cpu")
+		}
+		if s.config.MaxGoroutines > 0 {
+			goroutinePressure := float64(goroutines) / float64(s.config.MaxGoroutines)
+			s.pressureGauge.Set(goroutinePressure, "goroutines")
+			pressure = math.Max(pressure, goroutinePressure)
+		}
+		if s.config.MaxInFlight > 0 {
+			s.pressureGauge.Set(float64(s.inFlight.Load())/float64(s.config.MaxInFlight), "in_flight")
+		}
+		s.pressure.Store(math.Float64bits(pressure))
+
+		s.mu.Lock()
+		s.cpu = cpu
+		s.goroutines = goroutines
+		s.mu.Unlock()
+	}
+}
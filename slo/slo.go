@@ -0,0 +1,525 @@
+package slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+)
+
+// Indicators measured for each objective
+const (
+	IndicatorLatency = "latency" // Share of requests faster than the latency threshold
+	IndicatorErrors  = "errors"  // Share of requests without a 5xx response
+)
+
+// Alert severities, following multiwindow burn rate alerting: an alert fires
+// when both its long and short window burn the error budget faster than the
+// configured rate, and resolves when either stops
+const (
+	SeverityFast = "fast" // 1h and 5m windows; the budget would last about two days
+	SeveritySlow = "slow" // 6h and 30m windows; the budget would last about five days
+)
+
+// bucketWidth is the resolution of the rolling counts
+const bucketWidth = time.Minute
+
+// maxAlerts is the number of recent alerts kept for the admin API
+const maxAlerts = 100
+
+// burnWindows are the windows burn rates are reported for, shortest first
+var burnWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+// Objective declares the objectives of a group of routes
+type Objective struct {
+	Name          string
+	Paths         []string      // Route prefixes covered
+	Methods       []string      // Empty covers every method
+	Latency       time.Duration // Requests slower than this miss the objective; 0 disables it
+	LatencyTarget float64       // Share of requests that must be faster
+	ErrorRate     float64       // Highest share of 5xx responses; 0 disables it
+}
+
+// Config represents SLO tracking configuration
+type Config struct {
+	Objectives     []*Objective
+	Window         time.Duration // Compliance period
+	FastBurnRate   float64
+	SlowBurnRate   float64
+	Interval       time.Duration // How often alerts are evaluated
+	WebhookURL     string        // Receives alerts as JSON; empty disables
+	WebhookTimeout time.Duration
+}
+
+// Indicator reports how well an objective is met
+type Indicator struct {
+	Objective            float64            `json:"objective"`              // Share of requests that must be good
+	Compliance           float64            `json:"compliance"`             // Share of good requests over the window
+	ErrorBudgetRemaining float64            `json:"error_budget_remaining"` // 1 is untouched, 0 or less is exhausted
+	BurnRates            map[string]float64 `json:"burn_rates"`             // Window -> budget consumption relative to a steady pace
+	Alerts               []string           `json:"alerts"`                 // Severities firing
+}
+
+// Status reports an objective over the compliance window
+type Status struct {
+	Name             string     `json:"name"`
+	Paths            []string   `json:"paths"`
+	Methods          []string   `json:"methods,omitempty"`
+	Window           string     `json:"window"`
+	Requests         int64      `json:"requests"`
+	LatencyThreshold string     `json:"latency_threshold,omitempty"`
+	Latency          *Indicator `json:"latency,omitempty"`
+	Errors           *Indicator `json:"errors,omitempty"`
+}
+
+// Alert describes an objective starting or stopping to burn its error
+// budget too fast
+type Alert struct {
+	Time                 time.Time `json:"time"`
+	Objective            string    `json:"objective"`
+	Indicator            string    `json:"indicator"`
+	Severity             string    `json:"severity"`
+	State                string    `json:"state"`     // "firing" or "resolved"
+	BurnRate             float64   `json:"burn_rate"` // Over the long window
+	Threshold            float64   `json:"threshold"`
+	ErrorBudgetRemaining float64   `json:"error_budget_remaining"`
+}
+
+// bucket counts the requests of one minute
+type bucket struct {
+	Minute   int64 `json:"minute"` // Unix minute the counts belong to
+	Requests int64 `json:"requests"`
+	Slow     int64 `json:"slow"`
+	Errors   int64 `json:"errors"`
+}
+
+// counts sums buckets
+type counts struct {
+	requests, slow, errors int64
+}
+
+// tracker holds the rolling counts of an objective
+type tracker struct {
+	objective *Objective
+	buckets   []bucket // Ring indexed by minute
+	firing    map[string]bool
+}
+
+// Tracker tracks per-route SLO compliance and alerts on fast budget burn
+type Tracker struct {
+	config *Config
+	client *http.Client
+
+	mu       sync.Mutex
+	trackers []*tracker
+	alerts   []*Alert
+
+	compliance *metrics.GaugeVec
+	budget     *metrics.GaugeVec
+	burnRate   *metrics.GaugeVec
+	firing     *metrics.GaugeVec
+	alertCount *metrics.CounterVec
+}
+
+// NewTracker creates an SLO tracker and starts evaluating alerts
+func NewTracker(config *Config, reg *metrics.Registry) *Tracker {
+	t := &Tracker{
+		config: config,
+		client: &http.Client{Timeout: config.WebhookTimeout},
+		compliance: reg.NewGaugeVec("gateway_slo_compliance",
+			"Share of good requests over the SLO window, by objective and indicator.", "slo", "indicator"),
+		budget: reg.NewGaugeVec("gateway_slo_error_budget_remaining",
+			"Share of the error budget left over the SLO window, by objective and indicator.", "slo", "indicator"),
+		burnRate: reg.NewGaugeVec("gateway_slo_burn_rate",
+			"Error budget consumption relative to a steady pace, by objective, indicator and window.", "slo", "indicator", "window"),
+		firing: reg.NewGaugeVec("gateway_slo_alert_firing",
+			"Whether a burn rate alert is firing, by objective, indicator and severity.", "slo", "indicator", "severity"),
+		alertCount: reg.NewCounterVec("gateway_slo_alerts_total",
+			"Burn rate alerts fired, by objective, indicator and severity.", "slo", "indicator", "severity"),
+	}
+	size := int(config.Window / bucketWidth)
+	for _, objective := range config.Objectives {
+		t.trackers = append(t.trackers, &tracker{
+			objective: objective,
+			buckets:   make([]bucket, size),
+			firing:    make(map[string]bool),
+		})
+	}
+
+	go t.evaluateRoutine()
+
+	return t
+}
+
+// Middleware returns the HTTP middleware function
+func (t *Tracker) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var matched []*tracker
+			for _, tr := range t.trackers {
+				if tr.matches(r) {
+					matched = append(matched, tr)
+				}
+			}
+			if len(matched) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			sw := httputil.NewStatusWriter(w)
+
+			next.ServeHTTP(sw, r)
+
+			latency := time.Since(start)
+			t.mu.Lock()
+			for _, tr := range matched {
+				tr.observe(start, latency, sw.StatusCode())
+			}
+			t.mu.Unlock()
+		})
+	}
+}
+
+// Status returns the current status of every objective
+func (t *Tracker) Status() []*Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]*Status, 0, len(t.trackers))
+	for _, tr := range t.trackers {
+		statuses = append(statuses, t.status(tr, now))
+	}
+	return statuses
+}
+
+// Alerts returns the most recent alerts, newest first
+func (t *Tracker) Alerts() []*Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	alerts := make([]*Alert, len(t.alerts))
+	for i, alert := range t.alerts {
+		alerts[len(t.alerts)-1-i] = alert
+	}
+	return alerts
+}
+
+// matches reports whether a request is covered by the objective
+func (tr *tracker) matches(r *http.Request) bool {
+	if len(tr.objective.Methods) > 0 {
+		covered := false
+		for _, method := range tr.objective.Methods {
+			if strings.EqualFold(method, r.Method) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	for _, path := range tr.objective.Paths {
+		if strings.HasPrefix(r.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe counts a request in its minute
+func (tr *tracker) observe(at time.Time, latency time.Duration, status int) {
+	minute := at.Unix() / int64(bucketWidth/time.Second)
+	b := &tr.buckets[minute%int64(len(tr.buckets))]
+	if b.Minute != minute {
+		*b = bucket{Minute: minute}
+	}
+	b.Requests++
+	if tr.objective.Latency > 0 && latency > tr.objective.Latency {
+		b.Slow++
+	}
+	if status >= 500 {
+		b.Errors++
+	}
+}
+
+// sum adds up the counts of the last window ending at now
+func (tr *tracker) sum(now time.Time, window time.Duration) counts {
+	var c counts
+	current := now.Unix() / int64(bucketWidth/time.Second)
+	minutes := int64(window / bucketWidth)
+	if minutes > int64(len(tr.buckets)) {
+		minutes = int64(len(tr.buckets))
+	}
+	for minute := current - minutes + 1; minute <= current; minute++ {
+		b := tr.buckets[minute%int64(len(tr.buckets))]
+		if b.Minute == minute {
+			c.requests += b.Requests
+			c.slow += b.Slow
+			c.errors += b.Errors
+		}
+	}
+	return c
+}
+
+// bad returns the requests that missed the indicator's objective
+func (c counts) bad(indicator string) int64 {
+	if indicator == IndicatorLatency {
+		return c.slow
+	}
+	return c.errors
+}
+
+// target returns the share of requests that must be good for an indicator,
+// or 0 when the indicator is disabled
+func (tr *tracker) target(indicator string) float64 {
+	if indicator == IndicatorLatency {
+		if tr.objective.Latency <= 0 {
+			return 0
+		}
+		return tr.objective.LatencyTarget
+	}
+	if tr.objective.ErrorRate <= 0 {
+		return 0
+	}
+	return 1 - tr.objective.ErrorRate
+}
+
+// burnRate returns how fast a window consumed the error budget, where 1
+// would exhaust it exactly at the end of the compliance window
+func burnRate(c counts, indicator string, target float64) float64 {
+	if c.requests == 0 {
+		return 0
+	}
+	return float64(c.bad(indicator)) / float64(c.requests) / (1 - target)
+}
+
+// status computes an objective's status
+func (t *Tracker) status(tr *tracker, now time.Time) *Status {
+	total := tr.sum(now, t.config.Window)
+	status := &Status{
+		Name:     tr.objective.Name,
+		Paths:    tr.objective.Paths,
+		Methods:  tr.objective.Methods,
+		Window:   t.config.Window.String(),
+		Requests: total.requests,
+	}
+
+	for _, indicator := range []string{IndicatorLatency, IndicatorErrors} {
+		target := tr.target(indicator)
+		if target == 0 {
+			continue
+		}
+		result := &Indicator{
+			Objective:            target,
+			Compliance:           1,
+			ErrorBudgetRemaining: 1,
+			BurnRates:            make(map[string]float64, len(burnWindows)),
+			Alerts:               []string{},
+		}
+		if total.requests > 0 {
+			bad := float64(total.bad(indicator))
+			result.Compliance = 1 - bad/float64(total.requests)
+			result.ErrorBudgetRemaining = 1 - bad/((1-target)*float64(total.requests))
+		}
+		for _, window := range burnWindows {
+			result.BurnRates[windowLabel(window)] = burnRate(tr.sum(now, window), indicator, target)
+		}
+		for _, severity := range []string{SeverityFast, SeveritySlow} {
+			if tr.firing[indicator+"\x00"+severity] {
+				result.Alerts = append(result.Alerts, severity)
+			}
+		}
+
+		if indicator == IndicatorLatency {
+			status.Latency = result
+			status.LatencyThreshold = tr.objective.Latency.String()
+		} else {
+			status.Errors = result
+		}
+	}
+	return status
+}
+
+// evaluateRoutine periodically publishes metrics and evaluates alerts
+func (t *Tracker) evaluateRoutine() {
+	ticker := time.NewTicker(t.config.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, alert := range t.evaluate(now) {
+			t.emit(alert)
+		}
+	}
+}
+
+// evaluate publishes metrics and returns alerts that started or stopped firing
+func (t *Tracker) evaluate(now time.Time) []*Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	severities := map[string]struct {
+		long, short time.Duration
+		rate        float64
+	}{
+		SeverityFast: {time.Hour, 5 * time.Minute, t.config.FastBurnRate},
+		SeveritySlow: {6 * time.Hour, 30 * time.Minute, t.config.SlowBurnRate},
+	}
+
+	var changed []*Alert
+	for _, tr := range t.trackers {
+		status := t.status(tr, now)
+		name := tr.objective.Name
+		for indicator, result := range map[string]*Indicator{IndicatorLatency: status.Latency, IndicatorErrors: status.Errors} {
+			if result == nil {
+				continue
+			}
+			t.compliance.Set(result.Compliance, name, indicator)
+			t.budget.Set(result.ErrorBudgetRemaining, name, indicator)
+			for window, rate := range result.BurnRates {
+				t.burnRate.Set(rate, name, indicator, window)
+			}
+
+			target := tr.target(indicator)
+			for _, severity := range []string{SeverityFast, SeveritySlow} {
+				windows := severities[severity]
+				long := burnRate(tr.sum(now, windows.long), indicator, target)
+				short := burnRate(tr.sum(now, windows.short), indicator, target)
+				firing := long >= windows.rate && short >= windows.rate
+
+				key := indicator + "\x00" + severity
+				if firing == tr.firing[key] {
+					continue
+				}
+				tr.firing[key] = firing
+
+				state := "resolved"
+				value := 0.0
+				if firing {
+					state, value = "firing", 1
+					t.alertCount.Inc(name, indicator, severity)
+				}
+				t.firing.Set(value, name, indicator, severity)
+
+				alert := &Alert{
+					Time:                 now,
+					Objective:            name,
+					Indicator:            indicator,
+					Severity:             severity,
+					State:                state,
+					BurnRate:             long,
+					Threshold:            windows.rate,
+					ErrorBudgetRemaining: result.ErrorBudgetRemaining,
+				}
+				t.alerts = append(t.alerts, alert)
+				if len(t.alerts) > maxAlerts {
+					t.alerts = t.alerts[len(t.alerts)-maxAlerts:]
+				}
+				changed = append(changed, alert)
+			}
+		}
+	}
+	return changed
+}
+
+// emit logs and delivers an alert
+func (t *Tracker) emit(alert *Alert) {
+	log.Printf("SLO: %s %s %s burn alert %s (burn rate %.2f, threshold %.2f, %.1f%% of error budget left)",
+		alert.Objective, alert.Indicator, alert.Severity, alert.State, alert.BurnRate, alert.Threshold, alert.ErrorBudgetRemaining*100)
+
+	if t.config.WebhookURL == "" {
+		return
+	}
+	if err := t.deliver(alert); err != nil {
+		log.Printf("Failed to deliver SLO alert: %v", err)
+	}
+}
+
+// deliver posts an alert to the webhook
+func (t *Tracker) deliver(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.config.WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// windowLabel formats a burn rate window, e.g. "5m" or "6h"
+func windowLabel(window time.Duration) string {
+	if window%time.Hour == 0 {
+		return fmt.Sprintf("%dh", window/time.Hour)
+	}
+	return fmt.Sprintf("%dm", window/time.Minute)
+}
+
+// Snapshot returns the rolling counts of every objective
+func (t *Tracker) Snapshot() (any, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := make(map[string][]bucket, len(t.trackers))
+	for _, tr := range t.trackers {
+		var buckets []bucket
+		for _, b := range tr.buckets {
+			if b.Requests > 0 {
+				buckets = append(buckets, b)
+			}
+		}
+		snapshot[tr.objective.Name] = buckets
+	}
+	return snapshot, nil
+}
+
+// Restore loads saved counts for objectives that are still configured
+func (t *Tracker) Restore(data json.RawMessage) error {
+	var snapshot map[string][]bucket
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldest := time.Now().Add(-t.config.Window).Unix() / int64(bucketWidth/time.Second)
+	for _, tr := range t.trackers {
+		for _, b := range snapshot[tr.objective.Name] {
+			if b.Minute <= oldest {
+				continue
+			}
+			slot := &tr.buckets[b.Minute%int64(len(tr.buckets))]
+			if slot.Minute == b.Minute {
+				slot.Requests += b.Requests
+				slot.Slow += b.Slow
+				slot.Errors += b.Errors
+			} else if slot.Minute < b.Minute {
+				*slot = b
+			}
+		}
+	}
+	return nil
+}
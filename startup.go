@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"api-gateway/config"
+)
+
+// version is the gateway build version, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// logStartup logs a structured readiness record with the resolved
+// configuration summary (secrets redacted), the listener address, enabled
+// subsystems and warnings for insecure settings
+func logStartup(cfg *config.Config, addr string) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	rateLimit := cfg.RateLimit
+	policy := cfg.Policy
+	metrics := cfg.Metrics
+	docs := cfg.Docs
+
+	subsystems := map[string]bool{
+		"rate_limit":      rateLimit.Enabled,
+		"anonymous":       cfg.Anonymous.Enabled,
+		"policy":          policy.Enabled,
+		"waf":             cfg.WAF.Enabled,
+		"compression":     cfg.Compression.Enabled,
+		"headers":         cfg.Headers.Enabled,
+		"error_pages":     cfg.ErrorPages.Enabled,
+		"masking":         cfg.Masking.Enabled,
+		"metrics":         metrics.Enabled,
+		"statsd":          cfg.StatsD.Enabled,
+		"access_log":      cfg.AccessLog.Enabled,
+		"idempotency":     cfg.Idempotency.Enabled,
+		"replay":          cfg.Replay.Enabled,
+		"csrf":            cfg.CSRF.Enabled,
+		"impersonation":   cfg.Impersonation.Enabled,
+		"groups":          cfg.Groups.Enabled,
+		"scim":            cfg.SCIM.Enabled,
+		"ldap":            cfg.LDAP.Enabled,
+		"saml":            cfg.SAML.Enabled,
+		"device_flow":     cfg.DeviceFlow.Enabled,
+		"personal_tokens": cfg.PersonalTokens.Enabled,
+		"coalesce":        cfg.Coalesce.Enabled,
+		"cache":           cfg.Cache.Enabled,
+		"conditional":     cfg.Conditional.Enabled,
+		"async":           cfg.Async.Enabled,
+		"mqtt":            cfg.MQTT.Enabled,
+		"devices":         cfg.Devices.Enabled,
+		"capture":         cfg.Capture.Enabled,
+		"debug_log":       cfg.DebugLog.Enabled,
+		"tail_capture":    cfg.TailCapture.Enabled,
+		"diagnostics":     cfg.Diagnostics.Enabled,
+		"chaos":           cfg.Chaos.Enabled,
+		"shedding":        cfg.Shedding.Enabled,
+		"conn_limits":     cfg.ConnLimits.Enabled,
+		"autoscale":       cfg.Autoscale.Enabled,
+		"throttle":        cfg.Throttle.Enabled,
+		"stream_limits":   cfg.StreamLimits.Enabled,
+		"upload_scan":     cfg.UploadScan.Enabled,
+		"feature_flags":   cfg.FeatureFlags.Enabled,
+		"experiments":     cfg.Experiments.Enabled,
+		"penalty_box":     cfg.PenaltyBox.Enabled,
+		"anomaly":         cfg.Anomaly.Enabled,
+		"slo":             cfg.SLO.Enabled,
+		"latency_budget":  cfg.LatencyBudget.Enabled,
+		"chargeback":      cfg.Chargeback.Enabled,
+		"metering":        cfg.Metering.Enabled,
+		"queue":           cfg.Queue.Enabled,
+		"portal":          cfg.Portal.Enabled,
+		"cluster":         cfg.Cluster.Enabled,
+		"sql_storage":     cfg.SQLStorage.Enabled,
+		"federation":      cfg.Federation.Enabled,
+		"warm_restart":    cfg.WarmRestart.Enabled,
+		"docs":            docs.Enabled,
+	}
+	names := make([]string, 0, len(subsystems))
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	enabled := make([]any, 0, len(names))
+	for _, name := range names {
+		enabled = append(enabled, slog.Bool(name, subsystems[name]))
+	}
+
+	attrs := []any{
+		slog.String("version", version),
+		slog.String("environment", cfg.Server.Environment),
+		slog.String("listen", addr),
+		slog.Bool("tls", cfg.Server.TLSEnabled()),
+		slog.Any("config_files", cfg.Files),
+		slog.Group("subsystems", enabled...),
+		slog.Group("jwt",
+			slog.String("secret", cfg.Redacted().JWT.Secret),
+			slog.String("issuer", cfg.JWT.Issuer),
+			slog.String("audience", cfg.JWT.Audience),
+			slog.String("expiry", cfg.JWT.Expiry.String()),
+		),
+	}
+	if rateLimit.Enabled {
+		backend := "memory"
+		if rateLimit.UseRedis {
+			backend = fmt.Sprintf("redis://%s:%d/%d", rateLimit.Redis.Host, rateLimit.Redis.Port, rateLimit.Redis.DB)
+		} else if rateLimit.Sync.Enabled {
+			backend = fmt.Sprintf("memory+sync://%s (%d peers)", rateLimit.Sync.ListenAddr, len(rateLimit.Sync.Peers))
+		}
+		attrs = append(attrs, slog.Group("rate_limit",
+			slog.String("identifier", rateLimit.Identifier),
+			slog.Int("capacity", rateLimit.Capacity),
+			slog.Int("refill_rate", rateLimit.RefillRate),
+			slog.String("window", rateLimit.Window.String()),
+			slog.String("backend", backend),
+		))
+	}
+	if policy.Enabled {
+		attrs = append(attrs, slog.Group("policy",
+			slog.String("mode", policy.Mode),
+			slog.String("opa_url", policy.OPAURL),
+			slog.Bool("fail_open", policy.FailOpen),
+		))
+	}
+	if metrics.Enabled {
+		attrs = append(attrs, slog.String("metrics_path", metrics.Path))
+	}
+	if docs.Enabled {
+		attrs = append(attrs, slog.String("docs_path", "/swagger/"))
+	}
+
+	if report, err := config.Validate(); err == nil {
+		for _, issue := range report.Issues {
+			level := slog.LevelError
+			if issue.Warning {
+				level = slog.LevelWarn
+			}
+			logger.Log(context.Background(), level, "configuration issue",
+				slog.String("key", issue.Key),
+				slog.String("source", issue.Source),
+				slog.String("issue", issue.Message),
+			)
+		}
+	}
+
+	logger.Info("gateway ready", attrs...)
+}
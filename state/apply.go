@@ -0,0 +1,311 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/config"
+)
+
+// APIVersion and Kind identify declarative gateway specs
+const (
+	APIVersion = "gateway/v1"
+	Kind       = "GatewayConfig"
+)
+
+// Spec is a declarative description of the gateway, in the style of a
+// Kubernetes custom resource. Omitted sections are left unmanaged.
+type Spec struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   SpecMetadata `json:"metadata"`
+	Spec       SpecBody     `json:"spec"`
+}
+
+// SpecMetadata names a spec
+type SpecMetadata struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// SpecBody is the desired state
+type SpecBody struct {
+	APIKeys  []*auth.APIKey           `json:"api_keys,omitempty"`
+	Routes   []*config.UpstreamConfig `json:"routes,omitempty"` // Secrets are compared only by whether they are set
+	Plans    *Plans                   `json:"plans,omitempty"`
+	Policies *Policies                `json:"policies,omitempty"`
+	Products []*config.ProductConfig  `json:"products,omitempty"`
+}
+
+// Change is one difference between a spec and the gateway
+type Change struct {
+	Resource string   `json:"resource"` // "api_key", "route", "portal_plan", "bandwidth_plan", "policy" or "product"
+	Name     string   `json:"name"`
+	Action   string   `json:"action"`             // "create", "update" or "delete"
+	Fields   []string `json:"fields,omitempty"`   // Fields an update changes
+	Settings string   `json:"settings,omitempty"` // Settings that apply a configuration-backed change
+}
+
+// ApplyResult summarizes what applying a spec changed or would change
+type ApplyResult struct {
+	DryRun    bool      `json:"dry_run"`
+	Applied   bool      `json:"applied"`
+	Changes   []*Change `json:"changes"`
+	Unchanged int       `json:"unchanged"`
+	Errors    []string  `json:"errors,omitempty"` // Why the spec was not applied
+}
+
+// apiKeyStateFields are API key fields that record usage rather than
+// configuration, and are never compared or applied
+var apiKeyStateFields = []string{"requests", "created_at", "last_used_at"}
+
+// Apply diffs a spec against the gateway and applies the changes all or
+// nothing. API keys are applied at runtime; keys missing from the spec are
+// deleted only when prune is set. Routes, plans, policies and products come
+// from configuration, so a spec that changes them is rejected with the
+// settings to change instead.
+func (m *Manager) Apply(spec *Spec, prune, dryRun bool) (*ApplyResult, error) {
+	if spec.APIVersion != APIVersion || spec.Kind != Kind {
+		return nil, fmt.Errorf("spec must have apiVersion %s and kind %s", APIVersion, Kind)
+	}
+
+	result := &ApplyResult{
+		DryRun:  dryRun,
+		Changes: []*Change{},
+	}
+
+	keyChanges, desired, err := m.diffAPIKeys(spec.Spec.APIKeys, prune, result)
+	if err != nil {
+		return nil, err
+	}
+	result.Changes = append(result.Changes, keyChanges...)
+
+	local := m.snapshot()
+	if spec.Spec.Routes != nil {
+		current := make(map[string]interface{}, len(local.Routes))
+		for _, route := range local.Routes {
+			current[route.Name] = route
+		}
+		wanted := make(map[string]interface{}, len(spec.Spec.Routes))
+		for _, route := range spec.Spec.Routes {
+			wanted[route.Name] = route.Redacted()
+		}
+		m.diffConfig(result, "route", "UPSTREAMS and UPSTREAM_<NAME>_*", current, wanted)
+	}
+	if spec.Spec.Plans != nil {
+		m.diffConfig(result, "portal_plan", "PORTAL_PLANS", toInterfaces(local.Plans.Portal), toInterfaces(spec.Spec.Plans.Portal))
+		m.diffConfig(result, "bandwidth_plan", "THROTTLE_PLAN_RATES", toInterfaces(local.Plans.Bandwidth), toInterfaces(spec.Spec.Plans.Bandwidth))
+	}
+	if spec.Spec.Policies != nil {
+		m.diffConfig(result, "policy", "OPA_* and WAF_*",
+			map[string]interface{}{"opa": local.Policies.OPA, "waf": local.Policies.WAF},
+			map[string]interface{}{"opa": spec.Spec.Policies.OPA, "waf": spec.Spec.Policies.WAF})
+	}
+	if spec.Spec.Products != nil {
+		current := make(map[string]interface{}, len(local.Products))
+		for _, product := range local.Products {
+			current[product.Name] = product
+		}
+		wanted := make(map[string]interface{}, len(spec.Spec.Products))
+		for _, product := range spec.Spec.Products {
+			wanted[product.Name] = product
+		}
+		m.diffConfig(result, "product", "PRODUCTS and PRODUCT_<NAME>_*", current, wanted)
+	}
+
+	for _, change := range result.Changes {
+		if change.Settings != "" {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s %s cannot be changed at runtime; %s it through %s settings", change.Resource, change.Name, change.Action, change.Settings))
+		}
+	}
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	if err := m.applyAPIKeys(desired, prune && spec.Spec.APIKeys != nil); err != nil {
+		return nil, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// diffAPIKeys returns the API key changes and the desired keys by value
+func (m *Manager) diffAPIKeys(keys []*auth.APIKey, prune bool, result *ApplyResult) ([]*Change, map[string]*auth.APIKey, error) {
+	if keys == nil {
+		return nil, nil, nil
+	}
+
+	desired := make(map[string]*auth.APIKey, len(keys))
+	var changes []*Change
+	for _, key := range keys {
+		if key.Key == "" || key.UserID == "" || key.ExpiresAt.IsZero() {
+			return nil, nil, fmt.Errorf("API key %q must have a key, a user ID and an expiry", key.Name)
+		}
+		if _, duplicate := desired[key.Key]; duplicate {
+			return nil, nil, fmt.Errorf("API key %s is declared more than once", keyName(key.Key))
+		}
+		desired[key.Key] = key
+
+		existing, exists := m.apiKeyStore.GetAPIKey(key.Key)
+		if !exists {
+			changes = append(changes, &Change{Resource: "api_key", Name: keyName(key.Key), Action: "create"})
+			continue
+		}
+		fields := changedFields(existing, key, apiKeyStateFields...)
+		if len(fields) == 0 {
+			result.Unchanged++
+			continue
+		}
+		changes = append(changes, &Change{Resource: "api_key", Name: keyName(key.Key), Action: "update", Fields: fields})
+	}
+
+	if prune {
+		for _, existing := range m.apiKeyStore.ExportAPIKeys() {
+			if _, wanted := desired[existing.Key]; !wanted && !existing.Deleted() {
+				changes = append(changes, &Change{Resource: "api_key", Name: keyName(existing.Key), Action: "delete"})
+			}
+		}
+	}
+	return changes, desired, nil
+}
+
+// applyAPIKeys makes the stored API keys match the desired keys, restoring
+// the previous keys if any change fails
+func (m *Manager) applyAPIKeys(desired map[string]*auth.APIKey, prune bool) error {
+	previous := make(map[string]*auth.APIKey)
+	for _, existing := range m.apiKeyStore.ExportAPIKeys() {
+		previous[existing.Key] = existing
+	}
+
+	var applied []string
+	rollback := func() {
+		for _, key := range applied {
+			if before, ok := previous[key]; ok {
+				if err := m.apiKeyStore.ImportAPIKey(before); err != nil {
+					log.Printf("Failed to restore API key %s: %v", keyName(key), err)
+				}
+			} else {
+				m.apiKeyStore.PurgeAPIKey(key)
+			}
+		}
+	}
+
+	for key, spec := range desired {
+		before, exists := previous[key]
+		if exists && len(changedFields(before, spec, apiKeyStateFields...)) == 0 {
+			continue
+		}
+		copied := *spec
+		if exists {
+			copied.Requests, copied.CreatedAt, copied.LastUsedAt = before.Requests, before.CreatedAt, before.LastUsedAt
+		} else if copied.CreatedAt.IsZero() {
+			copied.CreatedAt = time.Now()
+		}
+		if err := m.apiKeyStore.ImportAPIKey(&copied); err != nil {
+			rollback()
+			return fmt.Errorf("%w: failed to apply API key %s: %v", ErrNotSaved, keyName(key), err)
+		}
+		applied = append(applied, key)
+	}
+	if !prune {
+		return nil
+	}
+	for key, before := range previous {
+		if _, wanted := desired[key]; wanted || before.Deleted() {
+			continue
+		}
+		if err := m.apiKeyStore.DeleteAPIKey(key); err != nil {
+			rollback()
+			return fmt.Errorf("%w: failed to delete API key %s: %v", ErrNotSaved, keyName(key), err)
+		}
+		applied = append(applied, key)
+	}
+	return nil
+}
+
+// diffConfig records changes to a configuration-backed resource
+func (m *Manager) diffConfig(result *ApplyResult, resource, settings string, current, wanted map[string]interface{}) {
+	for _, name := range sortedNames(wanted) {
+		existing, exists := current[name]
+		if !exists {
+			result.Changes = append(result.Changes, &Change{Resource: resource, Name: name, Action: "create", Settings: settings})
+			continue
+		}
+		fields := changedFields(existing, wanted[name])
+		if len(fields) == 0 {
+			result.Unchanged++
+			continue
+		}
+		result.Changes = append(result.Changes, &Change{Resource: resource, Name: name, Action: "update", Fields: fields, Settings: settings})
+	}
+	for _, name := range sortedNames(current) {
+		if _, exists := wanted[name]; !exists {
+			result.Changes = append(result.Changes, &Change{Resource: resource, Name: name, Action: "delete", Settings: settings})
+		}
+	}
+}
+
+// changedFields returns the JSON fields that differ between two values, or
+// "value" when they are not objects
+func changedFields(a, b interface{}, ignore ...string) []string {
+	if sameJSON(a, b) {
+		return nil
+	}
+
+	var fieldsA, fieldsB map[string]json.RawMessage
+	encodedA, _ := json.Marshal(a)
+	encodedB, _ := json.Marshal(b)
+	if json.Unmarshal(encodedA, &fieldsA) != nil || json.Unmarshal(encodedB, &fieldsB) != nil || fieldsA == nil || fieldsB == nil {
+		return []string{"value"}
+	}
+	for _, field := range ignore {
+		delete(fieldsA, field)
+		delete(fieldsB, field)
+	}
+
+	var fields []string
+	for field, value := range fieldsA {
+		if !sameJSON(value, fieldsB[field]) {
+			fields = append(fields, field)
+		}
+	}
+	for field := range fieldsB {
+		if _, exists := fieldsA[field]; !exists {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// keyName names an API key in change summaries by a prefix rather than
+// exposing the whole key
+func keyName(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
+
+// toInterfaces converts a plan map for diffing
+func toInterfaces[V any](values map[string]V) map[string]interface{} {
+	converted := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		converted[name] = value
+	}
+	return converted
+}
+
+// sortedNames returns the map's keys in order
+func sortedNames(values map[string]interface{}) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
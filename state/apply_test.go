@@ -0,0 +1,112 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/config"
+	"api-gateway/storage"
+)
+
+// failingStore refuses to save the API key named by fail
+type failingStore struct {
+	*storage.MemoryStore
+	fail string
+}
+
+func (s *failingStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if s.fail != "" && strings.HasSuffix(key, s.fail) {
+		return errors.New("connection refused")
+	}
+	return s.MemoryStore.Set(ctx, key, value, ttl)
+}
+
+// newTestManager returns a manager whose API keys are persisted in store
+func newTestManager(t *testing.T, store storage.Store) (*Manager, *auth.APIKeyStore) {
+	t.Helper()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewAPIKeyStore(time.Hour)
+	if err := keys.Persist(store, 0); err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(cfg, keys, []byte("signing-key")), keys
+}
+
+// newSpec returns a spec declaring the given API keys
+func newSpec(keys ...*auth.APIKey) *Spec {
+	return &Spec{APIVersion: APIVersion, Kind: Kind, Spec: SpecBody{APIKeys: keys}}
+}
+
+func newKey(key, name string) *auth.APIKey {
+	return &auth.APIKey{Key: key, Name: name, UserID: "1", IsActive: true, ExpiresAt: time.Now().Add(time.Hour)}
+}
+
+func TestApplyRollsBackFailedSaves(t *testing.T) {
+	store := &failingStore{MemoryStore: storage.NewMemoryStore()}
+	manager, keys := newTestManager(t, store)
+	if _, err := manager.Apply(newSpec(newKey("key-existing", "before")), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	store.fail = "key-broken"
+	_, err := manager.Apply(newSpec(newKey("key-existing", "after"), newKey("key-new", "new"), newKey("key-broken", "broken")), false, false)
+	if !errors.Is(err, ErrNotSaved) || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Apply with a failing save: %v", err)
+	}
+
+	// Nothing the spec changed is left behind
+	if key, ok := keys.GetAPIKey("key-existing"); !ok || key.Name != "before" {
+		t.Errorf("existing key after rollback: %+v", key)
+	}
+	for _, value := range []string{"key-new", "key-broken"} {
+		if _, ok := keys.GetAPIKey(value); ok {
+			t.Errorf("%s kept after rollback", value)
+		}
+	}
+}
+
+func TestImportReportsFailedSaves(t *testing.T) {
+	source, sourceKeys := newTestManager(t, storage.NewMemoryStore())
+	if err := sourceKeys.ImportAPIKey(newKey("key-broken", "broken")); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := source.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager, keys := newTestManager(t, &failingStore{MemoryStore: storage.NewMemoryStore(), fail: "key-broken"})
+	if _, err := manager.Import(bundle, false, false); !errors.Is(err, ErrNotSaved) {
+		t.Error("Import with a failing save succeeded")
+	}
+	if _, ok := keys.GetAPIKey("key-broken"); ok {
+		t.Error("key kept although it was not saved")
+	}
+}
+
+func TestApplyRejectsConfigurationChanges(t *testing.T) {
+	manager, keys := newTestManager(t, storage.NewMemoryStore())
+	spec := newSpec(newKey("key-new", "new"))
+	spec.Spec.Routes = []*config.UpstreamConfig{{Name: "orders", URL: "http://orders:8080", PathPrefix: "/orders"}}
+	spec.Spec.Plans = &Plans{Portal: map[string]int{"gold": 6000}}
+
+	result, err := manager.Apply(spec, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied || !strings.Contains(strings.Join(result.Errors, "\n"), "route orders cannot be changed at runtime") ||
+		!strings.Contains(strings.Join(result.Errors, "\n"), "portal_plan gold cannot be changed at runtime") {
+		t.Errorf("applied %v with errors %q, want the route and plan rejected", result.Applied, result.Errors)
+	}
+	// The spec is applied all or nothing, so its API keys are not either
+	if _, ok := keys.GetAPIKey("key-new"); ok {
+		t.Error("API key applied from a rejected spec")
+	}
+}
@@ -0,0 +1,128 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"api-gateway/auth"
+	"api-gateway/config"
+)
+
+// ErrNotSaved is wrapped by errors for API keys the store failed to save
+var ErrNotSaved = errors.New("API key store failed")
+
+// ImportResult reports what an import changed or would change
+type ImportResult struct {
+	DryRun      bool     `json:"dry_run"`
+	Imported    int      `json:"imported"`    // Keys added
+	Overwritten int      `json:"overwritten"` // Existing keys replaced
+	Skipped     int      `json:"skipped"`     // Existing keys left unchanged
+	Warnings    []string `json:"warnings"`    // Configuration that differs and must be applied through settings
+}
+
+// Manager exports and imports gateway state
+type Manager struct {
+	config      *config.Config
+	apiKeyStore *auth.APIKeyStore
+	signingKey  []byte
+}
+
+// NewManager creates a state manager signing bundles with signingKey
+func NewManager(cfg *config.Config, apiKeyStore *auth.APIKeyStore, signingKey []byte) *Manager {
+	return &Manager{
+		config:      cfg,
+		apiKeyStore: apiKeyStore,
+		signingKey:  signingKey,
+	}
+}
+
+// Export returns a signed bundle of the current state
+func (m *Manager) Export() (*Bundle, error) {
+	return Sign(m.snapshot(), m.signingKey)
+}
+
+// Import verifies a bundle and loads its API keys. Routes, policies, plans and
+// products come from configuration, so differences are reported as warnings.
+func (m *Manager) Import(bundle *Bundle, overwrite, dryRun bool) (*ImportResult, error) {
+	snapshot, err := Verify(bundle, m.signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		DryRun:   dryRun,
+		Warnings: []string{},
+	}
+
+	for _, key := range snapshot.APIKeys {
+		_, exists := m.apiKeyStore.GetAPIKey(key.Key)
+		switch {
+		case exists && !overwrite:
+			result.Skipped++
+			continue
+		case exists:
+			result.Overwritten++
+		default:
+			result.Imported++
+		}
+		if !dryRun {
+			if err := m.apiKeyStore.ImportAPIKey(key); err != nil {
+				return nil, fmt.Errorf("%w: failed to import API key %s: %v", ErrNotSaved, keyName(key.Key), err)
+			}
+		}
+	}
+
+	local := m.snapshot()
+	sections := []struct {
+		name     string
+		settings string
+		local    interface{}
+		imported interface{}
+	}{
+		{"routes", "UPSTREAMS and UPSTREAM_<NAME>_*", local.Routes, snapshot.Routes},
+		{"OPA policy", "OPA_*", local.Policies.OPA, snapshot.Policies.OPA},
+		{"WAF policy", "WAF_*", local.Policies.WAF, snapshot.Policies.WAF},
+		{"portal plans", "PORTAL_PLANS", local.Plans.Portal, snapshot.Plans.Portal},
+		{"bandwidth plans", "THROTTLE_PLAN_RATES", local.Plans.Bandwidth, snapshot.Plans.Bandwidth},
+		{"products", "PRODUCTS and PRODUCT_<NAME>_*", local.Products, snapshot.Products},
+	}
+	for _, section := range sections {
+		if !sameJSON(section.local, section.imported) {
+			result.Warnings = append(result.Warnings, "Bundle section "+section.name+" differs from this gateway; apply it through "+section.settings+" settings")
+		}
+	}
+
+	return result, nil
+}
+
+// snapshot captures the current state
+func (m *Manager) snapshot() *Snapshot {
+	redacted := m.config.Redacted()
+
+	return &Snapshot{
+		Version:     Version,
+		ExportedAt:  time.Now().UTC(),
+		Environment: m.config.Server.Environment,
+		APIKeys:     m.apiKeyStore.ExportAPIKeys(),
+		Routes:      redacted.Proxy.Upstreams,
+		Policies: Policies{
+			OPA: m.config.Policy,
+			WAF: m.config.WAF,
+		},
+		Plans: Plans{
+			Portal:    m.config.Portal.Plans,
+			Bandwidth: m.config.Throttle.PlanRates,
+		},
+		Products: m.config.Products,
+	}
+}
+
+// sameJSON reports whether two values have the same JSON encoding
+func sameJSON(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,18 @@
+// Package static embeds the gateway's static web assets into the binary
+package static
+
+import (
+	_ "embed"
+)
+
+// SwaggerHTML is the Swagger UI page template. It expects a DocURL field
+// pointing at the OpenAPI document.
+//
+//go:embed swagger.html
+var SwaggerHTML string
+
+// DeviceHTML is the device flow verification page template. It expects
+// UserCode and SSOLoginURL fields; the SSO link is hidden when the URL is empty.
+//
+//go:embed device.html
+var DeviceHTML string
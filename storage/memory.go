@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a value with its expiry time; zero never expires
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// expired reports whether the entry has expired at now
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryStore keeps values and locks in memory; they are lost on restart
+// unless saved with warm restarts
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		entries: make(map[string]*memoryEntry),
+	}
+
+	go store.cleanupRoutine()
+
+	return store
+}
+
+// expiry returns the expiry time of a value stored now for ttl
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// lookup returns the unexpired entry for key. The caller holds the lock.
+func (s *MemoryStore) lookup(key string, now time.Time) *memoryEntry {
+	entry, exists := s.entries[key]
+	if !exists {
+		return nil
+	}
+	if entry.expired(now) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// Get returns the value of key
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.lookup(key, time.Now())
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(entry.value), nil
+}
+
+// Set stores a value
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &memoryEntry{value: bytes.Clone(value), expiresAt: expiry(time.Now(), ttl)}
+	return nil
+}
+
+// Delete removes key
+func (s *MemoryStore) Delete(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.lookup(key, time.Now())
+	delete(s.entries, key)
+	return entry != nil, nil
+}
+
+// SetNX stores a value if key is unset
+func (s *MemoryStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.lookup(key, now) != nil {
+		return false, nil
+	}
+	s.entries[key] = &memoryEntry{value: bytes.Clone(value), expiresAt: expiry(now, ttl)}
+	return true, nil
+}
+
+// Swap stores a value and returns the previous one
+func (s *MemoryStore) Swap(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var previous []byte
+	if entry := s.lookup(key, now); entry != nil {
+		previous = entry.value
+	}
+	s.entries[key] = &memoryEntry{value: bytes.Clone(value), expiresAt: expiry(now, ttl)}
+	return previous, nil
+}
+
+// Incr adds one to a counter
+func (s *MemoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry := s.lookup(key, now)
+	if entry == nil {
+		s.entries[key] = &memoryEntry{value: []byte("1"), expiresAt: expiry(now, ttl)}
+		return 1, nil
+	}
+	count, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value of %s is not a counter", key)
+	}
+	count++
+	entry.value = strconv.AppendInt(nil, count, 10)
+	return count, nil
+}
+
+// Keys returns the keys starting with prefix
+func (s *MemoryStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, entry := range s.entries {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Lock takes a named lock
+func (s *MemoryStore) Lock(ctx context.Context, name string, ttl time.Duration) (func(context.Context) error, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(name)
+	acquired, _ := s.SetNX(ctx, key, token, ttl)
+	if !acquired {
+		return nil, ErrLocked
+	}
+	return func(context.Context) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if entry := s.lookup(key, time.Now()); entry != nil && bytes.Equal(entry.value, token) {
+			delete(s.entries, key)
+		}
+		return nil
+	}, nil
+}
+
+// memorySnapshotEntry is a saved value
+type memorySnapshotEntry struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Snapshot returns the stored values, so state kept in memory survives a
+// warm restart. Locks are left out.
+func (s *MemoryStore) Snapshot() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	snapshot := make(map[string]memorySnapshotEntry, len(s.entries))
+	for key, entry := range s.entries {
+		if strings.HasPrefix(key, lockKey("")) || entry.expired(now) {
+			continue
+		}
+		snapshot[key] = memorySnapshotEntry{Value: entry.value, ExpiresAt: entry.expiresAt}
+	}
+	return snapshot, nil
+}
+
+// Restore loads saved values that have not expired
+func (s *MemoryStore) Restore(data json.RawMessage) error {
+	var snapshot map[string]memorySnapshotEntry
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, saved := range snapshot {
+		entry := &memoryEntry{value: saved.Value, expiresAt: saved.ExpiresAt}
+		if !entry.expired(now) {
+			s.entries[key] = entry
+		}
+	}
+	return nil
+}
+
+// cleanupRoutine periodically removes expired values
+func (s *MemoryStore) cleanupRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if entry.expired(now) {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
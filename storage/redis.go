@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps values and locks in Redis so every replica shares them.
+// Keys are used as given.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+// Get returns the value of key
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// Set stores a value
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := s.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes key
+func (s *RedisStore) Delete(ctx context.Context, key string) (bool, error) {
+	deleted, err := s.client.Del(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return deleted > 0, nil
+}
+
+// SetNX stores a value if key is unset
+func (s *RedisStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	stored, err := s.client.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return stored, nil
+}
+
+// Swap stores a value and returns the previous one
+func (s *RedisStore) Swap(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, error) {
+	previous, err := s.client.SetArgs(ctx, key, value, redis.SetArgs{Get: true, TTL: ttl}).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to swap %s: %w", key, err)
+	}
+	return previous, nil
+}
+
+// incrScript increments a counter, starting its expiry on the first increment
+var incrScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Incr adds one to a counter
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	count, err := incrScript.Run(ctx, s.client, []string{key}, strconv.FormatInt(ttl.Milliseconds(), 10)).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment %s: %w", key, err)
+	}
+	return count, nil
+}
+
+// globEscaper escapes the characters SCAN patterns treat specially
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// Keys returns the keys starting with prefix
+func (s *RedisStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	iter := s.client.Scan(ctx, 0, globEscaper.Replace(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list keys under %s: %w", prefix, err)
+	}
+	// SCAN may return a key more than once
+	sort.Strings(keys)
+	unique := keys[:0]
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			unique = append(unique, key)
+		}
+	}
+	return unique, nil
+}
+
+// unlockScript deletes a lock only while it still holds the caller's token
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Lock takes a named lock
+func (s *RedisStore) Lock(ctx context.Context, name string, ttl time.Duration) (func(context.Context) error, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(name)
+	acquired, err := s.SetNX(ctx, key, token, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrLocked
+	}
+	return func(ctx context.Context) error {
+		if err := unlockScript.Run(ctx, s.client, []string{key}, token).Err(); err != nil {
+			return fmt.Errorf("failed to release lock %s: %w", name, err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SQLConfig represents the table an SQL store keeps its values in. The table
+// needs a primary key column k (string), a binary column v and an integer
+// column expires_at holding Unix milliseconds, 0 for values that never expire.
+type SQLConfig struct {
+	Table       string
+	Placeholder string // "?" for MySQL and SQLite, "$" for PostgreSQL's $1, $2, ...
+}
+
+// maxRetries bounds the compare-and-swap attempts of a contended update
+const maxRetries = 10
+
+// SQLStore keeps values and locks in an SQL table through database/sql, so
+// replicas sharing the database share them. It only uses statements common to
+// PostgreSQL, MySQL and SQLite; read-modify-write operations are done as
+// compare-and-swap updates, so no particular isolation level is needed.
+type SQLStore struct {
+	db      *sql.DB
+	table   string
+	dollar  bool
+	queries map[string]string
+}
+
+// NewSQLStore creates a new store on an open database
+func NewSQLStore(db *sql.DB, config *SQLConfig) *SQLStore {
+	store := &SQLStore{
+		db:      db,
+		table:   config.Table,
+		dollar:  config.Placeholder == "$",
+		queries: make(map[string]string),
+	}
+
+	for name, query := range map[string]string{
+		"get":     "SELECT v, expires_at FROM %s WHERE k = ?",
+		"insert":  "INSERT INTO %s (k, v, expires_at) VALUES (?, ?, ?)",
+		"update":  "UPDATE %s SET v = ?, expires_at = ? WHERE k = ?",
+		"replace": "UPDATE %s SET v = ?, expires_at = ? WHERE k = ? AND v = ? AND expires_at = ?",
+		"delete":  "DELETE FROM %s WHERE k = ? AND (expires_at = 0 OR expires_at > ?)",
+		"expire":  "DELETE FROM %s WHERE k = ? AND expires_at <> 0 AND expires_at <= ?",
+		"unlock":  "DELETE FROM %s WHERE k = ? AND v = ?",
+		"keys":    "SELECT k FROM %s WHERE SUBSTR(k, 1, ?) = ? AND (expires_at = 0 OR expires_at > ?) ORDER BY k",
+		"cleanup": "DELETE FROM %s WHERE expires_at <> 0 AND expires_at <= ?",
+	} {
+		store.queries[name] = store.rebind(fmt.Sprintf(query, config.Table))
+	}
+
+	go store.cleanupRoutine()
+
+	return store
+}
+
+// rebind numbers the placeholders of a query for PostgreSQL
+func (s *SQLStore) rebind(query string) string {
+	if !s.dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// millis returns the stored expiry of a value stored now for ttl
+func millis(now time.Time, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now.Add(ttl).UnixMilli()
+}
+
+// load returns the value of key with its stored expiry, or ErrNotFound when
+// it is unset or expired
+func (s *SQLStore) load(ctx context.Context, key string) ([]byte, int64, error) {
+	var value []byte
+	var expiresAt int64
+	err := s.db.QueryRowContext(ctx, s.queries["get"], key).Scan(&value, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	if expiresAt != 0 && expiresAt <= time.Now().UnixMilli() {
+		return nil, 0, ErrNotFound
+	}
+	return value, expiresAt, nil
+}
+
+// Get returns the value of key
+func (s *SQLStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := s.load(ctx, key)
+	return value, err
+}
+
+// Set stores a value, updating the row or inserting it when there is none
+func (s *SQLStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	expiresAt := millis(time.Now(), ttl)
+	var insertErr error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		result, err := s.db.ExecContext(ctx, s.queries["update"], value, expiresAt, key)
+		if err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+		if updated, _ := result.RowsAffected(); updated > 0 {
+			return nil
+		}
+		// Another writer may insert the row first, in which case the
+		// update is tried again
+		if _, insertErr = s.db.ExecContext(ctx, s.queries["insert"], key, value, expiresAt); insertErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to set %s: %w", key, insertErr)
+}
+
+// Delete removes key
+func (s *SQLStore) Delete(ctx context.Context, key string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, s.queries["delete"], key, time.Now().UnixMilli())
+	if err != nil {
+		return false, fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted > 0, nil
+}
+
+// SetNX stores a value if key is unset, replacing an expired row
+func (s *SQLStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, s.queries["expire"], key, now.UnixMilli()); err != nil {
+		return false, fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	_, insertErr := s.db.ExecContext(ctx, s.queries["insert"], key, value, millis(now, ttl))
+	if insertErr == nil {
+		return true, nil
+	}
+	// Drivers report duplicate keys differently, so check for the row
+	if _, _, err := s.load(ctx, key); err == nil {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to set %s: %w", key, insertErr)
+}
+
+// compareAndSwap replaces the value of key if it is still current
+func (s *SQLStore) compareAndSwap(ctx context.Context, key string, current []byte, currentExpiry int64, value []byte, expiresAt int64) (bool, error) {
+	result, err := s.db.ExecContext(ctx, s.queries["replace"], value, expiresAt, key, current, currentExpiry)
+	if err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	updated, _ := result.RowsAffected()
+	return updated > 0, nil
+}
+
+// Swap stores a value and returns the previous one
+func (s *SQLStore) Swap(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, error) {
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		expiresAt := millis(time.Now(), ttl)
+		current, currentExpiry, err := s.load(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			stored, err := s.SetNX(ctx, key, value, ttl)
+			if err != nil || stored {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		swapped, err := s.compareAndSwap(ctx, key, current, currentExpiry, value, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if swapped {
+			return current, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to swap %s: too much contention", key)
+}
+
+// Incr adds one to a counter
+func (s *SQLStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		current, currentExpiry, err := s.load(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			stored, err := s.SetNX(ctx, key, []byte("1"), ttl)
+			if err != nil {
+				return 0, err
+			}
+			if stored {
+				return 1, nil
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		count, err := strconv.ParseInt(string(current), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of %s is not a counter", key)
+		}
+		count++
+		swapped, err := s.compareAndSwap(ctx, key, current, currentExpiry, strconv.AppendInt(nil, count, 10), currentExpiry)
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return count, nil
+		}
+	}
+	return 0, fmt.Errorf("failed to increment %s: too much contention", key)
+}
+
+// Keys returns the keys starting with prefix
+func (s *SQLStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.queries["keys"], utf8.RuneCountInString(prefix), prefix, time.Now().UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys under %s: %w", prefix, err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to list keys under %s: %w", prefix, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list keys under %s: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+// Lock takes a named lock
+func (s *SQLStore) Lock(ctx context.Context, name string, ttl time.Duration) (func(context.Context) error, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(name)
+	acquired, err := s.SetNX(ctx, key, token, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrLocked
+	}
+	return func(ctx context.Context) error {
+		if _, err := s.db.ExecContext(ctx, s.queries["unlock"], key, token); err != nil {
+			return fmt.Errorf("failed to release lock %s: %w", name, err)
+		}
+		return nil
+	}, nil
+}
+
+// cleanupRoutine periodically deletes expired rows
+func (s *SQLStore) cleanupRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := s.db.ExecContext(ctx, s.queries["cleanup"], time.Now().UnixMilli()); err != nil {
+			log.Printf("Failed to delete expired rows from %s: %v", s.table, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned for keys that are unset or have expired
+var ErrNotFound = errors.New("key not found")
+
+// ErrLocked is returned when a lock is held by someone else
+var ErrLocked = errors.New("lock is held")
+
+// Cache holds values by key. A TTL of 0 keeps a value until it is deleted.
+type Cache interface {
+	// Get returns the value of key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores a value, replacing any previous one
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key, reporting whether it was set. Only one of several
+	// concurrent callers sees it removed.
+	Delete(ctx context.Context, key string) (bool, error)
+}
+
+// KeyValueStore is a cache with the atomic operations needed for state
+// shared between gateway replicas
+type KeyValueStore interface {
+	Cache
+	// SetNX stores a value only if key is unset, reporting whether it did
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Swap stores a value and returns the previous one, or nil when key was unset
+	Swap(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, error)
+	// Incr adds one to the counter at key and returns the new count. A
+	// counter started by Incr expires after ttl.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Keys returns the keys starting with prefix, sorted
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Locker hands out named locks. A lock lapses after its TTL, so a holder that
+// dies cannot block others for long.
+type Locker interface {
+	// Lock takes the named lock, or returns ErrLocked while another holder
+	// has it. Calling release frees it, unless it has lapsed and been taken
+	// by someone else meanwhile.
+	Lock(ctx context.Context, name string, ttl time.Duration) (release func(context.Context) error, err error)
+}
+
+// Store is a key-value store that also provides locks, as every backend does
+type Store interface {
+	KeyValueStore
+	Locker
+}
+
+// Every backend is a full store
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*RedisStore)(nil)
+	_ Store = (*SQLStore)(nil)
+)
+
+// Acquire takes the named lock, waiting while someone else holds it until ctx
+// is done
+func Acquire(ctx context.Context, locker Locker, name string, ttl time.Duration) (func(context.Context) error, error) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		release, err := locker.Lock(ctx, name, ttl)
+		if !errors.Is(err, ErrLocked) {
+			return release, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to take lock %s: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// lockKey is the key a backend holds a lock under
+func lockKey(name string) string {
+	return "lock:" + name
+}
+
+// newLockToken returns a random value identifying one holder of a lock
+func newLockToken() ([]byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,137 @@
+package streamlimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// QuotaStore accounts streamed bytes per consumer in fixed quota windows
+type QuotaStore interface {
+	// Usage returns the bytes a consumer streamed in the current window and
+	// when the window ends
+	Usage(ctx context.Context, consumer string, window time.Duration) (int64, time.Time, error)
+	// Add accounts bytes to a consumer, starting a window if none is open
+	Add(ctx context.Context, consumer string, n int64, window time.Duration) error
+}
+
+// usageWindow holds the bytes of one consumer
+type usageWindow struct {
+	bytes int64
+	end   time.Time
+}
+
+// MemoryQuotaStore accounts streamed bytes in memory
+type MemoryQuotaStore struct {
+	mu      sync.Mutex
+	windows map[string]*usageWindow
+}
+
+// NewMemoryQuotaStore creates a new in-memory quota store
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	store := &MemoryQuotaStore{
+		windows: make(map[string]*usageWindow),
+	}
+
+	go store.cleanupRoutine()
+
+	return store
+}
+
+// Usage returns the bytes a consumer streamed in the current window
+func (s *MemoryQuotaStore) Usage(ctx context.Context, consumer string, window time.Duration) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	win, exists := s.windows[consumer]
+	if !exists || !now.Before(win.end) {
+		return 0, now.Add(window), nil
+	}
+	return win.bytes, win.end, nil
+}
+
+// Add accounts bytes to a consumer
+func (s *MemoryQuotaStore) Add(ctx context.Context, consumer string, n int64, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	win, exists := s.windows[consumer]
+	if !exists || !now.Before(win.end) {
+		win = &usageWindow{end: now.Add(window)}
+		s.windows[consumer] = win
+	}
+	win.bytes += n
+	return nil
+}
+
+// cleanupRoutine removes finished quota windows
+func (s *MemoryQuotaStore) cleanupRoutine() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for consumer, win := range s.windows {
+			if !now.Before(win.end) {
+				delete(s.windows, consumer)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisQuotaStore accounts streamed bytes in Redis so the quota holds across replicas
+type RedisQuotaStore struct {
+	client *redis.Client
+}
+
+// NewRedisQuotaStore creates a new Redis-backed quota store
+func NewRedisQuotaStore(client *redis.Client) *RedisQuotaStore {
+	return &RedisQuotaStore{
+		client: client,
+	}
+}
+
+// addScript adds to a counter, starting its window when it is created
+var addScript = redis.NewScript(`
+local bytes = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return bytes
+`)
+
+// Usage returns the bytes a consumer streamed in the current window
+func (s *RedisQuotaStore) Usage(ctx context.Context, consumer string, window time.Duration) (int64, time.Time, error) {
+	key := "stream-quota:" + consumer
+	pipe := s.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read stream quota usage: %w", err)
+	}
+
+	bytes, err := get.Int64()
+	if err == redis.Nil {
+		return 0, time.Now().Add(window), nil
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read stream quota usage: %w", err)
+	}
+	return bytes, time.Now().Add(ttl.Val()), nil
+}
+
+// Add accounts bytes to a consumer
+func (s *RedisQuotaStore) Add(ctx context.Context, consumer string, n int64, window time.Duration) error {
+	if err := addScript.Run(ctx, s.client, []string{"stream-quota:" + consumer}, n, strconv.FormatInt(window.Milliseconds(), 10)).Err(); err != nil {
+		return fmt.Errorf("failed to account streamed bytes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package streamlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// Route limits the streams of requests matching its path prefixes
+type Route struct {
+	Name             string
+	Paths            []string
+	MaxRequestBytes  int64         // 0 is unlimited
+	MaxResponseBytes int64         // 0 is unlimited
+	MaxDuration      time.Duration // 0 is unlimited
+}
+
+// Config represents stream limit configuration
+type Config struct {
+	Routes      []*Route
+	Quota       int64            // Bytes per consumer and quota window; 0 disables the quota
+	PlanQuotas  map[string]int64 // Plan -> bytes per quota window; 0 is unlimited
+	QuotaWindow time.Duration
+	// Identify returns the consumer a stream is accounted to and its plan
+	Identify func(r *http.Request) (consumer, plan string)
+}
+
+// errResponseTooLarge aborts a response that outgrew its limit
+var errResponseTooLarge = errors.New("response body exceeds the stream limit")
+
+// Limiter enforces per-route stream limits and per-consumer transfer quotas
+type Limiter struct {
+	config *Config
+	quotas QuotaStore
+
+	aborted *metrics.CounterVec
+}
+
+// NewLimiter creates a new stream limiter
+func NewLimiter(config *Config, quotas QuotaStore, reg *metrics.Registry) *Limiter {
+	return &Limiter{
+		config: config,
+		quotas: quotas,
+		aborted: reg.NewCounterVec("gateway_stream_limit_aborts_total",
+			"Streams rejected or cut off by stream limits, by route and limit (request_bytes, response_bytes, duration or quota).", "route", "limit"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (l *Limiter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := l.match(r.URL.Path)
+			consumer, plan := l.config.Identify(r)
+			quota := l.config.Quota
+			if planQuota, ok := l.config.PlanQuotas[plan]; ok {
+				quota = planQuota
+			}
+			if route == nil && quota <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			routeName := "none"
+			if route != nil {
+				routeName = route.Name
+			}
+			s := &stream{route: route, budget: -1}
+
+			if quota > 0 {
+				used, reset, err := l.quotas.Usage(r.Context(), consumer, l.config.QuotaWindow)
+				if err != nil {
+					// Fail open rather than block traffic when usage is unavailable
+					log.Printf("Stream quota check failed: %v", err)
+				} else {
+					s.budget = quota - used
+					if s.budget <= 0 || r.ContentLength > s.budget {
+						l.aborted.Inc(routeName, "quota")
+						w.Header().Set("Content-Type", "application/json")
+						w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+						w.WriteHeader(http.StatusTooManyRequests)
+						fmt.Fprintf(w, `{"error":"Transfer quota exceeded","details":"The transfer quota of %d bytes per %s is exhausted"}`, quota, l.config.QuotaWindow)
+						return
+					}
+				}
+			}
+
+			if limit, name := s.requestLimit(); limit >= 0 && r.ContentLength > limit {
+				l.aborted.Inc(routeName, name)
+				http.Error(w, fmt.Sprintf(`{"error":"Request body too large","details":"Request bodies on this route are limited to %d bytes"}`, limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			if route != nil && route.MaxDuration > 0 {
+				ctx, cancel := context.WithTimeoutCause(r.Context(), route.MaxDuration,
+					fmt.Errorf("Streams on this route are limited to %s", route.MaxDuration))
+				defer cancel()
+				r = r.WithContext(ctx)
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &limitedBody{ReadCloser: r.Body, stream: s}
+			}
+			sw := &limitedWriter{ResponseWriter: w, stream: s}
+
+			defer func() {
+				if quota > 0 {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					if err := l.quotas.Add(ctx, consumer, s.requestBytes.Load()+s.responseBytes.Load(), l.config.QuotaWindow); err != nil {
+						log.Printf("Stream quota accounting failed: %v", err)
+					}
+					cancel()
+				}
+				if limit := s.exceeded.Load(); limit != nil {
+					l.aborted.Inc(routeName, *limit)
+					log.Printf("Stream on %s cut off by the %s limit after %d request and %d response bytes",
+						r.URL.Path, *limit, s.requestBytes.Load(), s.responseBytes.Load())
+				} else if r.Context().Err() == context.DeadlineExceeded && route != nil && route.MaxDuration > 0 {
+					l.aborted.Inc(routeName, "duration")
+				}
+
+				// The proxy aborts when a replaced response's body is refused;
+				// the error response has been written already
+				if v := recover(); v != nil {
+					if v == http.ErrAbortHandler && sw.replaced {
+						return
+					}
+					panic(v)
+				}
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// match returns the route with the longest path prefix matching path, or nil
+func (l *Limiter) match(path string) *Route {
+	var matched *Route
+	longest := -1
+	for _, route := range l.config.Routes {
+		for _, prefix := range route.Paths {
+			if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+				matched, longest = route, len(prefix)
+			}
+		}
+	}
+	return matched
+}
+
+// stream tracks the bytes of one request and response
+type stream struct {
+	route  *Route
+	budget int64 // Quota bytes left for the request and response together; -1 is unlimited
+
+	requestBytes  atomic.Int64
+	responseBytes atomic.Int64
+	exceeded      atomic.Pointer[string] // Name of the limit that cut the stream off
+}
+
+// requestLimit returns how many request body bytes may be streamed, or -1
+// for unlimited, and which limit applies
+func (s *stream) requestLimit() (int64, string) {
+	limit := int64(-1)
+	name := "request_bytes"
+	if s.route != nil && s.route.MaxRequestBytes > 0 {
+		limit = s.route.MaxRequestBytes
+	}
+	if s.budget >= 0 && (limit < 0 || s.budget < limit) {
+		limit, name = s.budget, "quota"
+	}
+	return limit, name
+}
+
+// responseLimit returns how many response body bytes may be streamed, or -1
+// for unlimited, and which limit applies
+func (s *stream) responseLimit() (int64, string) {
+	limit := int64(-1)
+	name := "response_bytes"
+	if s.route != nil && s.route.MaxResponseBytes > 0 {
+		limit = s.route.MaxResponseBytes
+	}
+	if s.budget >= 0 {
+		if left := s.budget - s.requestBytes.Load(); limit < 0 || left < limit {
+			limit, name = left, "quota"
+		}
+	}
+	return limit, name
+}
+
+// limitedBody cuts off request bodies that exceed the stream's limit
+type limitedBody struct {
+	io.ReadCloser
+	stream *stream
+}
+
+// Read implements io.Reader
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	total := b.stream.requestBytes.Add(int64(n))
+	if limit, name := b.stream.requestLimit(); limit >= 0 && total > limit {
+		b.stream.exceeded.Store(&name)
+		b.stream.requestBytes.Add(limit - total)
+		return n - int(total-limit), &http.MaxBytesError{Limit: limit}
+	}
+	return n, err
+}
+
+// limitedWriter cuts off responses that exceed the stream's limit
+type limitedWriter struct {
+	http.ResponseWriter
+	stream   *stream
+	replaced bool // The upstream response was replaced by an error
+}
+
+// WriteHeader rejects responses whose declared length already exceeds the limit
+func (w *limitedWriter) WriteHeader(status int) {
+	limit, name := w.stream.responseLimit()
+	if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && limit >= 0 && length > limit {
+		w.stream.exceeded.Store(&name)
+		w.replaced = true
+		header := w.Header()
+		for key := range header {
+			header.Del(key)
+		}
+		header.Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w.ResponseWriter, `{"error":"Response too large","details":"Response bodies on this route are limited to %d bytes"}`, limit)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write passes data on until the limit is reached, then fails so the proxy
+// aborts the response
+func (w *limitedWriter) Write(data []byte) (int, error) {
+	if w.replaced {
+		return 0, errResponseTooLarge
+	}
+	limit, name := w.stream.responseLimit()
+	if limit >= 0 {
+		if left := limit - w.stream.responseBytes.Load(); int64(len(data)) > left {
+			n, _ := w.ResponseWriter.Write(data[:max(left, 0)])
+			w.stream.responseBytes.Add(int64(n))
+			w.stream.exceeded.Store(&name)
+			return n, errResponseTooLarge
+		}
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.stream.responseBytes.Add(int64(n))
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *limitedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,347 @@
+package tailcapture
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"api-gateway/auth"
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/redact"
+)
+
+// Config represents tail-based capture configuration
+type Config struct {
+	SlowThreshold time.Duration    // Requests taking at least this long are kept; 0 disables
+	MinStatus     int              // Responses with at least this status are kept
+	Size          int              // Captures kept; the oldest are overwritten
+	MaxBodySize   int              // Bytes of each body kept
+	Redactor      *redact.Redactor // Masks secrets in headers, query strings and bodies
+}
+
+// Event is one step of a request's trace
+type Event struct {
+	Name     string  `json:"name"`
+	OffsetMs float64 `json:"offset_ms"` // Time since the gateway received the request
+	Detail   string  `json:"detail,omitempty"`
+}
+
+// Capture is a retained slow or failed request
+type Capture struct {
+	ID              string              `json:"id"`
+	Time            time.Time           `json:"time"`
+	Reasons         []string            `json:"reasons"` // "error" and/or "slow"
+	RequestID       string              `json:"request_id,omitempty"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	Route           string              `json:"route"`
+	ClientIP        string              `json:"client_ip"`
+	Consumer        string              `json:"consumer"`
+	Status          int                 `json:"status"`
+	DurationMs      float64             `json:"duration_ms"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+	Trace           []Event             `json:"trace"`
+}
+
+// Filter selects captures
+type Filter struct {
+	Reason     string // "error" or "slow"; empty matches both
+	PathPrefix string
+	Limit      int // 0 returns every match
+}
+
+// Recorder traces every request but keeps only those that turn out slow or
+// failed, in a ring buffer
+type Recorder struct {
+	config *Config
+
+	mu       sync.Mutex
+	captures []*Capture // Ring buffer
+	next     int
+
+	captured *metrics.CounterVec
+}
+
+// NewRecorder creates a new tail-based capture recorder
+func NewRecorder(config *Config, reg *metrics.Registry) *Recorder {
+	return &Recorder{
+		config:   config,
+		captures: make([]*Capture, 0, config.Size),
+		captured: reg.NewCounterVec("gateway_tail_captures_total",
+			"Requests retained for triage, by reason.", "reason"),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (rc *Recorder) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = auth.WithIdentitySlot(r)
+			t := &trace{start: time.Now()}
+			t.add("received", "")
+			r = r.WithContext(httptrace.WithClientTrace(r.Context(), t.clientTrace()))
+
+			requestBody := httputil.PeekBody(r, int64(rc.config.MaxBodySize))
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK, limit: rc.config.MaxBodySize, trace: t}
+
+			next.ServeHTTP(rw, r)
+
+			duration := time.Since(t.start)
+			t.add("completed", fmt.Sprintf("%d bytes", rw.bytes))
+
+			var reasons []string
+			if rw.status >= rc.config.MinStatus {
+				reasons = append(reasons, "error")
+			}
+			if rc.config.SlowThreshold > 0 && duration >= rc.config.SlowThreshold {
+				reasons = append(reasons, "slow")
+			}
+			if len(reasons) == 0 {
+				return
+			}
+
+			redactor := rc.config.Redactor
+			rc.add(&Capture{
+				Time:            t.start,
+				Reasons:         reasons,
+				RequestID:       r.Header.Get("X-Request-ID"),
+				Method:          r.Method,
+				Path:            r.URL.Path,
+				Query:           redactor.Query(r.URL.RawQuery),
+				Route:           metrics.RouteLabel(r),
+				ClientIP:        httputil.ClientIP(r),
+				Consumer:        metrics.Consumer(r),
+				Status:          rw.status,
+				DurationMs:      milliseconds(duration),
+				RequestHeaders:  redactor.Header(r.Header),
+				RequestBody:     bodyText(redactor.Body(requestBody, r.Header.Get("Content-Type"))),
+				ResponseHeaders: redactor.Header(w.Header()),
+				ResponseBody:    bodyText(redactor.Body(rw.body.Bytes(), w.Header().Get("Content-Type"))),
+				Trace:           t.snapshot(),
+			})
+		})
+	}
+}
+
+// add stores a capture, overwriting the oldest once the buffer is full
+func (rc *Recorder) add(capture *Capture) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	capture.ID = hex.EncodeToString(b)
+	for _, reason := range capture.Reasons {
+		rc.captured.Inc(reason)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if len(rc.captures) < rc.config.Size {
+		rc.captures = append(rc.captures, capture)
+	} else {
+		rc.captures[rc.next] = capture
+	}
+	rc.next = (rc.next + 1) % rc.config.Size
+}
+
+// Recent returns the captures matching the filter, newest first
+func (rc *Recorder) Recent(filter Filter) []*Capture {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	matches := make([]*Capture, 0, len(rc.captures))
+	for i := 1; i <= len(rc.captures); i++ {
+		capture := rc.captures[(rc.next-i+len(rc.captures))%len(rc.captures)]
+		if filter.Reason != "" && !contains(capture.Reasons, filter.Reason) {
+			continue
+		}
+		if !strings.HasPrefix(capture.Path, filter.PathPrefix) {
+			continue
+		}
+		matches = append(matches, capture)
+		if filter.Limit > 0 && len(matches) == filter.Limit {
+			break
+		}
+	}
+	return matches
+}
+
+// Get returns a capture by ID
+func (rc *Recorder) Get(id string) (*Capture, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for _, capture := range rc.captures {
+		if capture.ID == id {
+			return capture, true
+		}
+	}
+	return nil, false
+}
+
+// trace collects the events of one request. Client trace hooks may run on
+// other goroutines.
+type trace struct {
+	start time.Time
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// add records an event at the current time
+func (t *trace) add(name, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, Event{Name: name, OffsetMs: milliseconds(time.Since(t.start)), Detail: detail})
+}
+
+// snapshot returns a copy of the events
+func (t *trace) snapshot() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Event(nil), t.events...)
+}
+
+// clientTrace records the phases of upstream requests made for the request
+func (t *trace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			t.add("upstream_get_conn", hostPort)
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			t.add("upstream_dns_start", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.add("upstream_dns_done", errorDetail(info.Err))
+		},
+		ConnectStart: func(network, addr string) {
+			t.add("upstream_connect_start", addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.add("upstream_connect_done", errorDetail(err))
+		},
+		TLSHandshakeStart: func() {
+			t.add("upstream_tls_start", "")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.add("upstream_tls_done", errorDetail(err))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			detail := "new"
+			if info.Reused {
+				detail = fmt.Sprintf("reused, idle %s", info.IdleTime)
+			}
+			t.add("upstream_got_conn", detail)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			t.add("upstream_wrote_request", errorDetail(info.Err))
+		},
+		GotFirstResponseByte: func() {
+			t.add("upstream_first_byte", "")
+		},
+	}
+}
+
+// responseWriter records the status, the start of the response and the
+// first bytes of the body
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+	body        bytes.Buffer
+	limit       int
+	trace       *trace
+}
+
+// WriteHeader records the status code and forwards it
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.status = code
+		rw.trace.add("response_started", fmt.Sprintf("status %d", code))
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write keeps the body prefix and forwards the data
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.bytes == 0 && len(b) > 0 {
+		rw.trace.add("response_first_byte", "")
+	}
+	if remaining := rw.limit - rw.body.Len(); remaining > 0 {
+		rw.body.Write(b[:min(len(b), remaining)])
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// bodyText returns a body as text, describing binary bodies instead
+func bodyText(body []byte) string {
+	// Drop a character cut off by the size limit
+	for i := 1; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+		body = body[:len(body)-1]
+	}
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("[%d bytes of binary data]", len(body))
+	}
+	return string(body)
+}
+
+// errorDetail describes an optional error
+func errorDetail(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// contains reports whether values holds value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
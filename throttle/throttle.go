@@ -0,0 +1,215 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-gateway/metrics"
+)
+
+// Config represents response bandwidth throttling configuration
+type Config struct {
+	DefaultRate int64            // Bytes per second for consumers without a plan rate; 0 is unlimited
+	PlanRates   map[string]int64 // Plan -> bytes per second; 0 is unlimited
+	Burst       int64            // Bytes a consumer may send at full speed before pacing starts
+	// Identify returns the consumer a response is accounted to and its plan
+	Identify func(r *http.Request) (consumer, plan string)
+}
+
+// Throttler paces response bodies so each consumer stays within its bandwidth
+type Throttler struct {
+	config *Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	delay *metrics.CounterVec
+}
+
+// NewThrottler creates a new bandwidth throttler
+func NewThrottler(config *Config, reg *metrics.Registry) *Throttler {
+	t := &Throttler{
+		config:  config,
+		buckets: make(map[string]*bucket),
+		delay: reg.NewCounterVec("gateway_bandwidth_throttle_seconds_total",
+			"Time responses were delayed by bandwidth throttling, by plan.", "plan"),
+	}
+
+	go t.cleanupRoutine()
+
+	return t
+}
+
+// Middleware returns the HTTP middleware function
+func (t *Throttler) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			consumer, plan := t.config.Identify(r)
+
+			rate := t.config.DefaultRate
+			if planRate, ok := t.config.PlanRates[plan]; ok {
+				rate = planRate
+			}
+			if rate <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if plan == "" {
+				plan = "default"
+			}
+
+			next.ServeHTTP(&throttledWriter{
+				ResponseWriter: w,
+				ctx:            r.Context(),
+				bucket:         t.bucket(consumer, rate),
+				chunk:          chunkSize(rate),
+				onDelay: func(d time.Duration) {
+					t.delay.Add(d.Seconds(), plan)
+				},
+			}, r)
+		})
+	}
+}
+
+// bucket returns the consumer's bucket, shared by all of its concurrent responses
+func (t *Throttler) bucket(consumer string, rate int64) *bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, exists := t.buckets[consumer]
+	if !exists || b.rate != float64(rate) {
+		b = newBucket(rate, t.config.Burst)
+		t.buckets[consumer] = b
+	}
+	return b
+}
+
+// cleanupRoutine drops buckets of consumers that have gone idle
+func (t *Throttler) cleanupRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		for consumer, b := range t.buckets {
+			if b.idle(10 * time.Minute) {
+				delete(t.buckets, consumer)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// chunkSize limits each write to roughly 100ms of bandwidth for smooth pacing
+func chunkSize(rate int64) int {
+	size := rate / 10
+	if size < 512 {
+		size = 512
+	}
+	if size > 64*1024 {
+		size = 64 * 1024
+	}
+	return int(size)
+}
+
+// bucket is a byte token bucket that lets consumers go into debt and then
+// makes them wait until the debt is paid back
+type bucket struct {
+	rate  float64
+	burst float64
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	lastUsed time.Time
+}
+
+// newBucket creates a full bucket
+func newBucket(rate, burst int64) *bucket {
+	now := time.Now()
+	return &bucket{
+		rate:     float64(rate),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     now,
+		lastUsed: now,
+	}
+}
+
+// reserve takes n bytes and returns how long the caller must wait before sending them
+func (b *bucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.lastUsed = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket has not been used for the given duration
+func (b *bucket) idle(d time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Since(b.lastUsed) > d
+}
+
+// throttledWriter paces body writes through a bucket
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	bucket  *bucket
+	chunk   int
+	onDelay func(time.Duration)
+}
+
+// Write sends data in chunks, waiting for bandwidth before each one
+func (tw *throttledWriter) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		end := written + tw.chunk
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if wait := tw.bucket.reserve(end - written); wait > 0 {
+			tw.onDelay(wait)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-tw.ctx.Done():
+				timer.Stop()
+				return written, tw.ctx.Err()
+			}
+		}
+
+		n, err := tw.ResponseWriter.Write(data[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		// Push each chunk to the client so pacing is visible on the wire
+		if flusher, ok := tw.ResponseWriter.(http.Flusher); ok && end < len(data) {
+			flusher.Flush()
+		}
+	}
+	return written, nil
+}
+
+// Flush implements http.Flusher
+func (tw *throttledWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// upgradeEnv marks a process started by a binary upgrade. It inherits the
+// listener as fd 3, the pipe announcing it is ready as fd 4 and the pipe the
+// previous process closes once it has drained as fd 5.
+const upgradeEnv = "GATEWAY_UPGRADE"
+
+// handover is the new process's side of a binary upgrade
+type handover struct {
+	ready *os.File
+	done  *os.File
+}
+
+// listen opens the gateway listener, or inherits it from the previous process
+// during a binary upgrade
+func listen(addr string) (net.Listener, *handover, error) {
+	if os.Getenv(upgradeEnv) == "" {
+		listener, err := net.Listen("tcp", addr)
+		return listener, nil, err
+	}
+	os.Unsetenv(upgradeEnv)
+
+	file := os.NewFile(3, "listener")
+	listener, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inherit listener: %w", err)
+	}
+	return listener, &handover{
+		ready: os.NewFile(4, "upgrade-ready"),
+		done:  os.NewFile(5, "upgrade-done"),
+	}, nil
+}
+
+// announce tells the previous process that this one accepts connections
+func (h *handover) announce() error {
+	defer h.ready.Close()
+	_, err := h.ready.Write([]byte("ready\n"))
+	return err
+}
+
+// wait blocks until the previous process has drained its requests and saved
+// its state, or the timeout passes
+func (h *handover) wait(timeout time.Duration) {
+	defer h.done.Close()
+	h.done.SetReadDeadline(time.Now().Add(timeout))
+	io.Copy(io.Discard, h.done)
+}
+
+// upgrade starts the gateway binary, which may have been replaced on disk,
+// handing it the listener. It returns once the new process accepts
+// connections; release tells it that this process has finished draining.
+func upgrade(listener net.Listener, timeout time.Duration) (release func(), err error) {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+	doneReader, doneWriter, err := os.Pipe()
+	if err != nil {
+		readyWriter.Close()
+		return nil, err
+	}
+
+	process, err := startUpgrade(path, listener, readyWriter, doneReader)
+	readyWriter.Close()
+	doneReader.Close()
+	if err != nil {
+		doneWriter.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", path, err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(readyReader).ReadString('\n')
+		ready <- err
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		state, err := process.Wait()
+		if err == nil {
+			err = errors.New(state.String())
+		}
+		exited <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err == nil {
+			log.Printf("Upgrade: process %d took over the listener", process.Pid)
+			return func() { doneWriter.Close() }, nil
+		}
+		process.Kill()
+		doneWriter.Close()
+		return nil, fmt.Errorf("new process did not become ready: %w", err)
+	case err := <-exited:
+		doneWriter.Close()
+		return nil, fmt.Errorf("new process exited before becoming ready: %v", err)
+	case <-time.After(timeout):
+		process.Kill()
+		doneWriter.Close()
+		return nil, fmt.Errorf("new process was not ready within %s", timeout)
+	}
+}
+
+// writePIDFile records the process ID for service managers that follow the
+// gateway across upgrades
+func writePIDFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
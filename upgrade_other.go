@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// upgradeSignal is unset where listeners cannot be handed over
+var upgradeSignal os.Signal
+
+// startUpgrade is not supported on this platform
+func startUpgrade(path string, listener net.Listener, ready, done *os.File) (*os.Process, error) {
+	return nil, errors.New("binary upgrades are not supported on this platform")
+}
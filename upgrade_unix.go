@@ -0,0 +1,44 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// upgradeSignal starts a binary upgrade
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// startUpgrade starts the new process with the listener as fd 3 and the
+// handover pipes as fds 4 and 5. The listener's descriptor is passed as is:
+// os/exec would switch the shared socket to blocking mode, leaving this
+// process stuck in accept after it stops serving.
+func startUpgrade(path string, listener net.Listener, ready, done *os.File) (*os.Process, error) {
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("listener cannot be handed over")
+	}
+	rawConn, err := tcpListener.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var pid int
+	var startErr error
+	err = rawConn.Control(func(fd uintptr) {
+		pid, startErr = syscall.ForkExec(path, os.Args, &syscall.ProcAttr{
+			Env:   append(os.Environ(), upgradeEnv+"=1"),
+			Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fd, ready.Fd(), done.Fd()},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if startErr != nil {
+		return nil, startErr
+	}
+	return os.FindProcess(pid)
+}
@@ -0,0 +1,244 @@
+package waf
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"api-gateway/proxy"
+)
+
+// Mode determines what happens when a rule matches
+type Mode string
+
+const (
+	// ModeBlock rejects matching requests with 403
+	ModeBlock Mode = "block"
+	// ModeLog only records matches and lets requests through
+	ModeLog Mode = "log"
+	// ModeOff disables inspection
+	ModeOff Mode = "off"
+)
+
+// Config represents WAF configuration
+type Config struct {
+	Mode          Mode            `json:"mode"`
+	RouteModes    map[string]Mode `json:"route_modes"` // path prefix -> mode
+	MaxBodySize   int64           `json:"max_body_size"`
+	Rules         []*Rule         `json:"-"`
+	DisabledRules []string        `json:"disabled_rules"`
+}
+
+// WAF inspects requests against a set of rules
+type WAF struct {
+	config *Config
+	rules  []*Rule
+
+	mu      sync.Mutex
+	hits    map[string]int64 // rule name -> matches
+	blocked int64
+	logged  int64
+	scanned int64
+}
+
+// New creates a new WAF
+func New(config *Config) *WAF {
+	disabled := make(map[string]bool)
+	for _, name := range config.DisabledRules {
+		disabled[name] = true
+	}
+
+	var rules []*Rule
+	for _, rule := range config.Rules {
+		if !disabled[rule.Name] {
+			rules = append(rules, rule)
+		}
+	}
+
+	return &WAF{
+		config: config,
+		rules:  rules,
+		hits:   make(map[string]int64),
+	}
+}
+
+// Middleware returns the HTTP middleware function
+func (w *WAF) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			mode := w.modeFor(r.URL.Path)
+			if mode == ModeOff {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			req, complete, err := w.normalize(r)
+			if err != nil {
+				http.Error(rw, `{"error":"Invalid request body","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+				return
+			}
+			// The rest of the body would reach the upstream uninspected
+			if !complete && mode == ModeBlock {
+				w.recordTooLarge()
+				http.Error(rw, `{"error":"Request body too large","details":"Inspected request bodies must not exceed `+
+					strconv.FormatInt(w.config.MaxBodySize, 10)+` bytes"}`, http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			matched := w.inspect(req)
+			w.record(matched, mode)
+
+			if len(matched) == 0 {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			log.Printf("WAF rules matched (mode=%s) %s %s: %s", mode, r.Method, r.URL.Path, strings.Join(matched, ", "))
+
+			if mode == ModeBlock {
+				http.Error(rw, `{"error":"Request blocked","details":"Request matched security rule: `+matched[0]+`"}`, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// modeFor returns the mode for a path using the longest matching route prefix
+func (w *WAF) modeFor(path string) Mode {
+	mode := w.config.Mode
+	longest := -1
+	for prefix, routeMode := range w.config.RouteModes {
+		if proxy.HasPathPrefix(path, prefix) && len(prefix) > longest {
+			mode = routeMode
+			longest = len(prefix)
+		}
+	}
+	return mode
+}
+
+// normalize builds the inspected view of the request, buffering the body
+// prefix and restoring it so downstream handlers still see the full body. It
+// reports whether the view holds the whole body, which it does not for
+// bodies over MaxBodySize.
+func (w *WAF) normalize(r *http.Request) (*Request, bool, error) {
+	path := r.URL.Path
+	if r.URL.RawPath != "" {
+		path = r.URL.RawPath
+	}
+
+	req := &Request{
+		Path:        path,
+		ContentType: r.Header.Get("Content-Type"),
+	}
+
+	for _, values := range r.URL.Query() {
+		req.Query = append(req.Query, values...)
+	}
+
+	if r.Body == nil || r.Body == http.NoBody || !isInspectable(req.ContentType) {
+		return req, true, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, w.config.MaxBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+	complete := int64(len(body)) <= w.config.MaxBodySize
+	if !complete {
+		body = body[:w.config.MaxBodySize]
+	}
+
+	if mediaType(req.ContentType) == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			for _, values := range form {
+				req.Query = append(req.Query, values...)
+			}
+		}
+	}
+	req.Body = body
+
+	return req, complete, nil
+}
+
+// replayBody re-exposes a partially consumed request body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// inspect returns the names of all rules that match the request
+func (w *WAF) inspect(req *Request) []string {
+	var matched []string
+	for _, rule := range w.rules {
+		if rule.Match(req) {
+			matched = append(matched, rule.Name)
+		}
+	}
+	return matched
+}
+
+// record updates rule hit counters
+func (w *WAF) record(matched []string, mode Mode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.scanned++
+	if len(matched) == 0 {
+		return
+	}
+
+	for _, name := range matched {
+		w.hits[name]++
+	}
+	if mode == ModeBlock {
+		w.blocked++
+	} else {
+		w.logged++
+	}
+}
+
+// recordTooLarge counts a request blocked for a body too large to inspect
+func (w *WAF) recordTooLarge() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.scanned++
+	w.blocked++
+}
+
+// GetStats returns WAF statistics
+func (w *WAF) GetStats() map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hits := make(map[string]int64, len(w.hits))
+	for name, count := range w.hits {
+		hits[name] = count
+	}
+
+	rules := make([]map[string]string, 0, len(w.rules))
+	for _, rule := range w.rules {
+		rules = append(rules, map[string]string{
+			"name":        rule.Name,
+			"description": rule.Description,
+		})
+	}
+
+	return map[string]interface{}{
+		"mode":             w.config.Mode,
+		"route_modes":      w.config.RouteModes,
+		"rules":            rules,
+		"rule_hits":        hits,
+		"requests_scanned": w.scanned,
+		"requests_blocked": w.blocked,
+		"requests_logged":  w.logged,
+	}
+}
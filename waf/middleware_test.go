@@ -0,0 +1,89 @@
+package waf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	w := New(&Config{
+		Mode:        ModeBlock,
+		RouteModes:  map[string]Mode{"/login": ModeLog, "/swagger": ModeOff},
+		MaxBodySize: 64,
+		Rules:       DefaultRules(20, nil),
+	})
+	var received string
+	handler := w.Middleware()(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+
+	attack := `{"q":"1' OR '1'='1"}`
+	tail := `{"padding":"` + strings.Repeat("x", 64) + `","q":"1' OR '1'='1"}`
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"clean body", "/api/orders", `{"item":"book"}`, http.StatusOK},
+		{"attack in block mode", "/api/orders", attack, http.StatusForbidden},
+		{"attack in log mode", "/login", attack, http.StatusOK},
+		{"attack with inspection off", "/swagger", attack, http.StatusOK},
+		{"body over the limit in block mode", "/api/orders", tail, http.StatusRequestEntityTooLarge},
+		{"body at the limit", "/api/orders", `{"padding":"` + strings.Repeat("x", 50) + `"}`, http.StatusOK},
+		{"body over the limit in log mode", "/login", tail, http.StatusOK},
+		// Route modes apply to whole path segments
+		{"attack on a path sharing a prefix", "/loginx", attack, http.StatusForbidden},
+		{"attack below a route", "/login/sso", attack, http.StatusOK},
+		{"attack on a path sharing an off prefix", "/swagger-admin", attack, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		received = ""
+		r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		// Requests let through reach the upstream with their whole body
+		if tt.status == http.StatusOK && received != tt.body {
+			t.Errorf("%s: upstream received %q", tt.name, received)
+		}
+	}
+
+	stats := w.GetStats()
+	if stats["requests_blocked"] != int64(4) {
+		t.Errorf("requests_blocked %v, want 4", stats["requests_blocked"])
+	}
+}
+
+func TestModeFor(t *testing.T) {
+	w := New(&Config{
+		Mode:       ModeBlock,
+		RouteModes: map[string]Mode{"/api": ModeLog, "/api/admin": ModeOff, "/static/": ModeOff},
+	})
+	tests := []struct {
+		path string
+		want Mode
+	}{
+		{"/", ModeBlock},
+		{"/api", ModeLog},
+		{"/api/orders", ModeLog},
+		{"/apiv2", ModeBlock},
+		{"/api/admin", ModeOff},
+		{"/api/admin/users", ModeOff},
+		{"/api/administrators", ModeLog},
+		{"/static/app.js", ModeOff},
+		{"/staticfiles", ModeBlock},
+	}
+	for _, tt := range tests {
+		if got := w.modeFor(tt.path); got != tt.want {
+			t.Errorf("modeFor(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// Request is the normalized view of a request that rules inspect
+type Request struct {
+	Path        string
+	Query       []string // Decoded query parameter values
+	ContentType string
+	Body        []byte
+}
+
+// Rule represents a single inspection rule
+type Rule struct {
+	Name        string
+	Description string
+	Match       func(req *Request) bool
+}
+
+// Pattern sets used by the built-in rules
+var (
+	sqlInjectionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bunion\b[\s\S]+\bselect\b`),
+		regexp.MustCompile(`(?i)'\s*(or|and)\s+'?[\w]+'?\s*=\s*'?[\w]+`),
+		regexp.MustCompile(`(?i)\b(or|and)\s+\d+\s*=\s*\d+`),
+		regexp.MustCompile(`(?i);\s*(drop|delete|insert|update|alter|truncate)\s`),
+		regexp.MustCompile(`(?i)'\s*;?\s*--`),
+		regexp.MustCompile(`(?i)\b(sleep|benchmark|pg_sleep|waitfor\s+delay)\s*\(`),
+	}
+
+	xssPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)<\s*script\b`),
+		regexp.MustCompile(`(?i)<\s*(iframe|object|embed|svg)\b`),
+		regexp.MustCompile(`(?i)javascript\s*:`),
+		regexp.MustCompile(`(?i)\bon(load|error|click|mouseover|focus)\s*=`),
+	}
+
+	pathTraversalPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(^|[/\\])\.\.([/\\]|$)`),
+		regexp.MustCompile(`(?i)%2e%2e`),
+		regexp.MustCompile(`(?i)\b(etc/passwd|win\.ini|boot\.ini)\b`),
+	}
+)
+
+// PatternRule creates a rule that matches any of the patterns against the given fields
+func PatternRule(name, description string, patterns []*regexp.Regexp, inspectPath, inspectBody bool) *Rule {
+	return &Rule{
+		Name:        name,
+		Description: description,
+		Match: func(req *Request) bool {
+			var targets []string
+			if inspectPath {
+				targets = append(targets, req.Path)
+			}
+			targets = append(targets, req.Query...)
+			if inspectBody && len(req.Body) > 0 {
+				targets = append(targets, string(req.Body))
+			}
+
+			for _, target := range targets {
+				for _, pattern := range patterns {
+					if pattern.MatchString(target) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	}
+}
+
+// JSONDepthRule creates a rule that matches JSON bodies nested deeper than maxDepth
+func JSONDepthRule(maxDepth int) *Rule {
+	return &Rule{
+		Name:        "json_depth",
+		Description: "JSON body exceeds maximum nesting depth",
+		Match: func(req *Request) bool {
+			if !isJSON(req.ContentType) || len(req.Body) == 0 {
+				return false
+			}
+			return jsonDepthExceeds(req.Body, maxDepth)
+		},
+	}
+}
+
+// ContentTypeRule creates a rule that matches banned content types
+func ContentTypeRule(banned []string) *Rule {
+	bannedSet := make(map[string]bool)
+	for _, contentType := range banned {
+		bannedSet[strings.ToLower(contentType)] = true
+	}
+
+	return &Rule{
+		Name:        "content_type",
+		Description: "Request content type is not allowed",
+		Match: func(req *Request) bool {
+			return bannedSet[mediaType(req.ContentType)]
+		},
+	}
+}
+
+// DefaultRules returns the built-in rule set
+func DefaultRules(maxJSONDepth int, bannedContentTypes []string) []*Rule {
+	return []*Rule{
+		PatternRule("sqli", "SQL injection pattern detected", sqlInjectionPatterns, false, true),
+		PatternRule("xss", "Cross-site scripting pattern detected", xssPatterns, true, true),
+		PatternRule("path_traversal", "Path traversal sequence detected", pathTraversalPatterns, true, false),
+		JSONDepthRule(maxJSONDepth),
+		ContentTypeRule(bannedContentTypes),
+	}
+}
+
+// jsonDepthExceeds walks the JSON token stream and reports whether nesting exceeds maxDepth
+func jsonDepthExceeds(body []byte, maxDepth int) bool {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			// Malformed JSON is left for the handler to reject
+			return false
+		}
+
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+				if depth > maxDepth {
+					return true
+				}
+			case '}', ']':
+				depth--
+			}
+		}
+	}
+}
+
+// mediaType returns the lower-cased media type without parameters
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	parsed, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return parsed
+}
+
+// isJSON reports whether the content type is JSON
+func isJSON(contentType string) bool {
+	mt := mediaType(contentType)
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// isInspectable reports whether a body of this content type should be inspected
+func isInspectable(contentType string) bool {
+	mt := mediaType(contentType)
+	return isJSON(contentType) ||
+		mt == "application/x-www-form-urlencoded" ||
+		mt == "application/xml" ||
+		strings.HasPrefix(mt, "text/")
+}
@@ -0,0 +1,106 @@
+package warmrestart
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps the snapshot between runs
+type Store interface {
+	// Save replaces the stored snapshot
+	Save(ctx context.Context, data []byte) error
+	// Load returns the stored snapshot, or nil when there is none
+	Load(ctx context.Context) ([]byte, error)
+	// Clear removes the stored snapshot
+	Clear(ctx context.Context) error
+}
+
+// FileStore keeps the snapshot in a local file
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a store writing the snapshot to path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Save writes the snapshot to a temporary file and renames it into place, so
+// a crash while saving never leaves a partial snapshot
+func (s *FileStore) Save(ctx context.Context, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Load reads the snapshot file
+func (s *FileStore) Load(ctx context.Context) ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Clear removes the snapshot file
+func (s *FileStore) Clear(ctx context.Context) error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RedisStore keeps the snapshot in a Redis key, for gateways without a
+// persistent disk. Each instance needs its own key.
+type RedisStore struct {
+	client *redis.Client
+	key    string
+	ttl    time.Duration
+}
+
+// NewRedisStore creates a store writing the snapshot to key, expiring it
+// after ttl
+func NewRedisStore(client *redis.Client, key string, ttl time.Duration) *RedisStore {
+	return &RedisStore{
+		client: client,
+		key:    key,
+		ttl:    ttl,
+	}
+}
+
+// Save writes the snapshot
+func (s *RedisStore) Save(ctx context.Context, data []byte) error {
+	return s.client.Set(ctx, s.key, data, s.ttl).Err()
+}
+
+// Load reads the snapshot
+func (s *RedisStore) Load(ctx context.Context) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Clear deletes the snapshot
+func (s *RedisStore) Clear(ctx context.Context) error {
+	return s.client.Del(ctx, s.key).Err()
+}
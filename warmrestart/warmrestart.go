@@ -0,0 +1,138 @@
+package warmrestart
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Version is the snapshot format version written by this gateway
+const Version = 1
+
+// Component is in-memory state that survives a restart
+type Component interface {
+	// Snapshot returns the component's state for encoding as JSON
+	Snapshot() (any, error)
+	// Restore loads state returned by an earlier Snapshot
+	Restore(data json.RawMessage) error
+}
+
+// Snapshot is the state of every component, saved on shutdown
+type Snapshot struct {
+	Version    int                        `json:"version"`
+	SavedAt    time.Time                  `json:"saved_at"`
+	Components map[string]json.RawMessage `json:"components"`
+}
+
+// Manager saves the state of registered components on shutdown and restores
+// it on startup
+type Manager struct {
+	store  Store
+	maxAge time.Duration // Older snapshots are ignored, since their state no longer applies
+
+	mu         sync.Mutex
+	components map[string]Component
+}
+
+// NewManager creates a warm restart manager
+func NewManager(store Store, maxAge time.Duration) *Manager {
+	return &Manager{
+		store:      store,
+		maxAge:     maxAge,
+		components: make(map[string]Component),
+	}
+}
+
+// Register adds a component under a name that must stay the same across
+// restarts
+func (m *Manager) Register(name string, component Component) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components[name] = component
+}
+
+// Save snapshots every component and writes the snapshot to the store
+func (m *Manager) Save(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := &Snapshot{
+		Version:    Version,
+		SavedAt:    time.Now().UTC(),
+		Components: make(map[string]json.RawMessage, len(m.components)),
+	}
+	for _, name := range m.names() {
+		state, err := m.components[name].Snapshot()
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", name, err)
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		snapshot.Components[name] = data
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return m.store.Save(ctx, data)
+}
+
+// Restore loads the stored snapshot into the registered components and
+// clears it, so a later crash does not restore the same state again. A
+// component that fails to restore starts empty without affecting the others.
+func (m *Manager) Restore(ctx context.Context) error {
+	data, err := m.store.Load(ctx)
+	if err != nil || data == nil {
+		return err
+	}
+	if err := m.store.Clear(ctx); err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snapshot.Version != Version {
+		return fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	if age := time.Since(snapshot.SavedAt); age > m.maxAge {
+		log.Printf("Warm restart: ignoring snapshot saved %s ago", age.Round(time.Second))
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restored []string
+	for _, name := range m.names() {
+		state, ok := snapshot.Components[name]
+		if !ok {
+			continue
+		}
+		if err := m.components[name].Restore(state); err != nil {
+			log.Printf("Warm restart: failed to restore %s: %v", name, err)
+			continue
+		}
+		restored = append(restored, name)
+	}
+	log.Printf("Warm restart: restored %v from snapshot saved at %s", restored, snapshot.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// names returns the registered component names in order
+func (m *Manager) names() []string {
+	names := make([]string, 0, len(m.components))
+	for name := range m.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
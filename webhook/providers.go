@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errSignature rejects deliveries not signed with any of the route's secrets
+var errSignature = errors.New("invalid webhook signature")
+
+// delivery identifies a verified webhook delivery
+type delivery struct {
+	id     string
+	sentAt time.Time // Signed send time; zero when the provider signs none
+}
+
+// verifyFunc checks a provider's signature over the raw body
+type verifyFunc func(r *http.Request, body []byte, secrets []string) (*delivery, error)
+
+// providers are the supported webhook signing schemes, by name
+var providers = map[string]verifyFunc{
+	"github":   verifyGitHub,
+	"stripe":   verifyStripe,
+	"standard": verifyStandard,
+}
+
+// verifyGitHub checks X-Hub-Signature-256, an HMAC-SHA256 of the body. GitHub
+// signs no timestamp, so only deduplication protects against replays, and
+// since X-GitHub-Delivery is not signed either, deliveries are identified by
+// a hash of the signed body: a replay under a fresh delivery ID is still a
+// duplicate. Every event body carries its own IDs and timestamps, so
+// distinct events do not collide.
+func verifyGitHub(r *http.Request, body []byte, secrets []string) (*delivery, error) {
+	if r.Header.Get("X-GitHub-Delivery") == "" {
+		return nil, errors.New("missing X-GitHub-Delivery header")
+	}
+	signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return nil, errSignature
+	}
+	sent, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, errSignature
+	}
+	for _, secret := range secrets {
+		if hmac.Equal(sent, sign([]byte(secret), body)) {
+			sum := sha256.Sum256(body)
+			return &delivery{id: "sha256:" + hex.EncodeToString(sum[:])}, nil
+		}
+	}
+	return nil, errSignature
+}
+
+// verifyStripe checks Stripe-Signature, "t=<unix time>,v1=<signature>,...",
+// where each v1 is an HMAC-SHA256 of the timestamp, a dot and the body.
+// Deliveries are identified by their event ID.
+func verifyStripe(r *http.Request, body []byte, secrets []string) (*delivery, error) {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			timestamp = value
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return nil, errSignature
+	}
+	if !anyMatch(signatures, secrets, []byte(timestamp+"."), body) {
+		return nil, errSignature
+	}
+
+	var event struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" {
+		return nil, errors.New("webhook body has no event id")
+	}
+	return &delivery{id: event.ID, sentAt: time.Unix(seconds, 0)}, nil
+}
+
+// verifyStandard checks Standard Webhooks headers: webhook-signature holds
+// space-separated "v1,<base64 signature>" entries, each an HMAC-SHA256 of the
+// webhook-id, webhook-timestamp and body joined by dots. Secrets are given
+// as "whsec_<base64 key>".
+func verifyStandard(r *http.Request, body []byte, secrets []string) (*delivery, error) {
+	id := r.Header.Get("webhook-id")
+	if id == "" {
+		return nil, errors.New("missing webhook-id header")
+	}
+	timestamp := r.Header.Get("webhook-timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errSignature
+	}
+	var signatures [][]byte
+	for _, entry := range strings.Fields(r.Header.Get("webhook-signature")) {
+		version, value, _ := strings.Cut(entry, ",")
+		if version != "v1" {
+			continue
+		}
+		if signature, err := base64.StdEncoding.DecodeString(value); err == nil {
+			signatures = append(signatures, signature)
+		}
+	}
+
+	keys := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, string(standardKey(secret)))
+	}
+	if !anyMatch(signatures, keys, []byte(id+"."+timestamp+"."), body) {
+		return nil, errSignature
+	}
+	return &delivery{id: id, sentAt: time.Unix(seconds, 0)}, nil
+}
+
+// standardKey returns the signing key of a Standard Webhooks secret. Secrets
+// without the whsec_ prefix are used as is.
+func standardKey(secret string) []byte {
+	if encoded, ok := strings.CutPrefix(secret, "whsec_"); ok {
+		if key, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			return key
+		}
+	}
+	return []byte(secret)
+}
+
+// anyMatch reports whether any signature is the HMAC of prefix and body under
+// any of the secrets
+func anyMatch(signatures [][]byte, secrets []string, prefix, body []byte) bool {
+	for _, secret := range secrets {
+		expected := sign([]byte(secret), prefix, body)
+		for _, signature := range signatures {
+			if hmac.Equal(signature, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sign returns the HMAC-SHA256 of the concatenated parts
+func sign(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
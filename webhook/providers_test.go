@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBody = `{"id":"evt_1","action":"opened"}`
+
+// signedRequest builds a delivery for a provider, signed with secret over body
+func signedRequest(provider, secret, body string, sentAt time.Time) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(body))
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	switch provider {
+	case "github":
+		r.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign([]byte(secret), []byte(body))))
+	case "stripe":
+		r.Header.Set("Stripe-Signature", "t="+timestamp+",v1="+hex.EncodeToString(sign([]byte(secret), []byte(timestamp+"."), []byte(body))))
+	case "standard":
+		r.Header.Set("webhook-id", "msg_1")
+		r.Header.Set("webhook-timestamp", timestamp)
+		r.Header.Set("webhook-signature", "v1,"+base64.StdEncoding.EncodeToString(sign(standardKey(secret), []byte("msg_1."+timestamp+"."), []byte(body))))
+	}
+	return r
+}
+
+func TestProvidersVerifySignatures(t *testing.T) {
+	secret := "whsec_" + base64.StdEncoding.EncodeToString([]byte("signing-key"))
+	for name, verify := range providers {
+		t.Run(name, func(t *testing.T) {
+			r := signedRequest(name, secret, testBody, time.Now())
+			if _, err := verify(r, []byte(testBody), []string{"old", secret}); err != nil {
+				t.Fatalf("valid delivery rejected: %v", err)
+			}
+
+			if _, err := verify(r, []byte(testBody+" "), []string{secret}); !errors.Is(err, errSignature) {
+				t.Errorf("tampered body: err = %v, want errSignature", err)
+			}
+			if _, err := verify(r, []byte(testBody), []string{"other"}); !errors.Is(err, errSignature) {
+				t.Errorf("wrong secret: err = %v, want errSignature", err)
+			}
+
+			unsigned := signedRequest(name, secret, testBody, time.Now())
+			for _, header := range []string{"X-Hub-Signature-256", "Stripe-Signature", "webhook-signature"} {
+				unsigned.Header.Del(header)
+			}
+			if _, err := verify(unsigned, []byte(testBody), []string{secret}); err == nil {
+				t.Error("unsigned delivery accepted")
+			}
+		})
+	}
+}
+
+func TestStripeAndStandardSignTimestamp(t *testing.T) {
+	for _, name := range []string{"stripe", "standard"} {
+		r := signedRequest(name, "secret", testBody, time.Now().Add(-time.Hour))
+		// Moving the timestamp forward breaks the signature
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		if name == "stripe" {
+			sig := r.Header.Get("Stripe-Signature")
+			r.Header.Set("Stripe-Signature", "t="+now+sig[strings.Index(sig, ","):])
+		} else {
+			r.Header.Set("webhook-timestamp", now)
+		}
+		if _, err := providers[name](r, []byte(testBody), []string{"secret"}); !errors.Is(err, errSignature) {
+			t.Errorf("%s: rewritten timestamp: err = %v, want errSignature", name, err)
+		}
+	}
+}
+
+func TestGitHubDeliveryIgnoresUnsignedID(t *testing.T) {
+	first := signedRequest("github", "secret", testBody, time.Now())
+	replay := signedRequest("github", "secret", testBody, time.Now())
+	replay.Header.Set("X-GitHub-Delivery", "forged-delivery-id")
+
+	a, err := verifyGitHub(first, []byte(testBody), []string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := verifyGitHub(replay, []byte(testBody), []string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.id != b.id {
+		t.Errorf("a new X-GitHub-Delivery changed the delivery: %s != %s", a.id, b.id)
+	}
+	if !a.sentAt.IsZero() {
+		t.Error("GitHub deliveries have no signed timestamp")
+	}
+}
@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"api-gateway/httputil"
+	"api-gateway/metrics"
+	"api-gateway/storage"
+)
+
+// Delivery states in the store, under deliveryPrefix + route + ":" + ID
+const (
+	deliveryPrefix  = "webhook:delivery:"
+	stateForwarding = "forwarding" // The delivery is being forwarded; duplicates are retried later
+	stateDelivered  = "delivered"  // The upstream accepted the delivery; duplicates are acknowledged
+)
+
+// storeTimeout bounds each lookup and write of the store
+const storeTimeout = 2 * time.Second
+
+// forwardingTTL bounds how long a delivery that is being forwarded blocks its
+// duplicates, should the gateway die before releasing it
+const forwardingTTL = 5 * time.Minute
+
+// Route represents one webhook receiver route
+type Route struct {
+	Name        string
+	Provider    string        // "github", "stripe" or "standard" (Standard Webhooks)
+	Secrets     []string      // Any of them may sign a delivery, so secrets can be rotated
+	Tolerance   time.Duration // How far a signed timestamp may be from now
+	DedupeTTL   time.Duration // How long delivered IDs are remembered
+	MaxBodySize int64
+}
+
+// Receiver verifies inbound webhooks at the gateway before they reach the
+// upstream: the provider's signature over the raw body must match one of
+// the route's secrets, signed timestamps must be fresh, and each delivery
+// ID is forwarded only once. Deliveries the upstream fails are forgotten,
+// so the provider's retries get through.
+type Receiver struct {
+	store storage.KeyValueStore
+
+	deliveries *metrics.CounterVec
+}
+
+// NewReceiver creates a webhook receiver remembering deliveries in store.
+// With a shared store, such as Redis or SQL, replicas share deliveries.
+func NewReceiver(store storage.KeyValueStore, reg *metrics.Registry) *Receiver {
+	return &Receiver{
+		store: store,
+		deliveries: reg.NewCounterVec("gateway_webhook_deliveries_total",
+			"Inbound webhook deliveries, by route and result (forwarded, duplicate, in_progress, invalid_signature, expired or malformed).", "route", "result"),
+	}
+}
+
+// Middleware returns the HTTP middleware verifying a route's webhooks
+func (rc *Receiver) Middleware(route *Route) (func(http.Handler) http.Handler, error) {
+	verify, ok := providers[route.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown webhook provider %q", route.Provider)
+	}
+	if len(route.Secrets) == 0 {
+		return nil, fmt.Errorf("webhook route %s has no secrets", route.Name)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, `{"error":"Method not allowed","details":"Webhooks are delivered with POST"}`, http.StatusMethodNotAllowed)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, route.MaxBodySize+1))
+			if err != nil {
+				http.Error(w, `{"error":"Bad request","details":"Failed to read webhook body"}`, http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > route.MaxBodySize {
+				rc.deliveries.Inc(route.Name, "malformed")
+				http.Error(w, `{"error":"Request entity too large","details":"Webhook body is too large"}`, http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			delivery, err := verify(r, body, route.Secrets)
+			switch {
+			case errors.Is(err, errSignature):
+				rc.deliveries.Inc(route.Name, "invalid_signature")
+				http.Error(w, `{"error":"Unauthorized","details":"`+err.Error()+`"}`, http.StatusUnauthorized)
+				return
+			case err != nil:
+				rc.deliveries.Inc(route.Name, "malformed")
+				http.Error(w, `{"error":"Bad request","details":"`+err.Error()+`"}`, http.StatusBadRequest)
+				return
+			}
+			// The timestamp is signed, so it cannot be moved forward to replay
+			// a delivery
+			if !delivery.sentAt.IsZero() {
+				if skew := time.Since(delivery.sentAt).Abs(); skew > route.Tolerance {
+					rc.deliveries.Inc(route.Name, "expired")
+					http.Error(w, `{"error":"Unauthorized","details":"Webhook timestamp is outside the tolerance"}`, http.StatusUnauthorized)
+					return
+				}
+			}
+
+			key := deliveryPrefix + route.Name + ":" + delivery.id
+			claimed, err := rc.claim(r.Context(), key)
+			if err != nil {
+				// Deliveries fail open rather than being dropped; upstreams
+				// see a duplicate at worst
+				log.Printf("Webhook route %s could not check delivery %s: %v", route.Name, delivery.id, err)
+				claimed = true
+			}
+			if !claimed {
+				rc.duplicate(w, r, route, key)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			rec := httputil.NewTeeRecorder(w, 0)
+			forwarded := false
+			defer func() {
+				// Release deliveries whose forwarding failed or panicked
+				if !forwarded {
+					rc.release(key)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			if rec.StatusCode < 200 || rec.StatusCode >= 300 {
+				return
+			}
+			forwarded = true
+			rc.deliveries.Inc(route.Name, "forwarded")
+
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+			if err := rc.store.Set(ctx, key, []byte(stateDelivered), route.DedupeTTL); err != nil {
+				log.Printf("Webhook route %s could not record delivery %s: %v", route.Name, delivery.id, err)
+			}
+		})
+	}, nil
+}
+
+// claim marks a delivery as being forwarded, reporting false when it was
+// already seen
+func (rc *Receiver) claim(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	return rc.store.SetNX(ctx, key, []byte(stateForwarding), forwardingTTL)
+}
+
+// release forgets a delivery, so the provider's retry is forwarded
+func (rc *Receiver) release(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if _, err := rc.store.Delete(ctx, key); err != nil {
+		log.Printf("Failed to release webhook delivery %s: %v", key, err)
+	}
+}
+
+// duplicate answers a delivery seen before: delivered ones are acknowledged
+// so the provider stops retrying, and ones still being forwarded are
+// retried later
+func (rc *Receiver) duplicate(w http.ResponseWriter, r *http.Request, route *Route, key string) {
+	ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	defer cancel()
+	state, err := rc.store.Get(ctx, key)
+	if err == nil && string(state) == stateDelivered {
+		rc.deliveries.Inc(route.Name, "duplicate")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"duplicate"}` + "\n"))
+		return
+	}
+
+	rc.deliveries.Inc(route.Name, "in_progress")
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, `{"error":"Conflict","details":"Delivery is already being processed"}`, http.StatusConflict)
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/metrics"
+	"api-gateway/storage"
+)
+
+func TestMiddlewareRejectsReplays(t *testing.T) {
+	for name := range providers {
+		t.Run(name, func(t *testing.T) {
+			rc := NewReceiver(storage.NewMemoryStore(), metrics.NewRegistry())
+			mw, err := rc.Middleware(&Route{
+				Name:        name,
+				Provider:    name,
+				Secrets:     []string{"secret"},
+				Tolerance:   5 * time.Minute,
+				DedupeTTL:   time.Hour,
+				MaxBodySize: 1 << 20,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			forwarded := 0
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded++
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			serve := func(r *http.Request) int {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, r)
+				return rec.Code
+			}
+
+			if code := serve(signedRequest(name, "secret", testBody, time.Now())); code != http.StatusAccepted {
+				t.Fatalf("first delivery: status %d, want 202", code)
+			}
+			replay := signedRequest(name, "secret", testBody, time.Now())
+			if name == "github" {
+				replay.Header.Set("X-GitHub-Delivery", "forged-delivery-id")
+			}
+			if code := serve(replay); code != http.StatusOK {
+				t.Errorf("replay: status %d, want 200 duplicate", code)
+			}
+			if forwarded != 1 {
+				t.Errorf("upstream saw %d deliveries, want 1", forwarded)
+			}
+
+			if code := serve(signedRequest(name, "wrong", testBody, time.Now())); code != http.StatusUnauthorized {
+				t.Errorf("wrong secret: status %d, want 401", code)
+			}
+			if name != "github" {
+				stale := signedRequest(name, "secret", `{"id":"evt_2"}`, time.Now().Add(-time.Hour))
+				if code := serve(stale); code != http.StatusUnauthorized {
+					t.Errorf("stale timestamp: status %d, want 401", code)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareForgetsFailedDeliveries(t *testing.T) {
+	rc := NewReceiver(storage.NewMemoryStore(), metrics.NewRegistry())
+	mw, err := rc.Middleware(&Route{Name: "gh", Provider: "github", Secrets: []string{"secret"}, DedupeTTL: time.Hour, MaxBodySize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := http.StatusBadGateway
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	for _, want := range []int{http.StatusBadGateway, http.StatusNoContent, http.StatusOK} {
+		if want == http.StatusNoContent {
+			status = http.StatusNoContent
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, signedRequest("github", "secret", testBody, time.Now()))
+		if rec.Code != want {
+			t.Errorf("status %d, want %d", rec.Code, want)
+		}
+	}
+}